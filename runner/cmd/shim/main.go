@@ -7,8 +7,10 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"os/signal"
 	"path"
 	"path/filepath"
+	"syscall"
 	"time"
 
 	"github.com/dstackai/dstack/runner/consts"
@@ -60,6 +62,137 @@ func main() {
 				Destination: &args.Shim.LogLevel,
 				EnvVars:     []string{"DSTACK_SHIM_LOG_LEVEL"},
 			},
+			&cli.IntFlag{
+				Name:        "shim-max-concurrent-tasks",
+				Usage:       "Set the maximum number of concurrently running tasks, extra tasks are queued (0 = unlimited)",
+				Value:       0,
+				Destination: &args.Shim.MaxConcurrentTasks,
+				EnvVars:     []string{"DSTACK_SHIM_MAX_CONCURRENT_TASKS"},
+			},
+			&cli.IntFlag{
+				Name:        "shim-container-name-hash-length",
+				Usage:       "Set the length of the unique hex suffix of container names, 8..32 (0 = 8)",
+				Value:       0,
+				Destination: &args.Shim.ContainerNameHashLength,
+				EnvVars:     []string{"DSTACK_SHIM_CONTAINER_NAME_HASH_LENGTH"},
+			},
+			&cli.PathFlag{
+				Name:        "shim-audit-log",
+				Usage:       "Append task lifecycle audit records (JSON lines) to this file, disabled if not set",
+				Destination: &args.Shim.AuditLog.Path,
+				EnvVars:     []string{"DSTACK_SHIM_AUDIT_LOG"},
+			},
+			&cli.IntFlag{
+				Name:        "shim-audit-log-max-size",
+				Usage:       "Rotate the audit log once it exceeds this size in MiB (0 = never)",
+				Value:       100,
+				Destination: &args.Shim.AuditLog.MaxSizeMiB,
+				EnvVars:     []string{"DSTACK_SHIM_AUDIT_LOG_MAX_SIZE"},
+			},
+			&cli.IntFlag{
+				Name:        "shim-audit-log-max-backups",
+				Usage:       "Keep this many rotated audit log files",
+				Value:       5,
+				Destination: &args.Shim.AuditLog.MaxBackups,
+				EnvVars:     []string{"DSTACK_SHIM_AUDIT_LOG_MAX_BACKUPS"},
+			},
+			&cli.StringFlag{
+				Name:        "shim-admission-webhook-url",
+				Usage:       "POST submitted task configs (redacted) to this URL and reject tasks it denies, disabled if not set",
+				Destination: &args.Shim.AdmissionWebhook.URL,
+				EnvVars:     []string{"DSTACK_SHIM_ADMISSION_WEBHOOK_URL"},
+			},
+			&cli.DurationFlag{
+				Name:        "shim-admission-webhook-timeout",
+				Usage:       "Set the admission webhook request timeout",
+				Value:       5 * time.Second,
+				Destination: &args.Shim.AdmissionWebhook.Timeout,
+				EnvVars:     []string{"DSTACK_SHIM_ADMISSION_WEBHOOK_TIMEOUT"},
+			},
+			&cli.BoolFlag{
+				Name:        "shim-admission-webhook-fail-open",
+				Usage:       "Accept tasks if the admission webhook fails, otherwise they are rejected",
+				Destination: &args.Shim.AdmissionWebhook.FailOpen,
+				EnvVars:     []string{"DSTACK_SHIM_ADMISSION_WEBHOOK_FAIL_OPEN"},
+			},
+			&cli.StringFlag{
+				Name:        "shim-credential-provider-url",
+				Usage:       "POST to this URL to fetch temporary credentials of tasks with credentials, such tasks are rejected if not set",
+				Destination: &args.Shim.CredentialProvider.URL,
+				EnvVars:     []string{"DSTACK_SHIM_CREDENTIAL_PROVIDER_URL"},
+			},
+			&cli.DurationFlag{
+				Name:        "shim-credential-provider-timeout",
+				Usage:       "Set the credential provider request timeout",
+				Value:       10 * time.Second,
+				Destination: &args.Shim.CredentialProvider.Timeout,
+				EnvVars:     []string{"DSTACK_SHIM_CREDENTIAL_PROVIDER_TIMEOUT"},
+			},
+			&cli.StringFlag{
+				Name:        "shim-otlp-endpoint",
+				Usage:       "Export task spans and metrics to this OTLP/HTTP collector URL, e.g., http://localhost:4318, disabled if not set",
+				Destination: &args.Shim.Telemetry.OTLPEndpoint,
+				EnvVars:     []string{"DSTACK_SHIM_OTLP_ENDPOINT"},
+			},
+			&cli.DurationFlag{
+				Name:        "shim-otlp-metric-interval",
+				Usage:       "Set how often metrics are exported to the OTLP collector",
+				Value:       time.Minute,
+				Destination: &args.Shim.Telemetry.MetricInterval,
+				EnvVars:     []string{"DSTACK_SHIM_OTLP_METRIC_INTERVAL"},
+			},
+			&cli.Uint64Flag{
+				Name:        "shim-min-free-disk-mb",
+				Usage:       "Don't report the shim ready until the home dir and the Docker root dir have this much free disk space, MiB, 0 = not checked",
+				Value:       1024,
+				Destination: &args.Shim.MinFreeDiskMB,
+				EnvVars:     []string{"DSTACK_SHIM_MIN_FREE_DISK_MB"},
+			},
+			&cli.StringFlag{
+				Name:        "shim-exec-token",
+				Usage:       "Enable running commands in and attaching to task containers via the API for clients with this token",
+				Destination: &args.Shim.ExecToken,
+				EnvVars:     []string{"DSTACK_SHIM_EXEC_TOKEN"},
+			},
+			&cli.Float64Flag{
+				Name:        "shim-api-rate-limit",
+				Usage:       "Limit task submit, replace, update and GPU reservation requests per second, 0 = unlimited",
+				Destination: &args.Shim.APIRateLimit,
+				EnvVars:     []string{"DSTACK_SHIM_API_RATE_LIMIT"},
+			},
+			&cli.IntFlag{
+				Name:        "shim-api-rate-burst",
+				Usage:       "Set how many rate limited requests may be made at once",
+				Value:       10,
+				Destination: &args.Shim.APIRateBurst,
+				EnvVars:     []string{"DSTACK_SHIM_API_RATE_BURST"},
+			},
+			&cli.PathFlag{
+				Name:        "shim-core-dump-dir",
+				Usage:       "Collect core dumps of tasks with core_dumps into this host dir, the kernel core pattern must point to " + shim.CoreDumpContainerDir,
+				Destination: &args.Shim.CoreDumpDir,
+				EnvVars:     []string{"DSTACK_SHIM_CORE_DUMP_DIR"},
+			},
+			&cli.DurationFlag{
+				Name:        "shim-gpu-drain-timeout",
+				Usage:       "Wait for compute processes to exit before releasing GPUs of a finished task, 0 to disable",
+				Value:       10 * time.Second,
+				Destination: &args.Shim.GPUDrain.Timeout,
+				EnvVars:     []string{"DSTACK_SHIM_GPU_DRAIN_TIMEOUT"},
+			},
+			&cli.BoolFlag{
+				Name:        "shim-gpu-drain-kill",
+				Usage:       "Kill compute processes still running after the GPU drain timeout",
+				Destination: &args.Shim.GPUDrain.Kill,
+				EnvVars:     []string{"DSTACK_SHIM_GPU_DRAIN_KILL"},
+			},
+			&cli.DurationFlag{
+				Name:        "shim-shutdown-timeout",
+				Usage:       "Set the deadline for stopping tasks on SIGTERM or SIGINT, see the task shutdown_behavior",
+				Value:       time.Minute,
+				Destination: &args.Shim.ShutdownTimeout,
+				EnvVars:     []string{"DSTACK_SHIM_SHUTDOWN_TIMEOUT"},
+			},
 			/* Runner Parameters */
 			&cli.StringFlag{
 				Name:        "runner-download-url",
@@ -116,6 +249,85 @@ func main() {
 				Destination: &args.Docker.PJRTDevice,
 				EnvVars:     []string{"PJRT_DEVICE"},
 			},
+			&cli.DurationFlag{
+				Name:        "container-gone-grace-period",
+				Usage:       "Re-check the container over this period before considering it gone and terminating the task",
+				Value:       10 * time.Second,
+				Destination: &args.Docker.ContainerGoneGracePeriod,
+				EnvVars:     []string{"DSTACK_DOCKER_CONTAINER_GONE_GRACE_PERIOD"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "allow-unconfined",
+				Usage:   "Allow tasks to request unconfined security profiles of the given types (seccomp, apparmor)",
+				EnvVars: []string{"DSTACK_DOCKER_ALLOW_UNCONFINED"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "allow-docker-socket",
+				Usage:   "Allow tasks to mount the host Docker socket in the given modes (ro, rw), rw implies ro",
+				EnvVars: []string{"DSTACK_DOCKER_ALLOW_DOCKER_SOCKET"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "registry-mirror",
+				Usage:   "Pull images of the registry from the mirror, in the form of registry=mirror, e.g., docker.io=mirror.local:5000",
+				EnvVars: []string{"DSTACK_DOCKER_REGISTRY_MIRROR"},
+			},
+			&cli.BoolFlag{
+				Name:        "registry-mirror-fallback",
+				Usage:       "Pull images from the upstream registry if the mirror fails",
+				Destination: &args.Docker.RegistryMirrorFallback,
+				EnvVars:     []string{"DSTACK_DOCKER_REGISTRY_MIRROR_FALLBACK"},
+			},
+			&cli.IntFlag{
+				Name:        "max-concurrent-pulls",
+				Usage:       "Set the maximum number of concurrent image pulls, extra pulls are queued (0 = unlimited)",
+				Value:       3,
+				Destination: &args.Docker.MaxConcurrentPulls,
+				EnvVars:     []string{"DSTACK_DOCKER_MAX_CONCURRENT_PULLS"},
+			},
+			&cli.PathFlag{
+				Name:        "image-signature-policy",
+				Usage:       "Require cosign signatures of images according to the policy `FILE` (requires cosign on the host)",
+				Destination: &args.Docker.ImageSignaturePolicy,
+				EnvVars:     []string{"DSTACK_DOCKER_IMAGE_SIGNATURE_POLICY"},
+			},
+			&cli.IntFlag{
+				Name:        "circuit-breaker-threshold",
+				Usage:       "Reject new tasks after this many consecutive Docker daemon failures until the daemon recovers (0 = disabled)",
+				Value:       5,
+				Destination: &args.Docker.CircuitBreakerThreshold,
+				EnvVars:     []string{"DSTACK_DOCKER_CIRCUIT_BREAKER_THRESHOLD"},
+			},
+			&cli.DurationFlag{
+				Name:        "circuit-breaker-cooldown",
+				Usage:       "Wait this long after Docker daemon failures before probing the daemon for recovery",
+				Value:       30 * time.Second,
+				Destination: &args.Docker.CircuitBreakerCooldown,
+				EnvVars:     []string{"DSTACK_DOCKER_CIRCUIT_BREAKER_COOLDOWN"},
+			},
+			&cli.StringFlag{
+				Name:        "docker-host",
+				Usage:       "Connect to the Docker daemon at unix:///path/to/socket or tcp://host:port (default: DOCKER_HOST or the local socket)",
+				Destination: &args.Docker.Endpoint.Host,
+				EnvVars:     []string{"DSTACK_DOCKER_HOST"},
+			},
+			&cli.StringFlag{
+				Name:        "docker-tls-ca-cert",
+				Usage:       "Verify the Docker daemon certificate with this CA certificate (PEM), requires tcp:// docker-host",
+				Destination: &args.Docker.Endpoint.TLSCACert,
+				EnvVars:     []string{"DSTACK_DOCKER_TLS_CA_CERT"},
+			},
+			&cli.StringFlag{
+				Name:        "docker-tls-cert",
+				Usage:       "Authenticate to the Docker daemon with this client certificate (PEM), requires docker-tls-key",
+				Destination: &args.Docker.Endpoint.TLSCert,
+				EnvVars:     []string{"DSTACK_DOCKER_TLS_CERT"},
+			},
+			&cli.StringFlag{
+				Name:        "docker-tls-key",
+				Usage:       "Authenticate to the Docker daemon with this client key (PEM), requires docker-tls-cert",
+				Destination: &args.Docker.Endpoint.TLSKey,
+				EnvVars:     []string{"DSTACK_DOCKER_TLS_KEY"},
+			},
 			/* Misc Parameters */
 			&cli.BoolFlag{
 				Name:        "service",
@@ -125,6 +337,9 @@ func main() {
 			},
 		},
 		Action: func(c *cli.Context) error {
+			args.Docker.AllowUnconfined = c.StringSlice("allow-unconfined")
+			args.Docker.AllowDockerSocket = c.StringSlice("allow-docker-socket")
+			args.Docker.RegistryMirrors = c.StringSlice("registry-mirror")
 			return start(ctx, args, serviceMode)
 		},
 	}
@@ -179,9 +394,18 @@ func start(ctx context.Context, args shim.CLIArgs, serviceMode bool) (err error)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		closeCtx, cancelClose := context.WithTimeout(ctx, 5*time.Second)
+		defer cancelClose()
+		if err := dockerRunner.CloseTelemetry(closeCtx); err != nil {
+			log.Error(ctx, "failed to flush telemetry", "err", err)
+		}
+	}()
 
 	address := fmt.Sprintf(":%d", args.Shim.HTTPPort)
 	shimServer := api.NewShimServer(ctx, address, dockerRunner, Version)
+	shimServer.SetRateLimit(api.RateLimitConfig{Rate: args.Shim.APIRateLimit, Burst: args.Shim.APIRateBurst})
+	shimServer.SetExecToken(args.Shim.ExecToken)
 
 	defer func() {
 		shutdownCtx, cancelShutdown := context.WithTimeout(ctx, 5*time.Second)
@@ -199,9 +423,25 @@ func start(ctx context.Context, args shim.CLIArgs, serviceMode bool) (err error)
 		}
 	}
 
+	signalCtx, stopSignals := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	go func() {
+		<-signalCtx.Done()
+		shutdownCtx, cancelShutdown := context.WithTimeout(ctx, 5*time.Second)
+		defer cancelShutdown()
+		_ = shimServer.HttpServer.Shutdown(shutdownCtx)
+	}()
+
 	if err := shimServer.HttpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
 
+	if signalCtx.Err() != nil {
+		log.Info(ctx, "shutting down", "timeout", args.Shim.ShutdownTimeout)
+		if err := dockerRunner.Shutdown(ctx, args.Shim.ShutdownTimeout); err != nil {
+			log.Error(ctx, "failed to stop tasks on shutdown", "err", err)
+		}
+	}
+
 	return nil
 }
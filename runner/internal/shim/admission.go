@@ -0,0 +1,128 @@
+package shim
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/dstackai/dstack/runner/internal/log"
+)
+
+// Used if AdmissionWebhookConfig.Timeout is not set
+const defaultAdmissionWebhookTimeout = 5 * time.Second
+
+// The maximum size of the webhook response body, the rest is not read
+const maxAdmissionResponseSize = 64 * 1024
+
+// AdmissionWebhookConfig configures the admission webhook, see admissionWebhook.
+// Empty URL disables the webhook
+type AdmissionWebhookConfig struct {
+	URL     string
+	Timeout time.Duration
+	// If true, tasks are accepted if the webhook fails (is unreachable, times out,
+	// returns a non-2xx status or a malformed response), otherwise rejected
+	FailOpen bool
+}
+
+// AdmissionRequest is the body of the request sent to the admission webhook
+type AdmissionRequest struct {
+	// Redacted, see redactTaskConfig()
+	Config *TaskConfig `json:"config"`
+	// See WithAuditActor()
+	Actor string `json:"actor,omitempty"`
+}
+
+// AdmissionResponse is the expected body of a 2xx webhook response
+type AdmissionResponse struct {
+	Allowed bool `json:"allowed"`
+	// Returned to the client if the task is denied
+	Reason string `json:"reason"`
+}
+
+// admissionWebhook asks an external service whether the submitted task is allowed, so that
+// operators can enforce custom policies (allowed images, required labels, etc.) at submit time
+type admissionWebhook struct {
+	url      string
+	client   *http.Client
+	failOpen bool
+}
+
+// newAdmissionWebhook returns nil if the webhook is disabled
+func newAdmissionWebhook(config AdmissionWebhookConfig) (*admissionWebhook, error) {
+	if config.URL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid admission webhook URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid admission webhook URL %s: scheme must be http or https", config.URL)
+	}
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = defaultAdmissionWebhookTimeout
+	}
+	return &admissionWebhook{
+		url:      config.URL,
+		client:   &http.Client{Timeout: timeout},
+		failOpen: config.FailOpen,
+	}, nil
+}
+
+// Admit returns ErrDenied with the webhook reason if the task is denied.
+// If the webhook fails, it returns ErrHostUnavailable, unless the webhook fails open.
+// A nil webhook admits all tasks
+func (w *admissionWebhook) Admit(ctx context.Context, cfg TaskConfig) error {
+	if w == nil {
+		return nil
+	}
+	resp, err := w.review(ctx, AdmissionRequest{Config: redactTaskConfig(cfg), Actor: GetAuditActor(ctx)})
+	if err != nil {
+		if w.failOpen {
+			log.Warning(ctx, "admission webhook failed, admitting task", "task", cfg.ID, "err", err)
+			return nil
+		}
+		log.Error(ctx, "admission webhook failed, rejecting task", "task", cfg.ID, "err", err)
+		return fmt.Errorf("%w: admission webhook failed: %w", ErrHostUnavailable, err)
+	}
+	if !resp.Allowed {
+		log.Info(ctx, "task denied by admission webhook", "task", cfg.ID, "reason", resp.Reason)
+		return fmt.Errorf("%w: task %s denied by admission webhook: %s", ErrDenied, cfg.ID, resp.Reason)
+	}
+	return nil
+}
+
+func (w *admissionWebhook) review(ctx context.Context, admissionReq AdmissionRequest) (AdmissionResponse, error) {
+	body, err := json.Marshal(admissionReq)
+	if err != nil {
+		return AdmissionResponse{}, fmt.Errorf("marshal request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return AdmissionResponse{}, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return AdmissionResponse{}, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxAdmissionResponseSize))
+	if err != nil {
+		return AdmissionResponse{}, fmt.Errorf("read response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return AdmissionResponse{}, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, bytes.TrimSpace(respBody))
+	}
+	var admissionResp AdmissionResponse
+	if err := json.Unmarshal(respBody, &admissionResp); err != nil {
+		return AdmissionResponse{}, fmt.Errorf("parse response: %w", err)
+	}
+	return admissionResp, nil
+}
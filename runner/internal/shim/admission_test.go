@@ -0,0 +1,131 @@
+package shim
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newAdmissionServer returns a webhook server that responds with the given status and body
+// and records received requests
+func newAdmissionServer(t *testing.T, status int, body string) (*httptest.Server, func() []AdmissionRequest) {
+	var mu sync.Mutex
+	var requests []AdmissionRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req AdmissionRequest
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		mu.Lock()
+		requests = append(requests, req)
+		mu.Unlock()
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []AdmissionRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+}
+
+func TestDockerRunner_Submit_AdmissionAllowed(t *testing.T) {
+	server, requests := newAdmissionServer(t, http.StatusOK, `{"allowed": true}`)
+	runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{
+		admissionWebhook: AdmissionWebhookConfig{URL: server.URL},
+	})
+	cfg := createTaskConfig(t)
+	cfg.RegistryPassword = "hunter2"
+	ctx := WithAuditActor(context.Background(), "alice")
+
+	require.NoError(t, runner.Submit(ctx, cfg))
+
+	assert.Equal(t, TaskStatusPending, runner.TaskInfo(cfg.ID).Status)
+	reqs := requests()
+	require.Len(t, reqs, 1)
+	assert.Equal(t, "alice", reqs[0].Actor)
+	require.NotNil(t, reqs[0].Config)
+	assert.Equal(t, cfg.ID, reqs[0].Config.ID)
+	assert.Equal(t, cfg.ImageName, reqs[0].Config.ImageName)
+	assert.Equal(t, redactedAuditValue, reqs[0].Config.RegistryPassword)
+}
+
+func TestDockerRunner_Submit_AdmissionDenied(t *testing.T) {
+	server, _ := newAdmissionServer(t, http.StatusOK, `{"allowed": false, "reason": "image ubuntu is not allowed"}`)
+	runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{
+		admissionWebhook: AdmissionWebhookConfig{URL: server.URL},
+	})
+	cfg := createTaskConfig(t)
+
+	err := runner.Submit(context.Background(), cfg)
+
+	assert.ErrorIs(t, err, ErrDenied)
+	assert.ErrorContains(t, err, "image ubuntu is not allowed")
+	assert.Equal(t, TaskInfo{}, runner.TaskInfo(cfg.ID))
+}
+
+func TestDockerRunner_Submit_AdmissionWebhookError(t *testing.T) {
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(slow.Close)
+	// the server waits for handlers on close
+	t.Cleanup(func() { close(release) })
+	serverError, _ := newAdmissionServer(t, http.StatusInternalServerError, "boom")
+	malformed, _ := newAdmissionServer(t, http.StatusOK, "allowed")
+
+	testCases := map[string]string{
+		"unreachable":        unreachable.URL,
+		"timeout":            slow.URL,
+		"server error":       serverError.URL,
+		"malformed response": malformed.URL,
+	}
+	for name, url := range testCases {
+		t.Run(name, func(t *testing.T) {
+			for _, failOpen := range []bool{false, true} {
+				runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{
+					admissionWebhook: AdmissionWebhookConfig{URL: url, Timeout: 100 * time.Millisecond, FailOpen: failOpen},
+				})
+				cfg := createTaskConfig(t)
+
+				err := runner.Submit(context.Background(), cfg)
+
+				if failOpen {
+					assert.NoError(t, err)
+					assert.Equal(t, TaskStatusPending, runner.TaskInfo(cfg.ID).Status)
+				} else {
+					assert.ErrorIs(t, err, ErrHostUnavailable)
+					assert.Equal(t, TaskInfo{}, runner.TaskInfo(cfg.ID))
+				}
+			}
+		})
+	}
+}
+
+func TestNewAdmissionWebhook(t *testing.T) {
+	webhook, err := newAdmissionWebhook(AdmissionWebhookConfig{})
+	require.NoError(t, err)
+	assert.Nil(t, webhook)
+	// a nil webhook admits all tasks
+	assert.NoError(t, webhook.Admit(context.Background(), TaskConfig{}))
+
+	webhook, err = newAdmissionWebhook(AdmissionWebhookConfig{URL: "https://policy.local/admit"})
+	require.NoError(t, err)
+	assert.Equal(t, defaultAdmissionWebhookTimeout, webhook.client.Timeout)
+
+	_, err = newAdmissionWebhook(AdmissionWebhookConfig{URL: "policy.local/admit"})
+	assert.Error(t, err)
+	_, err = newAdmissionWebhook(AdmissionWebhookConfig{URL: "unix:///run/policy.sock"})
+	assert.Error(t, err)
+}
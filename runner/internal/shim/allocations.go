@@ -0,0 +1,156 @@
+package shim
+
+import (
+	"context"
+	"slices"
+
+	"github.com/dstackai/dstack/runner/internal/shim/host"
+)
+
+// Allocations is a snapshot of host resources committed to tasks, used by the server
+// for placement decisions
+type Allocations struct {
+	Gpus  []GpuAllocation  `json:"gpus"`
+	Tasks []TaskAllocation `json:"tasks"`
+	// GPUs neither locked, shared, nor reserved, minus GPUs requested by pending tasks
+	FreeGpus     int     `json:"free_gpus"`
+	CpuCount     int     `json:"cpu_count"`
+	CpuCommitted float64 `json:"cpu_committed"`
+	CpuFree      float64 `json:"cpu_free"`
+	// bytes
+	TotalMemory     uint64 `json:"total_memory"`
+	MemoryCommitted uint64 `json:"memory_committed"`
+	MemoryFree      uint64 `json:"memory_free"`
+}
+
+type GpuAllocation struct {
+	// vendor-specific resource ID, see GPUID
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Vram int    `json:"vram"` // MiB
+	// IDs of tasks using the GPU, either one task with exclusive access or several sharing tasks
+	TaskIDs      []string `json:"task_ids"`
+	Exclusive    bool     `json:"exclusive"`
+	FreeFraction float64  `json:"free_fraction"`
+	// An ID of the GPU reservation not consumed yet, see DockerRunner.ReserveGpus()
+	Reservation string `json:"reservation,omitempty"`
+}
+
+// TaskAllocation describes resources committed to the task. CPU and Memory are limits
+// from TaskConfig, zero means "no limit" and is not counted as committed.
+// Pending tasks have not acquired GPUs yet, only GPU (requested count) is set,
+// unless the task has consumed a GPU reservation
+type TaskAllocation struct {
+	ID                string     `json:"id"`
+	Status            TaskStatus `json:"status"`
+	GPU               int        `json:"gpu"`
+	GpuIDs            []string   `json:"gpu_ids"`
+	GpuMemoryFraction float64    `json:"gpu_memory_fraction"`
+	// MPS limits of the task, see TaskConfig.MPSThreadPercentage
+	MPSThreadPercentage uint    `json:"mps_thread_percentage,omitempty"`
+	MPSMemoryLimitMB    uint    `json:"mps_memory_limit_mb,omitempty"`
+	CPU                 float64 `json:"cpu"`
+	Memory              uint64  `json:"memory"` // bytes
+}
+
+// Allocations computes resources committed to all tasks that are not terminated yet,
+// including pending ones, as they are going to acquire resources soon
+func (d *DockerRunner) Allocations(ctx context.Context) Allocations {
+	resources := d.Resources(ctx)
+	allocations := Allocations{
+		Gpus:        make([]GpuAllocation, 0, len(d.gpus)),
+		Tasks:       []TaskAllocation{},
+		CpuCount:    resources.CpuCount,
+		TotalMemory: resources.TotalMemory,
+	}
+	gpuAllocations := make(map[string]*GpuAllocation, len(d.gpus))
+	for _, gpu := range d.gpus {
+		id := getGpuResourceID(gpu)
+		allocations.Gpus = append(allocations.Gpus, GpuAllocation{
+			ID:      id,
+			Name:    gpu.Name,
+			Vram:    gpu.Vram,
+			TaskIDs: []string{},
+		})
+	}
+	for i := range allocations.Gpus {
+		gpuAllocations[allocations.Gpus[i].ID] = &allocations.Gpus[i]
+	}
+	pendingGpus := 0
+	ids := d.tasks.IDs()
+	slices.Sort(ids)
+	for _, id := range ids {
+		task, ok := d.tasks.Get(id)
+		if !ok || task.Status.IsFinished() {
+			continue
+		}
+		taskAllocation := TaskAllocation{
+			ID:                  task.ID,
+			Status:              task.Status,
+			GPU:                 task.config.GPU,
+			GpuIDs:              task.gpuIDs,
+			GpuMemoryFraction:   task.gpuMemoryFraction,
+			MPSThreadPercentage: task.config.MPSThreadPercentage,
+			MPSMemoryLimitMB:    task.config.MPSMemoryLimitMB,
+			CPU:                 task.config.CPU,
+		}
+		if task.config.Memory > 0 {
+			taskAllocation.Memory = uint64(task.config.Memory)
+		}
+		if taskAllocation.GpuIDs == nil {
+			taskAllocation.GpuIDs = []string{}
+		}
+		// Restored tasks have no config, but hold GPUs
+		if taskAllocation.GPU == 0 {
+			taskAllocation.GPU = len(task.gpuIDs)
+		}
+		allocations.Tasks = append(allocations.Tasks, taskAllocation)
+		allocations.CpuCommitted += taskAllocation.CPU
+		allocations.MemoryCommitted += taskAllocation.Memory
+		if task.Status == TaskStatusPending && len(task.gpuIDs) == 0 {
+			// -1 (all available) cannot be known in advance, the task will take whatever is left
+			if task.config.GPU > 0 {
+				pendingGpus += task.config.GPU
+			}
+			continue
+		}
+		for _, gpuID := range task.gpuIDs {
+			gpuAllocation, ok := gpuAllocations[gpuID]
+			if !ok {
+				continue
+			}
+			gpuAllocation.TaskIDs = append(gpuAllocation.TaskIDs, task.ID)
+			if task.gpuMemoryFraction == 0 {
+				gpuAllocation.Exclusive = true
+			}
+		}
+	}
+	// GPUAllocator is the source of truth, tasks may be updated concurrently
+	available := d.gpuAllocator.Available()
+	for gpuID, reservationID := range d.gpuAllocator.Reserved() {
+		if gpuAllocation, ok := gpuAllocations[gpuID]; ok {
+			gpuAllocation.Reservation = reservationID
+		}
+	}
+	for i := range allocations.Gpus {
+		gpuAllocation := &allocations.Gpus[i]
+		gpuAllocation.FreeFraction = available[gpuAllocation.ID]
+		if gpuAllocation.FreeFraction == 1 {
+			allocations.FreeGpus++
+		}
+	}
+	allocations.FreeGpus = max(0, allocations.FreeGpus-pendingGpus)
+	allocations.CpuFree = max(0, float64(allocations.CpuCount)-allocations.CpuCommitted)
+	if allocations.MemoryCommitted < allocations.TotalMemory {
+		allocations.MemoryFree = allocations.TotalMemory - allocations.MemoryCommitted
+	}
+	return allocations
+}
+
+// getGpuResourceID returns vendor-specific GPU ID used by GPUAllocator
+func getGpuResourceID(gpu host.GpuInfo) string {
+	if gpu.Vendor == host.GpuVendorAmd {
+		return gpu.RenderNodePath
+	}
+	return gpu.ID
+}
@@ -0,0 +1,78 @@
+package shim
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dstackai/dstack/runner/internal/shim/host"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDockerRunner_Allocations(t *testing.T) {
+	gpus := []host.GpuInfo{
+		{Vendor: host.GpuVendorNvidia, Name: "H100", Vram: 81920, ID: "GPU-beef"},
+		{Vendor: host.GpuVendorNvidia, Name: "H100", Vram: 81920, ID: "GPU-f00d"},
+		{Vendor: host.GpuVendorNvidia, Name: "H100", Vram: 81920, ID: "GPU-c0de"},
+		{Vendor: host.GpuVendorNvidia, Name: "H100", Vram: 81920, ID: "GPU-cafe"},
+	}
+	runner, err := newDockerRunner(context.Background(), newFakeDockerClient(), &dockerParametersMock{}, gpus)
+	require.NoError(t, err)
+	addTask := func(id string, status TaskStatus, cfg TaskConfig, gpuIDs ...string) {
+		cfg.ID = id
+		task := NewTaskFromConfig(cfg)
+		task.Status = status
+		task.gpuIDs = gpuIDs
+		require.NoError(t, runner.tasks.Add(task))
+		// GPUAllocator is the source of truth for GPU availability
+		if !status.IsFinished() {
+			runner.gpuAllocator.Restore(context.Background(), id, gpuIDs, cfg.GPUMemoryFraction, MPSLimits{})
+		}
+	}
+	// exclusive GPU
+	addTask("running", TaskStatusRunning, TaskConfig{GPU: 1, CPU: 2, Memory: 1024}, "GPU-beef")
+	// GPUs are acquired before the container is created, should be reported as well
+	addTask("creating", TaskStatusCreating, TaskConfig{GPU: 1, CPU: 0.5}, "GPU-f00d")
+	// shared GPU
+	addTask("shared-1", TaskStatusRunning, TaskConfig{GPU: 1, GPUMemoryFraction: 0.25, Memory: 512}, "GPU-c0de")
+	addTask("shared-2", TaskStatusPulling, TaskConfig{GPU: 1, GPUMemoryFraction: 0.5}, "GPU-c0de")
+	// not acquired yet, GPU is counted as requested
+	addTask("pending", TaskStatusPending, TaskConfig{GPU: 1, CPU: 1})
+	// not counted
+	addTask("terminated", TaskStatusTerminated, TaskConfig{GPU: 1, CPU: 8, Memory: 4096}, "GPU-cafe")
+
+	allocations := runner.Allocations(context.Background())
+
+	assert.Equal(t, []GpuAllocation{
+		{ID: "GPU-beef", Name: "H100", Vram: 81920, TaskIDs: []string{"running"}, Exclusive: true, FreeFraction: 0},
+		{ID: "GPU-f00d", Name: "H100", Vram: 81920, TaskIDs: []string{"creating"}, Exclusive: true, FreeFraction: 0},
+		{ID: "GPU-c0de", Name: "H100", Vram: 81920, TaskIDs: []string{"shared-1", "shared-2"}, FreeFraction: 0.25},
+		{ID: "GPU-cafe", Name: "H100", Vram: 81920, TaskIDs: []string{}, FreeFraction: 1},
+	}, allocations.Gpus)
+	assert.Equal(t, []TaskAllocation{
+		{ID: "creating", Status: TaskStatusCreating, GPU: 1, GpuIDs: []string{"GPU-f00d"}, CPU: 0.5},
+		{ID: "pending", Status: TaskStatusPending, GPU: 1, GpuIDs: []string{}, CPU: 1},
+		{ID: "running", Status: TaskStatusRunning, GPU: 1, GpuIDs: []string{"GPU-beef"}, CPU: 2, Memory: 1024},
+		{ID: "shared-1", Status: TaskStatusRunning, GPU: 1, GpuIDs: []string{"GPU-c0de"}, GpuMemoryFraction: 0.25, Memory: 512},
+		{ID: "shared-2", Status: TaskStatusPulling, GPU: 1, GpuIDs: []string{"GPU-c0de"}, GpuMemoryFraction: 0.5},
+	}, allocations.Tasks)
+	// GPU-cafe is free, but requested by the pending task
+	assert.Equal(t, 0, allocations.FreeGpus)
+	assert.Equal(t, 3.5, allocations.CpuCommitted)
+	assert.Equal(t, uint64(1536), allocations.MemoryCommitted)
+	assert.Equal(t, max(0, float64(allocations.CpuCount)-3.5), allocations.CpuFree)
+	assert.Equal(t, allocations.TotalMemory-1536, allocations.MemoryFree)
+}
+
+func TestDockerRunner_Allocations_NoTasks(t *testing.T) {
+	runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{})
+
+	allocations := runner.Allocations(context.Background())
+
+	assert.Equal(t, []GpuAllocation{}, allocations.Gpus)
+	assert.Equal(t, []TaskAllocation{}, allocations.Tasks)
+	assert.Equal(t, 0, allocations.FreeGpus)
+	assert.Equal(t, float64(0), allocations.CpuCommitted)
+	assert.Equal(t, float64(allocations.CpuCount), allocations.CpuFree)
+	assert.Equal(t, allocations.TotalMemory, allocations.MemoryFree)
+}
@@ -0,0 +1,204 @@
+package shim
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/dstackai/dstack/runner/internal/log"
+)
+
+// The subdir of the shim state dir where taskStates are persisted
+const taskStateDirName = "tasks"
+
+// Annotation limits, annotations are kept in memory and persisted for each task
+const (
+	maxAnnotations         = 64
+	maxAnnotationKeySize   = 253
+	maxAnnotationValueSize = 4096
+)
+
+// taskAnnotations stores annotations of tasks, separately from TaskStorage for the same reason
+// as taskLeases: an update would be lost if a concurrent operation commits its own Task copy.
+// Annotations are persisted with taskStates
+type taskAnnotations struct {
+	// Task.ID: annotations mapping
+	annotations map[string]map[string]string
+	mu          sync.Mutex
+}
+
+func newTaskAnnotations() *taskAnnotations {
+	return &taskAnnotations{annotations: make(map[string]map[string]string)}
+}
+
+// Get returns a copy of the task annotations, nil if there are none
+func (a *taskAnnotations) Get(taskID string) map[string]string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return maps.Clone(a.annotations[taskID])
+}
+
+// Set replaces all annotations of the task
+func (a *taskAnnotations) Set(taskID string, annotations map[string]string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(annotations) == 0 {
+		delete(a.annotations, taskID)
+		return
+	}
+	a.annotations[taskID] = maps.Clone(annotations)
+}
+
+func (a *taskAnnotations) Delete(taskID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.annotations, taskID)
+}
+
+// taskState is the part of the task config that can be changed by Update(). Container labels
+// cannot be changed, so the current values are persisted separately to be restored on shim restart
+type taskState struct {
+	Annotations map[string]string `json:"annotations"`
+	// Seconds, 0 = no lease
+	LeaseDuration uint `json:"lease_duration"`
+}
+
+// taskStates persists taskState of tasks, one tasks/<task ID>.json file per task in the shim
+// state dir, like restartIntents. Empty dir disables persistence, changes are lost on shim
+// restart then
+type taskStates struct {
+	dir string
+}
+
+func newTaskStates(stateDir string) *taskStates {
+	if stateDir == "" {
+		return &taskStates{}
+	}
+	return &taskStates{dir: filepath.Join(stateDir, taskStateDirName)}
+}
+
+// Save writes the state atomically, it's a no-op if persistence is disabled
+func (s *taskStates) Save(taskID string, state taskState) error {
+	if s.dir == "" {
+		return nil
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("marshal task state: %w", err)
+	}
+	return writeStateFile(s.path(taskID), data)
+}
+
+// Load returns the persisted state of the task, ok is false if there is none
+func (s *taskStates) Load(taskID string) (state taskState, ok bool, err error) {
+	if s.dir == "" {
+		return taskState{}, false, nil
+	}
+	data, err := os.ReadFile(s.path(taskID))
+	if errors.Is(err, os.ErrNotExist) {
+		return taskState{}, false, nil
+	}
+	if err != nil {
+		return taskState{}, false, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return taskState{}, false, fmt.Errorf("parse task state: %w", err)
+	}
+	return state, true, nil
+}
+
+// Delete removes the state of the task. It's not an error if the task has no state
+func (s *taskStates) Delete(taskID string) error {
+	if s.dir == "" {
+		return nil
+	}
+	if err := os.Remove(s.path(taskID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *taskStates) path(taskID string) string {
+	return filepath.Join(s.dir, taskID+".json")
+}
+
+// getTaskState returns the current state of the task, as it would be persisted
+func (d *DockerRunner) getTaskState(taskID string) taskState {
+	state := taskState{Annotations: d.annotations.Get(taskID)}
+	if duration, _, ok := d.leases.Get(taskID); ok {
+		state.LeaseDuration = uint(duration / time.Second)
+	}
+	return state
+}
+
+func validateAnnotations(annotations map[string]string) error {
+	if len(annotations) > maxAnnotations {
+		return fmt.Errorf("%w: too many annotations, %d > %d", ErrInvalidConfig, len(annotations), maxAnnotations)
+	}
+	for key, value := range annotations {
+		if key == "" || len(key) > maxAnnotationKeySize {
+			return fmt.Errorf("%w: annotation key must be 1..%d bytes long, got %q", ErrInvalidConfig, maxAnnotationKeySize, key)
+		}
+		if len(value) > maxAnnotationValueSize {
+			return fmt.Errorf("%w: annotation %s value is too long, %d > %d bytes", ErrInvalidConfig, key, len(value), maxAnnotationValueSize)
+		}
+	}
+	return nil
+}
+
+// Update changes mutable fields of a submitted, not yet terminated task and returns
+// the updated task info. Other fields cannot be changed without resubmitting the task.
+// Changes are persisted before they take effect, see taskStates
+func (d *DockerRunner) Update(ctx context.Context, taskID string, update TaskUpdate) (TaskInfo, error) {
+	task, ok := d.tasks.Get(taskID)
+	if !ok {
+		return TaskInfo{}, fmt.Errorf("task %s: %w", taskID, ErrNotFound)
+	}
+	ctx = withTraceID(ctx, task.config.TraceID)
+	// Serialized with Terminate() and Remove(), so that the state of a removed task is not saved
+	locked := task
+	locked.Lock(ctx)
+	defer func() { locked.Release(ctx) }()
+	if task, ok = d.tasks.Get(taskID); !ok || task.mu != locked.mu {
+		return TaskInfo{}, fmt.Errorf("task %s: %w", taskID, ErrNotFound)
+	}
+	if task.Status.IsFinished() {
+		return TaskInfo{}, fmt.Errorf("%w: cannot update task %s with %s status", ErrRequest, task.ID, task.Status)
+	}
+	if update.Annotations != nil {
+		if err := validateAnnotations(update.Annotations); err != nil {
+			return TaskInfo{}, err
+		}
+	}
+	state := d.getTaskState(task.ID)
+	if update.Annotations != nil {
+		state.Annotations = update.Annotations
+	}
+	if update.LeaseDuration != nil {
+		state.LeaseDuration = *update.LeaseDuration
+	}
+	if err := d.taskStates.Save(task.ID, state); err != nil {
+		return TaskInfo{}, fmt.Errorf("%w: task %s: failed to persist task state: %w", ErrInternal, task.ID, err)
+	}
+	if update.Annotations != nil {
+		d.annotations.Set(task.ID, update.Annotations)
+		log.Debug(ctx, "annotations updated", "task", task.ID)
+	}
+	if update.LeaseDuration != nil {
+		if *update.LeaseDuration > 0 {
+			expiresAt := d.leases.Start(task.ID, time.Duration(*update.LeaseDuration)*time.Second)
+			log.Debug(ctx, "lease restarted", "task", task.ID, "duration", *update.LeaseDuration, "expires", expiresAt)
+		} else {
+			d.leases.Delete(task.ID)
+			log.Debug(ctx, "lease removed", "task", task.ID)
+		}
+	}
+	d.audit.Record(ctx, AuditRecord{Action: AuditActionUpdate, TaskID: task.ID, TraceID: task.config.TraceID, Update: &update})
+	return d.TaskInfo(task.ID), nil
+}
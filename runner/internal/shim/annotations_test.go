@@ -0,0 +1,136 @@
+package shim
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateAnnotations(t *testing.T) {
+	assert.NoError(t, validateAnnotations(nil))
+	assert.NoError(t, validateAnnotations(map[string]string{"dstack.ai/run": "run-1", "note": ""}))
+
+	tooMany := map[string]string{}
+	for i := range maxAnnotations + 1 {
+		tooMany[strings.Repeat("k", i+1)] = "v"
+	}
+	for _, annotations := range []map[string]string{
+		tooMany,
+		{"": "v"},
+		{strings.Repeat("k", maxAnnotationKeySize+1): "v"},
+		{"k": strings.Repeat("v", maxAnnotationValueSize+1)},
+	} {
+		assert.ErrorIs(t, validateAnnotations(annotations), ErrInvalidConfig)
+	}
+}
+
+func TestDockerRunner_Update(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.Annotations = map[string]string{"run": "run-1", "replica": "0"}
+	cfg.LeaseDuration = 60
+	containerID := runTask(t, runner, cfg)
+
+	taskInfo := runner.TaskInfo(cfg.ID)
+	assert.Equal(t, cfg.Annotations, taskInfo.Annotations)
+	assert.Equal(t, uint(60), taskInfo.LeaseDuration)
+	require.NotNil(t, taskInfo.LeaseExpiresAt)
+
+	leaseDuration := uint(600)
+	taskInfo, err := runner.Update(ctx, cfg.ID, TaskUpdate{
+		Annotations:   map[string]string{"run": "run-1", "replica": "1"},
+		LeaseDuration: &leaseDuration,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"run": "run-1", "replica": "1"}, taskInfo.Annotations)
+	assert.Equal(t, uint(600), taskInfo.LeaseDuration)
+	assert.WithinDuration(t, time.Now().Add(600*time.Second), *taskInfo.LeaseExpiresAt, 5*time.Second)
+	assert.Equal(t, TaskStatusRunning, taskInfo.Status)
+	assert.Equal(t, containerID, taskInfo.ContainerID)
+
+	// nil fields are not changed
+	taskInfo, err = runner.Update(ctx, cfg.ID, TaskUpdate{})
+	require.NoError(t, err)
+	assert.Equal(t, "1", taskInfo.Annotations["replica"])
+	assert.Equal(t, uint(600), taskInfo.LeaseDuration)
+
+	leaseDuration = 0
+	_, err = runner.Update(ctx, cfg.ID, TaskUpdate{Annotations: map[string]string{}, LeaseDuration: &leaseDuration})
+	require.NoError(t, err)
+	taskInfo = runner.TaskInfo(cfg.ID)
+	assert.Empty(t, taskInfo.Annotations)
+	assert.Equal(t, uint(0), taskInfo.LeaseDuration)
+	assert.Nil(t, taskInfo.LeaseExpiresAt)
+	_, err = runner.Renew(ctx, cfg.ID)
+	assert.ErrorIs(t, err, ErrRequest)
+
+	// the update survives the task copy committed by Run()
+	_, err = runner.Update(ctx, cfg.ID, TaskUpdate{Annotations: map[string]string{"run": "run-2"}})
+	require.NoError(t, err)
+	client.exitContainer(containerID, 0)
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusTerminated)
+	assert.Equal(t, map[string]string{"run": "run-2"}, runner.TaskInfo(cfg.ID).Annotations)
+}
+
+func TestDockerRunner_Update_RestoredOnRestart(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeDockerClient()
+	params := &dockerParametersMock{stateDir: t.TempDir()}
+	runner := newFakeDockerRunner(t, client, params)
+	cfg := createTaskConfig(t)
+	cfg.Annotations = map[string]string{"replica": "0"}
+	cfg.LeaseDuration = 60
+	containerID := runTask(t, runner, cfg)
+	defer client.exitContainer(containerID, 0)
+	// submitted annotations are restored too
+	noUpdate := createTaskConfig(t)
+	noUpdate.Annotations = map[string]string{"run": "run-1"}
+	noUpdateContainerID := runTask(t, runner, noUpdate)
+	defer client.exitContainer(noUpdateContainerID, 0)
+
+	leaseDuration := uint(600)
+	_, err := runner.Update(ctx, cfg.ID, TaskUpdate{
+		Annotations:   map[string]string{"replica": "1"},
+		LeaseDuration: &leaseDuration,
+	})
+	require.NoError(t, err)
+
+	restarted := newFakeDockerRunner(t, client, params)
+	taskInfo := restarted.TaskInfo(cfg.ID)
+	assert.Equal(t, TaskStatusRunning, taskInfo.Status)
+	assert.Equal(t, map[string]string{"replica": "1"}, taskInfo.Annotations)
+	assert.Equal(t, uint(600), taskInfo.LeaseDuration)
+	assert.Equal(t, map[string]string{"run": "run-1"}, restarted.TaskInfo(noUpdate.ID).Annotations)
+
+	require.NoError(t, restarted.Terminate(ctx, cfg.ID, nil, "TERMINATED_BY_SERVER", ""))
+	require.NoError(t, restarted.Remove(ctx, cfg.ID))
+	_, ok, err := restarted.taskStates.Load(cfg.ID)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestDockerRunner_Update_Errors(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+
+	_, err := runner.Update(ctx, "unknown", TaskUpdate{})
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	cfg := createTaskConfig(t)
+	containerID := runTask(t, runner, cfg)
+	_, err = runner.Update(ctx, cfg.ID, TaskUpdate{Annotations: map[string]string{"": "v"}})
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+
+	client.exitContainer(containerID, 0)
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusTerminated)
+	_, err = runner.Update(ctx, cfg.ID, TaskUpdate{Annotations: map[string]string{"k": "v"}})
+	assert.ErrorIs(t, err, ErrRequest)
+	assert.Empty(t, runner.TaskInfo(cfg.ID).Annotations)
+}
@@ -2,14 +2,22 @@ package api
 
 import (
 	"context"
+	"io"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/dstackai/dstack/runner/internal/shim"
 )
 
 type DummyRunner struct {
 	tasks map[string]bool
-	mu    sync.Mutex
+	// submitted tasks, TaskConfig.Labels are matched by SelectTasks()
+	configs map[string]shim.TaskConfig
+	// returned by TaskDiff() for any submitted task
+	changes []shim.FilesystemChange
+	mu      sync.Mutex
 }
 
 func (ds *DummyRunner) Submit(ctx context.Context, cfg shim.TaskConfig) error {
@@ -19,6 +27,7 @@ func (ds *DummyRunner) Submit(ctx context.Context, cfg shim.TaskConfig) error {
 		return shim.ErrRequest
 	}
 	ds.tasks[cfg.ID] = true
+	ds.configs[cfg.ID] = cfg
 	return nil
 }
 
@@ -26,28 +35,134 @@ func (ds *DummyRunner) Run(context.Context, string) error {
 	return nil
 }
 
-func (ds *DummyRunner) Terminate(context.Context, string, uint, string, string) error {
+func (ds *DummyRunner) Terminate(context.Context, string, *uint, string, string) error {
 	return nil
 }
 
+func (ds *DummyRunner) TerminateBatch(_ context.Context, taskIDs []string, all bool, _ *uint, _ string, _ string) ([]shim.TerminateResult, error) {
+	if all == (len(taskIDs) > 0) {
+		return nil, shim.ErrRequest
+	}
+	results := []shim.TerminateResult{}
+	for _, taskID := range taskIDs {
+		results = append(results, shim.TerminateResult{TaskID: taskID, Status: shim.TerminateResultStopped})
+	}
+	return results, nil
+}
+
 func (ds *DummyRunner) Remove(context.Context, string) error {
 	return nil
 }
 
+func (ds *DummyRunner) Renew(context.Context, string) (time.Time, error) {
+	return time.Time{}, shim.ErrNotFound
+}
+
+func (ds *DummyRunner) Update(context.Context, string, shim.TaskUpdate) (shim.TaskInfo, error) {
+	return shim.TaskInfo{}, shim.ErrNotFound
+}
+
+func (ds *DummyRunner) Replace(context.Context, string, shim.TaskConfig) (shim.TaskInfo, error) {
+	return shim.TaskInfo{}, shim.ErrNotFound
+}
+
+func (ds *DummyRunner) Wait(context.Context, string, time.Duration) (shim.TaskInfo, error) {
+	return shim.TaskInfo{}, shim.ErrNotFound
+}
+
+func (ds *DummyRunner) SearchLogs(context.Context, string, shim.LogSearchQuery) (shim.LogSearchResult, error) {
+	return shim.LogSearchResult{}, shim.ErrNotFound
+}
+
+func (ds *DummyRunner) TaskFiles(context.Context, string, string) (io.ReadCloser, error) {
+	return nil, shim.ErrNotFound
+}
+
+func (ds *DummyRunner) TaskDiff(_ context.Context, taskID string) ([]shim.FilesystemChange, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if !ds.tasks[taskID] {
+		return nil, shim.ErrNotFound
+	}
+	return ds.changes, nil
+}
+
+func (ds *DummyRunner) Attach(context.Context, string) (*shim.AttachStream, error) {
+	return nil, shim.ErrNotFound
+}
+
+// Exec echoes the command, `false` exits with 1
+func (ds *DummyRunner) Exec(_ context.Context, taskID string, req shim.ExecRequest) (shim.ExecResult, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if !ds.tasks[taskID] {
+		return shim.ExecResult{}, shim.ErrNotFound
+	}
+	result := shim.ExecResult{Output: strings.Join(req.Command, " ")}
+	if req.Command[0] == "false" {
+		result.ExitCode = 1
+	}
+	return result, nil
+}
+
+func (ds *DummyRunner) RunLogs(context.Context, string, bool) (*shim.RunLogStream, error) {
+	return nil, shim.ErrNotFound
+}
+
 func (ds *DummyRunner) TaskIDs() []string {
-	return []string{}
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ids := make([]string, 0, len(ds.tasks))
+	for id := range ds.tasks {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func (ds *DummyRunner) SelectTasks(selector shim.TaskSelector) []string {
+	ids := []string{}
+	for _, id := range ds.TaskIDs() {
+		ds.mu.Lock()
+		labels := ds.configs[id].Labels
+		ds.mu.Unlock()
+		if selector.Matches(labels, nil) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
 }
 
 func (ds *DummyRunner) TaskInfo(taskID string) shim.TaskInfo {
 	return shim.TaskInfo{}
 }
 
+func (ds *DummyRunner) TaskConfig(taskID string) (shim.TaskConfig, bool) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	cfg, ok := ds.configs[taskID]
+	return cfg, ok
+}
+
 func (ds *DummyRunner) Resources(context.Context) shim.Resources {
 	return shim.Resources{}
 }
 
+func (ds *DummyRunner) Allocations(context.Context) shim.Allocations {
+	return shim.Allocations{}
+}
+
+func (ds *DummyRunner) ReserveGpus(context.Context, []string, time.Duration) (string, time.Time, error) {
+	return "", time.Time{}, shim.ErrNoCapacity
+}
+
+func (ds *DummyRunner) Ready(context.Context) error {
+	return nil
+}
+
 func NewDummyRunner() *DummyRunner {
 	return &DummyRunner{
-		tasks: map[string]bool{},
+		tasks:   map[string]bool{},
+		configs: map[string]shim.TaskConfig{},
 	}
 }
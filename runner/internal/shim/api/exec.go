@@ -0,0 +1,68 @@
+package api
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/dstackai/dstack/runner/internal/api"
+	"github.com/dstackai/dstack/runner/internal/log"
+)
+
+// SetExecToken enables POST /api/tasks/{id}/exec and POST /api/tasks/{id}/attach, which give
+// access to the container processes, for clients presenting the token, either as
+// `Authorization: Bearer <token>` or as the HTTP basic auth password, so that the username
+// is still recorded as the audit actor. Exec and attach are disabled if the token is empty.
+// Must be called before the server is started
+func (s *ShimServer) SetExecToken(token string) {
+	s.execToken = token
+}
+
+// execAuthorized responds with 403 if exec is disabled, and with 401 if the token is missing or wrong
+func (s *ShimServer) execAuthorized(handler func(http.ResponseWriter, *http.Request) (interface{}, error)) func(http.ResponseWriter, *http.Request) (interface{}, error) {
+	return func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+		if err := s.checkExecToken(w, r); err != nil {
+			return nil, err
+		}
+		return handler(w, r)
+	}
+}
+
+// execAuthorizedRaw is the same as execAuthorized, but for handlers writing the response themselves
+func (s *ShimServer) execAuthorizedRaw(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := s.checkExecToken(w, r); err != nil {
+			if err.Err != nil {
+				log.Info(r.Context(), "request rejected", "err", err.Err)
+			}
+			http.Error(w, err.Msg, err.Status)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (s *ShimServer) checkExecToken(w http.ResponseWriter, r *http.Request) *api.Error {
+	if s.execToken == "" {
+		return &api.Error{Status: http.StatusForbidden, Msg: "exec and attach are disabled, the shim exec token is not set"}
+	}
+	token, ok := getRequestToken(r)
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.execToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="dstack-shim"`)
+		return &api.Error{
+			Status: http.StatusUnauthorized,
+			Err:    fmt.Errorf("invalid or missing exec token: %s %s", r.Method, r.URL.Path),
+			Msg:    "invalid or missing exec token",
+		}
+	}
+	return nil
+}
+
+func getRequestToken(r *http.Request) (string, bool) {
+	if _, password, ok := r.BasicAuth(); ok {
+		return password, true
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token, ok && token != ""
+}
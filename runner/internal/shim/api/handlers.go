@@ -2,14 +2,28 @@ package api
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/dstackai/dstack/runner/internal/api"
 	"github.com/dstackai/dstack/runner/internal/log"
 	"github.com/dstackai/dstack/runner/internal/shim"
 )
 
+// TaskWaitHandler timeout limits, the server may need to adjust its HTTP client timeout
+const (
+	defaultWaitTimeout = 30 * time.Second
+	maxWaitTimeout     = 10 * time.Minute
+)
+
 func (s *ShimServer) HealthcheckHandler(w http.ResponseWriter, r *http.Request) (interface{}, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -20,8 +34,50 @@ func (s *ShimServer) HealthcheckHandler(w http.ResponseWriter, r *http.Request)
 	}, nil
 }
 
+// ReadyzHandler reports whether the shim accepts new tasks, 503 if it doesn't,
+// e.g., the Docker daemon is unhealthy. Unlike the healthcheck, the shim may be alive but not ready
+func (s *ShimServer) ReadyzHandler(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	if err := s.runner.Ready(r.Context()); err != nil {
+		return nil, &api.Error{Status: http.StatusServiceUnavailable, Err: err}
+	}
+	return nil, nil
+}
+
+func (s *ShimServer) AllocationsHandler(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	return AllocationsResponse(s.runner.Allocations(r.Context())), nil
+}
+
+// GpuReservationHandler reserves idle GPUs for a task to be submitted later, see shim.TaskConfig.GPUReservation
+func (s *ShimServer) GpuReservationHandler(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	var req GpuReservationRequest
+	if err := api.DecodeJSONBody(w, r, &req, true); err != nil {
+		return nil, err
+	}
+	ctx := r.Context()
+	reservationID, expiresAt, err := s.runner.ReserveGpus(ctx, req.GpuIDs, time.Duration(req.TTL)*time.Second)
+	if err != nil {
+		if errors.Is(err, shim.ErrInvalidConfig) {
+			log.Info(ctx, "invalid GPU reservation", "gpus", req.GpuIDs, "err", err)
+			return nil, &api.Error{Status: http.StatusBadRequest, Err: err}
+		}
+		if errors.Is(err, shim.ErrNoCapacity) {
+			log.Info(ctx, "cannot reserve GPUs", "gpus", req.GpuIDs, "err", err)
+			return nil, &api.Error{Status: http.StatusConflict, Err: err}
+		}
+		log.Error(ctx, "failed to reserve GPUs", "gpus", req.GpuIDs, "err", err)
+		return nil, &api.Error{Status: http.StatusInternalServerError, Err: err}
+	}
+	return &GpuReservationResponse{ReservationID: reservationID, ExpiresAt: expiresAt}, nil
+}
+
+// TaskListHandler returns task IDs in ascending order, optionally paginated, see parsePageParams()
 func (s *ShimServer) TaskListHandler(w http.ResponseWriter, r *http.Request) (interface{}, error) {
-	return &TaskListResponse{IDs: s.runner.TaskIDs()}, nil
+	limit, afterID, err := parsePageParams(r.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+	ids, nextCursor := paginateIDs(s.runner.TaskIDs(), afterID, limit)
+	return &TaskListResponse{IDs: ids, NextCursor: nextCursor}, nil
 }
 
 func (s *ShimServer) TaskInfoHandler(w http.ResponseWriter, r *http.Request) (interface{}, error) {
@@ -56,10 +112,22 @@ func (s *ShimServer) TaskSubmitHandler(w http.ResponseWriter, r *http.Request) (
 	ctx := r.Context()
 	taskConfig := shim.TaskConfig(req)
 	if err := s.runner.Submit(ctx, taskConfig); err != nil {
+		if errors.Is(err, shim.ErrInvalidConfig) {
+			log.Info(ctx, "invalid config", "task", taskConfig.ID, "err", err)
+			return nil, &api.Error{Status: http.StatusBadRequest, Err: err}
+		}
 		if errors.Is(err, shim.ErrRequest) {
 			log.Info(ctx, "already submitted", "task", taskConfig.ID, "err", err)
 			return nil, &api.Error{Status: http.StatusConflict, Err: err}
 		}
+		if errors.Is(err, shim.ErrDenied) {
+			log.Info(ctx, "denied", "task", taskConfig.ID, "err", err)
+			return nil, &api.Error{Status: http.StatusForbidden, Err: err}
+		}
+		if errors.Is(err, shim.ErrHostUnavailable) {
+			log.Warning(ctx, "host unavailable", "task", taskConfig.ID, "err", err)
+			return nil, &api.Error{Status: http.StatusServiceUnavailable, Err: err}
+		}
 		log.Error(ctx, "conflict", "task", taskConfig.ID, "err", err)
 		return nil, &api.Error{Status: http.StatusInternalServerError, Err: err}
 	}
@@ -103,6 +171,52 @@ func (s *ShimServer) TaskTerminateHandler(w http.ResponseWriter, r *http.Request
 	return TaskInfoResponse(taskInfo), nil
 }
 
+// TaskStopHandler stops the tasks listed in the body, all tasks, or, if the selector query
+// parameter is set, tasks matching it, see shim.ParseTaskSelector(). The empty selector
+// is rejected unless all is set, so that a missing value doesn't stop everything
+func (s *ShimServer) TaskStopHandler(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	ctx := r.Context()
+	var req TaskStopRequest
+	if err := api.DecodeJSONBody(w, r, &req, true); err != nil {
+		return nil, err
+	}
+	ids, all := req.IDs, req.All
+	query := r.URL.Query()
+	if query.Has("selector") {
+		if len(req.IDs) > 0 {
+			return nil, &api.Error{Status: http.StatusBadRequest, Msg: "either ids or selector must be set, not both"}
+		}
+		selector, err := shim.ParseTaskSelector(query.Get("selector"))
+		if err != nil {
+			return nil, &api.Error{Status: http.StatusBadRequest, Err: err}
+		}
+		if len(selector) == 0 && !req.All {
+			return nil, &api.Error{Status: http.StatusBadRequest, Msg: "empty selector matches all tasks, set all to stop them"}
+		}
+		if len(selector) > 0 {
+			if req.All {
+				return nil, &api.Error{Status: http.StatusBadRequest, Msg: "either all or a non-empty selector must be set, not both"}
+			}
+			ids, all = s.runner.SelectTasks(selector), false
+			log.Info(ctx, "stopping tasks by selector", "selector", query.Get("selector"), "count", len(ids))
+			if len(ids) == 0 {
+				return TaskStopResponse{Results: []shim.TerminateResult{}}, nil
+			}
+		}
+	}
+	// Stopping continues even if the client disconnects, tasks are not left half-stopped
+	results, err := s.runner.TerminateBatch(context.WithoutCancel(ctx), ids, all, req.Timeout, req.TerminationReason, req.TerminationMessage)
+	if err != nil {
+		if errors.Is(err, shim.ErrRequest) {
+			return nil, &api.Error{Status: http.StatusBadRequest, Err: err}
+		}
+		log.Error(ctx, "failed to stop tasks", "err", err)
+		return nil, &api.Error{Status: http.StatusInternalServerError, Err: err}
+	}
+	log.Info(ctx, "stopped tasks", "count", len(results))
+	return TaskStopResponse{Results: results}, nil
+}
+
 func (s *ShimServer) TaskRemoveHandler(w http.ResponseWriter, r *http.Request) (interface{}, error) {
 	ctx := r.Context()
 	taskID := r.PathValue("id")
@@ -121,3 +235,445 @@ func (s *ShimServer) TaskRemoveHandler(w http.ResponseWriter, r *http.Request) (
 	log.Info(ctx, "removed", "task", taskID)
 	return nil, nil
 }
+
+// TaskRenewHandler extends the task lease, see shim.TaskConfig.LeaseDuration
+func (s *ShimServer) TaskRenewHandler(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	ctx := r.Context()
+	taskID := r.PathValue("id")
+	expiresAt, err := s.runner.Renew(ctx, taskID)
+	if err != nil {
+		if errors.Is(err, shim.ErrNotFound) {
+			log.Info(ctx, "not found", "task", taskID, "err", err)
+			return nil, &api.Error{Status: http.StatusNotFound, Err: err}
+		}
+		if errors.Is(err, shim.ErrRequest) {
+			log.Info(ctx, "cannot renew", "task", taskID, "err", err)
+			return nil, &api.Error{Status: http.StatusConflict, Err: err}
+		}
+		log.Error(ctx, "failed to renew", "task", taskID, "err", err)
+		return nil, &api.Error{Status: http.StatusInternalServerError, Err: err}
+	}
+	return &TaskRenewResponse{LeaseExpiresAt: expiresAt}, nil
+}
+
+// TaskUpdateHandler changes mutable fields of the task, see shim.TaskUpdate. Other fields
+// of the submit request are immutable, an attempt to change them is a conflict, while
+// the current values are accepted, e.g., if the server sends the whole config back
+func (s *ShimServer) TaskUpdateHandler(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	ctx := r.Context()
+	taskID := r.PathValue("id")
+	var body json.RawMessage
+	if err := api.DecodeJSONBody(w, r, &body, true); err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, &api.Error{Status: http.StatusBadRequest, Msg: "request body must be a JSON object"}
+	}
+	mutableFields := getJSONFieldNames(reflect.TypeFor[TaskUpdateRequest]())
+	configFields := getJSONFieldNames(reflect.TypeFor[TaskSubmitRequest]())
+	var immutableFields []string
+	for name := range fields {
+		if slices.Contains(mutableFields, name) {
+			continue
+		}
+		if slices.Contains(configFields, name) {
+			immutableFields = append(immutableFields, name)
+			continue
+		}
+		return nil, &api.Error{Status: http.StatusBadRequest, Msg: fmt.Sprintf("unknown field %s", name)}
+	}
+	if len(immutableFields) > 0 {
+		cfg, ok := s.runner.TaskConfig(taskID)
+		if !ok {
+			return nil, &api.Error{Status: http.StatusNotFound, Err: fmt.Errorf("task %s: %w", taskID, shim.ErrNotFound)}
+		}
+		name, err := getChangedConfigField(cfg, fields, immutableFields)
+		if err != nil {
+			return nil, &api.Error{Status: http.StatusBadRequest, Msg: fmt.Sprintf("invalid request body: %s", err)}
+		}
+		if name != "" {
+			return nil, &api.Error{Status: http.StatusConflict, Msg: fmt.Sprintf("%s cannot be changed", name)}
+		}
+	}
+	var req TaskUpdateRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, &api.Error{Status: http.StatusBadRequest, Msg: fmt.Sprintf("invalid request body: %s", err)}
+	}
+	taskInfo, err := s.runner.Update(ctx, taskID, shim.TaskUpdate(req))
+	if err != nil {
+		if errors.Is(err, shim.ErrInvalidConfig) {
+			return nil, &api.Error{Status: http.StatusBadRequest, Err: err}
+		}
+		if errors.Is(err, shim.ErrNotFound) {
+			log.Info(ctx, "not found", "task", taskID, "err", err)
+			return nil, &api.Error{Status: http.StatusNotFound, Err: err}
+		}
+		if errors.Is(err, shim.ErrRequest) {
+			log.Info(ctx, "cannot update", "task", taskID, "err", err)
+			return nil, &api.Error{Status: http.StatusConflict, Err: err}
+		}
+		log.Error(ctx, "failed to update", "task", taskID, "err", err)
+		return nil, &api.Error{Status: http.StatusInternalServerError, Err: err}
+	}
+	log.Info(ctx, "updated", "task", taskID)
+	return TaskInfoResponse(taskInfo), nil
+}
+
+func (s *ShimServer) TaskReplaceHandler(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	ctx := r.Context()
+	taskID := r.PathValue("id")
+	var req TaskSubmitRequest
+	if err := api.DecodeJSONBody(w, r, &req, true); err != nil {
+		return nil, err
+	}
+	if req.ID == "" {
+		req.ID = taskID
+	}
+	if req.Name == "" {
+		return nil, &api.Error{Status: http.StatusBadRequest, Msg: "empty name"}
+	}
+	if req.ImageName == "" {
+		return nil, &api.Error{Status: http.StatusBadRequest, Msg: "empty image_name"}
+	}
+	if req.ContainerUser == "" {
+		req.ContainerUser = "root"
+	}
+	if req.NetworkMode == "" {
+		req.NetworkMode = shim.NetworkModeHost
+	}
+	taskInfo, err := s.runner.Replace(ctx, taskID, shim.TaskConfig(req))
+	if err != nil {
+		if errors.Is(err, shim.ErrInvalidConfig) {
+			log.Info(ctx, "invalid config", "task", taskID, "err", err)
+			return nil, &api.Error{Status: http.StatusBadRequest, Err: err}
+		}
+		if errors.Is(err, shim.ErrNotFound) {
+			log.Info(ctx, "not found", "task", taskID, "err", err)
+			return nil, &api.Error{Status: http.StatusNotFound, Err: err}
+		}
+		if errors.Is(err, shim.ErrRequest) {
+			log.Info(ctx, "cannot replace", "task", taskID, "err", err)
+			return nil, &api.Error{Status: http.StatusConflict, Err: err}
+		}
+		log.Error(ctx, "failed to replace", "task", taskID, "err", err)
+		return nil, &api.Error{Status: http.StatusInternalServerError, Err: err}
+	}
+	log.Info(ctx, "replaced", "task", taskID)
+	return TaskInfoResponse(taskInfo), nil
+}
+
+// getJSONFieldNames returns JSON names of the struct fields
+// getChangedConfigField returns the first of the given fields whose value differs from the config,
+// or an empty string if none. Empty and nil slices and maps are equal, as both are sent as empty
+func getChangedConfigField(cfg shim.TaskConfig, fields map[string]json.RawMessage, names []string) (string, error) {
+	current := reflect.ValueOf(cfg)
+	for i := 0; i < current.NumField(); i++ {
+		field := current.Type().Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !slices.Contains(names, name) {
+			continue
+		}
+		value := reflect.New(field.Type)
+		if err := json.Unmarshal(fields[name], value.Interface()); err != nil {
+			return "", fmt.Errorf("%s: %w", name, err)
+		}
+		if !isEqualConfigValue(current.Field(i), value.Elem()) {
+			return name, nil
+		}
+	}
+	return "", nil
+}
+
+func isEqualConfigValue(a reflect.Value, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.Slice, reflect.Map:
+		if a.Len() == 0 && b.Len() == 0 {
+			return true
+		}
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
+
+func getJSONFieldNames(t reflect.Type) []string {
+	names := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ","); name != "" && name != "-" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// TaskWaitHandler long-polls until the task is terminated or the `timeout` (seconds) expires,
+// and returns the task info either way, the client must check the status
+func (s *ShimServer) TaskWaitHandler(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	ctx := r.Context()
+	taskID := r.PathValue("id")
+	timeout := defaultWaitTimeout
+	if value := r.URL.Query().Get("timeout"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 || time.Duration(seconds)*time.Second > maxWaitTimeout {
+			return nil, &api.Error{
+				Status: http.StatusBadRequest,
+				Msg:    fmt.Sprintf("timeout must be an integer in 0..%d range", int(maxWaitTimeout.Seconds())),
+			}
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+	taskInfo, err := s.runner.Wait(ctx, taskID, timeout)
+	if err != nil {
+		if errors.Is(err, shim.ErrNotFound) {
+			log.Info(ctx, "not found", "task", taskID, "err", err)
+			return nil, &api.Error{Status: http.StatusNotFound, Err: err}
+		}
+		log.Error(ctx, "failed to wait", "task", taskID, "err", err)
+		return nil, &api.Error{Status: http.StatusInternalServerError, Err: err}
+	}
+	return TaskInfoResponse(taskInfo), nil
+}
+
+// TaskLogSearchHandler returns lines of the task container logs matching the `q` substring
+// (or the regex if `regex` is true) with `context` lines around each match, paginated
+// by the `limit` and `cursor` parameters
+func (s *ShimServer) TaskLogSearchHandler(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	ctx := r.Context()
+	taskID := r.PathValue("id")
+	query := r.URL.Query()
+	limit, afterLine, err := parsePageParams(query)
+	if err != nil {
+		return nil, err
+	}
+	searchQuery := shim.LogSearchQuery{Pattern: query.Get("q"), Limit: limit}
+	if afterLine != "" {
+		if searchQuery.AfterLine, err = strconv.Atoi(afterLine); err != nil {
+			return nil, &api.Error{Status: http.StatusBadRequest, Msg: "invalid cursor"}
+		}
+	}
+	if value := query.Get("regex"); value != "" {
+		if searchQuery.Regex, err = strconv.ParseBool(value); err != nil {
+			return nil, &api.Error{Status: http.StatusBadRequest, Msg: "regex must be a boolean"}
+		}
+	}
+	if value := query.Get("context"); value != "" {
+		if searchQuery.Context, err = strconv.Atoi(value); err != nil {
+			return nil, &api.Error{Status: http.StatusBadRequest, Msg: "context must be an integer"}
+		}
+	}
+	result, err := s.runner.SearchLogs(ctx, taskID, searchQuery)
+	if err != nil {
+		if errors.Is(err, shim.ErrInvalidConfig) {
+			return nil, &api.Error{Status: http.StatusBadRequest, Err: err}
+		}
+		if errors.Is(err, shim.ErrNotFound) {
+			log.Info(ctx, "not found", "task", taskID, "err", err)
+			return nil, &api.Error{Status: http.StatusNotFound, Err: err}
+		}
+		if errors.Is(err, shim.ErrRequest) {
+			log.Info(ctx, "cannot search logs", "task", taskID, "err", err)
+			return nil, &api.Error{Status: http.StatusConflict, Err: err}
+		}
+		log.Error(ctx, "failed to search logs", "task", taskID, "err", err)
+		return nil, &api.Error{Status: http.StatusInternalServerError, Err: err}
+	}
+	response := &TaskLogSearchResponse{Matches: result.Matches}
+	if result.NextAfterLine > 0 {
+		response.NextCursor = encodeCursor(strconv.Itoa(result.NextAfterLine))
+	}
+	return response, nil
+}
+
+// TaskDiffHandler lists changes of the task container filesystem, paginated by path
+func (s *ShimServer) TaskDiffHandler(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	ctx := r.Context()
+	taskID := r.PathValue("id")
+	limit, afterPath, err := parsePageParams(r.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+	changes, err := s.runner.TaskDiff(ctx, taskID)
+	if err != nil {
+		if errors.Is(err, shim.ErrNotFound) {
+			log.Info(ctx, "not found", "task", taskID, "err", err)
+			return nil, &api.Error{Status: http.StatusNotFound, Err: err}
+		}
+		if errors.Is(err, shim.ErrRequest) {
+			log.Info(ctx, "cannot get container changes", "task", taskID, "err", err)
+			return nil, &api.Error{Status: http.StatusConflict, Err: err}
+		}
+		log.Error(ctx, "failed to get container changes", "task", taskID, "err", err)
+		return nil, &api.Error{Status: http.StatusInternalServerError, Err: err}
+	}
+	page, nextCursor := paginate(changes, func(change shim.FilesystemChange) string { return change.Path }, afterPath, limit)
+	return &TaskDiffResponse{Changes: page, NextCursor: nextCursor}, nil
+}
+
+// TaskExecHandler runs a one-off command in the running task container and responds
+// with its output and exit code once it exits or times out
+func (s *ShimServer) TaskExecHandler(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	ctx := r.Context()
+	taskID := r.PathValue("id")
+	var req TaskExecRequest
+	if err := api.DecodeJSONBody(w, r, &req, true); err != nil {
+		return nil, err
+	}
+	if len(req.Command) == 0 {
+		return nil, &api.Error{Status: http.StatusBadRequest, Msg: "empty command"}
+	}
+	timeout := time.Duration(req.Timeout) * time.Second
+	if timeout > shim.MaxExecTimeout {
+		return nil, &api.Error{Status: http.StatusBadRequest, Msg: fmt.Sprintf("timeout must not exceed %d seconds", int(shim.MaxExecTimeout.Seconds()))}
+	}
+	result, err := s.runner.Exec(ctx, taskID, shim.ExecRequest{
+		Command:    req.Command,
+		WorkingDir: req.WorkingDir,
+		User:       req.User,
+		Timeout:    timeout,
+	})
+	if err != nil {
+		if errors.Is(err, shim.ErrNotFound) {
+			log.Info(ctx, "not found", "task", taskID, "err", err)
+			return nil, &api.Error{Status: http.StatusNotFound, Err: err}
+		}
+		if errors.Is(err, shim.ErrRequest) {
+			log.Info(ctx, "cannot exec", "task", taskID, "err", err)
+			return nil, &api.Error{Status: http.StatusConflict, Err: err}
+		}
+		log.Error(ctx, "failed to exec", "task", taskID, "err", err)
+		return nil, &api.Error{Status: http.StatusInternalServerError, Err: err}
+	}
+	log.Info(ctx, "exec finished", "task", taskID, "exit_code", result.ExitCode, "timed_out", result.TimedOut)
+	return TaskExecResponse(result), nil
+}
+
+// TaskFilesHandler streams a tar archive of the file or directory at the `path`
+// inside the task container. Unlike other handlers, it writes the response directly
+func (s *ShimServer) TaskFilesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	taskID := r.PathValue("id")
+	path := r.URL.Query().Get("path")
+	reader, err := s.runner.TaskFiles(ctx, taskID, path)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, shim.ErrInvalidConfig):
+			status = http.StatusBadRequest
+		case errors.Is(err, shim.ErrNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, shim.ErrRequest):
+			status = http.StatusConflict
+		}
+		log.Info(ctx, "failed to get task files", "task", taskID, "path", path, "status", status, "err", err)
+		http.Error(w, err.Error(), status)
+		return
+	}
+	defer reader.Close()
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, reader); err != nil {
+		log.Error(ctx, "failed to stream task files", "task", taskID, "path", path, "err", err)
+	}
+}
+
+// TaskAttachHandler attaches to the task container's stdio, the protocol is the same as
+// of Docker's /containers/{id}/attach: the connection is hijacked and upgraded to a raw
+// TCP stream, the output is multiplexed (see stdcopy) unless the container has TTY
+func (s *ShimServer) TaskAttachHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	taskID := r.PathValue("id")
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "connection cannot be hijacked", http.StatusInternalServerError)
+		return
+	}
+	stream, err := s.runner.Attach(ctx, taskID)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, shim.ErrNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, shim.ErrRequest):
+			status = http.StatusConflict
+		}
+		log.Info(ctx, "failed to attach", "task", taskID, "status", status, "err", err)
+		http.Error(w, err.Error(), status)
+		return
+	}
+	defer stream.Close()
+	conn, bufrw, err := hijacker.Hijack()
+	if err != nil {
+		log.Error(ctx, "failed to hijack connection", "task", taskID, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+	mediaType := "application/vnd.docker.multiplexed-stream"
+	if stream.TTY {
+		mediaType = "application/vnd.docker.raw-stream"
+	}
+	if _, err := fmt.Fprintf(conn, "HTTP/1.1 101 UPGRADED\r\nContent-Type: %s\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n", mediaType); err != nil {
+		log.Error(ctx, "failed to upgrade connection", "task", taskID, "err", err)
+		return
+	}
+	log.Info(ctx, "attached", "task", taskID)
+	// bufrw may have buffered some input already
+	if err := stream.Pipe(ctx, bufrw, conn, nil); err != nil {
+		log.Info(ctx, "attach stream closed", "task", taskID, "err", err)
+	}
+	log.Info(ctx, "detached", "task", taskID)
+}
+
+// RunLogsHandler streams merged logs of all containers of the run as newline-delimited JSON
+// objects ordered by the capture time. With `follow=true`, the stream continues until all tasks
+// of the run are finished. Unlike other handlers, it writes the response directly
+func (s *ShimServer) RunLogsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	runID := r.PathValue("id")
+	var follow bool
+	if value := r.URL.Query().Get("follow"); value != "" {
+		var err error
+		if follow, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, "follow must be a boolean", http.StatusBadRequest)
+			return
+		}
+	}
+	stream, err := s.runner.RunLogs(ctx, runID, follow)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, shim.ErrInvalidConfig):
+			status = http.StatusBadRequest
+		case errors.Is(err, shim.ErrNotFound):
+			status = http.StatusNotFound
+		}
+		log.Info(ctx, "failed to get run logs", "run", runID, "status", status, "err", err)
+		http.Error(w, err.Error(), status)
+		return
+	}
+	defer stream.Close()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	// In follow mode, the first line may take a while, the client gets the status right away
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	encoder := json.NewEncoder(w)
+	for {
+		line, err := stream.Next()
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if err != nil {
+			log.Info(ctx, "run logs stream closed", "run", runID, "err", err)
+			return
+		}
+		if err := encoder.Encode(line); err != nil {
+			log.Info(ctx, "failed to write run logs", "run", runID, "err", err)
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
@@ -2,11 +2,19 @@ package api
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	common "github.com/dstackai/dstack/runner/internal/api"
+	"github.com/dstackai/dstack/runner/internal/shim"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestHealthcheck(t *testing.T) {
@@ -53,3 +61,470 @@ func TestTaskSubmit(t *testing.T) {
 		t.Errorf("Want status '%d', got '%d'", 409, responseRecorder.Code)
 	}
 }
+
+func TestTaskList_Pagination(t *testing.T) {
+	runner := NewDummyRunner()
+	expected := []string{}
+	for i := 0; i < 10; i++ {
+		id := fmt.Sprintf("task-%02d", i)
+		require.NoError(t, runner.Submit(context.Background(), shim.TaskConfig{ID: id}))
+		expected = append(expected, id)
+	}
+	server := NewShimServer(context.Background(), ":12341", runner, "0.0.1.dev2")
+
+	ids := []string{}
+	cursor := ""
+	for pages := 1; ; pages++ {
+		resp := getTaskList(t, server, url.Values{"limit": {"3"}, "cursor": {cursor}})
+		assert.LessOrEqual(t, len(resp.IDs), 3)
+		ids = append(ids, resp.IDs...)
+		if resp.NextCursor == "" {
+			assert.Equal(t, 4, pages)
+			break
+		}
+		// tasks submitted while paging are not lost, as long as they sort after the cursor
+		if pages == 1 {
+			require.NoError(t, runner.Submit(context.Background(), shim.TaskConfig{ID: "task-99"}))
+			expected = append(expected, "task-99")
+		}
+		cursor = resp.NextCursor
+	}
+	assert.Equal(t, expected, ids)
+}
+
+func TestTaskList_NoLimit(t *testing.T) {
+	runner := NewDummyRunner()
+	for _, id := range []string{"c", "a", "b"} {
+		require.NoError(t, runner.Submit(context.Background(), shim.TaskConfig{ID: id}))
+	}
+	server := NewShimServer(context.Background(), ":12342", runner, "0.0.1.dev2")
+
+	resp := getTaskList(t, server, url.Values{})
+	assert.Equal(t, []string{"a", "b", "c"}, resp.IDs)
+	assert.Equal(t, "", resp.NextCursor)
+
+	// the cursor of the removed task is still valid
+	resp = getTaskList(t, server, url.Values{"cursor": {encodeCursor("aa")}})
+	assert.Equal(t, []string{"b", "c"}, resp.IDs)
+}
+
+func TestTaskList_InvalidParams(t *testing.T) {
+	server := NewShimServer(context.Background(), ":12343", NewDummyRunner(), "0.0.1.dev2")
+	for _, query := range []string{"limit=0", "limit=-1", "limit=ten", "cursor=%25%25", "cursor=="} {
+		request := httptest.NewRequest("GET", "/api/tasks?"+query, nil)
+		responseRecorder := httptest.NewRecorder()
+		common.JSONResponseHandler(server.TaskListHandler)(responseRecorder, request)
+		assert.Equal(t, 400, responseRecorder.Code, query)
+	}
+}
+
+func TestTaskLogSearch_InvalidParams(t *testing.T) {
+	server := NewShimServer(context.Background(), ":12344", NewDummyRunner(), "0.0.1.dev2")
+	for _, query := range []string{"q=x&regex=maybe", "q=x&context=two", "q=x&limit=0", "q=x&cursor=" + encodeCursor("abc")} {
+		request := httptest.NewRequest("GET", "/api/tasks/dummy-id/logs/search?"+query, nil)
+		responseRecorder := httptest.NewRecorder()
+		common.JSONResponseHandler(server.TaskLogSearchHandler)(responseRecorder, request)
+		assert.Equal(t, 400, responseRecorder.Code, query)
+	}
+}
+
+func TestTaskWait_InvalidParams(t *testing.T) {
+	server := NewShimServer(context.Background(), ":12346", NewDummyRunner(), "0.0.1.dev2")
+	for _, query := range []string{"timeout=-1", "timeout=1.5", "timeout=1m", "timeout=601"} {
+		request := httptest.NewRequest("GET", "/api/tasks/dummy-id/wait?"+query, nil)
+		request.SetPathValue("id", "dummy-id")
+		responseRecorder := httptest.NewRecorder()
+		common.JSONResponseHandler(server.TaskWaitHandler)(responseRecorder, request)
+		assert.Equal(t, 400, responseRecorder.Code, query)
+	}
+}
+
+func TestTaskWait_NotFound(t *testing.T) {
+	server := NewShimServer(context.Background(), ":12347", NewDummyRunner(), "0.0.1.dev2")
+	request := httptest.NewRequest("GET", "/api/tasks/dummy-id/wait?timeout=1", nil)
+	request.SetPathValue("id", "dummy-id")
+	responseRecorder := httptest.NewRecorder()
+	common.JSONResponseHandler(server.TaskWaitHandler)(responseRecorder, request)
+	assert.Equal(t, 404, responseRecorder.Code)
+}
+
+func TestRunLogs_Errors(t *testing.T) {
+	server := NewShimServer(context.Background(), ":12352", NewDummyRunner(), "0.0.1.dev2")
+	for query, status := range map[string]int{"follow=maybe": 400, "follow=true": 404, "": 404} {
+		request := httptest.NewRequest("GET", "/api/runs/dummy-run/logs?"+query, nil)
+		request.SetPathValue("id", "dummy-run")
+		responseRecorder := httptest.NewRecorder()
+		server.RunLogsHandler(responseRecorder, request)
+		assert.Equal(t, status, responseRecorder.Code, query)
+	}
+}
+
+func TestTaskUpdate_Fields(t *testing.T) {
+	runner := NewDummyRunner()
+	server := NewShimServer(context.Background(), ":12348", runner, "0.0.1.dev2")
+	require.NoError(t, runner.Submit(context.Background(), shim.TaskConfig{
+		ID: "dummy-id", ImageName: "ubuntu:22.04", GPU: 1, Env: map[string]string{"K": "V"},
+	}))
+	testCases := []struct {
+		taskID string
+		body   string
+		status int
+	}{
+		// mutable fields reach the runner, DummyRunner.Update() returns not found
+		{"dummy-id", `{"annotations": {"k": "v"}, "lease_duration": 60}`, 404},
+		{"dummy-id", `{}`, 404},
+		{"dummy-id", `{"image_name": "ubuntu:24.04"}`, 409},
+		{"dummy-id", `{"annotations": {}, "gpu": 2}`, 409},
+		{"dummy-id", `{"env": {"K": "W"}}`, 409},
+		{"dummy-id", `{"volume_mounts": [{"name": "data", "path": "/data"}]}`, 409},
+		// unchanged immutable fields are accepted
+		{"dummy-id", `{"image_name": "ubuntu:22.04", "gpu": 1, "env": {"K": "V"}, "lease_duration": 60}`, 404},
+		{"dummy-id", `{"volume_mounts": [], "labels": null}`, 404},
+		{"unknown-id", `{"image_name": "ubuntu:24.04"}`, 404},
+		{"dummy-id", `{"image_name": 1}`, 400},
+		{"dummy-id", `{"callback_url": "http://example.com"}`, 400},
+		{"dummy-id", `{"lease_duration": -1}`, 400},
+		{"dummy-id", `{"annotations": ["k"]}`, 400},
+		{"dummy-id", `[]`, 400},
+	}
+	for _, tc := range testCases {
+		request := httptest.NewRequest("PATCH", "/api/tasks/"+tc.taskID, strings.NewReader(tc.body))
+		request.SetPathValue("id", tc.taskID)
+		responseRecorder := httptest.NewRecorder()
+		common.JSONResponseHandler(server.TaskUpdateHandler)(responseRecorder, request)
+		assert.Equal(t, tc.status, responseRecorder.Code, tc.body)
+	}
+}
+
+func getTaskList(t *testing.T, server *ShimServer, query url.Values) TaskListResponse {
+	t.Helper()
+	request := httptest.NewRequest("GET", "/api/tasks?"+query.Encode(), nil)
+	responseRecorder := httptest.NewRecorder()
+	common.JSONResponseHandler(server.TaskListHandler)(responseRecorder, request)
+	require.Equal(t, 200, responseRecorder.Code, responseRecorder.Body.String())
+	var resp TaskListResponse
+	require.NoError(t, json.Unmarshal(responseRecorder.Body.Bytes(), &resp))
+	return resp
+}
+
+func TestGpuReservation_Conflict(t *testing.T) {
+	server := NewShimServer(context.Background(), ":12349", NewDummyRunner(), "0.0.1.dev2")
+	request := httptest.NewRequest("POST", "/api/gpu_reservations", strings.NewReader(`{"gpu_ids": ["GPU-beef"], "ttl": 60}`))
+	responseRecorder := httptest.NewRecorder()
+	common.JSONResponseHandler(server.GpuReservationHandler)(responseRecorder, request)
+	assert.Equal(t, 409, responseRecorder.Code)
+}
+
+func TestTaskReplace_NotFound(t *testing.T) {
+	server := NewShimServer(context.Background(), ":12350", NewDummyRunner(), "0.0.1.dev2")
+	testCases := []struct {
+		body   string
+		status int
+	}{
+		{`{"name": "job", "image_name": "ubuntu:24.04"}`, 404},
+		{`{"image_name": "ubuntu:24.04"}`, 400},
+		{`{"name": "job"}`, 400},
+	}
+	for _, tc := range testCases {
+		request := httptest.NewRequest("POST", "/api/tasks/dummy-id/replace", strings.NewReader(tc.body))
+		request.SetPathValue("id", "dummy-id")
+		responseRecorder := httptest.NewRecorder()
+		common.JSONResponseHandler(server.TaskReplaceHandler)(responseRecorder, request)
+		assert.Equal(t, tc.status, responseRecorder.Code, tc.body)
+	}
+}
+
+func TestAuditActorHandler(t *testing.T) {
+	var actor string
+	handler := auditActorHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor = shim.GetAuditActor(r.Context())
+	}))
+
+	request := httptest.NewRequest("POST", "/api/tasks", nil)
+	request.SetBasicAuth("alice", "secret")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+	assert.Equal(t, "alice", actor)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/tasks", nil))
+	assert.Equal(t, "", actor)
+}
+
+func TestTaskStop(t *testing.T) {
+	server := NewShimServer(context.Background(), ":12351", NewDummyRunner(), "0.0.1.dev2")
+	testCases := []struct {
+		body   string
+		status int
+	}{
+		{`{"ids": ["task-1", "task-2"]}`, 200},
+		{`{"all": true, "termination_reason": "HOST_DRAINING"}`, 200},
+		{`{}`, 400},
+		{`{"ids": ["task-1"], "all": true}`, 400},
+		{`{"ids": "all"}`, 400},
+	}
+	for _, tc := range testCases {
+		request := httptest.NewRequest("POST", "/api/tasks/stop", strings.NewReader(tc.body))
+		responseRecorder := httptest.NewRecorder()
+		common.JSONResponseHandler(server.TaskStopHandler)(responseRecorder, request)
+		assert.Equal(t, tc.status, responseRecorder.Code, tc.body)
+	}
+
+	request := httptest.NewRequest("POST", "/api/tasks/stop", strings.NewReader(`{"ids": ["task-1"]}`))
+	responseRecorder := httptest.NewRecorder()
+	common.JSONResponseHandler(server.TaskStopHandler)(responseRecorder, request)
+	assert.JSONEq(t, `{"results": [{"id": "task-1", "status": "stopped"}]}`, responseRecorder.Body.String())
+}
+
+func TestTaskDiff_Pagination(t *testing.T) {
+	runner := NewDummyRunner()
+	require.NoError(t, runner.Submit(context.Background(), shim.TaskConfig{ID: "dummy-id"}))
+	runner.changes = []shim.FilesystemChange{
+		{Path: "/etc", Kind: shim.FilesystemChangeModified},
+		{Path: "/etc/hosts", Kind: shim.FilesystemChangeModified},
+		{Path: "/tmp/out.bin", Kind: shim.FilesystemChangeAdded},
+		{Path: "/var/cache", Kind: shim.FilesystemChangeDeleted},
+	}
+	server := NewShimServer(context.Background(), ":12353", runner, "0.0.1.dev2")
+
+	var changes []shim.FilesystemChange
+	cursor := ""
+	for pages := 1; ; pages++ {
+		request := httptest.NewRequest("GET", "/api/tasks/dummy-id/diff?"+url.Values{"limit": {"3"}, "cursor": {cursor}}.Encode(), nil)
+		request.SetPathValue("id", "dummy-id")
+		responseRecorder := httptest.NewRecorder()
+		common.JSONResponseHandler(server.TaskDiffHandler)(responseRecorder, request)
+		require.Equal(t, 200, responseRecorder.Code, responseRecorder.Body.String())
+		var resp TaskDiffResponse
+		require.NoError(t, json.Unmarshal(responseRecorder.Body.Bytes(), &resp))
+		changes = append(changes, resp.Changes...)
+		if resp.NextCursor == "" {
+			assert.Equal(t, 2, pages)
+			break
+		}
+		cursor = resp.NextCursor
+	}
+	assert.Equal(t, runner.changes, changes)
+}
+
+func TestTaskDiff_Errors(t *testing.T) {
+	server := NewShimServer(context.Background(), ":12354", NewDummyRunner(), "0.0.1.dev2")
+	for query, status := range map[string]int{"limit=0": 400, "cursor=%25%25": 400, "": 404} {
+		request := httptest.NewRequest("GET", "/api/tasks/dummy-id/diff?"+query, nil)
+		request.SetPathValue("id", "dummy-id")
+		responseRecorder := httptest.NewRecorder()
+		common.JSONResponseHandler(server.TaskDiffHandler)(responseRecorder, request)
+		assert.Equal(t, status, responseRecorder.Code, query)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	server := NewShimServer(context.Background(), ":12355", NewDummyRunner(), "0.0.1.dev2")
+	server.SetRateLimit(RateLimitConfig{Rate: 0.5, Burst: 2})
+	now := time.Now()
+	server.limiter.now = func() time.Time { return now }
+	submit := func(id string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"id": "%s", "name": "job", "image_name": "ubuntu"}`, id)
+		responseRecorder := httptest.NewRecorder()
+		server.HttpServer.Handler.ServeHTTP(responseRecorder, httptest.NewRequest("POST", "/api/tasks", strings.NewReader(body)))
+		return responseRecorder
+	}
+
+	assert.Equal(t, 200, submit("task-1").Code)
+	assert.Equal(t, 200, submit("task-2").Code)
+	for _, id := range []string{"task-3", "task-4"} {
+		responseRecorder := submit(id)
+		assert.Equal(t, 429, responseRecorder.Code)
+		assert.Equal(t, "2", responseRecorder.Header().Get("Retry-After"))
+	}
+	// the bucket is shared by mutating endpoints
+	responseRecorder := httptest.NewRecorder()
+	server.HttpServer.Handler.ServeHTTP(responseRecorder, httptest.NewRequest("POST", "/api/tasks/task-1/replace", strings.NewReader(`{"name": "job", "image_name": "ubuntu"}`)))
+	assert.Equal(t, 429, responseRecorder.Code)
+	// read and terminate endpoints are not limited
+	for _, request := range []*http.Request{
+		httptest.NewRequest("GET", "/api/tasks", nil),
+		httptest.NewRequest("GET", "/api/tasks/task-1", nil),
+		httptest.NewRequest("POST", "/api/tasks/task-1/terminate", strings.NewReader(`{}`)),
+	} {
+		responseRecorder := httptest.NewRecorder()
+		server.HttpServer.Handler.ServeHTTP(responseRecorder, request)
+		assert.NotEqual(t, 429, responseRecorder.Code, request.URL.Path)
+	}
+
+	now = now.Add(time.Second)
+	responseRecorder = submit("task-3")
+	assert.Equal(t, 429, responseRecorder.Code)
+	assert.Equal(t, "1", responseRecorder.Header().Get("Retry-After"))
+	now = now.Add(time.Second)
+	assert.Equal(t, 200, submit("task-3").Code)
+	assert.Equal(t, 429, submit("task-4").Code)
+	// refills up to the burst
+	now = now.Add(time.Minute)
+	assert.Equal(t, 200, submit("task-4").Code)
+	assert.Equal(t, 200, submit("task-5").Code)
+	assert.Equal(t, 429, submit("task-6").Code)
+}
+
+func TestRateLimit_Disabled(t *testing.T) {
+	server := NewShimServer(context.Background(), ":12356", NewDummyRunner(), "0.0.1.dev2")
+	server.SetRateLimit(RateLimitConfig{Rate: 0, Burst: 1})
+	assert.Nil(t, server.limiter)
+	for i := range 20 {
+		body := fmt.Sprintf(`{"id": "task-%d", "name": "job", "image_name": "ubuntu"}`, i)
+		responseRecorder := httptest.NewRecorder()
+		server.HttpServer.Handler.ServeHTTP(responseRecorder, httptest.NewRequest("POST", "/api/tasks", strings.NewReader(body)))
+		assert.Equal(t, 200, responseRecorder.Code)
+	}
+}
+
+func TestTaskStop_Selector(t *testing.T) {
+	runner := NewDummyRunner()
+	for id, labels := range map[string]map[string]string{
+		"task-1": {"user": "alice", "project": "main"},
+		"task-2": {"user": "alice", "project": "dev"},
+		"task-3": {"user": "bob", "project": "main"},
+		"task-4": nil,
+	} {
+		require.NoError(t, runner.Submit(context.Background(), shim.TaskConfig{ID: id, Labels: labels}))
+	}
+	server := NewShimServer(context.Background(), ":12357", runner, "0.0.1.dev2")
+	stop := func(selector string, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("POST", "/api/tasks/stop?"+url.Values{"selector": {selector}}.Encode(), strings.NewReader(body))
+		responseRecorder := httptest.NewRecorder()
+		common.JSONResponseHandler(server.TaskStopHandler)(responseRecorder, request)
+		return responseRecorder
+	}
+	stoppedIDs := func(responseRecorder *httptest.ResponseRecorder) []string {
+		require.Equal(t, 200, responseRecorder.Code, responseRecorder.Body.String())
+		var resp TaskStopResponse
+		require.NoError(t, json.Unmarshal(responseRecorder.Body.Bytes(), &resp))
+		ids := []string{}
+		for _, result := range resp.Results {
+			assert.Equal(t, shim.TerminateResultStopped, result.Status)
+			ids = append(ids, result.TaskID)
+		}
+		return ids
+	}
+
+	assert.Equal(t, []string{"task-1", "task-2"}, stoppedIDs(stop("user=alice", `{}`)))
+	assert.Equal(t, []string{"task-1"}, stoppedIDs(stop("user=alice,project=main", `{"termination_reason": "TERMINATED_BY_USER"}`)))
+	assert.Equal(t, []string{}, stoppedIDs(stop("user=carol", `{}`)))
+	// the empty selector with all is the same as all, DummyRunner returns no results then
+	assert.Equal(t, []string{}, stoppedIDs(stop("", `{"all": true}`)))
+
+	testCases := []struct {
+		selector string
+		body     string
+		msg      string
+	}{
+		{"", `{}`, "empty selector matches all tasks, set all to stop them"},
+		{"user=alice", `{"all": true}`, "either all or a non-empty selector must be set, not both"},
+		{"user=alice", `{"ids": ["task-1"]}`, "either ids or selector must be set, not both"},
+		{"user", `{}`, "invalid selector term"},
+		{"user=alice,user=bob", `{}`, "selector key user is repeated"},
+	}
+	for _, tc := range testCases {
+		responseRecorder := stop(tc.selector, tc.body)
+		assert.Equal(t, 400, responseRecorder.Code, tc.selector)
+		assert.Contains(t, responseRecorder.Body.String(), tc.msg, tc.selector)
+	}
+}
+
+func TestTaskExec(t *testing.T) {
+	runner := NewDummyRunner()
+	require.NoError(t, runner.Submit(context.Background(), shim.TaskConfig{ID: "task-1"}))
+	server := NewShimServer(context.Background(), ":12358", runner, "0.0.1.dev2")
+	server.SetExecToken("secret")
+	exec := func(taskID string, body string, setAuth func(r *http.Request)) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("POST", fmt.Sprintf("/api/tasks/%s/exec", taskID), strings.NewReader(body))
+		if setAuth != nil {
+			setAuth(request)
+		}
+		responseRecorder := httptest.NewRecorder()
+		server.HttpServer.Handler.ServeHTTP(responseRecorder, request)
+		return responseRecorder
+	}
+	bearer := func(token string) func(r *http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+
+	responseRecorder := exec("task-1", `{"command": ["nvidia-smi", "-L"], "timeout": 10}`, bearer("secret"))
+	require.Equal(t, 200, responseRecorder.Code, responseRecorder.Body.String())
+	assert.JSONEq(t, `{"output": "nvidia-smi -L", "truncated": false, "exit_code": 0, "timed_out": false}`, responseRecorder.Body.String())
+
+	// the token as the basic auth password
+	responseRecorder = exec("task-1", `{"command": ["false"]}`, func(r *http.Request) { r.SetBasicAuth("alice", "secret") })
+	require.Equal(t, 200, responseRecorder.Code, responseRecorder.Body.String())
+	var resp TaskExecResponse
+	require.NoError(t, json.Unmarshal(responseRecorder.Body.Bytes(), &resp))
+	assert.Equal(t, 1, resp.ExitCode)
+
+	testCases := []struct {
+		name    string
+		taskID  string
+		body    string
+		setAuth func(r *http.Request)
+		status  int
+	}{
+		{"no token", "task-1", `{"command": ["true"]}`, nil, 401},
+		{"wrong token", "task-1", `{"command": ["true"]}`, bearer("guess"), 401},
+		{"wrong basic auth password", "task-1", `{"command": ["true"]}`, func(r *http.Request) { r.SetBasicAuth("alice", "guess") }, 401},
+		{"empty command", "task-1", `{"command": []}`, bearer("secret"), 400},
+		{"timeout too long", "task-1", `{"command": ["true"], "timeout": 3600}`, bearer("secret"), 400},
+		{"unknown task", "task-2", `{"command": ["true"]}`, bearer("secret"), 404},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			responseRecorder := exec(tc.taskID, tc.body, tc.setAuth)
+			assert.Equal(t, tc.status, responseRecorder.Code, responseRecorder.Body.String())
+			if tc.status == 401 {
+				assert.NotEmpty(t, responseRecorder.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestTaskAttach_Authorized(t *testing.T) {
+	runner := NewDummyRunner()
+	require.NoError(t, runner.Submit(context.Background(), shim.TaskConfig{ID: "task-1"}))
+	attach := func(server *ShimServer, setAuth func(r *http.Request)) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("POST", "/api/tasks/task-1/attach", nil)
+		if setAuth != nil {
+			setAuth(request)
+		}
+		responseRecorder := httptest.NewRecorder()
+		server.HttpServer.Handler.ServeHTTP(responseRecorder, request)
+		return responseRecorder
+	}
+	bearer := func(token string) func(r *http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+
+	disabled := NewShimServer(context.Background(), ":12360", runner, "0.0.1.dev2")
+	assert.Equal(t, 403, attach(disabled, bearer("secret")).Code)
+
+	server := NewShimServer(context.Background(), ":12361", runner, "0.0.1.dev2")
+	server.SetExecToken("secret")
+	for name, setAuth := range map[string]func(r *http.Request){
+		"no token":    nil,
+		"wrong token": bearer("guess"),
+	} {
+		responseRecorder := attach(server, setAuth)
+		assert.Equal(t, 401, responseRecorder.Code, name)
+		assert.NotEmpty(t, responseRecorder.Header().Get("WWW-Authenticate"), name)
+	}
+	// passed to the handler, the recorder cannot be hijacked
+	responseRecorder := attach(server, bearer("secret"))
+	assert.Equal(t, 500, responseRecorder.Code)
+	assert.Contains(t, responseRecorder.Body.String(), "hijacked")
+}
+
+func TestTaskExec_Disabled(t *testing.T) {
+	runner := NewDummyRunner()
+	require.NoError(t, runner.Submit(context.Background(), shim.TaskConfig{ID: "task-1"}))
+	server := NewShimServer(context.Background(), ":12359", runner, "0.0.1.dev2")
+	request := httptest.NewRequest("POST", "/api/tasks/task-1/exec", strings.NewReader(`{"command": ["true"]}`))
+	request.Header.Set("Authorization", "Bearer ")
+	responseRecorder := httptest.NewRecorder()
+	server.HttpServer.Handler.ServeHTTP(responseRecorder, request)
+	assert.Equal(t, 403, responseRecorder.Code)
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/dstackai/dstack/runner/internal/api"
+)
+
+// The cursor is the last ID of the previous page, encoded to keep it opaque to clients.
+// Since pages are selected by ID comparison, not by offset, paging is resumable:
+// tasks added or removed between requests don't cause duplicates or gaps
+// among the tasks present during the whole paging
+
+func encodeCursor(lastID string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(lastID))
+}
+
+func decodeCursor(cursor string) (string, error) {
+	lastID, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(lastID) == 0 {
+		return "", errors.New("invalid cursor")
+	}
+	return string(lastID), nil
+}
+
+// parsePageParams parses `limit` and `cursor` query parameters. Zero limit means "no limit"
+func parsePageParams(query url.Values) (limit int, afterID string, err error) {
+	if value := query.Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return 0, "", &api.Error{Status: http.StatusBadRequest, Msg: "limit must be a positive integer"}
+		}
+	}
+	if cursor := query.Get("cursor"); cursor != "" {
+		afterID, err = decodeCursor(cursor)
+		if err != nil {
+			return 0, "", &api.Error{Status: http.StatusBadRequest, Err: err}
+		}
+	}
+	return limit, afterID, nil
+}
+
+// paginateIDs returns up to limit IDs following afterID in ascending order, and the cursor
+// of the next page, if any. ids must be sorted
+func paginateIDs(ids []string, afterID string, limit int) (page []string, nextCursor string) {
+	return paginate(ids, func(id string) string { return id }, afterID, limit)
+}
+
+// paginate is paginateIDs for items identified by key(), items must be sorted by key
+func paginate[T any](items []T, key func(T) string, afterKey string, limit int) (page []T, nextCursor string) {
+	start := 0
+	if afterKey != "" {
+		start = sort.Search(len(items), func(i int) bool { return key(items[i]) > afterKey })
+	}
+	page = items[start:]
+	if limit > 0 && len(page) > limit {
+		page = page[:limit]
+		nextCursor = encodeCursor(key(page[len(page)-1]))
+	}
+	return page, nextCursor
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dstackai/dstack/runner/internal/api"
+)
+
+// Used if RateLimitConfig.Burst is not set
+const defaultRateLimitBurst = 10
+
+// RateLimitConfig limits requests that start containers or change task resources, see
+// ShimServer.SetRateLimit(). Zero Rate disables the limit
+type RateLimitConfig struct {
+	Rate  float64 // requests per second, on average
+	Burst int     // requests that may be made at once, 0 = 10
+}
+
+// rateLimiter is a token bucket shared by all clients of the shim
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+func newRateLimiter(config RateLimitConfig) *rateLimiter {
+	burst := config.Burst
+	if burst <= 0 {
+		burst = defaultRateLimitBurst
+	}
+	return &rateLimiter{
+		rate:   config.Rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		now:    time.Now,
+	}
+}
+
+// allow takes a token if there is one, otherwise it returns how long to wait for the next one
+func (l *rateLimiter) allow() (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if !l.last.IsZero() {
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+// SetRateLimit enables the limit for mutating endpoints that create containers or change
+// task resources. Stop, terminate, remove and renew are not limited, as they release resources
+// or keep leases alive, nor are read endpoints. Must be called before the server is started
+func (s *ShimServer) SetRateLimit(config RateLimitConfig) {
+	if config.Rate <= 0 {
+		s.limiter = nil
+		return
+	}
+	s.limiter = newRateLimiter(config)
+}
+
+// rateLimited responds with 429 and Retry-After (in seconds, rounded up) if the limit is exceeded
+func (s *ShimServer) rateLimited(handler func(http.ResponseWriter, *http.Request) (interface{}, error)) func(http.ResponseWriter, *http.Request) (interface{}, error) {
+	return func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+		if s.limiter == nil {
+			return handler(w, r)
+		}
+		if ok, retryAfter := s.limiter.allow(); !ok {
+			seconds := int(math.Ceil(retryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+			return nil, &api.Error{
+				Status: http.StatusTooManyRequests,
+				Err:    fmt.Errorf("rate limit exceeded: %s %s", r.Method, r.URL.Path),
+				Msg:    "rate limit exceeded",
+			}
+		}
+		return handler(w, r)
+	}
+}
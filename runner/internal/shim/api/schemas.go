@@ -1,14 +1,33 @@
 package api
 
-import "github.com/dstackai/dstack/runner/internal/shim"
+import (
+	"time"
+
+	"github.com/dstackai/dstack/runner/internal/shim"
+)
 
 type HealthcheckResponse struct {
 	Service string `json:"service"`
 	Version string `json:"version"`
 }
 
+type AllocationsResponse = shim.Allocations
+
+type GpuReservationRequest struct {
+	// GPU IDs as reported in AllocationsResponse
+	GpuIDs []string `json:"gpu_ids"`
+	TTL    uint     `json:"ttl"` // seconds
+}
+
+type GpuReservationResponse struct {
+	ReservationID string    `json:"reservation_id"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
 type TaskListResponse struct {
 	IDs []string `json:"ids"`
+	// Empty if there are no more pages
+	NextCursor string `json:"next_cursor"`
 }
 
 type TaskInfoResponse struct {
@@ -18,15 +37,74 @@ type TaskInfoResponse struct {
 	TerminationMessage string             `json:"termination_message"`
 	Ports              []shim.PortMapping `json:"ports"`
 	// The following fields are for debugging only, server doesn't need them
-	ContainerName string   `json:"container_name"`
-	ContainerID   string   `json:"container_id"`
-	GpuIDs        []string `json:"gpus_ids"`
+	ContainerName  string   `json:"container_name"`
+	ContainerID    string   `json:"container_id"`
+	GpuIDs         []string `json:"gpus_ids"`
+	QueuedDuration float64  `json:"queued_duration"` // seconds
+	// Set if the container failed to start or exited right after start
+	Diagnostics *shim.ContainerDiagnostics `json:"diagnostics"`
+	// Set when the container exits
+	ResourceSummary *shim.ResourceSummary `json:"resource_summary"`
+	// Coarse progress for UI, never goes backwards
+	Progress    shim.TaskProgress `json:"progress"`
+	Annotations map[string]string `json:"annotations"`
+	// seconds, 0 = no lease
+	LeaseDuration  uint       `json:"lease_duration"`
+	LeaseExpiresAt *time.Time `json:"lease_expires_at"`
+	// The last lifecycle events, the oldest first
+	Events []shim.TaskHistoryEvent `json:"events"`
+	// Core dumps captured from the container, see TaskConfig.CoreDumps
+	CoreDumps []shim.CoreDump `json:"core_dumps"`
+	// TaskConfig.TraceID, either submitted or generated by the shim
+	TraceID string `json:"trace_id"`
+	// nil if TaskConfig.Credentials is not set
+	Credentials *shim.CredentialsStatus `json:"credentials"`
 }
 
 type TaskSubmitRequest = shim.TaskConfig
 
+type TaskUpdateRequest = shim.TaskUpdate
+
 type TaskTerminateRequest struct {
 	TerminationReason  string `json:"termination_reason"`
 	TerminationMessage string `json:"termination_message"`
-	Timeout            uint   `json:"timeout"`
+	Timeout            *uint  `json:"timeout"` // if not set, TaskConfig.StopTimeout is used
+}
+
+type TaskStopRequest struct {
+	// Either IDs or All must be set
+	IDs                []string `json:"ids"`
+	All                bool     `json:"all"`
+	TerminationReason  string   `json:"termination_reason"`
+	TerminationMessage string   `json:"termination_message"`
+	Timeout            *uint    `json:"timeout"` // if not set, TaskConfig.StopTimeout is used
+}
+
+type TaskStopResponse struct {
+	Results []shim.TerminateResult `json:"results"`
+}
+
+type TaskExecRequest struct {
+	Command    []string `json:"command"`
+	WorkingDir string   `json:"working_dir"`
+	User       string   `json:"user"`
+	Timeout    uint     `json:"timeout"` // seconds; 0 = shim.DefaultExecTimeout
+}
+
+type TaskExecResponse = shim.ExecResult
+
+type TaskRenewResponse struct {
+	LeaseExpiresAt time.Time `json:"lease_expires_at"`
+}
+
+type TaskLogSearchResponse struct {
+	Matches []shim.LogMatch `json:"matches"`
+	// Empty if there are no more pages
+	NextCursor string `json:"next_cursor"`
+}
+
+type TaskDiffResponse struct {
+	Changes []shim.FilesystemChange `json:"changes"`
+	// Empty if there are no more pages
+	NextCursor string `json:"next_cursor"`
 }
@@ -2,23 +2,42 @@ package api
 
 import (
 	"context"
+	"io"
 	"net"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/dstackai/dstack/runner/internal/api"
 	"github.com/dstackai/dstack/runner/internal/shim"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type TaskRunner interface {
 	Submit(context.Context, shim.TaskConfig) error
 	Run(ctx context.Context, taskID string) error
-	Terminate(ctx context.Context, taskID string, timeout uint, reason string, message string) error
+	Terminate(ctx context.Context, taskID string, timeout *uint, reason string, message string) error
+	TerminateBatch(ctx context.Context, taskIDs []string, all bool, timeout *uint, reason string, message string) ([]shim.TerminateResult, error)
 	Remove(ctx context.Context, taskID string) error
+	Renew(ctx context.Context, taskID string) (time.Time, error)
+	Update(ctx context.Context, taskID string, update shim.TaskUpdate) (shim.TaskInfo, error)
+	Replace(ctx context.Context, taskID string, cfg shim.TaskConfig) (shim.TaskInfo, error)
+	Wait(ctx context.Context, taskID string, timeout time.Duration) (shim.TaskInfo, error)
+	SearchLogs(ctx context.Context, taskID string, query shim.LogSearchQuery) (shim.LogSearchResult, error)
+	TaskFiles(ctx context.Context, taskID string, path string) (io.ReadCloser, error)
+	TaskDiff(ctx context.Context, taskID string) ([]shim.FilesystemChange, error)
+	Attach(ctx context.Context, taskID string) (*shim.AttachStream, error)
+	Exec(ctx context.Context, taskID string, req shim.ExecRequest) (shim.ExecResult, error)
+	RunLogs(ctx context.Context, runID string, follow bool) (*shim.RunLogStream, error)
 
 	Resources(context.Context) shim.Resources
-	TaskIDs() []string
+	Allocations(context.Context) shim.Allocations
+	ReserveGpus(ctx context.Context, gpuIDs []string, ttl time.Duration) (string, time.Time, error)
+	Ready(context.Context) error
+	TaskIDs() []string                               // in ascending order
+	SelectTasks(selector shim.TaskSelector) []string // in ascending order
 	TaskInfo(taskID string) shim.TaskInfo
+	TaskConfig(taskID string) (shim.TaskConfig, bool)
 }
 
 type ShimServer struct {
@@ -26,6 +45,10 @@ type ShimServer struct {
 	mu         sync.RWMutex
 
 	runner TaskRunner
+	// nil if requests are not rate limited, see SetRateLimit()
+	limiter *rateLimiter
+	// exec is disabled if empty, see SetExecToken()
+	execToken string
 
 	version string
 }
@@ -35,7 +58,7 @@ func NewShimServer(ctx context.Context, address string, runner TaskRunner, versi
 	s := &ShimServer{
 		HttpServer: &http.Server{
 			Addr:        address,
-			Handler:     r,
+			Handler:     auditActorHandler(r),
 			BaseContext: func(l net.Listener) context.Context { return ctx },
 		},
 
@@ -46,11 +69,39 @@ func NewShimServer(ctx context.Context, address string, runner TaskRunner, versi
 
 	// The healthcheck endpoint should stay backward compatible, as it is used for negotiation
 	r.AddHandler("GET", "/api/healthcheck", s.HealthcheckHandler)
+	r.AddHandler("GET", "/api/allocations", s.AllocationsHandler)
+	r.AddHandler("POST", "/api/gpu_reservations", s.rateLimited(s.GpuReservationHandler))
 	r.AddHandler("GET", "/api/tasks", s.TaskListHandler)
 	r.AddHandler("GET", "/api/tasks/{id}", s.TaskInfoHandler)
-	r.AddHandler("POST", "/api/tasks", s.TaskSubmitHandler)
+	r.AddHandler("PATCH", "/api/tasks/{id}", s.rateLimited(s.TaskUpdateHandler))
+	r.AddHandler("POST", "/api/tasks", s.rateLimited(s.TaskSubmitHandler))
+	r.AddHandler("POST", "/api/tasks/stop", s.TaskStopHandler)
 	r.AddHandler("POST", "/api/tasks/{id}/terminate", s.TaskTerminateHandler)
 	r.AddHandler("POST", "/api/tasks/{id}/remove", s.TaskRemoveHandler)
+	r.AddHandler("POST", "/api/tasks/{id}/renew", s.TaskRenewHandler)
+	r.AddHandler("POST", "/api/tasks/{id}/replace", s.rateLimited(s.TaskReplaceHandler))
+	r.AddHandler("GET", "/api/tasks/{id}/wait", s.TaskWaitHandler)
+	r.AddHandler("GET", "/api/tasks/{id}/logs/search", s.TaskLogSearchHandler)
+	r.AddHandler("GET", "/api/tasks/{id}/diff", s.TaskDiffHandler)
+	r.AddHandler("POST", "/api/tasks/{id}/exec", s.execAuthorized(s.TaskExecHandler))
+	r.HandleFunc("GET /api/tasks/{id}/files", s.TaskFilesHandler)
+	r.HandleFunc("POST /api/tasks/{id}/attach", s.execAuthorizedRaw(s.TaskAttachHandler))
+	r.HandleFunc("GET /api/runs/{id}/logs", s.RunLogsHandler)
+
+	r.AddHandler("GET", "/readyz", s.ReadyzHandler)
+	r.Handle("GET /metrics", promhttp.Handler())
 
 	return s
 }
+
+// auditActorHandler passes the HTTP basic auth username, if any, to the runner as the audit actor.
+// The shim doesn't authenticate requests itself, the actor is recorded as claimed by the client
+// (or set by an authenticating proxy)
+func auditActorHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, _, ok := r.BasicAuth(); ok && username != "" {
+			r = r.WithContext(shim.WithAuditActor(r.Context(), username))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
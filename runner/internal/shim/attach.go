@@ -0,0 +1,125 @@
+package shim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/dstackai/dstack/runner/internal/log"
+)
+
+// AttachStream is a hijacked connection to the container's stdio, see Attach()
+type AttachStream struct {
+	types.HijackedResponse
+	// If true, the output is a raw stream, otherwise stdout and stderr are multiplexed, see stdcopy
+	TTY bool
+	// If false, the container's stdin is closed, the input is discarded
+	Stdin bool
+	// If true, Pipe() prepends the capture time to each output line, see TaskConfig.LogTimestamps
+	Timestamps bool
+	clock      clock
+}
+
+// Attach attaches to the running task container's stdin (if TaskConfig.TTY is set), stdout
+// and stderr, as `docker attach` does. The caller is responsible for closing the stream
+func (d *DockerRunner) Attach(ctx context.Context, taskID string) (*AttachStream, error) {
+	task, ok := d.tasks.Get(taskID)
+	if !ok {
+		return nil, fmt.Errorf("task %s: %w", taskID, ErrNotFound)
+	}
+	if task.Status != TaskStatusRunning {
+		return nil, fmt.Errorf("%w: cannot attach to task %s with %s status", ErrRequest, task.ID, task.Status)
+	}
+	// Restored tasks have no config, the container is the source of truth
+	containerFull, err := d.client.ContainerInspect(ctx, task.containerID)
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return nil, fmt.Errorf("%w: task %s container is gone", ErrRequest, task.ID)
+		}
+		return nil, fmt.Errorf("%w: failed to inspect container: %w", ErrInternal, err)
+	}
+	stream := &AttachStream{
+		TTY:   containerFull.Config.Tty,
+		Stdin: containerFull.Config.OpenStdin,
+		// Restored tasks have no config, their output is passed as is
+		Timestamps: task.config.LogTimestamps,
+		clock:      d.clock,
+	}
+	attachOptions := container.AttachOptions{
+		Stream: true,
+		Stdin:  stream.Stdin,
+		Stdout: true,
+		Stderr: true,
+	}
+	stream.HijackedResponse, err = d.client.ContainerAttach(ctx, task.containerID, attachOptions)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to attach to container: %w", ErrInternal, err)
+	}
+	log.Debug(ctx, "attached to container", "task", task.ID, "tty", stream.TTY, "stdin", stream.Stdin)
+	return stream, nil
+}
+
+// Pipe copies stdin to the container and the container output to stdout and stderr
+// until the output is closed (the container exited) or ctx is done, ctx.Err() is returned
+// in the latter case. The stream is closed on return.
+// If stderr is nil, the output is copied to stdout as is, that is, multiplexed
+// if the container has no TTY. If Timestamps is set, each line of stdout and stderr
+// is prefixed, a multiplexed output is demultiplexed to do so and then multiplexed again
+func (s *AttachStream) Pipe(ctx context.Context, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		// unblocks both copying goroutines
+		<-ctx.Done()
+		s.Close()
+	}()
+
+	if stdin != nil && s.Stdin {
+		go func() {
+			if _, err := io.Copy(s.Conn, stdin); err != nil && !errors.Is(err, net.ErrClosed) {
+				log.Debug(ctx, "failed to copy stdin to container", "err", err)
+			}
+			// let the container see EOF, the output is still being copied
+			_ = s.CloseWrite()
+		}()
+	}
+
+	outputDone := make(chan error, 1)
+	go func() {
+		outputDone <- s.copyOutput(stdout, stderr)
+	}()
+
+	select {
+	case err := <-outputDone:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *AttachStream) copyOutput(stdout io.Writer, stderr io.Writer) error {
+	var err error
+	switch {
+	case !s.Timestamps && (s.TTY || stderr == nil):
+		_, err = io.Copy(stdout, s.Reader)
+	case s.TTY:
+		_, err = io.Copy(newTimestampWriter(stdout, s.clock), s.Reader)
+	case stderr == nil:
+		_, err = stdcopy.StdCopy(
+			newTimestampWriter(stdcopy.NewStdWriter(stdout, stdcopy.Stdout), s.clock),
+			newTimestampWriter(stdcopy.NewStdWriter(stdout, stdcopy.Stderr), s.clock),
+			s.Reader,
+		)
+	case !s.Timestamps:
+		_, err = stdcopy.StdCopy(stdout, stderr, s.Reader)
+	default:
+		_, err = stdcopy.StdCopy(newTimestampWriter(stdout, s.clock), newTimestampWriter(stderr, s.clock), s.Reader)
+	}
+	return err
+}
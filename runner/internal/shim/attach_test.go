@@ -0,0 +1,169 @@
+package shim
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDockerRunner_Attach_TTY(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.TTY = true
+	containerID := runTask(t, runner, cfg)
+	defer client.exitContainer(containerID, 0)
+
+	stream, err := runner.Attach(context.Background(), cfg.ID)
+	require.NoError(t, err)
+	assert.True(t, stream.TTY)
+	assert.True(t, stream.Stdin)
+	ctr, err := client.getContainer(containerID)
+	require.NoError(t, err)
+
+	// the fake container process echoes its stdin and exits
+	go func() {
+		buf := make([]byte, 5)
+		_, _ = io.ReadFull(ctr.attachConn, buf)
+		_, _ = ctr.attachConn.Write(append([]byte("echo: "), buf...))
+		_ = ctr.attachConn.Close()
+	}()
+	var stdout bytes.Buffer
+	err = stream.Pipe(context.Background(), strings.NewReader("hello"), &stdout, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "echo: hello", stdout.String())
+}
+
+func TestDockerRunner_Attach_Multiplexed(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	containerID := runTask(t, runner, cfg)
+	defer client.exitContainer(containerID, 0)
+
+	stream, err := runner.Attach(context.Background(), cfg.ID)
+	require.NoError(t, err)
+	assert.False(t, stream.TTY)
+	assert.False(t, stream.Stdin)
+	ctr, err := client.getContainer(containerID)
+	require.NoError(t, err)
+
+	go func() {
+		_, _ = stdcopy.NewStdWriter(ctr.attachConn, stdcopy.Stdout).Write([]byte("out"))
+		_, _ = stdcopy.NewStdWriter(ctr.attachConn, stdcopy.Stderr).Write([]byte("err"))
+		_ = ctr.attachConn.Close()
+	}()
+	var stdout, stderr bytes.Buffer
+	err = stream.Pipe(context.Background(), nil, &stdout, &stderr)
+	assert.NoError(t, err)
+	assert.Equal(t, "out", stdout.String())
+	assert.Equal(t, "err", stderr.String())
+}
+
+func TestDockerRunner_Attach_Timestamps(t *testing.T) {
+	testCases := []struct {
+		name     string
+		tty      bool
+		demux    bool
+		expected string
+	}{
+		{"tty", true, false, "2024-01-01T00:00:00.000000000Z out\n2024-01-01T00:00:00.000000000Z err\n2024-01-01T00:00:00.000000000Z partial"},
+		{"demultiplexed", false, true, "2024-01-01T00:00:00.000000000Z out\n2024-01-01T00:00:00.000000000Z partial"},
+		{"multiplexed", false, false, "2024-01-01T00:00:00.000000000Z out\n2024-01-01T00:00:00.000000000Z partial"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newFakeDockerClient()
+			runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+			runner.clock = newFakeClock()
+			cfg := createTaskConfig(t)
+			cfg.TTY = tc.tty
+			cfg.LogTimestamps = true
+			containerID := runTask(t, runner, cfg)
+			defer client.exitContainer(containerID, 0)
+
+			stream, err := runner.Attach(context.Background(), cfg.ID)
+			require.NoError(t, err)
+			assert.True(t, stream.Timestamps)
+			ctr, err := client.getContainer(containerID)
+			require.NoError(t, err)
+
+			go func() {
+				if tc.tty {
+					_, _ = ctr.attachConn.Write([]byte("out\nerr\npartial"))
+				} else {
+					_, _ = stdcopy.NewStdWriter(ctr.attachConn, stdcopy.Stdout).Write([]byte("out\npartial"))
+					_, _ = stdcopy.NewStdWriter(ctr.attachConn, stdcopy.Stderr).Write([]byte("err\n"))
+				}
+				_ = ctr.attachConn.Close()
+			}()
+			var stdout, stderr bytes.Buffer
+			if tc.tty {
+				require.NoError(t, stream.Pipe(context.Background(), nil, &stdout, nil))
+				assert.Equal(t, tc.expected, stdout.String())
+			} else if tc.demux {
+				require.NoError(t, stream.Pipe(context.Background(), nil, &stdout, &stderr))
+				assert.Equal(t, tc.expected, stdout.String())
+				assert.Equal(t, "2024-01-01T00:00:00.000000000Z err\n", stderr.String())
+			} else {
+				// stderr == nil, the output is multiplexed again
+				require.NoError(t, stream.Pipe(context.Background(), nil, &stdout, nil))
+				var demuxedStdout, demuxedStderr bytes.Buffer
+				_, err := stdcopy.StdCopy(&demuxedStdout, &demuxedStderr, &stdout)
+				require.NoError(t, err)
+				assert.Equal(t, tc.expected, demuxedStdout.String())
+				assert.Equal(t, "2024-01-01T00:00:00.000000000Z err\n", demuxedStderr.String())
+			}
+		})
+	}
+}
+
+func TestDockerRunner_Attach_ContextCanceled(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.TTY = true
+	containerID := runTask(t, runner, cfg)
+	defer client.exitContainer(containerID, 0)
+
+	stream, err := runner.Attach(context.Background(), cfg.ID)
+	require.NoError(t, err)
+	ctr, err := client.getContainer(containerID)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stdinReader, stdinWriter := io.Pipe()
+	defer stdinWriter.Close()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// the container side sees the connection closed
+		_, err := io.ReadAll(ctr.attachConn)
+		assert.NoError(t, err)
+	}()
+	pipeErr := make(chan error)
+	go func() { pipeErr <- stream.Pipe(ctx, stdinReader, io.Discard, nil) }()
+	cancel()
+	assert.ErrorIs(t, <-pipeErr, context.Canceled)
+	wg.Wait()
+}
+
+func TestDockerRunner_Attach_NotRunning(t *testing.T) {
+	runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	require.NoError(t, runner.Submit(context.Background(), cfg))
+
+	_, err := runner.Attach(context.Background(), cfg.ID)
+	assert.ErrorIs(t, err, ErrRequest)
+
+	_, err = runner.Attach(context.Background(), "unknown")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -0,0 +1,220 @@
+package shim
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dstackai/dstack/runner/internal/log"
+)
+
+const (
+	redactedAuditValue = "<redacted>"
+	auditLogFileMode   = 0o600
+)
+
+// AuditLogConfig configures the task audit log, see auditLog. Empty Path disables the log
+type AuditLogConfig struct {
+	// JSON lines file, created if missing, appended otherwise
+	Path string
+	// The file is rotated once it exceeds this size, 0 = never rotated
+	MaxSizeMiB int
+	// The number of rotated files kept as <Path>.1 (the newest) ... <Path>.<MaxBackups>,
+	// 0 = rotated files are removed
+	MaxBackups int
+}
+
+type AuditAction string
+
+const (
+	AuditActionSubmit  AuditAction = "submit"
+	AuditActionStart   AuditAction = "start"
+	AuditActionStop    AuditAction = "stop"
+	AuditActionRemove  AuditAction = "remove"
+	AuditActionUpdate  AuditAction = "update"
+	AuditActionReplace AuditAction = "replace"
+	// The task is recreated after the host reboot, see TaskConfig.RestartOnReboot
+	AuditActionRecover AuditAction = "recover"
+	// A one-off command is run in the task container, see Exec()
+	AuditActionExec AuditAction = "exec"
+)
+
+// AuditRecord is a single line of the audit log. Only successful actions are recorded
+type AuditRecord struct {
+	Time   time.Time   `json:"time"`
+	Action AuditAction `json:"action"`
+	TaskID string      `json:"task_id"`
+	// TaskConfig.TraceID, empty for tasks restored from containers created before trace IDs
+	TraceID string `json:"trace_id,omitempty"`
+	// Empty for actions performed by the shim itself, e.g., starting a task
+	// or terminating it on lease expiration
+	Actor       string      `json:"actor,omitempty"`
+	ContainerID string      `json:"container_id,omitempty"`
+	Reason      string      `json:"reason,omitempty"`
+	Config      *TaskConfig `json:"config,omitempty"` // redacted, see redactTaskConfig()
+	Update      *TaskUpdate `json:"update,omitempty"`
+	// Set for exec actions
+	Command []string `json:"command,omitempty"`
+}
+
+type auditActorKey struct{}
+
+// WithAuditActor sets the actor recorded for actions performed with the returned context
+func WithAuditActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, auditActorKey{}, actor)
+}
+
+// GetAuditActor returns the actor set by WithAuditActor(), empty if not set
+func GetAuditActor(ctx context.Context) string {
+	actor, _ := ctx.Value(auditActorKey{}).(string)
+	return actor
+}
+
+// auditLog appends records to a file, each record is synced to disk before Record() returns.
+// Failures are logged but do not fail the audited action.
+// nil *auditLog is valid and discards records
+type auditLog struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	clock      clock
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func newAuditLog(cfg AuditLogConfig, clock clock) (*auditLog, error) {
+	if cfg.Path == "" {
+		return nil, nil
+	}
+	if cfg.MaxSizeMiB < 0 || cfg.MaxBackups < 0 {
+		return nil, fmt.Errorf("%w: audit log max size and max backups must be non-negative", ErrInvalidConfig)
+	}
+	l := &auditLog{
+		path:       cfg.Path,
+		maxSize:    int64(cfg.MaxSizeMiB) * 1024 * 1024,
+		maxBackups: cfg.MaxBackups,
+		clock:      clock,
+	}
+	if err := l.open(); err != nil {
+		return nil, fmt.Errorf("%w: failed to open audit log: %w", ErrInvalidConfig, err)
+	}
+	return l, nil
+}
+
+func (l *auditLog) open() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, auditLogFileMode)
+	if err != nil {
+		return err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	l.file = file
+	l.size = stat.Size()
+	return nil
+}
+
+// Record fills Time and Actor (from the context) and appends the record
+func (l *auditLog) Record(ctx context.Context, record AuditRecord) {
+	if l == nil {
+		return
+	}
+	record.Time = l.clock.Now().UTC()
+	record.Actor = GetAuditActor(ctx)
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Error(ctx, "failed to marshal audit record", "task", record.TaskID, "err", err)
+		return
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			log.Error(ctx, "failed to rotate audit log", "path", l.path, "err", err)
+		}
+	}
+	if l.file == nil {
+		// the previous rotation has failed to reopen the file
+		if err := l.open(); err != nil {
+			log.Error(ctx, "failed to open audit log", "path", l.path, "err", err)
+			return
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err == nil {
+		err = l.file.Sync()
+	}
+	if err != nil {
+		log.Error(ctx, "failed to write audit record", "task", record.TaskID, "action", record.Action, "err", err)
+	}
+}
+
+// rotate shifts <path>.N files, renames the current file to <path>.1, and opens a new one
+func (l *auditLog) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	l.file = nil
+	if l.maxBackups == 0 {
+		if err := os.Remove(l.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	} else {
+		for i := l.maxBackups - 1; i >= 1; i-- {
+			err := os.Rename(l.backupPath(i), l.backupPath(i+1))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+		if err := os.Rename(l.path, l.backupPath(1)); err != nil {
+			return err
+		}
+	}
+	return l.open()
+}
+
+func (l *auditLog) backupPath(n int) string {
+	return fmt.Sprintf("%s.%d", l.path, n)
+}
+
+// redactTaskConfig returns a copy of the config safe to be written to the audit log:
+// the registry password, env, log option values, and file contents are redacted
+func redactTaskConfig(cfg TaskConfig) *TaskConfig {
+	if cfg.RegistryPassword != "" {
+		cfg.RegistryPassword = redactedAuditValue
+	}
+	cfg.Env = redactMapValues(cfg.Env)
+	cfg.LogOptions = redactMapValues(cfg.LogOptions)
+	if cfg.Files != nil {
+		files := make([]InlineFile, len(cfg.Files))
+		for i, f := range cfg.Files {
+			f.Content = redactedAuditValue
+			files[i] = f
+		}
+		cfg.Files = files
+	}
+	return &cfg
+}
+
+func redactMapValues(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	redacted := maps.Clone(m)
+	for key := range redacted {
+		redacted[key] = redactedAuditValue
+	}
+	return redacted
+}
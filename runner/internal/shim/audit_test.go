@@ -0,0 +1,130 @@
+package shim
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readAuditRecords(t *testing.T, path string) []AuditRecord {
+	t.Helper()
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	var records []AuditRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record AuditRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record), scanner.Text())
+		records = append(records, record)
+	}
+	require.NoError(t, scanner.Err())
+	return records
+}
+
+func TestDockerRunner_Audit_SubmitStop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{auditLog: AuditLogConfig{Path: path}})
+	clock := newFakeClock()
+	runner.audit.clock = clock
+	cfg := createTaskConfig(t)
+	cfg.RegistryUsername = "user"
+	cfg.RegistryPassword = "registry-hunter2"
+	cfg.Env = map[string]string{"API_TOKEN": "env-hunter2"}
+	cfg.Files = []InlineFile{{Path: "/root/.netrc", Content: "file-hunter2", Secret: true}}
+	ctx := WithAuditActor(context.Background(), "alice")
+
+	require.NoError(t, runner.Submit(ctx, cfg))
+	go func() { _ = runner.Run(context.Background(), cfg.ID) }()
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusRunning)
+	containerID := runner.TaskInfo(cfg.ID).ContainerID
+	traceID := runner.TaskInfo(cfg.ID).TraceID
+	require.Eventually(t, func() bool { return len(readAuditRecords(t, path)) == 2 }, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, runner.Terminate(ctx, cfg.ID, nil, "TERMINATED_BY_SERVER", ""))
+	// no-op, not recorded
+	require.NoError(t, runner.Terminate(ctx, cfg.ID, nil, "TERMINATED_BY_SERVER", ""))
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusTerminated)
+	require.NoError(t, runner.Remove(ctx, cfg.ID))
+
+	records := readAuditRecords(t, path)
+	require.Len(t, records, 4)
+	for _, record := range records {
+		assert.Equal(t, cfg.ID, record.TaskID)
+		assert.Equal(t, clock.Now().UTC(), record.Time)
+	}
+	assert.Equal(t, AuditActionSubmit, records[0].Action)
+	assert.Equal(t, "alice", records[0].Actor)
+	require.NotNil(t, records[0].Config)
+	assert.Equal(t, "user", records[0].Config.RegistryUsername)
+	assert.Equal(t, redactedAuditValue, records[0].Config.RegistryPassword)
+	assert.Equal(t, map[string]string{"API_TOKEN": redactedAuditValue}, records[0].Config.Env)
+	assert.Equal(t, "/root/.netrc", records[0].Config.Files[0].Path)
+
+	// started by the shim itself
+	assert.Equal(t, AuditRecord{
+		Time: clock.Now().UTC(), Action: AuditActionStart, TaskID: cfg.ID, TraceID: traceID, ContainerID: containerID,
+	}, records[1])
+	assert.Equal(t, AuditRecord{
+		Time: clock.Now().UTC(), Action: AuditActionStop, TaskID: cfg.ID, TraceID: traceID, Actor: "alice",
+		ContainerID: containerID, Reason: "TERMINATED_BY_SERVER",
+	}, records[2])
+	assert.Equal(t, AuditActionRemove, records[3].Action)
+	assert.Equal(t, "alice", records[3].Actor)
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(content), "hunter2")
+	// the submitted config is not modified
+	assert.Equal(t, "registry-hunter2", cfg.RegistryPassword)
+	assert.Equal(t, "env-hunter2", cfg.Env["API_TOKEN"])
+	assert.Equal(t, "file-hunter2", cfg.Files[0].Content)
+}
+
+func TestAuditLog_Rotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	audit, err := newAuditLog(AuditLogConfig{Path: path, MaxBackups: 2}, newFakeClock())
+	require.NoError(t, err)
+	// each record is ~70 bytes, one record per file
+	audit.maxSize = 100
+
+	for _, taskID := range []string{"task-1", "task-2", "task-3", "task-4"} {
+		audit.Record(context.Background(), AuditRecord{Action: AuditActionSubmit, TaskID: taskID})
+	}
+
+	getTaskIDs := func(path string) []string {
+		var ids []string
+		for _, record := range readAuditRecords(t, path) {
+			ids = append(ids, record.TaskID)
+		}
+		return ids
+	}
+	assert.Equal(t, []string{"task-4"}, getTaskIDs(path))
+	assert.Equal(t, []string{"task-3"}, getTaskIDs(path+".1"))
+	assert.Equal(t, []string{"task-2"}, getTaskIDs(path+".2"))
+	assert.NoFileExists(t, path+".3")
+
+	// appended on reopen
+	audit, err = newAuditLog(AuditLogConfig{Path: path}, newFakeClock())
+	require.NoError(t, err)
+	audit.Record(context.Background(), AuditRecord{Action: AuditActionStop, TaskID: "task-4"})
+	assert.Equal(t, []string{"task-4", "task-4"}, getTaskIDs(path))
+}
+
+func TestAuditLog_Disabled(t *testing.T) {
+	audit, err := newAuditLog(AuditLogConfig{}, newFakeClock())
+	require.NoError(t, err)
+	assert.Nil(t, audit)
+	// no-op
+	audit.Record(context.Background(), AuditRecord{Action: AuditActionSubmit, TaskID: "task"})
+
+	_, err = newAuditLog(AuditLogConfig{Path: filepath.Join(t.TempDir(), "missing", "audit.jsonl")}, newFakeClock())
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+}
@@ -0,0 +1,293 @@
+package shim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
+	docker "github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/dstackai/dstack/runner/internal/log"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+type circuitState string
+
+const (
+	// Requests go through, consecutive daemon failures are counted
+	circuitClosed circuitState = "closed"
+	// The daemon is considered unhealthy, new tasks are rejected until the cooldown expires
+	circuitOpen circuitState = "open"
+	// The cooldown has expired, the daemon is being probed
+	circuitHalfOpen circuitState = "half-open"
+)
+
+// circuitBreaker tracks the Docker daemon health. After the threshold of consecutive daemon
+// failures, the circuit opens: new tasks fail fast instead of piling up more requests
+// to the unhealthy daemon. After the cooldown, the next Allow() call probes the daemon
+// (half-open state), the circuit closes if the probe succeeds, otherwise it opens again.
+// Any successful daemon request closes the circuit as well.
+// Zero threshold disables the breaker
+type circuitBreaker struct {
+	clock     clock
+	threshold int
+	cooldown  time.Duration
+	probe     func(context.Context) error
+
+	state    circuitState
+	failures int
+	openedAt time.Time
+	mu       sync.Mutex
+}
+
+func newCircuitBreaker(clock clock, threshold int, cooldown time.Duration, probe func(context.Context) error) *circuitBreaker {
+	return &circuitBreaker{
+		clock:     clock,
+		threshold: threshold,
+		cooldown:  cooldown,
+		probe:     probe,
+		state:     circuitClosed,
+	}
+}
+
+// Allow returns ErrHostUnavailable if the circuit is open. If the cooldown has expired,
+// it probes the daemon first
+func (b *circuitBreaker) Allow(ctx context.Context) error {
+	if b.threshold <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	switch b.state {
+	case circuitClosed:
+		b.mu.Unlock()
+		return nil
+	case circuitHalfOpen:
+		b.mu.Unlock()
+		return fmt.Errorf("%w: Docker daemon is unhealthy, checking recovery", ErrHostUnavailable)
+	}
+	retryAt := b.openedAt.Add(b.cooldown)
+	if b.clock.Now().Before(retryAt) {
+		b.mu.Unlock()
+		return fmt.Errorf("%w: Docker daemon is unhealthy, retry after %s", ErrHostUnavailable, retryAt.Format(time.RFC3339))
+	}
+	b.setState(ctx, circuitHalfOpen)
+	b.mu.Unlock()
+
+	err := b.probe(ctx)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// the state could be changed by Record() while probing
+	if b.state != circuitHalfOpen {
+		if b.state == circuitOpen {
+			return fmt.Errorf("%w: Docker daemon is unhealthy", ErrHostUnavailable)
+		}
+		return nil
+	}
+	if err != nil {
+		log.Warning(ctx, "Docker daemon is still unhealthy", "err", err)
+		b.openedAt = b.clock.Now()
+		b.setState(ctx, circuitOpen)
+		return fmt.Errorf("%w: Docker daemon is unhealthy: %w", ErrHostUnavailable, err)
+	}
+	b.failures = 0
+	b.setState(ctx, circuitClosed)
+	return nil
+}
+
+// Record accounts the result of a daemon request
+func (b *circuitBreaker) Record(ctx context.Context, err error) {
+	if b.threshold <= 0 {
+		return
+	}
+	failure := isDaemonFailure(err)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failure {
+		b.failures = 0
+		if b.state == circuitOpen {
+			b.setState(ctx, circuitClosed)
+		}
+		return
+	}
+	b.failures++
+	if b.state == circuitClosed && b.failures >= b.threshold {
+		log.Warning(ctx, "too many Docker daemon failures", "failures", b.failures, "err", err)
+		b.openedAt = b.clock.Now()
+		b.setState(ctx, circuitOpen)
+	}
+}
+
+func (b *circuitBreaker) State() circuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// setState must be called with lock held
+func (b *circuitBreaker) setState(ctx context.Context, state circuitState) {
+	if b.state == state {
+		return
+	}
+	log.Info(ctx, "Docker daemon circuit breaker state changed", "from", b.state, "to", state)
+	b.state = state
+	dockerCircuitOpen.Set(boolToFloat(state != circuitClosed))
+}
+
+func boolToFloat(v bool) float64 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// isDaemonFailure reports whether the error is caused by the daemon itself (unreachable,
+// internal error, timeout), not by the request, e.g., no such container. NB: the daemon
+// reports some request-specific errors (e.g., OCI runtime errors) as internal errors too,
+// see isContainerConfigError()
+func isDaemonFailure(err error) bool {
+	switch {
+	case err == nil, errors.Is(err, context.Canceled):
+		return false
+	case errdefs.IsNotFound(err), errdefs.IsConflict(err), errdefs.IsInvalidParameter(err),
+		errdefs.IsUnauthorized(err), errdefs.IsForbidden(err), errdefs.IsNotModified(err),
+		errdefs.IsNotImplemented(err):
+		return false
+	}
+	return true
+}
+
+// isContainerConfigError reports whether the container create or start error is an OCI runtime
+// error the daemon reports as an internal one, which is caused by the container config,
+// e.g., a missing entrypoint or an invalid mount, rather than by the daemon
+func isContainerConfigError(err error) bool {
+	return errdefs.IsSystem(err) && strings.Contains(err.Error(), "OCI runtime")
+}
+
+// breakerClient records results of daemon requests in the circuit breaker. Only local daemon
+// operations are recorded: pulls and registry requests may fail due to the registry,
+// and ContainerWait() and ContainerAttach() are long-lived
+type breakerClient struct {
+	docker.APIClient
+	breaker *circuitBreaker
+}
+
+func (c *breakerClient) ContainerList(ctx context.Context, options container.ListOptions) ([]types.Container, error) {
+	containers, err := c.APIClient.ContainerList(ctx, options)
+	c.breaker.Record(ctx, err)
+	return containers, err
+}
+
+func (c *breakerClient) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *ocispec.Platform, containerName string) (container.CreateResponse, error) {
+	resp, err := c.APIClient.ContainerCreate(ctx, config, hostConfig, networkingConfig, platform, containerName)
+	c.recordContainerConfigResult(ctx, err)
+	return resp, err
+}
+
+func (c *breakerClient) ContainerStart(ctx context.Context, containerID string, options container.StartOptions) error {
+	err := c.APIClient.ContainerStart(ctx, containerID, options)
+	c.recordContainerConfigResult(ctx, err)
+	return err
+}
+
+// recordContainerConfigResult records the result of the request that applies the container config,
+// errors caused by the config are not recorded, as the daemon is healthy
+func (c *breakerClient) recordContainerConfigResult(ctx context.Context, err error) {
+	if isContainerConfigError(err) {
+		return
+	}
+	c.breaker.Record(ctx, err)
+}
+
+func (c *breakerClient) ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error) {
+	resp, err := c.APIClient.ContainerInspect(ctx, containerID)
+	c.breaker.Record(ctx, err)
+	return resp, err
+}
+
+func (c *breakerClient) ContainerStop(ctx context.Context, containerID string, options container.StopOptions) error {
+	err := c.APIClient.ContainerStop(ctx, containerID, options)
+	c.breaker.Record(ctx, err)
+	return err
+}
+
+func (c *breakerClient) ContainerKill(ctx context.Context, containerID string, signal string) error {
+	err := c.APIClient.ContainerKill(ctx, containerID, signal)
+	c.breaker.Record(ctx, err)
+	return err
+}
+
+func (c *breakerClient) ContainerRemove(ctx context.Context, containerID string, options container.RemoveOptions) error {
+	err := c.APIClient.ContainerRemove(ctx, containerID, options)
+	c.breaker.Record(ctx, err)
+	return err
+}
+
+func (c *breakerClient) ContainerLogs(ctx context.Context, containerID string, options container.LogsOptions) (io.ReadCloser, error) {
+	reader, err := c.APIClient.ContainerLogs(ctx, containerID, options)
+	c.breaker.Record(ctx, err)
+	return reader, err
+}
+
+func (c *breakerClient) ContainerStats(ctx context.Context, containerID string, stream bool) (types.ContainerStats, error) {
+	stats, err := c.APIClient.ContainerStats(ctx, containerID, stream)
+	c.breaker.Record(ctx, err)
+	return stats, err
+}
+
+func (c *breakerClient) CopyFromContainer(ctx context.Context, containerID, srcPath string) (io.ReadCloser, types.ContainerPathStat, error) {
+	reader, stat, err := c.APIClient.CopyFromContainer(ctx, containerID, srcPath)
+	c.breaker.Record(ctx, err)
+	return reader, stat, err
+}
+
+func (c *breakerClient) ContainerDiff(ctx context.Context, containerID string) ([]container.FilesystemChange, error) {
+	changes, err := c.APIClient.ContainerDiff(ctx, containerID)
+	c.breaker.Record(ctx, err)
+	return changes, err
+}
+
+func (c *breakerClient) CopyToContainer(ctx context.Context, containerID, dstPath string, content io.Reader, options types.CopyToContainerOptions) error {
+	err := c.APIClient.CopyToContainer(ctx, containerID, dstPath, content, options)
+	c.breaker.Record(ctx, err)
+	return err
+}
+
+func (c *breakerClient) ImageList(ctx context.Context, options image.ListOptions) ([]image.Summary, error) {
+	images, err := c.APIClient.ImageList(ctx, options)
+	c.breaker.Record(ctx, err)
+	return images, err
+}
+
+func (c *breakerClient) ImageTag(ctx context.Context, source, target string) error {
+	err := c.APIClient.ImageTag(ctx, source, target)
+	c.breaker.Record(ctx, err)
+	return err
+}
+
+func (c *breakerClient) VolumeCreate(ctx context.Context, options volume.CreateOptions) (volume.Volume, error) {
+	vol, err := c.APIClient.VolumeCreate(ctx, options)
+	c.breaker.Record(ctx, err)
+	return vol, err
+}
+
+func (c *breakerClient) VolumeList(ctx context.Context, options volume.ListOptions) (volume.ListResponse, error) {
+	resp, err := c.APIClient.VolumeList(ctx, options)
+	c.breaker.Record(ctx, err)
+	return resp, err
+}
+
+func (c *breakerClient) VolumeRemove(ctx context.Context, volumeID string, force bool) error {
+	err := c.APIClient.VolumeRemove(ctx, volumeID, force)
+	c.breaker.Record(ctx, err)
+	return err
+}
@@ -0,0 +1,162 @@
+package shim
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	docker "github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	var probeErr error
+	probes := 0
+	breaker := newCircuitBreaker(clock, 3, time.Minute, func(context.Context) error {
+		probes++
+		return probeErr
+	})
+	daemonErr := errdefs.System(errors.New("dial unix /var/run/docker.sock: connect: connection refused"))
+
+	// non-consecutive and request-specific failures don't open the circuit
+	breaker.Record(ctx, daemonErr)
+	breaker.Record(ctx, daemonErr)
+	breaker.Record(ctx, nil)
+	breaker.Record(ctx, daemonErr)
+	breaker.Record(ctx, errdefs.NotFound(errors.New("no such container")))
+	breaker.Record(ctx, daemonErr)
+	assert.Equal(t, circuitClosed, breaker.State())
+	assert.NoError(t, breaker.Allow(ctx))
+
+	breaker.Record(ctx, daemonErr)
+	breaker.Record(ctx, daemonErr)
+	breaker.Record(ctx, daemonErr)
+	assert.Equal(t, circuitOpen, breaker.State())
+	assert.ErrorIs(t, breaker.Allow(ctx), ErrHostUnavailable)
+	assert.Equal(t, 0, probes)
+
+	// half-open, the probe fails
+	clock.Advance(time.Minute)
+	probeErr = daemonErr
+	assert.ErrorIs(t, breaker.Allow(ctx), ErrHostUnavailable)
+	assert.Equal(t, 1, probes)
+	assert.Equal(t, circuitOpen, breaker.State())
+	// the cooldown starts over
+	clock.Advance(30 * time.Second)
+	assert.ErrorIs(t, breaker.Allow(ctx), ErrHostUnavailable)
+	assert.Equal(t, 1, probes)
+
+	// half-open, the probe succeeds
+	clock.Advance(30 * time.Second)
+	probeErr = nil
+	assert.NoError(t, breaker.Allow(ctx))
+	assert.Equal(t, 2, probes)
+	assert.Equal(t, circuitClosed, breaker.State())
+}
+
+func TestCircuitBreaker_SuccessCloses(t *testing.T) {
+	ctx := context.Background()
+	breaker := newCircuitBreaker(newFakeClock(), 1, time.Hour, func(context.Context) error { return nil })
+	breaker.Record(ctx, errors.New("EOF"))
+	require.Equal(t, circuitOpen, breaker.State())
+	// e.g., an in-flight request has succeeded
+	breaker.Record(ctx, nil)
+	assert.Equal(t, circuitClosed, breaker.State())
+}
+
+func TestCircuitBreaker_Disabled(t *testing.T) {
+	ctx := context.Background()
+	breaker := newCircuitBreaker(newFakeClock(), 0, time.Hour, nil)
+	for i := 0; i < 10; i++ {
+		breaker.Record(ctx, errors.New("EOF"))
+	}
+	assert.NoError(t, breaker.Allow(ctx))
+}
+
+func TestIsDaemonFailure(t *testing.T) {
+	assert.False(t, isDaemonFailure(nil))
+	assert.False(t, isDaemonFailure(context.Canceled))
+	assert.False(t, isDaemonFailure(errdefs.NotFound(errors.New("no such container"))))
+	assert.False(t, isDaemonFailure(errdefs.Conflict(errors.New("name is already in use"))))
+	assert.False(t, isDaemonFailure(errdefs.InvalidParameter(errors.New("invalid mount config"))))
+	assert.True(t, isDaemonFailure(docker.ErrorConnectionFailed("unix:///var/run/docker.sock")))
+	assert.True(t, isDaemonFailure(errdefs.System(errors.New("containerd is not running"))))
+	assert.True(t, isDaemonFailure(context.DeadlineExceeded))
+}
+
+func TestIsContainerConfigError(t *testing.T) {
+	assert.False(t, isContainerConfigError(nil))
+	assert.True(t, isContainerConfigError(errdefs.System(errors.New(
+		"failed to create task for container: OCI runtime create failed: exec: \"/start.sh\": no such file or directory",
+	))))
+	assert.False(t, isContainerConfigError(errdefs.System(errors.New("containerd is not running"))))
+	// not reported by the daemon
+	assert.False(t, isContainerConfigError(errors.New("OCI runtime create failed")))
+}
+
+func TestDockerRunner_CircuitBreaker_ContainerConfigErrors(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{
+		circuitBreakerThreshold: 2,
+		circuitBreakerCooldown:  time.Minute,
+	})
+	configErr := errdefs.System(errors.New("OCI runtime create failed: invalid mount"))
+
+	client.injectErrors("ContainerCreate", configErr)
+	client.injectErrors("ContainerStart", configErr)
+	_, err := runner.client.ContainerCreate(ctx, &container.Config{}, &container.HostConfig{}, nil, nil, "any")
+	assert.ErrorIs(t, err, configErr)
+	assert.ErrorIs(t, runner.client.ContainerStart(ctx, "any", container.StartOptions{}), configErr)
+	assert.Equal(t, circuitClosed, runner.breaker.State())
+	assert.NoError(t, runner.Ready(ctx))
+
+	// other internal errors are counted
+	daemonErr := errdefs.System(errors.New("containerd is not running"))
+	client.injectErrors("ContainerStart", daemonErr, daemonErr)
+	for i := 0; i < 2; i++ {
+		_ = runner.client.ContainerStart(ctx, "any", container.StartOptions{})
+	}
+	assert.ErrorIs(t, runner.Ready(ctx), ErrHostUnavailable)
+}
+
+func TestDockerRunner_CircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{
+		circuitBreakerThreshold: 2,
+		circuitBreakerCooldown:  time.Minute,
+	})
+	clock := newFakeClock()
+	runner.breaker.clock = clock
+	daemonErr := docker.ErrorConnectionFailed("unix:///var/run/docker.sock")
+
+	client.injectErrors("ContainerInspect", daemonErr, daemonErr)
+	for i := 0; i < 2; i++ {
+		_, err := runner.client.ContainerInspect(ctx, "any")
+		require.Error(t, err)
+	}
+	assert.ErrorIs(t, runner.Ready(ctx), ErrHostUnavailable)
+	cfg := createTaskConfig(t)
+	err := runner.Submit(ctx, cfg)
+	assert.ErrorIs(t, err, ErrHostUnavailable)
+	assert.ErrorContains(t, err, "Docker daemon is unhealthy")
+	assert.Equal(t, "", runner.TaskInfo(cfg.ID).ID)
+
+	// the daemon is still down
+	clock.Advance(time.Minute)
+	client.injectErrors("Ping", daemonErr)
+	assert.ErrorIs(t, runner.Ready(ctx), ErrHostUnavailable)
+
+	// the daemon has recovered
+	clock.Advance(time.Minute)
+	assert.NoError(t, runner.Ready(ctx))
+	containerID := runTask(t, runner, cfg)
+	client.exitContainer(containerID, 0)
+}
@@ -0,0 +1,34 @@
+package shim
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Allowed characters of a cgroup path component: cgroupfs permits almost anything except '/',
+// but systemd and the Docker daemon are stricter, so stick to the safe subset
+var cgroupNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_.@:-]+$`)
+
+// validateCgroupParent checks that the cgroup parent is either a systemd slice name
+// (e.g., slurm.slice or machine-batch.slice, systemd cgroup driver) or a cgroupfs path
+// (e.g., /slurm/job_42 or kubepods/burstable, cgroupfs driver). Which of the two forms
+// the daemon accepts depends on its cgroup driver, this is not checked here.
+// Empty string is a valid value meaning "the daemon default"
+func validateCgroupParent(cgroupParent string) error {
+	if cgroupParent == "" {
+		return nil
+	}
+	if strings.HasSuffix(cgroupParent, ".slice") {
+		if strings.Contains(cgroupParent, "/") || !cgroupNameRegex.MatchString(cgroupParent) {
+			return fmt.Errorf("%w: invalid cgroup_parent %q, systemd slice must be a unit name", ErrInvalidConfig, cgroupParent)
+		}
+		return nil
+	}
+	for _, name := range strings.Split(strings.TrimPrefix(cgroupParent, "/"), "/") {
+		if name == "" || name == "." || name == ".." || !cgroupNameRegex.MatchString(name) {
+			return fmt.Errorf("%w: invalid cgroup_parent %q, invalid path component %q", ErrInvalidConfig, cgroupParent, name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package shim
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateCgroupParent(t *testing.T) {
+	for _, cgroupParent := range []string{"", "slurm.slice", "machine-batch.slice", "/slurm/job_42", "kubepods/burstable/pod-1", "/docker"} {
+		assert.NoError(t, validateCgroupParent(cgroupParent), cgroupParent)
+	}
+	for _, cgroupParent := range []string{"/", "/slurm/", "//slurm", "slurm/../..", "./slurm", "/slurm/job 42", "system/slurm.slice"} {
+		assert.ErrorIs(t, validateCgroupParent(cgroupParent), ErrInvalidConfig, cgroupParent)
+	}
+}
+
+func TestDockerRunner_CgroupParent(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.CgroupParent = "/slurm/job_42"
+	containerID := runTask(t, runner, cfg)
+	defer client.exitContainer(containerID, 0)
+
+	ctr, err := client.getContainer(containerID)
+	require.NoError(t, err)
+	assert.Equal(t, "/slurm/job_42", ctr.hostConfig.CgroupParent)
+}
+
+func TestDockerRunner_CgroupParent_SubmitRejected(t *testing.T) {
+	runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.CgroupParent = "../escape"
+
+	assert.ErrorIs(t, runner.Submit(context.Background(), cfg), ErrInvalidConfig)
+	assert.Equal(t, "", runner.TaskInfo(cfg.ID).ID)
+}
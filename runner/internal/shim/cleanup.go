@@ -0,0 +1,66 @@
+package shim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/dstackai/dstack/runner/internal/log"
+)
+
+// Task cleanup steps, see runCleanup()
+const (
+	cleanupStepRemoveContainer      = "remove_container"
+	cleanupStepReleaseGpus          = "release_gpus"
+	cleanupStepRemoveAuthorizedKeys = "remove_authorized_keys"
+	cleanupStepUnmountVolumes       = "unmount_volumes"
+	cleanupStepRemoveVolumes        = "remove_volumes"
+	cleanupStepRemoveRunnerDir      = "remove_runner_dir"
+)
+
+// cleanupOrder is the order cleanup steps are run in, regardless of the order they are added in.
+// The steps are split between two pipelines run one after another. Run() captures exit info
+// (the exit code, the OOM flag, core dumps) and the last logs once the container has exited,
+// then releases GPUs, as no process of the task holds them anymore (see drainGpus() for
+// processes that escaped the container), and the other resources acquired for the task.
+// Remove() removes the container later (a finished task keeps it so that logs can be read),
+// then volumes, as they cannot be removed while in use. The runner dir goes last, it's where
+// the container logs are written to
+var cleanupOrder = []string{
+	// Run()
+	cleanupStepReleaseGpus,
+	cleanupStepRemoveAuthorizedKeys,
+	cleanupStepUnmountVolumes,
+	// Remove()
+	cleanupStepRemoveContainer,
+	cleanupStepRemoveVolumes,
+	cleanupStepRemoveRunnerDir,
+}
+
+type cleanupStep struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// runCleanup runs the steps in cleanupOrder. A failed step doesn't abort the rest: the failure
+// is logged and recorded as the task history event, and the joined error of all failed steps
+// is returned
+func (d *DockerRunner) runCleanup(ctx context.Context, taskID string, steps []cleanupStep) error {
+	steps = slices.Clone(steps)
+	slices.SortStableFunc(steps, func(a, b cleanupStep) int {
+		return slices.Index(cleanupOrder, a.name) - slices.Index(cleanupOrder, b.name)
+	})
+	var errs []error
+	for _, step := range steps {
+		if err := step.run(ctx); err != nil {
+			log.Error(ctx, "cleanup step failed", "task", taskID, "step", step.name, "err", err)
+			d.tasks.RecordEvent(taskID, TaskHistoryEvent{
+				Type:    TaskHistoryEventCleanupFailed,
+				Message: fmt.Sprintf("%s: %s", step.name, err),
+			})
+			errs = append(errs, fmt.Errorf("%s: %w", step.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
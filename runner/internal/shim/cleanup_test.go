@@ -0,0 +1,138 @@
+package shim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/volume"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getCleanupFailedMessages(runner *DockerRunner, taskID string) []string {
+	var messages []string
+	for _, event := range runner.tasks.Events(taskID) {
+		if event.Type == TaskHistoryEventCleanupFailed {
+			messages = append(messages, event.Message)
+		}
+	}
+	return messages
+}
+
+func TestDockerRunner_RunCleanup(t *testing.T) {
+	runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	require.NoError(t, runner.Submit(context.Background(), cfg))
+
+	var calls []string
+	step := func(name string, err error) cleanupStep {
+		return cleanupStep{name: name, run: func(context.Context) error {
+			calls = append(calls, name)
+			return err
+		}}
+	}
+	errGpus := errors.New("gpus are busy")
+	errVolumes := errors.New("volume is in use")
+	// added in the wrong order
+	err := runner.runCleanup(context.Background(), cfg.ID, []cleanupStep{
+		step(cleanupStepRemoveRunnerDir, nil),
+		step(cleanupStepRemoveVolumes, errVolumes),
+		step(cleanupStepReleaseGpus, errGpus),
+		step(cleanupStepRemoveContainer, nil),
+	})
+
+	assert.Equal(t, []string{
+		cleanupStepReleaseGpus, cleanupStepRemoveContainer, cleanupStepRemoveVolumes, cleanupStepRemoveRunnerDir,
+	}, calls)
+	assert.ErrorIs(t, err, errGpus)
+	assert.ErrorIs(t, err, errVolumes)
+	assert.Equal(t, []string{
+		"release_gpus: gpus are busy",
+		"remove_volumes: volume is in use",
+	}, getCleanupFailedMessages(runner, cfg.ID))
+
+	assert.NoError(t, runner.runCleanup(context.Background(), cfg.ID, nil))
+}
+
+func TestDockerRunner_Remove_ContainerRemoveFailed(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.DockerVolumes = []DockerVolumeMount{{Name: "cache", Path: "/cache"}}
+	containerID := runTask(t, runner, cfg)
+	client.exitContainer(containerID, 0)
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusTerminated)
+
+	client.injectErrors("ContainerRemove", errors.New("connection refused"))
+	err := runner.Remove(context.Background(), cfg.ID)
+	assert.ErrorIs(t, err, ErrInternal)
+
+	assert.Equal(t, []string{"remove_container: connection refused"}, getCleanupFailedMessages(runner, cfg.ID))
+	// the volume removal is attempted anyway, the volume is skipped as the container still uses it
+	assert.Contains(t, client.volumes, "cache")
+	_, ok := runner.tasks.Get(cfg.ID)
+	assert.True(t, ok)
+
+	require.NoError(t, runner.Remove(context.Background(), cfg.ID))
+	assert.NotContains(t, client.containers, containerID)
+	assert.NotContains(t, client.volumes, "cache")
+	_, ok = runner.tasks.Get(cfg.ID)
+	assert.False(t, ok)
+}
+
+func TestDockerRunner_Remove_VolumeRemoveFailed(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.DockerVolumes = []DockerVolumeMount{{Name: "cache", Path: "/cache"}}
+	containerID := runTask(t, runner, cfg)
+	client.exitContainer(containerID, 0)
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusTerminated)
+
+	client.injectErrors("VolumeRemove", errors.New("device or resource busy"))
+	assert.ErrorIs(t, runner.Remove(context.Background(), cfg.ID), ErrInternal)
+
+	// the container is removed before volumes
+	assert.NotContains(t, client.containers, containerID)
+	assert.Equal(t, []string{"remove_volumes: failed to remove volume cache: device or resource busy"}, getCleanupFailedMessages(runner, cfg.ID))
+
+	require.NoError(t, runner.Remove(context.Background(), cfg.ID))
+	assert.Equal(t, map[string]*volume.Volume{}, client.volumes)
+}
+
+func TestDockerRunner_RunRemove_CleanupOrder(t *testing.T) {
+	client := newFakeDockerClient()
+	nvml := newFakeNVML()
+	runner := newGPUDrainRunner(t, client, GPUDrainConfig{Timeout: 5 * time.Second}, nvml)
+	cfg := createTaskConfig(t)
+	cfg.GPU = 1
+	containerID := runTask(t, runner, cfg)
+
+	// GPUs are drained right before they are released, the container state is captured then
+	var mu sync.Mutex
+	var calls []string
+	runner.gpuProcesses = func(ctx context.Context) (map[GPUID][]int, error) {
+		client.mu.Lock()
+		ctr, ok := client.containers[containerID]
+		running := ok && ctr.running
+		client.mu.Unlock()
+		mu.Lock()
+		calls = append(calls, fmt.Sprintf("%s: exists=%t, running=%t", cleanupStepReleaseGpus, ok, running))
+		mu.Unlock()
+		return nvml.ComputeProcesses(ctx)
+	}
+
+	client.exitContainer(containerID, 0)
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusTerminated)
+	require.NoError(t, runner.Remove(context.Background(), cfg.ID))
+	assert.NotContains(t, client.containers, containerID)
+
+	mu.Lock()
+	defer mu.Unlock()
+	// released by Run() once the container has exited, then the container is removed by Remove()
+	assert.Equal(t, []string{cleanupStepReleaseGpus + ": exists=true, running=false"}, calls)
+}
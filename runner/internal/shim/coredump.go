@@ -0,0 +1,148 @@
+package shim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/go-units"
+
+	"github.com/dstackai/dstack/runner/internal/log"
+)
+
+// CoreDumpContainerDir is the directory in task containers where core dumps are captured from.
+// The host kernel.core_pattern must point there, e.g., /var/crash/dstack/core.%e.%p.%t.
+// The kernel resolves the pattern in the mount namespace of the crashing process, that is,
+// the dump is written into the container, where the dir is bind-mounted from the host
+const CoreDumpContainerDir = "/var/crash/dstack"
+
+// Sticky and writable by all, so that container users other than root can write dumps,
+// but not remove dumps of others
+const coreDumpDirMode = 0o777 | os.ModeSticky
+
+// Overridden in tests
+var corePatternPath = "/proc/sys/kernel/core_pattern"
+
+// CoreDump is a core dump file captured from the task container, see TaskConfig.CoreDumps
+type CoreDump struct {
+	// On the host
+	Path string    `json:"path"`
+	Size int64     `json:"size"` // bytes
+	Time time.Time `json:"time"` // modification time
+}
+
+// coreDumps keeps core dumps of tasks in <dir>/<task ID>, the dir is configured by
+// the shim operator, as dumps may be large. Dumps are kept after the task is removed,
+// the operator is responsible for cleaning them up. Empty dir disables capture
+type coreDumps struct {
+	dir string
+}
+
+func newCoreDumps(dir string) *coreDumps {
+	return &coreDumps{dir: dir}
+}
+
+func (cd *coreDumps) Enabled() bool {
+	return cd.dir != ""
+}
+
+// CheckCorePattern warns if the host kernel.core_pattern doesn't point to CoreDumpContainerDir,
+// in which case dumps are either written elsewhere or passed to a handler, e.g., systemd-coredump
+func (cd *coreDumps) CheckCorePattern(ctx context.Context) {
+	if !cd.Enabled() {
+		return
+	}
+	data, err := os.ReadFile(corePatternPath)
+	if err != nil {
+		log.Warning(ctx, "cannot read core pattern", "path", corePatternPath, "err", err)
+		return
+	}
+	pattern := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(pattern, CoreDumpContainerDir+"/") {
+		log.Warning(ctx, "core dumps won't be captured: core pattern doesn't point to the container dir", "pattern", pattern, "dir", CoreDumpContainerDir)
+	}
+}
+
+// Prepare creates the task dir and returns its mount
+func (cd *coreDumps) Prepare(taskID string) (mount.Mount, error) {
+	dir := cd.taskDir(taskID)
+	if err := os.MkdirAll(dir, coreDumpDirMode); err != nil {
+		return mount.Mount{}, fmt.Errorf("create core dump dir: %w", err)
+	}
+	// MkdirAll is subject to umask and doesn't change the existing dir
+	if err := os.Chmod(dir, coreDumpDirMode); err != nil {
+		return mount.Mount{}, fmt.Errorf("chmod core dump dir: %w", err)
+	}
+	return mount.Mount{Type: mount.TypeBind, Source: dir, Target: CoreDumpContainerDir}, nil
+}
+
+// Collect returns dumps of the task, the oldest first. It's not an error if the task has no dir
+func (cd *coreDumps) Collect(taskID string) ([]CoreDump, error) {
+	if !cd.Enabled() {
+		return nil, nil
+	}
+	dir := cd.taskDir(taskID)
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var dumps []CoreDump
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// removed concurrently
+			continue
+		}
+		dumps = append(dumps, CoreDump{
+			Path: filepath.Join(dir, entry.Name()),
+			Size: info.Size(),
+			Time: info.ModTime(),
+		})
+	}
+	slices.SortFunc(dumps, func(a, b CoreDump) int {
+		return a.Time.Compare(b.Time)
+	})
+	return dumps, nil
+}
+
+func (cd *coreDumps) taskDir(taskID string) string {
+	return filepath.Join(cd.dir, taskID)
+}
+
+// configureCoreDumps mounts the task core dump dir and lifts the core size limit,
+// which is 0 by default in most distributions
+func (d *DockerRunner) configureCoreDumps(task *Task, hostConfig *container.HostConfig) error {
+	coreDumpMount, err := d.coreDumps.Prepare(task.ID)
+	if err != nil {
+		return err
+	}
+	hostConfig.Mounts = append(hostConfig.Mounts, coreDumpMount)
+	hostConfig.Ulimits = append(hostConfig.Ulimits, &units.Ulimit{Name: "core", Soft: -1, Hard: -1})
+	return nil
+}
+
+// collectCoreDumps sets dumps written by the task container(s), including replaced ones
+func (d *DockerRunner) collectCoreDumps(ctx context.Context, task *Task) {
+	dumps, err := d.coreDumps.Collect(task.ID)
+	if err != nil {
+		log.Error(ctx, "failed to collect core dumps", "task", task.ID, "err", err)
+		return
+	}
+	if len(dumps) > 0 {
+		log.Info(ctx, "captured core dumps", "task", task.ID, "count", len(dumps))
+	}
+	task.coreDumps = dumps
+}
@@ -0,0 +1,124 @@
+package shim
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/go-units"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDockerRunner_CoreDumps(t *testing.T) {
+	dir := t.TempDir()
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{coreDumpDir: dir})
+	cfg := createTaskConfig(t)
+	cfg.CoreDumps = true
+	containerID := runTask(t, runner, cfg)
+
+	taskDir := filepath.Join(dir, cfg.ID)
+	client.mu.Lock()
+	hostConfig := client.containers[containerID].hostConfig
+	client.mu.Unlock()
+	assert.Contains(t, hostConfig.Mounts, mount.Mount{Type: mount.TypeBind, Source: taskDir, Target: CoreDumpContainerDir})
+	assert.Contains(t, hostConfig.Ulimits, &units.Ulimit{Name: "core", Soft: -1, Hard: -1})
+	info, err := os.Stat(taskDir)
+	require.NoError(t, err)
+	assert.Equal(t, coreDumpDirMode, info.Mode()&(os.ModePerm|os.ModeSticky))
+	assert.Empty(t, runner.TaskInfo(cfg.ID).CoreDumps)
+
+	// the kernel writes the dump into the mounted dir on crash
+	dumpPath := filepath.Join(taskDir, "core.python.42")
+	require.NoError(t, os.WriteFile(dumpPath, []byte("ELF core"), 0o600))
+	client.exitContainer(containerID, 139)
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusFailed)
+
+	dumps := runner.TaskInfo(cfg.ID).CoreDumps
+	require.Len(t, dumps, 1)
+	assert.Equal(t, dumpPath, dumps[0].Path)
+	assert.Equal(t, int64(8), dumps[0].Size)
+	assert.False(t, dumps[0].Time.IsZero())
+
+	// dumps are kept for debugging after the task is removed
+	require.NoError(t, runner.Remove(context.Background(), cfg.ID))
+	assert.FileExists(t, dumpPath)
+}
+
+func TestDockerRunner_CoreDumps_NotRequested(t *testing.T) {
+	dir := t.TempDir()
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{coreDumpDir: dir})
+	cfg := createTaskConfig(t)
+	containerID := runTask(t, runner, cfg)
+
+	client.mu.Lock()
+	hostConfig := client.containers[containerID].hostConfig
+	client.mu.Unlock()
+	for _, m := range hostConfig.Mounts {
+		assert.NotEqual(t, CoreDumpContainerDir, m.Target)
+	}
+	assert.NoDirExists(t, filepath.Join(dir, cfg.ID))
+}
+
+func TestDockerRunner_CoreDumps_NoDir(t *testing.T) {
+	runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.CoreDumps = true
+
+	err := runner.Submit(context.Background(), cfg)
+
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+}
+
+func TestDockerRunner_CoreDumps_Restore(t *testing.T) {
+	dir := t.TempDir()
+	client := newFakeDockerClient()
+	addTaskContainer(client, "crashed", false, 139, time.Now())
+	addTaskContainer(client, "running", true, 0, time.Now())
+	for _, taskID := range []string{"crashed", "running"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, taskID), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, taskID, "core.1"), []byte("core"), 0o600))
+	}
+
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{coreDumpDir: dir})
+
+	dumps := runner.TaskInfo("crashed").CoreDumps
+	require.Len(t, dumps, 1)
+	assert.Equal(t, filepath.Join(dir, "crashed", "core.1"), dumps[0].Path)
+	// collected once the container exits
+	assert.Empty(t, runner.TaskInfo("running").CoreDumps)
+}
+
+func TestCoreDumps_Collect(t *testing.T) {
+	dir := t.TempDir()
+	dumps := newCoreDumps(dir)
+	collected, err := dumps.Collect("task")
+	require.NoError(t, err)
+	assert.Empty(t, collected)
+
+	taskDir := filepath.Join(dir, "task")
+	require.NoError(t, os.MkdirAll(filepath.Join(taskDir, "subdir"), 0o755))
+	now := time.Now()
+	for i, name := range []string{"core.b", "core.a"} {
+		path := filepath.Join(taskDir, name)
+		require.NoError(t, os.WriteFile(path, []byte(name), 0o600))
+		mtime := now.Add(time.Duration(i) * time.Second)
+		require.NoError(t, os.Chtimes(path, mtime, mtime))
+	}
+
+	collected, err = dumps.Collect("task")
+	require.NoError(t, err)
+	require.Len(t, collected, 2)
+	// the oldest first, directories are skipped
+	assert.Equal(t, filepath.Join(taskDir, "core.b"), collected[0].Path)
+	assert.Equal(t, filepath.Join(taskDir, "core.a"), collected[1].Path)
+
+	collected, err = newCoreDumps("").Collect("task")
+	require.NoError(t, err)
+	assert.Nil(t, collected)
+}
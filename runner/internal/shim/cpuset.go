@@ -0,0 +1,97 @@
+package shim
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The kernel lists of online CPUs and NUMA nodes, in the cpuset format
+var (
+	onlineCpusPath  = "/sys/devices/system/cpu/online"
+	onlineNodesPath = "/sys/devices/system/node/online"
+)
+
+// parseCpuset parses the cpuset list format (e.g., 0-3,8,10-11) used by the kernel and
+// Docker (--cpuset-cpus, --cpuset-mems), returns sorted unique IDs
+func parseCpuset(cpuset string) ([]int, error) {
+	var ids []int
+	for _, part := range strings.Split(cpuset, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		start, err := parseCpusetID(first)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpuset %q: %w", cpuset, err)
+		}
+		end := start
+		if isRange {
+			if end, err = parseCpusetID(last); err != nil {
+				return nil, fmt.Errorf("invalid cpuset %q: %w", cpuset, err)
+			}
+			if end < start {
+				return nil, fmt.Errorf("invalid cpuset %q: invalid range %s", cpuset, part)
+			}
+		}
+		for id := start; id <= end; id++ {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return slices.Compact(ids), nil
+}
+
+func parseCpusetID(s string) (int, error) {
+	// strconv.Atoi accepts signs
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, fmt.Errorf("invalid ID %q", s)
+	}
+	return strconv.Atoi(s)
+}
+
+// getOnlineCpuset returns IDs listed in the sysfs file, or 0..fallbackCount-1 if the file
+// cannot be read, e.g., sysfs is not mounted
+func getOnlineCpuset(path string, fallbackCount int) ([]int, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		ids := make([]int, fallbackCount)
+		for i := range ids {
+			ids[i] = i
+		}
+		return ids, nil
+	}
+	return parseCpuset(strings.TrimSpace(string(content)))
+}
+
+// validateCpuset checks that cpuset_cpus and cpuset_mems are valid lists of online CPUs and
+// NUMA nodes of the host. Empty strings are valid values meaning "no pinning"
+func (d *DockerRunner) validateCpuset(cfg TaskConfig) error {
+	fields := []struct {
+		name      string
+		value     string
+		onlineIDs func() ([]int, error)
+	}{
+		{"cpuset_cpus", cfg.CPUSetCPUs, func() ([]int, error) { return getOnlineCpuset(onlineCpusPath, d.dockerInfo.NCPU) }},
+		// A host without NUMA support has a single node 0
+		{"cpuset_mems", cfg.CPUSetMems, func() ([]int, error) { return getOnlineCpuset(onlineNodesPath, 1) }},
+	}
+	for _, field := range fields {
+		if field.value == "" {
+			continue
+		}
+		ids, err := parseCpuset(field.value)
+		if err != nil {
+			return fmt.Errorf("%w: %s: %w", ErrInvalidConfig, field.name, err)
+		}
+		onlineIDs, err := field.onlineIDs()
+		if err != nil {
+			return fmt.Errorf("%w: failed to get host %s: %w", ErrInternal, field.name, err)
+		}
+		for _, id := range ids {
+			if _, found := slices.BinarySearch(onlineIDs, id); !found {
+				return fmt.Errorf("%w: %s: %d is not online on the host", ErrInvalidConfig, field.name, id)
+			}
+		}
+	}
+	return nil
+}
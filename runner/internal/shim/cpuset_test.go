@@ -0,0 +1,90 @@
+package shim
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCpuset(t *testing.T) {
+	testCases := []struct {
+		cpuset string
+		ids    []int
+	}{
+		{"0", []int{0}},
+		{"0-3", []int{0, 1, 2, 3}},
+		{"8,0-2,10-11", []int{0, 1, 2, 8, 10, 11}},
+		{"1,1,0-1", []int{0, 1}},
+		{"3-3", []int{3}},
+	}
+	for _, tc := range testCases {
+		ids, err := parseCpuset(tc.cpuset)
+		require.NoError(t, err, tc.cpuset)
+		assert.Equal(t, tc.ids, ids, tc.cpuset)
+	}
+	for _, cpuset := range []string{"", ",", "0,", "-1", "3-1", "0-", "a", "0 - 3", "+1", "0-3-5", "1.5"} {
+		_, err := parseCpuset(cpuset)
+		assert.Error(t, err, cpuset)
+	}
+}
+
+func setOnlineCpusets(t *testing.T, cpus string, nodes string) {
+	dir := t.TempDir()
+	onlineCpusPathOrig, onlineNodesPathOrig := onlineCpusPath, onlineNodesPath
+	onlineCpusPath = filepath.Join(dir, "cpu_online")
+	onlineNodesPath = filepath.Join(dir, "node_online")
+	t.Cleanup(func() { onlineCpusPath, onlineNodesPath = onlineCpusPathOrig, onlineNodesPathOrig })
+	require.NoError(t, os.WriteFile(onlineCpusPath, []byte(cpus+"\n"), 0o644))
+	require.NoError(t, os.WriteFile(onlineNodesPath, []byte(nodes+"\n"), 0o644))
+}
+
+func TestDockerRunner_Cpuset(t *testing.T) {
+	setOnlineCpusets(t, "0-7", "0-1")
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.CPUSetCPUs = "0-3,6"
+	cfg.CPUSetMems = "1"
+	containerID := runTask(t, runner, cfg)
+	defer client.exitContainer(containerID, 0)
+
+	ctr, err := client.getContainer(containerID)
+	require.NoError(t, err)
+	assert.Equal(t, "0-3,6", ctr.hostConfig.CpusetCpus)
+	assert.Equal(t, "1", ctr.hostConfig.CpusetMems)
+}
+
+func TestDockerRunner_Cpuset_SubmitRejected(t *testing.T) {
+	setOnlineCpusets(t, "0-7", "0")
+	runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{})
+	testCases := []struct {
+		cpus string
+		mems string
+		err  string
+	}{
+		{"0-3,", "", "cpuset_cpus: invalid cpuset"},
+		{"4-2", "", "invalid range"},
+		{"6-8", "", "cpuset_cpus: 8 is not online"},
+		{"", "1", "cpuset_mems: 1 is not online"},
+		{"", "0-a", "cpuset_mems: invalid cpuset"},
+	}
+	for _, tc := range testCases {
+		cfg := createTaskConfig(t)
+		cfg.CPUSetCPUs = tc.cpus
+		cfg.CPUSetMems = tc.mems
+		err := runner.Submit(context.Background(), cfg)
+		assert.ErrorIs(t, err, ErrInvalidConfig, tc.err)
+		assert.ErrorContains(t, err, tc.err)
+		assert.Equal(t, "", runner.TaskInfo(cfg.ID).ID)
+	}
+}
+
+func TestGetOnlineCpuset_Fallback(t *testing.T) {
+	ids, err := getOnlineCpuset(filepath.Join(t.TempDir(), "missing"), 2)
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1}, ids)
+}
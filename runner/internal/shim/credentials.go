@@ -0,0 +1,298 @@
+package shim
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+
+	"github.com/dstackai/dstack/runner/internal/log"
+)
+
+// Used if CredentialProviderConfig.Timeout is not set
+const defaultCredentialProviderTimeout = 10 * time.Second
+
+// The maximum size of the provider response body, the rest is not read
+const maxCredentialResponseSize = 256 * 1024
+
+// Used if TaskCredentials.Path is not set
+const DefaultCredentialsPath = "/run/dstack/credentials"
+
+// The container env var with the credentials file path
+const CredentialsFileEnvVar = "DSTACK_CREDENTIALS_FILE"
+
+// Used if TaskCredentials.Mode is not set
+const defaultCredentialsFileMode = 0o600
+
+// Credentials are refreshed once this share of their lifetime has passed
+const credentialsRefreshRatio = 2.0 / 3.0
+
+// Lower bound of the refresh delay, so that short-lived credentials don't flood the provider
+const defaultMinCredentialsRefreshDelay = time.Second
+
+const defaultCredentialsRetryInterval = 30 * time.Second
+
+// CredentialProviderConfig configures the credential provider, see credentialProvider.
+// Empty URL disables the provider, tasks with TaskConfig.Credentials are rejected
+type CredentialProviderConfig struct {
+	URL     string
+	Timeout time.Duration
+}
+
+// TaskCredentials requests temporary credentials for the task, see TaskConfig.Credentials
+type TaskCredentials struct {
+	// An opaque identifier passed to the provider, e.g., an IAM role ARN or a Vault role
+	Role string `json:"role"`
+	// An absolute path inside the container, DefaultCredentialsPath if not set
+	Path string `json:"path"`
+	// Permission bits, 0 = 0600. The file is owned by root, a task running as another user
+	// needs a mode readable by others, e.g., 0644
+	Mode uint32 `json:"mode"`
+}
+
+func (c TaskCredentials) getPath() string {
+	if c.Path == "" {
+		return DefaultCredentialsPath
+	}
+	return c.Path
+}
+
+func (c TaskCredentials) getMode() uint32 {
+	if c.Mode == 0 {
+		return defaultCredentialsFileMode
+	}
+	return c.Mode
+}
+
+// CredentialRequest is the body of the request sent to the credential provider
+type CredentialRequest struct {
+	TaskID  string `json:"task_id"`
+	RunID   string `json:"run_id,omitempty"`
+	TraceID string `json:"trace_id,omitempty"`
+	Role    string `json:"role"`
+}
+
+// CredentialResponse is the expected body of a 2xx provider response
+type CredentialResponse struct {
+	// Written to the credentials file as is, e.g., an AWS credentials file or a Vault token
+	Content   string    `json:"content"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CredentialsStatus is the state of the task credentials as reported in TaskInfo
+type CredentialsStatus struct {
+	// Of the credentials currently written to the container
+	ExpiresAt   time.Time `json:"expires_at"`
+	RefreshedAt time.Time `json:"refreshed_at"`
+	// The error of the last refresh attempt, empty if it succeeded. The task keeps running
+	// with the previous credentials, which may have expired
+	Error string `json:"error"`
+}
+
+// credentialProvider fetches short-lived credentials from an external service, e.g., a proxy
+// to AWS STS or Vault, so that tasks don't need long-lived secrets
+type credentialProvider struct {
+	url    string
+	client *http.Client
+}
+
+// newCredentialProvider returns nil if the provider is disabled
+func newCredentialProvider(config CredentialProviderConfig) (*credentialProvider, error) {
+	if config.URL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credential provider URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid credential provider URL %s: scheme must be http or https", config.URL)
+	}
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = defaultCredentialProviderTimeout
+	}
+	return &credentialProvider{
+		url:    config.URL,
+		client: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (p *credentialProvider) Fetch(ctx context.Context, credReq CredentialRequest) (CredentialResponse, error) {
+	body, err := json.Marshal(credReq)
+	if err != nil {
+		return CredentialResponse{}, fmt.Errorf("marshal request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return CredentialResponse{}, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return CredentialResponse{}, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxCredentialResponseSize))
+	if err != nil {
+		return CredentialResponse{}, fmt.Errorf("read response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		// The body is not included, as it may contain secrets
+		return CredentialResponse{}, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	var credResp CredentialResponse
+	if err := json.Unmarshal(respBody, &credResp); err != nil {
+		return CredentialResponse{}, fmt.Errorf("parse response: %w", err)
+	}
+	if credResp.ExpiresAt.IsZero() {
+		return CredentialResponse{}, fmt.Errorf("invalid response: expires_at is not set")
+	}
+	return credResp, nil
+}
+
+// getCredentialsRefreshDelay returns the time until the next refresh of credentials fetched at now,
+// at least minDelay
+func getCredentialsRefreshDelay(now time.Time, expiresAt time.Time, minDelay time.Duration) time.Duration {
+	delay := time.Duration(float64(expiresAt.Sub(now)) * credentialsRefreshRatio)
+	return max(delay, minDelay)
+}
+
+// taskCredentials keeps CredentialsStatus of tasks with TaskConfig.Credentials.
+// The state is not persisted, credentials of tasks restored on shim restart are not refreshed
+type taskCredentials struct {
+	statuses map[string]CredentialsStatus
+	mu       sync.Mutex
+}
+
+func newTaskCredentials() *taskCredentials {
+	return &taskCredentials{statuses: make(map[string]CredentialsStatus)}
+}
+
+// Get returns nil if the task has no credentials
+func (tc *taskCredentials) Get(taskID string) *CredentialsStatus {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	status, ok := tc.statuses[taskID]
+	if !ok {
+		return nil
+	}
+	return &status
+}
+
+func (tc *taskCredentials) Refreshed(taskID string, now time.Time, expiresAt time.Time) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.statuses[taskID] = CredentialsStatus{ExpiresAt: expiresAt, RefreshedAt: now}
+}
+
+func (tc *taskCredentials) Failed(taskID string, err error) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	status := tc.statuses[taskID]
+	status.Error = err.Error()
+	tc.statuses[taskID] = status
+}
+
+func (tc *taskCredentials) Delete(taskID string) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	delete(tc.statuses, taskID)
+}
+
+func validateTaskCredentials(creds *TaskCredentials, provider *credentialProvider) error {
+	if creds == nil {
+		return nil
+	}
+	if provider == nil {
+		return fmt.Errorf("%w: credentials: the credential provider is not configured", ErrInvalidConfig)
+	}
+	filePath, err := validateContainerPath(creds.getPath())
+	if err != nil {
+		return err
+	}
+	if filePath == "/" || strings.HasSuffix(creds.Path, "/") {
+		return fmt.Errorf("%w: credentials path must not be a directory: %s", ErrInvalidConfig, creds.Path)
+	}
+	if creds.Mode > 0o777 {
+		return fmt.Errorf("%w: credentials: mode must be in 0..0777 range, got %#o", ErrInvalidConfig, creds.Mode)
+	}
+	return nil
+}
+
+// injectCredentials fetches credentials and writes them into the container, it's called
+// after the container is created, so that the task starts with valid credentials.
+// Returns the expiration time
+func (d *DockerRunner) injectCredentials(ctx context.Context, task *Task, containerID string) (time.Time, error) {
+	creds := task.config.Credentials
+	resp, err := d.credentialProvider.Fetch(ctx, CredentialRequest{
+		TaskID:  task.ID,
+		RunID:   task.config.RunID,
+		TraceID: task.config.TraceID,
+		Role:    creds.Role,
+	})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("fetch credentials: %w", err)
+	}
+	archive, err := createInlineFilesArchive([]InlineFile{{
+		Path: creds.getPath(), Content: resp.Content, Mode: creds.getMode(), Secret: true,
+	}})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("archive credentials: %w", err)
+	}
+	if err := d.client.CopyToContainer(ctx, containerID, "/", archive, types.CopyToContainerOptions{}); err != nil {
+		return time.Time{}, fmt.Errorf("copy credentials to container: %w", err)
+	}
+	d.credentials.Refreshed(task.ID, time.Now(), resp.ExpiresAt)
+	log.Debug(ctx, "credentials written", "task", task.ID, "path", creds.getPath(), "expires", resp.ExpiresAt)
+	return resp.ExpiresAt, nil
+}
+
+// startCredentialsRefresher refreshes credentials of the running container before they expire
+// until the returned function is called. Failures are retried, the task is not stopped
+func (d *DockerRunner) startCredentialsRefresher(ctx context.Context, task *Task) func() {
+	status := d.credentials.Get(task.ID)
+	if task.config.Credentials == nil || status == nil {
+		return func() {}
+	}
+	// Run() keeps updating the task
+	taskCopy := *task
+	task = &taskCopy
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		containerID := task.containerID
+		delay := getCredentialsRefreshDelay(status.RefreshedAt, status.ExpiresAt, d.credentialsMinRefresh)
+		for {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return
+			}
+			expiresAt, err := d.injectCredentials(ctx, task, containerID)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				log.Error(ctx, "failed to refresh credentials", "task", task.ID, "err", err)
+				d.credentials.Failed(task.ID, err)
+				delay = d.credentialsRetryInterval
+				continue
+			}
+			delay = getCredentialsRefreshDelay(time.Now(), expiresAt, d.credentialsMinRefresh)
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
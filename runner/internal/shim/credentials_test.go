@@ -0,0 +1,218 @@
+package shim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCredentialProvider issues token-1, token-2, ... valid for ttl. Requests listed
+// in failures (1-based) fail with 503
+type fakeCredentialProvider struct {
+	server   *httptest.Server
+	ttl      time.Duration
+	failures map[int]bool
+	mu       sync.Mutex
+	requests []CredentialRequest
+}
+
+func newFakeCredentialProvider(t *testing.T, ttl time.Duration, failures ...int) *fakeCredentialProvider {
+	p := &fakeCredentialProvider{ttl: ttl, failures: map[int]bool{}}
+	for _, n := range failures {
+		p.failures[n] = true
+	}
+	p.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req CredentialRequest
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		p.mu.Lock()
+		p.requests = append(p.requests, req)
+		n := len(p.requests)
+		p.mu.Unlock()
+		if p.failures[n] {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(CredentialResponse{
+			Content:   fmt.Sprintf("token-%d", n),
+			ExpiresAt: time.Now().Add(p.ttl),
+		})
+	}))
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func (p *fakeCredentialProvider) Requests() []CredentialRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.requests
+}
+
+func getContainerFile(t *testing.T, client *fakeDockerClient, containerID string, path string) (string, int64) {
+	t.Helper()
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	ctr := client.containers[containerID]
+	return ctr.files[path], ctr.fileModes[path]
+}
+
+func TestDockerRunner_Credentials_Injected(t *testing.T) {
+	provider := newFakeCredentialProvider(t, time.Hour)
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{
+		credentialProvider: CredentialProviderConfig{URL: provider.server.URL},
+	})
+	cfg := createTaskConfig(t)
+	cfg.RunID = "run"
+	cfg.TraceID = "trace"
+	cfg.Credentials = &TaskCredentials{Role: "arn:aws:iam::123456789012:role/train"}
+	containerID := runTask(t, runner, cfg)
+	defer client.exitContainer(containerID, 0)
+
+	content, mode := getContainerFile(t, client, containerID, DefaultCredentialsPath)
+	assert.Equal(t, "token-1", content)
+	assert.Equal(t, int64(0o600), mode)
+	ctr, err := client.getContainer(containerID)
+	require.NoError(t, err)
+	assert.Contains(t, ctr.config.Env, "DSTACK_CREDENTIALS_FILE=/run/dstack/credentials")
+	assert.Equal(t, []CredentialRequest{{
+		TaskID: cfg.ID, RunID: "run", TraceID: "trace", Role: "arn:aws:iam::123456789012:role/train",
+	}}, provider.Requests())
+	status := runner.TaskInfo(cfg.ID).Credentials
+	require.NotNil(t, status)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), status.ExpiresAt, time.Minute)
+	assert.Empty(t, status.Error)
+
+	require.NoError(t, runner.Terminate(context.Background(), cfg.ID, nil, "TERMINATED_BY_USER", ""))
+	require.NoError(t, runner.Remove(context.Background(), cfg.ID))
+	assert.Nil(t, runner.credentials.Get(cfg.ID))
+}
+
+func TestDockerRunner_Credentials_Refresh(t *testing.T) {
+	// the second request, that is, the first refresh, fails
+	provider := newFakeCredentialProvider(t, 150*time.Millisecond, 2)
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{
+		credentialProvider: CredentialProviderConfig{URL: provider.server.URL},
+	})
+	runner.credentialsRetryInterval = 10 * time.Millisecond
+	runner.credentialsMinRefresh = time.Millisecond
+	cfg := createTaskConfig(t)
+	cfg.Credentials = &TaskCredentials{Path: "/home/user/.aws/credentials", Mode: 0o644}
+	containerID := runTask(t, runner, cfg)
+	path := "/home/user/.aws/credentials"
+	content, mode := getContainerFile(t, client, containerID, path)
+	assert.Equal(t, "token-1", content)
+	assert.Equal(t, int64(0o644), mode)
+	initial := runner.TaskInfo(cfg.ID).Credentials
+	require.NotNil(t, initial)
+
+	// the failure is surfaced, the task keeps running with the previous credentials
+	require.Eventually(t, func() bool {
+		status := runner.TaskInfo(cfg.ID).Credentials
+		return status != nil && status.Error != ""
+	}, 5*time.Second, time.Millisecond)
+	status := runner.TaskInfo(cfg.ID).Credentials
+	assert.Contains(t, status.Error, "unexpected status 503")
+	assert.Equal(t, initial.ExpiresAt, status.ExpiresAt)
+	assert.Equal(t, TaskStatusRunning, runner.TaskInfo(cfg.ID).Status)
+
+	// retried
+	require.Eventually(t, func() bool {
+		content, _ := getContainerFile(t, client, containerID, path)
+		return content == "token-3"
+	}, 5*time.Second, time.Millisecond)
+	require.Eventually(t, func() bool {
+		status := runner.TaskInfo(cfg.ID).Credentials
+		return status.Error == "" && status.ExpiresAt.After(initial.ExpiresAt)
+	}, 5*time.Second, time.Millisecond)
+
+	// not refreshed once the container exits
+	client.exitContainer(containerID, 0)
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusTerminated)
+	count := len(provider.Requests())
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, count, len(provider.Requests()))
+}
+
+func TestDockerRunner_Credentials_InitialFailure(t *testing.T) {
+	provider := newFakeCredentialProvider(t, time.Hour, 1)
+	runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{
+		credentialProvider: CredentialProviderConfig{URL: provider.server.URL},
+	})
+	cfg := createTaskConfig(t)
+	cfg.Credentials = &TaskCredentials{Role: "train"}
+	require.NoError(t, runner.Submit(context.Background(), cfg))
+
+	assert.Error(t, runner.Run(context.Background(), cfg.ID))
+
+	info := runner.TaskInfo(cfg.ID)
+	assert.Equal(t, TaskStatusFailed, info.Status)
+	assert.Equal(t, "CREATING_CONTAINER_ERROR", info.TerminationReason)
+	assert.Contains(t, info.TerminationMessage, "fetch credentials: unexpected status 503")
+}
+
+func TestDockerRunner_Credentials_SubmitRejected(t *testing.T) {
+	runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.Credentials = &TaskCredentials{Role: "train"}
+
+	err := runner.Submit(context.Background(), cfg)
+
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.ErrorContains(t, err, "credential provider is not configured")
+}
+
+func TestValidateTaskCredentials(t *testing.T) {
+	provider := &credentialProvider{}
+	assert.NoError(t, validateTaskCredentials(nil, nil))
+	assert.NoError(t, validateTaskCredentials(&TaskCredentials{}, provider))
+	assert.NoError(t, validateTaskCredentials(&TaskCredentials{Path: "/root/.aws/credentials", Mode: 0o640}, provider))
+	for _, creds := range []TaskCredentials{
+		{Path: "relative"},
+		{Path: "/etc/"},
+		{Path: "/a/../b"},
+		{Mode: 0o1777},
+	} {
+		assert.ErrorIs(t, validateTaskCredentials(&creds, provider), ErrInvalidConfig, creds)
+	}
+}
+
+func TestGetCredentialsRefreshDelay(t *testing.T) {
+	now := time.Now()
+	assert.Equal(t, 40*time.Minute, getCredentialsRefreshDelay(now, now.Add(time.Hour), time.Second))
+	// already expired
+	assert.Equal(t, time.Second, getCredentialsRefreshDelay(now, now.Add(-time.Minute), time.Second))
+}
+
+func TestNewCredentialProvider(t *testing.T) {
+	provider, err := newCredentialProvider(CredentialProviderConfig{})
+	require.NoError(t, err)
+	assert.Nil(t, provider)
+	_, err = newCredentialProvider(CredentialProviderConfig{URL: "ftp://example.com"})
+	assert.Error(t, err)
+	provider, err = newCredentialProvider(CredentialProviderConfig{URL: "https://vault.example.com/creds"})
+	require.NoError(t, err)
+	assert.Equal(t, defaultCredentialProviderTimeout, provider.client.Timeout)
+}
+
+func TestCredentialProvider_Fetch_NoExpiration(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"content": "token"}`))
+	}))
+	defer server.Close()
+	provider, err := newCredentialProvider(CredentialProviderConfig{URL: server.URL})
+	require.NoError(t, err)
+
+	_, err = provider.Fetch(context.Background(), CredentialRequest{TaskID: "task"})
+
+	assert.ErrorContains(t, err, "expires_at is not set")
+}
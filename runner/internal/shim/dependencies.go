@@ -0,0 +1,107 @@
+package shim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/dstackai/dstack/runner/internal/log"
+)
+
+// A dependency is satisfied if it has terminated with this reason, i.e., the container exited with 0
+const dependencySuccessReason = "DONE_BY_RUNNER"
+
+// validateDependencies checks that all dependencies are known tasks and that the new task
+// doesn't create a dependency cycle. The latter is possible since task IDs can be reused
+// after removal: the new task may be a dependency of an existing one
+func (d *DockerRunner) validateDependencies(cfg TaskConfig) error {
+	for _, dependencyID := range cfg.DependsOn {
+		if dependencyID == cfg.ID {
+			return fmt.Errorf("%w: task %s depends on itself", ErrInvalidConfig, cfg.ID)
+		}
+		if _, ok := d.tasks.Get(dependencyID); !ok {
+			return fmt.Errorf("%w: unknown dependency %s", ErrInvalidConfig, dependencyID)
+		}
+	}
+	if cycle := d.findDependencyCycle(cfg); cycle != nil {
+		return fmt.Errorf("%w: dependency cycle: %s", ErrInvalidConfig, strings.Join(cycle, " -> "))
+	}
+	return nil
+}
+
+// findDependencyCycle returns the path from the new task back to itself, if any
+func (d *DockerRunner) findDependencyCycle(cfg TaskConfig) []string {
+	visited := map[string]bool{}
+	var visit func(path []string, dependsOn []string) []string
+	visit = func(path []string, dependsOn []string) []string {
+		for _, dependencyID := range dependsOn {
+			if dependencyID == cfg.ID {
+				return append(slices.Clone(path), dependencyID)
+			}
+			if visited[dependencyID] {
+				continue
+			}
+			visited[dependencyID] = true
+			if dependency, ok := d.tasks.Get(dependencyID); ok {
+				if cycle := visit(append(path, dependencyID), dependency.config.DependsOn); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		return nil
+	}
+	return visit([]string{cfg.ID}, cfg.DependsOn)
+}
+
+// waitDependencies blocks until all dependencies of the pending task have finished successfully.
+// If any dependency has failed or is removed before finishing, the task fails with
+// DEPENDENCY_FAILED reason and an error is returned. If the task is no longer pending,
+// it returns nil, the caller must check the task status
+func (d *DockerRunner) waitDependencies(ctx context.Context, taskID string, dependsOn []string) error {
+	// Subscribe before the first check, otherwise the update may be missed
+	updates, unsubscribe := d.tasks.Subscribe(dependsOn...)
+	defer unsubscribe()
+	remaining := slices.Clone(dependsOn)
+	for {
+		task, ok := d.tasks.Get(taskID)
+		if !ok || task.Status != TaskStatusPending {
+			return nil
+		}
+		var failure string
+		remaining = slices.DeleteFunc(remaining, func(dependencyID string) bool {
+			dependency, ok := d.tasks.Get(dependencyID)
+			switch {
+			case failure != "":
+				return false
+			case !ok:
+				failure = fmt.Sprintf("dependency %s not found", dependencyID)
+				return false
+			case !dependency.Status.IsFinished():
+				return false
+			case dependency.TerminationReason == dependencySuccessReason:
+				return true
+			default:
+				failure = fmt.Sprintf("dependency %s failed: %s", dependencyID, dependency.TerminationReason)
+				return false
+			}
+		})
+		if failure != "" {
+			d.failPendingTask(ctx, taskID, "DEPENDENCY_FAILED", failure)
+			return fmt.Errorf("%w: task %s: %s", ErrRequest, taskID, failure)
+		}
+		if len(remaining) == 0 {
+			log.Debug(ctx, "dependencies finished", "task", taskID)
+			return nil
+		}
+		select {
+		case <-updates:
+		case <-ctx.Done():
+			if errors.Is(context.Cause(ctx), errTerminatedWhilePending) {
+				return nil
+			}
+			return fmt.Errorf("%w: task %s: failed to wait for dependencies: %w", ErrInternal, taskID, ctx.Err())
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package shim
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDockerRunner_DependsOn(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	first := createTaskConfig(t)
+	firstContainerID := runTask(t, runner, first)
+
+	second := createTaskConfig(t)
+	second.DependsOn = []string{first.ID}
+	require.NoError(t, runner.Submit(context.Background(), second))
+	runErr := make(chan error)
+	go func() { runErr <- runner.Run(context.Background(), second.ID) }()
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, TaskStatusPending, runner.TaskInfo(second.ID).Status)
+
+	client.exitContainer(firstContainerID, 0)
+	waitTaskStatus(t, runner, second.ID, TaskStatusRunning)
+	client.exitContainer(runner.TaskInfo(second.ID).ContainerID, 0)
+	require.NoError(t, <-runErr)
+	assert.Equal(t, "DONE_BY_RUNNER", runner.TaskInfo(second.ID).TerminationReason)
+}
+
+func TestDockerRunner_DependsOn_Failed(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	first := createTaskConfig(t)
+	firstContainerID := runTask(t, runner, first)
+
+	second := createTaskConfig(t)
+	second.DependsOn = []string{first.ID}
+	require.NoError(t, runner.Submit(context.Background(), second))
+	third := createTaskConfig(t)
+	third.DependsOn = []string{second.ID}
+	require.NoError(t, runner.Submit(context.Background(), third))
+	secondErr := make(chan error)
+	go func() { secondErr <- runner.Run(context.Background(), second.ID) }()
+	thirdErr := make(chan error)
+	go func() { thirdErr <- runner.Run(context.Background(), third.ID) }()
+
+	client.exitContainer(firstContainerID, 1)
+	assert.ErrorIs(t, <-secondErr, ErrRequest)
+	assert.ErrorIs(t, <-thirdErr, ErrRequest)
+
+	taskInfo := runner.TaskInfo(second.ID)
+	assert.Equal(t, TaskStatusFailed, taskInfo.Status)
+	assert.Equal(t, "DEPENDENCY_FAILED", taskInfo.TerminationReason)
+	assert.Equal(t, "dependency "+first.ID+" failed: CONTAINER_EXITED_WITH_ERROR", taskInfo.TerminationMessage)
+	assert.Empty(t, taskInfo.ContainerID)
+	// the failure propagates down the chain
+	taskInfo = runner.TaskInfo(third.ID)
+	assert.Equal(t, TaskStatusFailed, taskInfo.Status)
+	assert.Equal(t, "DEPENDENCY_FAILED", taskInfo.TerminationReason)
+	assert.Equal(t, "dependency "+second.ID+" failed: DEPENDENCY_FAILED", taskInfo.TerminationMessage)
+}
+
+func TestDockerRunner_DependsOn_TerminatedWhileWaiting(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	first := createTaskConfig(t)
+	firstContainerID := runTask(t, runner, first)
+	defer client.exitContainer(firstContainerID, 0)
+
+	second := createTaskConfig(t)
+	second.DependsOn = []string{first.ID}
+	require.NoError(t, runner.Submit(context.Background(), second))
+	runErr := make(chan error)
+	go func() { runErr <- runner.Run(context.Background(), second.ID) }()
+	// let Run() start waiting
+	time.Sleep(50 * time.Millisecond)
+
+	require.NoError(t, runner.Terminate(context.Background(), second.ID, nil, "TERMINATED_BY_USER", ""))
+	require.NoError(t, <-runErr)
+	assert.Equal(t, "TERMINATED_BY_USER", runner.TaskInfo(second.ID).TerminationReason)
+}
+
+func TestDockerRunner_DependsOn_SubmitRejected(t *testing.T) {
+	runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{})
+	ctx := context.Background()
+
+	cfg := createTaskConfig(t)
+	cfg.DependsOn = []string{cfg.ID}
+	assert.ErrorContains(t, runner.Submit(ctx, cfg), "depends on itself")
+
+	cfg.DependsOn = []string{"unknown"}
+	assert.ErrorIs(t, runner.Submit(ctx, cfg), ErrInvalidConfig)
+
+	// a -> b -> c, then c is removed and resubmitted depending on a
+	a, b, c := createTaskConfig(t), createTaskConfig(t), createTaskConfig(t)
+	require.NoError(t, runner.Submit(ctx, c))
+	b.DependsOn = []string{c.ID}
+	require.NoError(t, runner.Submit(ctx, b))
+	a.DependsOn = []string{b.ID}
+	require.NoError(t, runner.Submit(ctx, a))
+	require.NoError(t, runner.Terminate(ctx, c.ID, nil, "TERMINATED_BY_USER", ""))
+	require.NoError(t, runner.Remove(ctx, c.ID))
+	c.DependsOn = []string{a.ID}
+	err := runner.Submit(ctx, c)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.ErrorContains(t, err, "dependency cycle: "+c.ID+" -> "+a.ID+" -> "+b.ID+" -> "+c.ID)
+}
@@ -0,0 +1,124 @@
+package shim
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	docker "github.com/docker/docker/client"
+	"github.com/dstackai/dstack/runner/internal/log"
+)
+
+const (
+	// A container that exits within this period after start is considered failed to start,
+	// e.g., due to a bad entrypoint or a missing binary
+	startupPeriod = 10 * time.Second
+	// The number of first container log lines included in startup diagnostics
+	startupLogsLines = 20
+	// Upper bound on the container output read to collect startup logs
+	startupLogsMaxBytes = 64 * 1024
+)
+
+// ContainerDiagnostics is collected if the container fails to start or exits right after start
+type ContainerDiagnostics struct {
+	ExitCode int `json:"exit_code"`
+	// The start error returned by Docker or the container state error, e.g., exec format error
+	Error     string `json:"error"`
+	OOMKilled bool   `json:"oom_killed"`
+	// The first lines of the container output
+	Logs []string `json:"logs"`
+}
+
+// Message returns a human-readable summary suitable for TerminationMessage
+func (cd *ContainerDiagnostics) Message() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "container failed to start, exit code %d", cd.ExitCode)
+	if cd.OOMKilled {
+		b.WriteString(", OOM killed")
+	}
+	if cd.Error != "" {
+		fmt.Fprintf(&b, ": %s", cd.Error)
+	}
+	for _, line := range cd.Logs {
+		b.WriteString("\n")
+		b.WriteString(line)
+	}
+	return b.String()
+}
+
+// getStartupDiagnostics returns diagnostics if the container failed to start (startErr is not nil)
+// or exited within startupPeriod after start, otherwise nil
+func (d *DockerRunner) getStartupDiagnostics(ctx context.Context, task *Task, startErr error) *ContainerDiagnostics {
+	containerJSON, err := d.client.ContainerInspect(ctx, task.containerID)
+	if err != nil {
+		log.Error(ctx, "failed to inspect container", "task", task.ID, "err", err)
+		if startErr == nil {
+			return nil
+		}
+		return &ContainerDiagnostics{Error: startErr.Error(), Logs: []string{}}
+	}
+	state := containerJSON.State
+	if state == nil {
+		state = &types.ContainerState{}
+	}
+	if startErr == nil && !exitedOnStartup(state) {
+		return nil
+	}
+	diagnostics := &ContainerDiagnostics{
+		ExitCode:  state.ExitCode,
+		Error:     state.Error,
+		OOMKilled: state.OOMKilled,
+		Logs:      []string{},
+	}
+	if startErr != nil {
+		diagnostics.Error = startErr.Error()
+	}
+	if logDriver := d.getEffectiveLogDriver(task.config); !isLogDriverReadable(logDriver) {
+		log.Warning(ctx, "skip getting container logs: not supported by log driver", "task", task.ID, "driver", logDriver)
+	} else if logs, err := getContainerFirstLogs(ctx, d.client, task.containerID, startupLogsLines, task.config.TTY); err != nil {
+		log.Error(ctx, "failed to get container logs", "task", task.ID, "err", err)
+	} else {
+		diagnostics.Logs = logs
+	}
+	return diagnostics
+}
+
+func exitedOnStartup(state *types.ContainerState) bool {
+	if state.Running || state.Restarting {
+		return false
+	}
+	startedAt, err := time.Parse(time.RFC3339Nano, state.StartedAt)
+	if err != nil {
+		return false
+	}
+	finishedAt, err := time.Parse(time.RFC3339Nano, state.FinishedAt)
+	if err != nil {
+		return false
+	}
+	return finishedAt.Sub(startedAt) <= startupPeriod
+}
+
+func getContainerFirstLogs(ctx context.Context, client docker.APIClient, containerID string, n int, tty bool) ([]string, error) {
+	options := container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+	}
+	reader, err := client.ContainerLogs(ctx, containerID, options)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	// a truncated frame at the end of the multiplexed stream is silently dropped by StdCopy
+	lines, err := readContainerLogs(io.LimitReader(reader, startupLogsMaxBytes), tty)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) > n {
+		lines = lines[:n]
+	}
+	return lines, nil
+}
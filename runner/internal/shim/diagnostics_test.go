@@ -0,0 +1,105 @@
+package shim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDockerRunner_StartupDiagnostics_ImmediateExit(t *testing.T) {
+	client := newFakeDockerClient()
+	client.crashOnStart = &fakeCrash{
+		exitCode: 127,
+		logs:     []string{"/bin/sh: 1: python: not found"},
+	}
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	require.NoError(t, runner.Submit(context.Background(), cfg))
+
+	assert.Error(t, runner.Run(context.Background(), cfg.ID))
+	taskInfo := runner.TaskInfo(cfg.ID)
+	assert.Equal(t, TaskStatusFailed, taskInfo.Status)
+	assert.Equal(t, "CONTAINER_EXITED_WITH_ERROR", taskInfo.TerminationReason)
+	assert.Equal(t, "container failed to start, exit code 127\n/bin/sh: 1: python: not found", taskInfo.TerminationMessage)
+	assert.Equal(t, &ContainerDiagnostics{
+		ExitCode: 127,
+		Logs:     []string{"/bin/sh: 1: python: not found"},
+	}, taskInfo.Diagnostics)
+}
+
+func TestDockerRunner_StartupDiagnostics_FirstLines(t *testing.T) {
+	client := newFakeDockerClient()
+	logs := make([]string, 0, startupLogsLines+10)
+	for i := 0; i < cap(logs); i++ {
+		logs = append(logs, fmt.Sprintf("line %d", i))
+	}
+	client.crashOnStart = &fakeCrash{exitCode: 1, logs: logs, stateError: "exec format error"}
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.TTY = true
+	require.NoError(t, runner.Submit(context.Background(), cfg))
+
+	assert.Error(t, runner.Run(context.Background(), cfg.ID))
+	diagnostics := runner.TaskInfo(cfg.ID).Diagnostics
+	require.NotNil(t, diagnostics)
+	assert.Equal(t, "exec format error", diagnostics.Error)
+	assert.Equal(t, logs[:startupLogsLines], diagnostics.Logs)
+	assert.Contains(t, runner.TaskInfo(cfg.ID).TerminationMessage, "exit code 1: exec format error\nline 0\n")
+}
+
+func TestDockerRunner_StartupDiagnostics_StartError(t *testing.T) {
+	client := newFakeDockerClient()
+	startErr := errors.New(
+		"OCI runtime create failed: exec: \"/start.sh\": stat /start.sh: no such file or directory",
+	)
+	client.injectErrors("ContainerStart", startErr)
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	require.NoError(t, runner.Submit(context.Background(), cfg))
+
+	assert.Error(t, runner.Run(context.Background(), cfg.ID))
+	taskInfo := runner.TaskInfo(cfg.ID)
+	assert.Equal(t, "CONTAINER_EXITED_WITH_ERROR", taskInfo.TerminationReason)
+	assert.Contains(t, taskInfo.TerminationMessage, "no such file or directory")
+	require.NotNil(t, taskInfo.Diagnostics)
+	assert.Equal(t, startErr.Error(), taskInfo.Diagnostics.Error)
+}
+
+func TestDockerRunner_StartupDiagnostics_NotCollectedAfterStartup(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	containerID := runTask(t, runner, cfg)
+	client.mu.Lock()
+	client.containers[containerID].startedAt = time.Now().Add(-time.Hour)
+	client.containers[containerID].logs = []string{"error"}
+	client.mu.Unlock()
+	client.exitContainer(containerID, 1)
+
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusFailed)
+	taskInfo := runner.TaskInfo(cfg.ID)
+	assert.Equal(t, "CONTAINER_EXITED_WITH_ERROR", taskInfo.TerminationReason)
+	assert.Equal(t, "error", taskInfo.TerminationMessage)
+	assert.Nil(t, taskInfo.Diagnostics)
+}
+
+func TestExitedOnStartup(t *testing.T) {
+	startedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	state := func(running bool, ranFor time.Duration) *types.ContainerState {
+		return &types.ContainerState{
+			Running:    running,
+			StartedAt:  startedAt.Format(time.RFC3339Nano),
+			FinishedAt: startedAt.Add(ranFor).Format(time.RFC3339Nano),
+		}
+	}
+	assert.True(t, exitedOnStartup(state(false, time.Second)))
+	assert.False(t, exitedOnStartup(state(false, time.Minute)))
+	assert.False(t, exitedOnStartup(state(true, 0)))
+	assert.False(t, exitedOnStartup(&types.ContainerState{}))
+}
@@ -0,0 +1,64 @@
+package shim
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+
+	"github.com/dstackai/dstack/runner/internal/log"
+)
+
+type FilesystemChangeKind string
+
+const (
+	FilesystemChangeAdded    FilesystemChangeKind = "added"
+	FilesystemChangeModified FilesystemChangeKind = "modified"
+	FilesystemChangeDeleted  FilesystemChangeKind = "deleted"
+)
+
+// FilesystemChange is a path changed in the container filesystem compared to the image,
+// as reported by `docker diff`. Mounted volumes and tmpfs are not included
+type FilesystemChange struct {
+	Path string               `json:"path"`
+	Kind FilesystemChangeKind `json:"kind"`
+}
+
+// TaskDiff returns changes of the task container filesystem, sorted by path. The container
+// may be either running or terminated, but not removed
+func (d *DockerRunner) TaskDiff(ctx context.Context, taskID string) ([]FilesystemChange, error) {
+	task, ok := d.tasks.Get(taskID)
+	if !ok {
+		return nil, fmt.Errorf("task %s: %w", taskID, ErrNotFound)
+	}
+	if task.containerID == "" {
+		return nil, fmt.Errorf("%w: task %s has no container", ErrRequest, task.ID)
+	}
+	dockerChanges, err := d.client.ContainerDiff(ctx, task.containerID)
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return nil, fmt.Errorf("%w: task %s container is gone", ErrRequest, task.ID)
+		}
+		return nil, fmt.Errorf("%w: failed to get container changes: %w", ErrInternal, err)
+	}
+	changes := make([]FilesystemChange, 0, len(dockerChanges))
+	for _, change := range dockerChanges {
+		changes = append(changes, FilesystemChange{Path: change.Path, Kind: getFilesystemChangeKind(change.Kind)})
+	}
+	slices.SortFunc(changes, func(a, b FilesystemChange) int { return strings.Compare(a.Path, b.Path) })
+	log.Debug(ctx, "container changes", "task", task.ID, "count", len(changes))
+	return changes, nil
+}
+
+func getFilesystemChangeKind(kind container.ChangeType) FilesystemChangeKind {
+	switch kind {
+	case container.ChangeAdd:
+		return FilesystemChangeAdded
+	case container.ChangeDelete:
+		return FilesystemChangeDeleted
+	}
+	return FilesystemChangeModified
+}
@@ -0,0 +1,73 @@
+package shim
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setContainerChanges(client *fakeDockerClient, containerID string, changes ...container.FilesystemChange) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.containers[containerID].changes = changes
+}
+
+func TestDockerRunner_TaskDiff(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	containerID := runTask(t, runner, cfg)
+	setContainerChanges(client, containerID,
+		container.FilesystemChange{Path: "/tmp/out.bin", Kind: container.ChangeAdd},
+		container.FilesystemChange{Path: "/var/cache/apt", Kind: container.ChangeDelete},
+		container.FilesystemChange{Path: "/etc", Kind: container.ChangeModify},
+	)
+	expected := []FilesystemChange{
+		{Path: "/etc", Kind: FilesystemChangeModified},
+		{Path: "/tmp/out.bin", Kind: FilesystemChangeAdded},
+		{Path: "/var/cache/apt", Kind: FilesystemChangeDeleted},
+	}
+
+	changes, err := runner.TaskDiff(context.Background(), cfg.ID)
+	require.NoError(t, err)
+	assert.Equal(t, expected, changes)
+
+	// still available after the container exits
+	client.exitContainer(containerID, 0)
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusTerminated)
+	changes, err = runner.TaskDiff(context.Background(), cfg.ID)
+	require.NoError(t, err)
+	assert.Equal(t, expected, changes)
+
+	client.injectErrors("ContainerDiff", errors.New("daemon error"))
+	_, err = runner.TaskDiff(context.Background(), cfg.ID)
+	assert.ErrorIs(t, err, ErrInternal)
+}
+
+func TestDockerRunner_TaskDiff_Errors(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+
+	_, err := runner.TaskDiff(context.Background(), "unknown")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// no container yet
+	cfg := createTaskConfig(t)
+	require.NoError(t, runner.Submit(context.Background(), cfg))
+	_, err = runner.TaskDiff(context.Background(), cfg.ID)
+	assert.ErrorIs(t, err, ErrRequest)
+
+	// the container is gone
+	cfg = createTaskConfig(t)
+	containerID := runTask(t, runner, cfg)
+	client.mu.Lock()
+	delete(client.containers, containerID)
+	client.mu.Unlock()
+	_, err = runner.TaskDiff(context.Background(), cfg.ID)
+	assert.ErrorIs(t, err, ErrRequest)
+	assert.ErrorContains(t, err, "container is gone")
+}
@@ -0,0 +1,187 @@
+package shim
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/go-units"
+
+	"github.com/dstackai/dstack/runner/internal/log"
+	"github.com/dstackai/dstack/runner/internal/shim/host"
+)
+
+// The termination reason of tasks stopped by the disk monitor, see DiskMonitor.AutoStop
+const diskQuotaExceededReason = "DISK_QUOTA_EXCEEDED"
+
+const (
+	defaultDiskMonitorInterval = 60 // seconds
+	// Measuring the writable layer walks the container filesystem, it's too expensive to do it often
+	minDiskMonitorInterval = 10 // seconds
+)
+
+// DiskMonitor fields are in seconds, overridden in tests
+var diskMonitorTimeUnit = time.Second
+
+// DiskMonitor periodically checks disk usage of the running task against the limits,
+// see TaskConfig.DiskMonitor. It's a soft limit for storage drivers and volumes without
+// hard quotas: the usage may exceed the limit between checks. At least one limit must be set
+type DiskMonitor struct {
+	// The container writable layer (files added or changed in the container), bytes, 0 = not checked
+	MaxWritableLayerSize uint64 `json:"max_writable_layer_size"`
+	// Used space of each of the task VolumeMounts, percent, 1..100, 0 = not checked.
+	// The volume filesystem usage is checked, including files written by other tasks, if any
+	MaxVolumeUsagePercent uint `json:"max_volume_usage_percent"`
+	// Stop the task with DISK_QUOTA_EXCEEDED once a limit is exceeded, otherwise the task
+	// keeps running, and only the history event is recorded
+	AutoStop bool `json:"auto_stop"`
+	// Seconds between checks, 0 = 60, at least 10
+	Interval uint `json:"interval"`
+}
+
+func (m DiskMonitor) getInterval() time.Duration {
+	if m.Interval == 0 {
+		return defaultDiskMonitorInterval * diskMonitorTimeUnit
+	}
+	return time.Duration(m.Interval) * diskMonitorTimeUnit
+}
+
+func validateDiskMonitor(cfg TaskConfig) error {
+	monitor := cfg.DiskMonitor
+	if monitor == nil {
+		return nil
+	}
+	if monitor.MaxWritableLayerSize == 0 && monitor.MaxVolumeUsagePercent == 0 {
+		return fmt.Errorf("%w: disk_monitor: max_writable_layer_size or max_volume_usage_percent must be set", ErrInvalidConfig)
+	}
+	if monitor.MaxVolumeUsagePercent > 100 {
+		return fmt.Errorf("%w: disk_monitor: max_volume_usage_percent must be in 1..100 range, got %d", ErrInvalidConfig, monitor.MaxVolumeUsagePercent)
+	}
+	if monitor.MaxVolumeUsagePercent > 0 && len(cfg.VolumeMounts) == 0 {
+		return fmt.Errorf("%w: disk_monitor: max_volume_usage_percent is set, but there are no volume_mounts", ErrInvalidConfig)
+	}
+	if monitor.Interval != 0 && monitor.Interval < minDiskMonitorInterval {
+		return fmt.Errorf("%w: disk_monitor: interval must be at least %d seconds, got %d", ErrInvalidConfig, minDiskMonitorInterval, monitor.Interval)
+	}
+	return nil
+}
+
+type volumeDiskUsage struct {
+	Name  string
+	Total uint64 // bytes
+	Used  uint64 // bytes
+}
+
+type diskUsage struct {
+	WritableLayer uint64 // bytes
+	Volumes       []volumeDiskUsage
+}
+
+// check returns descriptions of exceeded limits, empty if none
+func (u diskUsage) check(monitor DiskMonitor) []string {
+	var exceeded []string
+	if limit := monitor.MaxWritableLayerSize; limit > 0 && u.WritableLayer > limit {
+		exceeded = append(exceeded, fmt.Sprintf(
+			"writable layer size %s exceeds %s", units.BytesSize(float64(u.WritableLayer)), units.BytesSize(float64(limit)),
+		))
+	}
+	if limit := monitor.MaxVolumeUsagePercent; limit > 0 {
+		for _, vol := range u.Volumes {
+			if vol.Total == 0 {
+				continue
+			}
+			if percent := float64(vol.Used) * 100 / float64(vol.Total); percent > float64(limit) {
+				exceeded = append(exceeded, fmt.Sprintf("volume %s usage %.1f%% exceeds %d%%", vol.Name, percent, limit))
+			}
+		}
+	}
+	return exceeded
+}
+
+// getDiskUsage is the default DockerRunner.diskUsage
+func (d *DockerRunner) getDiskUsage(ctx context.Context, task *Task) (diskUsage, error) {
+	var usage diskUsage
+	if task.config.DiskMonitor.MaxWritableLayerSize > 0 {
+		inspect, _, err := d.client.ContainerInspectWithRaw(ctx, task.containerID, true)
+		if err != nil {
+			return diskUsage{}, fmt.Errorf("failed to inspect container: %w", err)
+		}
+		if inspect.SizeRw != nil && *inspect.SizeRw > 0 {
+			usage.WritableLayer = uint64(*inspect.SizeRw)
+		}
+	}
+	if task.config.DiskMonitor.MaxVolumeUsagePercent > 0 {
+		for _, mountPoint := range task.config.VolumeMounts {
+			total, used, err := host.GetDiskUsage(ctx, getVolumeMountPoint(mountPoint.Name))
+			if err != nil {
+				return diskUsage{}, fmt.Errorf("volume %s: %w", mountPoint.Name, err)
+			}
+			usage.Volumes = append(usage.Volumes, volumeDiskUsage{Name: mountPoint.Name, Total: total, Used: used})
+		}
+	}
+	return usage, nil
+}
+
+// startDiskMonitor checks disk usage of the running container until the returned function
+// is called, see TaskConfig.DiskMonitor. A failed check is logged and retried on the next tick.
+// The history event is recorded each time the usage goes over a limit, not on each check
+func (d *DockerRunner) startDiskMonitor(ctx context.Context, task *Task) func() {
+	monitor := task.config.DiskMonitor
+	if monitor == nil {
+		return func() {}
+	}
+	// Run() keeps updating the task
+	taskCopy := *task
+	task = &taskCopy
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(monitor.getInterval())
+		defer ticker.Stop()
+		exceeded := false
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			usage, err := d.diskUsage(ctx, task)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				log.Warning(ctx, "failed to check disk usage", "task", task.ID, "err", err)
+				continue
+			}
+			violations := usage.check(*monitor)
+			if len(violations) == 0 {
+				exceeded = false
+				continue
+			}
+			if exceeded {
+				continue
+			}
+			exceeded = true
+			message := strings.Join(violations, "; ")
+			log.Warning(ctx, "disk usage limit exceeded", "task", task.ID, "msg", message, "auto_stop", monitor.AutoStop)
+			d.tasks.RecordEvent(task.ID, TaskHistoryEvent{
+				Type: TaskHistoryEventDiskUsageExceeded, ContainerID: task.containerID, Message: message,
+			})
+			if monitor.AutoStop {
+				// Stopping the container makes Run() call the stop function, stopping
+				// must not be canceled by it
+				err := d.Terminate(context.WithoutCancel(ctx), task.ID, nil, diskQuotaExceededReason, "disk quota exceeded: "+message)
+				if err != nil {
+					log.Error(ctx, "failed to stop task exceeding disk usage limit", "task", task.ID, "err", err)
+				}
+				return
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
@@ -0,0 +1,146 @@
+package shim
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setDiskMonitorTimeUnit(t *testing.T, unit time.Duration) {
+	t.Helper()
+	prev := diskMonitorTimeUnit
+	diskMonitorTimeUnit = unit
+	t.Cleanup(func() { diskMonitorTimeUnit = prev })
+}
+
+// fakeDiskUsage returns samples in order, the last one is repeated
+type fakeDiskUsage struct {
+	mu      sync.Mutex
+	samples []diskUsage
+	errs    []error
+	calls   int
+}
+
+func (f *fakeDiskUsage) get(context.Context, *Task) (diskUsage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	i := min(f.calls, len(f.samples)-1)
+	f.calls++
+	if i < len(f.errs) && f.errs[i] != nil {
+		return diskUsage{}, f.errs[i]
+	}
+	return f.samples[i], nil
+}
+
+func (f *fakeDiskUsage) getCalls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+func getTaskEvents(runner *DockerRunner, taskID string, eventType TaskHistoryEventType) []TaskHistoryEvent {
+	var events []TaskHistoryEvent
+	for _, event := range runner.tasks.Events(taskID) {
+		if event.Type == eventType {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+func TestDockerRunner_DiskMonitor_AutoStop(t *testing.T) {
+	setDiskMonitorTimeUnit(t, time.Millisecond)
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	usage := &fakeDiskUsage{
+		samples: []diskUsage{{WritableLayer: 512}, {}, {WritableLayer: 2048}},
+		// a failed check doesn't stop the monitor
+		errs: []error{nil, errors.New("connection refused")},
+	}
+	runner.diskUsage = usage.get
+	cfg := createTaskConfig(t)
+	cfg.DiskMonitor = &DiskMonitor{MaxWritableLayerSize: 1024, AutoStop: true, Interval: 10}
+	runTask(t, runner, cfg)
+
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusTerminated)
+	info := runner.TaskInfo(cfg.ID)
+	assert.Equal(t, "DISK_QUOTA_EXCEEDED", info.TerminationReason)
+	assert.Equal(t, "disk quota exceeded: writable layer size 2KiB exceeds 1KiB", info.TerminationMessage)
+	events := getTaskEvents(runner, cfg.ID, TaskHistoryEventDiskUsageExceeded)
+	require.Len(t, events, 1)
+	assert.Equal(t, "writable layer size 2KiB exceeds 1KiB", events[0].Message)
+
+	// the monitor stops with the task
+	calls := usage.getCalls()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, calls, usage.getCalls())
+}
+
+func TestDockerRunner_DiskMonitor_AlertOnly(t *testing.T) {
+	setDiskMonitorTimeUnit(t, time.Millisecond)
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	over := diskUsage{WritableLayer: 2048}
+	usage := &fakeDiskUsage{samples: []diskUsage{over, over, {WritableLayer: 512}, over}}
+	runner.diskUsage = usage.get
+	cfg := createTaskConfig(t)
+	cfg.DiskMonitor = &DiskMonitor{MaxWritableLayerSize: 1024, Interval: 10}
+	containerID := runTask(t, runner, cfg)
+
+	// once per breach, not on each check
+	require.Eventually(t, func() bool { return usage.getCalls() > 6 }, 5*time.Second, 10*time.Millisecond)
+	assert.Len(t, getTaskEvents(runner, cfg.ID, TaskHistoryEventDiskUsageExceeded), 2)
+	assert.Equal(t, TaskStatusRunning, runner.TaskInfo(cfg.ID).Status)
+
+	client.exitContainer(containerID, 0)
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusTerminated)
+	assert.Equal(t, "DONE_BY_RUNNER", runner.TaskInfo(cfg.ID).TerminationReason)
+}
+
+func TestDiskUsage_Check(t *testing.T) {
+	usage := diskUsage{
+		WritableLayer: 3 << 30,
+		Volumes: []volumeDiskUsage{
+			{Name: "data", Total: 100, Used: 95},
+			{Name: "cache", Total: 100, Used: 50},
+			// not mounted yet
+			{Name: "empty"},
+		},
+	}
+	assert.Equal(t, []string{
+		"writable layer size 3GiB exceeds 1GiB",
+		"volume data usage 95.0% exceeds 90%",
+	}, usage.check(DiskMonitor{MaxWritableLayerSize: 1 << 30, MaxVolumeUsagePercent: 90}))
+	assert.Equal(t, []string{"volume data usage 95.0% exceeds 90%"}, usage.check(DiskMonitor{MaxVolumeUsagePercent: 90}))
+	assert.Empty(t, usage.check(DiskMonitor{MaxWritableLayerSize: 4 << 30, MaxVolumeUsagePercent: 95}))
+}
+
+func TestValidateDiskMonitor(t *testing.T) {
+	volumes := []VolumeMountPoint{{Name: "data", Path: "/data"}}
+	testCases := []struct {
+		monitor *DiskMonitor
+		volumes []VolumeMountPoint
+		valid   bool
+	}{
+		{nil, nil, true},
+		{&DiskMonitor{MaxWritableLayerSize: 1 << 30}, nil, true},
+		{&DiskMonitor{MaxVolumeUsagePercent: 90, Interval: 10}, volumes, true},
+		{&DiskMonitor{}, nil, false},
+		{&DiskMonitor{MaxVolumeUsagePercent: 101}, volumes, false},
+		{&DiskMonitor{MaxVolumeUsagePercent: 90}, nil, false},
+		{&DiskMonitor{MaxWritableLayerSize: 1 << 30, Interval: 1}, nil, false},
+	}
+	for _, tc := range testCases {
+		err := validateDiskMonitor(TaskConfig{DiskMonitor: tc.monitor, VolumeMounts: tc.volumes})
+		if tc.valid {
+			assert.NoError(t, err, tc.monitor)
+		} else {
+			assert.ErrorIs(t, err, ErrInvalidConfig, tc.monitor)
+		}
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"os/user"
 	"path/filepath"
 	rt "runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -45,59 +46,219 @@ const (
 	// Set to "true" on containers spawned by DockerRunner, used for identification.
 	LabelKeyIsTask = LabelKeyPrefix + "is-task"
 	LabelKeyTaskID = LabelKeyPrefix + "task-id"
-	LabelValueTrue = "true"
+	// Set on containers of tasks sharing GPUs, the value is TaskConfig.GPUMemoryFraction
+	LabelKeyGpuMemoryFraction = LabelKeyPrefix + "gpu-memory-fraction"
+	LabelValueTrue            = "true"
 )
 
 type DockerRunner struct {
-	client       *docker.Client
+	client       docker.APIClient
 	dockerParams DockerParameters
 	dockerInfo   dockersystem.Info
 	gpus         []host.GpuInfo
 	gpuVendor    host.GpuVendor
-	gpuLock      *GpuLock
+	gpuAllocator *GPUAllocator
 	tasks        TaskStorage
+	queue        *taskQueue
+	puller       *imagePuller
+	// nil = signatures are not verified, see verifyImageSignature()
+	signaturePolicy *SignaturePolicy
+	imageVerifier   imageVerifier
+	leases          *taskLeases
+	progress        *taskProgressTracker
+	annotations     *taskAnnotations
+	breaker         *circuitBreaker
+	clock           clock
+	replacing       *taskSet
+	pendingWaits    *pendingWaits
+	// tasks being stopped by Terminate(), see Run()
+	terminating *taskSet
+	// tasks whose startup probe has not passed yet, see watchStartupProbe()
+	startingUp *taskSet
+	// host prerequisites, the shim is not ready until critical checks pass
+	preflight *preflight
+	audit     *auditLog
+	// nil = all tasks are admitted
+	admission *admissionWebhook
+	// see TaskConfig.RestartOnReboot
+	restartIntents *restartIntents
+	// annotations and leases changed by Update()
+	taskStates *taskStates
+	// see TaskConfig.CoreDumps
+	coreDumps *coreDumps
+	// nil = tasks with TaskConfig.Credentials are rejected
+	credentialProvider *credentialProvider
+	credentials        *taskCredentials
+	// nil = telemetry is disabled, see traceTask()
+	telemetry *telemetry
+
+	// hex characters of the container name suffix, see generateUniqueName()
+	nameSuffixLen int
+	// GPU ID: used memory (MiB), nil if not supported by the GPU vendor
+	gpuMemoryUsage func(context.Context) (map[string]int, error)
+	// getDiskUsage, overridden in tests, see startDiskMonitor()
+	diskUsage func(ctx context.Context, task *Task) (diskUsage, error)
+	// GPU ID: PIDs of compute processes, nil if not supported by the GPU vendor, see drainGpus()
+	gpuProcesses          func(context.Context) (map[GPUID][]int, error)
+	gpuDrain              GPUDrainConfig
+	gpuDrainCheckInterval time.Duration
+	killProcess           func(pid int) error
+	// see startCredentialsRefresher()
+	credentialsRetryInterval time.Duration
+	credentialsMinRefresh    time.Duration
+	usageSampleInterval      time.Duration
+	// see Replace()
+	replaceHealthTimeout time.Duration
+	replaceHealthyAfter  time.Duration
+	replaceCheckInterval time.Duration
+	// host.InfinibandSysfsDir and host.InfinibandDevDir, overridden in tests
+	infinibandSysfsDir string
+	infinibandDevDir   string
 }
 
 func NewDockerRunner(ctx context.Context, dockerParams DockerParameters) (*DockerRunner, error) {
-	client, err := docker.NewClientWithOpts(docker.FromEnv, docker.WithAPIVersionNegotiation())
+	client, err := newDockerClient(ctx, dockerParams.DockerEndpoint())
 	if err != nil {
 		return nil, tracerr.Wrap(err)
 	}
+	gpus := host.GetGpuInfo(ctx)
+	if gpuVendor := host.GetGpuVendor(); gpuVendor != host.GpuVendorNone && len(gpus) == 0 {
+		log.Warning(ctx, "GPU devices found, but GPUs cannot be queried, running as a GPU-less host", "vendor", gpuVendor)
+	}
+	runner, err := newDockerRunner(ctx, client, dockerParams, gpus)
+	if err != nil {
+		return nil, err
+	}
+	go runner.watchLeases(ctx)
+	go runner.watchReservations(ctx)
+	go runner.watchPreflight(ctx)
+	return runner, nil
+}
+
+func newDockerRunner(ctx context.Context, client docker.APIClient, dockerParams DockerParameters, gpus []host.GpuInfo) (*DockerRunner, error) {
 	dockerInfo, err := client.Info(ctx)
 	if err != nil {
 		return nil, tracerr.Wrap(err)
 	}
 
 	var gpuVendor host.GpuVendor
-	gpus := host.GetGpuInfo(ctx)
 	if len(gpus) > 0 {
 		gpuVendor = gpus[0].Vendor
 	} else {
 		gpuVendor = host.GpuVendorNone
 	}
-	gpuLock, err := NewGpuLock(gpus)
+	gpuAllocator, err := NewGPUAllocator(gpus)
+	if err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+	nameSuffixLen, err := validateNameSuffixLen(dockerParams.ShimContainerNameHashLength())
+	if err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+	audit, err := newAuditLog(dockerParams.ShimAuditLog(), systemClock{})
+	if err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+	admission, err := newAdmissionWebhook(dockerParams.ShimAdmissionWebhook())
+	if err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+	credentialProvider, err := newCredentialProvider(dockerParams.ShimCredentialProvider())
 	if err != nil {
 		return nil, tracerr.Wrap(err)
 	}
+	telemetry, err := newTelemetry(ctx, dockerParams.ShimTelemetry())
+	if err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+	mirrors, err := parseRegistryMirrors(dockerParams.DockerRegistryMirrors())
+	if err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+	signaturePolicy, err := loadSignaturePolicy(dockerParams.DockerImageSignaturePolicy())
+	if err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+
+	breaker := newCircuitBreaker(
+		systemClock{}, dockerParams.DockerCircuitBreakerThreshold(), dockerParams.DockerCircuitBreakerCooldown(),
+		func(ctx context.Context) error {
+			_, err := client.Ping(ctx)
+			return err
+		},
+	)
+	client = &breakerClient{APIClient: client, breaker: breaker}
+
+	puller := newImagePuller(
+		client, mirrors, dockerParams.DockerRegistryMirrorFallback(), dockerParams.DockerMaxConcurrentPulls(),
+	)
 
 	runner := &DockerRunner{
-		client:       client,
-		dockerParams: dockerParams,
-		dockerInfo:   dockerInfo,
-		gpus:         gpus,
-		gpuVendor:    gpuVendor,
-		gpuLock:      gpuLock,
-		tasks:        NewTaskStorage(),
-	}
+		client:          client,
+		dockerParams:    dockerParams,
+		dockerInfo:      dockerInfo,
+		gpus:            gpus,
+		gpuVendor:       gpuVendor,
+		gpuAllocator:    gpuAllocator,
+		tasks:           NewTaskStorage(),
+		queue:           newTaskQueue(dockerParams.ShimMaxConcurrentTasks()),
+		puller:          puller,
+		signaturePolicy: signaturePolicy,
+		imageVerifier:   cosignVerifier{path: "cosign"},
+		leases:          newTaskLeases(systemClock{}),
+		progress:        newTaskProgressTracker(),
+		annotations:     newTaskAnnotations(),
+		breaker:         breaker,
+		clock:           systemClock{},
+		replacing:       newTaskSet(),
+		pendingWaits:    newPendingWaits(),
+		terminating:     newTaskSet(),
+		startingUp:      newTaskSet(),
+		preflight:       newPreflight(dockerParams.ShimPreflight()),
+		audit:           audit,
+		admission:       admission,
+
+		restartIntents:           newRestartIntents(dockerParams.ShimStateDir()),
+		taskStates:               newTaskStates(dockerParams.ShimStateDir()),
+		coreDumps:                newCoreDumps(dockerParams.ShimCoreDumpDir()),
+		credentialProvider:       credentialProvider,
+		credentials:              newTaskCredentials(),
+		telemetry:                telemetry,
+		gpuDrain:                 dockerParams.ShimGPUDrain(),
+		gpuDrainCheckInterval:    defaultGPUDrainCheckInterval,
+		killProcess:              killProcess,
+		credentialsRetryInterval: defaultCredentialsRetryInterval,
+		credentialsMinRefresh:    defaultMinCredentialsRefreshDelay,
+		nameSuffixLen:            nameSuffixLen,
+		usageSampleInterval:      defaultUsageSampleInterval,
+		replaceHealthTimeout:     defaultReplaceHealthTimeout,
+		replaceHealthyAfter:      defaultReplaceHealthyAfter,
+		replaceCheckInterval:     defaultReplaceCheckInterval,
+		infinibandSysfsDir:       host.InfinibandSysfsDir,
+		infinibandDevDir:         host.InfinibandDevDir,
+	}
+	runner.diskUsage = runner.getDiskUsage
+	if gpuVendor == host.GpuVendorNvidia {
+		runner.gpuMemoryUsage = host.GetNvidiaGpuMemoryUsage
+		runner.gpuProcesses = host.GetNvidiaGpuComputeProcesses
+	}
+
+	runner.coreDumps.CheckCorePattern(ctx)
 
 	if err := runner.restoreStateFromContainers(ctx); err != nil {
 		return nil, tracerr.Errorf("failed to restore state from containers: %w", err)
 	}
+	runner.recoverTasksAfterReboot(ctx)
+	if err := runner.removeOrphanedDockerVolumes(ctx); err != nil {
+		// non-fatal, volumes will be removed on the next start
+		log.Error(ctx, "failed to remove orphaned volumes", "err", err)
+	}
+	runner.checkPreflight(ctx)
 
 	return runner, nil
 }
 
-// restoreStateFromContainers regenerates TaskStorage and GpuLock inspecting containers
+// restoreStateFromContainers regenerates TaskStorage and GPUAllocator inspecting containers
 // Used to restore shim state on restarts
 func (d *DockerRunner) restoreStateFromContainers(ctx context.Context) error {
 	listOptions := container.ListOptions{
@@ -117,7 +278,7 @@ func (d *DockerRunner) restoreStateFromContainers(ctx context.Context) error {
 		}
 		var status TaskStatus
 		if containerShort.State == "exited" {
-			status = TaskStatusTerminated
+			status = getExitedContainerStatus(containerShort.Status)
 		} else {
 			status = TaskStatusRunning
 		}
@@ -161,20 +322,76 @@ func (d *DockerRunner) restoreStateFromContainers(ctx context.Context) error {
 				break
 			}
 		}
+		var gpuMemoryFraction float64
+		if value, ok := containerShort.Labels[LabelKeyGpuMemoryFraction]; ok {
+			if gpuMemoryFraction, err = strconv.ParseFloat(value, 64); err != nil {
+				log.Error(ctx, "invalid label value", "id", containerID, "label", LabelKeyGpuMemoryFraction, "err", err)
+			}
+		}
+		var leaseDuration int
+		if value, ok := containerShort.Labels[LabelKeyLeaseDuration]; ok {
+			if leaseDuration, err = strconv.Atoi(value); err != nil {
+				log.Error(ctx, "invalid label value", "id", containerID, "label", LabelKeyLeaseDuration, "err", err)
+			}
+		}
+		// the label keeps the submitted value, the state is changed by Update()
+		state, hasState, err := d.taskStates.Load(taskID)
+		if err != nil {
+			log.Error(ctx, "failed to load task state", "task", taskID, "err", err)
+		} else if hasState {
+			leaseDuration = int(state.LeaseDuration)
+		}
 		task := NewTask(taskID, status, containerName, containerID, gpuIDs, ports, runnerDir)
-		if !d.tasks.Add(task) {
-			log.Error(ctx, "duplicate restored task", "task", taskID)
+		task.gpuMemoryFraction = gpuMemoryFraction
+		// config fields restored from labels, the rest of the config is lost
+		task.config.RunID = containerShort.Labels[LabelKeyRunID]
+		task.config.TraceID = containerShort.Labels[LabelKeyTraceID]
+		mpsLimits := parseMPSLabels(ctx, containerID, containerShort.Labels)
+		task.config.MPSThreadPercentage, task.config.MPSMemoryLimitMB = mpsLimits.ThreadPercentage, mpsLimits.MemoryMB
+		if value, ok := containerShort.Labels[LabelKeyStopSignals]; ok {
+			if task.config.StopSignals, err = parseStopSignals(value); err != nil {
+				log.Error(ctx, "invalid label value", "id", containerID, "label", LabelKeyStopSignals, "err", err)
+			}
+		}
+		task.containerStarted = status == TaskStatusRunning
+		if status.IsFinished() {
+			// the dir is only created for tasks with TaskConfig.CoreDumps
+			if task.coreDumps, err = d.coreDumps.Collect(taskID); err != nil {
+				log.Error(ctx, "failed to collect core dumps", "task", taskID, "err", err)
+			}
+		}
+		if err := d.tasks.Add(task); err != nil {
+			log.Error(ctx, "failed to add restored task", "task", taskID, "err", err)
 		} else {
 			log.Debug(ctx, "restored task", "task", taskID, "status", status, "gpus", gpuIDs)
+			if hasState {
+				d.annotations.Set(taskID, state.Annotations)
+			}
+			if status == TaskStatusRunning && leaseDuration > 0 {
+				// the expiration time is not persisted, the server gets the full lease
+				// to reconnect after the shim restart
+				expiresAt := d.leases.Start(taskID, time.Duration(leaseDuration)*time.Second)
+				log.Debug(ctx, "restored task lease", "task", taskID, "expires", expiresAt)
+			}
 		}
 		if status == TaskStatusRunning && len(gpuIDs) > 0 {
-			lockedGpuIDs := d.gpuLock.Lock(ctx, gpuIDs)
-			log.Debug(ctx, "locked GPU(s) due to running task", "task", taskID, "gpus", lockedGpuIDs)
+			restoredGpuIDs := d.gpuAllocator.Restore(ctx, taskID, gpuIDs, gpuMemoryFraction, mpsLimits)
+			log.Debug(ctx, "restored GPU allocation of running task", "task", taskID, "gpus", restoredGpuIDs, "fraction", gpuMemoryFraction)
 		}
 	}
 	return nil
 }
 
+// getExitedContainerStatus returns the status of the restored task by the container status
+// description, e.g., "Exited (1) 5 minutes ago". Termination reasons are not restored
+func getExitedContainerStatus(description string) TaskStatus {
+	var exitCode int
+	if _, err := fmt.Sscanf(description, "Exited (%d)", &exitCode); err == nil && exitCode != 0 {
+		return TaskStatusFailed
+	}
+	return TaskStatusTerminated
+}
+
 func (d *DockerRunner) Resources(ctx context.Context) Resources {
 	cpuCount := host.GetCpuCount(ctx)
 	totalMemory, err := host.GetTotalMemory(ctx)
@@ -195,6 +412,7 @@ func (d *DockerRunner) Resources(ctx context.Context) Resources {
 		TotalMemory:  totalMemory,
 		DiskSize:     diskSize,
 		NetAddresses: netAddresses,
+		RDMA:         d.getRDMAInfo(ctx),
 	}
 }
 
@@ -202,12 +420,18 @@ func (d *DockerRunner) TaskIDs() []string {
 	return d.tasks.IDs()
 }
 
+// TaskConfig returns the config the task has been submitted or replaced with, see Replace()
+func (d *DockerRunner) TaskConfig(taskID string) (TaskConfig, bool) {
+	task, ok := d.tasks.Get(taskID)
+	return task.config, ok
+}
+
 func (d *DockerRunner) TaskInfo(taskID string) TaskInfo {
 	task, ok := d.tasks.Get(taskID)
 	if !ok {
 		return TaskInfo{}
 	}
-	return TaskInfo{
+	taskInfo := TaskInfo{
 		ID:                 task.ID,
 		Status:             task.Status,
 		TerminationReason:  task.TerminationReason,
@@ -216,13 +440,117 @@ func (d *DockerRunner) TaskInfo(taskID string) TaskInfo {
 		ContainerName:      task.containerName,
 		ContainerID:        task.containerID,
 		GpuIDs:             task.gpuIDs,
+		QueuedDuration:     task.QueuedDuration(d.clock.Now()).Seconds(),
+		Diagnostics:        task.diagnostics,
+		ResourceSummary:    task.resourceSummary,
+		Progress:           d.getTaskProgress(task),
+		Annotations:        d.annotations.Get(task.ID),
+		Events:             d.tasks.Events(task.ID),
+		CoreDumps:          task.coreDumps,
+		TraceID:            task.config.TraceID,
+		Credentials:        d.credentials.Get(task.ID),
+	}
+	if duration, expiresAt, ok := d.leases.Get(task.ID); ok {
+		taskInfo.LeaseDuration = uint(duration.Seconds())
+		taskInfo.LeaseExpiresAt = &expiresAt
+	}
+	return taskInfo
+}
+
+// Wait blocks until the task is terminated or the timeout expires, whichever comes first,
+// and returns the task info at that moment, that is, on timeout the task is not terminated yet.
+// It returns ErrNotFound if the task doesn't exist or is removed while waiting
+func (d *DockerRunner) Wait(ctx context.Context, taskID string, timeout time.Duration) (TaskInfo, error) {
+	// Subscribe before the first check, otherwise the update may be missed
+	updates, unsubscribe := d.tasks.Subscribe(taskID)
+	defer unsubscribe()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		taskInfo := d.TaskInfo(taskID)
+		if taskInfo.ID == "" {
+			return TaskInfo{}, fmt.Errorf("task %s: %w", taskID, ErrNotFound)
+		}
+		if taskInfo.Status.IsFinished() {
+			return taskInfo, nil
+		}
+		select {
+		case <-updates:
+		case <-timer.C:
+			return taskInfo, nil
+		case <-ctx.Done():
+			return TaskInfo{}, tracerr.Wrap(ctx.Err())
+		}
 	}
 }
 
+// Ready returns ErrHostUnavailable if new tasks cannot be accepted: critical preflight checks
+// have failed, see checkPreflight(), or the Docker daemon is unhealthy, see circuitBreaker
+func (d *DockerRunner) Ready(ctx context.Context) error {
+	if err := d.preflight.Err(); err != nil {
+		return err
+	}
+	return d.breaker.Allow(ctx)
+}
+
 func (d *DockerRunner) Submit(ctx context.Context, cfg TaskConfig) error {
+	if err := d.breaker.Allow(ctx); err != nil {
+		return tracerr.Wrap(err)
+	}
+	if cfg.TraceID == "" {
+		cfg.TraceID = generateTraceID()
+	}
+	ctx = withTraceID(ctx, cfg.TraceID)
+	if err := d.validateTaskConfig(cfg); err != nil {
+		return tracerr.Wrap(err)
+	}
+	if err := d.admission.Admit(ctx, cfg); err != nil {
+		return tracerr.Wrap(err)
+	}
 	task := NewTaskFromConfig(cfg)
-	if ok := d.tasks.Add(task); !ok {
-		return tracerr.Errorf("%w: task %s is already submitted", ErrRequest, task.ID)
+	task.submittedAt = d.clock.Now()
+	task.containerName = generateUniqueName(cfg.Name, cfg.ID, d.nameSuffixLen)
+	if cfg.GPUReservation != "" {
+		// The reservation could expire after validation
+		gpuIDs, err := d.gpuAllocator.Consume(cfg.GPUReservation, task.ID, d.clock.Now())
+		if err != nil {
+			return tracerr.Wrap(err)
+		}
+		task.gpuIDs = gpuIDs
+		log.Debug(ctx, "consumed GPU reservation", "task", task.ID, "reservation", cfg.GPUReservation, "gpus", gpuIDs)
+	}
+	if err := d.tasks.Add(task); err != nil {
+		// The reservation is consumed anyway
+		d.releaseGpus(ctx, &task)
+		return tracerr.Wrap(err)
+	}
+	if cfg.RestartOnReboot {
+		if err := d.restartIntents.Save(cfg); err != nil {
+			d.tasks.Delete(task.ID)
+			d.releaseGpus(ctx, &task)
+			return tracerr.Errorf("%w: task %s: failed to persist restart intent: %w", ErrInternal, task.ID, err)
+		}
+	}
+	if len(cfg.Annotations) > 0 || cfg.LeaseDuration > 0 {
+		state := taskState{Annotations: cfg.Annotations, LeaseDuration: cfg.LeaseDuration}
+		if err := d.taskStates.Save(task.ID, state); err != nil {
+			d.tasks.Delete(task.ID)
+			d.releaseGpus(ctx, &task)
+			d.deleteRestartIntent(ctx, task.ID)
+			return tracerr.Errorf("%w: task %s: failed to persist task state: %w", ErrInternal, task.ID, err)
+		}
+	}
+	if cfg.LeaseDuration > 0 {
+		expiresAt := d.leases.Start(task.ID, time.Duration(cfg.LeaseDuration)*time.Second)
+		log.Debug(ctx, "lease started", "task", task.ID, "expires", expiresAt)
+	}
+	d.annotations.Set(task.ID, cfg.Annotations)
+	if logDriver := d.getEffectiveLogDriver(cfg); !isLogDriverReadable(logDriver) {
+		log.Warning(ctx, "container logs cannot be read back, exit error details won't be available", "task", task.ID, "driver", logDriver)
+	}
+	d.audit.Record(ctx, AuditRecord{Action: AuditActionSubmit, TaskID: task.ID, TraceID: cfg.TraceID, Config: redactTaskConfig(cfg)})
+	if d.telemetry != nil {
+		go d.traceTask(task)
 	}
 	log.Debug(ctx, "new task submitted", "task", task.ID)
 	return nil
@@ -234,20 +562,75 @@ func (d *DockerRunner) Run(ctx context.Context, taskID string) error {
 		log.Error(ctx, "cannot run: not found", "task", taskID)
 		return fmt.Errorf("task %s: %w", taskID, ErrNotFound)
 	}
+	ctx = withTraceID(ctx, task.config.TraceID)
 
 	if task.Status != TaskStatusPending {
 		return fmt.Errorf("%w: cannot run task %s with %s status", ErrRequest, task.ID, task.Status)
 	}
 
+	pendingCtx, cancelPending := withPendingTimeout(ctx, task, d.clock.Now())
+	defer cancelPending()
+	pendingCtx = d.pendingWaits.Start(pendingCtx, task.ID)
+	defer d.pendingWaits.Stop(task.ID)
+
+	// The task stays pending until its dependencies finish successfully, without taking a queue slot
+	if len(task.config.DependsOn) > 0 {
+		log.Debug(ctx, "waiting for dependencies", "task", task.ID, "dependencies", task.config.DependsOn)
+		if err := d.waitDependencies(pendingCtx, task.ID, task.config.DependsOn); err != nil {
+			d.terminateOnPendingTimeout(ctx, pendingCtx, task.ID)
+			return tracerr.Wrap(err)
+		}
+	}
+
+	// If the number of concurrently running tasks is limited, wait for a free slot.
+	// The task stays pending while waiting in the queue
+	if err := d.queue.Acquire(pendingCtx); err != nil {
+		if errors.Is(context.Cause(pendingCtx), errTerminatedWhilePending) {
+			log.Debug(ctx, "task terminated while waiting in queue", "task", task.ID)
+			return nil
+		}
+		d.terminateOnPendingTimeout(ctx, pendingCtx, task.ID)
+		return tracerr.Errorf("%w: task %s: failed to wait in queue: %w", ErrInternal, task.ID, err)
+	}
+	defer d.queue.Release()
+	d.pendingWaits.Stop(task.ID)
+	// The task could be terminated while waiting for dependencies or in the queue
+	task, ok = d.tasks.Get(taskID)
+	if !ok || task.Status != TaskStatusPending {
+		log.Debug(ctx, "task is gone or no longer pending after queue", "task", taskID)
+		return nil
+	}
+	task.startedAt = d.clock.Now()
+	taskQueueSeconds.Observe(task.QueuedDuration(task.startedAt).Seconds())
+	log.Debug(ctx, "task left the queue", "task", task.ID, "queued", task.QueuedDuration(task.startedAt))
+
 	defer func() {
+		// The container exits with non-zero code (or the pull is aborted) when the task is
+		// stopped intentionally, Run() may notice it before Terminate() commits the status
+		stoppedByShim := d.terminating.Has(task.ID)
+		if task.Status == TaskStatusFailed && stoppedByShim {
+			task.Status = TaskStatusTerminated
+		}
 		if err := d.tasks.Update(task); err != nil {
 			if currentTask, ok := d.tasks.Get(task.ID); ok && currentTask.Status != task.Status {
 				// ignore error if task is gone or status has not changed, e.g., terminated -> terminated
 				log.Error(ctx, "failed to update", "task", task.ID, "err", err)
 			}
+		} else if task.Status.IsFinished() && !stoppedByShim {
+			// Finished on its own, otherwise Terminate() decides
+			d.deleteRestartIntent(ctx, task.ID)
 		}
 	}()
 
+	// Resources are added to the cleanup as they are acquired, and released in cleanupOrder
+	// once the task is finished, before the final status is committed
+	var cleanup []cleanupStep
+	defer func() { _ = d.runCleanup(ctx, task.ID, cleanup) }()
+	releaseGpus := cleanupStep{name: cleanupStepReleaseGpus, run: func(ctx context.Context) error {
+		d.releaseGpus(ctx, &task)
+		return nil
+	}}
+
 	task.SetStatusPreparing()
 	if err := d.tasks.Update(task); err != nil {
 		return tracerr.Errorf("%w: failed to update task %s: %w", ErrInternal, task.ID, err)
@@ -256,20 +639,26 @@ func (d *DockerRunner) Run(ctx context.Context, taskID string) error {
 	cfg := task.config
 	var err error
 
-	if cfg.GPU != 0 {
-		gpuIDs, err := d.gpuLock.Acquire(ctx, cfg.GPU)
+	if len(task.gpuIDs) > 0 {
+		// Already allocated by the GPU reservation, see Submit()
+		cleanup = append(cleanup, releaseGpus)
+	} else if cfg.GPU != 0 {
+		var gpuIDs []string
+		gpuIDs, err = d.gpuAllocator.Allocate(ctx, GPURequest{
+			TaskID:         task.ID,
+			Count:          cfg.GPU,
+			MemoryFraction: task.gpuMemoryFraction,
+			MPS:            getTaskMPSLimits(cfg),
+		})
 		if err != nil {
 			log.Error(ctx, err.Error())
-			task.SetStatusTerminated("EXECUTOR_ERROR", err.Error())
+			task.SetStatusFailed("EXECUTOR_ERROR", err.Error())
 			return tracerr.Wrap(err)
 		}
 		task.gpuIDs = gpuIDs
-		log.Debug(ctx, "acquired GPU(s)", "task", task.ID, "gpus", gpuIDs)
+		log.Debug(ctx, "allocated GPU(s)", "task", task.ID, "gpus", gpuIDs, "fraction", task.gpuMemoryFraction)
 
-		defer func() {
-			releasedGpuIDs := d.gpuLock.Release(ctx, task.gpuIDs)
-			log.Debug(ctx, "released GPU(s)", "task", task.ID, "gpus", releasedGpuIDs)
-		}()
+		cleanup = append(cleanup, releaseGpus)
 	} else {
 		task.gpuIDs = []string{}
 	}
@@ -279,34 +668,33 @@ func (d *DockerRunner) Run(ctx context.Context, taskID string) error {
 		if err := ak.AppendPublicKeys(cfg.HostSshKeys); err != nil {
 			errMessage := fmt.Sprintf("ak.AppendPublicKeys error: %s", err.Error())
 			log.Error(ctx, errMessage)
-			task.SetStatusTerminated("EXECUTOR_ERROR", errMessage)
+			task.SetStatusFailed("EXECUTOR_ERROR", errMessage)
 			return tracerr.Wrap(err)
 		}
-		defer func(cfg TaskConfig) {
-			err := ak.RemovePublicKeys(cfg.HostSshKeys)
-			if err != nil {
-				log.Error(ctx, "Error RemovePublicKeys", "err", err)
-			}
-		}(cfg)
+		cleanup = append(cleanup, cleanupStep{name: cleanupStepRemoveAuthorizedKeys, run: func(context.Context) error {
+			return ak.RemovePublicKeys(cfg.HostSshKeys)
+		}})
 	}
 
 	log.Debug(ctx, "Preparing volumes")
-	// defer unmountVolumes() before calling prepareVolumes(), as the latter
+	// add unmountVolumes() before calling prepareVolumes(), as the latter
 	// may fail when some volumes are already mounted; if the volume is not mounted,
 	// unmountVolumes() simply skips it
-	defer func() { _ = unmountVolumes(ctx, cfg) }()
+	cleanup = append(cleanup, cleanupStep{name: cleanupStepUnmountVolumes, run: func(ctx context.Context) error {
+		return unmountVolumes(ctx, cfg)
+	}})
 	err = prepareVolumes(ctx, cfg)
 	if err != nil {
 		errMessage := fmt.Sprintf("prepareVolumes error: %s", err.Error())
 		log.Error(ctx, errMessage)
-		task.SetStatusTerminated("EXECUTOR_ERROR", errMessage)
+		task.SetStatusFailed("EXECUTOR_ERROR", errMessage)
 		return tracerr.Wrap(err)
 	}
 	err = prepareInstanceMountPoints(cfg)
 	if err != nil {
 		errMessage := fmt.Sprintf("prepareInstanceMountPoints error: %s", err.Error())
 		log.Error(ctx, errMessage)
-		task.SetStatusTerminated("EXECUTOR_ERROR", errMessage)
+		task.SetStatusFailed("EXECUTOR_ERROR", errMessage)
 		return tracerr.Wrap(err)
 	}
 
@@ -317,10 +705,22 @@ func (d *DockerRunner) Run(ctx context.Context, taskID string) error {
 	if err := d.tasks.Update(task); err != nil {
 		return tracerr.Errorf("%w: failed to update task %s: %w", ErrInternal, task.ID, err)
 	}
-	if err = pullImage(pullCtx, d.client, cfg); err != nil {
+	if err = d.puller.Pull(pullCtx, cfg); err != nil {
+		if isPlatformMismatchError(err) {
+			errMessage := d.getPlatformMismatchMessage(ctx, cfg)
+			log.Error(ctx, errMessage, "err", err)
+			task.SetStatusFailed("IMAGE_PLATFORM_MISMATCH", errMessage)
+			return tracerr.Wrap(err)
+		}
 		errMessage := fmt.Sprintf("pullImage error: %s", err.Error())
 		log.Error(ctx, errMessage)
-		task.SetStatusTerminated("CREATING_CONTAINER_ERROR", errMessage)
+		task.SetStatusFailed("CREATING_CONTAINER_ERROR", errMessage)
+		return tracerr.Wrap(err)
+	}
+
+	if err := d.verifyImageSignature(ctx, cfg); err != nil {
+		log.Error(ctx, "image signature verification failed", "task", task.ID, "err", err)
+		task.SetStatusFailed("IMAGE_SIGNATURE_VERIFICATION_FAILED", err.Error())
 		return tracerr.Wrap(err)
 	}
 
@@ -330,9 +730,15 @@ func (d *DockerRunner) Run(ctx context.Context, taskID string) error {
 		return tracerr.Errorf("%w: failed to update task %s: %w", ErrInternal, task.ID, err)
 	}
 	if err := d.createContainer(ctx, &task); err != nil {
+		if isPlatformMismatchError(err) {
+			errMessage := d.getPlatformMismatchMessage(ctx, cfg)
+			log.Error(ctx, errMessage, "err", err)
+			task.SetStatusFailed("IMAGE_PLATFORM_MISMATCH", errMessage)
+			return tracerr.Wrap(err)
+		}
 		errMessage := fmt.Sprintf("createContainer error: %s", err.Error())
 		log.Error(ctx, errMessage)
-		task.SetStatusTerminated("CREATING_CONTAINER_ERROR", errMessage)
+		task.SetStatusFailed("CREATING_CONTAINER_ERROR", errMessage)
 		return tracerr.Wrap(err)
 	}
 
@@ -342,23 +748,67 @@ func (d *DockerRunner) Run(ctx context.Context, taskID string) error {
 		return tracerr.Errorf("%w: failed to update task %s: %w", ErrInternal, task.ID, err)
 	}
 	err = d.startContainer(ctx, &task)
+	startErr := err
 	if err == nil {
+		task.containerStarted = true
 		// startContainer sets `ports` field, committing update
 		if err := d.tasks.Update(task); err != nil {
 			return tracerr.Errorf("%w: failed to update task %s: %w", ErrInternal, task.ID, err)
 		}
-		err = d.waitContainer(ctx, &task)
+		d.audit.Record(ctx, AuditRecord{Action: AuditActionStart, TaskID: task.ID, TraceID: task.config.TraceID, ContainerID: task.containerID})
+		d.tasks.RecordEvent(task.ID, TaskHistoryEvent{Type: TaskHistoryEventContainerStarted, ContainerID: task.containerID})
+		var startupProbeErr error
+		waitStartupProbe := d.watchStartupProbe(ctx, &task)
+		for {
+			sampler := d.startUsageSampler(ctx, &task)
+			stopRefresher := d.startCredentialsRefresher(ctx, &task)
+			stopDiskMonitor := d.startDiskMonitor(ctx, &task)
+			err = d.waitContainer(ctx, &task)
+			startupProbeErr = waitStartupProbe()
+			stopDiskMonitor()
+			stopRefresher()
+			summary := sampler.Stop()
+			task.resourceSummary = &summary
+			log.Debug(ctx, "resource usage", "task", task.ID, "summary", summary)
+			// A process may be OOM killed while the container exits with any code, even 0
+			if oomKilled := d.recordContainerExit(ctx, &task); oomKilled && err == nil {
+				err = errors.New("container was OOM killed")
+			}
+			// The container exited because it has been replaced, waiting for the new one, see Replace()
+			if startupProbeErr != nil || !d.adoptReplacement(&task) {
+				break
+			}
+			log.Debug(ctx, "Waiting for replacement container", "task", task.ID, "name", task.containerName)
+		}
+		if startupProbeErr != nil {
+			err = startupProbeErr
+		}
+	}
+	if task.config.CoreDumps && task.containerID != "" {
+		d.collectCoreDumps(ctx, &task)
+	}
+	if errors.Is(err, errStartupProbeFailed) {
+		task.SetStatusFailed("STARTUP_PROBE_FAILED", err.Error())
+		return tracerr.Wrap(err)
 	}
 	if err != nil {
 		log.Error(ctx, "failed to run container", "err", err)
+		if diagnostics := d.getStartupDiagnostics(ctx, &task, startErr); diagnostics != nil {
+			log.Error(ctx, "container failed to start", "task", task.ID, "exit_code", diagnostics.ExitCode, "error", diagnostics.Error)
+			task.diagnostics = diagnostics
+			task.SetStatusFailed("CONTAINER_EXITED_WITH_ERROR", diagnostics.Message())
+			return tracerr.Wrap(err)
+		}
 		var errMessage string
-		if lastLogs, err := getContainerLastLogs(ctx, d.client, task.containerID, 5); err == nil {
+		if logDriver := d.getEffectiveLogDriver(task.config); !isLogDriverReadable(logDriver) {
+			log.Warning(ctx, "skip getting container logs: not supported by log driver", "task", task.ID, "driver", logDriver)
+		} else if lastLogs, err := getContainerLastLogs(ctx, d.client, task.containerID, 5, task.config.TTY); err == nil {
 			errMessage = strings.Join(lastLogs, "\n")
 		} else {
 			log.Error(ctx, "getContainerLastLogs error", "err", err)
 			errMessage = ""
 		}
-		task.SetStatusTerminated("CONTAINER_EXITED_WITH_ERROR", errMessage)
+		task.SetStatusFailed("CONTAINER_EXITED_WITH_ERROR", errMessage)
 		return tracerr.Wrap(err)
 	}
 
@@ -370,23 +820,64 @@ func (d *DockerRunner) Run(ctx context.Context, taskID string) error {
 
 // Terminate aborts running operations (pulling an image, running a container) and sets task status to terminated
 // Associated resources (container, logs, etc.) are not destroyed, use Remove() for cleanup
-func (d *DockerRunner) Terminate(ctx context.Context, taskID string, timeout uint, reason string, message string) (err error) {
+// timeout, if not nil, overrides TaskConfig.StopTimeout for this call, zero means "kill immediately"
+func (d *DockerRunner) Terminate(ctx context.Context, taskID string, timeout *uint, reason string, message string) error {
+	_, err := d.terminateTask(ctx, taskID, timeout, reason, message)
+	return err
+}
+
+// terminateTask is the same as Terminate, but also reports whether the task was already finished,
+// in which case terminating it was a no-op
+func (d *DockerRunner) terminateTask(ctx context.Context, taskID string, timeout *uint, reason string, message string) (wasTerminated bool, err error) {
 	task, ok := d.tasks.Get(taskID)
 	if !ok {
 		log.Error(ctx, "cannot terminate task: not found", "task", taskID)
-		return fmt.Errorf("task %s: %w", taskID, ErrNotFound)
+		return false, fmt.Errorf("task %s: %w", taskID, ErrNotFound)
+	}
+	ctx = withTraceID(ctx, task.config.TraceID)
+	// GPUs are released once the lock is released, as draining them may take up to
+	// the drain timeout, see drainGpus()
+	var terminated *Task
+	defer func() {
+		if terminated != nil {
+			d.releaseGpus(ctx, terminated)
+		}
+	}()
+	locked := task
+	locked.Lock(ctx)
+	defer func() { locked.Release(ctx) }()
+	// The task may have been terminated or removed concurrently, e.g., by the lease watcher,
+	// while waiting for the lock
+	if task, ok = d.tasks.Get(taskID); !ok || task.mu != locked.mu {
+		return false, fmt.Errorf("task %s: %w", taskID, ErrNotFound)
+	}
+	if task.Status.IsFinished() {
+		if reason != shutdownReason {
+			d.deleteRestartIntent(ctx, task.ID)
+		}
+		return true, nil
 	}
-	task.Lock(ctx)
-	defer func() { task.Release(ctx) }()
+	d.terminating.Add(task.ID)
+	defer d.terminating.Delete(task.ID)
 	defer func() {
 		if err := d.tasks.Update(task); err != nil {
 			log.Error(ctx, "failed to update task", "task", task.ID, "err", err)
 		}
 	}()
-	return d.terminate(ctx, &task, timeout, reason, message)
+	if err := d.terminate(ctx, &task, timeout, reason, message); err != nil {
+		return false, err
+	}
+	terminated = &task
+	d.audit.Record(ctx, AuditRecord{
+		Action: AuditActionStop, TaskID: task.ID, TraceID: task.config.TraceID, ContainerID: task.containerID, Reason: reason,
+	})
+	if reason != shutdownReason {
+		d.deleteRestartIntent(ctx, task.ID)
+	}
+	return false, nil
 }
 
-func (d *DockerRunner) terminate(ctx context.Context, task *Task, timeout uint, reason string, message string) (err error) {
+func (d *DockerRunner) terminate(ctx context.Context, task *Task, timeout *uint, reason string, message string) (err error) {
 	log.Debug(ctx, "terminating", "task", task.ID)
 	defer func() {
 		if err != nil {
@@ -397,29 +888,145 @@ func (d *DockerRunner) terminate(ctx context.Context, task *Task, timeout uint,
 		return fmt.Errorf("%w: cannot terminate task %s with %s status", ErrRequest, task.ID, task.Status)
 	}
 	switch task.Status {
-	case TaskStatusPending, TaskStatusPreparing, TaskStatusCreating, TaskStatusTerminated:
+	case TaskStatusFailed:
+		// nothing to do, the status is kept
+		return nil
+	case TaskStatusPending:
+		d.pendingWaits.Cancel(task.ID)
+	case TaskStatusPreparing, TaskStatusCreating, TaskStatusTerminated:
 		// nothing to do
 	case TaskStatusPulling:
 		task.cancelPull()
 	case TaskStatusRunning:
-		stopOptions := container.StopOptions{}
-		timeout := int(timeout)
-		stopOptions.Timeout = &timeout
-		if err := d.client.ContainerStop(ctx, task.containerID, stopOptions); err != nil {
+		if err := d.stopContainer(ctx, task, timeout); err != nil {
 			return fmt.Errorf("%w: failed to stop container: %w", ErrInternal, err)
 		}
 	default:
 		return fmt.Errorf("%w: should not reach here", ErrInternal)
 	}
-	if len(task.gpuIDs) > 0 {
-		releasedGpuIDs := d.gpuLock.Release(ctx, task.gpuIDs)
-		log.Debug(ctx, "released GPU(s)", "task", task.ID, "gpus", releasedGpuIDs)
-	}
 	task.SetStatusTerminated(reason, message)
+	if task.finishedAt.IsZero() {
+		task.finishedAt = d.clock.Now()
+	}
 	log.Debug(ctx, "terminated", "task", task.ID)
 	return nil
 }
 
+// releaseGpus releases GPUs allocated to the task, either exclusively or shared,
+// once exclusive GPUs are drained, see drainGpus()
+// It's safe to call it multiple times
+func (d *DockerRunner) releaseGpus(ctx context.Context, task *Task) {
+	d.drainGpus(ctx, task)
+	if releasedGpuIDs := d.gpuAllocator.Release(ctx, task.ID); len(releasedGpuIDs) > 0 {
+		log.Debug(ctx, "released GPU(s)", "task", task.ID, "gpus", releasedGpuIDs)
+	}
+}
+
+// validateTaskConfig checks the parts of the config that can be checked before running the task
+func (d *DockerRunner) validateTaskConfig(cfg TaskConfig) error {
+	if cfg.GPUMemoryFraction != 0 {
+		if cfg.GPUMemoryFraction < 0 || cfg.GPUMemoryFraction > 1 {
+			return fmt.Errorf("%w: gpu_memory_fraction must be in (0.0, 1.0] range, got %v", ErrInvalidConfig, cfg.GPUMemoryFraction)
+		}
+		if cfg.GPU == 0 {
+			return fmt.Errorf("%w: gpu_memory_fraction is set, but no GPUs requested", ErrInvalidConfig)
+		}
+	}
+	if err := d.validateMPSLimits(cfg); err != nil {
+		return err
+	}
+	if err := d.validateRDMA(cfg); err != nil {
+		return err
+	}
+	if cfg.OOMScoreAdj < -1000 || cfg.OOMScoreAdj > 1000 {
+		return fmt.Errorf("%w: oom_score_adj must be in -1000..1000 range, got %d", ErrInvalidConfig, cfg.OOMScoreAdj)
+	}
+	if _, err := getSecurityOpts(cfg, d.dockerParams.DockerAllowUnconfined()); err != nil {
+		return err
+	}
+	if _, err := getLogConfig(cfg); err != nil {
+		return err
+	}
+	if _, err := parsePlatform(cfg.Platform); err != nil {
+		return err
+	}
+	if _, err := getDockerSocketMount(cfg, d.dockerParams.DockerAllowDockerSocket()); err != nil {
+		return err
+	}
+	if err := validateCgroupParent(cfg.CgroupParent); err != nil {
+		return err
+	}
+	if err := d.validateCpuset(cfg); err != nil {
+		return err
+	}
+	if err := d.validateDependencies(cfg); err != nil {
+		return err
+	}
+	privileged := cfg.Privileged || d.dockerParams.DockerPrivileged()
+	if _, err := getUsernsMode(cfg, privileged, isUsernsRemapEnabled(d.dockerInfo)); err != nil {
+		return err
+	}
+	if err := validateShutdownBehavior(cfg.ShutdownBehavior); err != nil {
+		return err
+	}
+	if err := validateStopSignals(cfg.StopSignals); err != nil {
+		return err
+	}
+	if err := validateTraceID(cfg.TraceID); err != nil {
+		return err
+	}
+	if err := validateStartupProbe(cfg.StartupProbe); err != nil {
+		return err
+	}
+	if err := validateDiskMonitor(cfg); err != nil {
+		return err
+	}
+	if err := validatePullPolicy(cfg.PullPolicy); err != nil {
+		return err
+	}
+	if cfg.RestartOnReboot && !d.restartIntents.Enabled() {
+		return fmt.Errorf("%w: restart_on_reboot is set, but the shim state dir is not configured", ErrInvalidConfig)
+	}
+	if cfg.CoreDumps && !d.coreDumps.Enabled() {
+		return fmt.Errorf("%w: core_dumps is set, but the shim core dump dir is not configured", ErrInvalidConfig)
+	}
+	if err := validateAnnotations(cfg.Annotations); err != nil {
+		return err
+	}
+	if err := validateLabels(cfg.Labels); err != nil {
+		return err
+	}
+	if err := validateEnv(cfg.Env); err != nil {
+		return err
+	}
+	if err := validateTaskCredentials(cfg.Credentials, d.credentialProvider); err != nil {
+		return err
+	}
+	if err := validateInlineFiles(cfg.Files); err != nil {
+		return err
+	}
+	if err := d.validateGpuReservation(cfg); err != nil {
+		return err
+	}
+	// The IPC mode is only known at container creation, see configureGpus()
+	if _, err := getSysctls(cfg, false); err != nil {
+		return err
+	}
+	if len(cfg.GPUCapabilities) > 0 {
+		if cfg.GPU == 0 {
+			return fmt.Errorf("%w: gpu_capabilities is set, but no GPUs requested", ErrInvalidConfig)
+		}
+		if _, err := getNvidiaDriverCapabilities(cfg.GPUCapabilities); err != nil {
+			return err
+		}
+	}
+	// -1 means "all available", which is zero on a GPU-less host
+	if cfg.GPU > 0 && len(d.gpus) == 0 {
+		return fmt.Errorf("%w: %d GPUs requested, but there are no GPUs on this host", ErrInvalidConfig, cfg.GPU)
+	}
+	return nil
+}
+
 // Remove destroys resources associated with task (container, logs, etc.), if any
 // On success, it also removes the task from TaskStorage
 func (d *DockerRunner) Remove(ctx context.Context, taskID string) error {
@@ -428,11 +1035,26 @@ func (d *DockerRunner) Remove(ctx context.Context, taskID string) error {
 		log.Error(ctx, "cannot remove: not found", "task", taskID)
 		return fmt.Errorf("task %s: %w", taskID, ErrNotFound)
 	}
-	task.Lock(ctx)
-	defer func() { task.Release(ctx) }()
+	ctx = withTraceID(ctx, task.config.TraceID)
+	locked := task
+	locked.Lock(ctx)
+	defer func() { locked.Release(ctx) }()
+	// The task may have been terminated or removed concurrently while waiting for the lock
+	if task, ok = d.tasks.Get(taskID); !ok || task.mu != locked.mu {
+		return fmt.Errorf("task %s: %w", taskID, ErrNotFound)
+	}
 	err := d.remove(ctx, &task)
 	if err == nil {
 		d.tasks.Delete(taskID)
+		d.leases.Delete(taskID)
+		d.progress.Delete(taskID)
+		d.annotations.Delete(taskID)
+		d.credentials.Delete(taskID)
+		d.deleteRestartIntent(ctx, taskID)
+		if err := d.taskStates.Delete(taskID); err != nil {
+			log.Error(ctx, "failed to delete task state", "task", taskID, "err", err)
+		}
+		d.audit.Record(ctx, AuditRecord{Action: AuditActionRemove, TaskID: taskID, TraceID: task.config.TraceID, ContainerID: task.containerID})
 	}
 	return err
 }
@@ -444,31 +1066,46 @@ func (d *DockerRunner) remove(ctx context.Context, task *Task) (err error) {
 			log.Error(ctx, "cannot remove", "task", task.ID, "err", err)
 		}
 	}()
-	if task.Status != TaskStatusTerminated {
+	if !task.Status.IsFinished() {
 		return fmt.Errorf("%w: cannot remove task %s with %s status", ErrRequest, task.ID, task.Status)
 	}
-	removeOptions := container.RemoveOptions{Force: true, RemoveVolumes: true}
-	// Normally, it should not be empty
-	if task.containerID != "" {
-		err := d.client.ContainerRemove(ctx, task.containerID, removeOptions)
-		if err != nil {
-			if errdefs.IsNotFound(err) {
+	steps := []cleanupStep{
+		{name: cleanupStepRemoveContainer, run: func(ctx context.Context) error {
+			// Normally, it should not be empty
+			if task.containerID == "" {
+				return nil
+			}
+			removeOptions := container.RemoveOptions{Force: true, RemoveVolumes: true}
+			if err := d.client.ContainerRemove(ctx, task.containerID, removeOptions); err != nil {
+				if !errdefs.IsNotFound(err) {
+					return err
+				}
 				log.Error(ctx, "cannot remove container: not found", "task", task.ID)
-			} else {
-				return fmt.Errorf("%w: failed to remove container task=%s: %w", ErrInternal, task.ID, err)
 			}
-		}
-	}
-	// Normally, it should not be empty
-	if task.runnerDir != "" {
-		// Failed attempts to remove or rename runner dir are considered non-fatal
-		if err := os.RemoveAll(task.runnerDir); err != nil {
-			log.Error(ctx, "failed to remove runner directory", "dir", task.runnerDir, "err", err)
-			trashName := fmt.Sprintf(".trash-%s-%d", task.runnerDir, time.Now().UnixMicro())
-			if err := os.Rename(task.runnerDir, trashName); err != nil {
-				log.Error(ctx, "failed to rename runner directory", "dir", task.runnerDir, "err", err)
+			return nil
+		}},
+		{name: cleanupStepRemoveVolumes, run: func(ctx context.Context) error {
+			return d.removeDockerVolumes(ctx, task.ID)
+		}},
+		{name: cleanupStepRemoveRunnerDir, run: func(ctx context.Context) error {
+			// Normally, it should not be empty
+			if task.runnerDir == "" {
+				return nil
 			}
-		}
+			// Failed attempts to remove or rename runner dir are considered non-fatal
+			if err := os.RemoveAll(task.runnerDir); err != nil {
+				log.Error(ctx, "failed to remove runner directory", "dir", task.runnerDir, "err", err)
+				trashName := fmt.Sprintf(".trash-%s-%d", task.runnerDir, time.Now().UnixMicro())
+				if err := os.Rename(task.runnerDir, trashName); err != nil {
+					log.Error(ctx, "failed to rename runner directory", "dir", task.runnerDir, "err", err)
+				}
+			}
+			return nil
+		}},
+	}
+	// The task is kept if any step fails, so that removing can be retried
+	if err := d.runCleanup(ctx, task.ID, steps); err != nil {
+		return fmt.Errorf("%w: failed to remove task=%s: %w", ErrInternal, task.ID, err)
 	}
 	log.Debug(ctx, "removed", "task", task.ID)
 	return nil
@@ -636,23 +1273,45 @@ func mountDisk(ctx context.Context, deviceName, mountPoint string, fsRootPerms o
 	return nil
 }
 
-func pullImage(ctx context.Context, client docker.APIClient, taskConfig TaskConfig) error {
+// pullImage pulls the image as required by the task PullPolicy. If there is a mirror for the image
+// registry, the image is pulled from the mirror and tagged with the original name.
+// Registry credentials are not sent to the mirror
+// onProgress, if not nil, is called with downloaded and total bytes as the pull progresses
+func pullImage(ctx context.Context, client docker.APIClient, taskConfig TaskConfig, mirrors registryMirrors, mirrorFallback bool, onProgress func(current, total uint)) error {
 	if !strings.Contains(taskConfig.ImageName, ":") {
 		taskConfig.ImageName += ":latest"
 	}
-	images, err := client.ImageList(ctx, image.ListOptions{
-		Filters: filters.NewArgs(filters.Arg("reference", taskConfig.ImageName)),
-	})
-	if err != nil {
-		return tracerr.Wrap(err)
+	if taskConfig.PullPolicy != PullPolicyAlways {
+		images, err := client.ImageList(ctx, image.ListOptions{
+			Filters: filters.NewArgs(filters.Arg("reference", taskConfig.ImageName)),
+		})
+		if err != nil {
+			return tracerr.Wrap(err)
+		}
+		if !taskConfig.PullPolicy.needsPull(taskConfig.ImageName, len(images) > 0) {
+			return nil
+		}
+		if taskConfig.PullPolicy == PullPolicyNever {
+			return tracerr.Errorf("image %s is not present locally, pull_policy is %s", taskConfig.ImageName, PullPolicyNever)
+		}
 	}
 
-	// TODO: force pull latset
-	if len(images) > 0 && !strings.Contains(taskConfig.ImageName, ":latest") {
-		return nil
+	if mirrorImageName, ok := mirrors.Rewrite(taskConfig.ImageName); ok {
+		log.Debug(ctx, "pulling image from mirror", "name", taskConfig.ImageName, "mirror", mirrorImageName)
+		err := pullImageRef(ctx, client, mirrorImageName, image.PullOptions{Platform: taskConfig.Platform}, onProgress)
+		if err == nil {
+			if err := client.ImageTag(ctx, mirrorImageName, taskConfig.ImageName); err != nil {
+				return tracerr.Errorf("failed to tag mirrored image: %w", err)
+			}
+			return nil
+		}
+		if !mirrorFallback || ctx.Err() != nil {
+			return tracerr.Errorf("failed to pull image from mirror %s: %w", mirrorImageName, err)
+		}
+		log.Warning(ctx, "failed to pull image from mirror, falling back to upstream", "mirror", mirrorImageName, "err", err)
 	}
 
-	opts := image.PullOptions{}
+	opts := image.PullOptions{Platform: taskConfig.Platform}
 	regAuth, err := encodeRegistryAuth(taskConfig.RegistryUsername, taskConfig.RegistryPassword)
 	if err != nil {
 		log.Error(ctx, err.Error())
@@ -660,9 +1319,12 @@ func pullImage(ctx context.Context, client docker.APIClient, taskConfig TaskConf
 	if regAuth != "" {
 		opts.RegistryAuth = regAuth
 	}
+	return pullImageRef(ctx, client, taskConfig.ImageName, opts, onProgress)
+}
 
+func pullImageRef(ctx context.Context, client docker.APIClient, imageName string, opts image.PullOptions, onProgress func(current, total uint)) error {
 	startTime := time.Now()
-	reader, err := client.ImagePull(ctx, taskConfig.ImageName, opts)
+	reader, err := client.ImagePull(ctx, imageName, opts)
 	if err != nil {
 		return tracerr.Wrap(err)
 	}
@@ -683,6 +1345,8 @@ func pullImage(ctx context.Context, client docker.APIClient, taskConfig TaskConf
 	}
 
 	var status bool
+	// the daemon reports some errors (e.g., manifest not found) in the stream, not as HTTP errors
+	var pullError string
 
 	scanner := bufio.NewScanner(reader)
 	for scanner.Scan() {
@@ -698,8 +1362,12 @@ func pullImage(ctx context.Context, client docker.APIClient, taskConfig TaskConf
 		if progressRow.Status == "Download complete" {
 			current[progressRow.Id] = total[progressRow.Id]
 		}
+		if onProgress != nil && (progressRow.Status == "Downloading" || progressRow.Status == "Download complete") {
+			onProgress(sumValues(current), sumValues(total))
+		}
 		if progressRow.Error != "" {
-			log.Error(ctx, "error pulling image", "name", taskConfig.ImageName, "err", progressRow.Error)
+			log.Error(ctx, "error pulling image", "name", imageName, "err", progressRow.Error)
+			pullError = progressRow.Error
 		}
 		if strings.HasPrefix(progressRow.Status, "Status:") {
 			status = true
@@ -709,14 +1377,8 @@ func pullImage(ctx context.Context, client docker.APIClient, taskConfig TaskConf
 
 	duration := time.Since(startTime)
 
-	var currentBytes uint
-	var totalBytes uint
-	for _, v := range current {
-		currentBytes += v
-	}
-	for _, v := range total {
-		totalBytes += v
-	}
+	currentBytes := sumValues(current)
+	totalBytes := sumValues(total)
 
 	speed := bytesize.New(float64(currentBytes) / duration.Seconds())
 	if status && currentBytes == totalBytes {
@@ -729,9 +1391,20 @@ func pullImage(ctx context.Context, client docker.APIClient, taskConfig TaskConf
 	if err != nil {
 		return tracerr.Errorf("imagepull interrupted: downloaded %d bytes out of %d (%s/s): %w", currentBytes, totalBytes, speed, err)
 	}
+	if pullError != "" && !status {
+		return tracerr.Errorf("failed to pull image %s: %s", imageName, pullError)
+	}
 	return nil
 }
 
+func sumValues(m map[string]uint) uint {
+	var sum uint
+	for _, v := range m {
+		sum += v
+	}
+	return sum
+}
+
 func (d *DockerRunner) createContainer(ctx context.Context, task *Task) error {
 	runnerDir, err := d.dockerParams.MakeRunnerDir(task.containerName)
 	if err != nil {
@@ -753,14 +1426,49 @@ func (d *DockerRunner) createContainer(ctx context.Context, task *Task) error {
 		return tracerr.Wrap(err)
 	}
 	mounts = append(mounts, instanceMounts...)
+	dockerVolumeMounts, err := d.createDockerVolumes(ctx, task)
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+	mounts = append(mounts, dockerVolumeMounts...)
+	dockerSocketMount, err := getDockerSocketMount(task.config, d.dockerParams.DockerAllowDockerSocket())
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+	if dockerSocketMount != nil {
+		mounts = append(mounts, *dockerSocketMount)
+	}
 
 	ports := d.dockerParams.DockerPorts()
 
+	gpuCapabilities, err := getNvidiaDriverCapabilities(task.config.GPUCapabilities)
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+
 	// Set the environment variables
 	envVars := []string{}
+	if d.gpuVendor == host.GpuVendorNvidia && len(task.gpuIDs) > 0 {
+		envVars = append(envVars, fmt.Sprintf("NVIDIA_DRIVER_CAPABILITIES=%s", strings.Join(gpuCapabilities, ",")))
+	}
 	if d.dockerParams.DockerPJRTDevice() != "" {
 		envVars = append(envVars, fmt.Sprintf("PJRT_DEVICE=%s", d.dockerParams.DockerPJRTDevice()))
 	}
+	if task.gpuMemoryFraction > 0 && len(task.gpuIDs) > 0 {
+		envVars = append(envVars, getGpuMemoryFractionEnv(d.gpus, task.gpuIDs, task.gpuMemoryFraction)...)
+		envVars = append(envVars, getMPSEnv(getTaskMPSLimits(task.config), len(task.gpuIDs))...)
+	}
+	if task.config.TraceID != "" {
+		envVars = append(envVars, fmt.Sprintf("%s=%s", TraceIDEnvVar, task.config.TraceID))
+	}
+	if task.config.Credentials != nil {
+		envVars = append(envVars, fmt.Sprintf("%s=%s", CredentialsFileEnvVar, task.config.Credentials.getPath()))
+	}
+	taskEnvVars, err := d.getTaskEnv(ctx, task)
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+	envVars = mergeEnv(envVars, taskEnvVars)
 
 	// Override /dev/shm with tmpfs mount with `exec` option (the default is `noexec`)
 	// if ShmSize is specified (i.e. not zero, which is the default value).
@@ -781,14 +1489,44 @@ func (d *DockerRunner) createContainer(ctx context.Context, task *Task) error {
 		Entrypoint:   []string{"/bin/sh", "-c"},
 		ExposedPorts: exposePorts(ports),
 		Env:          envVars,
+		Tty:          task.config.TTY,
+		OpenStdin:    task.config.TTY,
 		Labels: map[string]string{
 			LabelKeyIsTask: LabelValueTrue,
 			LabelKeyTaskID: task.ID,
 		},
 	}
+	if task.gpuMemoryFraction > 0 {
+		containerConfig.Labels[LabelKeyGpuMemoryFraction] = strconv.FormatFloat(task.gpuMemoryFraction, 'f', -1, 64)
+		setMPSLabels(containerConfig.Labels, getTaskMPSLimits(task.config))
+	}
+	if task.config.DockerSocket != "" {
+		containerConfig.Labels[LabelKeyDockerSocket] = task.config.DockerSocket
+	}
+	if task.config.LeaseDuration > 0 {
+		containerConfig.Labels[LabelKeyLeaseDuration] = strconv.FormatUint(uint64(task.config.LeaseDuration), 10)
+	}
+	if task.config.RunID != "" {
+		containerConfig.Labels[LabelKeyRunID] = task.config.RunID
+	}
+	if task.config.TraceID != "" {
+		containerConfig.Labels[LabelKeyTraceID] = task.config.TraceID
+	}
+	if len(task.config.StopSignals) > 0 {
+		containerConfig.Labels[LabelKeyStopSignals] = formatStopSignals(task.config.StopSignals)
+	}
+	containerConfig.Labels = mergeLabels(task.config.Labels, containerConfig.Labels)
 	if task.config.ContainerUser != "" {
 		containerConfig.User = task.config.ContainerUser
 	}
+	if task.config.StopTimeout > 0 {
+		stopTimeout := int(task.config.StopTimeout)
+		containerConfig.StopTimeout = &stopTimeout
+	}
+	if task.config.StartupProbe != nil {
+		// The image HEALTHCHECK, if any, is kept, but its failures don't count while starting
+		containerConfig.Healthcheck = &container.HealthConfig{StartPeriod: task.config.StartupProbe.getBudget()}
+	}
 	hostConfig := &container.HostConfig{
 		Privileged:   task.config.Privileged || d.dockerParams.DockerPrivileged(),
 		NetworkMode:  getNetworkMode(task.config.NetworkMode),
@@ -796,19 +1534,60 @@ func (d *DockerRunner) createContainer(ctx context.Context, task *Task) error {
 		Mounts:       mounts,
 		ShmSize:      task.config.ShmSize,
 		Tmpfs:        tmpfs,
+		OomScoreAdj:  task.config.OOMScoreAdj,
 	}
 	hostConfig.Resources.NanoCPUs = int64(task.config.CPU * 1000000000)
 	hostConfig.Resources.Memory = task.config.Memory
+	hostConfig.Resources.CgroupParent = task.config.CgroupParent
+	hostConfig.Resources.CpusetCpus = task.config.CPUSetCPUs
+	hostConfig.Resources.CpusetMems = task.config.CPUSetMems
 	if len(task.gpuIDs) > 0 {
-		configureGpus(hostConfig, d.gpuVendor, task.gpuIDs)
+		configureGpus(hostConfig, d.gpuVendor, task.gpuIDs, gpuCapabilities)
+	}
+	configureHpcNetworkingIfAvailable(hostConfig, d.infinibandDevDir)
+	securityOpts, err := getSecurityOpts(task.config, d.dockerParams.DockerAllowUnconfined())
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+	hostConfig.SecurityOpt = mergeSecurityOpts(hostConfig.SecurityOpt, securityOpts)
+	logConfig, err := getLogConfig(task.config)
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+	hostConfig.LogConfig = logConfig
+	usernsMode, err := getUsernsMode(task.config, hostConfig.Privileged, isUsernsRemapEnabled(d.dockerInfo))
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+	hostConfig.UsernsMode = usernsMode
+	sysctls, err := getSysctls(task.config, hostConfig.IpcMode.IsHost())
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+	hostConfig.Sysctls = sysctls
+	if task.config.CoreDumps {
+		if err := d.configureCoreDumps(task, hostConfig); err != nil {
+			return tracerr.Wrap(err)
+		}
 	}
-	configureHpcNetworkingIfAvailable(hostConfig)
 
-	resp, err := d.client.ContainerCreate(ctx, containerConfig, hostConfig, nil, nil, task.containerName)
+	platform, err := parsePlatform(task.config.Platform)
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+	resp, err := d.client.ContainerCreate(ctx, containerConfig, hostConfig, nil, platform, task.containerName)
 	if err != nil {
 		return tracerr.Wrap(err)
 	}
 	task.containerID = resp.ID
+	if err := d.writeInlineFiles(ctx, task); err != nil {
+		return err
+	}
+	if task.config.Credentials != nil {
+		if _, err := d.injectCredentials(ctx, task, task.containerID); err != nil {
+			return fmt.Errorf("%w: %w", ErrInternal, err)
+		}
+	}
 	return nil
 }
 
@@ -829,18 +1608,63 @@ func (d *DockerRunner) startContainer(ctx context.Context, task *Task) error {
 }
 
 func (d *DockerRunner) waitContainer(ctx context.Context, task *Task) error {
-	waitCh, errorCh := d.client.ContainerWait(ctx, task.containerID, "")
-	select {
-	case waitResp := <-waitCh:
-		{
-			if waitResp.StatusCode != 0 {
-				return fmt.Errorf("container exited with exit code %d", waitResp.StatusCode)
+	gracePeriod := d.dockerParams.DockerContainerGoneGracePeriod()
+	failures := 0
+	for {
+		waitStartedAt := time.Now()
+		waitCh, errorCh := d.client.ContainerWait(ctx, task.containerID, "")
+		select {
+		case waitResp := <-waitCh:
+			{
+				if waitResp.StatusCode != 0 {
+					return fmt.Errorf("container exited with exit code %d", waitResp.StatusCode)
+				}
 			}
+		case err := <-errorCh:
+			if ctx.Err() != nil {
+				return tracerr.Wrap(err)
+			}
+			log.Error(ctx, "failed to wait for container", "task", task.ID, "err", err)
+			// Count only back-to-back failures, a failure after a long wait is a new incident
+			if time.Since(waitStartedAt) > gracePeriod {
+				failures = 0
+			}
+			failures++
+			if failures > containerGoneCheckAttempts || !d.confirmContainerExists(ctx, task.containerID, gracePeriod) {
+				return tracerr.Wrap(err)
+			}
+			log.Info(ctx, "container is still there, resuming wait", "task", task.ID)
+			continue
 		}
-	case err := <-errorCh:
-		return tracerr.Wrap(err)
+		return nil
 	}
-	return nil
+}
+
+// The number of attempts to inspect the container before considering it gone
+const containerGoneCheckAttempts = 3
+
+// confirmContainerExists re-checks the container several times over the grace period before
+// reporting it as gone. This is required to avoid premature termination of healthy tasks
+// due to transient Docker daemon failures.
+// Any successful inspect confirms that the container exists, even if it has already exited,
+// as in that case ContainerWait() returns the exit code immediately
+func (d *DockerRunner) confirmContainerExists(ctx context.Context, containerID string, gracePeriod time.Duration) bool {
+	interval := gracePeriod / containerGoneCheckAttempts
+	for attempt := 1; attempt <= containerGoneCheckAttempts; attempt++ {
+		_, err := d.client.ContainerInspect(ctx, containerID)
+		if err == nil {
+			return true
+		}
+		log.Error(ctx, "failed to inspect container", "id", containerID, "attempt", attempt, "err", err)
+		if attempt < containerGoneCheckAttempts {
+			select {
+			case <-time.After(interval):
+			case <-ctx.Done():
+				return false
+			}
+		}
+	}
+	return false
 }
 
 func encodeRegistryAuth(username string, password string) (string, error) {
@@ -966,7 +1790,40 @@ func getNetworkMode(networkMode NetworkMode) container.NetworkMode {
 	return "default"
 }
 
-func configureGpus(hostConfig *container.HostConfig, vendor host.GpuVendor, ids []string) {
+// NVIDIA driver capabilities, see
+// https://docs.nvidia.com/datacenter/cloud-native/container-toolkit/1.16.0/docker-specialized.html
+var nvidiaDriverCapabilities = []string{"compute", "compat32", "graphics", "utility", "video", "display"}
+
+// Used if the task does not request capabilities explicitly, enough for CUDA workloads and nvidia-smi
+var defaultNvidiaDriverCapabilities = []string{"compute", "utility"}
+
+// getNvidiaDriverCapabilities validates the requested capabilities and returns them in a canonical order
+// without duplicates. "all" expands to all capabilities, an empty list means the default ones
+func getNvidiaDriverCapabilities(requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return defaultNvidiaDriverCapabilities, nil
+	}
+	capabilities := []string{}
+	for _, capability := range requested {
+		if capability == "all" {
+			return nvidiaDriverCapabilities, nil
+		}
+		if !slices.Contains(nvidiaDriverCapabilities, capability) {
+			return nil, fmt.Errorf(
+				"%w: unknown GPU capability %q, must be one of: all, %s",
+				ErrInvalidConfig, capability, strings.Join(nvidiaDriverCapabilities, ", "),
+			)
+		}
+	}
+	for _, capability := range nvidiaDriverCapabilities {
+		if slices.Contains(requested, capability) {
+			capabilities = append(capabilities, capability)
+		}
+	}
+	return capabilities, nil
+}
+
+func configureGpus(hostConfig *container.HostConfig, vendor host.GpuVendor, ids []string, capabilities []string) {
 	// NVIDIA: ids are identifiers reported by nvidia-smi, GPU-<UUID> strings
 	// AMD: ids are DRI render node paths, e.g., /dev/dri/renderD128
 	switch vendor {
@@ -974,10 +1831,9 @@ func configureGpus(hostConfig *container.HostConfig, vendor host.GpuVendor, ids
 		hostConfig.Resources.DeviceRequests = append(
 			hostConfig.Resources.DeviceRequests,
 			container.DeviceRequest{
-				// Request all capabilities to maximize compatibility with all sorts of GPU workloads.
-				// Default capabilities: utility, compute.
-				// https://docs.nvidia.com/datacenter/cloud-native/container-toolkit/1.16.0/docker-specialized.html
-				Capabilities: [][]string{{"gpu", "utility", "compute", "graphics", "video", "display", "compat32"}},
+				// The Docker daemon translates driver capabilities into NVIDIA_DRIVER_CAPABILITIES,
+				// "gpu" is required for the request to be handled by the NVIDIA driver at all
+				Capabilities: [][]string{append([]string{"gpu"}, capabilities...)},
 				DeviceIDs:    ids,
 			},
 		)
@@ -1016,14 +1872,44 @@ func configureGpus(hostConfig *container.HostConfig, vendor host.GpuVendor, ids
 	}
 }
 
-func configureHpcNetworkingIfAvailable(hostConfig *container.HostConfig) {
+// getGpuMemoryFractionEnv returns framework-specific environment variables that limit
+// the GPU memory usage. This is a best-effort limit: it only works if the framework
+// respects these variables, there is no hardware isolation (unlike MIG)
+func getGpuMemoryFractionEnv(gpus []host.GpuInfo, ids []string, fraction float64) []string {
+	fractionStr := strconv.FormatFloat(fraction, 'f', -1, 64)
+	env := []string{
+		fmt.Sprintf("DSTACK_GPU_MEMORY_FRACTION=%s", fractionStr),
+		// JAX
+		fmt.Sprintf("XLA_PYTHON_CLIENT_MEM_FRACTION=%s", fractionStr),
+		// TensorFlow, allocate on demand instead of grabbing all memory at startup
+		"TF_FORCE_GPU_ALLOW_GROWTH=true",
+		// PyTorch has no env-based limit (only torch.cuda.set_per_process_memory_fraction()),
+		// expandable segments at least reduce fragmentation and overallocation
+		"PYTORCH_CUDA_ALLOC_CONF=expandable_segments:True",
+	}
+	// The smallest of allocated GPUs, MiB
+	minVram := 0
+	for _, gpu := range gpus {
+		if slices.Contains(ids, getGpuResourceID(gpu)) && (minVram == 0 || gpu.Vram < minVram) {
+			minVram = gpu.Vram
+		}
+	}
+	if minVram > 0 {
+		// Not used by TensorFlow directly, but can be passed to tf.config.set_logical_device_configuration()
+		env = append(env, fmt.Sprintf("TF_GPU_MEMORY_LIMIT=%d", int(float64(minVram)*fraction)))
+	}
+	return env
+}
+
+// devDir is normally host.InfinibandDevDir
+func configureHpcNetworkingIfAvailable(hostConfig *container.HostConfig, devDir string) {
 	// Although AWS EFA is not InfiniBand, EFA adapters are exposed as /dev/infiniband/uverbsN (N=0,1,...)
-	if _, err := os.Stat("/dev/infiniband"); !errors.Is(err, os.ErrNotExist) {
+	if _, err := os.Stat(devDir); !errors.Is(err, os.ErrNotExist) {
 		hostConfig.Resources.Devices = append(
 			hostConfig.Resources.Devices,
 			container.DeviceMapping{
-				PathOnHost:        "/dev/infiniband",
-				PathInContainer:   "/dev/infiniband",
+				PathOnHost:        devDir,
+				PathInContainer:   host.InfinibandDevDir,
 				CgroupPermissions: "rwm",
 			},
 		)
@@ -1058,7 +1944,8 @@ func getInstanceMounts(mountPoints []InstanceMountPoint) ([]mount.Mount, error)
 	return mounts, nil
 }
 
-func getContainerLastLogs(ctx context.Context, client docker.APIClient, containerID string, n int) ([]string, error) {
+// If tty is true, the logs are not multiplexed
+func getContainerLastLogs(ctx context.Context, client docker.APIClient, containerID string, n int, tty bool) ([]string, error) {
 	options := container.LogsOptions{
 		ShowStdout: true,
 		ShowStderr: true,
@@ -1071,10 +1958,22 @@ func getContainerLastLogs(ctx context.Context, client docker.APIClient, containe
 	}
 	defer muxedReader.Close()
 
+	return readContainerLogs(muxedReader, tty)
+}
+
+// readContainerLogs splits the container output into lines. Unless the container has TTY,
+// the output is multiplexed, stdout and stderr are merged
+func readContainerLogs(muxedReader io.Reader, tty bool) ([]string, error) {
 	demuxedBuffer := new(bytes.Buffer)
-	// Using the same Writer for both stdout and stderr should be roughly equivalent to 2>&1
-	if _, err := stdcopy.StdCopy(demuxedBuffer, demuxedBuffer, muxedReader); err != nil {
-		return nil, err
+	if tty {
+		if _, err := io.Copy(demuxedBuffer, muxedReader); err != nil {
+			return nil, err
+		}
+	} else {
+		// Using the same Writer for both stdout and stderr should be roughly equivalent to 2>&1
+		if _, err := stdcopy.StdCopy(demuxedBuffer, demuxedBuffer, muxedReader); err != nil {
+			return nil, err
+		}
 	}
 
 	var lines []string
@@ -1099,6 +1998,91 @@ func (c *CLIArgs) DockerPJRTDevice() string {
 	return c.Docker.PJRTDevice
 }
 
+func (c *CLIArgs) DockerAllowUnconfined() []string {
+	return c.Docker.AllowUnconfined
+}
+
+func (c *CLIArgs) DockerAllowDockerSocket() []string {
+	return c.Docker.AllowDockerSocket
+}
+
+func (c *CLIArgs) DockerContainerGoneGracePeriod() time.Duration {
+	return c.Docker.ContainerGoneGracePeriod
+}
+
+func (c *CLIArgs) DockerRegistryMirrors() []string {
+	return c.Docker.RegistryMirrors
+}
+
+func (c *CLIArgs) DockerRegistryMirrorFallback() bool {
+	return c.Docker.RegistryMirrorFallback
+}
+
+func (c *CLIArgs) DockerCircuitBreakerThreshold() int {
+	return c.Docker.CircuitBreakerThreshold
+}
+
+func (c *CLIArgs) DockerCircuitBreakerCooldown() time.Duration {
+	return c.Docker.CircuitBreakerCooldown
+}
+
+func (c *CLIArgs) DockerImageSignaturePolicy() string {
+	return c.Docker.ImageSignaturePolicy
+}
+
+func (c *CLIArgs) DockerMaxConcurrentPulls() int {
+	return c.Docker.MaxConcurrentPulls
+}
+
+func (c *CLIArgs) DockerEndpoint() DockerEndpoint {
+	return c.Docker.Endpoint
+}
+
+func (c *CLIArgs) ShimMaxConcurrentTasks() int {
+	return c.Shim.MaxConcurrentTasks
+}
+
+func (c *CLIArgs) ShimContainerNameHashLength() int {
+	return c.Shim.ContainerNameHashLength
+}
+
+// ShimStateDir returns the directory where the shim persists its state across host reboots,
+// see TaskConfig.RestartOnReboot
+func (c *CLIArgs) ShimStateDir() string {
+	if c.Shim.HomeDir == "" {
+		return ""
+	}
+	return filepath.Join(c.Shim.HomeDir, "state")
+}
+
+func (c *CLIArgs) ShimCoreDumpDir() string {
+	return c.Shim.CoreDumpDir
+}
+
+func (c *CLIArgs) ShimAdmissionWebhook() AdmissionWebhookConfig {
+	return c.Shim.AdmissionWebhook
+}
+
+func (c *CLIArgs) ShimGPUDrain() GPUDrainConfig {
+	return c.Shim.GPUDrain
+}
+
+func (c *CLIArgs) ShimCredentialProvider() CredentialProviderConfig {
+	return c.Shim.CredentialProvider
+}
+
+func (c *CLIArgs) ShimTelemetry() TelemetryConfig {
+	return c.Shim.Telemetry
+}
+
+func (c *CLIArgs) ShimPreflight() PreflightConfig {
+	return PreflightConfig{HomeDir: c.Shim.HomeDir, MinFreeDiskMB: c.Shim.MinFreeDiskMB}
+}
+
+func (c *CLIArgs) ShimAuditLog() AuditLogConfig {
+	return c.Shim.AuditLog
+}
+
 func (c *CLIArgs) DockerShellCommands(publicKeys []string) []string {
 	concatinatedPublicKeys := c.Docker.ConcatinatedPublicSSHKeys
 	if len(publicKeys) > 0 {
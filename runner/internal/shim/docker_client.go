@@ -0,0 +1,101 @@
+package shim
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	docker "github.com/docker/docker/client"
+	"github.com/dstackai/dstack/runner/internal/log"
+)
+
+// DockerEndpoint is the Docker daemon the shim connects to. Empty Host means the local daemon
+// configured via the standard environment variables (DOCKER_HOST, DOCKER_CERT_PATH, etc.),
+// which defaults to the local socket. TLS is enabled if any of the TLS files is set.
+// NB: with a remote daemon, GPUs are still detected on the shim host, and host paths
+// (instance mounts, runner directories) refer to the daemon host, that is, the shim must run
+// on the same host as the daemon or share these paths with it
+type DockerEndpoint struct {
+	// unix:///path/to/socket or tcp://host:port
+	Host string
+	// PEM files
+	TLSCACert string
+	TLSCert   string
+	TLSKey    string
+}
+
+func (e DockerEndpoint) isTLS() bool {
+	return e.TLSCACert != "" || e.TLSCert != "" || e.TLSKey != ""
+}
+
+// newDockerClient connects to the endpoint, see getDockerClientOpts()
+func newDockerClient(ctx context.Context, endpoint DockerEndpoint) (*docker.Client, error) {
+	opts, err := getDockerClientOpts(ctx, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return docker.NewClientWithOpts(opts...)
+}
+
+// getDockerClientOpts validates the endpoint, including the TLS material, and returns
+// the client options. Explicitly set values override the environment variables
+func getDockerClientOpts(ctx context.Context, endpoint DockerEndpoint) ([]docker.Opt, error) {
+	opts := []docker.Opt{docker.FromEnv}
+	if endpoint.Host == "" {
+		if endpoint.isTLS() {
+			return nil, fmt.Errorf("%w: Docker TLS files are set, but the Docker host is not", ErrInvalidConfig)
+		}
+		return append(opts, docker.WithAPIVersionNegotiation()), nil
+	}
+	hostURL, err := docker.ParseHostURL(endpoint.Host)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid Docker host: %w", ErrInvalidConfig, err)
+	}
+	switch hostURL.Scheme {
+	case "unix":
+		if endpoint.isTLS() {
+			return nil, fmt.Errorf("%w: Docker TLS is only supported with tcp:// host", ErrInvalidConfig)
+		}
+	case "tcp":
+		if !endpoint.isTLS() {
+			log.Warning(ctx, "connecting to Docker daemon over TCP without TLS", "host", endpoint.Host)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported Docker host scheme %s, expected unix or tcp", ErrInvalidConfig, hostURL.Scheme)
+	}
+	opts = append(opts, docker.WithHost(endpoint.Host))
+	if endpoint.isTLS() {
+		if err := validateDockerTLSFiles(endpoint); err != nil {
+			return nil, err
+		}
+		opts = append(opts, docker.WithTLSClientConfig(endpoint.TLSCACert, endpoint.TLSCert, endpoint.TLSKey))
+	}
+	return append(opts, docker.WithAPIVersionNegotiation()), nil
+}
+
+// validateDockerTLSFiles checks that the files are readable and contain valid PEM data, so that
+// misconfiguration is reported at startup instead of on the first daemon request.
+// The client certificate is optional (daemons not requiring client auth), the CA is optional
+// as well (the daemon certificate is signed by a CA trusted by the system)
+func validateDockerTLSFiles(endpoint DockerEndpoint) error {
+	if (endpoint.TLSCert == "") != (endpoint.TLSKey == "") {
+		return fmt.Errorf("%w: Docker TLS certificate and key must be set together", ErrInvalidConfig)
+	}
+	if endpoint.TLSCert != "" {
+		if _, err := tls.LoadX509KeyPair(endpoint.TLSCert, endpoint.TLSKey); err != nil {
+			return fmt.Errorf("%w: invalid Docker TLS certificate or key: %w", ErrInvalidConfig, err)
+		}
+	}
+	if endpoint.TLSCACert != "" {
+		pem, err := os.ReadFile(endpoint.TLSCACert)
+		if err != nil {
+			return fmt.Errorf("%w: cannot read Docker TLS CA certificate: %w", ErrInvalidConfig, err)
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%w: no certificates found in Docker TLS CA file %s", ErrInvalidConfig, endpoint.TLSCACert)
+		}
+	}
+	return nil
+}
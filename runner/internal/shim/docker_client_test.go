@@ -0,0 +1,149 @@
+package shim
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDockerClient_Socket(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+	server := httptest.NewUnstartedServer(newFakeDockerDaemonHandler())
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	client, err := newDockerClient(context.Background(), DockerEndpoint{Host: "unix://" + socketPath})
+	require.NoError(t, err)
+	assert.Equal(t, "unix://"+socketPath, client.DaemonHost())
+	ping, err := client.Ping(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "1.45", ping.APIVersion)
+}
+
+func TestNewDockerClient_Default(t *testing.T) {
+	t.Setenv("DOCKER_HOST", "")
+	t.Setenv("DOCKER_CERT_PATH", "")
+	client, err := newDockerClient(context.Background(), DockerEndpoint{})
+	require.NoError(t, err)
+	assert.Equal(t, "unix:///var/run/docker.sock", client.DaemonHost())
+}
+
+func TestNewDockerClient_TLS(t *testing.T) {
+	caCert, cert, key := writeTestTLSFiles(t)
+	server := httptest.NewUnstartedServer(newFakeDockerDaemonHandler())
+	serverCert, err := tls.LoadX509KeyPair(cert, key)
+	require.NoError(t, err)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(serverCert.Leaf)
+	// the client must present a certificate signed by the CA
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	server.StartTLS()
+	defer server.Close()
+	host := "tcp://" + server.Listener.Addr().String()
+
+	client, err := newDockerClient(context.Background(), DockerEndpoint{
+		Host: host, TLSCACert: caCert, TLSCert: cert, TLSKey: key,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, host, client.DaemonHost())
+	ping, err := client.Ping(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "1.45", ping.APIVersion)
+
+	// without the client certificate
+	client, err = newDockerClient(context.Background(), DockerEndpoint{Host: host, TLSCACert: caCert})
+	require.NoError(t, err)
+	_, err = client.Ping(context.Background())
+	assert.Error(t, err)
+}
+
+// newFakeDockerDaemonHandler only responds to pings
+func newFakeDockerDaemonHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/_ping") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("API-Version", "1.45")
+		_, _ = w.Write([]byte("OK"))
+	})
+}
+
+func TestNewDockerClient_Errors(t *testing.T) {
+	caCert, cert, key := writeTestTLSFiles(t)
+	notPEM := filepath.Join(t.TempDir(), "not.pem")
+	require.NoError(t, os.WriteFile(notPEM, []byte("not a certificate"), 0o600))
+	testCases := []struct {
+		endpoint DockerEndpoint
+		err      string
+	}{
+		{DockerEndpoint{TLSCACert: caCert}, "the Docker host is not"},
+		{DockerEndpoint{Host: "docker.internal:2376"}, "invalid Docker host"},
+		{DockerEndpoint{Host: "ssh://user@docker.internal"}, "unsupported Docker host scheme ssh"},
+		{DockerEndpoint{Host: "unix:///var/run/docker.sock", TLSCACert: caCert}, "only supported with tcp://"},
+		{DockerEndpoint{Host: "tcp://docker.internal:2376", TLSCert: cert}, "must be set together"},
+		{DockerEndpoint{Host: "tcp://docker.internal:2376", TLSCert: cert, TLSKey: notPEM}, "invalid Docker TLS certificate or key"},
+		{DockerEndpoint{Host: "tcp://docker.internal:2376", TLSCACert: filepath.Join(t.TempDir(), "missing.pem")}, "cannot read Docker TLS CA certificate"},
+		{DockerEndpoint{Host: "tcp://docker.internal:2376", TLSCACert: notPEM, TLSCert: cert, TLSKey: key}, "no certificates found"},
+	}
+	for _, tc := range testCases {
+		_, err := newDockerClient(context.Background(), tc.endpoint)
+		assert.ErrorIs(t, err, ErrInvalidConfig, tc.endpoint)
+		assert.ErrorContains(t, err, tc.err, tc.endpoint)
+	}
+}
+
+// writeTestTLSFiles writes a self-signed certificate for 127.0.0.1, which is used as the CA,
+// the server, and the client certificate, and returns paths to the CA, certificate, and key files
+func writeTestTLSFiles(t *testing.T) (string, string, string) {
+	t.Helper()
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "dstack-shim-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(privateKey)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	require.NoError(t, os.WriteFile(certPath, certPEM, 0o600))
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certPath, certPath, keyPath
+}
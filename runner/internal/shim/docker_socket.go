@@ -0,0 +1,48 @@
+package shim
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/docker/docker/api/types/mount"
+)
+
+const (
+	DockerSocketModeReadOnly  = "ro"
+	DockerSocketModeReadWrite = "rw"
+
+	// The same path is used inside the container, so that Docker clients work without DOCKER_HOST
+	dockerSocketPath = "/var/run/docker.sock"
+)
+
+// Set on containers with the host Docker socket mounted, the value is TaskConfig.DockerSocket
+const LabelKeyDockerSocket = LabelKeyPrefix + "docker-socket"
+
+// getDockerSocketMount returns the host Docker socket mount requested by the task, if any.
+// allowDockerSocket is an operator-provided list of allowed modes (DockerSocketMode*),
+// the read-write mode implies the read-only one
+func getDockerSocketMount(cfg TaskConfig, allowDockerSocket []string) (*mount.Mount, error) {
+	switch cfg.DockerSocket {
+	case "":
+		return nil, nil
+	case DockerSocketModeReadOnly:
+		if !slices.Contains(allowDockerSocket, DockerSocketModeReadOnly) && !slices.Contains(allowDockerSocket, DockerSocketModeReadWrite) {
+			return nil, fmt.Errorf("%w: mounting Docker socket is not allowed on this host", ErrInvalidConfig)
+		}
+	case DockerSocketModeReadWrite:
+		if !slices.Contains(allowDockerSocket, DockerSocketModeReadWrite) {
+			return nil, fmt.Errorf("%w: mounting Docker socket read-write is not allowed on this host", ErrInvalidConfig)
+		}
+	default:
+		return nil, fmt.Errorf(
+			"%w: docker_socket must be either %q or %q, got %q",
+			ErrInvalidConfig, DockerSocketModeReadOnly, DockerSocketModeReadWrite, cfg.DockerSocket,
+		)
+	}
+	return &mount.Mount{
+		Type:     mount.TypeBind,
+		Source:   dockerSocketPath,
+		Target:   dockerSocketPath,
+		ReadOnly: cfg.DockerSocket == DockerSocketModeReadOnly,
+	}, nil
+}
@@ -0,0 +1,86 @@
+package shim
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetDockerSocketMount(t *testing.T) {
+	m, err := getDockerSocketMount(TaskConfig{}, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, m)
+
+	m, err = getDockerSocketMount(TaskConfig{DockerSocket: "ro"}, []string{"ro"})
+	assert.NoError(t, err)
+	assert.Equal(t, &mount.Mount{
+		Type: mount.TypeBind, Source: "/var/run/docker.sock", Target: "/var/run/docker.sock", ReadOnly: true,
+	}, m)
+
+	// rw implies ro
+	m, err = getDockerSocketMount(TaskConfig{DockerSocket: "ro"}, []string{"rw"})
+	assert.NoError(t, err)
+	assert.True(t, m.ReadOnly)
+
+	m, err = getDockerSocketMount(TaskConfig{DockerSocket: "rw"}, []string{"rw"})
+	assert.NoError(t, err)
+	assert.False(t, m.ReadOnly)
+}
+
+func TestGetDockerSocketMount_Errors(t *testing.T) {
+	testCases := []struct {
+		mode  string
+		allow []string
+		err   string
+	}{
+		{"ro", nil, "mounting Docker socket is not allowed"},
+		{"rw", nil, "mounting Docker socket read-write is not allowed"},
+		{"rw", []string{"ro"}, "mounting Docker socket read-write is not allowed"},
+		{"true", []string{"ro", "rw"}, "docker_socket must be either \"ro\" or \"rw\""},
+	}
+	for _, tc := range testCases {
+		_, err := getDockerSocketMount(TaskConfig{DockerSocket: tc.mode}, tc.allow)
+		assert.ErrorIs(t, err, ErrInvalidConfig, tc.mode)
+		assert.ErrorContains(t, err, tc.err, tc.mode)
+	}
+}
+
+func TestDockerRunner_DockerSocket(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{allowDockerSocket: []string{"rw"}})
+	cfg := createTaskConfig(t)
+	cfg.DockerSocket = "rw"
+	containerID := runTask(t, runner, cfg)
+	defer client.exitContainer(containerID, 0)
+
+	ctr, err := client.getContainer(containerID)
+	require.NoError(t, err)
+	assert.Contains(t, ctr.hostConfig.Mounts, mount.Mount{
+		Type: mount.TypeBind, Source: "/var/run/docker.sock", Target: "/var/run/docker.sock",
+	})
+	assert.Equal(t, "rw", ctr.config.Labels[LabelKeyDockerSocket])
+}
+
+func TestDockerRunner_DockerSocket_NotRequested(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{allowDockerSocket: []string{"rw"}})
+	containerID := runTask(t, runner, createTaskConfig(t))
+	defer client.exitContainer(containerID, 0)
+
+	ctr, err := client.getContainer(containerID)
+	require.NoError(t, err)
+	assert.Empty(t, ctr.hostConfig.Mounts)
+	assert.NotContains(t, ctr.config.Labels, LabelKeyDockerSocket)
+}
+
+func TestDockerRunner_DockerSocket_SubmitRejected(t *testing.T) {
+	runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.DockerSocket = "ro"
+
+	assert.ErrorIs(t, runner.Submit(context.Background(), cfg), ErrInvalidConfig)
+	assert.Equal(t, "", runner.TaskInfo(cfg.ID).ID)
+}
@@ -1,11 +1,20 @@
 package shim
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"math/rand"
+	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -13,7 +22,19 @@ import (
 	"testing"
 	"time"
 
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/api/types/system"
+	"github.com/docker/docker/api/types/volume"
+	docker "github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/dstackai/dstack/runner/internal/shim/host"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -141,23 +162,511 @@ func TestDocker_ShmExecIfSizeSpecified(t *testing.T) {
 	assert.NoError(t, dockerRunner.Run(ctx, taskConfig.ID))
 }
 
+func TestDockerRunner_WaitContainer_TransientFailure(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{containerGoneGracePeriod: 30 * time.Millisecond})
+	cfg := createTaskConfig(t)
+	cfg.NetworkMode = NetworkModeHost
+	client.injectErrors("ContainerWait", errors.New("connection reset by peer"))
+	client.injectErrors("ContainerInspect", errors.New("connection refused"))
+
+	require.NoError(t, runner.Submit(context.Background(), cfg))
+	runErr := make(chan error)
+	go func() { runErr <- runner.Run(context.Background(), cfg.ID) }()
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusRunning)
+
+	// wait failed, then the first inspect failed, but the second succeeded
+	require.Eventually(t, func() bool {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		return len(client.errors["ContainerWait"]) == 0 && len(client.errors["ContainerInspect"]) == 0
+	}, time.Second, time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, TaskStatusRunning, runner.TaskInfo(cfg.ID).Status)
+
+	client.exitContainer(runner.TaskInfo(cfg.ID).ContainerID, 0)
+	assert.NoError(t, <-runErr)
+	taskInfo := runner.TaskInfo(cfg.ID)
+	assert.Equal(t, TaskStatusTerminated, taskInfo.Status)
+	assert.Equal(t, "DONE_BY_RUNNER", taskInfo.TerminationReason)
+}
+
+func TestDockerRunner_WaitContainer_ContainerGone(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{containerGoneGracePeriod: 30 * time.Millisecond})
+	cfg := createTaskConfig(t)
+	cfg.NetworkMode = NetworkModeHost
+	client.injectErrors("ContainerWait", errdefs.NotFound(errors.New("no such container")))
+	client.injectErrors(
+		"ContainerInspect",
+		errors.New("connection refused"),
+		errdefs.NotFound(errors.New("no such container")),
+		errdefs.NotFound(errors.New("no such container")),
+	)
+
+	require.NoError(t, runner.Submit(context.Background(), cfg))
+	startedAt := time.Now()
+	assert.Error(t, runner.Run(context.Background(), cfg.ID))
+
+	assert.GreaterOrEqual(t, time.Since(startedAt), 20*time.Millisecond)
+	taskInfo := runner.TaskInfo(cfg.ID)
+	assert.Equal(t, TaskStatusFailed, taskInfo.Status)
+	assert.Equal(t, "CONTAINER_EXITED_WITH_ERROR", taskInfo.TerminationReason)
+}
+
+func TestDockerRunner_FinalStatus(t *testing.T) {
+	testCases := []struct {
+		name   string
+		run    func(t *testing.T, runner *DockerRunner, client *fakeDockerClient, cfg TaskConfig)
+		status TaskStatus
+		reason string
+		// the OOM flag of the container_exited event, if any
+		oomKilled bool
+	}{
+		{
+			name: "exited with 0",
+			run: func(t *testing.T, runner *DockerRunner, client *fakeDockerClient, cfg TaskConfig) {
+				client.exitContainer(runTask(t, runner, cfg), 0)
+			},
+			status: TaskStatusTerminated,
+			reason: "DONE_BY_RUNNER",
+		},
+		{
+			name: "exited with non-zero code",
+			run: func(t *testing.T, runner *DockerRunner, client *fakeDockerClient, cfg TaskConfig) {
+				client.exitContainer(runTask(t, runner, cfg), 1)
+			},
+			status: TaskStatusFailed,
+			reason: "CONTAINER_EXITED_WITH_ERROR",
+		},
+		{
+			name: "OOM killed",
+			run: func(t *testing.T, runner *DockerRunner, client *fakeDockerClient, cfg TaskConfig) {
+				client.exitContainer(runOOMKilledTask(t, runner, client, cfg), 137)
+			},
+			status:    TaskStatusFailed,
+			reason:    "CONTAINER_EXITED_WITH_ERROR",
+			oomKilled: true,
+		},
+		{
+			name: "OOM killed, exited with non-137 code",
+			run: func(t *testing.T, runner *DockerRunner, client *fakeDockerClient, cfg TaskConfig) {
+				client.exitContainer(runOOMKilledTask(t, runner, client, cfg), 1)
+			},
+			status:    TaskStatusFailed,
+			reason:    "CONTAINER_EXITED_WITH_ERROR",
+			oomKilled: true,
+		},
+		{
+			name: "OOM killed, exited with 0",
+			run: func(t *testing.T, runner *DockerRunner, client *fakeDockerClient, cfg TaskConfig) {
+				client.exitContainer(runOOMKilledTask(t, runner, client, cfg), 0)
+			},
+			status:    TaskStatusFailed,
+			reason:    "CONTAINER_EXITED_WITH_ERROR",
+			oomKilled: true,
+		},
+		{
+			// SIGKILL, not by the OOM killer
+			name: "killed",
+			run: func(t *testing.T, runner *DockerRunner, client *fakeDockerClient, cfg TaskConfig) {
+				client.exitContainer(runTask(t, runner, cfg), 137)
+			},
+			status: TaskStatusFailed,
+			reason: "CONTAINER_EXITED_WITH_ERROR",
+		},
+		{
+			name: "crashed on start",
+			run: func(t *testing.T, runner *DockerRunner, client *fakeDockerClient, cfg TaskConfig) {
+				client.crashOnStart = &fakeCrash{exitCode: 127}
+				require.NoError(t, runner.Submit(context.Background(), cfg))
+				assert.Error(t, runner.Run(context.Background(), cfg.ID))
+			},
+			status: TaskStatusFailed,
+			reason: "CONTAINER_EXITED_WITH_ERROR",
+		},
+		{
+			name: "pull failed",
+			run: func(t *testing.T, runner *DockerRunner, client *fakeDockerClient, cfg TaskConfig) {
+				client.injectErrors("ImagePull", errors.New("manifest unknown"))
+				require.NoError(t, runner.Submit(context.Background(), cfg))
+				assert.Error(t, runner.Run(context.Background(), cfg.ID))
+			},
+			status: TaskStatusFailed,
+			reason: "CREATING_CONTAINER_ERROR",
+		},
+		{
+			name: "stopped by server",
+			run: func(t *testing.T, runner *DockerRunner, client *fakeDockerClient, cfg TaskConfig) {
+				runTask(t, runner, cfg)
+				require.NoError(t, runner.Terminate(context.Background(), cfg.ID, nil, "TERMINATED_BY_SERVER", ""))
+			},
+			status: TaskStatusTerminated,
+			reason: "TERMINATED_BY_SERVER",
+		},
+		{
+			name: "stopped by server after failure",
+			run: func(t *testing.T, runner *DockerRunner, client *fakeDockerClient, cfg TaskConfig) {
+				client.exitContainer(runTask(t, runner, cfg), 1)
+				waitTaskStatus(t, runner, cfg.ID, TaskStatusFailed)
+				require.NoError(t, runner.Terminate(context.Background(), cfg.ID, nil, "TERMINATED_BY_SERVER", ""))
+			},
+			status: TaskStatusFailed,
+			reason: "CONTAINER_EXITED_WITH_ERROR",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newFakeDockerClient()
+			runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+			cfg := createTaskConfig(t)
+			tc.run(t, runner, client, cfg)
+
+			waitTaskStatus(t, runner, cfg.ID, tc.status)
+			// the status is final
+			time.Sleep(20 * time.Millisecond)
+			taskInfo := runner.TaskInfo(cfg.ID)
+			assert.Equal(t, tc.status, taskInfo.Status)
+			assert.Equal(t, tc.reason, taskInfo.TerminationReason)
+			for _, event := range taskInfo.Events {
+				if event.Type == TaskHistoryEventContainerExited {
+					assert.Equal(t, tc.oomKilled, event.OOMKilled)
+				}
+			}
+		})
+	}
+}
+
+// runOOMKilledTask runs the task as runTask does, the container is reported as OOM killed once exited
+func runOOMKilledTask(t *testing.T, runner *DockerRunner, client *fakeDockerClient, cfg TaskConfig) string {
+	t.Helper()
+	containerID := runTask(t, runner, cfg)
+	client.mu.Lock()
+	client.containers[containerID].oomKilled = true
+	client.mu.Unlock()
+	return containerID
+}
+
+func TestGetExitedContainerStatus(t *testing.T) {
+	assert.Equal(t, TaskStatusTerminated, getExitedContainerStatus("Exited (0) 5 minutes ago"))
+	assert.Equal(t, TaskStatusFailed, getExitedContainerStatus("Exited (137) About an hour ago"))
+	assert.Equal(t, TaskStatusTerminated, getExitedContainerStatus(""))
+}
+
+func TestDockerRunner_GPUMemoryFraction(t *testing.T) {
+	client := newFakeDockerClient()
+	gpus := []host.GpuInfo{{Vendor: host.GpuVendorNvidia, ID: "GPU-beef", Vram: 81920}}
+	runner, err := newDockerRunner(context.Background(), client, &dockerParametersMock{}, gpus)
+	require.NoError(t, err)
+
+	first := createTaskConfig(t)
+	first.GPU = 1
+	first.GPUMemoryFraction = 0.6
+	require.NoError(t, runner.Submit(context.Background(), first))
+	go func() { _ = runner.Run(context.Background(), first.ID) }()
+	waitTaskStatus(t, runner, first.ID, TaskStatusRunning)
+	containerID := runner.TaskInfo(first.ID).ContainerID
+	ctr, err := client.getContainer(containerID)
+	require.NoError(t, err)
+	assert.Contains(t, ctr.config.Env, "XLA_PYTHON_CLIENT_MEM_FRACTION=0.6")
+	assert.Contains(t, ctr.config.Env, "TF_GPU_MEMORY_LIMIT=49152")
+	assert.Equal(t, "0.6", ctr.config.Labels[LabelKeyGpuMemoryFraction])
+
+	// 0.6 + 0.6 > 1.0
+	second := createTaskConfig(t)
+	second.GPU = 1
+	second.GPUMemoryFraction = 0.6
+	require.NoError(t, runner.Submit(context.Background(), second))
+	assert.ErrorIs(t, runner.Run(context.Background(), second.ID), ErrNoCapacity)
+	assert.Equal(t, TaskStatusFailed, runner.TaskInfo(second.ID).Status)
+	assert.Equal(t, "EXECUTOR_ERROR", runner.TaskInfo(second.ID).TerminationReason)
+
+	client.exitContainer(containerID, 0)
+	waitTaskStatus(t, runner, first.ID, TaskStatusTerminated)
+	assert.Equal(t, map[string]float64{}, runner.gpuAllocator.devices["GPU-beef"].shares)
+}
+
+func TestDockerRunner_GPUMemoryFraction_SubmitRejected(t *testing.T) {
+	runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{})
+	testCases := []struct {
+		gpu      int
+		fraction float64
+		err      string
+	}{
+		{1, -0.1, "must be in (0.0, 1.0] range"},
+		{1, 1.1, "must be in (0.0, 1.0] range"},
+		{0, 0.5, "no GPUs requested"},
+	}
+	for _, tc := range testCases {
+		cfg := createTaskConfig(t)
+		cfg.GPU = tc.gpu
+		cfg.GPUMemoryFraction = tc.fraction
+		err := runner.Submit(context.Background(), cfg)
+		assert.ErrorIs(t, err, ErrInvalidConfig)
+		assert.ErrorContains(t, err, tc.err)
+	}
+}
+
+func TestDockerRunner_NoGPUs_SubmitRejected(t *testing.T) {
+	runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.GPU = 2
+
+	err := runner.Submit(context.Background(), cfg)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.ErrorContains(t, err, "no GPUs on this host")
+	assert.Equal(t, "", runner.TaskInfo(cfg.ID).ID)
+}
+
+func TestDockerRunner_NoGPUs_AllAvailable(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	assert.Empty(t, runner.Resources(context.Background()).Gpus)
+	cfg := createTaskConfig(t)
+	cfg.GPU = -1
+	containerID := runTask(t, runner, cfg)
+	defer client.exitContainer(containerID, 0)
+
+	assert.Empty(t, runner.TaskInfo(cfg.ID).GpuIDs)
+	ctr, err := client.getContainer(containerID)
+	require.NoError(t, err)
+	assert.Empty(t, ctr.hostConfig.DeviceRequests)
+	assert.Equal(t, []string{TraceIDEnvVar + "=" + runner.TaskInfo(cfg.ID).TraceID}, ctr.config.Env)
+}
+
+func TestDockerRunner_OOMScoreAdj(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.OOMScoreAdj = -500
+
+	require.NoError(t, runner.Submit(context.Background(), cfg))
+	go func() { _ = runner.Run(context.Background(), cfg.ID) }()
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusRunning)
+
+	containerID := runner.TaskInfo(cfg.ID).ContainerID
+	defer client.exitContainer(containerID, 0)
+	ctr, err := client.getContainer(containerID)
+	require.NoError(t, err)
+	assert.Equal(t, -500, ctr.hostConfig.OomScoreAdj)
+}
+
+func TestDockerRunner_OOMScoreAdj_SubmitRejected(t *testing.T) {
+	runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{})
+	for _, oomScoreAdj := range []int{-1001, 1001} {
+		cfg := createTaskConfig(t)
+		cfg.OOMScoreAdj = oomScoreAdj
+		err := runner.Submit(context.Background(), cfg)
+		assert.ErrorIs(t, err, ErrInvalidConfig)
+		assert.ErrorContains(t, err, "oom_score_adj must be in -1000..1000 range")
+	}
+}
+
+func TestDockerRunner_Terminate_StopTimeout(t *testing.T) {
+	override := uint(30)
+	immediate := uint(0)
+	testCases := []struct {
+		name                string
+		stopTimeout         uint
+		override            *uint
+		expectedStopTimeout *int
+	}{
+		// nil means the container's StopTimeout is used by Docker
+		{"fallback to config", 60, nil, nil},
+		{"override", 60, &override, ptr(30)},
+		{"immediate kill", 60, &immediate, ptr(0)},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newFakeDockerClient()
+			runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+			cfg := createTaskConfig(t)
+			cfg.StopTimeout = tc.stopTimeout
+
+			require.NoError(t, runner.Submit(context.Background(), cfg))
+			go func() { _ = runner.Run(context.Background(), cfg.ID) }()
+			waitTaskStatus(t, runner, cfg.ID, TaskStatusRunning)
+			containerID := runner.TaskInfo(cfg.ID).ContainerID
+
+			require.NoError(t, runner.Terminate(context.Background(), cfg.ID, tc.override, "TERMINATED_BY_USER", ""))
+
+			ctr, err := client.getContainer(containerID)
+			require.NoError(t, err)
+			assert.Equal(t, ptr(60), ctr.config.StopTimeout)
+			require.NotNil(t, ctr.stopOptions)
+			assert.Equal(t, tc.expectedStopTimeout, ctr.stopOptions.Timeout)
+			assert.Equal(t, TaskStatusTerminated, runner.TaskInfo(cfg.ID).Status)
+		})
+	}
+}
+
+func TestDockerRunner_StopTimeout_NotSet(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+
+	require.NoError(t, runner.Submit(context.Background(), cfg))
+	go func() { _ = runner.Run(context.Background(), cfg.ID) }()
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusRunning)
+	containerID := runner.TaskInfo(cfg.ID).ContainerID
+	defer client.exitContainer(containerID, 0)
+
+	ctr, err := client.getContainer(containerID)
+	require.NoError(t, err)
+	assert.Nil(t, ctr.config.StopTimeout)
+}
+
+func TestDockerRunner_Wait_AlreadyTerminated(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	containerID := runTask(t, runner, cfg)
+	client.exitContainer(containerID, 0)
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusTerminated)
+
+	start := time.Now()
+	taskInfo, err := runner.Wait(ctx, cfg.ID, time.Minute)
+	require.NoError(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, TaskStatusTerminated, taskInfo.Status)
+	assert.Equal(t, "DONE_BY_RUNNER", taskInfo.TerminationReason)
+}
+
+func TestDockerRunner_Wait_Terminated(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	containerID := runTask(t, runner, cfg)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		client.exitContainer(containerID, 1)
+	}()
+	start := time.Now()
+	taskInfo, err := runner.Wait(ctx, cfg.ID, time.Minute)
+	require.NoError(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Equal(t, TaskStatusFailed, taskInfo.Status)
+	assert.Equal(t, "CONTAINER_EXITED_WITH_ERROR", taskInfo.TerminationReason)
+}
+
+func TestDockerRunner_Wait_Timeout(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	containerID := runTask(t, runner, cfg)
+	defer client.exitContainer(containerID, 0)
+
+	start := time.Now()
+	taskInfo, err := runner.Wait(ctx, cfg.ID, 100*time.Millisecond)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+	assert.Equal(t, cfg.ID, taskInfo.ID)
+	assert.Equal(t, TaskStatusRunning, taskInfo.Status)
+}
+
+func TestDockerRunner_Wait_NotFound(t *testing.T) {
+	runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{})
+	_, err := runner.Wait(context.Background(), "unknown", time.Minute)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
 /* Mocks */
 
 type dockerParametersMock struct {
 	// If sshPort is not set (equals zero), sshd won't be started.
-	commands     []string
-	sshPort      int
-	publicSSHKey string
+	commands                 []string
+	sshPort                  int
+	publicSSHKey             string
+	maxConcurrentTasks       int
+	containerNameHashLength  int
+	auditLog                 AuditLogConfig
+	allowUnconfined          []string
+	allowDockerSocket        []string
+	containerGoneGracePeriod time.Duration
+	registryMirrors          []string
+	registryMirrorFallback   bool
+	maxConcurrentPulls       int
+	privileged               bool
+	circuitBreakerThreshold  int
+	circuitBreakerCooldown   time.Duration
+	stateDir                 string
+	imageSignaturePolicy     string
+	admissionWebhook         AdmissionWebhookConfig
+	coreDumpDir              string
+	gpuDrain                 GPUDrainConfig
+	credentialProvider       CredentialProviderConfig
+	telemetry                TelemetryConfig
+	preflight                PreflightConfig
 }
 
 func (c *dockerParametersMock) DockerPrivileged() bool {
-	return false
+	return c.privileged
 }
 
 func (c *dockerParametersMock) DockerPJRTDevice() string {
 	return ""
 }
 
+func (c *dockerParametersMock) DockerAllowUnconfined() []string {
+	return c.allowUnconfined
+}
+
+func (c *dockerParametersMock) DockerAllowDockerSocket() []string {
+	return c.allowDockerSocket
+}
+
+func (c *dockerParametersMock) DockerContainerGoneGracePeriod() time.Duration {
+	return c.containerGoneGracePeriod
+}
+
+func (c *dockerParametersMock) DockerEndpoint() DockerEndpoint {
+	return DockerEndpoint{}
+}
+
+func (c *dockerParametersMock) ShimAuditLog() AuditLogConfig {
+	return c.auditLog
+}
+
+func (c *dockerParametersMock) ShimStateDir() string {
+	return c.stateDir
+}
+
+func (c *dockerParametersMock) ShimCoreDumpDir() string {
+	return c.coreDumpDir
+}
+
+func (c *dockerParametersMock) ShimAdmissionWebhook() AdmissionWebhookConfig {
+	return c.admissionWebhook
+}
+
+func (c *dockerParametersMock) ShimGPUDrain() GPUDrainConfig {
+	return c.gpuDrain
+}
+
+func (c *dockerParametersMock) ShimCredentialProvider() CredentialProviderConfig {
+	return c.credentialProvider
+}
+
+func (c *dockerParametersMock) ShimTelemetry() TelemetryConfig {
+	return c.telemetry
+}
+
+func (c *dockerParametersMock) ShimPreflight() PreflightConfig {
+	return c.preflight
+}
+
+func (c *dockerParametersMock) ShimMaxConcurrentTasks() int {
+	return c.maxConcurrentTasks
+}
+
+func (c *dockerParametersMock) ShimContainerNameHashLength() int {
+	return c.containerNameHashLength
+}
+
 func (c *dockerParametersMock) DockerShellCommands(publicKeys []string) []string {
 	userPublicKey := c.publicSSHKey
 	if len(publicKeys) > 0 {
@@ -179,6 +688,30 @@ func (c *dockerParametersMock) DockerPorts() []int {
 	return ports
 }
 
+func (c *dockerParametersMock) DockerRegistryMirrors() []string {
+	return c.registryMirrors
+}
+
+func (c *dockerParametersMock) DockerRegistryMirrorFallback() bool {
+	return c.registryMirrorFallback
+}
+
+func (c *dockerParametersMock) DockerCircuitBreakerThreshold() int {
+	return c.circuitBreakerThreshold
+}
+
+func (c *dockerParametersMock) DockerCircuitBreakerCooldown() time.Duration {
+	return c.circuitBreakerCooldown
+}
+
+func (c *dockerParametersMock) DockerImageSignaturePolicy() string {
+	return c.imageSignaturePolicy
+}
+
+func (c *dockerParametersMock) DockerMaxConcurrentPulls() int {
+	return c.maxConcurrentPulls
+}
+
 func (c *dockerParametersMock) DockerMounts(string) ([]mount.Mount, error) {
 	return nil, nil
 }
@@ -187,6 +720,730 @@ func (c *dockerParametersMock) MakeRunnerDir(string) (string, error) {
 	return "", nil
 }
 
+// fakeDockerClient implements a subset of docker.APIClient methods used by DockerRunner
+// to run tasks without Docker daemon. Calling any other method panics, as the embedded
+// interface is nil. Containers "run" until exitContainer() or ContainerStop() is called
+type fakeDockerClient struct {
+	docker.APIClient
+
+	info       system.Info
+	mu         sync.Mutex
+	containers map[string]*fakeContainer
+	volumes    map[string]*volume.Volume
+	pullCount  int
+	pulledRefs []string
+	// references of images present locally, returned by ImageList; pulls don't add images
+	images map[string]bool
+	// the number of ImagePull calls in progress and its peak value
+	pullsInFlight    int
+	maxPullsInFlight int
+	// platforms returned by DistributionInspect
+	imagePlatforms []ocispec.Platform
+	// PullOptions.Platform of the last ImagePull call
+	pullPlatform string
+	// target: source mapping of ImageTag calls
+	tags      map[string]string
+	logsCount int
+	// if set, ImagePull blocks until closed
+	pullGate chan struct{}
+	// if set, returned by ImagePull as the progress stream instead of the immediate success
+	pullStream io.ReadCloser
+	// if set, started containers immediately exit with this output and code
+	crashOnStart *fakeCrash
+	// samples returned by subsequent ContainerStats calls, the last one is repeated
+	stats      []types.StatsJSON
+	statsCount int
+	// method name: errors returned by subsequent calls, see injectErrors()
+	errors map[string][]error
+	// exec ID: exit code, execs complete as soon as started
+	execs map[string]int
+	// exit codes of subsequent execs, the last one is repeated, 0 if not set
+	execExitCodes []int
+	// output of execs started with ContainerExecAttach
+	execStdout string
+	execStderr string
+	// if set, the exec output is never closed, as if the command hangs
+	execHang bool
+	// if set, ContainerStop blocks until closed
+	stopGate  chan struct{}
+	stopCount int
+}
+
+type fakeContainer struct {
+	id         string
+	name       string
+	config     *container.Config
+	hostConfig *container.HostConfig
+	platform   *ocispec.Platform
+	running    bool
+	exitCode   int64
+	exited     chan struct{}
+	// the container side of the last ContainerAttach call
+	attachConn net.Conn
+	// options of the last ContainerStop call
+	stopOptions *container.StopOptions
+	// signals sent by ContainerKill calls, in order
+	signals []string
+	// signals the container doesn't exit on, it exits on any other signal
+	ignoredSignals []string
+	files          map[string]string // absolute path: content
+	fileModes      map[string]int64  // absolute path: mode, set by CopyToContainer
+	logs           []string          // output lines, returned by ContainerLogs
+	logTimes       []time.Time       // capture times of logs lines, the start time if not set
+	stateError     string
+	oomKilled      bool
+	startedAt      time.Time
+	finishedAt     time.Time
+	// commands of ContainerExecCreate calls, in order
+	execCmds [][]string
+	// config of the last ContainerExecCreate call
+	lastExec types.ExecConfig
+	// returned by ContainerDiff
+	changes []container.FilesystemChange
+}
+
+type fakeCrash struct {
+	exitCode   int64
+	logs       []string
+	stateError string
+}
+
+func newFakeDockerClient() *fakeDockerClient {
+	return &fakeDockerClient{
+		containers: make(map[string]*fakeContainer),
+		volumes:    make(map[string]*volume.Volume),
+		tags:       make(map[string]string),
+		errors:     make(map[string][]error),
+		execs:      make(map[string]int),
+	}
+}
+
+// injectErrors makes the next len(errs) calls of the method fail with the given errors,
+// nil error means "call as usual"
+func (c *fakeDockerClient) injectErrors(method string, errs ...error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.errors[method] = append(c.errors[method], errs...)
+}
+
+func (c *fakeDockerClient) popError(method string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	errs := c.errors[method]
+	if len(errs) == 0 {
+		return nil
+	}
+	c.errors[method] = errs[1:]
+	return errs[0]
+}
+
+// exitContainer makes the container exit with the given code
+func (c *fakeDockerClient) exitContainer(id string, code int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ctr, ok := c.containers[id]
+	if !ok || !ctr.running {
+		return
+	}
+	ctr.running = false
+	ctr.exitCode = code
+	ctr.finishedAt = time.Now()
+	close(ctr.exited)
+}
+
+// addContainer adds the container as if it was created by the previous shim
+func (c *fakeDockerClient) addContainer(ctr *fakeContainer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ctr.exited = make(chan struct{})
+	if !ctr.running {
+		close(ctr.exited)
+	}
+	ctr.files = make(map[string]string)
+	ctr.fileModes = make(map[string]int64)
+	if ctr.hostConfig == nil {
+		ctr.hostConfig = &container.HostConfig{}
+	}
+	c.containers[ctr.id] = ctr
+}
+
+// writeFile creates a regular file inside the container
+func (c *fakeDockerClient) writeFile(id string, path string, content string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.containers[id].files[path] = content
+}
+
+func (c *fakeDockerClient) getContainer(id string) (*fakeContainer, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ctr, ok := c.containers[id]
+	if !ok {
+		return nil, errdefs.NotFound(fmt.Errorf("no such container: %s", id))
+	}
+	return ctr, nil
+}
+
+func (c *fakeDockerClient) Info(context.Context) (system.Info, error) {
+	return c.info, nil
+}
+
+func (c *fakeDockerClient) Ping(context.Context) (types.Ping, error) {
+	if err := c.popError("Ping"); err != nil {
+		return types.Ping{}, err
+	}
+	return types.Ping{APIVersion: "1.45"}, nil
+}
+
+// ContainerList returns all task containers, options are ignored
+func (c *fakeDockerClient) ContainerList(context.Context, container.ListOptions) ([]types.Container, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var containers []types.Container
+	for _, ctr := range c.containers {
+		if ctr.config == nil || ctr.config.Labels[LabelKeyIsTask] != LabelValueTrue {
+			continue
+		}
+		state := "running"
+		status := "Up"
+		if !ctr.running {
+			state = "exited"
+			status = fmt.Sprintf("Exited (%d)", ctr.exitCode)
+		}
+		containers = append(containers, types.Container{
+			ID:     ctr.id,
+			Names:  []string{"/" + ctr.name},
+			Labels: ctr.config.Labels,
+			State:  state,
+			Status: status,
+		})
+	}
+	return containers, nil
+}
+
+func (c *fakeDockerClient) ImageList(ctx context.Context, options image.ListOptions) ([]image.Summary, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var images []image.Summary
+	for _, ref := range options.Filters.Get("reference") {
+		if c.images[ref] {
+			images = append(images, image.Summary{ID: ref, RepoTags: []string{ref}})
+		}
+	}
+	return images, nil
+}
+
+func (c *fakeDockerClient) ImagePull(ctx context.Context, ref string, options image.PullOptions) (io.ReadCloser, error) {
+	c.mu.Lock()
+	c.pullCount++
+	c.pulledRefs = append(c.pulledRefs, ref)
+	c.pullPlatform = options.Platform
+	c.pullsInFlight++
+	c.maxPullsInFlight = max(c.maxPullsInFlight, c.pullsInFlight)
+	pullGate := c.pullGate
+	pullStream := c.pullStream
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.pullsInFlight--
+		c.mu.Unlock()
+	}()
+	if pullGate != nil {
+		select {
+		case <-pullGate:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if err := c.popError("ImagePull"); err != nil {
+		return nil, err
+	}
+	if pullStream != nil {
+		return pullStream, nil
+	}
+	progress := fmt.Sprintf(`{"status":"Status: Downloaded newer image for %s"}`, ref)
+	return io.NopCloser(strings.NewReader(progress + "\n")), nil
+}
+
+func (c *fakeDockerClient) DistributionInspect(ctx context.Context, image, encodedRegistryAuth string) (registry.DistributionInspect, error) {
+	if err := c.popError("DistributionInspect"); err != nil {
+		return registry.DistributionInspect{}, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return registry.DistributionInspect{Platforms: c.imagePlatforms}, nil
+}
+
+// The digest of all images, returned by ImageInspectWithRaw as a repo digest
+const fakeImageDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func (c *fakeDockerClient) ImageInspectWithRaw(ctx context.Context, image string) (types.ImageInspect, []byte, error) {
+	if err := c.popError("ImageInspectWithRaw"); err != nil {
+		return types.ImageInspect{}, nil, err
+	}
+	name, _, _ := strings.Cut(image, ":")
+	return types.ImageInspect{ID: image, RepoDigests: []string{name + "@" + fakeImageDigest}}, nil, nil
+}
+
+func (c *fakeDockerClient) ImageTag(ctx context.Context, source, target string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tags[target] = source
+	return nil
+}
+
+func (c *fakeDockerClient) ContainerCreate(
+	ctx context.Context, config *container.Config, hostConfig *container.HostConfig,
+	networkingConfig *network.NetworkingConfig, platform *ocispec.Platform, containerName string,
+) (container.CreateResponse, error) {
+	if err := c.popError("ContainerCreate"); err != nil {
+		return container.CreateResponse{}, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := containerName
+	c.containers[id] = &fakeContainer{
+		id:         id,
+		name:       containerName,
+		config:     config,
+		hostConfig: hostConfig,
+		platform:   platform,
+		exited:     make(chan struct{}),
+		files:      make(map[string]string),
+		fileModes:  make(map[string]int64),
+	}
+	return container.CreateResponse{ID: id}, nil
+}
+
+func (c *fakeDockerClient) ContainerStart(ctx context.Context, id string, options container.StartOptions) error {
+	if err := c.popError("ContainerStart"); err != nil {
+		return err
+	}
+	ctr, err := c.getContainer(id)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	ctr.running = true
+	ctr.startedAt = time.Now()
+	crash := c.crashOnStart
+	if crash != nil {
+		ctr.logs = crash.logs
+		ctr.stateError = crash.stateError
+	}
+	c.mu.Unlock()
+	if crash != nil {
+		c.exitContainer(id, crash.exitCode)
+	}
+	return nil
+}
+
+func (c *fakeDockerClient) ContainerInspect(ctx context.Context, id string) (types.ContainerJSON, error) {
+	if err := c.popError("ContainerInspect"); err != nil {
+		return types.ContainerJSON{}, err
+	}
+	ctr, err := c.getContainer(id)
+	if err != nil {
+		return types.ContainerJSON{}, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state := &types.ContainerState{
+		Running: ctr.running, ExitCode: int(ctr.exitCode), Error: ctr.stateError, OOMKilled: ctr.oomKilled,
+	}
+	if !ctr.startedAt.IsZero() {
+		state.StartedAt = ctr.startedAt.Format(time.RFC3339Nano)
+	}
+	if !ctr.finishedAt.IsZero() {
+		state.FinishedAt = ctr.finishedAt.Format(time.RFC3339Nano)
+	}
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:         ctr.id,
+			Name:       "/" + ctr.name,
+			State:      state,
+			HostConfig: ctr.hostConfig,
+		},
+		Config:          ctr.config,
+		NetworkSettings: &types.NetworkSettings{},
+	}, nil
+}
+
+// ContainerAttach returns one end of an in-memory connection, the other end is stored
+// as fakeContainer.attachConn to act as the container process
+func (c *fakeDockerClient) ContainerAttach(ctx context.Context, id string, options container.AttachOptions) (types.HijackedResponse, error) {
+	if err := c.popError("ContainerAttach"); err != nil {
+		return types.HijackedResponse{}, err
+	}
+	ctr, err := c.getContainer(id)
+	if err != nil {
+		return types.HijackedResponse{}, err
+	}
+	clientConn, containerConn := net.Pipe()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ctr.attachConn = containerConn
+	mediaType := "application/vnd.docker.multiplexed-stream"
+	if ctr.config.Tty {
+		mediaType = "application/vnd.docker.raw-stream"
+	}
+	return types.NewHijackedResponse(clientConn, mediaType), nil
+}
+
+func (c *fakeDockerClient) ContainerWait(
+	ctx context.Context, id string, condition container.WaitCondition,
+) (<-chan container.WaitResponse, <-chan error) {
+	waitCh := make(chan container.WaitResponse, 1)
+	errCh := make(chan error, 1)
+	if err := c.popError("ContainerWait"); err != nil {
+		errCh <- err
+		return waitCh, errCh
+	}
+	ctr, err := c.getContainer(id)
+	if err != nil {
+		errCh <- err
+		return waitCh, errCh
+	}
+	go func() {
+		select {
+		case <-ctr.exited:
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			waitCh <- container.WaitResponse{StatusCode: ctr.exitCode}
+		case <-ctx.Done():
+			errCh <- ctx.Err()
+		}
+	}()
+	return waitCh, errCh
+}
+
+func (c *fakeDockerClient) ContainerStop(ctx context.Context, id string, options container.StopOptions) error {
+	if err := c.popError("ContainerStop"); err != nil {
+		return err
+	}
+	if _, err := c.getContainer(id); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.containers[id].stopOptions = &options
+	c.stopCount++
+	gate := c.stopGate
+	c.mu.Unlock()
+	if gate != nil {
+		<-gate
+	}
+	// 128 + SIGKILL
+	c.exitContainer(id, 137)
+	return nil
+}
+
+// ContainerKill exits the container with 128 + the signal number, unless the signal is ignored.
+// Like Docker, it fails with a conflict error if the container is not running
+func (c *fakeDockerClient) ContainerExecCreate(ctx context.Context, id string, config types.ExecConfig) (types.IDResponse, error) {
+	if err := c.popError("ContainerExecCreate"); err != nil {
+		return types.IDResponse{}, err
+	}
+	ctr, err := c.getContainer(id)
+	if err != nil {
+		return types.IDResponse{}, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !ctr.running {
+		return types.IDResponse{}, errdefs.Conflict(fmt.Errorf("container %s is not running", id))
+	}
+	ctr.execCmds = append(ctr.execCmds, config.Cmd)
+	ctr.lastExec = config
+	exitCode := 0
+	if len(c.execExitCodes) > 0 {
+		exitCode = c.execExitCodes[0]
+		if len(c.execExitCodes) > 1 {
+			c.execExitCodes = c.execExitCodes[1:]
+		}
+	}
+	execID := fmt.Sprintf("exec-%d", len(c.execs))
+	c.execs[execID] = exitCode
+	return types.IDResponse{ID: execID}, nil
+}
+
+func (c *fakeDockerClient) ContainerExecStart(ctx context.Context, execID string, config types.ExecStartCheck) error {
+	if err := c.popError("ContainerExecStart"); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.execs[execID]; !ok {
+		return errdefs.NotFound(fmt.Errorf("no such exec: %s", execID))
+	}
+	return nil
+}
+
+// ContainerExecAttach starts the exec, the output is execStdout followed by execStderr, multiplexed
+func (c *fakeDockerClient) ContainerExecAttach(ctx context.Context, execID string, config types.ExecStartCheck) (types.HijackedResponse, error) {
+	if err := c.popError("ContainerExecAttach"); err != nil {
+		return types.HijackedResponse{}, err
+	}
+	c.mu.Lock()
+	_, ok := c.execs[execID]
+	stdout, stderr, hang := c.execStdout, c.execStderr, c.execHang
+	c.mu.Unlock()
+	if !ok {
+		return types.HijackedResponse{}, errdefs.NotFound(fmt.Errorf("no such exec: %s", execID))
+	}
+	clientConn, execConn := net.Pipe()
+	go func() {
+		if stdout != "" {
+			_, _ = stdcopy.NewStdWriter(execConn, stdcopy.Stdout).Write([]byte(stdout))
+		}
+		if stderr != "" {
+			_, _ = stdcopy.NewStdWriter(execConn, stdcopy.Stderr).Write([]byte(stderr))
+		}
+		if !hang {
+			_ = execConn.Close()
+		}
+	}()
+	return types.NewHijackedResponse(clientConn, "application/vnd.docker.multiplexed-stream"), nil
+}
+
+func (c *fakeDockerClient) ContainerExecInspect(ctx context.Context, execID string) (types.ContainerExecInspect, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	exitCode, ok := c.execs[execID]
+	if !ok {
+		return types.ContainerExecInspect{}, errdefs.NotFound(fmt.Errorf("no such exec: %s", execID))
+	}
+	return types.ContainerExecInspect{ExecID: execID, ExitCode: exitCode}, nil
+}
+
+func (c *fakeDockerClient) ContainerKill(ctx context.Context, id string, signal string) error {
+	if err := c.popError("ContainerKill"); err != nil {
+		return err
+	}
+	ctr, err := c.getContainer(id)
+	if err != nil {
+		return err
+	}
+	num, err := parseSignal(signal)
+	if err != nil {
+		return errdefs.InvalidParameter(err)
+	}
+	c.mu.Lock()
+	if !ctr.running {
+		c.mu.Unlock()
+		return errdefs.Conflict(fmt.Errorf("container %s is not running", id))
+	}
+	ctr.signals = append(ctr.signals, signal)
+	ignored := slices.Contains(ctr.ignoredSignals, signal)
+	c.mu.Unlock()
+	if !ignored {
+		c.exitContainer(id, 128+int64(num))
+	}
+	return nil
+}
+
+func (c *fakeDockerClient) ContainerRemove(ctx context.Context, id string, options container.RemoveOptions) error {
+	if err := c.popError("ContainerRemove"); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.containers[id]; !ok {
+		return errdefs.NotFound(fmt.Errorf("no such container: %s", id))
+	}
+	delete(c.containers, id)
+	return nil
+}
+
+func (c *fakeDockerClient) ContainerStats(ctx context.Context, id string, stream bool) (types.ContainerStats, error) {
+	if err := c.popError("ContainerStats"); err != nil {
+		return types.ContainerStats{}, err
+	}
+	if _, err := c.getContainer(id); err != nil {
+		return types.ContainerStats{}, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var stats types.StatsJSON
+	if len(c.stats) > 0 {
+		stats = c.stats[min(c.statsCount, len(c.stats)-1)]
+	}
+	c.statsCount++
+	body, err := json.Marshal(stats)
+	if err != nil {
+		return types.ContainerStats{}, err
+	}
+	return types.ContainerStats{Body: io.NopCloser(bytes.NewReader(body)), OSType: "linux"}, nil
+}
+
+// ContainerLogs writes all lines to stdout, the output is multiplexed unless the container has TTY.
+// With options.Timestamps, each line is prefixed with its capture time (logTimes) or the container
+// start time as Docker does
+func (c *fakeDockerClient) ContainerLogs(ctx context.Context, id string, options container.LogsOptions) (io.ReadCloser, error) {
+	ctr, err := c.getContainer(id)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.logsCount++
+	lines := ctr.logs
+	if tail, err := strconv.Atoi(options.Tail); err == nil && tail < len(lines) {
+		lines = lines[len(lines)-tail:]
+	}
+	buf := new(bytes.Buffer)
+	var w io.Writer = buf
+	if !ctr.config.Tty {
+		w = stdcopy.NewStdWriter(buf, stdcopy.Stdout)
+	}
+	offset := len(ctr.logs) - len(lines)
+	for i, line := range lines {
+		if options.Timestamps {
+			capturedAt := ctr.startedAt
+			if offset+i < len(ctr.logTimes) {
+				capturedAt = ctr.logTimes[offset+i]
+			}
+			line = capturedAt.UTC().Format(logTimestampLayout) + " " + line
+		}
+		_, _ = w.Write([]byte(line + "\n"))
+	}
+	return io.NopCloser(buf), nil
+}
+
+// VolumeCreate returns the existing volume as is if the name is taken, as Docker does
+func (c *fakeDockerClient) VolumeCreate(ctx context.Context, options volume.CreateOptions) (volume.Volume, error) {
+	if err := c.popError("VolumeCreate"); err != nil {
+		return volume.Volume{}, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if vol, ok := c.volumes[options.Name]; ok {
+		return *vol, nil
+	}
+	vol := &volume.Volume{Name: options.Name, Driver: "local", Labels: options.Labels}
+	c.volumes[options.Name] = vol
+	return *vol, nil
+}
+
+// VolumeList supports `label=key=value` filters only
+func (c *fakeDockerClient) VolumeList(ctx context.Context, options volume.ListOptions) (volume.ListResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	resp := volume.ListResponse{Volumes: []*volume.Volume{}}
+	for _, vol := range c.volumes {
+		matched := true
+		for _, label := range options.Filters.Get("label") {
+			key, value, _ := strings.Cut(label, "=")
+			if vol.Labels[key] != value {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			volCopy := *vol
+			resp.Volumes = append(resp.Volumes, &volCopy)
+		}
+	}
+	return resp, nil
+}
+
+func (c *fakeDockerClient) VolumeRemove(ctx context.Context, volumeID string, force bool) error {
+	if err := c.popError("VolumeRemove"); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.volumes[volumeID]; !ok {
+		return errdefs.NotFound(fmt.Errorf("no such volume: %s", volumeID))
+	}
+	for _, ctr := range c.containers {
+		for _, m := range ctr.hostConfig.Mounts {
+			if m.Type == mount.TypeVolume && m.Source == volumeID {
+				return errdefs.Conflict(fmt.Errorf("volume is in use: %s", volumeID))
+			}
+		}
+	}
+	delete(c.volumes, volumeID)
+	return nil
+}
+
+// CopyFromContainer returns a tar archive with all the files under srcPath,
+// entry names are relative to the srcPath parent, as in Docker
+func (c *fakeDockerClient) ContainerDiff(ctx context.Context, id string) ([]container.FilesystemChange, error) {
+	if err := c.popError("ContainerDiff"); err != nil {
+		return nil, err
+	}
+	ctr, err := c.getContainer(id)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(ctr.changes), nil
+}
+
+func (c *fakeDockerClient) CopyFromContainer(ctx context.Context, id string, srcPath string) (io.ReadCloser, types.ContainerPathStat, error) {
+	ctr, err := c.getContainer(id)
+	if err != nil {
+		return nil, types.ContainerPathStat{}, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	found := false
+	for path, content := range ctr.files {
+		if path != srcPath && !strings.HasPrefix(path, srcPath+"/") {
+			continue
+		}
+		found = true
+		name, _ := filepath.Rel(filepath.Dir(srcPath), path)
+		hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(content))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, types.ContainerPathStat{}, err
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			return nil, types.ContainerPathStat{}, err
+		}
+	}
+	if !found {
+		return nil, types.ContainerPathStat{}, errdefs.NotFound(fmt.Errorf("no such file or directory: %s", srcPath))
+	}
+	if err := tw.Close(); err != nil {
+		return nil, types.ContainerPathStat{}, err
+	}
+	return io.NopCloser(&buf), types.ContainerPathStat{Name: filepath.Base(srcPath)}, nil
+}
+
+// CopyToContainer extracts regular files from the tar archive under dstPath
+func (c *fakeDockerClient) CopyToContainer(ctx context.Context, id string, dstPath string, content io.Reader, options types.CopyToContainerOptions) error {
+	if err := c.popError("CopyToContainer"); err != nil {
+		return err
+	}
+	ctr, err := c.getContainer(id)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tr := tar.NewReader(content)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dstPath, hdr.Name)
+		ctr.files[path] = string(data)
+		ctr.fileModes[path] = hdr.Mode
+	}
+}
+
 /* Utilities */
 
 var portNumber int32 = 10000
@@ -210,6 +1467,33 @@ func generateID(t *testing.T) string {
 	return hex.EncodeToString(b)[:idLen]
 }
 
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func newFakeDockerRunner(t *testing.T, client docker.APIClient, params DockerParameters) *DockerRunner {
+	runner, err := newDockerRunner(context.Background(), client, params, []host.GpuInfo{})
+	require.NoError(t, err)
+	return runner
+}
+
+// runTask submits and runs the task in background, waiting for the running status
+func runTask(t *testing.T, runner *DockerRunner, cfg TaskConfig) string {
+	t.Helper()
+	require.NoError(t, runner.Submit(context.Background(), cfg))
+	go func() { _ = runner.Run(context.Background(), cfg.ID) }()
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusRunning)
+	return runner.TaskInfo(cfg.ID).ContainerID
+}
+
+// waitTaskStatus waits until the task reaches the given status
+func waitTaskStatus(t *testing.T, runner *DockerRunner, taskID string, status TaskStatus) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		return runner.TaskInfo(taskID).Status == status
+	}, 5*time.Second, 10*time.Millisecond, "task %s: expected %s status", taskID, status)
+}
+
 func createTaskConfig(t *testing.T) TaskConfig {
 	return TaskConfig{
 		ID:        generateID(t),
@@ -217,3 +1501,48 @@ func createTaskConfig(t *testing.T) TaskConfig {
 		ImageName: "ubuntu",
 	}
 }
+
+func TestDockerRunner_GPUCapabilities(t *testing.T) {
+	testCases := []struct {
+		requested []string
+		env       string
+		caps      []string
+	}{
+		{nil, "compute,utility", []string{"gpu", "compute", "utility"}},
+		{[]string{"video", "compute", "utility", "video"}, "compute,utility,video", []string{"gpu", "compute", "utility", "video"}},
+		{[]string{"graphics", "all"}, "compute,compat32,graphics,utility,video,display", []string{"gpu", "compute", "compat32", "graphics", "utility", "video", "display"}},
+	}
+	for _, tc := range testCases {
+		client := newFakeDockerClient()
+		gpus := []host.GpuInfo{{Vendor: host.GpuVendorNvidia, ID: "GPU-beef"}}
+		runner, err := newDockerRunner(context.Background(), client, &dockerParametersMock{}, gpus)
+		require.NoError(t, err)
+		cfg := createTaskConfig(t)
+		cfg.GPU = 1
+		cfg.GPUCapabilities = tc.requested
+		containerID := runTask(t, runner, cfg)
+
+		ctr, err := client.getContainer(containerID)
+		require.NoError(t, err)
+		assert.Contains(t, ctr.config.Env, "NVIDIA_DRIVER_CAPABILITIES="+tc.env, tc.requested)
+		require.Len(t, ctr.hostConfig.DeviceRequests, 1)
+		assert.Equal(t, [][]string{tc.caps}, ctr.hostConfig.DeviceRequests[0].Capabilities, tc.requested)
+		assert.Equal(t, []string{"GPU-beef"}, ctr.hostConfig.DeviceRequests[0].DeviceIDs)
+		client.exitContainer(containerID, 0)
+	}
+}
+
+func TestDockerRunner_GPUCapabilities_SubmitRejected(t *testing.T) {
+	runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{})
+
+	cfg := createTaskConfig(t)
+	cfg.GPU = 1
+	cfg.GPUCapabilities = []string{"compute", "opengl"}
+	err := runner.Submit(context.Background(), cfg)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.ErrorContains(t, err, "unknown GPU capability \"opengl\"")
+
+	cfg = createTaskConfig(t)
+	cfg.GPUCapabilities = []string{"compute"}
+	assert.ErrorIs(t, runner.Submit(context.Background(), cfg), ErrInvalidConfig)
+}
@@ -0,0 +1,110 @@
+package shim
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/errdefs"
+	"github.com/dstackai/dstack/runner/internal/log"
+)
+
+const (
+	// Set to "true" on Docker volumes created by DockerRunner, used for identification.
+	// LabelKeyTaskID is set to the ID of the task that created the volume
+	LabelKeyIsTaskVolume = LabelKeyPrefix + "is-task-volume"
+	// Set to "true" on volumes that must survive task removal
+	LabelKeyVolumePersistent = LabelKeyPrefix + "persistent"
+)
+
+// createDockerVolumes creates Docker named volumes requested by the task, if they don't
+// exist yet, and returns corresponding mounts.
+// Existing volumes are reused as is, their labels are not updated, that is, a volume
+// is owned by the task that created it (if it was created by the shim at all)
+func (d *DockerRunner) createDockerVolumes(ctx context.Context, task *Task) ([]mount.Mount, error) {
+	mounts := []mount.Mount{}
+	for _, dockerVolume := range task.config.DockerVolumes {
+		labels := map[string]string{
+			LabelKeyIsTaskVolume: LabelValueTrue,
+			LabelKeyTaskID:       task.ID,
+		}
+		if dockerVolume.Persistent {
+			labels[LabelKeyVolumePersistent] = LabelValueTrue
+		}
+		vol, err := d.client.VolumeCreate(ctx, volume.CreateOptions{Name: dockerVolume.Name, Labels: labels})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create volume %s: %w", dockerVolume.Name, err)
+		}
+		log.Debug(ctx, "created volume", "task", task.ID, "name", vol.Name, "owner", vol.Labels[LabelKeyTaskID])
+		mounts = append(mounts, mount.Mount{Type: mount.TypeVolume, Source: vol.Name, Target: dockerVolume.Path})
+	}
+	return mounts, nil
+}
+
+// removeDockerVolumes removes non-persistent volumes created by the task
+// The task container must be removed beforehand, otherwise the volumes are in use
+func (d *DockerRunner) removeDockerVolumes(ctx context.Context, taskID string) error {
+	volumes, err := d.listDockerVolumes(ctx, filters.Arg("label", fmt.Sprintf("%s=%s", LabelKeyTaskID, taskID)))
+	if err != nil {
+		return err
+	}
+	for _, vol := range volumes {
+		if err := d.removeDockerVolume(ctx, vol); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeOrphanedDockerVolumes removes non-persistent volumes of tasks unknown to the shim,
+// e.g., if the container was removed while the shim was not running
+// Should be called after the state is restored from containers
+func (d *DockerRunner) removeOrphanedDockerVolumes(ctx context.Context) error {
+	volumes, err := d.listDockerVolumes(ctx)
+	if err != nil {
+		return err
+	}
+	for _, vol := range volumes {
+		taskID := vol.Labels[LabelKeyTaskID]
+		if _, ok := d.tasks.Get(taskID); ok {
+			continue
+		}
+		log.Info(ctx, "removing orphaned volume", "name", vol.Name, "task", taskID)
+		if err := d.removeDockerVolume(ctx, vol); err != nil {
+			log.Error(ctx, "failed to remove orphaned volume", "name", vol.Name, "err", err)
+		}
+	}
+	return nil
+}
+
+// listDockerVolumes returns volumes created by the shim, additionally filtered by args
+func (d *DockerRunner) listDockerVolumes(ctx context.Context, args ...filters.KeyValuePair) ([]*volume.Volume, error) {
+	args = append(args, filters.Arg("label", fmt.Sprintf("%s=%s", LabelKeyIsTaskVolume, LabelValueTrue)))
+	resp, err := d.client.VolumeList(ctx, volume.ListOptions{Filters: filters.NewArgs(args...)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get volume list: %w", err)
+	}
+	return resp.Volumes, nil
+}
+
+func (d *DockerRunner) removeDockerVolume(ctx context.Context, vol *volume.Volume) error {
+	if vol.Labels[LabelKeyVolumePersistent] == LabelValueTrue {
+		log.Debug(ctx, "skip removing persistent volume", "name", vol.Name)
+		return nil
+	}
+	if err := d.client.VolumeRemove(ctx, vol.Name, false); err != nil {
+		if errdefs.IsNotFound(err) {
+			return nil
+		}
+		if errdefs.IsConflict(err) {
+			// used by another task's container, will be removed by the startup sweep
+			log.Warning(ctx, "skip removing volume: in use", "name", vol.Name, "err", err)
+			return nil
+		}
+		return fmt.Errorf("failed to remove volume %s: %w", vol.Name, err)
+	}
+	log.Debug(ctx, "removed volume", "name", vol.Name)
+	return nil
+}
@@ -0,0 +1,84 @@
+package shim
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDockerRunner_DockerVolumes_Lifecycle(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.DockerVolumes = []DockerVolumeMount{
+		{Name: "cache", Path: "/cache"},
+		{Name: "data", Path: "/data", Persistent: true},
+	}
+	containerID := runTask(t, runner, cfg)
+
+	ctr, err := client.getContainer(containerID)
+	require.NoError(t, err)
+	assert.Subset(t, ctr.hostConfig.Mounts, []mount.Mount{
+		{Type: mount.TypeVolume, Source: "cache", Target: "/cache"},
+		{Type: mount.TypeVolume, Source: "data", Target: "/data"},
+	})
+	assert.Equal(t, map[string]string{
+		LabelKeyIsTaskVolume: LabelValueTrue,
+		LabelKeyTaskID:       cfg.ID,
+	}, client.volumes["cache"].Labels)
+	assert.Equal(t, map[string]string{
+		LabelKeyIsTaskVolume:     LabelValueTrue,
+		LabelKeyTaskID:           cfg.ID,
+		LabelKeyVolumePersistent: LabelValueTrue,
+	}, client.volumes["data"].Labels)
+
+	client.exitContainer(containerID, 0)
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusTerminated)
+	require.NoError(t, runner.Remove(context.Background(), cfg.ID))
+
+	assert.NotContains(t, client.volumes, "cache")
+	assert.Contains(t, client.volumes, "data")
+}
+
+func TestDockerRunner_DockerVolumes_ExistingVolumeNotRemoved(t *testing.T) {
+	client := newFakeDockerClient()
+	// created by the user, not by the shim
+	client.volumes["models"] = &volume.Volume{Name: "models"}
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.DockerVolumes = []DockerVolumeMount{{Name: "models", Path: "/models"}}
+	containerID := runTask(t, runner, cfg)
+
+	client.exitContainer(containerID, 0)
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusTerminated)
+	require.NoError(t, runner.Remove(context.Background(), cfg.ID))
+
+	assert.Contains(t, client.volumes, "models")
+}
+
+func TestDockerRunner_DockerVolumes_StartupSweep(t *testing.T) {
+	client := newFakeDockerClient()
+	for _, persistent := range []bool{false, true} {
+		labels := map[string]string{
+			LabelKeyIsTaskVolume: LabelValueTrue,
+			LabelKeyTaskID:       "gone",
+		}
+		if persistent {
+			labels[LabelKeyVolumePersistent] = LabelValueTrue
+		}
+		name := fmt.Sprintf("orphaned-persistent-%t", persistent)
+		client.volumes[name] = &volume.Volume{Name: name, Labels: labels}
+	}
+	client.volumes["user"] = &volume.Volume{Name: "user"}
+
+	newFakeDockerRunner(t, client, &dockerParametersMock{})
+
+	assert.NotContains(t, client.volumes, "orphaned-persistent-false")
+	assert.Contains(t, client.volumes, "orphaned-persistent-true")
+	assert.Contains(t, client.volumes, "user")
+}
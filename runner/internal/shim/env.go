@@ -0,0 +1,160 @@
+package shim
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/dstackai/dstack/runner/internal/shim/host"
+)
+
+// Template variables that can be used in TaskConfig.Env values as ${NAME}, expanded
+// with host facts before the container is created. The set is fixed, unknown names are rejected
+const (
+	// The first non-loopback IPv4 address of the host
+	EnvTemplateHostIP = "HOST_IP"
+	// The host name
+	EnvTemplateHostName = "HOST_NAME"
+	// The number of host CPUs
+	EnvTemplateHostCPUCount = "HOST_CPU_COUNT"
+	// The number of host GPUs, including ones not allocated to the task
+	EnvTemplateHostGPUCount = "HOST_GPU_COUNT"
+	// The number of GPUs allocated to the task
+	EnvTemplateNodeGPUCount = "NODE_GPU_COUNT"
+	// Comma-separated IDs of GPUs allocated to the task, see host.GpuInfo.ID
+	EnvTemplateNodeGPUIDs = "NODE_GPU_IDS"
+	// The task ID
+	EnvTemplateTaskID = "TASK_ID"
+)
+
+var envTemplateVars = []string{
+	EnvTemplateHostIP,
+	EnvTemplateHostName,
+	EnvTemplateHostCPUCount,
+	EnvTemplateHostGPUCount,
+	EnvTemplateNodeGPUCount,
+	EnvTemplateNodeGPUIDs,
+	EnvTemplateTaskID,
+}
+
+// validateEnv checks variable names and template references in values, the values of
+// template variables themselves are only known after GPUs are allocated
+func validateEnv(env map[string]string) error {
+	for name, value := range env {
+		if name == "" || strings.ContainsAny(name, "=\x00") {
+			return fmt.Errorf("%w: invalid env variable name %q", ErrInvalidConfig, name)
+		}
+		_, err := expandEnvTemplate(value, func(string) (string, error) { return "", nil })
+		if err != nil {
+			return fmt.Errorf("%w: env variable %s: %w", ErrInvalidConfig, name, err)
+		}
+	}
+	return nil
+}
+
+// expandEnvTemplate replaces ${NAME} references with values returned by resolve, NAME must be
+// one of envTemplateVars. $${ is an escape for a literal ${, other $ characters are kept as is,
+// that is, values without templates are not changed
+func expandEnvTemplate(value string, resolve func(name string) (string, error)) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.Index(value, "${")
+		if i < 0 {
+			b.WriteString(value)
+			return b.String(), nil
+		}
+		if i > 0 && value[i-1] == '$' {
+			b.WriteString(value[:i-1])
+			b.WriteString("${")
+			value = value[i+2:]
+			continue
+		}
+		b.WriteString(value[:i])
+		end := strings.IndexByte(value[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated template reference in %q", value[i:])
+		}
+		name := value[i+2 : i+end]
+		if !slices.Contains(envTemplateVars, name) {
+			return "", fmt.Errorf("unknown template variable %q, expected one of %s", name, strings.Join(envTemplateVars, ", "))
+		}
+		resolved, err := resolve(name)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(resolved)
+		value = value[i+end+1:]
+	}
+}
+
+// getTaskEnv returns TaskConfig.Env in the KEY=value form, sorted by name, with templates expanded
+func (d *DockerRunner) getTaskEnv(ctx context.Context, task *Task) ([]string, error) {
+	names := make([]string, 0, len(task.config.Env))
+	for name := range task.config.Env {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	resolve := func(name string) (string, error) {
+		return d.resolveEnvTemplateVar(ctx, task, name)
+	}
+	envVars := make([]string, 0, len(names))
+	for _, name := range names {
+		value, err := expandEnvTemplate(task.config.Env[name], resolve)
+		if err != nil {
+			return nil, fmt.Errorf("%w: env variable %s: %w", ErrInvalidConfig, name, err)
+		}
+		envVars = append(envVars, fmt.Sprintf("%s=%s", name, value))
+	}
+	return envVars, nil
+}
+
+// mergeEnv appends variables to the base ones, skipping already set names, that is, variables
+// set by the shim cannot be overridden by the task Env
+func mergeEnv(base []string, envVars []string) []string {
+	merged := slices.Clone(base)
+	for _, envVar := range envVars {
+		name, _, _ := strings.Cut(envVar, "=")
+		if !slices.ContainsFunc(base, func(v string) bool { return strings.HasPrefix(v, name+"=") }) {
+			merged = append(merged, envVar)
+		}
+	}
+	return merged
+}
+
+func (d *DockerRunner) resolveEnvTemplateVar(ctx context.Context, task *Task, name string) (string, error) {
+	switch name {
+	case EnvTemplateHostIP:
+		return getHostIPv4(ctx)
+	case EnvTemplateHostName:
+		return os.Hostname()
+	case EnvTemplateHostCPUCount:
+		return strconv.Itoa(host.GetCpuCount(ctx)), nil
+	case EnvTemplateHostGPUCount:
+		return strconv.Itoa(len(d.gpus)), nil
+	case EnvTemplateNodeGPUCount:
+		return strconv.Itoa(len(task.gpuIDs)), nil
+	case EnvTemplateNodeGPUIDs:
+		return strings.Join(task.gpuIDs, ","), nil
+	case EnvTemplateTaskID:
+		return task.ID, nil
+	}
+	return "", fmt.Errorf("unknown template variable %q", name)
+}
+
+func getHostIPv4(ctx context.Context) (string, error) {
+	addresses, err := host.GetNetworkAddresses(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, address := range addresses {
+		ip, _, err := net.ParseCIDR(address)
+		if err == nil && ip.To4() != nil {
+			return ip.String(), nil
+		}
+	}
+	return "", fmt.Errorf("no IPv4 address found for %s", EnvTemplateHostIP)
+}
@@ -0,0 +1,111 @@
+package shim
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandEnvTemplate(t *testing.T) {
+	resolve := func(name string) (string, error) {
+		return map[string]string{"HOST_IP": "10.0.0.2", "NODE_GPU_COUNT": "8"}[name], nil
+	}
+	testCases := []struct {
+		value    string
+		expected string
+	}{
+		{"", ""},
+		{"plain", "plain"},
+		{"$HOME:$PATH", "$HOME:$PATH"},
+		{"${HOST_IP}", "10.0.0.2"},
+		{"tcp://${HOST_IP}:29500", "tcp://10.0.0.2:29500"},
+		{"${HOST_IP}/${NODE_GPU_COUNT}", "10.0.0.2/8"},
+		{"$${HOST_IP} ${HOST_IP}", "${HOST_IP} 10.0.0.2"},
+		{"$$${HOST_IP}", "$${HOST_IP}"},
+		{"$${UNKNOWN}", "${UNKNOWN}"},
+		{"$", "$"},
+	}
+	for _, tc := range testCases {
+		value, err := expandEnvTemplate(tc.value, resolve)
+		assert.NoError(t, err, tc.value)
+		assert.Equal(t, tc.expected, value, tc.value)
+	}
+}
+
+func TestExpandEnvTemplate_Errors(t *testing.T) {
+	resolve := func(string) (string, error) { return "", errors.New("no IPv4 address found") }
+	testCases := []struct {
+		value string
+		err   string
+	}{
+		{"${UNKNOWN}", `unknown template variable "UNKNOWN"`},
+		{"${host_ip}", `unknown template variable "host_ip"`},
+		{"${}", `unknown template variable ""`},
+		{"${HOST_IP", "unterminated template reference"},
+		{"${HOST_IP}", "no IPv4 address found"},
+	}
+	for _, tc := range testCases {
+		_, err := expandEnvTemplate(tc.value, resolve)
+		assert.ErrorContains(t, err, tc.err, tc.value)
+	}
+}
+
+func TestValidateEnv(t *testing.T) {
+	assert.NoError(t, validateEnv(nil))
+	assert.NoError(t, validateEnv(map[string]string{"MASTER_ADDR": "${HOST_IP}", "FOO": "bar"}))
+	for _, env := range []map[string]string{
+		{"": "x"},
+		{"A=B": "x"},
+		{"NPROC": "${GPU_COUNT}"},
+	} {
+		assert.ErrorIs(t, validateEnv(env), ErrInvalidConfig, env)
+	}
+}
+
+func TestMergeEnv(t *testing.T) {
+	merged := mergeEnv(
+		[]string{"NVIDIA_DRIVER_CAPABILITIES=compute,utility", "PJRT_DEVICE=TPU"},
+		[]string{"NVIDIA_DRIVER_CAPABILITIES=all", "NVIDIA=1", "PJRT_DEVICE_X=1"},
+	)
+	assert.Equal(t, []string{
+		"NVIDIA_DRIVER_CAPABILITIES=compute,utility", "PJRT_DEVICE=TPU", "NVIDIA=1", "PJRT_DEVICE_X=1",
+	}, merged)
+}
+
+func TestDockerRunner_Env(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.Env = map[string]string{
+		"NPROC_PER_NODE": "${NODE_GPU_COUNT}",
+		"JOB":            "${TASK_ID}-cpus-${HOST_CPU_COUNT}",
+		"SCRIPT":         "echo $HOME $${HOST_IP}",
+	}
+	containerID := runTask(t, runner, cfg)
+	defer client.exitContainer(containerID, 0)
+
+	ctr, err := client.getContainer(containerID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		TraceIDEnvVar + "=" + runner.TaskInfo(cfg.ID).TraceID,
+		"JOB=" + cfg.ID + "-cpus-" + strconv.Itoa(runtime.NumCPU()),
+		"NPROC_PER_NODE=0",
+		"SCRIPT=echo $HOME ${HOST_IP}",
+	}, ctr.config.Env)
+}
+
+func TestDockerRunner_Env_SubmitRejected(t *testing.T) {
+	runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.Env = map[string]string{"RANK": "${NODE_RANK}"}
+
+	err := runner.Submit(context.Background(), cfg)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.ErrorContains(t, err, `env variable RANK: unknown template variable "NODE_RANK"`)
+	assert.Equal(t, "", runner.TaskInfo(cfg.ID).ID)
+}
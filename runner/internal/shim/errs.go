@@ -30,4 +30,10 @@ var (
 	ErrRequest = errors.New("request error")
 	// referenced object does not exist
 	ErrNotFound = errors.New("not found")
+	// submitted task configuration is invalid or not supported on this host
+	ErrInvalidConfig = errors.New("invalid config")
+	// the host cannot run tasks at the moment, e.g., Docker daemon is unhealthy
+	ErrHostUnavailable = errors.New("host unavailable")
+	// submitted task is valid, but not allowed by the policy, e.g., denied by the admission webhook
+	ErrDenied = errors.New("denied")
 )
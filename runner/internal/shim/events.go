@@ -0,0 +1,106 @@
+package shim
+
+import (
+	"context"
+	"time"
+
+	"github.com/dstackai/dstack/runner/internal/log"
+)
+
+// The maximum number of events kept for each task, older events are dropped
+const maxTaskHistoryEvents = 64
+
+type TaskHistoryEventType string
+
+const (
+	// The task status has changed, including the initial status
+	TaskHistoryEventStatus TaskHistoryEventType = "status"
+	// The container has been started, either for the first time or as a replacement
+	TaskHistoryEventContainerStarted TaskHistoryEventType = "container_started"
+	// The container has exited, with the exit code and the OOM flag if known
+	TaskHistoryEventContainerExited TaskHistoryEventType = "container_exited"
+	// The task continues in the new container, see Replace()
+	TaskHistoryEventContainerReplaced TaskHistoryEventType = "container_replaced"
+	// The task has been recreated after the host reboot, see TaskConfig.RestartOnReboot
+	TaskHistoryEventRecovered TaskHistoryEventType = "recovered"
+	// Compute processes were still running on the task GPUs after the drain timeout, and the GPUs
+	// were released as is, the message lists the processes, see GPUDrainConfig
+	TaskHistoryEventGPUNotDrained TaskHistoryEventType = "gpu_not_drained"
+	// Compute processes still running on the task GPUs after the drain timeout were killed,
+	// the message lists the processes
+	TaskHistoryEventGPUForceCleared TaskHistoryEventType = "gpu_force_cleared"
+	// A cleanup step has failed, the message is the step name and the error. Other steps
+	// are run anyway, see runCleanup()
+	TaskHistoryEventCleanupFailed TaskHistoryEventType = "cleanup_failed"
+	// The task disk usage has gone over a limit, the message lists exceeded limits,
+	// see TaskConfig.DiskMonitor
+	TaskHistoryEventDiskUsageExceeded TaskHistoryEventType = "disk_usage_exceeded"
+)
+
+// TaskHistoryEvent is a timestamped lifecycle event of the task, see TaskStorage.Events()
+type TaskHistoryEvent struct {
+	Time time.Time            `json:"time"`
+	Type TaskHistoryEventType `json:"type"`
+	// Set for status events
+	Status TaskStatus `json:"status,omitempty"`
+	// Set for status events with the final status
+	TerminationReason string `json:"termination_reason,omitempty"`
+	ContainerID       string `json:"container_id,omitempty"`
+	// Set for container_exited events, if the container could be inspected
+	ExitCode  *int   `json:"exit_code,omitempty"`
+	OOMKilled bool   `json:"oom_killed,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+// taskHistory is a ring buffer of the last maxTaskHistoryEvents events, so that memory used
+// by long-running tasks, e.g., with many replacements, is bounded
+type taskHistory struct {
+	events []TaskHistoryEvent
+	// the index of the oldest event once the buffer is full
+	start int
+	// the number of events ever added, including dropped ones
+	total int
+}
+
+func (h *taskHistory) add(event TaskHistoryEvent) {
+	h.total++
+	if len(h.events) < maxTaskHistoryEvents {
+		h.events = append(h.events, event)
+		return
+	}
+	h.events[h.start] = event
+	h.start = (h.start + 1) % maxTaskHistoryEvents
+}
+
+// list returns a copy of events, the oldest first
+func (h *taskHistory) list() []TaskHistoryEvent {
+	events := make([]TaskHistoryEvent, 0, len(h.events))
+	events = append(events, h.events[h.start:]...)
+	return append(events, h.events[:h.start]...)
+}
+
+// since returns a copy of events added after the first n ones, the oldest first, and the number
+// of events ever added, to be passed as n to the next call. Dropped events are skipped
+func (h *taskHistory) since(n int) ([]TaskHistoryEvent, int) {
+	events := h.list()
+	if skip := n - (h.total - len(events)); skip > 0 {
+		events = events[min(skip, len(events)):]
+	}
+	return events, h.total
+}
+
+// recordContainerExit records the exit of the task container and returns the OOM flag. The exit
+// code and the OOM flag are taken from the container state, the event is recorded without them
+// if the container cannot be inspected, e.g., it is gone
+func (d *DockerRunner) recordContainerExit(ctx context.Context, task *Task) (oomKilled bool) {
+	event := TaskHistoryEvent{Type: TaskHistoryEventContainerExited, ContainerID: task.containerID}
+	if inspect, err := d.client.ContainerInspect(ctx, task.containerID); err != nil {
+		log.Debug(ctx, "cannot inspect exited container", "task", task.ID, "err", err)
+	} else if state := inspect.State; state != nil && !state.Running {
+		exitCode := state.ExitCode
+		event.ExitCode = &exitCode
+		event.OOMKilled = state.OOMKilled
+	}
+	d.tasks.RecordEvent(task.ID, event)
+	return event.OOMKilled
+}
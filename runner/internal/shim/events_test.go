@@ -0,0 +1,167 @@
+package shim
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func eventTypes(events []TaskHistoryEvent) []TaskHistoryEventType {
+	types := make([]TaskHistoryEventType, 0, len(events))
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func TestTaskHistory_Cap(t *testing.T) {
+	var history taskHistory
+	for i := range maxTaskHistoryEvents + 10 {
+		history.add(TaskHistoryEvent{Message: strconv.Itoa(i)})
+		events := history.list()
+		require.Len(t, events, min(i+1, maxTaskHistoryEvents))
+		// the oldest event first
+		assert.Equal(t, strconv.Itoa(max(0, i+1-maxTaskHistoryEvents)), events[0].Message)
+		assert.Equal(t, strconv.Itoa(i), events[len(events)-1].Message)
+	}
+	events := history.list()
+	for i, event := range events {
+		assert.Equal(t, strconv.Itoa(i+10), event.Message)
+	}
+	// a copy is returned
+	events[0].Message = "changed"
+	assert.Equal(t, "10", history.list()[0].Message)
+}
+
+func TestTaskHistory_Since(t *testing.T) {
+	var history taskHistory
+	events, n := history.since(0)
+	assert.Empty(t, events)
+	assert.Equal(t, 0, n)
+
+	// the same time, the events are still told apart
+	now := time.Now()
+	history.add(TaskHistoryEvent{Time: now, Message: "0"})
+	history.add(TaskHistoryEvent{Time: now, Message: "1"})
+	events, n = history.since(1)
+	require.Len(t, events, 1)
+	assert.Equal(t, "1", events[0].Message)
+	assert.Equal(t, 2, n)
+	events, n = history.since(n)
+	assert.Empty(t, events)
+	assert.Equal(t, 2, n)
+
+	// events dropped from the buffer are skipped
+	for i := 2; i < maxTaskHistoryEvents+10; i++ {
+		history.add(TaskHistoryEvent{Message: strconv.Itoa(i)})
+	}
+	events, n = history.since(2)
+	require.Len(t, events, maxTaskHistoryEvents)
+	assert.Equal(t, "10", events[0].Message)
+	assert.Equal(t, maxTaskHistoryEvents+10, n)
+	events, _ = history.since(maxTaskHistoryEvents + 8)
+	require.Len(t, events, 2)
+	assert.Equal(t, strconv.Itoa(maxTaskHistoryEvents+8), events[0].Message)
+}
+
+func TestTaskStorage_Events(t *testing.T) {
+	storage := NewTaskStorage()
+	task := NewTask("1", TaskStatusRunning, "", "", nil, nil, "")
+	require.NoError(t, storage.Add(task))
+	storage.RecordEvent("1", TaskHistoryEvent{Type: TaskHistoryEventContainerStarted, ContainerID: "c1"})
+	// unknown task, ignored
+	storage.RecordEvent("2", TaskHistoryEvent{Type: TaskHistoryEventContainerStarted})
+
+	// the status is not changed, no event
+	require.NoError(t, storage.Update(task))
+	task.SetStatusTerminated("TERMINATED_BY_USER", "bye")
+	require.NoError(t, storage.Update(task))
+
+	events := storage.Events("1")
+	require.Len(t, events, 3)
+	assert.Equal(t, TaskHistoryEvent{Time: events[0].Time, Type: TaskHistoryEventStatus, Status: TaskStatusRunning}, events[0])
+	assert.Equal(t, TaskHistoryEvent{Time: events[1].Time, Type: TaskHistoryEventContainerStarted, ContainerID: "c1"}, events[1])
+	assert.Equal(t, TaskHistoryEvent{
+		Time: events[2].Time, Type: TaskHistoryEventStatus, Status: TaskStatusTerminated,
+		TerminationReason: "TERMINATED_BY_USER", Message: "bye",
+	}, events[2])
+	for _, event := range events {
+		assert.False(t, event.Time.IsZero())
+	}
+	assert.Nil(t, storage.Events("2"))
+
+	storage.Delete("1")
+	assert.Nil(t, storage.Events("1"))
+}
+
+func TestDockerRunner_Events_ContainerExited(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	containerID := runTask(t, runner, cfg)
+
+	client.mu.Lock()
+	client.containers[containerID].oomKilled = true
+	client.mu.Unlock()
+	client.exitContainer(containerID, 137)
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusFailed)
+
+	events := runner.TaskInfo(cfg.ID).Events
+	assert.Equal(t, []TaskHistoryEventType{
+		TaskHistoryEventStatus, TaskHistoryEventStatus, TaskHistoryEventStatus, TaskHistoryEventStatus,
+		TaskHistoryEventStatus, TaskHistoryEventContainerStarted, TaskHistoryEventContainerExited, TaskHistoryEventStatus,
+	}, eventTypes(events))
+	var statuses []TaskStatus
+	for _, event := range events {
+		if event.Type == TaskHistoryEventStatus {
+			statuses = append(statuses, event.Status)
+		}
+	}
+	assert.Equal(t, []TaskStatus{
+		TaskStatusPending, TaskStatusPreparing, TaskStatusPulling, TaskStatusCreating, TaskStatusRunning, TaskStatusFailed,
+	}, statuses)
+	assert.Equal(t, containerID, events[5].ContainerID)
+	exited := events[6]
+	assert.Equal(t, containerID, exited.ContainerID)
+	require.NotNil(t, exited.ExitCode)
+	assert.Equal(t, 137, *exited.ExitCode)
+	assert.True(t, exited.OOMKilled)
+	assert.Equal(t, "CONTAINER_EXITED_WITH_ERROR", events[7].TerminationReason)
+	for i := 1; i < len(events); i++ {
+		assert.False(t, events[i].Time.Before(events[i-1].Time), "events must be ordered by time")
+	}
+}
+
+func TestDockerRunner_Events_Terminated(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	containerID := runTask(t, runner, cfg)
+
+	require.NoError(t, runner.Terminate(context.Background(), cfg.ID, nil, "TERMINATED_BY_SERVER", ""))
+
+	// Run() may record the exit after Terminate() returns
+	require.Eventually(t, func() bool {
+		return len(runner.TaskInfo(cfg.ID).Events) == 8
+	}, 5*time.Second, 10*time.Millisecond)
+	var terminated, exited []TaskHistoryEvent
+	for _, event := range runner.TaskInfo(cfg.ID).Events {
+		switch {
+		case event.Type == TaskHistoryEventContainerExited:
+			exited = append(exited, event)
+		case event.Type == TaskHistoryEventStatus && event.Status.IsFinished():
+			terminated = append(terminated, event)
+		}
+	}
+	require.Len(t, terminated, 1)
+	assert.Equal(t, TaskStatusTerminated, terminated[0].Status)
+	assert.Equal(t, "TERMINATED_BY_SERVER", terminated[0].TerminationReason)
+	require.Len(t, exited, 1)
+	assert.Equal(t, containerID, exited[0].ContainerID)
+	require.NotNil(t, exited[0].ExitCode)
+	assert.Equal(t, 137, *exited[0].ExitCode)
+}
@@ -0,0 +1,153 @@
+package shim
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
+
+	"github.com/dstackai/dstack/runner/internal/log"
+)
+
+// Used if ExecRequest.Timeout is not set
+const DefaultExecTimeout = 30 * time.Second
+
+// One-off commands are for diagnostics, long-running ones should use attach
+const MaxExecTimeout = 5 * time.Minute
+
+// The output beyond this size is discarded, ExecResult.Truncated is set then
+const maxExecOutputSize = 1024 * 1024
+
+// How often the exec is inspected for the exit code once the output is closed
+var execInspectInterval = 50 * time.Millisecond
+
+type ExecRequest struct {
+	Command []string
+	// Empty = the container working dir
+	WorkingDir string
+	// Empty = the container user
+	User string
+	// 0 = DefaultExecTimeout, up to MaxExecTimeout
+	Timeout time.Duration
+}
+
+type ExecResult struct {
+	// Combined stdout and stderr, in the order received
+	Output    string `json:"output"`
+	Truncated bool   `json:"truncated"`
+	// -1 if timed out
+	ExitCode int  `json:"exit_code"`
+	TimedOut bool `json:"timed_out"`
+}
+
+// limitedBuffer keeps up to limit bytes, the rest is counted, but discarded
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); len(p) > room {
+		b.truncated = true
+		b.buf.Write(p[:max(room, 0)])
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// Exec runs a one-off command in the running task container, as `docker exec` does, without
+// stdin and TTY, and waits for it to exit. A command still running after the timeout is left
+// running (Docker cannot kill exec processes), the output received so far is returned
+func (d *DockerRunner) Exec(ctx context.Context, taskID string, req ExecRequest) (ExecResult, error) {
+	if len(req.Command) == 0 {
+		return ExecResult{}, fmt.Errorf("%w: empty command", ErrRequest)
+	}
+	timeout := req.Timeout
+	if timeout == 0 {
+		timeout = DefaultExecTimeout
+	}
+	if timeout < 0 || timeout > MaxExecTimeout {
+		return ExecResult{}, fmt.Errorf("%w: timeout must be in (0, %s] range, got %s", ErrRequest, MaxExecTimeout, timeout)
+	}
+	task, ok := d.tasks.Get(taskID)
+	if !ok {
+		return ExecResult{}, fmt.Errorf("task %s: %w", taskID, ErrNotFound)
+	}
+	ctx = withTraceID(ctx, task.config.TraceID)
+	if task.Status != TaskStatusRunning {
+		return ExecResult{}, fmt.Errorf("%w: cannot exec in task %s with %s status", ErrRequest, task.ID, task.Status)
+	}
+
+	execConfig := types.ExecConfig{
+		Cmd:          req.Command,
+		WorkingDir:   req.WorkingDir,
+		User:         req.User,
+		AttachStdout: true,
+		AttachStderr: true,
+	}
+	exec, err := d.client.ContainerExecCreate(ctx, task.containerID, execConfig)
+	if err != nil {
+		if errdefs.IsNotFound(err) || errdefs.IsConflict(err) {
+			return ExecResult{}, fmt.Errorf("%w: task %s container is not running: %w", ErrRequest, task.ID, err)
+		}
+		return ExecResult{}, fmt.Errorf("%w: failed to create exec: %w", ErrInternal, err)
+	}
+	d.audit.Record(ctx, AuditRecord{
+		Action: AuditActionExec, TaskID: task.ID, TraceID: task.config.TraceID, ContainerID: task.containerID, Command: req.Command,
+	})
+	log.Debug(ctx, "exec created", "task", task.ID, "exec", exec.ID, "cmd", req.Command)
+
+	execCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	stream, err := d.client.ContainerExecAttach(execCtx, exec.ID, types.ExecStartCheck{})
+	if err != nil {
+		return ExecResult{}, fmt.Errorf("%w: failed to start exec: %w", ErrInternal, err)
+	}
+	defer stream.Close()
+	go func() {
+		// unblocks reading the output
+		<-execCtx.Done()
+		stream.Close()
+	}()
+	output := &limitedBuffer{limit: maxExecOutputSize}
+	// Both stdout and stderr go to the same buffer, so that the output is combined
+	_, copyErr := stdcopy.StdCopy(output, output, stream.Reader)
+	result := ExecResult{Output: output.buf.String(), Truncated: output.truncated}
+	if ctx.Err() != nil {
+		return ExecResult{}, ctx.Err()
+	}
+	// Reading fails once the stream is closed on timeout
+	if execCtx.Err() != nil {
+		log.Info(ctx, "exec timed out", "task", task.ID, "exec", exec.ID, "timeout", timeout)
+		result.ExitCode = -1
+		result.TimedOut = true
+		return result, nil
+	}
+	if copyErr != nil {
+		return ExecResult{}, fmt.Errorf("%w: failed to read exec output: %w", ErrInternal, copyErr)
+	}
+	// The output is closed once the process has exited, but Docker may not have
+	// the exit code yet
+	for {
+		inspect, err := d.client.ContainerExecInspect(execCtx, exec.ID)
+		if err != nil {
+			return ExecResult{}, fmt.Errorf("%w: failed to inspect exec: %w", ErrInternal, err)
+		}
+		if !inspect.Running {
+			result.ExitCode = inspect.ExitCode
+			break
+		}
+		if err := sleepCtx(execCtx, execInspectInterval); err != nil {
+			result.ExitCode = -1
+			result.TimedOut = true
+			break
+		}
+	}
+	log.Debug(ctx, "exec finished", "task", task.ID, "exec", exec.ID, "exit_code", result.ExitCode)
+	return result, nil
+}
@@ -0,0 +1,104 @@
+package shim
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDockerRunner_Exec(t *testing.T) {
+	client := newFakeDockerClient()
+	client.execStdout = "GPU 0: NVIDIA H100\n"
+	client.execStderr = "warning: persistence mode is disabled\n"
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	containerID := runTask(t, runner, cfg)
+
+	result, err := runner.Exec(context.Background(), cfg.ID, ExecRequest{
+		Command: []string{"nvidia-smi", "-L"}, WorkingDir: "/workflow", User: "root",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, ExecResult{
+		Output:   "GPU 0: NVIDIA H100\nwarning: persistence mode is disabled\n",
+		ExitCode: 0,
+	}, result)
+
+	ctr, err := client.getContainer(containerID)
+	require.NoError(t, err)
+	assert.Equal(t, types.ExecConfig{
+		Cmd: []string{"nvidia-smi", "-L"}, WorkingDir: "/workflow", User: "root", AttachStdout: true, AttachStderr: true,
+	}, ctr.lastExec)
+}
+
+func TestDockerRunner_Exec_NonZeroExit(t *testing.T) {
+	client := newFakeDockerClient()
+	client.execStderr = "cat: /etc/missing: No such file or directory\n"
+	client.execExitCodes = []int{1}
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	runTask(t, runner, cfg)
+
+	result, err := runner.Exec(context.Background(), cfg.ID, ExecRequest{Command: []string{"cat", "/etc/missing"}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.ExitCode)
+	assert.False(t, result.TimedOut)
+	assert.Equal(t, "cat: /etc/missing: No such file or directory\n", result.Output)
+}
+
+func TestDockerRunner_Exec_Timeout(t *testing.T) {
+	client := newFakeDockerClient()
+	client.execStdout = "started\n"
+	client.execHang = true
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	runTask(t, runner, cfg)
+
+	result, err := runner.Exec(context.Background(), cfg.ID, ExecRequest{Command: []string{"sleep", "infinity"}, Timeout: 100 * time.Millisecond})
+	require.NoError(t, err)
+	assert.True(t, result.TimedOut)
+	assert.Equal(t, -1, result.ExitCode)
+	assert.Equal(t, "started\n", result.Output)
+}
+
+func TestDockerRunner_Exec_OutputTruncated(t *testing.T) {
+	client := newFakeDockerClient()
+	client.execStdout = strings.Repeat("x", maxExecOutputSize+10)
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	runTask(t, runner, cfg)
+
+	result, err := runner.Exec(context.Background(), cfg.ID, ExecRequest{Command: []string{"yes"}})
+	require.NoError(t, err)
+	assert.True(t, result.Truncated)
+	assert.Len(t, result.Output, maxExecOutputSize)
+}
+
+func TestDockerRunner_Exec_Rejected(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	containerID := runTask(t, runner, cfg)
+
+	_, err := runner.Exec(context.Background(), "unknown", ExecRequest{Command: []string{"true"}})
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = runner.Exec(context.Background(), cfg.ID, ExecRequest{})
+	assert.ErrorIs(t, err, ErrRequest)
+	_, err = runner.Exec(context.Background(), cfg.ID, ExecRequest{Command: []string{"true"}, Timeout: time.Hour})
+	assert.ErrorIs(t, err, ErrRequest)
+
+	client.exitContainer(containerID, 0)
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusTerminated)
+	_, err = runner.Exec(context.Background(), cfg.ID, ExecRequest{Command: []string{"true"}})
+	assert.ErrorIs(t, err, ErrRequest)
+	assert.ErrorContains(t, err, "with terminated status")
+
+	pending := createTaskConfig(t)
+	require.NoError(t, runner.Submit(context.Background(), pending))
+	_, err = runner.Exec(context.Background(), pending.ID, ExecRequest{Command: []string{"true"}})
+	assert.ErrorIs(t, err, ErrRequest)
+}
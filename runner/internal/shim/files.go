@@ -0,0 +1,63 @@
+package shim
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/docker/docker/errdefs"
+	"github.com/dstackai/dstack/runner/internal/log"
+)
+
+// TaskFiles returns a tar archive stream of the file or directory at the given path inside
+// the task container. The container may be either running or terminated, but not removed.
+// The caller is responsible for closing the returned reader
+func (d *DockerRunner) TaskFiles(ctx context.Context, taskID string, containerPath string) (io.ReadCloser, error) {
+	task, ok := d.tasks.Get(taskID)
+	if !ok {
+		return nil, fmt.Errorf("task %s: %w", taskID, ErrNotFound)
+	}
+	containerPath, err := validateContainerPath(containerPath)
+	if err != nil {
+		return nil, err
+	}
+	if task.containerID == "" {
+		return nil, fmt.Errorf("%w: task %s has no container", ErrRequest, task.ID)
+	}
+	if _, err := d.client.ContainerInspect(ctx, task.containerID); err != nil {
+		if errdefs.IsNotFound(err) {
+			return nil, fmt.Errorf("%w: task %s container is gone", ErrRequest, task.ID)
+		}
+		return nil, fmt.Errorf("%w: failed to inspect container: %w", ErrInternal, err)
+	}
+	reader, _, err := d.client.CopyFromContainer(ctx, task.containerID, containerPath)
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return nil, fmt.Errorf("task %s: path %s: %w", task.ID, containerPath, ErrNotFound)
+		}
+		return nil, fmt.Errorf("%w: failed to copy from container: %w", ErrInternal, err)
+	}
+	log.Debug(ctx, "streaming files from container", "task", task.ID, "path", containerPath)
+	return reader, nil
+}
+
+// validateContainerPath checks that the path is absolute and doesn't contain any `..` elements,
+// and returns the cleaned path
+func validateContainerPath(containerPath string) (string, error) {
+	if containerPath == "" {
+		return "", fmt.Errorf("%w: empty path", ErrInvalidConfig)
+	}
+	if !path.IsAbs(containerPath) {
+		return "", fmt.Errorf("%w: path must be absolute: %s", ErrInvalidConfig, containerPath)
+	}
+	if strings.ContainsRune(containerPath, 0) {
+		return "", fmt.Errorf("%w: path contains NUL byte", ErrInvalidConfig)
+	}
+	if slices.Contains(strings.Split(containerPath, "/"), "..") {
+		return "", fmt.Errorf("%w: path must not contain `..` elements: %s", ErrInvalidConfig, containerPath)
+	}
+	return path.Clean(containerPath), nil
+}
@@ -0,0 +1,116 @@
+package shim
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateContainerPath(t *testing.T) {
+	testCases := []struct {
+		path, expected string
+	}{
+		{"/", "/"},
+		{"/workflow/output", "/workflow/output"},
+		{"/workflow//output/", "/workflow/output"},
+		{"/workflow/./output", "/workflow/output"},
+		{"/workflow/..output", "/workflow/..output"},
+	}
+	for _, tc := range testCases {
+		cleaned, err := validateContainerPath(tc.path)
+		assert.NoError(t, err, tc.path)
+		assert.Equal(t, tc.expected, cleaned, tc.path)
+	}
+}
+
+func TestValidateContainerPath_Rejected(t *testing.T) {
+	for _, path := range []string{"", "workflow/output", "../etc/passwd", "/workflow/../../etc/passwd", "/workflow/..", "/a\x00b"} {
+		_, err := validateContainerPath(path)
+		assert.ErrorIs(t, err, ErrInvalidConfig, path)
+	}
+}
+
+func TestDockerRunner_TaskFiles(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	require.NoError(t, runner.Submit(context.Background(), cfg))
+	runErr := make(chan error)
+	go func() { runErr <- runner.Run(context.Background(), cfg.ID) }()
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusRunning)
+	containerID := runner.TaskInfo(cfg.ID).ContainerID
+	client.writeFile(containerID, "/workflow/output/model.bin", "weights")
+	client.writeFile(containerID, "/workflow/output/logs/train.log", "loss=0.1")
+	client.writeFile(containerID, "/workflow/other", "other")
+
+	// running container
+	files := readTaskFiles(t, runner, cfg.ID, "/workflow/output/")
+	assert.Equal(t, map[string]string{
+		"output/model.bin":      "weights",
+		"output/logs/train.log": "loss=0.1",
+	}, files)
+
+	// terminated, but not yet removed container
+	client.exitContainer(containerID, 0)
+	require.NoError(t, <-runErr)
+	require.Equal(t, TaskStatusTerminated, runner.TaskInfo(cfg.ID).Status)
+	files = readTaskFiles(t, runner, cfg.ID, "/workflow/output/model.bin")
+	assert.Equal(t, map[string]string{"model.bin": "weights"}, files)
+
+	_, err := runner.TaskFiles(context.Background(), cfg.ID, "/workflow/missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, client.ContainerRemove(context.Background(), containerID, container.RemoveOptions{}))
+	_, err = runner.TaskFiles(context.Background(), cfg.ID, "/workflow/output")
+	assert.ErrorIs(t, err, ErrRequest)
+}
+
+func TestDockerRunner_TaskFiles_TraversalRejected(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	require.NoError(t, runner.Submit(context.Background(), cfg))
+	go func() { _ = runner.Run(context.Background(), cfg.ID) }()
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusRunning)
+	containerID := runner.TaskInfo(cfg.ID).ContainerID
+	defer client.exitContainer(containerID, 0)
+	client.writeFile(containerID, "/etc/shadow", "secret")
+
+	_, err := runner.TaskFiles(context.Background(), cfg.ID, "/workflow/../etc/shadow")
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	_, err = runner.TaskFiles(context.Background(), cfg.ID, "etc/shadow")
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+}
+
+func TestDockerRunner_TaskFiles_UnknownTask(t *testing.T) {
+	runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{})
+
+	_, err := runner.TaskFiles(context.Background(), "unknown", "/workflow")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func readTaskFiles(t *testing.T, runner *DockerRunner, taskID string, path string) map[string]string {
+	t.Helper()
+	reader, err := runner.TaskFiles(context.Background(), taskID, path)
+	require.NoError(t, err)
+	defer reader.Close()
+	files := make(map[string]string)
+	tr := tar.NewReader(reader)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		content, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		files[hdr.Name] = string(content)
+	}
+	return files
+}
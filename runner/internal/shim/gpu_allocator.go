@@ -0,0 +1,302 @@
+package shim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/dstackai/dstack/runner/internal/log"
+	"github.com/dstackai/dstack/runner/internal/shim/host"
+)
+
+// GPUID is a vendor-specific GPU resource ID:
+// NVIDIA: host.GpuInfo.ID
+// AMD: host.GpuInfo.RenderNodePath
+type GPUID = string
+
+// Tolerance used when comparing sums of fractions, e.g., 0.1 + 0.2 + 0.7 should fit
+const gpuFractionEpsilon = 1e-9
+
+// GPURequest is a request to allocate GPUs to a task, see GPUAllocator.Allocate()
+type GPURequest struct {
+	TaskID string
+	// Either positive or -1, which means "all available GPUs", even if none, that is,
+	// a request for all GPUs never fails, even on hosts without GPU
+	Count int
+	// If 0.0, GPUs are allocated exclusively, otherwise the given fraction (0.0, 1.0] of each GPU
+	// is allocated, and GPUs are shared with other tasks, see TaskConfig.GPUMemoryFraction
+	MemoryFraction float64
+	// MPS client limits of shared allocations, zero = no limits, see TaskConfig.MPSThreadPercentage
+	MPS MPSLimits
+}
+
+type gpuDevice struct {
+	// The task the GPU is allocated to exclusively, empty if none
+	taskID string
+	// The reservation locking the GPU, empty if none, see Reserve()
+	reservationID string
+	// task ID: fraction mapping of shared allocations, the sum of fractions never exceeds 1.0
+	// unless oversubscribed on restore, see Restore()
+	// A GPU is either allocated exclusively, reserved, or shared, never several at once
+	shares map[string]float64
+	// task ID: limits of shared allocations with MPS limits, sums of limits never exceed
+	// 100% of threads and vram unless oversubscribed on restore
+	mps  map[string]MPSLimits
+	vram int // MiB, 0 if unknown
+	// The GPU is being verified to have no compute processes left after the exclusive allocation,
+	// it's not available until drained, even if released, see Drain()
+	draining bool
+}
+
+func (dev *gpuDevice) isIdle() bool {
+	return dev.taskID == "" && dev.reservationID == "" && len(dev.shares) == 0 && !dev.draining
+}
+
+// fitsMPS reports whether the limits fit alongside limits of other tasks sharing the GPU.
+// Clients without limits are not counted, as MPS doesn't reserve anything for them
+func (dev *gpuDevice) fitsMPS(limits MPSLimits) bool {
+	var threads, memory uint
+	for _, l := range dev.mps {
+		threads += l.ThreadPercentage
+		memory += l.MemoryMB
+	}
+	if limits.ThreadPercentage > 0 && threads+limits.ThreadPercentage > 100 {
+		return false
+	}
+	if limits.MemoryMB > 0 && dev.vram > 0 && memory+limits.MemoryMB > uint(dev.vram) {
+		return false
+	}
+	return true
+}
+
+func (dev *gpuDevice) freeFraction() float64 {
+	if dev.taskID != "" || dev.reservationID != "" || dev.draining {
+		return 0
+	}
+	free := 1.0
+	for _, fraction := range dev.shares {
+		free -= fraction
+	}
+	if free < gpuFractionEpsilon {
+		return 0
+	}
+	return free
+}
+
+// GPUAllocator is the single source of truth for GPU ownership: exclusive and shared allocations
+// of tasks, and reservations not consumed yet. All methods are safe for concurrent use, each
+// method is atomic, that is, concurrent allocations never get the same GPU unless it is shared
+type GPUAllocator struct {
+	// in the host order, allocations prefer GPUs listed first
+	ids     []GPUID
+	devices map[GPUID]*gpuDevice
+	// task ID: GPUs allocated to the task, either exclusively or shared
+	tasks map[string][]GPUID
+	// reservation ID: reservation mapping, see Reserve()
+	reservations map[string]gpuReservation
+	mu           sync.Mutex
+}
+
+func NewGPUAllocator(gpus []host.GpuInfo) (*GPUAllocator, error) {
+	ga := &GPUAllocator{
+		ids:          make([]GPUID, 0, len(gpus)),
+		devices:      make(map[GPUID]*gpuDevice, len(gpus)),
+		tasks:        map[string][]GPUID{},
+		reservations: map[string]gpuReservation{},
+	}
+	if len(gpus) > 0 {
+		vendor := gpus[0].Vendor
+		for _, gpu := range gpus {
+			if gpu.Vendor != vendor {
+				return nil, errors.New("multiple GPU vendors detected")
+			}
+			var id GPUID
+			switch vendor {
+			case host.GpuVendorNvidia:
+				id = gpu.ID
+			case host.GpuVendorAmd:
+				id = gpu.RenderNodePath
+			case host.GpuVendorNone:
+				return nil, fmt.Errorf("unexpected GPU vendor %s", vendor)
+			default:
+				return nil, fmt.Errorf("unexpected GPU vendor %s", vendor)
+			}
+			if _, ok := ga.devices[id]; ok {
+				return nil, fmt.Errorf("duplicate GPU %s", id)
+			}
+			ga.ids = append(ga.ids, id)
+			ga.devices[id] = &gpuDevice{shares: map[string]float64{}, mps: map[string]MPSLimits{}, vram: gpu.Vram}
+		}
+	}
+	return ga, nil
+}
+
+// Allocate allocates the requested number of GPUs to the task and returns their IDs.
+// Exclusive allocations take idle GPUs only, shared allocations take GPUs that are neither
+// allocated exclusively nor reserved and have enough free fraction and, if MPS limits are set,
+// enough MPS threads and memory not committed to other tasks.
+// If there are not enough GPUs, none is allocated and ErrNoCapacity is returned.
+// A task has at most one allocation, to allocate again, Release() the task first
+func (ga *GPUAllocator) Allocate(ctx context.Context, req GPURequest) ([]GPUID, error) {
+	if req.TaskID == "" {
+		return nil, errors.New("task ID must be set")
+	}
+	if req.Count == 0 || req.Count < -1 {
+		return nil, fmt.Errorf("count must be either positive or -1, got %d", req.Count)
+	}
+	if req.MemoryFraction < 0 || req.MemoryFraction > 1 {
+		return nil, fmt.Errorf("fraction must be in (0.0, 1.0] range, got %v", req.MemoryFraction)
+	}
+	if !req.MPS.IsZero() && req.MemoryFraction == 0 {
+		return nil, errors.New("MPS limits require a shared allocation")
+	}
+	if req.MPS.ThreadPercentage > 100 {
+		return nil, fmt.Errorf("MPS thread percentage must be in 1..100 range, got %d", req.MPS.ThreadPercentage)
+	}
+	ga.mu.Lock()
+	defer ga.mu.Unlock()
+	if _, ok := ga.tasks[req.TaskID]; ok {
+		return nil, fmt.Errorf("task %s already has GPUs allocated", req.TaskID)
+	}
+	ids := []GPUID{}
+	for _, id := range ga.ids {
+		if req.Count > 0 && len(ids) >= req.Count {
+			break
+		}
+		dev := ga.devices[id]
+		if req.MemoryFraction == 0 {
+			if dev.isIdle() {
+				ids = append(ids, id)
+			}
+		} else if dev.freeFraction()+gpuFractionEpsilon >= req.MemoryFraction && dev.fitsMPS(req.MPS) {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) < req.Count {
+		if req.MemoryFraction == 0 {
+			return nil, fmt.Errorf("%w: %d GPUs requested, %d available", ErrNoCapacity, req.Count, len(ids))
+		}
+		if !req.MPS.IsZero() {
+			return nil, fmt.Errorf(
+				"%w: %d GPUs with %v free fraction, %d%% MPS threads, and %d MiB MPS memory requested, %d available",
+				ErrNoCapacity, req.Count, req.MemoryFraction, req.MPS.ThreadPercentage, req.MPS.MemoryMB, len(ids),
+			)
+		}
+		return nil, fmt.Errorf("%w: %d GPUs with %v free fraction requested, %d available", ErrNoCapacity, req.Count, req.MemoryFraction, len(ids))
+	}
+	ga.assign(req.TaskID, ids, req.MemoryFraction, req.MPS)
+	return slices.Clone(ids), nil
+}
+
+// Restore allocates the given GPUs to the task as is, even if it oversubscribes shared GPUs
+// or their MPS limits.
+// Used to restore the state on shim restarts.
+// This method never fails, unknown GPUs and GPUs that cannot be allocated are skipped,
+// the returned slice contains only actually allocated GPU IDs
+func (ga *GPUAllocator) Restore(ctx context.Context, taskID string, ids []GPUID, fraction float64, mps MPSLimits) []GPUID {
+	ga.mu.Lock()
+	defer ga.mu.Unlock()
+	restoredIDs := make([]GPUID, 0, len(ids))
+	if _, ok := ga.tasks[taskID]; ok {
+		log.Warning(ctx, "skip restoring: task already has GPUs allocated", "task", taskID)
+		return restoredIDs
+	}
+	for _, id := range ids {
+		dev, ok := ga.devices[id]
+		switch {
+		case !ok:
+			log.Warning(ctx, "skip restoring: unknown GPU resource", "id", id)
+		case dev.taskID != "" || dev.reservationID != "":
+			log.Info(ctx, "skip restoring: GPU is allocated exclusively", "id", id)
+		case fraction == 0 && len(dev.shares) > 0:
+			log.Info(ctx, "skip restoring: GPU is shared", "id", id)
+		case slices.Contains(restoredIDs, id):
+		default:
+			restoredIDs = append(restoredIDs, id)
+		}
+	}
+	ga.assign(taskID, restoredIDs, fraction, mps)
+	return slices.Clone(restoredIDs)
+}
+
+// Release releases all GPUs allocated to the task, either exclusively or shared.
+// This method never fails, it's safe to call it multiple times.
+// The returned slice contains only actually released GPU IDs
+func (ga *GPUAllocator) Release(ctx context.Context, taskID string) []GPUID {
+	ga.mu.Lock()
+	defer ga.mu.Unlock()
+	ids, ok := ga.tasks[taskID]
+	if !ok {
+		return []GPUID{}
+	}
+	for _, id := range ids {
+		dev := ga.devices[id]
+		if dev.taskID == taskID {
+			dev.taskID = ""
+		}
+		delete(dev.shares, taskID)
+		delete(dev.mps, taskID)
+	}
+	delete(ga.tasks, taskID)
+	return ids
+}
+
+// Drain marks GPUs allocated to the task exclusively as draining and returns their IDs,
+// GPUs already draining are skipped. Draining GPUs are not available until Drained() is called,
+// even if the task is released in the meantime
+func (ga *GPUAllocator) Drain(taskID string) []GPUID {
+	ga.mu.Lock()
+	defer ga.mu.Unlock()
+	ids := []GPUID{}
+	for _, id := range ga.tasks[taskID] {
+		if dev := ga.devices[id]; dev.taskID == taskID && !dev.draining {
+			dev.draining = true
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Drained makes the given GPUs available again, see Drain()
+func (ga *GPUAllocator) Drained(ids []GPUID) {
+	ga.mu.Lock()
+	defer ga.mu.Unlock()
+	for _, id := range ids {
+		if dev, ok := ga.devices[id]; ok {
+			dev.draining = false
+		}
+	}
+}
+
+// Available returns the free fraction of each GPU: 1.0 if the GPU is idle, 0.0 if the GPU is
+// allocated exclusively, reserved, or fully shared
+func (ga *GPUAllocator) Available() map[GPUID]float64 {
+	ga.mu.Lock()
+	defer ga.mu.Unlock()
+	available := make(map[GPUID]float64, len(ga.devices))
+	for id, dev := range ga.devices {
+		available[id] = dev.freeFraction()
+	}
+	return available
+}
+
+// assign records the allocation, must be called with lock held. Empty allocations
+// are not recorded, MPS limits are only recorded for shared allocations
+func (ga *GPUAllocator) assign(taskID string, ids []GPUID, fraction float64, mps MPSLimits) {
+	if len(ids) == 0 {
+		return
+	}
+	for _, id := range ids {
+		if fraction > 0 {
+			ga.devices[id].shares[taskID] = fraction
+			if !mps.IsZero() {
+				ga.devices[id].mps[taskID] = mps
+			}
+		} else {
+			ga.devices[id].taskID = taskID
+		}
+	}
+	ga.tasks[taskID] = slices.Clone(ids)
+}
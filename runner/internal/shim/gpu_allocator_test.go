@@ -0,0 +1,590 @@
+package shim
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dstackai/dstack/runner/internal/shim/host"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestGPUAllocator(t *testing.T, ids ...GPUID) *GPUAllocator {
+	t.Helper()
+	gpus := make([]host.GpuInfo, 0, len(ids))
+	for _, id := range ids {
+		gpus = append(gpus, host.GpuInfo{Vendor: host.GpuVendorNvidia, ID: id})
+	}
+	ga, err := NewGPUAllocator(gpus)
+	require.NoError(t, err)
+	return ga
+}
+
+// allocate is a shortcut for exclusive allocations that must succeed
+func allocate(t *testing.T, ga *GPUAllocator, taskID string, count int) []GPUID {
+	t.Helper()
+	ids, err := ga.Allocate(context.Background(), GPURequest{TaskID: taskID, Count: count})
+	require.NoError(t, err)
+	return ids
+}
+
+func TestNewGPUAllocator_NoGpus(t *testing.T) {
+	ga, err := NewGPUAllocator(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, map[GPUID]float64{}, ga.Available())
+}
+
+func TestNewGPUAllocator_NvidiaGpus(t *testing.T) {
+	gpus := []host.GpuInfo{
+		{Vendor: host.GpuVendorNvidia, ID: "GPU-beef"},
+		{Vendor: host.GpuVendorNvidia, ID: "GPU-f00d"},
+	}
+	ga, err := NewGPUAllocator(gpus)
+	assert.Nil(t, err)
+	assert.Equal(t, map[GPUID]float64{"GPU-beef": 1, "GPU-f00d": 1}, ga.Available())
+}
+
+func TestNewGPUAllocator_AmdGpus(t *testing.T) {
+	gpus := []host.GpuInfo{
+		{Vendor: host.GpuVendorAmd, RenderNodePath: "/dev/dri/renderD128"},
+		{Vendor: host.GpuVendorAmd, RenderNodePath: "/dev/dri/renderD129"},
+	}
+	ga, err := NewGPUAllocator(gpus)
+	assert.Nil(t, err)
+	assert.Equal(t, map[GPUID]float64{"/dev/dri/renderD128": 1, "/dev/dri/renderD129": 1}, ga.Available())
+}
+
+func TestNewGPUAllocator_Errors(t *testing.T) {
+	ga, err := NewGPUAllocator([]host.GpuInfo{
+		{Vendor: host.GpuVendorAmd},
+		{Vendor: host.GpuVendorNvidia},
+	})
+	assert.Nil(t, ga)
+	assert.ErrorContains(t, err, "multiple GPU vendors")
+
+	_, err = NewGPUAllocator([]host.GpuInfo{
+		{Vendor: host.GpuVendorNvidia, ID: "GPU-beef"},
+		{Vendor: host.GpuVendorNvidia, ID: "GPU-beef"},
+	})
+	assert.ErrorContains(t, err, "duplicate GPU GPU-beef")
+}
+
+func TestGPUAllocator_Allocate_ErrorBadRequest(t *testing.T) {
+	ga := newTestGPUAllocator(t, "GPU-beef")
+	testCases := []struct {
+		req GPURequest
+		err string
+	}{
+		{GPURequest{TaskID: "task", Count: 0}, "count must be either positive or -1, got 0"},
+		{GPURequest{TaskID: "task", Count: -2}, "count must be either positive or -1, got -2"},
+		{GPURequest{TaskID: "task", Count: 1, MemoryFraction: -0.5}, "fraction must be in (0.0, 1.0] range"},
+		{GPURequest{TaskID: "task", Count: 1, MemoryFraction: 1.5}, "fraction must be in (0.0, 1.0] range"},
+		{GPURequest{Count: 1}, "task ID must be set"},
+	}
+	for _, tc := range testCases {
+		ids, err := ga.Allocate(context.Background(), tc.req)
+		assert.ErrorContains(t, err, tc.err)
+		assert.Equal(t, 0, len(ids))
+	}
+	assert.Equal(t, map[GPUID]float64{"GPU-beef": 1}, ga.Available())
+}
+
+func TestGPUAllocator_Allocate_All_Available(t *testing.T) {
+	ga := newTestGPUAllocator(t, "GPU-beef", "GPU-f00d", "GPU-c0de")
+	assert.Equal(t, []GPUID{"GPU-f00d"}, ga.Restore(context.Background(), "task-1", []GPUID{"GPU-f00d"}, 0, MPSLimits{}))
+
+	ids := allocate(t, ga, "task-2", -1)
+	assert.Equal(t, []GPUID{"GPU-beef", "GPU-c0de"}, ids)
+	assert.Equal(t, map[GPUID]float64{"GPU-beef": 0, "GPU-f00d": 0, "GPU-c0de": 0}, ga.Available())
+}
+
+func TestGPUAllocator_Allocate_All_NoneAvailable(t *testing.T) {
+	ga := newTestGPUAllocator(t, "GPU-beef", "GPU-f00d")
+	allocate(t, ga, "task-1", 2)
+
+	ids := allocate(t, ga, "task-2", -1)
+	assert.Equal(t, 0, len(ids))
+	// an empty allocation is not recorded
+	assert.Equal(t, 0, len(ga.Release(context.Background(), "task-2")))
+}
+
+func TestGPUAllocator_Allocate_All_NoGpus(t *testing.T) {
+	ga := newTestGPUAllocator(t)
+	ids := allocate(t, ga, "task", -1)
+	assert.Equal(t, 0, len(ids))
+}
+
+func TestGPUAllocator_Allocate_Count_OK(t *testing.T) {
+	ga := newTestGPUAllocator(t, "GPU-beef", "GPU-f00d", "GPU-c0de", "GPU-cafe")
+	allocate(t, ga, "task-1", 1)
+
+	// GPUs are allocated in the host order
+	ids := allocate(t, ga, "task-2", 2)
+	assert.Equal(t, []GPUID{"GPU-f00d", "GPU-c0de"}, ids)
+	assert.Equal(t, map[GPUID]float64{"GPU-beef": 0, "GPU-f00d": 0, "GPU-c0de": 0, "GPU-cafe": 1}, ga.Available())
+}
+
+func TestGPUAllocator_Allocate_Count_ErrNoCapacity(t *testing.T) {
+	ga := newTestGPUAllocator(t, "GPU-beef", "GPU-f00d")
+	allocate(t, ga, "task-1", 1)
+
+	ids, err := ga.Allocate(context.Background(), GPURequest{TaskID: "task-2", Count: 2})
+	assert.ErrorIs(t, err, ErrNoCapacity)
+	assert.ErrorContains(t, err, "2 GPUs requested, 1 available")
+	assert.Equal(t, 0, len(ids))
+	assert.Equal(t, map[GPUID]float64{"GPU-beef": 0, "GPU-f00d": 1}, ga.Available())
+}
+
+func TestGPUAllocator_Allocate_ErrorAlreadyAllocated(t *testing.T) {
+	ga := newTestGPUAllocator(t, "GPU-beef", "GPU-f00d")
+	allocate(t, ga, "task", 1)
+
+	_, err := ga.Allocate(context.Background(), GPURequest{TaskID: "task", Count: 1})
+	assert.ErrorContains(t, err, "task task already has GPUs allocated")
+	_, err = ga.Allocate(context.Background(), GPURequest{TaskID: "task", Count: 1, MemoryFraction: 0.5})
+	assert.ErrorContains(t, err, "task task already has GPUs allocated")
+	assert.Equal(t, map[GPUID]float64{"GPU-beef": 0, "GPU-f00d": 1}, ga.Available())
+}
+
+func TestGPUAllocator_Restore(t *testing.T) {
+	ga := newTestGPUAllocator(t, "GPU-beef", "GPU-f00d", "GPU-c0de")
+	assert.Equal(t, []GPUID{"GPU-beef", "GPU-f00d"}, ga.Restore(context.Background(), "task-1", []GPUID{"GPU-beef", "GPU-f00d"}, 0, MPSLimits{}))
+	restored := ga.Restore(context.Background(), "task-2", []GPUID{
+		"GPU-beef", // already allocated
+		"GPU-dead", // unknown
+		"GPU-c0de", // idle
+		"GPU-c0de", // duplicate
+	}, 0, MPSLimits{})
+	assert.Equal(t, []GPUID{"GPU-c0de"}, restored)
+
+	// restored once
+	assert.Equal(t, []GPUID{}, ga.Restore(context.Background(), "task-2", []GPUID{"GPU-beef"}, 0, MPSLimits{}))
+	assert.Equal(t, []GPUID{}, ga.Restore(context.Background(), "task-3", nil, 0, MPSLimits{}))
+	assert.Equal(t, map[GPUID]float64{"GPU-beef": 0, "GPU-f00d": 0, "GPU-c0de": 0}, ga.Available())
+}
+
+func TestGPUAllocator_Restore_Shared(t *testing.T) {
+	ga := newTestGPUAllocator(t, "GPU-beef", "GPU-f00d")
+	allocate(t, ga, "task-1", 1)
+
+	restored := ga.Restore(context.Background(), "task-2", []GPUID{"GPU-beef", "GPU-f00d"}, 0.75, MPSLimits{})
+	assert.Equal(t, []GPUID{"GPU-f00d"}, restored)
+	// oversubscribed
+	restored = ga.Restore(context.Background(), "task-3", []GPUID{"GPU-f00d"}, 0.75, MPSLimits{})
+	assert.Equal(t, []GPUID{"GPU-f00d"}, restored)
+	assert.Equal(t, map[string]float64{"task-2": 0.75, "task-3": 0.75}, ga.devices["GPU-f00d"].shares)
+	assert.Equal(t, 0.0, ga.Available()["GPU-f00d"])
+
+	// a shared GPU cannot be restored exclusively
+	assert.Equal(t, []GPUID{}, ga.Restore(context.Background(), "task-4", []GPUID{"GPU-f00d"}, 0, MPSLimits{}))
+}
+
+func TestGPUAllocator_Release(t *testing.T) {
+	ga := newTestGPUAllocator(t, "GPU-beef", "GPU-f00d", "GPU-c0de")
+	allocate(t, ga, "task-1", 2)
+	allocate(t, ga, "task-2", 1)
+
+	assert.Equal(t, []GPUID{"GPU-beef", "GPU-f00d"}, ga.Release(context.Background(), "task-1"))
+	assert.Equal(t, map[GPUID]float64{"GPU-beef": 1, "GPU-f00d": 1, "GPU-c0de": 0}, ga.Available())
+
+	// safe to call multiple times, unknown tasks are ignored
+	assert.Equal(t, []GPUID{}, ga.Release(context.Background(), "task-1"))
+	assert.Equal(t, []GPUID{}, ga.Release(context.Background(), "unknown"))
+	assert.Equal(t, map[GPUID]float64{"GPU-beef": 1, "GPU-f00d": 1, "GPU-c0de": 0}, ga.Available())
+
+	// released GPUs can be allocated again, including to the same task
+	assert.Equal(t, []GPUID{"GPU-beef"}, allocate(t, ga, "task-1", 1))
+}
+
+func TestGPUAllocator_AllocateShared_OverAllocation(t *testing.T) {
+	ga := newTestGPUAllocator(t, "GPU-beef")
+
+	for _, taskID := range []string{"task-1", "task-2"} {
+		ids, err := ga.Allocate(context.Background(), GPURequest{TaskID: taskID, Count: 1, MemoryFraction: 0.5})
+		assert.Nil(t, err)
+		assert.Equal(t, []GPUID{"GPU-beef"}, ids)
+	}
+
+	ids, err := ga.Allocate(context.Background(), GPURequest{TaskID: "task-3", Count: 1, MemoryFraction: 0.1})
+	assert.ErrorIs(t, err, ErrNoCapacity)
+	assert.Equal(t, 0, len(ids))
+	assert.Equal(t, map[string]float64{"task-1": 0.5, "task-2": 0.5}, ga.devices["GPU-beef"].shares)
+	assert.Equal(t, "", ga.devices["GPU-beef"].taskID)
+}
+
+func TestGPUAllocator_AllocateShared_FloatSum(t *testing.T) {
+	ga := newTestGPUAllocator(t, "GPU-beef")
+
+	// 0.3 + 0.3 + 0.4 = 1.0000000000000002
+	for i, fraction := range []float64{0.3, 0.3, 0.4} {
+		_, err := ga.Allocate(context.Background(), GPURequest{TaskID: fmt.Sprintf("task-%d", i), Count: 1, MemoryFraction: fraction})
+		assert.Nil(t, err, fraction)
+	}
+	_, err := ga.Allocate(context.Background(), GPURequest{TaskID: "task-3", Count: 1, MemoryFraction: 0.01})
+	assert.ErrorIs(t, err, ErrNoCapacity)
+	assert.Equal(t, 0.0, ga.Available()["GPU-beef"])
+}
+
+func TestGPUAllocator_AllocateShared_Count(t *testing.T) {
+	ga := newTestGPUAllocator(t, "GPU-beef", "GPU-f00d", "GPU-c0de")
+	allocate(t, ga, "task-0", 1)
+	_, err := ga.Allocate(context.Background(), GPURequest{TaskID: "task-1", Count: 1, MemoryFraction: 0.75})
+	require.NoError(t, err)
+
+	ids, err := ga.Allocate(context.Background(), GPURequest{TaskID: "task-2", Count: 2, MemoryFraction: 0.5})
+	assert.ErrorIs(t, err, ErrNoCapacity)
+	assert.ErrorContains(t, err, "2 GPUs with 0.5 free fraction requested, 1 available")
+	assert.Equal(t, 0, len(ids))
+
+	ids, err = ga.Allocate(context.Background(), GPURequest{TaskID: "task-2", Count: -1, MemoryFraction: 0.25})
+	assert.Nil(t, err)
+	assert.Equal(t, []GPUID{"GPU-f00d", "GPU-c0de"}, ids)
+	assert.Equal(t, map[GPUID]float64{"GPU-beef": 0, "GPU-f00d": 0, "GPU-c0de": 0.75}, ga.Available())
+}
+
+func TestGPUAllocator_Allocate_SkipsShared(t *testing.T) {
+	ga := newTestGPUAllocator(t, "GPU-beef", "GPU-f00d")
+	_, err := ga.Allocate(context.Background(), GPURequest{TaskID: "task-1", Count: 1, MemoryFraction: 0.1})
+	require.NoError(t, err)
+
+	ids, err := ga.Allocate(context.Background(), GPURequest{TaskID: "task-2", Count: 2})
+	assert.ErrorIs(t, err, ErrNoCapacity)
+	assert.Equal(t, 0, len(ids))
+
+	assert.Equal(t, []GPUID{"GPU-f00d"}, allocate(t, ga, "task-2", 1))
+	assert.Equal(t, 0, len(ga.devices["GPU-f00d"].shares))
+}
+
+func TestGPUAllocator_ReleaseShared(t *testing.T) {
+	ga := newTestGPUAllocator(t, "GPU-beef", "GPU-f00d")
+	for _, taskID := range []string{"task-1", "task-2"} {
+		_, err := ga.Allocate(context.Background(), GPURequest{TaskID: taskID, Count: 1, MemoryFraction: 0.5})
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, []GPUID{"GPU-beef"}, ga.Release(context.Background(), "task-1"))
+	assert.Equal(t, map[string]float64{"task-2": 0.5}, ga.devices["GPU-beef"].shares)
+	assert.Equal(t, map[GPUID]float64{"GPU-beef": 0.5, "GPU-f00d": 1}, ga.Available())
+
+	assert.Equal(t, 0, len(ga.Release(context.Background(), "task-1")))
+}
+
+func TestGPUAllocator_Drain(t *testing.T) {
+	ga := newTestGPUAllocator(t, "GPU-beef", "GPU-f00d", "GPU-c0de")
+	assert.Equal(t, []GPUID{"GPU-beef", "GPU-f00d"}, allocate(t, ga, "task-1", 2))
+	_, err := ga.Allocate(context.Background(), GPURequest{TaskID: "task-2", Count: 1, MemoryFraction: 0.5})
+	require.NoError(t, err)
+
+	assert.Equal(t, []GPUID{"GPU-beef", "GPU-f00d"}, ga.Drain("task-1"))
+	// already draining
+	assert.Empty(t, ga.Drain("task-1"))
+	// shared GPUs are not drained
+	assert.Empty(t, ga.Drain("task-2"))
+	assert.Empty(t, ga.Drain("unknown"))
+
+	// released, but not available until drained
+	assert.Equal(t, []GPUID{"GPU-beef", "GPU-f00d"}, ga.Release(context.Background(), "task-1"))
+	assert.Equal(t, map[GPUID]float64{"GPU-beef": 0, "GPU-f00d": 0, "GPU-c0de": 0.5}, ga.Available())
+	_, err = ga.Allocate(context.Background(), GPURequest{TaskID: "task-3", Count: 1})
+	assert.ErrorIs(t, err, ErrNoCapacity)
+
+	ga.Drained([]GPUID{"GPU-beef", "GPU-f00d"})
+	assert.Equal(t, map[GPUID]float64{"GPU-beef": 1, "GPU-f00d": 1, "GPU-c0de": 0.5}, ga.Available())
+	assert.Equal(t, []GPUID{"GPU-beef"}, allocate(t, ga, "task-3", 1))
+}
+
+// checkGPUAllocatorInvariants checks that each GPU is owned by at most one party and shares
+// do not exceed 1.0, and that the task index matches the devices
+func checkGPUAllocatorInvariants(t *testing.T, ga *GPUAllocator) {
+	t.Helper()
+	ga.mu.Lock()
+	defer ga.mu.Unlock()
+	owned := map[GPUID]map[string]bool{}
+	for taskID, ids := range ga.tasks {
+		for _, id := range ids {
+			if owned[id] == nil {
+				owned[id] = map[string]bool{}
+			}
+			owned[id][taskID] = true
+		}
+	}
+	for id, dev := range ga.devices {
+		var owners int
+		if dev.taskID != "" {
+			owners++
+			assert.Equal(t, map[string]bool{dev.taskID: true}, owned[id], id)
+		}
+		if dev.reservationID != "" {
+			owners++
+		}
+		if len(dev.shares) > 0 {
+			owners++
+			var sum float64
+			for taskID, fraction := range dev.shares {
+				sum += fraction
+				assert.True(t, owned[id][taskID], id)
+			}
+			assert.LessOrEqual(t, sum, 1+gpuFractionEpsilon, id)
+			assert.Len(t, owned[id], len(dev.shares), id)
+		}
+		assert.LessOrEqual(t, owners, 1, id)
+		if dev.taskID == "" && len(dev.shares) == 0 {
+			assert.Empty(t, owned[id], id)
+		}
+	}
+}
+
+func TestGPUAllocator_Concurrent(t *testing.T) {
+	ids := make([]GPUID, 8)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("GPU-%d", i)
+	}
+	ga := newTestGPUAllocator(t, ids...)
+	const workers = 32
+	const iterations = 200
+
+	var mu sync.Mutex
+	// GPU ID: task ID holding it exclusively
+	holders := map[GPUID]string{}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				taskID := fmt.Sprintf("task-%d-%d", w, i)
+				req := GPURequest{TaskID: taskID, Count: 1 + (w+i)%3}
+				if w%4 == 0 {
+					req.MemoryFraction = 0.25
+				}
+				allocated, err := ga.Allocate(context.Background(), req)
+				if err != nil {
+					assert.ErrorIs(t, err, ErrNoCapacity)
+					continue
+				}
+				assert.Len(t, allocated, req.Count)
+				if req.MemoryFraction == 0 {
+					mu.Lock()
+					for _, id := range allocated {
+						if holder, ok := holders[id]; ok {
+							t.Errorf("GPU %s allocated to %s and %s", id, holder, taskID)
+						}
+						holders[id] = taskID
+					}
+					mu.Unlock()
+				}
+				if req.MemoryFraction == 0 {
+					// still allocated, no other task can hold these GPUs yet
+					mu.Lock()
+					for _, id := range allocated {
+						delete(holders, id)
+					}
+					mu.Unlock()
+				}
+				released := ga.Release(context.Background(), taskID)
+				assert.ElementsMatch(t, allocated, released)
+			}
+		}()
+	}
+	// concurrent readers and reservations
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	readers.Add(1)
+	go func() {
+		defer readers.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			for _, fraction := range ga.Available() {
+				assert.GreaterOrEqual(t, fraction, 0.0)
+			}
+			now := time.Now()
+			if reservationID, err := ga.Reserve(context.Background(), []GPUID{"GPU-7"}, now.Add(time.Minute)); err == nil {
+				assert.Equal(t, map[GPUID]string{"GPU-7": reservationID}, ga.Reserved())
+				assert.Equal(t, []string{reservationID}, ga.ReleaseExpired(context.Background(), now.Add(time.Minute)))
+			} else {
+				assert.ErrorIs(t, err, ErrNoCapacity)
+			}
+			checkGPUAllocatorInvariants(t, ga)
+		}
+	}()
+	wg.Wait()
+	close(stop)
+	readers.Wait()
+
+	checkGPUAllocatorInvariants(t, ga)
+	for _, id := range ids {
+		assert.Equal(t, 1.0, ga.Available()[id], id)
+	}
+	assert.Empty(t, ga.tasks)
+}
+
+func TestGPUAllocator_Concurrent_AllOrNothing(t *testing.T) {
+	ga := newTestGPUAllocator(t, "GPU-0", "GPU-1", "GPU-2", "GPU-3")
+	const workers = 16
+	results := make([][]GPUID, workers)
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			ids, err := ga.Allocate(context.Background(), GPURequest{TaskID: fmt.Sprintf("task-%d", w), Count: 2})
+			if err != nil {
+				assert.ErrorIs(t, err, ErrNoCapacity)
+				return
+			}
+			results[w] = ids
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	// exactly two tasks get two GPUs each, the others get none
+	allocated := map[GPUID]int{}
+	var succeeded int
+	for _, ids := range results {
+		if ids != nil {
+			succeeded++
+			assert.Len(t, ids, 2)
+		}
+		for _, id := range ids {
+			allocated[id]++
+		}
+	}
+	assert.Equal(t, 2, succeeded)
+	assert.Equal(t, map[GPUID]int{"GPU-0": 1, "GPU-1": 1, "GPU-2": 1, "GPU-3": 1}, allocated)
+	checkGPUAllocatorInvariants(t, ga)
+}
+
+func TestDockerRunner_Concurrent_GPUs(t *testing.T) {
+	client := newFakeDockerClient()
+	gpus := []host.GpuInfo{
+		{Vendor: host.GpuVendorNvidia, ID: "GPU-beef"},
+		{Vendor: host.GpuVendorNvidia, ID: "GPU-f00d"},
+	}
+	runner, err := newDockerRunner(context.Background(), client, &dockerParametersMock{}, gpus)
+	require.NoError(t, err)
+
+	const tasks = 8
+	cfgs := make([]TaskConfig, tasks)
+	var wg sync.WaitGroup
+	for i := range cfgs {
+		cfgs[i] = createTaskConfig(t)
+		cfgs[i].GPU = 1
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, runner.Submit(context.Background(), cfgs[i]))
+			err := runner.Run(context.Background(), cfgs[i].ID)
+			if err != nil {
+				assert.ErrorIs(t, err, ErrNoCapacity)
+			}
+		}()
+	}
+	// each task either gets a GPU and keeps running, or fails
+	var running []TaskInfo
+	require.Eventually(t, func() bool {
+		running = nil
+		for _, cfg := range cfgs {
+			info := runner.TaskInfo(cfg.ID)
+			switch info.Status {
+			case TaskStatusRunning:
+				running = append(running, info)
+			case TaskStatusFailed:
+			default:
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Len(t, running, 2)
+	var allocated []string
+	for _, info := range running {
+		ctr, err := client.getContainer(info.ContainerID)
+		require.NoError(t, err)
+		require.Len(t, ctr.hostConfig.DeviceRequests, 1)
+		allocated = append(allocated, ctr.hostConfig.DeviceRequests[0].DeviceIDs...)
+	}
+	assert.ElementsMatch(t, []string{"GPU-beef", "GPU-f00d"}, allocated)
+
+	for _, info := range running {
+		client.exitContainer(info.ContainerID, 0)
+	}
+	wg.Wait()
+	assert.Equal(t, map[GPUID]float64{"GPU-beef": 1, "GPU-f00d": 1}, runner.gpuAllocator.Available())
+	checkGPUAllocatorInvariants(t, runner.gpuAllocator)
+}
+
+func TestGPUAllocator_AllocateShared_MPSLimits(t *testing.T) {
+	ga, err := NewGPUAllocator([]host.GpuInfo{
+		{Vendor: host.GpuVendorNvidia, ID: "GPU-beef", Vram: 1000},
+		{Vendor: host.GpuVendorNvidia, ID: "GPU-f00d", Vram: 1000},
+	})
+	require.NoError(t, err)
+	allocateShared := func(taskID string, count int, mps MPSLimits) ([]GPUID, error) {
+		return ga.Allocate(context.Background(), GPURequest{TaskID: taskID, Count: count, MemoryFraction: 0.1, MPS: mps})
+	}
+
+	ids, err := allocateShared("task-1", 2, MPSLimits{ThreadPercentage: 60, MemoryMB: 400})
+	require.NoError(t, err)
+	assert.Equal(t, []GPUID{"GPU-beef", "GPU-f00d"}, ids)
+	// tasks without limits are not counted
+	_, err = allocateShared("task-2", 2, MPSLimits{})
+	require.NoError(t, err)
+
+	// 60% + 50% > 100%
+	_, err = allocateShared("task-3", 1, MPSLimits{ThreadPercentage: 50})
+	assert.ErrorIs(t, err, ErrNoCapacity)
+	assert.ErrorContains(t, err, "1 GPUs with 0.1 free fraction, 50% MPS threads, and 0 MiB MPS memory requested, 0 available")
+	// 400 MiB + 700 MiB > 1000 MiB
+	_, err = allocateShared("task-3", 1, MPSLimits{MemoryMB: 700})
+	assert.ErrorIs(t, err, ErrNoCapacity)
+
+	ids, err = allocateShared("task-3", 1, MPSLimits{ThreadPercentage: 40, MemoryMB: 600})
+	require.NoError(t, err)
+	assert.Equal(t, []GPUID{"GPU-beef"}, ids)
+	assert.Equal(t, map[string]MPSLimits{
+		"task-1": {ThreadPercentage: 60, MemoryMB: 400},
+		"task-3": {ThreadPercentage: 40, MemoryMB: 600},
+	}, ga.devices["GPU-beef"].mps)
+	// GPU-beef is fully committed, GPU-f00d is not
+	ids, err = allocateShared("task-4", 1, MPSLimits{ThreadPercentage: 10})
+	require.NoError(t, err)
+	assert.Equal(t, []GPUID{"GPU-f00d"}, ids)
+
+	ga.Release(context.Background(), "task-1")
+	assert.Equal(t, map[string]MPSLimits{"task-3": {ThreadPercentage: 40, MemoryMB: 600}}, ga.devices["GPU-beef"].mps)
+	_, err = allocateShared("task-1", 1, MPSLimits{ThreadPercentage: 60, MemoryMB: 400})
+	assert.NoError(t, err)
+}
+
+func TestGPUAllocator_AllocateShared_MPSLimits_Errors(t *testing.T) {
+	ga := newTestGPUAllocator(t, "GPU-beef")
+	_, err := ga.Allocate(context.Background(), GPURequest{TaskID: "task-1", Count: 1, MPS: MPSLimits{ThreadPercentage: 50}})
+	assert.ErrorContains(t, err, "MPS limits require a shared allocation")
+	_, err = ga.Allocate(context.Background(), GPURequest{TaskID: "task-1", Count: 1, MemoryFraction: 0.5, MPS: MPSLimits{ThreadPercentage: 101}})
+	assert.ErrorContains(t, err, "must be in 1..100 range")
+	// unknown vram, the memory limit is not accounted
+	_, err = ga.Allocate(context.Background(), GPURequest{TaskID: "task-1", Count: 1, MemoryFraction: 0.5, MPS: MPSLimits{MemoryMB: 1 << 20}})
+	assert.NoError(t, err)
+}
+
+func TestGPUAllocator_Restore_MPSLimits(t *testing.T) {
+	ga := newTestGPUAllocator(t, "GPU-beef")
+	// restored as is, even if oversubscribed
+	for _, taskID := range []string{"task-1", "task-2"} {
+		restored := ga.Restore(context.Background(), taskID, []GPUID{"GPU-beef"}, 0.25, MPSLimits{ThreadPercentage: 60})
+		assert.Equal(t, []GPUID{"GPU-beef"}, restored)
+	}
+	assert.Len(t, ga.devices["GPU-beef"].mps, 2)
+	_, err := ga.Allocate(context.Background(), GPURequest{TaskID: "task-3", Count: 1, MemoryFraction: 0.25, MPS: MPSLimits{ThreadPercentage: 1}})
+	assert.ErrorIs(t, err, ErrNoCapacity)
+}
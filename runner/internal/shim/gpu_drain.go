@@ -0,0 +1,127 @@
+package shim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/dstackai/dstack/runner/internal/log"
+)
+
+const defaultGPUDrainCheckInterval = 500 * time.Millisecond
+
+// How long to wait for killed processes to release GPUs
+const gpuDrainKillTimeout = 5 * time.Second
+
+// GPUDrainConfig configures the verification that GPUs released by a task have no compute
+// processes left, e.g., a process that escaped the container or is stuck in the driver,
+// so that the next task doesn't fail with OOM on a "free" GPU
+type GPUDrainConfig struct {
+	// The maximum time to wait for processes to exit, 0 = not verified
+	Timeout time.Duration
+	// If true, processes still running after Timeout are killed with SIGKILL,
+	// otherwise GPUs are released as is
+	Kill bool
+}
+
+// drainGpus waits until GPUs allocated to the task exclusively have no compute processes,
+// then kills lingering processes if configured. GPUs shared with other tasks are not checked,
+// as their processes cannot be told apart. GPUs are available again after this method returns
+// in any case, if processes are still running, a warning is logged and the task history
+// records the event, see TaskHistoryEventGPUNotDrained and TaskHistoryEventGPUForceCleared
+func (d *DockerRunner) drainGpus(ctx context.Context, task *Task) {
+	if d.gpuProcesses == nil || d.gpuDrain.Timeout <= 0 || task.containerID == "" {
+		return
+	}
+	ids := d.gpuAllocator.Drain(task.ID)
+	if len(ids) == 0 {
+		return
+	}
+	defer d.gpuAllocator.Drained(ids)
+	lingering, err := d.waitGpusDrained(ctx, ids, d.gpuDrain.Timeout)
+	if err != nil {
+		log.Warning(ctx, "cannot verify GPUs are drained, releasing", "task", task.ID, "gpus", ids, "err", err)
+		return
+	}
+	if len(lingering) == 0 {
+		return
+	}
+	processes := formatGpuProcesses(ids, lingering)
+	if !d.gpuDrain.Kill {
+		log.Warning(ctx, "GPU processes are still running, releasing", "task", task.ID, "processes", processes)
+		d.tasks.RecordEvent(task.ID, TaskHistoryEvent{Type: TaskHistoryEventGPUNotDrained, Message: processes})
+		return
+	}
+	log.Warning(ctx, "killing lingering GPU processes", "task", task.ID, "processes", processes)
+	for _, id := range ids {
+		for _, pid := range lingering[id] {
+			if err := d.killProcess(pid); err != nil && !errors.Is(err, unix.ESRCH) {
+				log.Error(ctx, "failed to kill GPU process", "task", task.ID, "gpu", id, "pid", pid, "err", err)
+			}
+		}
+	}
+	message := "killed " + processes
+	if lingering, err := d.waitGpusDrained(ctx, ids, gpuDrainKillTimeout); err != nil {
+		log.Warning(ctx, "cannot verify GPUs are drained after kill", "task", task.ID, "err", err)
+	} else if len(lingering) > 0 {
+		processes := formatGpuProcesses(ids, lingering)
+		log.Error(ctx, "GPU processes are still running after kill, releasing", "task", task.ID, "processes", processes)
+		message += ", still running " + processes
+	}
+	d.tasks.RecordEvent(task.ID, TaskHistoryEvent{Type: TaskHistoryEventGPUForceCleared, Message: message})
+}
+
+// waitGpusDrained polls compute processes until none of the GPUs has any or the timeout
+// is reached, returns GPU ID: PIDs mapping of GPUs with processes left
+func (d *DockerRunner) waitGpusDrained(ctx context.Context, ids []GPUID, timeout time.Duration) (map[GPUID][]int, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		processes, err := d.gpuProcesses(ctx)
+		if err != nil {
+			return nil, err
+		}
+		lingering := map[GPUID][]int{}
+		for _, id := range ids {
+			if pids := processes[id]; len(pids) > 0 {
+				lingering[id] = pids
+			}
+		}
+		wait := min(d.gpuDrainCheckInterval, time.Until(deadline))
+		if len(lingering) == 0 || wait <= 0 {
+			return lingering, nil
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return lingering, nil
+		}
+	}
+}
+
+// formatGpuProcesses returns processes in the form of GPU-beef: 123, 456; GPU-f00d: 789
+func formatGpuProcesses(ids []GPUID, processes map[GPUID][]int) string {
+	var parts []string
+	for _, id := range ids {
+		pids := processes[id]
+		if len(pids) == 0 {
+			continue
+		}
+		pids = slices.Sorted(slices.Values(pids))
+		strPids := make([]string, 0, len(pids))
+		for _, pid := range pids {
+			strPids = append(strPids, strconv.Itoa(pid))
+		}
+		parts = append(parts, fmt.Sprintf("%s: %s", id, strings.Join(strPids, ", ")))
+	}
+	return strings.Join(parts, "; ")
+}
+
+func killProcess(pid int) error {
+	return unix.Kill(pid, unix.SIGKILL)
+}
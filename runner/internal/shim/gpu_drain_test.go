@@ -0,0 +1,241 @@
+package shim
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dstackai/dstack/runner/internal/shim/host"
+)
+
+// fakeNVML reports compute processes, a process is gone after being killed or after
+// the given number of queries
+type fakeNVML struct {
+	mu sync.Mutex
+	// GPU ID: PID: queries left until the process exits, -1 = until killed
+	processes map[GPUID]map[int]int
+	queries   int
+	killed    []int
+}
+
+func newFakeNVML() *fakeNVML {
+	return &fakeNVML{processes: map[GPUID]map[int]int{}}
+}
+
+func (n *fakeNVML) addProcess(id GPUID, pid int, queriesLeft int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.processes[id] == nil {
+		n.processes[id] = map[int]int{}
+	}
+	n.processes[id][pid] = queriesLeft
+}
+
+func (n *fakeNVML) ComputeProcesses(context.Context) (map[GPUID][]int, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.queries++
+	result := map[GPUID][]int{}
+	for id, pids := range n.processes {
+		for pid, left := range pids {
+			if left == 0 {
+				delete(pids, pid)
+				continue
+			}
+			if left > 0 {
+				pids[pid] = left - 1
+			}
+			result[id] = append(result[id], pid)
+		}
+	}
+	return result, nil
+}
+
+func (n *fakeNVML) Kill(pid int) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.killed = append(n.killed, pid)
+	for _, pids := range n.processes {
+		delete(pids, pid)
+	}
+	return nil
+}
+
+func newGPUDrainRunner(t *testing.T, client *fakeDockerClient, drain GPUDrainConfig, nvml *fakeNVML) *DockerRunner {
+	t.Helper()
+	gpus := []host.GpuInfo{
+		{Vendor: host.GpuVendorNvidia, ID: "GPU-beef"},
+		{Vendor: host.GpuVendorNvidia, ID: "GPU-f00d"},
+	}
+	runner, err := newDockerRunner(context.Background(), client, &dockerParametersMock{gpuDrain: drain}, gpus)
+	require.NoError(t, err)
+	runner.gpuProcesses = nvml.ComputeProcesses
+	runner.killProcess = nvml.Kill
+	runner.gpuDrainCheckInterval = time.Millisecond
+	return runner
+}
+
+func TestDockerRunner_GPUDrain_Cleared(t *testing.T) {
+	client := newFakeDockerClient()
+	nvml := newFakeNVML()
+	runner := newGPUDrainRunner(t, client, GPUDrainConfig{Timeout: 5 * time.Second, Kill: true}, nvml)
+	cfg := createTaskConfig(t)
+	cfg.GPU = 1
+	containerID := runTask(t, runner, cfg)
+	gpuID := runner.TaskInfo(cfg.ID).GpuIDs[0]
+	// lingers for a few checks after the container exits
+	nvml.addProcess(gpuID, 42, 3)
+	// the other GPU is not allocated to the task
+	nvml.addProcess("GPU-f00d", 43, -1)
+
+	client.exitContainer(containerID, 0)
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusTerminated)
+
+	require.Eventually(t, func() bool { return runner.gpuAllocator.Available()[gpuID] == 1 }, 5*time.Second, time.Millisecond)
+	nvml.mu.Lock()
+	assert.GreaterOrEqual(t, nvml.queries, 4)
+	assert.Empty(t, nvml.killed)
+	nvml.mu.Unlock()
+	for _, event := range runner.TaskInfo(cfg.ID).Events {
+		assert.NotEqual(t, TaskHistoryEventGPUForceCleared, event.Type)
+		assert.NotEqual(t, TaskHistoryEventGPUNotDrained, event.Type)
+	}
+}
+
+func TestDockerRunner_GPUDrain_ForceCleared(t *testing.T) {
+	client := newFakeDockerClient()
+	nvml := newFakeNVML()
+	runner := newGPUDrainRunner(t, client, GPUDrainConfig{Timeout: 20 * time.Millisecond, Kill: true}, nvml)
+	cfg := createTaskConfig(t)
+	cfg.GPU = 2
+	runTask(t, runner, cfg)
+	nvml.addProcess("GPU-f00d", 44, -1)
+	nvml.addProcess("GPU-beef", 43, -1)
+	nvml.addProcess("GPU-beef", 42, -1)
+
+	require.NoError(t, runner.Terminate(context.Background(), cfg.ID, nil, "TERMINATED_BY_USER", ""))
+
+	assert.Equal(t, map[GPUID]float64{"GPU-beef": 1, "GPU-f00d": 1}, runner.gpuAllocator.Available())
+	nvml.mu.Lock()
+	assert.ElementsMatch(t, []int{42, 43, 44}, nvml.killed)
+	nvml.mu.Unlock()
+	var events []TaskHistoryEvent
+	for _, event := range runner.TaskInfo(cfg.ID).Events {
+		if event.Type == TaskHistoryEventGPUForceCleared {
+			events = append(events, event)
+		}
+	}
+	require.Len(t, events, 1)
+	assert.Equal(t, "killed GPU-beef: 42, 43; GPU-f00d: 44", events[0].Message)
+}
+
+func TestDockerRunner_GPUDrain_NoKill(t *testing.T) {
+	client := newFakeDockerClient()
+	nvml := newFakeNVML()
+	runner := newGPUDrainRunner(t, client, GPUDrainConfig{Timeout: 20 * time.Millisecond}, nvml)
+	cfg := createTaskConfig(t)
+	cfg.GPU = 1
+	runTask(t, runner, cfg)
+	gpuID := runner.TaskInfo(cfg.ID).GpuIDs[0]
+	nvml.addProcess(gpuID, 42, -1)
+
+	require.NoError(t, runner.Terminate(context.Background(), cfg.ID, nil, "TERMINATED_BY_USER", ""))
+
+	// released as is
+	assert.Equal(t, 1.0, runner.gpuAllocator.Available()[gpuID])
+	nvml.mu.Lock()
+	assert.Empty(t, nvml.killed)
+	nvml.mu.Unlock()
+	events := runner.TaskInfo(cfg.ID).Events
+	require.NotEmpty(t, events)
+	assert.Contains(t, events, TaskHistoryEvent{
+		Time: events[len(events)-1].Time, Type: TaskHistoryEventGPUNotDrained, Message: gpuID + ": 42",
+	})
+}
+
+func TestDockerRunner_GPUDrain_TaskNotLocked(t *testing.T) {
+	client := newFakeDockerClient()
+	nvml := newFakeNVML()
+	runner := newGPUDrainRunner(t, client, GPUDrainConfig{Timeout: 5 * time.Second}, nvml)
+	cfg := createTaskConfig(t)
+	cfg.GPU = 1
+	runTask(t, runner, cfg)
+	gpuID := runner.TaskInfo(cfg.ID).GpuIDs[0]
+	draining := make(chan struct{})
+	drained := make(chan struct{})
+	var once sync.Once
+	runner.gpuProcesses = func(ctx context.Context) (map[GPUID][]int, error) {
+		once.Do(func() { close(draining) })
+		<-drained
+		return nvml.ComputeProcesses(ctx)
+	}
+
+	terminated := make(chan error)
+	go func() {
+		terminated <- runner.Terminate(context.Background(), cfg.ID, nil, "TERMINATED_BY_USER", "")
+	}()
+	<-draining
+	// the task lock is not held while draining, other operations on the task proceed
+	done := make(chan error)
+	go func() {
+		done <- runner.Terminate(context.Background(), cfg.ID, nil, "TERMINATED_BY_USER", "")
+	}()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Error("blocked by the GPU drain")
+	}
+	assert.Equal(t, TaskStatusTerminated, runner.TaskInfo(cfg.ID).Status)
+	assert.Zero(t, runner.gpuAllocator.Available()[gpuID])
+
+	close(drained)
+	require.NoError(t, <-terminated)
+	require.Eventually(t, func() bool { return runner.gpuAllocator.Available()[gpuID] == 1 }, 5*time.Second, time.Millisecond)
+}
+
+func TestDockerRunner_GPUDrain_Disabled(t *testing.T) {
+	client := newFakeDockerClient()
+	nvml := newFakeNVML()
+	runner := newGPUDrainRunner(t, client, GPUDrainConfig{}, nvml)
+	cfg := createTaskConfig(t)
+	cfg.GPU = 1
+	runTask(t, runner, cfg)
+	nvml.addProcess(runner.TaskInfo(cfg.ID).GpuIDs[0], 42, -1)
+
+	require.NoError(t, runner.Terminate(context.Background(), cfg.ID, nil, "TERMINATED_BY_USER", ""))
+
+	nvml.mu.Lock()
+	assert.Zero(t, nvml.queries)
+	nvml.mu.Unlock()
+}
+
+func TestDockerRunner_GPUDrain_SharedNotChecked(t *testing.T) {
+	client := newFakeDockerClient()
+	nvml := newFakeNVML()
+	runner := newGPUDrainRunner(t, client, GPUDrainConfig{Timeout: 5 * time.Second, Kill: true}, nvml)
+	cfg := createTaskConfig(t)
+	cfg.GPU = 1
+	cfg.GPUMemoryFraction = 0.5
+	runTask(t, runner, cfg)
+	nvml.addProcess(runner.TaskInfo(cfg.ID).GpuIDs[0], 42, -1)
+
+	require.NoError(t, runner.Terminate(context.Background(), cfg.ID, nil, "TERMINATED_BY_USER", ""))
+
+	nvml.mu.Lock()
+	assert.Zero(t, nvml.queries)
+	assert.Empty(t, nvml.killed)
+	nvml.mu.Unlock()
+}
+
+func TestFormatGpuProcesses(t *testing.T) {
+	assert.Equal(t, "GPU-beef: 1, 2; GPU-f00d: 3", formatGpuProcesses(
+		[]GPUID{"GPU-beef", "GPU-c0de", "GPU-f00d"},
+		map[GPUID][]int{"GPU-f00d": {3}, "GPU-beef": {2, 1}},
+	))
+	assert.Equal(t, "", formatGpuProcesses([]GPUID{"GPU-beef"}, nil))
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -64,6 +65,12 @@ func GetGpuInfo(ctx context.Context) []GpuInfo {
 func getNvidiaGpuInfo(ctx context.Context) []GpuInfo {
 	gpus := []GpuInfo{}
 
+	// The device nodes may be present without the driver userspace, e.g., in a container
+	if _, err := exec.LookPath("nvidia-smi"); err != nil {
+		log.Error(ctx, "nvidia-smi is not available", "err", err)
+		return gpus
+	}
+
 	cmd := execute.ExecTask{
 		Command:     "nvidia-smi",
 		Args:        []string{"--query-gpu=name,memory.total,uuid", "--format=csv,noheader,nounits"},
@@ -196,3 +203,81 @@ func getAmdRenderNodePath(bdf string) (string, error) {
 func IsRenderNodePath(path string) bool {
 	return strings.HasPrefix(path, "/dev/dri/renderD")
 }
+
+// GetNvidiaGpuMemoryUsage returns GPU ID: used memory (MiB) mapping
+func GetNvidiaGpuMemoryUsage(ctx context.Context) (map[string]int, error) {
+	cmd := execute.ExecTask{
+		Command:     "nvidia-smi",
+		Args:        []string{"--query-gpu=uuid,memory.used", "--format=csv,noheader,nounits"},
+		StreamStdio: false,
+	}
+	res, err := cmd.Execute(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute nvidia-smi: %w", err)
+	}
+	if res.ExitCode != 0 {
+		return nil, fmt.Errorf("nvidia-smi exited with exit code %d: %s", res.ExitCode, res.Stderr)
+	}
+	usage := make(map[string]int)
+	r := csv.NewReader(strings.NewReader(res.Stdout))
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot read csv: %w", err)
+		}
+		if len(record) != 2 {
+			return nil, fmt.Errorf("2 csv fields expected, got %d", len(record))
+		}
+		used, err := strconv.Atoi(strings.TrimSpace(record[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid memory.used value %q: %w", record[1], err)
+		}
+		usage[strings.TrimSpace(record[0])] = used
+	}
+	return usage, nil
+}
+
+// GetNvidiaGpuComputeProcesses returns GPU ID: PIDs (in the host PID namespace) mapping
+// of compute processes, GPUs without processes are omitted
+func GetNvidiaGpuComputeProcesses(ctx context.Context) (map[string][]int, error) {
+	cmd := execute.ExecTask{
+		Command:     "nvidia-smi",
+		Args:        []string{"--query-compute-apps=gpu_uuid,pid", "--format=csv,noheader"},
+		StreamStdio: false,
+	}
+	res, err := cmd.Execute(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute nvidia-smi: %w", err)
+	}
+	if res.ExitCode != 0 {
+		return nil, fmt.Errorf("nvidia-smi exited with exit code %d: %s", res.ExitCode, res.Stderr)
+	}
+	return parseNvidiaComputeProcesses(res.Stdout)
+}
+
+func parseNvidiaComputeProcesses(output string) (map[string][]int, error) {
+	processes := make(map[string][]int)
+	r := csv.NewReader(strings.NewReader(output))
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot read csv: %w", err)
+		}
+		if len(record) != 2 {
+			return nil, fmt.Errorf("2 csv fields expected, got %d", len(record))
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(record[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid pid value %q: %w", record[1], err)
+		}
+		id := strings.TrimSpace(record[0])
+		processes[id] = append(processes[id], pid)
+	}
+	return processes, nil
+}
@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"net"
 	"runtime"
+	"time"
 
 	"github.com/dstackai/dstack/runner/internal/log"
+	gopsutilhost "github.com/shirou/gopsutil/v4/host"
 	"github.com/shirou/gopsutil/v4/mem"
 	"golang.org/x/sys/unix"
 )
@@ -24,6 +26,15 @@ func GetTotalMemory(ctx context.Context) (uint64, error) {
 	return v.Total, nil
 }
 
+// GetBootTime returns the time the host was booted at, with seconds precision
+func GetBootTime(ctx context.Context) (time.Time, error) {
+	bootTime, err := gopsutilhost.BootTimeWithContext(ctx)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("cannot get boot time: %w", err)
+	}
+	return time.Unix(int64(bootTime), 0), nil
+}
+
 func GetDiskSize(ctx context.Context, path string) (uint64, error) {
 	var stat unix.Statfs_t
 	err := unix.Statfs(path, &stat)
@@ -34,6 +45,18 @@ func GetDiskSize(ctx context.Context, path string) (uint64, error) {
 	return size, nil
 }
 
+// GetDiskUsage returns the total and used space of the filesystem the path is on, used includes
+// blocks reserved for root
+func GetDiskUsage(ctx context.Context, path string) (total uint64, used uint64, err error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return 0, 0, fmt.Errorf("cannot get disk usage: %w", err)
+	}
+	total = stat.Blocks * uint64(stat.Bsize)
+	used = (stat.Blocks - stat.Bfree) * uint64(stat.Bsize)
+	return total, used, nil
+}
+
 func GetNetworkAddresses(ctx context.Context) ([]string, error) {
 	var addresses []string
 	ifaces, err := net.Interfaces()
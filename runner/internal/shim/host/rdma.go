@@ -0,0 +1,103 @@
+package host
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// RDMA devices (InfiniBand HCAs, RoCE NICs, AWS EFA adapters) registered with the kernel,
+// the same source ibv_devices reads
+const InfinibandSysfsDir = "/sys/class/infiniband"
+
+// Character devices of RDMA verbs (uverbsN) and connection managers, mounted into containers
+const InfinibandDevDir = "/dev/infiniband"
+
+// The port state reported by the kernel once the link is up and the port is configured
+// by the subnet manager (or is Ethernet with RoCE)
+const RDMAPortStateActive = "ACTIVE"
+
+type RDMAPort struct {
+	Number int `json:"number"`
+	// Logical state, e.g., ACTIVE, INIT, DOWN
+	State string `json:"state"`
+	// Physical state, e.g., LinkUp, Polling, Disabled
+	PhysState string `json:"phys_state"`
+	// InfiniBand or Ethernet (RoCE)
+	LinkLayer string `json:"link_layer"`
+	// e.g., "200 Gb/sec (4X HDR)"
+	Rate string `json:"rate"`
+}
+
+type RDMADevice struct {
+	Name  string     `json:"name"`
+	Ports []RDMAPort `json:"ports"`
+}
+
+// IsActive reports whether any of the device ports is active
+func (d RDMADevice) IsActive() bool {
+	return slices.ContainsFunc(d.Ports, func(p RDMAPort) bool { return p.State == RDMAPortStateActive })
+}
+
+// GetRDMADevices lists RDMA devices and their ports found in the sysfs dir
+// (normally, InfinibandSysfsDir), ordered by name. No devices if the dir doesn't exist
+func GetRDMADevices(sysfsDir string) ([]RDMADevice, error) {
+	entries, err := os.ReadDir(sysfsDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []RDMADevice{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot list RDMA devices: %w", err)
+	}
+	devices := []RDMADevice{}
+	for _, entry := range entries {
+		// device entries are symlinks to /sys/devices/...
+		device := RDMADevice{Name: entry.Name(), Ports: []RDMAPort{}}
+		portsDir := filepath.Join(sysfsDir, entry.Name(), "ports")
+		portEntries, err := os.ReadDir(portsDir)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("cannot list ports of RDMA device %s: %w", device.Name, err)
+		}
+		for _, portEntry := range portEntries {
+			number, err := strconv.Atoi(portEntry.Name())
+			if err != nil {
+				continue
+			}
+			portDir := filepath.Join(portsDir, portEntry.Name())
+			device.Ports = append(device.Ports, RDMAPort{
+				Number:    number,
+				State:     readRDMAPortState(filepath.Join(portDir, "state")),
+				PhysState: readRDMAPortState(filepath.Join(portDir, "phys_state")),
+				LinkLayer: readSysfsValue(filepath.Join(portDir, "link_layer")),
+				Rate:      readSysfsValue(filepath.Join(portDir, "rate")),
+			})
+		}
+		slices.SortFunc(device.Ports, func(a, b RDMAPort) int { return a.Number - b.Number })
+		devices = append(devices, device)
+	}
+	slices.SortFunc(devices, func(a, b RDMADevice) int { return strings.Compare(a.Name, b.Name) })
+	return devices, nil
+}
+
+// readRDMAPortState strips the numeric code, e.g., "4: ACTIVE" -> "ACTIVE"
+func readRDMAPortState(path string) string {
+	value := readSysfsValue(path)
+	if _, state, ok := strings.Cut(value, ":"); ok {
+		return strings.TrimSpace(state)
+	}
+	return value
+}
+
+// readSysfsValue returns an empty string if the file cannot be read, as not all drivers
+// expose all attributes
+func readSysfsValue(path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
@@ -0,0 +1,56 @@
+package host
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeRDMAPortFixture creates <dir>/<device>/ports/<port>/ with the given attributes,
+// as found in /sys/class/infiniband
+func writeRDMAPortFixture(t *testing.T, dir string, device string, port string, attrs map[string]string) {
+	t.Helper()
+	portDir := filepath.Join(dir, device, "ports", port)
+	require.NoError(t, os.MkdirAll(portDir, 0o755))
+	for name, value := range attrs {
+		require.NoError(t, os.WriteFile(filepath.Join(portDir, name), []byte(value+"\n"), 0o644))
+	}
+}
+
+func TestGetRDMADevices(t *testing.T) {
+	dir := t.TempDir()
+	writeRDMAPortFixture(t, dir, "mlx5_1", "1", map[string]string{
+		"state": "1: DOWN", "phys_state": "3: Disabled", "link_layer": "Ethernet", "rate": "10 Gb/sec (1X QDR)",
+	})
+	writeRDMAPortFixture(t, dir, "mlx5_0", "2", map[string]string{"state": "2: INIT"})
+	writeRDMAPortFixture(t, dir, "mlx5_0", "1", map[string]string{
+		"state": "4: ACTIVE", "phys_state": "5: LinkUp", "link_layer": "InfiniBand", "rate": "200 Gb/sec (4X HDR)",
+	})
+	// no ports dir
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "efa_0"), 0o755))
+
+	devices, err := GetRDMADevices(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []RDMADevice{
+		{Name: "efa_0", Ports: []RDMAPort{}},
+		{Name: "mlx5_0", Ports: []RDMAPort{
+			{Number: 1, State: "ACTIVE", PhysState: "LinkUp", LinkLayer: "InfiniBand", Rate: "200 Gb/sec (4X HDR)"},
+			{Number: 2, State: "INIT"},
+		}},
+		{Name: "mlx5_1", Ports: []RDMAPort{
+			{Number: 1, State: "DOWN", PhysState: "Disabled", LinkLayer: "Ethernet", Rate: "10 Gb/sec (1X QDR)"},
+		}},
+	}, devices)
+	assert.False(t, devices[0].IsActive())
+	assert.True(t, devices[1].IsActive())
+	assert.False(t, devices[2].IsActive())
+}
+
+func TestGetRDMADevices_NoSysfsDir(t *testing.T) {
+	devices, err := GetRDMADevices(filepath.Join(t.TempDir(), "infiniband"))
+	require.NoError(t, err)
+	assert.Equal(t, []RDMADevice{}, devices)
+}
@@ -19,6 +19,7 @@ type hostInfo struct {
 	DiskSize  uint64         `json:"disk_size"` // bytes
 	NumCPUs   int            `json:"cpus"`
 	Memory    uint64         `json:"memory"` // bytes
+	RDMA      RDMAInfo       `json:"rdma"`
 }
 
 func WriteHostInfo(dir string, resources Resources) error {
@@ -48,6 +49,7 @@ func WriteHostInfo(dir string, resources Resources) error {
 		DiskSize:  resources.DiskSize,
 		NumCPUs:   resources.CpuCount,
 		Memory:    resources.TotalMemory,
+		RDMA:      resources.RDMA,
 	}
 
 	b, err := json.Marshal(info)
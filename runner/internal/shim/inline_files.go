@@ -0,0 +1,130 @@
+package shim
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/dstackai/dstack/runner/internal/log"
+)
+
+// Inline files are kept in memory and sent in the submit request, they are meant for small
+// config files, larger data should be put into volumes
+const (
+	maxInlineFiles         = 64
+	maxInlineFileSize      = 1024 * 1024
+	maxInlineFilesSize     = 4 * 1024 * 1024
+	defaultInlineFileMode  = 0o644
+	redactedInlineFileText = "<redacted>"
+)
+
+// InlineFile is written into the container after it is created, before it is started.
+// Missing parent directories are created, an existing file is overwritten.
+// The file is owned by root
+type InlineFile struct {
+	// An absolute path inside the container
+	Path    string `json:"path"`
+	Content string `json:"content"`
+	// Permission bits, 0 = 0644
+	Mode uint32 `json:"mode"`
+	// The content is never logged
+	Secret bool `json:"secret"`
+}
+
+// String redacts the content of secret files, it's used when the file is logged or formatted
+func (f InlineFile) String() string {
+	content := f.Content
+	if f.Secret {
+		content = redactedInlineFileText
+	}
+	return fmt.Sprintf("{%s %#o %q}", f.Path, f.getMode(), content)
+}
+
+func (f InlineFile) getMode() int64 {
+	if f.Mode == 0 {
+		return defaultInlineFileMode
+	}
+	return int64(f.Mode)
+}
+
+// validateInlineFiles checks paths, modes, and size limits. Error messages never include the content
+func validateInlineFiles(files []InlineFile) error {
+	if len(files) > maxInlineFiles {
+		return fmt.Errorf("%w: too many files: %d > %d", ErrInvalidConfig, len(files), maxInlineFiles)
+	}
+	seen := map[string]bool{}
+	totalSize := 0
+	for _, f := range files {
+		filePath, err := validateContainerPath(f.Path)
+		if err != nil {
+			return err
+		}
+		if filePath == "/" || strings.HasSuffix(f.Path, "/") {
+			return fmt.Errorf("%w: file path must not be a directory: %s", ErrInvalidConfig, f.Path)
+		}
+		if seen[filePath] {
+			return fmt.Errorf("%w: duplicate file path: %s", ErrInvalidConfig, filePath)
+		}
+		seen[filePath] = true
+		if f.Mode > 0o777 {
+			return fmt.Errorf("%w: file %s: mode must be in 0..0777 range, got %#o", ErrInvalidConfig, filePath, f.Mode)
+		}
+		if len(f.Content) > maxInlineFileSize {
+			return fmt.Errorf("%w: file %s is too large: %d > %d bytes", ErrInvalidConfig, filePath, len(f.Content), maxInlineFileSize)
+		}
+		totalSize += len(f.Content)
+	}
+	if totalSize > maxInlineFilesSize {
+		return fmt.Errorf("%w: files are too large: %d > %d bytes in total", ErrInvalidConfig, totalSize, maxInlineFilesSize)
+	}
+	return nil
+}
+
+// writeInlineFiles copies TaskConfig.Files into the created container as a tar archive
+// extracted at the root directory
+func (d *DockerRunner) writeInlineFiles(ctx context.Context, task *Task) error {
+	files := task.config.Files
+	if len(files) == 0 {
+		return nil
+	}
+	archive, err := createInlineFilesArchive(files)
+	if err != nil {
+		return fmt.Errorf("%w: failed to archive files: %w", ErrInternal, err)
+	}
+	if err := d.client.CopyToContainer(ctx, task.containerID, "/", archive, types.CopyToContainerOptions{}); err != nil {
+		return fmt.Errorf("%w: failed to copy files to container: %w", ErrInternal, err)
+	}
+	log.Debug(ctx, "files written", "task", task.ID, "files", files)
+	return nil
+}
+
+func createInlineFilesArchive(files []InlineFile) (*bytes.Buffer, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	now := time.Now()
+	for _, f := range files {
+		// validated, see validateInlineFiles()
+		filePath, _ := validateContainerPath(f.Path)
+		hdr := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     strings.TrimPrefix(filePath, "/"),
+			Mode:     f.getMode(),
+			Size:     int64(len(f.Content)),
+			ModTime:  now,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write([]byte(f.Content)); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
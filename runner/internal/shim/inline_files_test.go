@@ -0,0 +1,107 @@
+package shim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateInlineFiles(t *testing.T) {
+	assert.NoError(t, validateInlineFiles(nil))
+	assert.NoError(t, validateInlineFiles([]InlineFile{
+		{Path: "/etc/app/config.yaml", Content: "key: value"},
+		{Path: "/root/.netrc", Content: strings.Repeat("x", maxInlineFileSize), Mode: 0o600, Secret: true},
+	}))
+}
+
+func TestValidateInlineFiles_Errors(t *testing.T) {
+	tooManyFiles := make([]InlineFile, maxInlineFiles+1)
+	for i := range tooManyFiles {
+		tooManyFiles[i] = InlineFile{Path: fmt.Sprintf("/f%d", i)}
+	}
+	tooLargeFiles := make([]InlineFile, maxInlineFilesSize/maxInlineFileSize+1)
+	for i := range tooLargeFiles {
+		tooLargeFiles[i] = InlineFile{Path: fmt.Sprintf("/f%d", i), Content: strings.Repeat("x", maxInlineFileSize)}
+	}
+	testCases := []struct {
+		files []InlineFile
+		err   string
+	}{
+		{[]InlineFile{{Path: "etc/config"}}, "path must be absolute"},
+		{[]InlineFile{{Path: "/etc/../config"}}, "must not contain `..`"},
+		{[]InlineFile{{Path: "/"}}, "must not be a directory"},
+		{[]InlineFile{{Path: "/etc/"}}, "must not be a directory"},
+		{[]InlineFile{{Path: "/etc/config"}, {Path: "/etc//config"}}, "duplicate file path"},
+		{[]InlineFile{{Path: "/etc/config", Mode: 0o4755}}, "mode must be in 0..0777 range"},
+		{[]InlineFile{{Path: "/big", Content: strings.Repeat("x", maxInlineFileSize+1)}}, "file /big is too large"},
+		{tooLargeFiles, "in total"},
+		{tooManyFiles, "too many files"},
+	}
+	for _, tc := range testCases {
+		err := validateInlineFiles(tc.files)
+		assert.ErrorIs(t, err, ErrInvalidConfig, tc.err)
+		assert.ErrorContains(t, err, tc.err)
+	}
+}
+
+func TestInlineFile_String(t *testing.T) {
+	f := InlineFile{Path: "/etc/config", Content: "key: value"}
+	assert.Equal(t, `{/etc/config 0644 "key: value"}`, f.String())
+
+	secret := InlineFile{Path: "/root/.netrc", Content: "password hunter2", Mode: 0o600, Secret: true}
+	assert.Equal(t, `{/root/.netrc 0600 "<redacted>"}`, secret.String())
+	// nested values are formatted with String() as well
+	assert.NotContains(t, fmt.Sprintf("%+v", TaskConfig{Files: []InlineFile{secret}}), "hunter2")
+}
+
+func TestDockerRunner_InlineFiles(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.Files = []InlineFile{
+		{Path: "/etc/app/config.yaml", Content: "key: value"},
+		{Path: "/root/.netrc", Content: "password hunter2", Mode: 0o600, Secret: true},
+	}
+	containerID := runTask(t, runner, cfg)
+	defer client.exitContainer(containerID, 0)
+
+	ctr, err := client.getContainer(containerID)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"/etc/app/config.yaml": "key: value",
+		"/root/.netrc":         "password hunter2",
+	}, ctr.files)
+	assert.Equal(t, map[string]int64{
+		"/etc/app/config.yaml": 0o644,
+		"/root/.netrc":         0o600,
+	}, ctr.fileModes)
+}
+
+func TestDockerRunner_InlineFiles_CopyError(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	client.injectErrors("CopyToContainer", errors.New("no space left on device"))
+	cfg := createTaskConfig(t)
+	cfg.Files = []InlineFile{{Path: "/etc/config", Content: "key: value"}}
+	require.NoError(t, runner.Submit(context.Background(), cfg))
+
+	assert.Error(t, runner.Run(context.Background(), cfg.ID))
+	info := runner.TaskInfo(cfg.ID)
+	assert.Equal(t, TaskStatusFailed, info.Status)
+	assert.Equal(t, "CREATING_CONTAINER_ERROR", info.TerminationReason)
+	assert.Contains(t, info.TerminationMessage, "failed to copy files to container")
+}
+
+func TestDockerRunner_InlineFiles_SubmitRejected(t *testing.T) {
+	runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.Files = []InlineFile{{Path: "/big", Content: strings.Repeat("x", maxInlineFileSize+1)}}
+
+	assert.ErrorIs(t, runner.Submit(context.Background(), cfg), ErrInvalidConfig)
+	assert.Equal(t, "", runner.TaskInfo(cfg.ID).ID)
+}
@@ -0,0 +1,86 @@
+package shim
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// validateLabels checks user-provided container labels, see TaskConfig.Labels.
+// Keys under LabelKeyPrefix are reserved for the shim, they identify task containers
+// and carry the state restored on shim restarts
+func validateLabels(labels map[string]string) error {
+	for key := range labels {
+		if key == "" {
+			return fmt.Errorf("%w: label key must not be empty", ErrInvalidConfig)
+		}
+		// Docker label keys are case-sensitive, but a look-alike key is rejected as well
+		if strings.HasPrefix(strings.ToLower(key), LabelKeyPrefix) {
+			return fmt.Errorf("%w: label %s: %s prefix is reserved", ErrInvalidConfig, key, LabelKeyPrefix)
+		}
+	}
+	return nil
+}
+
+// mergeLabels returns a new map with both user and shim labels, shim labels always win
+func mergeLabels(userLabels map[string]string, shimLabels map[string]string) map[string]string {
+	labels := make(map[string]string, len(userLabels)+len(shimLabels))
+	maps.Copy(labels, userLabels)
+	maps.Copy(labels, shimLabels)
+	return labels
+}
+
+// TaskSelector matches tasks by TaskConfig.Labels and annotations (TaskConfig.Annotations, updated by Update()):
+// key: value mapping, all keys must match, a key matches if either the label or the annotation
+// has the value. The empty selector matches all tasks
+type TaskSelector map[string]string
+
+// ParseTaskSelector parses comma-separated key=value terms, e.g., user=alice,project=main.
+// Keys must not be repeated, values may be empty
+func ParseTaskSelector(s string) (TaskSelector, error) {
+	selector := TaskSelector{}
+	if s == "" {
+		return selector, nil
+	}
+	for _, term := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(term, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%w: invalid selector term %q, must be key=value", ErrRequest, term)
+		}
+		if _, ok := selector[key]; ok {
+			return nil, fmt.Errorf("%w: selector key %s is repeated", ErrRequest, key)
+		}
+		selector[key] = strings.TrimSpace(value)
+	}
+	return selector, nil
+}
+
+func (s TaskSelector) Matches(labels map[string]string, annotations map[string]string) bool {
+	for key, value := range s {
+		if label, ok := labels[key]; ok && label == value {
+			continue
+		}
+		if annotation, ok := annotations[key]; ok && annotation == value {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// SelectTasks returns IDs of tasks matching the selector, in ascending order, including finished
+// ones. Tasks restored on shim restart have no labels, they only match by annotations,
+// which are restored, see taskStates
+func (d *DockerRunner) SelectTasks(selector TaskSelector) []string {
+	ids := []string{}
+	for _, id := range d.tasks.IDs() {
+		task, ok := d.tasks.Get(id)
+		if ok && selector.Matches(task.config.Labels, d.annotations.Get(id)) {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
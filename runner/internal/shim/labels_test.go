@@ -0,0 +1,120 @@
+package shim
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateLabels(t *testing.T) {
+	assert.NoError(t, validateLabels(nil))
+	assert.NoError(t, validateLabels(map[string]string{
+		"com.example.team": "research",
+		"ai.dstack.run":    "my-run",
+		"empty":            "",
+	}))
+	for _, key := range []string{"", LabelKeyTaskID, LabelKeyPrefix + "custom", "AI.dstack.Shim.is-task"} {
+		err := validateLabels(map[string]string{key: "value"})
+		assert.ErrorIs(t, err, ErrInvalidConfig, key)
+	}
+}
+
+func TestMergeLabels(t *testing.T) {
+	userLabels := map[string]string{"team": "research", LabelKeyTaskID: "spoofed"}
+	labels := mergeLabels(userLabels, map[string]string{LabelKeyIsTask: LabelValueTrue, LabelKeyTaskID: "task"})
+	assert.Equal(t, map[string]string{"team": "research", LabelKeyIsTask: LabelValueTrue, LabelKeyTaskID: "task"}, labels)
+	// the inputs are not modified
+	assert.Equal(t, "spoofed", userLabels[LabelKeyTaskID])
+
+	assert.Equal(t, map[string]string{LabelKeyIsTask: LabelValueTrue}, mergeLabels(nil, map[string]string{LabelKeyIsTask: LabelValueTrue}))
+}
+
+func TestDockerRunner_Labels(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.Labels = map[string]string{"com.example.team": "research", "com.example.cost-center": "42"}
+	containerID := runTask(t, runner, cfg)
+	defer client.exitContainer(containerID, 0)
+
+	ctr, err := client.getContainer(containerID)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"com.example.team":        "research",
+		"com.example.cost-center": "42",
+		LabelKeyIsTask:            LabelValueTrue,
+		LabelKeyTaskID:            cfg.ID,
+		LabelKeyTraceID:           runner.TaskInfo(cfg.ID).TraceID,
+	}, ctr.config.Labels)
+}
+
+func TestDockerRunner_Labels_SubmitRejected(t *testing.T) {
+	runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.Labels = map[string]string{"com.example.team": "research", LabelKeyIsTask: "false"}
+
+	err := runner.Submit(context.Background(), cfg)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.ErrorContains(t, err, "ai.dstack.shim. prefix is reserved")
+	assert.Equal(t, "", runner.TaskInfo(cfg.ID).ID)
+}
+
+func TestParseTaskSelector(t *testing.T) {
+	selector, err := ParseTaskSelector("user=alice, project = main,empty=")
+	require.NoError(t, err)
+	assert.Equal(t, TaskSelector{"user": "alice", "project": "main", "empty": ""}, selector)
+
+	selector, err = ParseTaskSelector("")
+	require.NoError(t, err)
+	assert.Empty(t, selector)
+
+	for _, s := range []string{"user", "=alice", "user=alice,", "user=alice,user=bob"} {
+		_, err := ParseTaskSelector(s)
+		assert.ErrorIs(t, err, ErrRequest, s)
+	}
+}
+
+func TestTaskSelector_Matches(t *testing.T) {
+	labels := map[string]string{"user": "alice", "project": "main"}
+	annotations := map[string]string{"team": "ml"}
+	testCases := []struct {
+		selector TaskSelector
+		matches  bool
+	}{
+		{TaskSelector{}, true},
+		{TaskSelector{"user": "alice"}, true},
+		{TaskSelector{"user": "alice", "project": "main"}, true},
+		{TaskSelector{"user": "alice", "team": "ml"}, true},
+		{TaskSelector{"user": "bob"}, false},
+		{TaskSelector{"user": "alice", "project": "dev"}, false},
+		{TaskSelector{"unknown": ""}, false},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.matches, tc.selector.Matches(labels, annotations), tc.selector)
+	}
+	assert.False(t, TaskSelector{"user": "alice"}.Matches(nil, nil))
+}
+
+func TestDockerRunner_SelectTasks(t *testing.T) {
+	runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{})
+	cfgA := createTaskConfig(t)
+	cfgA.Labels = map[string]string{"user": "alice"}
+	cfgB := createTaskConfig(t)
+	cfgB.Labels = map[string]string{"user": "bob"}
+	cfgB.Annotations = map[string]string{"project": "main"}
+	cfgC := createTaskConfig(t)
+	for _, cfg := range []TaskConfig{cfgA, cfgB, cfgC} {
+		require.NoError(t, runner.Submit(context.Background(), cfg))
+	}
+
+	assert.Equal(t, []string{cfgA.ID}, runner.SelectTasks(TaskSelector{"user": "alice"}))
+	assert.Equal(t, []string{cfgB.ID}, runner.SelectTasks(TaskSelector{"project": "main"}))
+	assert.Equal(t, []string{}, runner.SelectTasks(TaskSelector{"user": "carol"}))
+	assert.Len(t, runner.SelectTasks(TaskSelector{}), 3)
+
+	_, err := runner.Update(context.Background(), cfgA.ID, TaskUpdate{Annotations: map[string]string{"project": "main"}})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{cfgA.ID, cfgB.ID}, runner.SelectTasks(TaskSelector{"project": "main"}))
+}
@@ -0,0 +1,151 @@
+package shim
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dstackai/dstack/runner/internal/log"
+)
+
+// How often expired leases are checked, that is, the maximum delay between the lease
+// expiration and the task termination
+const leaseCheckInterval = 5 * time.Second
+
+// Set on containers of tasks with a lease, the value is TaskConfig.LeaseDuration
+const LabelKeyLeaseDuration = LabelKeyPrefix + "lease-duration"
+
+type clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+type lease struct {
+	duration  time.Duration
+	expiresAt time.Time
+}
+
+// taskLeases tracks leases of tasks that must be periodically renewed by the server.
+// Leases are stored separately from TaskStorage, since Task is updated by copy, and
+// a renewal would be lost if a concurrent operation (e.g., Run()) commits its own copy
+type taskLeases struct {
+	clock clock
+	// Task.ID: lease mapping
+	leases map[string]lease
+	mu     sync.Mutex
+}
+
+func newTaskLeases(clock clock) *taskLeases {
+	return &taskLeases{
+		clock:  clock,
+		leases: make(map[string]lease),
+	}
+}
+
+// Start starts (or restarts) the lease, counting from now
+func (l *taskLeases) Start(taskID string, duration time.Duration) time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	expiresAt := l.clock.Now().Add(duration)
+	l.leases[taskID] = lease{duration: duration, expiresAt: expiresAt}
+	return expiresAt
+}
+
+// Renew extends the lease by its duration, counting from now. ok is false if the task
+// has no lease
+func (l *taskLeases) Renew(taskID string) (expiresAt time.Time, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ls, ok := l.leases[taskID]
+	if !ok {
+		return time.Time{}, false
+	}
+	ls.expiresAt = l.clock.Now().Add(ls.duration)
+	l.leases[taskID] = ls
+	return ls.expiresAt, true
+}
+
+// Get returns the current lease, ok is false if the task has no lease
+func (l *taskLeases) Get(taskID string) (duration time.Duration, expiresAt time.Time, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ls, ok := l.leases[taskID]
+	return ls.duration, ls.expiresAt, ok
+}
+
+func (l *taskLeases) Delete(taskID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.leases, taskID)
+}
+
+// Expired returns IDs of tasks with expired leases
+func (l *taskLeases) Expired() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.clock.Now()
+	ids := []string{}
+	for id, ls := range l.leases {
+		if !now.Before(ls.expiresAt) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Renew extends the task lease, the task must be submitted with non-zero LeaseDuration
+func (d *DockerRunner) Renew(ctx context.Context, taskID string) (time.Time, error) {
+	task, ok := d.tasks.Get(taskID)
+	if !ok {
+		return time.Time{}, fmt.Errorf("task %s: %w", taskID, ErrNotFound)
+	}
+	ctx = withTraceID(ctx, task.config.TraceID)
+	if task.Status.IsFinished() {
+		return time.Time{}, fmt.Errorf("%w: cannot renew lease of task %s with %s status", ErrRequest, task.ID, task.Status)
+	}
+	expiresAt, ok := d.leases.Renew(taskID)
+	if !ok {
+		return time.Time{}, fmt.Errorf("%w: task %s has no lease", ErrRequest, task.ID)
+	}
+	log.Debug(ctx, "lease renewed", "task", task.ID, "expires", expiresAt)
+	return expiresAt, nil
+}
+
+// watchLeases periodically terminates tasks with expired leases until ctx is done
+func (d *DockerRunner) watchLeases(ctx context.Context) {
+	ticker := time.NewTicker(leaseCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.terminateExpiredTasks(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// terminateExpiredTasks terminates tasks with expired leases. Removed and already terminated
+// tasks only drop their leases. If termination fails, the lease is kept, and termination
+// is retried on the next call
+func (d *DockerRunner) terminateExpiredTasks(ctx context.Context) {
+	for _, taskID := range d.leases.Expired() {
+		task, ok := d.tasks.Get(taskID)
+		if !ok || task.Status.IsFinished() {
+			d.leases.Delete(taskID)
+			continue
+		}
+		log.Info(ctx, "lease expired, terminating", "task", taskID)
+		if err := d.Terminate(ctx, taskID, nil, "LEASE_EXPIRED", "lease expired"); err != nil {
+			log.Error(ctx, "failed to terminate task with expired lease", "task", taskID, "err", err)
+			continue
+		}
+		d.leases.Delete(taskID)
+	}
+}
@@ -0,0 +1,190 @@
+package shim
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	now time.Time
+	mu  sync.Mutex
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestTaskLeases(t *testing.T) {
+	clock := newFakeClock()
+	leases := newTaskLeases(clock)
+	expiresAt := leases.Start("task", time.Minute)
+	assert.Equal(t, clock.Now().Add(time.Minute), expiresAt)
+	assert.Equal(t, []string{}, leases.Expired())
+
+	clock.Advance(50 * time.Second)
+	expiresAt, ok := leases.Renew("task")
+	assert.True(t, ok)
+	assert.Equal(t, clock.Now().Add(time.Minute), expiresAt)
+
+	clock.Advance(50 * time.Second)
+	assert.Equal(t, []string{}, leases.Expired())
+	clock.Advance(10 * time.Second)
+	assert.Equal(t, []string{"task"}, leases.Expired())
+
+	leases.Delete("task")
+	assert.Equal(t, []string{}, leases.Expired())
+	_, ok = leases.Renew("task")
+	assert.False(t, ok)
+}
+
+func TestDockerRunner_Lease_RenewalKeepsTaskAlive(t *testing.T) {
+	client := newFakeDockerClient()
+	runner, clock := newFakeDockerRunnerWithClock(t, client)
+	cfg := createTaskConfig(t)
+	cfg.LeaseDuration = 60
+	containerID := runTask(t, runner, cfg)
+	defer client.exitContainer(containerID, 0)
+
+	for i := 0; i < 3; i++ {
+		clock.Advance(50 * time.Second)
+		_, err := runner.Renew(context.Background(), cfg.ID)
+		require.NoError(t, err)
+		runner.terminateExpiredTasks(context.Background())
+	}
+	clock.Advance(59 * time.Second)
+	runner.terminateExpiredTasks(context.Background())
+
+	assert.Equal(t, TaskStatusRunning, runner.TaskInfo(cfg.ID).Status)
+	ctr, err := client.getContainer(containerID)
+	require.NoError(t, err)
+	assert.Equal(t, "60", ctr.config.Labels[LabelKeyLeaseDuration])
+}
+
+func TestDockerRunner_Lease_ExpiryTerminatesTask(t *testing.T) {
+	client := newFakeDockerClient()
+	runner, clock := newFakeDockerRunnerWithClock(t, client)
+	cfg := createTaskConfig(t)
+	cfg.LeaseDuration = 60
+	runTask(t, runner, cfg)
+
+	clock.Advance(60 * time.Second)
+	runner.terminateExpiredTasks(context.Background())
+
+	taskInfo := runner.TaskInfo(cfg.ID)
+	assert.Equal(t, TaskStatusTerminated, taskInfo.Status)
+	assert.Equal(t, "LEASE_EXPIRED", taskInfo.TerminationReason)
+	assert.Equal(t, "lease expired", taskInfo.TerminationMessage)
+	_, err := runner.Renew(context.Background(), cfg.ID)
+	assert.ErrorIs(t, err, ErrRequest)
+}
+
+func TestDockerRunner_Lease_ExpiryTerminatesPendingTask(t *testing.T) {
+	runner, clock := newFakeDockerRunnerWithClock(t, newFakeDockerClient())
+	cfg := createTaskConfig(t)
+	cfg.LeaseDuration = 60
+	require.NoError(t, runner.Submit(context.Background(), cfg))
+
+	clock.Advance(time.Hour)
+	runner.terminateExpiredTasks(context.Background())
+
+	taskInfo := runner.TaskInfo(cfg.ID)
+	assert.Equal(t, TaskStatusTerminated, taskInfo.Status)
+	assert.Equal(t, "LEASE_EXPIRED", taskInfo.TerminationReason)
+	assert.Equal(t, []string{}, runner.leases.Expired())
+}
+
+func TestDockerRunner_Lease_TerminationRetried(t *testing.T) {
+	client := newFakeDockerClient()
+	runner, clock := newFakeDockerRunnerWithClock(t, client)
+	cfg := createTaskConfig(t)
+	cfg.LeaseDuration = 60
+	runTask(t, runner, cfg)
+	client.injectErrors("ContainerStop", errors.New("daemon unavailable"))
+
+	clock.Advance(60 * time.Second)
+	runner.terminateExpiredTasks(context.Background())
+	assert.Equal(t, TaskStatusRunning, runner.TaskInfo(cfg.ID).Status)
+
+	runner.terminateExpiredTasks(context.Background())
+	assert.Equal(t, "LEASE_EXPIRED", runner.TaskInfo(cfg.ID).TerminationReason)
+}
+
+func TestDockerRunner_Lease_ExpiryRacesTerminate(t *testing.T) {
+	client := newFakeDockerClient()
+	client.stopGate = make(chan struct{})
+	runner, clock := newFakeDockerRunnerWithClock(t, client)
+	cfg := createTaskConfig(t)
+	cfg.LeaseDuration = 60
+	runTask(t, runner, cfg)
+
+	clock.Advance(60 * time.Second)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runner.terminateExpiredTasks(context.Background())
+	}()
+	// the lease watcher holds the task lock while the container is being stopped
+	require.Eventually(t, func() bool {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		return client.stopCount == 1
+	}, time.Second, time.Millisecond)
+	terminateErr := make(chan error, 1)
+	go func() {
+		terminateErr <- runner.Terminate(context.Background(), cfg.ID, nil, "TERMINATED_BY_SERVER", "")
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(client.stopGate)
+	wg.Wait()
+
+	require.NoError(t, <-terminateErr)
+	taskInfo := runner.TaskInfo(cfg.ID)
+	assert.Equal(t, TaskStatusTerminated, taskInfo.Status)
+	assert.Equal(t, "LEASE_EXPIRED", taskInfo.TerminationReason)
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	assert.Equal(t, 1, client.stopCount)
+}
+
+func TestDockerRunner_Lease_NoLease(t *testing.T) {
+	client := newFakeDockerClient()
+	runner, clock := newFakeDockerRunnerWithClock(t, client)
+	cfg := createTaskConfig(t)
+	containerID := runTask(t, runner, cfg)
+	defer client.exitContainer(containerID, 0)
+
+	_, err := runner.Renew(context.Background(), cfg.ID)
+	assert.ErrorIs(t, err, ErrRequest)
+	_, err = runner.Renew(context.Background(), "unknown")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	clock.Advance(24 * time.Hour)
+	runner.terminateExpiredTasks(context.Background())
+	assert.Equal(t, TaskStatusRunning, runner.TaskInfo(cfg.ID).Status)
+}
+
+func newFakeDockerRunnerWithClock(t *testing.T, client *fakeDockerClient) (*DockerRunner, *fakeClock) {
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	clock := newFakeClock()
+	runner.leases.clock = clock
+	return runner, clock
+}
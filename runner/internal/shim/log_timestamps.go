@@ -0,0 +1,54 @@
+package shim
+
+import (
+	"bytes"
+	"io"
+)
+
+// The format of timestamps prepended to log lines, see TaskConfig.LogTimestamps.
+// Fixed-width RFC3339 with nanoseconds in UTC, the same as Docker uses for `docker logs -t`,
+// so that timestamps are sortable as strings
+const logTimestampLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// timestampWriter prepends the current time to each line written through it.
+// A line starts at the beginning of the stream and after each '\n', the timestamp is written
+// once the first byte of the line arrives, that is, a line split across multiple writes
+// gets a single timestamp, and nothing is inserted anywhere else, output without newlines
+// (e.g., binary data or progress bars redrawn with '\r') is prefixed only once
+type timestampWriter struct {
+	w     io.Writer
+	clock clock
+	// true if the next byte starts a new line
+	lineStart bool
+	buf       bytes.Buffer
+}
+
+func newTimestampWriter(w io.Writer, clock clock) *timestampWriter {
+	return &timestampWriter{w: w, clock: clock, lineStart: true}
+}
+
+// Write returns len(p), not the number of bytes written to the underlying writer, including
+// timestamps, as io.Writer requires
+func (tw *timestampWriter) Write(p []byte) (int, error) {
+	tw.buf.Reset()
+	rest := p
+	for len(rest) > 0 {
+		if tw.lineStart {
+			tw.buf.WriteString(tw.clock.Now().UTC().Format(logTimestampLayout))
+			tw.buf.WriteByte(' ')
+			tw.lineStart = false
+		}
+		i := bytes.IndexByte(rest, '\n')
+		if i < 0 {
+			tw.buf.Write(rest)
+			break
+		}
+		tw.buf.Write(rest[:i+1])
+		rest = rest[i+1:]
+		tw.lineStart = true
+	}
+	if _, err := tw.w.Write(tw.buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,53 @@
+package shim
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimestampWriter(t *testing.T) {
+	clock := newFakeClock()
+	var buf bytes.Buffer
+	w := newTimestampWriter(&buf, clock)
+	write := func(s string) {
+		n, err := w.Write([]byte(s))
+		require.NoError(t, err)
+		assert.Equal(t, len(s), n)
+	}
+
+	write("first\nsec")
+	clock.Advance(time.Second)
+	// a line split across writes gets a single timestamp of its first byte
+	write("ond\r\n")
+	clock.Advance(1500 * time.Microsecond)
+	write("third\n\nfifth\n")
+	expected := "" +
+		"2024-01-01T00:00:00.000000000Z first\n" +
+		"2024-01-01T00:00:00.000000000Z second\r\n" +
+		"2024-01-01T00:00:01.001500000Z third\n" +
+		"2024-01-01T00:00:01.001500000Z \n" +
+		"2024-01-01T00:00:01.001500000Z fifth\n"
+	assert.Equal(t, expected, buf.String())
+
+	// no timestamp is written until the next line starts
+	clock.Advance(time.Second)
+	write("")
+	assert.Equal(t, expected, buf.String())
+	write("sixth")
+	assert.Contains(t, buf.String(), "fifth\n2024-01-01T00:00:02.001500000Z sixth")
+}
+
+func TestTimestampWriter_NoNewlines(t *testing.T) {
+	var buf bytes.Buffer
+	w := newTimestampWriter(&buf, newFakeClock())
+	// e.g., a progress bar redrawn in place or binary data
+	_, err := w.Write([]byte("10%\r50%\r"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte{0x00, 0xff, '\r', 0x1b})
+	require.NoError(t, err)
+	assert.Equal(t, append([]byte("2024-01-01T00:00:00.000000000Z 10%\r50%\r"), 0x00, 0xff, '\r', 0x1b), buf.Bytes())
+}
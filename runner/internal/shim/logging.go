@@ -0,0 +1,47 @@
+package shim
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// Log drivers built into Docker Engine on Linux, logging plugins are not supported
+var supportedLogDrivers = []string{
+	"json-file", "local", "journald", "syslog", "fluentd", "gelf", "awslogs", "splunk", "gcplogs", "none",
+}
+
+// Log drivers that support reading logs back (`docker logs`) without dual logging,
+// which may be disabled on the host. The shim reads container logs to report why
+// the container exited with error
+var readableLogDrivers = []string{"json-file", "local", "journald"}
+
+// getLogConfig validates the task's log driver and options and returns HostConfig.LogConfig.
+// If the driver is not set, the daemon default is used, and options must not be set either
+func getLogConfig(cfg TaskConfig) (container.LogConfig, error) {
+	if cfg.LogDriver == "" {
+		if len(cfg.LogOptions) > 0 {
+			return container.LogConfig{}, fmt.Errorf("%w: log_options are set, but log_driver is not", ErrInvalidConfig)
+		}
+		return container.LogConfig{}, nil
+	}
+	if !slices.Contains(supportedLogDrivers, cfg.LogDriver) {
+		return container.LogConfig{}, fmt.Errorf("%w: unsupported log driver %q, must be one of %v", ErrInvalidConfig, cfg.LogDriver, supportedLogDrivers)
+	}
+	return container.LogConfig{Type: cfg.LogDriver, Config: cfg.LogOptions}, nil
+}
+
+// getEffectiveLogDriver returns the task's log driver or the daemon default if not set
+func (d *DockerRunner) getEffectiveLogDriver(cfg TaskConfig) string {
+	if cfg.LogDriver != "" {
+		return cfg.LogDriver
+	}
+	return d.dockerInfo.LoggingDriver
+}
+
+// isLogDriverReadable reports whether container logs can be read back with the driver.
+// Unknown (empty) driver is assumed to be readable
+func isLogDriverReadable(driver string) bool {
+	return driver == "" || slices.Contains(readableLogDrivers, driver)
+}
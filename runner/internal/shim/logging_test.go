@@ -0,0 +1,89 @@
+package shim
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetLogConfig(t *testing.T) {
+	logConfig, err := getLogConfig(TaskConfig{})
+	assert.NoError(t, err)
+	assert.Equal(t, container.LogConfig{}, logConfig)
+
+	options := map[string]string{"gelf-address": "udp://127.0.0.1:12201"}
+	logConfig, err = getLogConfig(TaskConfig{LogDriver: "gelf", LogOptions: options})
+	assert.NoError(t, err)
+	assert.Equal(t, container.LogConfig{Type: "gelf", Config: options}, logConfig)
+}
+
+func TestGetLogConfig_Errors(t *testing.T) {
+	_, err := getLogConfig(TaskConfig{LogDriver: "loki"})
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.ErrorContains(t, err, "unsupported log driver \"loki\"")
+
+	_, err = getLogConfig(TaskConfig{LogOptions: map[string]string{"max-size": "10m"}})
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.ErrorContains(t, err, "log_driver is not")
+}
+
+func TestDockerRunner_LogConfig(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.LogDriver = "json-file"
+	cfg.LogOptions = map[string]string{"max-size": "10m", "max-file": "3"}
+	containerID := runTask(t, runner, cfg)
+	defer client.exitContainer(containerID, 0)
+
+	ctr, err := client.getContainer(containerID)
+	require.NoError(t, err)
+	assert.Equal(t, container.LogConfig{Type: "json-file", Config: cfg.LogOptions}, ctr.hostConfig.LogConfig)
+}
+
+func TestDockerRunner_LogConfig_SubmitRejected(t *testing.T) {
+	runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.LogDriver = "loki"
+
+	assert.ErrorIs(t, runner.Submit(context.Background(), cfg), ErrInvalidConfig)
+	assert.Equal(t, "", runner.TaskInfo(cfg.ID).ID)
+}
+
+func TestDockerRunner_ExitLogs_LogDriverCompatibility(t *testing.T) {
+	testCases := []struct {
+		name          string
+		daemonDriver  string
+		taskDriver    string
+		expectedCount int
+	}{
+		{"json-file", "", "json-file", 1},
+		{"journald", "", "journald", 1},
+		{"gelf", "", "gelf", 0},
+		{"daemon default readable", "local", "", 1},
+		{"daemon default not readable", "fluentd", "", 0},
+		{"task overrides daemon default", "fluentd", "json-file", 1},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newFakeDockerClient()
+			client.info.LoggingDriver = tc.daemonDriver
+			runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+			cfg := createTaskConfig(t)
+			cfg.LogDriver = tc.taskDriver
+
+			require.NoError(t, runner.Submit(context.Background(), cfg))
+			runErr := make(chan error)
+			go func() { runErr <- runner.Run(context.Background(), cfg.ID) }()
+			waitTaskStatus(t, runner, cfg.ID, TaskStatusRunning)
+			client.exitContainer(runner.TaskInfo(cfg.ID).ContainerID, 1)
+
+			assert.Error(t, <-runErr)
+			assert.Equal(t, "CONTAINER_EXITED_WITH_ERROR", runner.TaskInfo(cfg.ID).TerminationReason)
+			assert.Equal(t, tc.expectedCount, client.logsCount)
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package shim
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/dstackai/dstack/runner/internal/log"
+)
+
+// Log search limits. The logs are streamed, only the matches with their context are kept
+// in memory, and the result size is capped, so that searching gigabytes of logs is safe
+const (
+	defaultLogSearchLimit = 100
+	maxLogSearchLimit     = 1000
+	maxLogSearchContext   = 20
+	// Longer lines are truncated
+	maxLogLineSize = 16 * 1024
+	// Once exceeded, the result is cut short as if the limit was reached
+	maxLogSearchResultSize = 4 * 1024 * 1024
+)
+
+type LogSearchQuery struct {
+	Pattern string
+	// Treat the pattern as a regular expression (RE2 syntax) instead of a substring
+	Regex bool
+	// The number of lines before and after each match to include
+	Context int
+	// Only matches after this line number are returned, used for pagination
+	AfterLine int
+	// The maximum number of matches, 0 = defaultLogSearchLimit
+	Limit int
+}
+
+type LogMatch struct {
+	Line   int      `json:"line"` // 1-based line number
+	Text   string   `json:"text"`
+	Before []string `json:"before"`
+	After  []string `json:"after"`
+}
+
+type LogSearchResult struct {
+	Matches []LogMatch
+	// If there are more matches, the line number of the last returned match
+	// to be passed as AfterLine to get the next page, otherwise 0
+	NextAfterLine int
+}
+
+// SearchLogs scans the task container logs (stdout and stderr merged) and returns lines matching
+// the query. The logs are available as long as the container exists, that is, until the task is removed
+func (d *DockerRunner) SearchLogs(ctx context.Context, taskID string, query LogSearchQuery) (LogSearchResult, error) {
+	task, ok := d.tasks.Get(taskID)
+	if !ok {
+		return LogSearchResult{}, fmt.Errorf("task %s: %w", taskID, ErrNotFound)
+	}
+	match, err := getLogMatcher(query)
+	if err != nil {
+		return LogSearchResult{}, err
+	}
+	if task.containerID == "" {
+		return LogSearchResult{}, fmt.Errorf("%w: task %s has no container", ErrRequest, task.ID)
+	}
+	if logDriver := d.getEffectiveLogDriver(task.config); !isLogDriverReadable(logDriver) {
+		return LogSearchResult{}, fmt.Errorf("%w: task %s logs cannot be read back with %s log driver", ErrRequest, task.ID, logDriver)
+	}
+	muxedReader, err := d.client.ContainerLogs(ctx, task.containerID, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		// Docker prepends the time it captured each line, in the same format as logTimestampLayout
+		Timestamps: task.config.LogTimestamps,
+	})
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return LogSearchResult{}, fmt.Errorf("%w: task %s container is gone", ErrRequest, task.ID)
+		}
+		return LogSearchResult{}, fmt.Errorf("%w: failed to get container logs: %w", ErrInternal, err)
+	}
+	defer muxedReader.Close()
+
+	var reader io.Reader = muxedReader
+	if !task.config.TTY {
+		pipeReader, pipeWriter := io.Pipe()
+		// Unblocks the demuxing goroutine if the search stops before the end of the logs
+		defer pipeReader.Close()
+		go func() {
+			_, err := stdcopy.StdCopy(pipeWriter, pipeWriter, muxedReader)
+			pipeWriter.CloseWithError(err)
+		}()
+		reader = pipeReader
+	}
+	result, err := searchLogs(reader, match, query)
+	if err != nil {
+		return LogSearchResult{}, fmt.Errorf("%w: failed to read container logs: %w", ErrInternal, err)
+	}
+	log.Debug(ctx, "searched logs", "task", task.ID, "matches", len(result.Matches))
+	return result, nil
+}
+
+// getLogMatcher validates the query and returns the line matching function
+func getLogMatcher(query LogSearchQuery) (func(string) bool, error) {
+	if query.Pattern == "" {
+		return nil, fmt.Errorf("%w: empty search pattern", ErrInvalidConfig)
+	}
+	if query.Context < 0 || query.Context > maxLogSearchContext {
+		return nil, fmt.Errorf("%w: context must be in 0..%d range, got %d", ErrInvalidConfig, maxLogSearchContext, query.Context)
+	}
+	if query.Limit < 0 || query.Limit > maxLogSearchLimit {
+		return nil, fmt.Errorf("%w: limit must be in 0..%d range, got %d", ErrInvalidConfig, maxLogSearchLimit, query.Limit)
+	}
+	if query.AfterLine < 0 {
+		return nil, fmt.Errorf("%w: negative line number %d", ErrInvalidConfig, query.AfterLine)
+	}
+	if !query.Regex {
+		return func(line string) bool { return strings.Contains(line, query.Pattern) }, nil
+	}
+	re, err := regexp.Compile(query.Pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid regex: %w", ErrInvalidConfig, err)
+	}
+	return re.MatchString, nil
+}
+
+// searchLogs reads the demuxed logs line by line. Each match has its own context window,
+// that is, context lines of close matches overlap. To tell if there is the next page,
+// the search stops at the first match beyond the limit, after the context of the last
+// returned match is complete
+func searchLogs(reader io.Reader, match func(string) bool, query LogSearchQuery) (LogSearchResult, error) {
+	limit := query.Limit
+	if limit == 0 {
+		limit = defaultLogSearchLimit
+	}
+	var result LogSearchResult
+	// Indices of matches waiting for the after context
+	var pending []int
+	before := make([]string, 0, query.Context)
+	size := 0
+	bufReader := bufio.NewReader(reader)
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := readLogLine(bufReader)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return LogSearchResult{}, err
+		}
+		for _, i := range pending {
+			result.Matches[i].After = append(result.Matches[i].After, line)
+			size += len(line)
+		}
+		// Matches are ordered, the oldest ones are complete first
+		for len(pending) > 0 && len(result.Matches[pending[0]].After) == query.Context {
+			pending = pending[1:]
+		}
+		if result.NextAfterLine == 0 && lineNumber > query.AfterLine && match(line) {
+			if len(result.Matches) >= limit || (len(result.Matches) > 0 && size >= maxLogSearchResultSize) {
+				result.NextAfterLine = result.Matches[len(result.Matches)-1].Line
+			} else {
+				result.Matches = append(result.Matches, LogMatch{
+					Line:   lineNumber,
+					Text:   line,
+					Before: slices.Clone(before),
+					After:  []string{},
+				})
+				size += len(line)
+				for _, l := range before {
+					size += len(l)
+				}
+				if query.Context > 0 {
+					pending = append(pending, len(result.Matches)-1)
+				}
+			}
+		}
+		if result.NextAfterLine != 0 && len(pending) == 0 {
+			break
+		}
+		if query.Context > 0 {
+			if len(before) == query.Context {
+				before = append(before[:0], before[1:]...)
+			}
+			before = append(before, line)
+		}
+	}
+	if result.Matches == nil {
+		result.Matches = []LogMatch{}
+	}
+	return result, nil
+}
+
+// readLogLine reads the next line without the line terminator, the line is truncated
+// to maxLogLineSize. The last line may be unterminated
+func readLogLine(reader *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if n := maxLogLineSize - len(line); n > 0 {
+			line = append(line, chunk[:min(len(chunk), n)]...)
+		}
+		switch {
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		case errors.Is(err, io.EOF) && len(line) > 0:
+		case err != nil:
+			return "", err
+		}
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		return string(line), nil
+	}
+}
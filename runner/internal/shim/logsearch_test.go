@@ -0,0 +1,187 @@
+package shim
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testLogs = `starting
+epoch 1 loss=0.9
+epoch 2 loss=0.5
+WARNING: lr too high
+epoch 3 loss=nan
+Traceback (most recent call last):
+RuntimeError: CUDA error: out of memory
+done
+`
+
+func searchTestLogs(t *testing.T, query LogSearchQuery) LogSearchResult {
+	t.Helper()
+	match, err := getLogMatcher(query)
+	require.NoError(t, err)
+	result, err := searchLogs(strings.NewReader(testLogs), match, query)
+	require.NoError(t, err)
+	return result
+}
+
+func TestSearchLogs_Literal(t *testing.T) {
+	result := searchTestLogs(t, LogSearchQuery{Pattern: "Error", Context: 1})
+	assert.Equal(t, LogSearchResult{Matches: []LogMatch{{
+		Line:   7,
+		Text:   "RuntimeError: CUDA error: out of memory",
+		Before: []string{"Traceback (most recent call last):"},
+		After:  []string{"done"},
+	}}}, result)
+
+	// the pattern is case-sensitive, regex=false treats metacharacters literally
+	assert.Empty(t, searchTestLogs(t, LogSearchQuery{Pattern: "warning"}).Matches)
+	assert.Empty(t, searchTestLogs(t, LogSearchQuery{Pattern: "loss=.*"}).Matches)
+}
+
+func TestSearchLogs_Regex(t *testing.T) {
+	result := searchTestLogs(t, LogSearchQuery{Pattern: `loss=(nan|0\.9)`, Regex: true, Context: 2})
+	assert.Equal(t, []LogMatch{
+		{
+			Line:   2,
+			Text:   "epoch 1 loss=0.9",
+			Before: []string{"starting"},
+			After:  []string{"epoch 2 loss=0.5", "WARNING: lr too high"},
+		},
+		{
+			Line:   5,
+			Text:   "epoch 3 loss=nan",
+			Before: []string{"epoch 2 loss=0.5", "WARNING: lr too high"},
+			After:  []string{"Traceback (most recent call last):", "RuntimeError: CUDA error: out of memory"},
+		},
+	}, result.Matches)
+	assert.Equal(t, 0, result.NextAfterLine)
+}
+
+func TestSearchLogs_ContextAtEnd(t *testing.T) {
+	result := searchTestLogs(t, LogSearchQuery{Pattern: "done", Context: 3})
+	require.Len(t, result.Matches, 1)
+	assert.Equal(t, []string{"epoch 3 loss=nan", "Traceback (most recent call last):", "RuntimeError: CUDA error: out of memory"}, result.Matches[0].Before)
+	assert.Equal(t, []string{}, result.Matches[0].After)
+}
+
+func TestSearchLogs_Pagination(t *testing.T) {
+	query := LogSearchQuery{Pattern: "epoch", Context: 1, Limit: 2}
+	result := searchTestLogs(t, query)
+	require.Len(t, result.Matches, 2)
+	assert.Equal(t, 2, result.Matches[0].Line)
+	assert.Equal(t, 3, result.Matches[1].Line)
+	assert.Equal(t, []string{"WARNING: lr too high"}, result.Matches[1].After)
+	assert.Equal(t, 3, result.NextAfterLine)
+
+	query.AfterLine = result.NextAfterLine
+	result = searchTestLogs(t, query)
+	assert.Equal(t, []LogMatch{{
+		Line:   5,
+		Text:   "epoch 3 loss=nan",
+		Before: []string{"WARNING: lr too high"},
+		After:  []string{"Traceback (most recent call last):"},
+	}}, result.Matches)
+	assert.Equal(t, 0, result.NextAfterLine)
+}
+
+func TestGetLogMatcher_Errors(t *testing.T) {
+	testCases := []LogSearchQuery{
+		{},
+		{Pattern: "(", Regex: true},
+		{Pattern: "x", Context: -1},
+		{Pattern: "x", Context: maxLogSearchContext + 1},
+		{Pattern: "x", Limit: maxLogSearchLimit + 1},
+		{Pattern: "x", AfterLine: -1},
+	}
+	for _, query := range testCases {
+		_, err := getLogMatcher(query)
+		assert.ErrorIs(t, err, ErrInvalidConfig, query)
+	}
+}
+
+func TestReadLogLine(t *testing.T) {
+	longLine := strings.Repeat("a", maxLogLineSize+100)
+	reader := bufio.NewReader(strings.NewReader("crlf\r\n" + longLine + "\nlast"))
+
+	line, err := readLogLine(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "crlf", line)
+	line, err = readLogLine(reader)
+	require.NoError(t, err)
+	assert.Equal(t, longLine[:maxLogLineSize], line)
+	line, err = readLogLine(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "last", line)
+	_, err = readLogLine(reader)
+	assert.Error(t, err)
+}
+
+func TestDockerRunner_SearchLogs(t *testing.T) {
+	for _, tty := range []bool{false, true} {
+		client := newFakeDockerClient()
+		runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+		cfg := createTaskConfig(t)
+		cfg.TTY = tty
+		containerID := runTask(t, runner, cfg)
+		client.mu.Lock()
+		for i := 1; i <= 1000; i++ {
+			client.containers[containerID].logs = append(client.containers[containerID].logs, fmt.Sprintf("step %d", i))
+		}
+		client.mu.Unlock()
+		client.exitContainer(containerID, 0)
+		waitTaskStatus(t, runner, cfg.ID, TaskStatusTerminated)
+
+		result, err := runner.SearchLogs(context.Background(), cfg.ID, LogSearchQuery{Pattern: `^step \d*7$`, Regex: true, Context: 1, Limit: 10})
+		require.NoError(t, err, tty)
+		require.Len(t, result.Matches, 10, tty)
+		assert.Equal(t, LogMatch{Line: 7, Text: "step 7", Before: []string{"step 6"}, After: []string{"step 8"}}, result.Matches[0], tty)
+		assert.Equal(t, 97, result.NextAfterLine, tty)
+	}
+}
+
+func TestDockerRunner_SearchLogs_Timestamps(t *testing.T) {
+	for _, timestamps := range []bool{false, true} {
+		client := newFakeDockerClient()
+		runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+		cfg := createTaskConfig(t)
+		cfg.LogTimestamps = timestamps
+		containerID := runTask(t, runner, cfg)
+		client.mu.Lock()
+		ctr := client.containers[containerID]
+		ctr.logs = []string{"step 1", "step 2"}
+		startedAt := ctr.startedAt.UTC().Format(logTimestampLayout)
+		client.mu.Unlock()
+		client.exitContainer(containerID, 0)
+		waitTaskStatus(t, runner, cfg.ID, TaskStatusTerminated)
+
+		result, err := runner.SearchLogs(context.Background(), cfg.ID, LogSearchQuery{Pattern: "step 2", Context: 1})
+		require.NoError(t, err, timestamps)
+		require.Len(t, result.Matches, 1, timestamps)
+		if timestamps {
+			assert.Equal(t, LogMatch{Line: 2, Text: startedAt + " step 2", Before: []string{startedAt + " step 1"}, After: []string{}}, result.Matches[0])
+		} else {
+			assert.Equal(t, LogMatch{Line: 2, Text: "step 2", Before: []string{"step 1"}, After: []string{}}, result.Matches[0])
+		}
+	}
+}
+
+func TestDockerRunner_SearchLogs_Errors(t *testing.T) {
+	runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{})
+	_, err := runner.SearchLogs(context.Background(), "unknown", LogSearchQuery{Pattern: "x"})
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// no container yet
+	cfg := createTaskConfig(t)
+	require.NoError(t, runner.Submit(context.Background(), cfg))
+	_, err = runner.SearchLogs(context.Background(), cfg.ID, LogSearchQuery{Pattern: "x"})
+	assert.ErrorIs(t, err, ErrRequest)
+
+	_, err = runner.SearchLogs(context.Background(), cfg.ID, LogSearchQuery{})
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+}
@@ -0,0 +1,32 @@
+package shim
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Prometheus metrics exposed via the /metrics endpoint
+var (
+	taskQueueSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "shim_task_queue_seconds",
+		Help: "Time tasks spent in the queue waiting for a free slot, from submit to start",
+		// 100ms .. ~27min
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 15),
+	})
+	taskQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "shim_task_queue_depth",
+		Help: "Number of tasks currently waiting in the queue",
+	})
+	imagePullQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "shim_image_pull_queue_depth",
+		Help: "Number of image pulls currently waiting for a free slot",
+	})
+	tasksFinished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "shim_tasks_finished_total",
+		Help: "Number of tasks that reached a final status, terminated or failed",
+	}, []string{"status"})
+	dockerCircuitOpen = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "shim_docker_circuit_open",
+		Help: "1 if new tasks are rejected due to Docker daemon failures (the circuit breaker is open or half-open), 0 otherwise",
+	})
+)
@@ -1,6 +1,8 @@
 package shim
 
 import (
+	"time"
+
 	"github.com/docker/docker/api/types/mount"
 )
 
@@ -11,13 +13,50 @@ type DockerParameters interface {
 	DockerPorts() []int
 	MakeRunnerDir(name string) (string, error)
 	DockerPJRTDevice() string
+	DockerAllowUnconfined() []string
+	DockerAllowDockerSocket() []string
+	DockerContainerGoneGracePeriod() time.Duration
+	DockerRegistryMirrors() []string
+	DockerRegistryMirrorFallback() bool
+	DockerMaxConcurrentPulls() int
+	DockerImageSignaturePolicy() string
+	DockerCircuitBreakerThreshold() int
+	DockerCircuitBreakerCooldown() time.Duration
+	DockerEndpoint() DockerEndpoint
+	ShimMaxConcurrentTasks() int
+	ShimContainerNameHashLength() int
+	ShimAuditLog() AuditLogConfig
+	ShimAdmissionWebhook() AdmissionWebhookConfig
+	ShimStateDir() string
+	ShimCoreDumpDir() string
+	ShimGPUDrain() GPUDrainConfig
+	ShimCredentialProvider() CredentialProviderConfig
+	ShimTelemetry() TelemetryConfig
+	ShimPreflight() PreflightConfig
 }
 
 type CLIArgs struct {
 	Shim struct {
-		HTTPPort int
-		HomeDir  string
-		LogLevel int
+		HTTPPort           int
+		HomeDir            string
+		LogLevel           int
+		MaxConcurrentTasks int
+		ShutdownTimeout    time.Duration // the deadline for stopping tasks on shim shutdown
+		// hex characters of the container name suffix, 0 = the default, see generateUniqueName()
+		ContainerNameHashLength int
+		AuditLog                AuditLogConfig
+		AdmissionWebhook        AdmissionWebhookConfig
+		CoreDumpDir             string // host dir for core dumps of tasks, empty = not captured
+		GPUDrain                GPUDrainConfig
+		CredentialProvider      CredentialProviderConfig
+		Telemetry               TelemetryConfig
+		// requests per second to submit, replace, update tasks and reserve GPUs, 0 = unlimited
+		APIRateLimit float64
+		APIRateBurst int
+		// free disk space required on startup, MiB, 0 = not checked, see PreflightConfig
+		MinFreeDiskMB uint64
+		// the token required by the exec endpoint, empty = exec is disabled
+		ExecToken string
 	}
 
 	Runner struct {
@@ -32,6 +71,16 @@ type CLIArgs struct {
 		ConcatinatedPublicSSHKeys string
 		Privileged                bool
 		PJRTDevice                string
+		AllowUnconfined           []string
+		AllowDockerSocket         []string // docker socket modes (ro, rw) tasks are allowed to request
+		ContainerGoneGracePeriod  time.Duration
+		RegistryMirrors           []string // registry=mirror rules
+		RegistryMirrorFallback    bool
+		MaxConcurrentPulls        int
+		ImageSignaturePolicy      string // path to the SignaturePolicy JSON file, empty = not verified
+		CircuitBreakerThreshold   int    // consecutive daemon failures, 0 = disabled
+		CircuitBreakerCooldown    time.Duration
+		Endpoint                  DockerEndpoint
 	}
 }
 
@@ -52,6 +101,14 @@ type InstanceMountPoint struct {
 	Path         string `json:"path"`
 }
 
+// DockerVolumeMount is a Docker named volume, created by the shim if it doesn't exist
+type DockerVolumeMount struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	// If false, the volume is removed with the task (if it was created for the task)
+	Persistent bool `json:"persistent"`
+}
+
 type VolumeInfo struct {
 	Backend    string `json:"backend"`
 	Name       string `json:"name"`
@@ -81,10 +138,137 @@ type TaskConfig struct {
 	Volumes          []VolumeInfo         `json:"volumes"`
 	VolumeMounts     []VolumeMountPoint   `json:"volume_mounts"`
 	InstanceMounts   []InstanceMountPoint `json:"instance_mounts"`
+	DockerVolumes    []DockerVolumeMount  `json:"docker_volumes"`
 	HostSshUser      string               `json:"host_ssh_user"`
 	HostSshKeys      []string             `json:"host_ssh_keys"`
 	// TODO: submit keys to runner, not to shim
 	ContainerSshKeys []string `json:"container_ssh_keys"`
+	// "unconfined" or an absolute path to a JSON profile on the host
+	SeccompProfile string `json:"seccomp_profile"`
+	// "unconfined" or a name of a profile loaded into the kernel
+	AppArmorProfile string `json:"apparmor_profile"`
+	// Require usable RDMA (InfiniBand, RoCE, EFA) on the host, the task is rejected otherwise.
+	// RDMA devices are mounted into the container anyway, if present, see RDMAInfo
+	RDMA bool `json:"rdma"`
+	// A share of each allocated GPU reserved for the task, 0.0 = exclusive use (default),
+	// (0.0, 1.0] = the GPU may be shared with other fractional tasks as long as the sum
+	// of fractions does not exceed 1.0. The limit itself is enforced by frameworks
+	// via environment variables, that is, on a best-effort basis
+	GPUMemoryFraction float64 `json:"gpu_memory_fraction"`
+	// NVIDIA MPS client limits of the task sharing GPUs, applied to each allocated GPU:
+	// active thread percentage, 1..100, and pinned device memory, MiB; 0 = no limit.
+	// Require GPUMemoryFraction. The sums of limits of tasks sharing a GPU may not exceed
+	// 100% and the GPU memory, tasks without limits are not counted. See getMPSEnv()
+	MPSThreadPercentage uint `json:"mps_thread_percentage"`
+	MPSMemoryLimitMB    uint `json:"mps_memory_limit_mb"`
+	// An ID of the GPU reservation to consume, see DockerRunner.ReserveGpus(). The task gets
+	// the reserved GPUs, GPU must be equal to the number of reserved GPUs
+	GPUReservation string `json:"gpu_reservation"`
+	// oom_score_adj of the container init process, inherited by all child processes,
+	// -1000..1000; the higher, the more likely the task is killed under memory pressure,
+	// -1000 disables the OOM killer for the task. 0 = the kernel default
+	OOMScoreAdj int `json:"oom_score_adj"`
+	// Seconds to wait after SIGTERM before killing the container on termination,
+	// 0 = the Docker default (10 seconds). Can be overridden at termination time
+	StopTimeout uint `json:"stop_timeout"`
+	// Signals sent in order on termination, each followed by its wait for the container
+	// to exit, the container is killed after the last step. Empty = stop with the image
+	// stop signal and kill after StopTimeout
+	StopSignals []StopSignal `json:"stop_signals"`
+	// Allocate a pseudo-TTY and keep stdin open, required for interactive attach
+	TTY bool `json:"tty"`
+	// Docker log driver, e.g., json-file, journald, gelf; empty = the daemon default
+	LogDriver  string            `json:"log_driver"`
+	LogOptions map[string]string `json:"log_options"`
+	// Prepend the capture time in RFC3339 format with nanoseconds (UTC) to each line
+	// of the output streamed via attach and of the logs read back via log search.
+	// Lines are delimited by '\n' only, output without newlines is not modified
+	LogTimestamps bool `json:"log_timestamps"`
+	// Image platform in the form of os/arch[/variant], e.g., linux/amd64, for multi-arch images;
+	// empty = the host platform
+	Platform string `json:"platform"`
+	// When the image is pulled: always, if_not_present, or never; empty = if the image is not
+	// present locally or its tag is latest
+	PullPolicy PullPolicy `json:"pull_policy"`
+	// Seconds the task may run without a lease renewal (see DockerRunner.Renew()), after that
+	// the task is terminated with LEASE_EXPIRED reason; 0 = no lease, run indefinitely
+	LeaseDuration uint `json:"lease_duration"`
+	// Seconds the task may stay pending, i.e., waiting for dependencies or a free slot,
+	// counting from submission; after that it's terminated with PENDING_TIMEOUT reason.
+	// 0 = wait indefinitely
+	PendingTimeout uint `json:"pending_timeout"`
+	// Mount the host Docker socket, "ro" or "rw"; empty = don't mount. Must be allowed by the operator.
+	// NB: "ro" only protects the socket file, it does not restrict the Docker API
+	DockerSocket string `json:"docker_socket"`
+	// NVIDIA driver capabilities (compute, compat32, graphics, utility, video, display, or all),
+	// exposed as NVIDIA_DRIVER_CAPABILITIES; empty = compute, utility. Ignored for non-NVIDIA GPUs
+	GPUCapabilities []string `json:"gpu_capabilities"`
+	// The cgroup under which the container cgroup is created, for accounting by external
+	// resource managers (Slurm, kubelet); empty = the daemon default. The format depends on
+	// the daemon cgroup driver: a slice name for systemd (slurm.slice), a path for cgroupfs
+	// (/slurm/job_42). With cgroup v1, the path is created in each controller hierarchy
+	// (/sys/fs/cgroup/<controller>/<path>), with cgroup v2 in the unified one (/sys/fs/cgroup/<path>)
+	CgroupParent string `json:"cgroup_parent"`
+	// CPUs (e.g., 0-3,8) and NUMA memory nodes (e.g., 0) the container is pinned to, in the cpuset
+	// list format; empty = no pinning. Must be online on the host, but are not reserved:
+	// tasks with overlapping cpusets share the CPUs
+	CPUSetCPUs string `json:"cpuset_cpus"`
+	CPUSetMems string `json:"cpuset_mems"`
+	// IDs of tasks that must finish successfully (DONE_BY_RUNNER) before the task starts,
+	// the task stays pending until then. If any of them fails or is removed before the task
+	// starts, the task fails with DEPENDENCY_FAILED reason
+	DependsOn []string `json:"depends_on"`
+	// "host" = don't remap even if the daemon remaps user namespaces, "remap" = require remapping
+	// (userns-remap daemon option), incompatible with privileged, host network, and docker_socket;
+	// empty = the daemon default
+	UsernsMode string `json:"userns_mode"`
+	// Namespaced sysctls to set in the container, e.g., net.core.somaxconn or kernel.shmmax.
+	// Network ones are incompatible with host network, IPC ones with host IPC (AMD GPU tasks)
+	Sysctls map[string]string `json:"sysctls"`
+	// Environment variables of the container. Values may reference host facts as ${NAME},
+	// see envTemplateVars; $${ is a literal ${
+	Env map[string]string `json:"env"`
+	// Small files written into the container before it starts, e.g., configs or credentials
+	Files []InlineFile `json:"files"`
+	// What to do with the task on shim shutdown: stop gracefully, kill, or leave running;
+	// empty = keep
+	ShutdownBehavior ShutdownBehavior `json:"shutdown_behavior"`
+	// Arbitrary metadata set by the server, not interpreted by the shim, see TaskUpdate
+	Annotations map[string]string `json:"annotations"`
+	// Docker labels of the container, e.g., for monitoring or cost allocation tools.
+	// Keys under LabelKeyPrefix are reserved for the shim and rejected
+	Labels map[string]string `json:"labels"`
+	// Recreate the container if the task is interrupted by the host reboot, that is, the task
+	// was running when the host went down, and its container is gone or not running after
+	// the reboot. The config is persisted in the shim state dir until the task is finished
+	// on its own, stopped (except on the shim shutdown), or removed
+	RestartOnReboot bool `json:"restart_on_reboot"`
+	// Groups tasks of the same run, e.g., replicas or nodes of a multi-node job, so that
+	// their logs can be streamed together, see RunLogs(); empty = not grouped
+	RunID string `json:"run_id"`
+	// Capture core dumps of crashed processes, see CoreDumpContainerDir. Requires the shim
+	// core dump dir to be configured
+	CoreDumps bool `json:"core_dumps"`
+	// Correlates shim logs, audit records, and application logs of the task, passed to
+	// the container as TraceIDEnvVar; empty = generated by the shim
+	TraceID string `json:"trace_id"`
+	// Temporary credentials fetched from the credential provider before the container starts,
+	// and refreshed while the container is running. nil = not requested
+	Credentials *TaskCredentials `json:"credentials"`
+	// Must pass before the task is considered started, the task fails with STARTUP_PROBE_FAILED
+	// if it never does. The image HEALTHCHECK, if any, takes effect afterwards. nil = no probe
+	StartupProbe *StartupProbe `json:"startup_probe"`
+	// Disk usage limits checked while the container is running, the task may be stopped
+	// with DISK_QUOTA_EXCEEDED once exceeded. nil = not monitored
+	DiskMonitor *DiskMonitor `json:"disk_monitor"`
+}
+
+// TaskUpdate lists mutable fields of a submitted task, nil fields are left unchanged
+type TaskUpdate struct {
+	// Replaces all annotations, an empty map removes them
+	Annotations map[string]string `json:"annotations"`
+	// Restarts the lease with the new duration, counting from now; 0 removes the lease
+	LeaseDuration *uint `json:"lease_duration"`
 }
 
 type TaskInfo struct {
@@ -96,4 +280,15 @@ type TaskInfo struct {
 	ContainerName      string
 	ContainerID        string
 	GpuIDs             []string
+	QueuedDuration     float64 // seconds
+	Diagnostics        *ContainerDiagnostics
+	ResourceSummary    *ResourceSummary
+	Progress           TaskProgress
+	Annotations        map[string]string
+	LeaseDuration      uint       // seconds, 0 = no lease
+	LeaseExpiresAt     *time.Time // nil if no lease
+	Events             []TaskHistoryEvent
+	CoreDumps          []CoreDump
+	TraceID            string
+	Credentials        *CredentialsStatus
 }
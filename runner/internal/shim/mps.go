@@ -0,0 +1,100 @@
+package shim
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dstackai/dstack/runner/internal/log"
+	"github.com/dstackai/dstack/runner/internal/shim/host"
+)
+
+// Set on containers of tasks with MPS limits, the values are TaskConfig.MPSThreadPercentage
+// and TaskConfig.MPSMemoryLimitMB, used to restore the GPU allocation
+const (
+	LabelKeyMPSThreadPercentage = LabelKeyPrefix + "mps-thread-percentage"
+	LabelKeyMPSMemoryLimitMB    = LabelKeyPrefix + "mps-memory-limit-mb"
+)
+
+// MPSLimits are NVIDIA MPS (Multi-Process Service) client limits of a task sharing GPUs,
+// applied to each allocated GPU
+type MPSLimits struct {
+	// CUDA_MPS_ACTIVE_THREAD_PERCENTAGE, 1..100, 0 = no limit
+	ThreadPercentage uint
+	// CUDA_MPS_PINNED_DEVICE_MEM_LIMIT, MiB, 0 = no limit
+	MemoryMB uint
+}
+
+func (l MPSLimits) IsZero() bool {
+	return l.ThreadPercentage == 0 && l.MemoryMB == 0
+}
+
+func getTaskMPSLimits(cfg TaskConfig) MPSLimits {
+	return MPSLimits{ThreadPercentage: cfg.MPSThreadPercentage, MemoryMB: cfg.MPSMemoryLimitMB}
+}
+
+func (d *DockerRunner) validateMPSLimits(cfg TaskConfig) error {
+	limits := getTaskMPSLimits(cfg)
+	if limits.IsZero() {
+		return nil
+	}
+	if cfg.GPUMemoryFraction == 0 {
+		return fmt.Errorf("%w: mps_thread_percentage and mps_memory_limit_mb require shared GPUs, gpu_memory_fraction must be set", ErrInvalidConfig)
+	}
+	if d.gpuVendor != host.GpuVendorNvidia {
+		return fmt.Errorf("%w: mps_thread_percentage and mps_memory_limit_mb are only supported for NVIDIA GPUs", ErrInvalidConfig)
+	}
+	if limits.ThreadPercentage > 100 {
+		return fmt.Errorf("%w: mps_thread_percentage must be in 1..100 range, got %d", ErrInvalidConfig, limits.ThreadPercentage)
+	}
+	return nil
+}
+
+// getMPSEnv returns variables read by the CUDA runtime of MPS clients. The pinned memory limit
+// is set for each of the task GPUs, which are enumerated from 0 in the container.
+// The variables have no effect unless the MPS control daemon is running on the host and its
+// pipe directory is mounted into the container, which is up to the operator
+func getMPSEnv(limits MPSLimits, gpuCount int) []string {
+	var env []string
+	if limits.ThreadPercentage > 0 {
+		env = append(env, fmt.Sprintf("CUDA_MPS_ACTIVE_THREAD_PERCENTAGE=%d", limits.ThreadPercentage))
+	}
+	if limits.MemoryMB > 0 && gpuCount > 0 {
+		deviceLimits := make([]string, 0, gpuCount)
+		for i := range gpuCount {
+			deviceLimits = append(deviceLimits, fmt.Sprintf("%d=%dM", i, limits.MemoryMB))
+		}
+		env = append(env, "CUDA_MPS_PINNED_DEVICE_MEM_LIMIT="+strings.Join(deviceLimits, ","))
+	}
+	return env
+}
+
+func setMPSLabels(labels map[string]string, limits MPSLimits) {
+	if limits.ThreadPercentage > 0 {
+		labels[LabelKeyMPSThreadPercentage] = strconv.FormatUint(uint64(limits.ThreadPercentage), 10)
+	}
+	if limits.MemoryMB > 0 {
+		labels[LabelKeyMPSMemoryLimitMB] = strconv.FormatUint(uint64(limits.MemoryMB), 10)
+	}
+}
+
+// parseMPSLabels never fails, invalid values are logged and ignored
+func parseMPSLabels(ctx context.Context, containerID string, labels map[string]string) MPSLimits {
+	var limits MPSLimits
+	parse := func(key string) uint {
+		value, ok := labels[key]
+		if !ok {
+			return 0
+		}
+		parsed, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			log.Error(ctx, "invalid label value", "id", containerID, "label", key, "err", err)
+			return 0
+		}
+		return uint(parsed)
+	}
+	limits.ThreadPercentage = parse(LabelKeyMPSThreadPercentage)
+	limits.MemoryMB = parse(LabelKeyMPSMemoryLimitMB)
+	return limits
+}
@@ -0,0 +1,105 @@
+package shim
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dstackai/dstack/runner/internal/shim/host"
+)
+
+func TestGetMPSEnv(t *testing.T) {
+	assert.Empty(t, getMPSEnv(MPSLimits{}, 2))
+	assert.Equal(t, []string{"CUDA_MPS_ACTIVE_THREAD_PERCENTAGE=25"}, getMPSEnv(MPSLimits{ThreadPercentage: 25}, 2))
+	assert.Equal(t, []string{
+		"CUDA_MPS_ACTIVE_THREAD_PERCENTAGE=50",
+		"CUDA_MPS_PINNED_DEVICE_MEM_LIMIT=0=2048M,1=2048M",
+	}, getMPSEnv(MPSLimits{ThreadPercentage: 50, MemoryMB: 2048}, 2))
+}
+
+func TestParseMPSLabels(t *testing.T) {
+	labels := map[string]string{}
+	setMPSLabels(labels, MPSLimits{ThreadPercentage: 30, MemoryMB: 1024})
+	assert.Equal(t, MPSLimits{ThreadPercentage: 30, MemoryMB: 1024}, parseMPSLabels(context.Background(), "ctr", labels))
+	assert.Equal(t, MPSLimits{}, parseMPSLabels(context.Background(), "ctr", nil))
+	labels[LabelKeyMPSThreadPercentage] = "invalid"
+	assert.Equal(t, MPSLimits{MemoryMB: 1024}, parseMPSLabels(context.Background(), "ctr", labels))
+}
+
+func TestDockerRunner_MPSLimits(t *testing.T) {
+	client := newFakeDockerClient()
+	gpus := []host.GpuInfo{{Vendor: host.GpuVendorNvidia, ID: "GPU-beef", Vram: 81920}}
+	runner, err := newDockerRunner(context.Background(), client, &dockerParametersMock{}, gpus)
+	require.NoError(t, err)
+
+	first := createTaskConfig(t)
+	first.GPU = 1
+	first.GPUMemoryFraction = 0.25
+	first.MPSThreadPercentage = 70
+	first.MPSMemoryLimitMB = 20480
+	containerID := runTask(t, runner, first)
+	ctr, err := client.getContainer(containerID)
+	require.NoError(t, err)
+	assert.Contains(t, ctr.config.Env, "CUDA_MPS_ACTIVE_THREAD_PERCENTAGE=70")
+	assert.Contains(t, ctr.config.Env, "CUDA_MPS_PINNED_DEVICE_MEM_LIMIT=0=20480M")
+	assert.Equal(t, "70", ctr.config.Labels[LabelKeyMPSThreadPercentage])
+	assert.Equal(t, "20480", ctr.config.Labels[LabelKeyMPSMemoryLimitMB])
+	allocations := runner.Allocations(context.Background())
+	require.Len(t, allocations.Tasks, 1)
+	assert.Equal(t, uint(70), allocations.Tasks[0].MPSThreadPercentage)
+	assert.Equal(t, uint(20480), allocations.Tasks[0].MPSMemoryLimitMB)
+
+	// 70% + 40% > 100%, though the fractions fit
+	second := createTaskConfig(t)
+	second.GPU = 1
+	second.GPUMemoryFraction = 0.25
+	second.MPSThreadPercentage = 40
+	require.NoError(t, runner.Submit(context.Background(), second))
+	assert.ErrorIs(t, runner.Run(context.Background(), second.ID), ErrNoCapacity)
+	assert.Equal(t, "EXECUTOR_ERROR", runner.TaskInfo(second.ID).TerminationReason)
+
+	// the limits are restored from labels
+	restored, err := newDockerRunner(context.Background(), client, &dockerParametersMock{}, gpus)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]MPSLimits{first.ID: {ThreadPercentage: 70, MemoryMB: 20480}}, restored.gpuAllocator.devices["GPU-beef"].mps)
+
+	client.exitContainer(containerID, 0)
+	waitTaskStatus(t, runner, first.ID, TaskStatusTerminated)
+	assert.Empty(t, runner.gpuAllocator.devices["GPU-beef"].mps)
+}
+
+func TestDockerRunner_MPSLimits_SubmitRejected(t *testing.T) {
+	gpus := []host.GpuInfo{{Vendor: host.GpuVendorNvidia, ID: "GPU-beef"}}
+	runner, err := newDockerRunner(context.Background(), newFakeDockerClient(), &dockerParametersMock{}, gpus)
+	require.NoError(t, err)
+	testCases := []struct {
+		fraction float64
+		threads  uint
+		err      string
+	}{
+		{0, 50, "gpu_memory_fraction must be set"},
+		{0.5, 101, "must be in 1..100 range"},
+	}
+	for _, tc := range testCases {
+		cfg := createTaskConfig(t)
+		cfg.GPU = 1
+		cfg.GPUMemoryFraction = tc.fraction
+		cfg.MPSThreadPercentage = tc.threads
+		err := runner.Submit(context.Background(), cfg)
+		assert.ErrorIs(t, err, ErrInvalidConfig)
+		assert.ErrorContains(t, err, tc.err)
+	}
+
+	amdGpus := []host.GpuInfo{{Vendor: host.GpuVendorAmd, RenderNodePath: "/dev/dri/renderD128"}}
+	runner, err = newDockerRunner(context.Background(), newFakeDockerClient(), &dockerParametersMock{}, amdGpus)
+	require.NoError(t, err)
+	cfg := createTaskConfig(t)
+	cfg.GPU = 1
+	cfg.GPUMemoryFraction = 0.5
+	cfg.MPSMemoryLimitMB = 1024
+	err = runner.Submit(context.Background(), cfg)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.ErrorContains(t, err, "only supported for NVIDIA GPUs")
+}
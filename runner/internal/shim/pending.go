@@ -0,0 +1,108 @@
+package shim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dstackai/dstack/runner/internal/log"
+)
+
+const pendingTimeoutReason = "PENDING_TIMEOUT"
+
+// The cause of the pending context cancellation by Terminate(), see pendingWaits
+var errTerminatedWhilePending = errors.New("terminated while pending")
+
+// withPendingTimeout returns a context that is done when TaskConfig.PendingTimeout expires,
+// counting from the task submission. Zero timeout means "wait indefinitely"
+func withPendingTimeout(ctx context.Context, task Task, now time.Time) (context.Context, context.CancelFunc) {
+	if task.config.PendingTimeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	timeout := time.Duration(task.config.PendingTimeout) * time.Second
+	return context.WithTimeout(ctx, timeout-now.Sub(task.submittedAt))
+}
+
+// pendingWaits keeps cancel functions of contexts Run() waits for dependencies and a queue slot
+// with, so that Terminate() interrupts the wait, and the task doesn't keep its place in the queue
+type pendingWaits struct {
+	// Task.ID: cancel function mapping
+	cancels map[string]context.CancelCauseFunc
+	mu      sync.Mutex
+}
+
+func newPendingWaits() *pendingWaits {
+	return &pendingWaits{cancels: make(map[string]context.CancelCauseFunc)}
+}
+
+// Start returns a context that is canceled with errTerminatedWhilePending by Cancel().
+// Each Start() call must be paired with Stop()
+func (w *pendingWaits) Start(ctx context.Context, taskID string) context.Context {
+	ctx, cancel := context.WithCancelCause(ctx)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.cancels[taskID] = cancel
+	return ctx
+}
+
+func (w *pendingWaits) Stop(taskID string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if cancel, ok := w.cancels[taskID]; ok {
+		cancel(nil)
+		delete(w.cancels, taskID)
+	}
+}
+
+// Cancel interrupts the wait of the task, if any
+func (w *pendingWaits) Cancel(taskID string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if cancel, ok := w.cancels[taskID]; ok {
+		cancel(errTerminatedWhilePending)
+	}
+}
+
+// terminateOnPendingTimeout terminates the task with PENDING_TIMEOUT reason if waiting
+// has been interrupted by the pending timeout rather than by the parent context
+func (d *DockerRunner) terminateOnPendingTimeout(ctx context.Context, pendingCtx context.Context, taskID string) {
+	if ctx.Err() != nil || !errors.Is(pendingCtx.Err(), context.DeadlineExceeded) {
+		return
+	}
+	task, ok := d.tasks.Get(taskID)
+	if !ok || task.Status != TaskStatusPending {
+		return
+	}
+	message := fmt.Sprintf("pending timeout: the task has been pending for more than %d seconds", task.config.PendingTimeout)
+	log.Info(ctx, "pending timeout expired", "task", taskID, "timeout", task.config.PendingTimeout)
+	if err := d.Terminate(ctx, taskID, nil, pendingTimeoutReason, message); err != nil {
+		log.Error(ctx, "failed to terminate pending task", "task", taskID, "err", err)
+	}
+}
+
+// failPendingTask sets the failed status of the task that cannot start, unless the task is
+// no longer pending, e.g., it's been terminated concurrently
+func (d *DockerRunner) failPendingTask(ctx context.Context, taskID string, reason string, message string) {
+	task, ok := d.tasks.Get(taskID)
+	if !ok {
+		return
+	}
+	locked := task
+	locked.Lock(ctx)
+	defer func() { locked.Release(ctx) }()
+	if task, ok = d.tasks.Get(taskID); !ok || task.mu != locked.mu || task.Status != TaskStatusPending {
+		return
+	}
+	log.Info(ctx, "pending task failed", "task", taskID, "reason", reason, "msg", message)
+	task.SetStatusFailed(reason, message)
+	task.finishedAt = d.clock.Now()
+	if err := d.tasks.Update(task); err != nil {
+		log.Error(ctx, "failed to update task", "task", taskID, "err", err)
+		return
+	}
+	// Consumed GPU reservation, if any
+	d.releaseGpus(ctx, &task)
+	d.deleteRestartIntent(ctx, taskID)
+}
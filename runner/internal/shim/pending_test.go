@@ -0,0 +1,108 @@
+package shim
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDockerRunner_PendingTimeout_Queue(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{maxConcurrentTasks: 1})
+	first := createTaskConfig(t)
+	containerID := runTask(t, runner, first)
+	defer client.exitContainer(containerID, 0)
+
+	// no free slots until the first task exits
+	second := createTaskConfig(t)
+	second.PendingTimeout = 1
+	require.NoError(t, runner.Submit(context.Background(), second))
+	runErr := make(chan error)
+	go func() { runErr <- runner.Run(context.Background(), second.ID) }()
+
+	select {
+	case err := <-runErr:
+		assert.ErrorIs(t, err, ErrInternal)
+	case <-time.After(5 * time.Second):
+		t.Fatal("pending timeout has not expired")
+	}
+	info := runner.TaskInfo(second.ID)
+	assert.Equal(t, TaskStatusTerminated, info.Status)
+	assert.Equal(t, "PENDING_TIMEOUT", info.TerminationReason)
+	assert.Contains(t, info.TerminationMessage, "pending for more than 1 seconds")
+	assert.GreaterOrEqual(t, info.QueuedDuration, 1.0)
+	// not growing once terminated
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, info.QueuedDuration, runner.TaskInfo(second.ID).QueuedDuration)
+	assert.Equal(t, 0, runner.queue.Depth())
+	assert.Equal(t, TaskStatusRunning, runner.TaskInfo(first.ID).Status)
+}
+
+func TestDockerRunner_TerminateQueued(t *testing.T) {
+	client := newFakeDockerClient()
+	runner, clock := newFakeDockerRunnerWithClock(t, client)
+	runner.clock = clock
+	runner.queue = newTaskQueue(1)
+	first := createTaskConfig(t)
+	containerID := runTask(t, runner, first)
+	defer client.exitContainer(containerID, 0)
+
+	second := createTaskConfig(t)
+	require.NoError(t, runner.Submit(context.Background(), second))
+	runErr := make(chan error)
+	go func() { runErr <- runner.Run(context.Background(), second.ID) }()
+	require.Eventually(t, func() bool { return runner.queue.Depth() == 1 }, 5*time.Second, time.Millisecond)
+
+	clock.Advance(10 * time.Second)
+	require.NoError(t, runner.Terminate(context.Background(), second.ID, nil, "TERMINATED_BY_SERVER", ""))
+	// the queue wait is interrupted, the slot is not taken
+	select {
+	case err := <-runErr:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the queued task is still waiting")
+	}
+	assert.Equal(t, 0, runner.queue.Depth())
+	info := runner.TaskInfo(second.ID)
+	assert.Equal(t, TaskStatusTerminated, info.Status)
+	assert.Equal(t, "TERMINATED_BY_SERVER", info.TerminationReason)
+	assert.Equal(t, 10.0, info.QueuedDuration)
+	clock.Advance(10 * time.Second)
+	assert.Equal(t, 10.0, runner.TaskInfo(second.ID).QueuedDuration)
+}
+
+func TestDockerRunner_PendingTimeout_Dependency(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	dependency := createTaskConfig(t)
+	containerID := runTask(t, runner, dependency)
+	defer client.exitContainer(containerID, 0)
+
+	cfg := createTaskConfig(t)
+	cfg.DependsOn = []string{dependency.ID}
+	cfg.PendingTimeout = 1
+	require.NoError(t, runner.Submit(context.Background(), cfg))
+
+	assert.Error(t, runner.Run(context.Background(), cfg.ID))
+	info := runner.TaskInfo(cfg.ID)
+	assert.Equal(t, TaskStatusTerminated, info.Status)
+	assert.Equal(t, "PENDING_TIMEOUT", info.TerminationReason)
+}
+
+func TestDockerRunner_PendingTimeout_NotExpired(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{maxConcurrentTasks: 1})
+	cfg := createTaskConfig(t)
+	cfg.PendingTimeout = 1
+	containerID := runTask(t, runner, cfg)
+
+	// only the pending state is limited
+	time.Sleep(1100 * time.Millisecond)
+	assert.Equal(t, TaskStatusRunning, runner.TaskInfo(cfg.ID).Status)
+	client.exitContainer(containerID, 0)
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusTerminated)
+	assert.Equal(t, "DONE_BY_RUNNER", runner.TaskInfo(cfg.ID).TerminationReason)
+}
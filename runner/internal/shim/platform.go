@@ -0,0 +1,85 @@
+package shim
+
+import (
+	"context"
+	"fmt"
+	rt "runtime"
+	"strings"
+
+	"github.com/dstackai/dstack/runner/internal/log"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Substrings of Docker daemon errors caused by the image platform mismatch, e.g.,
+// "no matching manifest for linux/arm64/v8 in the manifest list entries"
+var platformMismatchErrors = []string{
+	"no matching manifest for",
+	"does not match the specified platform",
+	"image operating system",
+}
+
+func isPlatformMismatchError(err error) bool {
+	msg := err.Error()
+	for _, s := range platformMismatchErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePlatform parses `os/arch[/variant]` platform specifier, e.g., linux/arm64/v8
+// Empty string is a valid value meaning "the host platform"
+func parsePlatform(platform string) (*ocispec.Platform, error) {
+	if platform == "" {
+		return nil, nil
+	}
+	parts := strings.Split(strings.ToLower(platform), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("%w: invalid platform %q, must be os/arch[/variant]", ErrInvalidConfig, platform)
+	}
+	p := &ocispec.Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		if parts[2] == "" {
+			return nil, fmt.Errorf("%w: invalid platform %q, empty variant", ErrInvalidConfig, platform)
+		}
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+func formatPlatform(p ocispec.Platform) string {
+	if p.Variant != "" {
+		return fmt.Sprintf("%s/%s/%s", p.OS, p.Architecture, p.Variant)
+	}
+	return fmt.Sprintf("%s/%s", p.OS, p.Architecture)
+}
+
+// getPlatformMismatchMessage returns a human-readable explanation of the platform mismatch
+// with a list of platforms the image is available for, if the registry can be queried
+func (d *DockerRunner) getPlatformMismatchMessage(ctx context.Context, cfg TaskConfig) string {
+	platform := cfg.Platform
+	platformKind := "requested"
+	if platform == "" {
+		platform = fmt.Sprintf("%s/%s", rt.GOOS, rt.GOARCH)
+		platformKind = "host"
+	}
+	msg := fmt.Sprintf("image %s is not available for the %s platform %s", cfg.ImageName, platformKind, platform)
+	regAuth, err := encodeRegistryAuth(cfg.RegistryUsername, cfg.RegistryPassword)
+	if err != nil {
+		log.Error(ctx, err.Error())
+	}
+	distribution, err := d.client.DistributionInspect(ctx, cfg.ImageName, regAuth)
+	if err != nil {
+		log.Warning(ctx, "failed to get image platforms", "name", cfg.ImageName, "err", err)
+		return msg
+	}
+	platforms := make([]string, 0, len(distribution.Platforms))
+	for _, p := range distribution.Platforms {
+		platforms = append(platforms, formatPlatform(p))
+	}
+	if len(platforms) == 0 {
+		return msg
+	}
+	return fmt.Sprintf("%s, available platforms: %s", msg, strings.Join(platforms, ", "))
+}
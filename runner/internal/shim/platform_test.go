@@ -0,0 +1,131 @@
+package shim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	rt "runtime"
+	"testing"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePlatform(t *testing.T) {
+	platform, err := parsePlatform("")
+	assert.NoError(t, err)
+	assert.Nil(t, platform)
+
+	platform, err = parsePlatform("linux/amd64")
+	assert.NoError(t, err)
+	assert.Equal(t, &ocispec.Platform{OS: "linux", Architecture: "amd64"}, platform)
+
+	platform, err = parsePlatform("Linux/ARM64/v8")
+	assert.NoError(t, err)
+	assert.Equal(t, &ocispec.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, platform)
+}
+
+func TestParsePlatform_Errors(t *testing.T) {
+	for _, platform := range []string{"linux", "linux/", "/amd64", "linux/arm64/", "linux/arm64/v8/extra"} {
+		_, err := parsePlatform(platform)
+		assert.ErrorIs(t, err, ErrInvalidConfig, platform)
+	}
+}
+
+func TestIsPlatformMismatchError(t *testing.T) {
+	assert.True(t, isPlatformMismatchError(errors.New(
+		"Error response from daemon: no matching manifest for linux/arm64/v8 in the manifest list entries",
+	)))
+	assert.True(t, isPlatformMismatchError(errors.New(
+		"image with reference ubuntu was found but does not match the specified platform: wanted linux/arm64",
+	)))
+	assert.False(t, isPlatformMismatchError(errors.New("manifest unknown")))
+}
+
+func TestDockerRunner_Platform(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.Platform = "linux/arm64"
+	containerID := runTask(t, runner, cfg)
+	defer client.exitContainer(containerID, 0)
+
+	ctr, err := client.getContainer(containerID)
+	require.NoError(t, err)
+	assert.Equal(t, &ocispec.Platform{OS: "linux", Architecture: "arm64"}, ctr.platform)
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	assert.Equal(t, "linux/arm64", client.pullPlatform)
+}
+
+func TestDockerRunner_Platform_SubmitRejected(t *testing.T) {
+	runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.Platform = "arm64"
+
+	assert.ErrorIs(t, runner.Submit(context.Background(), cfg), ErrInvalidConfig)
+	assert.Equal(t, "", runner.TaskInfo(cfg.ID).ID)
+}
+
+func TestDockerRunner_PlatformMismatch(t *testing.T) {
+	testCases := []struct {
+		name             string
+		platform         string
+		imagePlatforms   []ocispec.Platform
+		inspectErr       error
+		expectedMessages []string
+	}{
+		{
+			name:     "host platform",
+			platform: "",
+			imagePlatforms: []ocispec.Platform{
+				{OS: "linux", Architecture: "amd64"},
+				{OS: "linux", Architecture: "arm", Variant: "v7"},
+			},
+			expectedMessages: []string{
+				fmt.Sprintf("not available for the host platform %s/%s", rt.GOOS, rt.GOARCH),
+				"available platforms: linux/amd64, linux/arm/v7",
+			},
+		},
+		{
+			name:             "requested platform",
+			platform:         "linux/s390x",
+			imagePlatforms:   []ocispec.Platform{{OS: "linux", Architecture: "amd64"}},
+			expectedMessages: []string{"not available for the requested platform linux/s390x"},
+		},
+		{
+			name:             "registry unavailable",
+			platform:         "linux/s390x",
+			inspectErr:       errors.New("unauthorized"),
+			expectedMessages: []string{"image ubuntu is not available for the requested platform linux/s390x"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newFakeDockerClient()
+			client.imagePlatforms = tc.imagePlatforms
+			client.injectErrors("ImagePull", errors.New(
+				"Error response from daemon: no matching manifest for linux/s390x in the manifest list entries",
+			))
+			if tc.inspectErr != nil {
+				client.injectErrors("DistributionInspect", tc.inspectErr)
+			}
+			runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+			cfg := createTaskConfig(t)
+			cfg.Platform = tc.platform
+			require.NoError(t, runner.Submit(context.Background(), cfg))
+
+			assert.Error(t, runner.Run(context.Background(), cfg.ID))
+			taskInfo := runner.TaskInfo(cfg.ID)
+			assert.Equal(t, TaskStatusFailed, taskInfo.Status)
+			assert.Equal(t, "IMAGE_PLATFORM_MISMATCH", taskInfo.TerminationReason)
+			for _, msg := range tc.expectedMessages {
+				assert.Contains(t, taskInfo.TerminationMessage, msg)
+			}
+			if tc.inspectErr != nil {
+				assert.NotContains(t, taskInfo.TerminationMessage, "available platforms")
+			}
+		})
+	}
+}
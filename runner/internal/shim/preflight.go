@@ -0,0 +1,229 @@
+package shim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/dstackai/dstack/runner/internal/log"
+	"github.com/dstackai/dstack/runner/internal/shim/host"
+)
+
+// How often failed preflight checks are repeated, so that the shim becomes ready once
+// the host is fixed, without restarting
+const preflightRetryInterval = 30 * time.Second
+
+// The NVIDIA Container Toolkit hook used by Docker for GPU device requests when the nvidia runtime
+// is not registered with the daemon
+const nvidiaContainerRuntimeHook = "nvidia-container-runtime-hook"
+
+// PreflightConfig configures host prerequisite checks run on shim startup, see checkPreflight()
+type PreflightConfig struct {
+	// The shim home dir, runner dirs are created in it
+	HomeDir string
+	// Free disk space required in the home dir and the Docker root dir, MiB, 0 = not checked
+	MinFreeDiskMB uint64
+}
+
+// PreflightCheck is a result of one host prerequisite check. The shim is not ready until
+// all critical checks pass, failed non-critical checks are only reported
+type PreflightCheck struct {
+	Name     string
+	Critical bool
+	Passed   bool
+	Message  string
+}
+
+type PreflightReport []PreflightCheck
+
+// Err returns ErrHostUnavailable listing failed critical checks, nil if there are none
+func (r PreflightReport) Err() error {
+	var failed []string
+	for _, check := range r {
+		if check.Critical && !check.Passed {
+			failed = append(failed, fmt.Sprintf("%s: %s", check.Name, check.Message))
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: preflight checks failed: %s", ErrHostUnavailable, strings.Join(failed, "; "))
+}
+
+type preflight struct {
+	config PreflightConfig
+	// host.GetDiskSize and exec.LookPath, overridden in tests
+	diskFree func(ctx context.Context, path string) (uint64, error)
+	lookPath func(file string) (string, error)
+
+	mu     sync.Mutex
+	report PreflightReport
+}
+
+func newPreflight(config PreflightConfig) *preflight {
+	return &preflight{
+		config:   config,
+		diskFree: host.GetDiskSize,
+		lookPath: exec.LookPath,
+	}
+}
+
+// Err returns the error of the last report, see PreflightReport.Err()
+func (p *preflight) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.report.Err()
+}
+
+// checkPreflight validates host prerequisites, logs the report, and keeps it for Ready()
+func (d *DockerRunner) checkPreflight(ctx context.Context) PreflightReport {
+	p := d.preflight
+	var report PreflightReport
+	add := func(name string, critical bool, err error, passedMessage string) {
+		check := PreflightCheck{Name: name, Critical: critical, Passed: err == nil, Message: passedMessage}
+		if err != nil {
+			check.Message = err.Error()
+		}
+		report = append(report, check)
+	}
+
+	if ping, err := d.client.Ping(ctx); err != nil {
+		add("docker", true, fmt.Errorf("cannot reach the Docker daemon: %w", err), "")
+	} else {
+		add("docker", true, nil, "API version "+ping.APIVersion)
+	}
+	if d.gpuVendor == host.GpuVendorNvidia {
+		add("nvidia_runtime", true, d.checkNvidiaRuntime(), "present")
+	}
+	if p.config.HomeDir != "" {
+		add("home_dir", true, checkDirWritable(p.config.HomeDir), p.config.HomeDir+" is writable")
+	}
+	if dir := d.dockerParams.ShimStateDir(); dir != "" {
+		add("state_dir", true, checkDirWritable(dir), dir+" is writable")
+	}
+	if dir := d.dockerParams.ShimCoreDumpDir(); dir != "" {
+		add("core_dump_dir", false, checkDirWritable(dir), dir+" is writable")
+	}
+	if p.config.MinFreeDiskMB > 0 {
+		if p.config.HomeDir != "" {
+			msg, err := p.checkFreeDisk(ctx, p.config.HomeDir)
+			add("home_dir_free_disk", true, err, msg)
+		}
+		if dir := d.dockerInfo.DockerRootDir; dir != "" {
+			msg, err := p.checkFreeDisk(ctx, dir)
+			// The daemon may be remote, the dir is not on this host then
+			critical := !errors.Is(err, os.ErrNotExist)
+			add("docker_root_free_disk", critical, err, msg)
+		}
+	}
+
+	var failed int
+	for _, check := range report {
+		switch {
+		case check.Passed:
+			log.Info(ctx, "preflight check passed", "check", check.Name, "msg", check.Message)
+		case check.Critical:
+			failed++
+			log.Error(ctx, "preflight check failed, the shim is not ready", "check", check.Name, "msg", check.Message)
+		default:
+			log.Warning(ctx, "preflight check failed", "check", check.Name, "msg", check.Message)
+		}
+	}
+	if failed > 0 {
+		log.Error(ctx, "preflight checks failed", "failed", failed, "total", len(report))
+	}
+	p.mu.Lock()
+	p.report = report
+	p.mu.Unlock()
+	return report
+}
+
+// watchPreflight repeats the checks until critical ones pass
+func (d *DockerRunner) watchPreflight(ctx context.Context) {
+	if d.preflight.Err() == nil {
+		return
+	}
+	ticker := time.NewTicker(preflightRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if d.checkPreflight(ctx).Err() == nil {
+				log.Info(ctx, "preflight checks passed, the shim is ready")
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (d *DockerRunner) checkNvidiaRuntime() error {
+	if _, ok := d.dockerInfo.Runtimes["nvidia"]; ok {
+		return nil
+	}
+	if _, err := d.preflight.lookPath(nvidiaContainerRuntimeHook); err == nil {
+		return nil
+	}
+	return fmt.Errorf(
+		"NVIDIA GPUs found, but neither the nvidia runtime is registered with Docker nor %s is installed, install NVIDIA Container Toolkit",
+		nvidiaContainerRuntimeHook,
+	)
+}
+
+// checkDirWritable writes a temporary file to the dir. A missing dir is not created, as its
+// owner creates it later with its own mode (e.g., stateDirMode), the closest existing parent
+// must be writable instead
+func checkDirWritable(dir string) error {
+	existing := dir
+	for {
+		info, err := os.Stat(existing)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("cannot create dir: %s is not a directory", existing)
+			}
+			break
+		}
+		// ENOTDIR: a parent is not a dir, it's reported below
+		if !errors.Is(err, os.ErrNotExist) && !errors.Is(err, syscall.ENOTDIR) {
+			return fmt.Errorf("cannot check dir: %w", err)
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return fmt.Errorf("cannot create dir: no existing parent of %s", dir)
+		}
+		existing = parent
+	}
+	f, err := os.CreateTemp(existing, ".preflight-*")
+	if err != nil {
+		if existing != dir {
+			return fmt.Errorf("cannot create dir: %s is not writable: %w", existing, err)
+		}
+		return fmt.Errorf("dir is not writable: %w", err)
+	}
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+	return nil
+}
+
+func (p *preflight) checkFreeDisk(ctx context.Context, path string) (string, error) {
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("cannot check free disk space: %w", err)
+	}
+	free, err := p.diskFree(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	freeMB := free / 1024 / 1024
+	if freeMB < p.config.MinFreeDiskMB {
+		return "", fmt.Errorf("%d MiB free in %s, at least %d MiB required", freeMB, path, p.config.MinFreeDiskMB)
+	}
+	return fmt.Sprintf("%d MiB free in %s", freeMB, path), nil
+}
@@ -0,0 +1,175 @@
+package shim
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/system"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dstackai/dstack/runner/internal/shim/host"
+)
+
+func getPreflightCheck(t *testing.T, report PreflightReport, name string) PreflightCheck {
+	t.Helper()
+	for _, check := range report {
+		if check.Name == name {
+			return check
+		}
+	}
+	require.FailNow(t, "check not found", name)
+	return PreflightCheck{}
+}
+
+// notWritableDir returns a path that cannot be created, as its parent is a file
+func notWritableDir(t *testing.T) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(file, nil, 0o644))
+	return filepath.Join(file, "dir")
+}
+
+func TestDockerRunner_Preflight_Passed(t *testing.T) {
+	client := newFakeDockerClient()
+	client.info.DockerRootDir = t.TempDir()
+	homeDir := t.TempDir()
+	stateDir := filepath.Join(homeDir, "state")
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{
+		stateDir:  stateDir,
+		preflight: PreflightConfig{HomeDir: homeDir, MinFreeDiskMB: 1},
+	})
+	runner.preflight.diskFree = func(context.Context, string) (uint64, error) { return 2 << 20, nil }
+
+	report := runner.checkPreflight(context.Background())
+	assert.NoError(t, report.Err())
+	var names []string
+	for _, check := range report {
+		assert.True(t, check.Passed, check.Name)
+		names = append(names, check.Name)
+	}
+	assert.Equal(t, []string{"docker", "home_dir", "state_dir", "home_dir_free_disk", "docker_root_free_disk"}, names)
+	assert.Equal(t, "2 MiB free in "+homeDir, getPreflightCheck(t, report, "home_dir_free_disk").Message)
+	// not created, its owner creates it with stateDirMode
+	assert.NoDirExists(t, stateDir)
+	assert.NoError(t, runner.Ready(context.Background()))
+}
+
+func TestDockerRunner_Preflight_DockerUnreachable(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	client.injectErrors("Ping", errors.New("connection refused"))
+
+	check := getPreflightCheck(t, runner.checkPreflight(context.Background()), "docker")
+	assert.False(t, check.Passed)
+	assert.True(t, check.Critical)
+	assert.Equal(t, "cannot reach the Docker daemon: connection refused", check.Message)
+	err := runner.Ready(context.Background())
+	assert.ErrorIs(t, err, ErrHostUnavailable)
+	assert.ErrorContains(t, err, "preflight checks failed: docker: cannot reach the Docker daemon")
+
+	// recovered
+	runner.checkPreflight(context.Background())
+	assert.NoError(t, runner.Ready(context.Background()))
+}
+
+func TestDockerRunner_Preflight_NvidiaRuntime(t *testing.T) {
+	gpus := []host.GpuInfo{{Vendor: host.GpuVendorNvidia, ID: "GPU-beef"}}
+	client := newFakeDockerClient()
+	runner, err := newDockerRunner(context.Background(), client, &dockerParametersMock{}, gpus)
+	require.NoError(t, err)
+	runner.preflight.lookPath = func(string) (string, error) { return "", errors.New("not found") }
+
+	check := getPreflightCheck(t, runner.checkPreflight(context.Background()), "nvidia_runtime")
+	assert.False(t, check.Passed)
+	assert.True(t, check.Critical)
+	assert.Contains(t, check.Message, "install NVIDIA Container Toolkit")
+	assert.ErrorIs(t, runner.Ready(context.Background()), ErrHostUnavailable)
+
+	runner.preflight.lookPath = func(string) (string, error) { return "/usr/bin/" + nvidiaContainerRuntimeHook, nil }
+	assert.True(t, getPreflightCheck(t, runner.checkPreflight(context.Background()), "nvidia_runtime").Passed)
+	assert.NoError(t, runner.Ready(context.Background()))
+
+	client.info.Runtimes = map[string]system.RuntimeWithStatus{"nvidia": {}}
+	runner, err = newDockerRunner(context.Background(), client, &dockerParametersMock{}, gpus)
+	require.NoError(t, err)
+	runner.preflight.lookPath = func(string) (string, error) { return "", errors.New("not found") }
+	assert.True(t, getPreflightCheck(t, runner.checkPreflight(context.Background()), "nvidia_runtime").Passed)
+
+	// not checked without NVIDIA GPUs
+	runner = newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{})
+	for _, check := range runner.checkPreflight(context.Background()) {
+		assert.NotEqual(t, "nvidia_runtime", check.Name)
+	}
+}
+
+func TestDockerRunner_Preflight_DirsNotWritable(t *testing.T) {
+	runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{
+		stateDir:  notWritableDir(t),
+		preflight: PreflightConfig{HomeDir: notWritableDir(t)},
+	})
+
+	report := runner.checkPreflight(context.Background())
+	for _, name := range []string{"home_dir", "state_dir"} {
+		check := getPreflightCheck(t, report, name)
+		assert.False(t, check.Passed, name)
+		assert.True(t, check.Critical, name)
+		assert.Contains(t, check.Message, "cannot create dir", name)
+	}
+	err := runner.Ready(context.Background())
+	assert.ErrorContains(t, err, "home_dir: cannot create dir")
+	assert.ErrorContains(t, err, "state_dir: cannot create dir")
+}
+
+func TestDockerRunner_Preflight_CoreDumpDirNotWritable(t *testing.T) {
+	runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{coreDumpDir: notWritableDir(t)})
+
+	report := runner.checkPreflight(context.Background())
+	check := getPreflightCheck(t, report, "core_dump_dir")
+	assert.False(t, check.Passed)
+	assert.False(t, check.Critical)
+	// a warning only
+	assert.NoError(t, report.Err())
+	assert.NoError(t, runner.Ready(context.Background()))
+}
+
+func TestDockerRunner_Preflight_LowDisk(t *testing.T) {
+	client := newFakeDockerClient()
+	client.info.DockerRootDir = t.TempDir()
+	homeDir := t.TempDir()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{
+		preflight: PreflightConfig{HomeDir: homeDir, MinFreeDiskMB: 1024},
+	})
+	runner.preflight.diskFree = func(ctx context.Context, path string) (uint64, error) {
+		if path == homeDir {
+			return 100 << 20, nil
+		}
+		return 2048 << 20, nil
+	}
+
+	report := runner.checkPreflight(context.Background())
+	check := getPreflightCheck(t, report, "home_dir_free_disk")
+	assert.False(t, check.Passed)
+	assert.True(t, check.Critical)
+	assert.Equal(t, "100 MiB free in "+homeDir+", at least 1024 MiB required", check.Message)
+	assert.True(t, getPreflightCheck(t, report, "docker_root_free_disk").Passed)
+	assert.ErrorIs(t, runner.Ready(context.Background()), ErrHostUnavailable)
+}
+
+func TestDockerRunner_Preflight_RemoteDockerRoot(t *testing.T) {
+	client := newFakeDockerClient()
+	client.info.DockerRootDir = filepath.Join(t.TempDir(), "missing")
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{
+		preflight: PreflightConfig{MinFreeDiskMB: 1024},
+	})
+
+	report := runner.checkPreflight(context.Background())
+	check := getPreflightCheck(t, report, "docker_root_free_disk")
+	assert.False(t, check.Passed)
+	assert.False(t, check.Critical)
+	assert.Contains(t, check.Message, "cannot check free disk space")
+	assert.NoError(t, runner.Ready(context.Background()))
+}
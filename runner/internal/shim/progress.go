@@ -0,0 +1,115 @@
+package shim
+
+import (
+	"sync"
+)
+
+// TaskPhase is a step of the task lifecycle, used for progress reporting only.
+// Phases mostly follow TaskStatus, but the running status is split into starting
+// (the container is being started or its startup probe has not passed yet, see
+// TaskConfig.StartupProbe) and running (the container is up)
+type TaskPhase string
+
+const (
+	TaskPhaseQueued     TaskPhase = "queued" // waiting for dependencies or a free slot
+	TaskPhasePreparing  TaskPhase = "preparing"
+	TaskPhasePulling    TaskPhase = "pulling"
+	TaskPhaseCreating   TaskPhase = "creating"
+	TaskPhaseStarting   TaskPhase = "starting"
+	TaskPhaseRunning    TaskPhase = "running"
+	TaskPhaseTerminated TaskPhase = "terminated"
+)
+
+// Progress, percent, at the start of each phase. Pulling is usually the longest phase,
+// its range is filled in proportion to downloaded bytes, other phases are discrete
+var taskPhaseProgress = map[TaskPhase]int{
+	TaskPhaseQueued:     0,
+	TaskPhasePreparing:  5,
+	TaskPhasePulling:    10,
+	TaskPhaseCreating:   85,
+	TaskPhaseStarting:   90,
+	TaskPhaseRunning:    100,
+	TaskPhaseTerminated: 100,
+}
+
+type TaskProgress struct {
+	Phase   TaskPhase `json:"phase"`
+	Percent int       `json:"percent"`
+}
+
+// getTaskPhase returns the phase of the task, the pulling progress is not known to the task
+func getTaskPhase(task Task) TaskPhase {
+	switch task.Status {
+	case TaskStatusPending:
+		return TaskPhaseQueued
+	case TaskStatusPreparing:
+		return TaskPhasePreparing
+	case TaskStatusPulling:
+		return TaskPhasePulling
+	case TaskStatusCreating:
+		return TaskPhaseCreating
+	case TaskStatusRunning:
+		if task.containerStarted {
+			return TaskPhaseRunning
+		}
+		return TaskPhaseStarting
+	}
+	return TaskPhaseTerminated
+}
+
+// getPhaseProgress returns the percent within the whole lifecycle. pulledFraction, [0.0, 1.0],
+// is the share of downloaded bytes, only used in the pulling phase
+func getPhaseProgress(phase TaskPhase, pulledFraction float64) int {
+	percent := taskPhaseProgress[phase]
+	if phase == TaskPhasePulling {
+		percent += int(float64(taskPhaseProgress[TaskPhaseCreating]-percent) * min(max(pulledFraction, 0), 1))
+	}
+	return percent
+}
+
+// taskProgressTracker keeps the progress reported for each task monotonic: the pulled fraction
+// may go backwards, e.g., when more layers are discovered or the pull falls back to upstream
+// after trying the mirror. The state is not persisted, that is, tasks restored on shim restart
+// start over, and so do tasks resubmitted with the ID of a removed task
+type taskProgressTracker struct {
+	// Task.ID: the highest reported percent
+	percents map[string]int
+	mu       sync.Mutex
+}
+
+func newTaskProgressTracker() *taskProgressTracker {
+	return &taskProgressTracker{percents: make(map[string]int)}
+}
+
+// Observe records the current progress and returns the highest one reported so far
+func (t *taskProgressTracker) Observe(taskID string, percent int) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	percent = max(t.percents[taskID], percent)
+	t.percents[taskID] = percent
+	return percent
+}
+
+func (t *taskProgressTracker) Delete(taskID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.percents, taskID)
+}
+
+// getTaskProgress returns the current phase and the monotonic progress of the task
+func (d *DockerRunner) getTaskProgress(task Task) TaskProgress {
+	phase := getTaskPhase(task)
+	if phase == TaskPhaseRunning && d.startingUp.Has(task.ID) {
+		phase = TaskPhaseStarting
+	}
+	var pulledFraction float64
+	if phase == TaskPhasePulling {
+		if current, total := d.puller.Progress(task.config); total > 0 {
+			pulledFraction = float64(current) / float64(total)
+		}
+	}
+	return TaskProgress{
+		Phase:   phase,
+		Percent: d.progress.Observe(task.ID, getPhaseProgress(phase, pulledFraction)),
+	}
+}
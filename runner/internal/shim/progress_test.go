@@ -0,0 +1,89 @@
+package shim
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetPhaseProgress(t *testing.T) {
+	assert.Equal(t, 0, getPhaseProgress(TaskPhaseQueued, 0))
+	assert.Equal(t, 10, getPhaseProgress(TaskPhasePulling, 0))
+	assert.Equal(t, 47, getPhaseProgress(TaskPhasePulling, 0.5))
+	assert.Equal(t, 85, getPhaseProgress(TaskPhasePulling, 1))
+	assert.Equal(t, 85, getPhaseProgress(TaskPhasePulling, 1.5))
+	assert.Equal(t, 85, getPhaseProgress(TaskPhaseCreating, 0))
+	assert.Equal(t, 100, getPhaseProgress(TaskPhaseRunning, 0))
+}
+
+func TestTaskProgressTracker(t *testing.T) {
+	tracker := newTaskProgressTracker()
+	assert.Equal(t, 40, tracker.Observe("a", 40))
+	assert.Equal(t, 40, tracker.Observe("a", 20))
+	assert.Equal(t, 10, tracker.Observe("b", 10))
+	tracker.Delete("a")
+	assert.Equal(t, 20, tracker.Observe("a", 20))
+}
+
+func TestDockerRunner_Progress(t *testing.T) {
+	client := newFakeDockerClient()
+	pullReader, pullWriter := io.Pipe()
+	client.pullStream = pullReader
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	require.NoError(t, runner.Submit(context.Background(), cfg))
+	assert.Equal(t, TaskProgress{Phase: TaskPhaseQueued, Percent: 0}, runner.TaskInfo(cfg.ID).Progress)
+
+	go func() { _ = runner.Run(context.Background(), cfg.ID) }()
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusPulling)
+	// the line is processed by the pull goroutine asynchronously, wait for the expected totals
+	writePullProgress := func(layer string, current, total int, wantCurrent, wantTotal uint) {
+		_, err := fmt.Fprintf(pullWriter, `{"status":"Downloading","id":"%s","progressDetail":{"current":%d,"total":%d}}`+"\n", layer, current, total)
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			c, tt := runner.puller.Progress(cfg)
+			return c == wantCurrent && tt == wantTotal
+		}, 5*time.Second, time.Millisecond)
+	}
+	writePullProgress("layer1", 50, 100, 50, 100)
+	assert.Equal(t, TaskProgress{Phase: TaskPhasePulling, Percent: 47}, runner.TaskInfo(cfg.ID).Progress)
+	// the second layer is discovered, the pulled fraction drops to 25%, but the progress doesn't
+	writePullProgress("layer2", 0, 100, 50, 200)
+	assert.Equal(t, TaskProgress{Phase: TaskPhasePulling, Percent: 47}, runner.TaskInfo(cfg.ID).Progress)
+	writePullProgress("layer1", 100, 100, 100, 200)
+	writePullProgress("layer2", 80, 100, 180, 200)
+	assert.Equal(t, TaskProgress{Phase: TaskPhasePulling, Percent: 77}, runner.TaskInfo(cfg.ID).Progress)
+
+	_, err := io.WriteString(pullWriter, `{"status":"Status: Downloaded newer image for ubuntu:latest"}`+"\n")
+	require.NoError(t, err)
+	require.NoError(t, pullWriter.Close())
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusRunning)
+	require.Eventually(t, func() bool {
+		return runner.TaskInfo(cfg.ID).Progress.Phase == TaskPhaseRunning
+	}, 5*time.Second, time.Millisecond)
+	taskInfo := runner.TaskInfo(cfg.ID)
+	assert.Equal(t, 100, taskInfo.Progress.Percent)
+
+	client.exitContainer(taskInfo.ContainerID, 0)
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusTerminated)
+	assert.Equal(t, TaskProgress{Phase: TaskPhaseTerminated, Percent: 100}, runner.TaskInfo(cfg.ID).Progress)
+
+	// the task resubmitted after removal starts over
+	require.NoError(t, runner.Remove(context.Background(), cfg.ID))
+	require.NoError(t, runner.Submit(context.Background(), cfg))
+	assert.Equal(t, TaskProgress{Phase: TaskPhaseQueued, Percent: 0}, runner.TaskInfo(cfg.ID).Progress)
+}
+
+func TestGetTaskPhase(t *testing.T) {
+	task := Task{Status: TaskStatusRunning}
+	assert.Equal(t, TaskPhaseStarting, getTaskPhase(task))
+	task.containerStarted = true
+	assert.Equal(t, TaskPhaseRunning, getTaskPhase(task))
+	task.Status = TaskStatusTerminated
+	assert.Equal(t, TaskPhaseTerminated, getTaskPhase(task))
+}
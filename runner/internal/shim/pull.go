@@ -0,0 +1,193 @@
+package shim
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	docker "github.com/docker/docker/client"
+	"github.com/dstackai/dstack/runner/internal/log"
+	"github.com/ztrue/tracerr"
+)
+
+// PullPolicy defines when the task image is pulled, see TaskConfig.PullPolicy
+type PullPolicy string
+
+const (
+	// Pull if the image is not present locally or its tag is latest
+	PullPolicyDefault PullPolicy = ""
+	// Pull even if the image is present locally, e.g., to get the current version of a moving tag
+	PullPolicyAlways PullPolicy = "always"
+	// Use the local image if the tag or digest is present, including latest
+	PullPolicyIfNotPresent PullPolicy = "if_not_present"
+	// Never pull, fail if the image is not present locally, e.g., on air-gapped hosts
+	// with preloaded images
+	PullPolicyNever PullPolicy = "never"
+)
+
+// needsPull reports whether the image is to be pulled, with PullPolicyNever the caller
+// must fail instead
+func (p PullPolicy) needsPull(imageName string, present bool) bool {
+	switch p {
+	case PullPolicyAlways:
+		return true
+	case PullPolicyIfNotPresent, PullPolicyNever:
+		return !present
+	default:
+		return !present || strings.HasSuffix(imageName, ":latest")
+	}
+}
+
+func validatePullPolicy(policy PullPolicy) error {
+	switch policy {
+	case PullPolicyDefault, PullPolicyAlways, PullPolicyIfNotPresent, PullPolicyNever:
+		return nil
+	}
+	return fmt.Errorf("%w: unknown pull_policy %s", ErrInvalidConfig, policy)
+}
+
+// imagePuller coalesces concurrent pulls of the same image with the same credentials:
+// the first caller starts the pull, subsequent callers wait for it to complete and
+// get the same result.
+// The pull itself is not bound to any caller's context, it's canceled only when all
+// callers have given up waiting, e.g., all tasks waiting for the image were terminated.
+// The number of concurrent pulls of different images may be limited, extra pulls wait
+// for a free slot (the tasks remain in the pulling state)
+type imagePuller struct {
+	client         docker.APIClient
+	mirrors        registryMirrors
+	mirrorFallback bool
+	// nil = no limit
+	slots chan struct{}
+	// pullKey(): in-flight pull mapping
+	calls map[string]*pullCall
+	mu    sync.Mutex
+}
+
+type pullCall struct {
+	done    chan struct{}
+	err     error // set before done is closed
+	waiters int
+	cancel  context.CancelFunc
+	// downloaded and total bytes of layers known so far, guarded by imagePuller.mu
+	currentBytes uint
+	totalBytes   uint
+}
+
+// Zero maxConcurrentPulls means "no limit"
+func newImagePuller(client docker.APIClient, mirrors registryMirrors, mirrorFallback bool, maxConcurrentPulls int) *imagePuller {
+	p := &imagePuller{
+		client:         client,
+		mirrors:        mirrors,
+		mirrorFallback: mirrorFallback,
+		calls:          make(map[string]*pullCall),
+	}
+	if maxConcurrentPulls > 0 {
+		p.slots = make(chan struct{}, maxConcurrentPulls)
+	}
+	return p
+}
+
+// Pull pulls the image if needed (see pullImage()), joining the in-flight pull if any
+func (p *imagePuller) Pull(ctx context.Context, taskConfig TaskConfig) error {
+	key := pullKey(taskConfig)
+	p.mu.Lock()
+	call, ok := p.calls[key]
+	if ok {
+		log.Debug(ctx, "joining in-flight image pull", "name", taskConfig.ImageName)
+	} else {
+		call = p.startPull(ctx, key, taskConfig)
+	}
+	call.waiters++
+	p.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.err
+	case <-ctx.Done():
+		p.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			call.cancel()
+			// the canceled pull must not be joined by new callers
+			if p.calls[key] == call {
+				delete(p.calls, key)
+			}
+		}
+		p.mu.Unlock()
+		return tracerr.Errorf("image pull interrupted: %w", ctx.Err())
+	}
+}
+
+// startPull must be called with lock held
+func (p *imagePuller) startPull(ctx context.Context, key string, taskConfig TaskConfig) *pullCall {
+	pullCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	call := &pullCall{
+		done:   make(chan struct{}),
+		cancel: cancel,
+	}
+	p.calls[key] = call
+	go func() {
+		defer cancel()
+		call.err = p.pull(pullCtx, taskConfig, func(current, total uint) {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			call.currentBytes, call.totalBytes = current, total
+		})
+		p.mu.Lock()
+		if p.calls[key] == call {
+			delete(p.calls, key)
+		}
+		p.mu.Unlock()
+		close(call.done)
+	}()
+	return call
+}
+
+// pull waits for a free slot, if the number of concurrent pulls is limited, and pulls the image.
+// ImagePullTimeout applies to the pull itself, not including the time spent waiting for a slot
+func (p *imagePuller) pull(ctx context.Context, taskConfig TaskConfig, onProgress func(current, total uint)) error {
+	if p.slots != nil {
+		select {
+		case p.slots <- struct{}{}:
+		default:
+			log.Debug(ctx, "waiting for a free image pull slot", "name", taskConfig.ImageName)
+			imagePullQueueDepth.Inc()
+			select {
+			case p.slots <- struct{}{}:
+				imagePullQueueDepth.Dec()
+			case <-ctx.Done():
+				imagePullQueueDepth.Dec()
+				return tracerr.Errorf("failed to wait for a free image pull slot: %w", ctx.Err())
+			}
+		}
+		defer func() { <-p.slots }()
+	}
+	ctx, cancel := context.WithTimeout(ctx, ImagePullTimeout)
+	defer cancel()
+	return pullImage(ctx, p.client, taskConfig, p.mirrors, p.mirrorFallback, onProgress)
+}
+
+// Progress returns the downloaded and total bytes of the in-flight pull of the task image.
+// Both are zero if there is no such pull or no layers are being downloaded (yet).
+// The total grows as the daemon discovers layers to download
+func (p *imagePuller) Progress(taskConfig TaskConfig) (current uint, total uint) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	call, ok := p.calls[pullKey(taskConfig)]
+	if !ok {
+		return 0, 0
+	}
+	return call.currentBytes, call.totalBytes
+}
+
+// pullKey identifies the pull by image reference, platform, credentials, and pull policy; the same
+// image with different credentials is pulled separately, as they may grant different access,
+// and a pull that is required by the policy doesn't join a check that the image is present
+func pullKey(taskConfig TaskConfig) string {
+	return strings.Join([]string{
+		taskConfig.ImageName, taskConfig.Platform, taskConfig.RegistryUsername, taskConfig.RegistryPassword,
+		string(taskConfig.PullPolicy),
+	}, "\x00")
+}
@@ -0,0 +1,273 @@
+package shim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDockerRunner_PullCoalescing(t *testing.T) {
+	const taskCount = 5
+	client := newFakeDockerClient()
+	client.pullGate = make(chan struct{})
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+
+	cfgs := make([]TaskConfig, 0, taskCount)
+	for i := 0; i < taskCount; i++ {
+		cfg := createTaskConfig(t)
+		require.NoError(t, runner.Submit(context.Background(), cfg))
+		go func() { _ = runner.Run(context.Background(), cfg.ID) }()
+		cfgs = append(cfgs, cfg)
+	}
+	waitPullWaiters(t, runner.puller, cfgs[0], taskCount)
+	close(client.pullGate)
+
+	for _, cfg := range cfgs {
+		waitTaskStatus(t, runner, cfg.ID, TaskStatusRunning)
+		client.exitContainer(runner.TaskInfo(cfg.ID).ContainerID, 0)
+	}
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	assert.Equal(t, 1, client.pullCount)
+}
+
+func TestDockerRunner_PullCoalescing_ErrorPropagated(t *testing.T) {
+	const taskCount = 3
+	client := newFakeDockerClient()
+	client.pullGate = make(chan struct{})
+	client.injectErrors("ImagePull", errors.New("manifest unknown"))
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+
+	cfgs := make([]TaskConfig, 0, taskCount)
+	for i := 0; i < taskCount; i++ {
+		cfg := createTaskConfig(t)
+		require.NoError(t, runner.Submit(context.Background(), cfg))
+		go func() { _ = runner.Run(context.Background(), cfg.ID) }()
+		cfgs = append(cfgs, cfg)
+	}
+	waitPullWaiters(t, runner.puller, cfgs[0], taskCount)
+	close(client.pullGate)
+
+	for _, cfg := range cfgs {
+		waitTaskStatus(t, runner, cfg.ID, TaskStatusFailed)
+		taskInfo := runner.TaskInfo(cfg.ID)
+		assert.Equal(t, "CREATING_CONTAINER_ERROR", taskInfo.TerminationReason)
+		assert.Contains(t, taskInfo.TerminationMessage, "manifest unknown")
+	}
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	assert.Equal(t, 1, client.pullCount)
+}
+
+func TestDockerRunner_PullConcurrencyLimit(t *testing.T) {
+	const taskCount = 6
+	const maxConcurrentPulls = 2
+	client := newFakeDockerClient()
+	client.pullGate = make(chan struct{})
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{maxConcurrentPulls: maxConcurrentPulls})
+
+	cfgs := make([]TaskConfig, 0, taskCount)
+	for i := 0; i < taskCount; i++ {
+		cfg := createTaskConfig(t)
+		cfg.ImageName = fmt.Sprintf("image-%d", i)
+		require.NoError(t, runner.Submit(context.Background(), cfg))
+		go func() { _ = runner.Run(context.Background(), cfg.ID) }()
+		cfgs = append(cfgs, cfg)
+	}
+	for _, cfg := range cfgs {
+		waitTaskStatus(t, runner, cfg.ID, TaskStatusPulling)
+	}
+	require.Eventually(t, func() bool {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		return client.pullCount == maxConcurrentPulls
+	}, 5*time.Second, time.Millisecond)
+	// extra pulls are queued, not started
+	assert.Never(t, func() bool {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		return client.pullCount > maxConcurrentPulls
+	}, 50*time.Millisecond, 5*time.Millisecond)
+	close(client.pullGate)
+
+	for _, cfg := range cfgs {
+		waitTaskStatus(t, runner, cfg.ID, TaskStatusRunning)
+		client.exitContainer(runner.TaskInfo(cfg.ID).ContainerID, 0)
+	}
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	assert.Equal(t, taskCount, client.pullCount)
+	assert.Equal(t, maxConcurrentPulls, client.maxPullsInFlight)
+}
+
+func TestImagePuller_QueuedPullCanceled(t *testing.T) {
+	client := newFakeDockerClient()
+	client.pullGate = make(chan struct{})
+	puller := newImagePuller(client, nil, false, 1)
+	first := TaskConfig{ImageName: "ubuntu"}
+	second := TaskConfig{ImageName: "debian"}
+
+	firstErr := make(chan error)
+	go func() { firstErr <- puller.Pull(context.Background(), first) }()
+	waitPullWaiters(t, puller, first, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	secondErr := make(chan error)
+	go func() { secondErr <- puller.Pull(ctx, second) }()
+	waitPullWaiters(t, puller, second, 1)
+
+	// the queued pull is dropped without reaching the daemon
+	cancel()
+	assert.ErrorIs(t, <-secondErr, context.Canceled)
+	close(client.pullGate)
+	assert.NoError(t, <-firstErr)
+	assert.Equal(t, []string{"ubuntu:latest"}, client.pulledRefs)
+}
+
+func TestImagePuller_DifferentCredentials(t *testing.T) {
+	client := newFakeDockerClient()
+	puller := newImagePuller(client, nil, false, 0)
+	cfg := TaskConfig{ImageName: "ubuntu"}
+
+	assert.NoError(t, puller.Pull(context.Background(), cfg))
+	cfg.RegistryUsername = "user"
+	cfg.RegistryPassword = "password"
+	assert.NoError(t, puller.Pull(context.Background(), cfg))
+
+	assert.Equal(t, 2, client.pullCount)
+	assert.Equal(t, 0, len(puller.calls))
+}
+
+func TestImagePuller_WaiterCanceled(t *testing.T) {
+	client := newFakeDockerClient()
+	client.pullGate = make(chan struct{})
+	puller := newImagePuller(client, nil, false, 0)
+	cfg := TaskConfig{ImageName: "ubuntu"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	canceledErr := make(chan error)
+	go func() { canceledErr <- puller.Pull(ctx, cfg) }()
+	waitPullWaiters(t, puller, cfg, 1)
+	pullErr := make(chan error)
+	go func() { pullErr <- puller.Pull(context.Background(), cfg) }()
+	waitPullWaiters(t, puller, cfg, 2)
+
+	// the pull goes on for the remaining waiter
+	cancel()
+	assert.ErrorIs(t, <-canceledErr, context.Canceled)
+	close(client.pullGate)
+	assert.NoError(t, <-pullErr)
+	assert.Equal(t, 1, client.pullCount)
+}
+
+func TestImagePuller_AllWaitersCanceled(t *testing.T) {
+	client := newFakeDockerClient()
+	client.pullGate = make(chan struct{})
+	puller := newImagePuller(client, nil, false, 0)
+	cfg := TaskConfig{ImageName: "ubuntu"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pullErr := make(chan error)
+	go func() { pullErr <- puller.Pull(ctx, cfg) }()
+	waitPullWaiters(t, puller, cfg, 1)
+	cancel()
+	assert.ErrorIs(t, <-pullErr, context.Canceled)
+
+	// the new pull is started instead of joining the canceled one
+	close(client.pullGate)
+	assert.NoError(t, puller.Pull(context.Background(), cfg))
+	assert.Equal(t, 2, client.pullCount)
+}
+
+func waitPullWaiters(t *testing.T, puller *imagePuller, cfg TaskConfig, waiters int) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		puller.mu.Lock()
+		defer puller.mu.Unlock()
+		call, ok := puller.calls[pullKey(cfg)]
+		return ok && call.waiters == waiters
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestPullImage_PullPolicy(t *testing.T) {
+	testCases := []struct {
+		policy  PullPolicy
+		image   string
+		present bool
+		pulled  bool
+		err     string
+	}{
+		{PullPolicyDefault, "ubuntu:22.04", false, true, ""},
+		{PullPolicyDefault, "ubuntu:22.04", true, false, ""},
+		{PullPolicyDefault, "ubuntu", true, true, ""},
+		{PullPolicyAlways, "ubuntu:22.04", false, true, ""},
+		{PullPolicyAlways, "ubuntu:22.04", true, true, ""},
+		{PullPolicyIfNotPresent, "ubuntu:22.04", false, true, ""},
+		{PullPolicyIfNotPresent, "ubuntu:22.04", true, false, ""},
+		{PullPolicyIfNotPresent, "ubuntu", true, false, ""},
+		{PullPolicyIfNotPresent, "ubuntu@" + fakeImageDigest, true, false, ""},
+		{PullPolicyNever, "ubuntu:22.04", true, false, ""},
+		{PullPolicyNever, "ubuntu", true, false, ""},
+		{PullPolicyNever, "ubuntu:22.04", false, false, "image ubuntu:22.04 is not present locally, pull_policy is never"},
+	}
+	for _, tc := range testCases {
+		client := newFakeDockerClient()
+		if tc.present {
+			ref := tc.image
+			if ref == "ubuntu" {
+				ref = "ubuntu:latest"
+			}
+			client.images = map[string]bool{ref: true}
+		}
+		cfg := TaskConfig{ImageName: tc.image, PullPolicy: tc.policy}
+		err := pullImage(context.Background(), client, cfg, nil, false, nil)
+		msg := fmt.Sprintf("%q %s present=%v", tc.policy, tc.image, tc.present)
+		if tc.err != "" {
+			assert.ErrorContains(t, err, tc.err, msg)
+		} else {
+			assert.NoError(t, err, msg)
+		}
+		assert.Equal(t, tc.pulled, client.pullCount > 0, msg)
+	}
+}
+
+func TestDockerRunner_PullPolicyNever_NotPresent(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.ImageName = "ubuntu:22.04"
+	cfg.PullPolicy = PullPolicyNever
+	require.NoError(t, runner.Submit(context.Background(), cfg))
+
+	assert.Error(t, runner.Run(context.Background(), cfg.ID))
+	info := runner.TaskInfo(cfg.ID)
+	assert.Equal(t, TaskStatusFailed, info.Status)
+	assert.Equal(t, "CREATING_CONTAINER_ERROR", info.TerminationReason)
+	assert.Contains(t, info.TerminationMessage, "is not present locally")
+	assert.Equal(t, 0, client.pullCount)
+	assert.Empty(t, client.containers)
+}
+
+func TestDockerRunner_PullPolicyNever_Present(t *testing.T) {
+	client := newFakeDockerClient()
+	client.images = map[string]bool{"ubuntu:22.04": true}
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.ImageName = "ubuntu:22.04"
+	cfg.PullPolicy = PullPolicyNever
+	containerID := runTask(t, runner, cfg)
+	client.exitContainer(containerID, 0)
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusTerminated)
+	assert.Equal(t, 0, client.pullCount)
+}
+
+func TestValidatePullPolicy(t *testing.T) {
+	for _, policy := range []PullPolicy{PullPolicyDefault, PullPolicyAlways, PullPolicyIfNotPresent, PullPolicyNever} {
+		assert.NoError(t, validatePullPolicy(policy))
+	}
+	assert.ErrorIs(t, validatePullPolicy("IfNotPresent"), ErrInvalidConfig)
+}
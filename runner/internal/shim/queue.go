@@ -0,0 +1,61 @@
+package shim
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// taskQueue limits the number of concurrently running tasks, that is, tasks that have
+// left the pending state and have not yet finished. Tasks that don't fit wait
+// in the queue (remaining pending) until one of the slots is released.
+// Zero capacity means "no limit", Acquire() never blocks in that case.
+// NB: tasks restored from containers on shim restart are not accounted
+type taskQueue struct {
+	slots chan struct{}
+	depth atomic.Int64
+}
+
+func newTaskQueue(capacity int) *taskQueue {
+	q := &taskQueue{}
+	if capacity > 0 {
+		q.slots = make(chan struct{}, capacity)
+	}
+	return q
+}
+
+// Acquire blocks until a slot is available or ctx is done
+// Each successful Acquire() call must be paired with Release()
+func (q *taskQueue) Acquire(ctx context.Context) error {
+	if q.slots == nil {
+		return nil
+	}
+	select {
+	case q.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	q.depth.Add(1)
+	taskQueueDepth.Inc()
+	defer func() {
+		q.depth.Add(-1)
+		taskQueueDepth.Dec()
+	}()
+	select {
+	case q.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *taskQueue) Release() {
+	if q.slots == nil {
+		return
+	}
+	<-q.slots
+}
+
+// Depth returns the number of tasks currently waiting for a slot
+func (q *taskQueue) Depth() int {
+	return int(q.depth.Load())
+}
@@ -0,0 +1,94 @@
+package shim
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTaskQueue_Unlimited(t *testing.T) {
+	q := newTaskQueue(0)
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, q.Acquire(context.Background()))
+	}
+	assert.Equal(t, 0, q.Depth())
+}
+
+func TestTaskQueue_AcquireBlocksUntilRelease(t *testing.T) {
+	q := newTaskQueue(1)
+	require.NoError(t, q.Acquire(context.Background()))
+
+	acquired := make(chan struct{})
+	go func() {
+		assert.NoError(t, q.Acquire(context.Background()))
+		close(acquired)
+	}()
+	require.Eventually(t, func() bool { return q.Depth() == 1 }, time.Second, time.Millisecond)
+	select {
+	case <-acquired:
+		t.Fatal("acquired beyond capacity")
+	default:
+	}
+
+	q.Release()
+	<-acquired
+	assert.Equal(t, 0, q.Depth())
+}
+
+func TestTaskQueue_AcquireCanceled(t *testing.T) {
+	q := newTaskQueue(1)
+	require.NoError(t, q.Acquire(context.Background()))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := q.Acquire(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 0, q.Depth())
+}
+
+func TestDockerRunner_QueuedDurationRecorded(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{maxConcurrentTasks: 1})
+	initialSampleCount := getHistogramSampleCount(t, taskQueueSeconds)
+
+	first := createTaskConfig(t)
+	second := createTaskConfig(t)
+	require.NoError(t, runner.Submit(context.Background(), first))
+	go func() { _ = runner.Run(context.Background(), first.ID) }()
+	waitTaskStatus(t, runner, first.ID, TaskStatusRunning)
+
+	require.NoError(t, runner.Submit(context.Background(), second))
+	runErr := make(chan error)
+	go func() { runErr <- runner.Run(context.Background(), second.ID) }()
+	require.Eventually(t, func() bool { return runner.queue.Depth() == 1 }, 5*time.Second, time.Millisecond)
+	// beyond capacity, the second task is queued
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, TaskStatusPending, runner.TaskInfo(second.ID).Status)
+	assert.Equal(t, initialSampleCount+1, getHistogramSampleCount(t, taskQueueSeconds))
+
+	client.exitContainer(runner.TaskInfo(first.ID).ContainerID, 0)
+	waitTaskStatus(t, runner, second.ID, TaskStatusRunning)
+
+	assert.Equal(t, 0, runner.queue.Depth())
+	assert.Equal(t, initialSampleCount+2, getHistogramSampleCount(t, taskQueueSeconds))
+	queuedDuration := runner.TaskInfo(second.ID).QueuedDuration
+	assert.GreaterOrEqual(t, queuedDuration, 0.05)
+	// recorded once the task has started, not growing anymore
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, queuedDuration, runner.TaskInfo(second.ID).QueuedDuration)
+
+	client.exitContainer(runner.TaskInfo(second.ID).ContainerID, 0)
+	assert.NoError(t, <-runErr)
+	assert.Equal(t, TaskStatusTerminated, runner.TaskInfo(second.ID).Status)
+}
+
+func getHistogramSampleCount(t *testing.T, histogram prometheus.Histogram) uint64 {
+	var m dto.Metric
+	require.NoError(t, histogram.Write(&m))
+	return m.GetHistogram().GetSampleCount()
+}
@@ -0,0 +1,58 @@
+package shim
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/dstackai/dstack/runner/internal/log"
+	"github.com/dstackai/dstack/runner/internal/shim/host"
+)
+
+// RDMAInfo is reported in host info, so that multi-node jobs (e.g., NCCL over InfiniBand)
+// are scheduled on hosts with usable RDMA only
+type RDMAInfo struct {
+	// At least one device has an active port, and verbs devices can be mounted into containers,
+	// see configureHpcNetworkingIfAvailable()
+	Available   bool              `json:"available"`
+	DeviceCount int               `json:"device_count"`
+	Devices     []host.RDMADevice `json:"devices"`
+}
+
+// getRDMAInfo never fails, errors are logged and no devices are reported then
+func (d *DockerRunner) getRDMAInfo(ctx context.Context) RDMAInfo {
+	devices, err := host.GetRDMADevices(d.infinibandSysfsDir)
+	if err != nil {
+		log.Error(ctx, "failed to get RDMA devices", "err", err)
+		devices = []host.RDMADevice{}
+	}
+	info := RDMAInfo{DeviceCount: len(devices), Devices: devices}
+	if slices.ContainsFunc(devices, host.RDMADevice.IsActive) {
+		if _, err := os.Stat(d.infinibandDevDir); err == nil {
+			info.Available = true
+		}
+	}
+	return info
+}
+
+// validateRDMA rejects tasks requesting RDMA on hosts without it
+func (d *DockerRunner) validateRDMA(cfg TaskConfig) error {
+	if !cfg.RDMA {
+		return nil
+	}
+	info := d.getRDMAInfo(context.Background())
+	if info.Available {
+		return nil
+	}
+	var reason string
+	switch {
+	case info.DeviceCount == 0:
+		reason = "no RDMA devices found"
+	case !slices.ContainsFunc(info.Devices, host.RDMADevice.IsActive):
+		reason = fmt.Sprintf("none of %d RDMA devices has an active port", info.DeviceCount)
+	default:
+		reason = d.infinibandDevDir + " not found"
+	}
+	return fmt.Errorf("%w: rdma is requested, but RDMA is not available on this host: %s", ErrInvalidConfig, reason)
+}
@@ -0,0 +1,103 @@
+package shim
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dstackai/dstack/runner/internal/shim/host"
+)
+
+// setRDMAFixture points the runner to fake sysfs and dev dirs with one InfiniBand device
+// with the port in the given state, or with no devices if state is empty
+func setRDMAFixture(t *testing.T, runner *DockerRunner, state string, devDir bool) {
+	t.Helper()
+	root := t.TempDir()
+	runner.infinibandSysfsDir = filepath.Join(root, "sys", "class", "infiniband")
+	runner.infinibandDevDir = filepath.Join(root, "dev", "infiniband")
+	if state != "" {
+		portDir := filepath.Join(runner.infinibandSysfsDir, "mlx5_0", "ports", "1")
+		require.NoError(t, os.MkdirAll(portDir, 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(portDir, "state"), []byte(state+"\n"), 0o644))
+		require.NoError(t, os.WriteFile(filepath.Join(portDir, "link_layer"), []byte("InfiniBand\n"), 0o644))
+	}
+	if devDir {
+		require.NoError(t, os.MkdirAll(runner.infinibandDevDir, 0o755))
+	}
+}
+
+func TestDockerRunner_GetRDMAInfo(t *testing.T) {
+	testCases := []struct {
+		name        string
+		state       string
+		devDir      bool
+		available   bool
+		deviceCount int
+	}{
+		{"present", "4: ACTIVE", true, true, 1},
+		{"absent", "", false, false, 0},
+		{"port down", "1: DOWN", true, false, 1},
+		{"no dev dir", "4: ACTIVE", false, false, 1},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{})
+			setRDMAFixture(t, runner, tc.state, tc.devDir)
+
+			info := runner.getRDMAInfo(context.Background())
+			assert.Equal(t, tc.available, info.Available)
+			assert.Equal(t, tc.deviceCount, info.DeviceCount)
+			assert.Len(t, info.Devices, tc.deviceCount)
+			assert.Equal(t, info, runner.Resources(context.Background()).RDMA)
+		})
+	}
+}
+
+func TestDockerRunner_RDMA_Present(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	setRDMAFixture(t, runner, "4: ACTIVE", true)
+	cfg := createTaskConfig(t)
+	cfg.RDMA = true
+
+	containerID := runTask(t, runner, cfg)
+	ctr, err := client.getContainer(containerID)
+	require.NoError(t, err)
+	assert.Contains(t, ctr.hostConfig.Devices, container.DeviceMapping{
+		PathOnHost: runner.infinibandDevDir, PathInContainer: host.InfinibandDevDir, CgroupPermissions: "rwm",
+	})
+}
+
+func TestDockerRunner_RDMA_Absent(t *testing.T) {
+	testCases := []struct {
+		name   string
+		state  string
+		devDir bool
+		msg    string
+	}{
+		{"no devices", "", false, "no RDMA devices found"},
+		{"port down", "1: DOWN", true, "none of 1 RDMA devices has an active port"},
+		{"no dev dir", "4: ACTIVE", false, "infiniband not found"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{})
+			setRDMAFixture(t, runner, tc.state, tc.devDir)
+			cfg := createTaskConfig(t)
+			cfg.RDMA = true
+
+			err := runner.Submit(context.Background(), cfg)
+			assert.ErrorIs(t, err, ErrInvalidConfig)
+			assert.ErrorContains(t, err, tc.msg)
+
+			// not required, devices are not mounted then
+			cfg.RDMA = false
+			assert.NoError(t, runner.Submit(context.Background(), cfg))
+		})
+	}
+}
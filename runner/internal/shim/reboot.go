@@ -0,0 +1,222 @@
+package shim
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+
+	"github.com/dstackai/dstack/runner/internal/log"
+	"github.com/dstackai/dstack/runner/internal/shim/host"
+)
+
+// Persisted state may contain secrets, it's only accessible to the shim user
+const (
+	stateFileMode        = 0o600
+	stateDirMode         = 0o700
+	restartIntentFileExt = ".json"
+)
+
+const rebootRecoveryReason = "HOST_REBOOT"
+
+// Overridden in tests
+var getHostBootTime = host.GetBootTime
+
+// restartIntents persists configs of tasks submitted with TaskConfig.RestartOnReboot, one
+// <task ID>.json file per task, so that the tasks can be recreated after the host reboot,
+// see recoverTasksAfterReboot(). Configs contain secrets (registry credentials, env, etc.),
+// the files are only accessible to the shim user. Empty dir disables persistence
+type restartIntents struct {
+	dir string
+}
+
+func newRestartIntents(dir string) *restartIntents {
+	return &restartIntents{dir: dir}
+}
+
+func (ri *restartIntents) Enabled() bool {
+	return ri.dir != ""
+}
+
+// Save writes the config atomically, replacing the existing intent of the task, if any
+func (ri *restartIntents) Save(cfg TaskConfig) error {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("marshal config: %w", err)
+	}
+	return writeStateFile(ri.path(cfg.ID), data)
+}
+
+// writeStateFile writes the file atomically, creating the dir if it doesn't exist
+func writeStateFile(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, stateDirMode); err != nil {
+		return fmt.Errorf("create state dir: %w", err)
+	}
+	file, err := os.CreateTemp(dir, filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer func() { _ = os.Remove(file.Name()) }()
+	if err := file.Chmod(stateFileMode); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("chmod temp file: %w", err)
+	}
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("write temp file: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("sync temp file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("close temp file: %w", err)
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return fmt.Errorf("rename temp file: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the intent of the task. It's not an error if the task has no intent
+func (ri *restartIntents) Delete(taskID string) error {
+	if !ri.Enabled() {
+		return nil
+	}
+	if err := os.Remove(ri.path(taskID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Load returns configs of all persisted intents. Unreadable files are logged and skipped
+func (ri *restartIntents) Load(ctx context.Context) ([]TaskConfig, error) {
+	if !ri.Enabled() {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(ri.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read state dir: %w", err)
+	}
+	var cfgs []TaskConfig
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, restartIntentFileExt) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(ri.dir, name))
+		if err != nil {
+			log.Error(ctx, "failed to read restart intent", "file", name, "err", err)
+			continue
+		}
+		var cfg TaskConfig
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			log.Error(ctx, "failed to parse restart intent", "file", name, "err", err)
+			continue
+		}
+		if cfg.ID+restartIntentFileExt != name {
+			log.Error(ctx, "restart intent does not match task ID", "file", name, "task", cfg.ID)
+			continue
+		}
+		cfgs = append(cfgs, cfg)
+	}
+	return cfgs, nil
+}
+
+func (ri *restartIntents) path(taskID string) string {
+	return filepath.Join(ri.dir, taskID+restartIntentFileExt)
+}
+
+// deleteRestartIntent is called once the task no longer should be recovered after the reboot,
+// that is, it is finished on its own, stopped on request, or removed. Tasks stopped
+// on the shim shutdown keep the intent, as the shutdown may be caused by the reboot
+func (d *DockerRunner) deleteRestartIntent(ctx context.Context, taskID string) {
+	if err := d.restartIntents.Delete(taskID); err != nil {
+		log.Error(ctx, "failed to delete restart intent", "task", taskID, "err", err)
+	}
+}
+
+// recoverTasksAfterReboot recreates tasks submitted with TaskConfig.RestartOnReboot that were
+// interrupted by the host reboot, that is, either their containers are gone, or the containers
+// were started before the host was booted and are not running now.
+// Must be called after restoreStateFromContainers(). The old container, if any, is removed,
+// the task is submitted again with the persisted config and run in background. Dependencies
+// and GPU reservations are dropped, as they were satisfied before the reboot
+func (d *DockerRunner) recoverTasksAfterReboot(ctx context.Context) {
+	cfgs, err := d.restartIntents.Load(ctx)
+	if err != nil {
+		log.Error(ctx, "failed to load restart intents", "err", err)
+		return
+	}
+	if len(cfgs) == 0 {
+		return
+	}
+	bootTime, err := getHostBootTime(ctx)
+	if err != nil {
+		// Without the boot time, only tasks without containers are recovered
+		log.Error(ctx, "failed to get host boot time", "err", err)
+	}
+	for _, cfg := range cfgs {
+		if task, ok := d.tasks.Get(cfg.ID); ok {
+			if !d.isInterruptedByReboot(ctx, task, bootTime) {
+				log.Debug(ctx, "skip recovering task: not interrupted by reboot", "task", cfg.ID, "status", task.Status)
+				continue
+			}
+			removeOptions := container.RemoveOptions{Force: true, RemoveVolumes: true}
+			if err := d.client.ContainerRemove(ctx, task.containerID, removeOptions); err != nil && !errdefs.IsNotFound(err) {
+				log.Error(ctx, "skip recovering task: failed to remove container", "task", cfg.ID, "err", err)
+				continue
+			}
+			d.tasks.Delete(cfg.ID)
+		}
+		cfg.DependsOn = nil
+		cfg.GPUReservation = ""
+		// the intent keeps the submitted config, the state may have been changed by Update()
+		if state, ok, err := d.taskStates.Load(cfg.ID); err != nil {
+			log.Error(ctx, "failed to load task state", "task", cfg.ID, "err", err)
+		} else if ok {
+			cfg.Annotations, cfg.LeaseDuration = state.Annotations, state.LeaseDuration
+		}
+		if err := d.Submit(ctx, cfg); err != nil {
+			log.Error(ctx, "failed to recover task after reboot", "task", cfg.ID, "err", err)
+			continue
+		}
+		d.audit.Record(ctx, AuditRecord{Action: AuditActionRecover, TaskID: cfg.ID, TraceID: cfg.TraceID, Reason: rebootRecoveryReason})
+		d.tasks.RecordEvent(cfg.ID, TaskHistoryEvent{Type: TaskHistoryEventRecovered, Message: rebootRecoveryReason})
+		log.Info(ctx, "recovering task after reboot", "task", cfg.ID)
+		runCtx := context.WithoutCancel(ctx)
+		go func() {
+			if err := d.Run(runCtx, cfg.ID); err != nil {
+				log.Error(runCtx, "failed to run recovered task", "task", cfg.ID, "err", err)
+			}
+		}()
+	}
+}
+
+func (d *DockerRunner) isInterruptedByReboot(ctx context.Context, task Task, bootTime time.Time) bool {
+	if task.Status == TaskStatusRunning || bootTime.IsZero() {
+		return false
+	}
+	containerFull, err := d.client.ContainerInspect(ctx, task.containerID)
+	if err != nil {
+		log.Error(ctx, "failed to inspect container", "task", task.ID, "err", err)
+		return false
+	}
+	startedAt, err := time.Parse(time.RFC3339Nano, containerFull.State.StartedAt)
+	if err != nil {
+		log.Error(ctx, "failed to parse container start time", "task", task.ID, "err", err)
+		return false
+	}
+	return startedAt.Before(bootTime)
+}
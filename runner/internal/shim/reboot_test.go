@@ -0,0 +1,214 @@
+package shim
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setHostBootTime(t *testing.T, bootTime time.Time, err error) {
+	getHostBootTimeOrig := getHostBootTime
+	getHostBootTime = func(context.Context) (time.Time, error) { return bootTime, err }
+	t.Cleanup(func() { getHostBootTime = getHostBootTimeOrig })
+}
+
+// addTaskContainer adds the container of the task as if it was run by the shim before the reboot
+func addTaskContainer(client *fakeDockerClient, taskID string, running bool, exitCode int64, startedAt time.Time) string {
+	id := "old-" + taskID
+	client.addContainer(&fakeContainer{
+		id:   id,
+		name: id,
+		config: &container.Config{Labels: map[string]string{
+			LabelKeyIsTask: LabelValueTrue,
+			LabelKeyTaskID: taskID,
+		}},
+		running:   running,
+		exitCode:  exitCode,
+		startedAt: startedAt,
+	})
+	return id
+}
+
+func TestRestartIntents_SaveLoadDelete(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state")
+	intents := newRestartIntents(dir)
+	ctx := context.Background()
+
+	cfgs, err := intents.Load(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, cfgs)
+
+	cfg := createTaskConfig(t)
+	cfg.RestartOnReboot = true
+	cfg.RegistryPassword = "hunter2"
+	require.NoError(t, intents.Save(cfg))
+	cfg.ImageName = "alpine"
+	require.NoError(t, intents.Save(cfg))
+	info, err := os.Stat(filepath.Join(dir, cfg.ID+".json"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(stateFileMode), info.Mode().Perm())
+	// unrelated and invalid files are skipped
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other.txt"), []byte("x"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "broken.json"), []byte("{"), 0o600))
+
+	cfgs, err = intents.Load(ctx)
+	require.NoError(t, err)
+	require.Len(t, cfgs, 1)
+	assert.Equal(t, cfg.ID, cfgs[0].ID)
+	assert.Equal(t, "alpine", cfgs[0].ImageName)
+	assert.Equal(t, "hunter2", cfgs[0].RegistryPassword)
+
+	require.NoError(t, intents.Delete(cfg.ID))
+	// already deleted
+	require.NoError(t, intents.Delete(cfg.ID))
+	cfgs, err = intents.Load(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, cfgs)
+}
+
+func TestDockerRunner_Submit_RestartOnReboot_NoStateDir(t *testing.T) {
+	runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.RestartOnReboot = true
+
+	err := runner.Submit(context.Background(), cfg)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+}
+
+func TestDockerRunner_RestartIntent_Lifecycle(t *testing.T) {
+	dir := t.TempDir()
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{stateDir: dir})
+	ctx := context.Background()
+	hasIntent := func(taskID string) bool {
+		_, err := os.Stat(filepath.Join(dir, taskID+".json"))
+		return err == nil
+	}
+
+	noRestart := createTaskConfig(t)
+	runTask(t, runner, noRestart)
+	assert.False(t, hasIntent(noRestart.ID))
+
+	// kept on the shim shutdown, deleted on Remove()
+	shutdown := createTaskConfig(t)
+	shutdown.RestartOnReboot = true
+	shutdown.ShutdownBehavior = ShutdownBehaviorFast
+	runTask(t, runner, shutdown)
+	assert.True(t, hasIntent(shutdown.ID))
+
+	// deleted on Terminate()
+	stopped := createTaskConfig(t)
+	stopped.RestartOnReboot = true
+	runTask(t, runner, stopped)
+	require.NoError(t, runner.Terminate(ctx, stopped.ID, nil, "TERMINATED_BY_SERVER", ""))
+	assert.False(t, hasIntent(stopped.ID))
+
+	// deleted once finished on its own
+	done := createTaskConfig(t)
+	done.RestartOnReboot = true
+	containerID := runTask(t, runner, done)
+	client.exitContainer(containerID, 0)
+	waitTaskStatus(t, runner, done.ID, TaskStatusTerminated)
+	require.Eventually(t, func() bool { return !hasIntent(done.ID) }, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, runner.Terminate(ctx, noRestart.ID, nil, "TERMINATED_BY_SERVER", ""))
+	require.NoError(t, runner.Shutdown(ctx, time.Second))
+	assert.Equal(t, TaskStatusTerminated, runner.TaskInfo(shutdown.ID).Status)
+	assert.True(t, hasIntent(shutdown.ID))
+	require.NoError(t, runner.Remove(ctx, shutdown.ID))
+	assert.False(t, hasIntent(shutdown.ID))
+}
+
+func TestDockerRunner_RecoverTasksAfterReboot(t *testing.T) {
+	bootTime := time.Now().Add(-time.Minute)
+	beforeBoot := bootTime.Add(-time.Hour)
+	setHostBootTime(t, bootTime, nil)
+	dir := t.TempDir()
+	auditPath := filepath.Join(t.TempDir(), "audit.jsonl")
+	intents := newRestartIntents(dir)
+	client := newFakeDockerClient()
+	newConfig := func(restartOnReboot bool) TaskConfig {
+		cfg := createTaskConfig(t)
+		cfg.RestartOnReboot = restartOnReboot
+		if restartOnReboot {
+			require.NoError(t, intents.Save(cfg))
+		}
+		return cfg
+	}
+
+	// the container is gone
+	goneRestart := newConfig(true)
+	goneNoRestart := newConfig(false)
+	// the container was killed by the reboot
+	exitedRestart := newConfig(true)
+	exitedRestartContainerID := addTaskContainer(client, exitedRestart.ID, false, 255, beforeBoot)
+	exitedNoRestart := newConfig(false)
+	exitedNoRestartContainerID := addTaskContainer(client, exitedNoRestart.ID, false, 255, beforeBoot)
+	// the container survived the reboot, e.g., it has a Docker restart policy
+	runningRestart := newConfig(true)
+	runningRestartContainerID := addTaskContainer(client, runningRestart.ID, true, 0, bootTime.Add(time.Second))
+	// the container failed after the reboot, e.g., the shim has been restarted
+	failedAfterBoot := newConfig(true)
+	failedAfterBootContainerID := addTaskContainer(client, failedAfterBoot.ID, false, 1, bootTime.Add(time.Second))
+
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{stateDir: dir, auditLog: AuditLogConfig{Path: auditPath}})
+
+	for _, taskID := range []string{goneRestart.ID, exitedRestart.ID} {
+		waitTaskStatus(t, runner, taskID, TaskStatusRunning)
+		containerID := runner.TaskInfo(taskID).ContainerID
+		assert.NotEqual(t, exitedRestartContainerID, containerID)
+		_, err := client.getContainer(containerID)
+		assert.NoError(t, err)
+	}
+	_, err := client.getContainer(exitedRestartContainerID)
+	assert.Error(t, err, "the old container must be removed")
+
+	assert.Equal(t, TaskInfo{}, runner.TaskInfo(goneNoRestart.ID))
+	info := runner.TaskInfo(exitedNoRestart.ID)
+	assert.Equal(t, TaskStatusFailed, info.Status)
+	assert.Equal(t, exitedNoRestartContainerID, info.ContainerID)
+	info = runner.TaskInfo(runningRestart.ID)
+	assert.Equal(t, TaskStatusRunning, info.Status)
+	assert.Equal(t, runningRestartContainerID, info.ContainerID)
+	info = runner.TaskInfo(failedAfterBoot.ID)
+	assert.Equal(t, TaskStatusFailed, info.Status)
+	assert.Equal(t, failedAfterBootContainerID, info.ContainerID)
+
+	var recovered []string
+	for _, record := range readAuditRecords(t, auditPath) {
+		if record.Action == AuditActionRecover {
+			assert.Equal(t, rebootRecoveryReason, record.Reason)
+			recovered = append(recovered, record.TaskID)
+		}
+	}
+	assert.ElementsMatch(t, []string{goneRestart.ID, exitedRestart.ID}, recovered)
+}
+
+func TestDockerRunner_RecoverTasksAfterReboot_NoBootTime(t *testing.T) {
+	setHostBootTime(t, time.Time{}, errors.New("no boot time"))
+	dir := t.TempDir()
+	intents := newRestartIntents(dir)
+	client := newFakeDockerClient()
+	gone := createTaskConfig(t)
+	gone.RestartOnReboot = true
+	require.NoError(t, intents.Save(gone))
+	exited := createTaskConfig(t)
+	exited.RestartOnReboot = true
+	require.NoError(t, intents.Save(exited))
+	exitedContainerID := addTaskContainer(client, exited.ID, false, 255, time.Now().Add(-time.Hour))
+
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{stateDir: dir})
+
+	// only tasks without containers are recovered
+	waitTaskStatus(t, runner, gone.ID, TaskStatusRunning)
+	info := runner.TaskInfo(exited.ID)
+	assert.Equal(t, TaskStatusFailed, info.Status)
+	assert.Equal(t, exitedContainerID, info.ContainerID)
+}
@@ -0,0 +1,68 @@
+package shim
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/distribution/reference"
+)
+
+// registryMirrors is a registry domain: mirror mapping, where mirror is a host[:port],
+// optionally followed by a path prefix, e.g., `mirror.local:5000/dockerhub`
+type registryMirrors map[string]string
+
+// parseRegistryMirrors parses `registry=mirror` rules, e.g., `docker.io=mirror.local:5000`
+func parseRegistryMirrors(rules []string) (registryMirrors, error) {
+	mirrors := make(registryMirrors, len(rules))
+	for _, rule := range rules {
+		registry, mirror, ok := strings.Cut(rule, "=")
+		registry = normalizeRegistryDomain(strings.TrimSpace(registry))
+		mirror = strings.TrimSuffix(strings.TrimSpace(mirror), "/")
+		if !ok || registry == "" || mirror == "" {
+			return nil, fmt.Errorf("invalid registry mirror rule %q: must be registry=mirror", rule)
+		}
+		if strings.Contains(mirror, "://") {
+			return nil, fmt.Errorf("invalid registry mirror rule %q: mirror must not contain scheme", rule)
+		}
+		if _, ok := mirrors[registry]; ok {
+			return nil, fmt.Errorf("invalid registry mirror rule %q: duplicate registry %s", rule, registry)
+		}
+		mirrors[registry] = mirror
+	}
+	return mirrors, nil
+}
+
+// Rewrite returns the image reference pointing to the mirror of the image's registry,
+// keeping the repository path, tag, and digest. ok is false if there is no mirror for
+// the registry or the reference cannot be parsed
+func (m registryMirrors) Rewrite(image string) (mirrorImage string, ok bool) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", false
+	}
+	mirror, ok := m[reference.Domain(named)]
+	if !ok {
+		return "", false
+	}
+	mirrorImage = mirror + "/" + reference.Path(named)
+	if tagged, isTagged := named.(reference.Tagged); isTagged {
+		mirrorImage += ":" + tagged.Tag()
+	}
+	if digested, isDigested := named.(reference.Digested); isDigested {
+		mirrorImage += "@" + digested.Digest().String()
+	}
+	// the mirror itself may be malformed, e.g., contain uppercase letters
+	if _, err := reference.ParseNormalizedNamed(mirrorImage); err != nil {
+		return "", false
+	}
+	return mirrorImage, true
+}
+
+// Docker Hub is known by several names, reference.Domain() returns docker.io
+func normalizeRegistryDomain(registry string) string {
+	switch registry {
+	case "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return "docker.io"
+	}
+	return registry
+}
@@ -0,0 +1,108 @@
+package shim
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRegistryMirrors(t *testing.T) {
+	mirrors, err := parseRegistryMirrors([]string{
+		"docker.io=mirror.local:5000",
+		"index.docker.io=mirror.local:5000",
+		"ghcr.io = mirror.local:5000/ghcr/",
+	})
+	assert.ErrorContains(t, err, "duplicate registry docker.io")
+	assert.Nil(t, mirrors)
+
+	mirrors, err = parseRegistryMirrors([]string{
+		"registry-1.docker.io=mirror.local:5000",
+		"ghcr.io = mirror.local:5000/ghcr/",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, registryMirrors{
+		"docker.io": "mirror.local:5000",
+		"ghcr.io":   "mirror.local:5000/ghcr",
+	}, mirrors)
+}
+
+func TestParseRegistryMirrors_Errors(t *testing.T) {
+	for _, rule := range []string{"docker.io", "=mirror.local", "docker.io=", "docker.io=https://mirror.local"} {
+		_, err := parseRegistryMirrors([]string{rule})
+		assert.ErrorContains(t, err, "invalid registry mirror rule", rule)
+	}
+}
+
+func TestRegistryMirrors_Rewrite(t *testing.T) {
+	mirrors := registryMirrors{
+		"docker.io":         "mirror.local:5000",
+		"ghcr.io":           "mirror.local:5000/ghcr",
+		"registry.local:80": "mirror.local",
+	}
+	digest := "sha256:" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	testCases := []struct {
+		image, expected string
+	}{
+		// Docker Hub official images
+		{"ubuntu", "mirror.local:5000/library/ubuntu"},
+		{"ubuntu:22.04", "mirror.local:5000/library/ubuntu:22.04"},
+		{"library/ubuntu:22.04", "mirror.local:5000/library/ubuntu:22.04"},
+		{"docker.io/library/ubuntu:22.04", "mirror.local:5000/library/ubuntu:22.04"},
+		// Docker Hub user images
+		{"dstackai/base:py3.12-0.6-cuda-12.1", "mirror.local:5000/dstackai/base:py3.12-0.6-cuda-12.1"},
+		{"docker.io/dstackai/base", "mirror.local:5000/dstackai/base"},
+		// explicit registries
+		{"ghcr.io/dstackai/dstack:latest", "mirror.local:5000/ghcr/dstackai/dstack:latest"},
+		{"registry.local:80/team/image:v1", "mirror.local/team/image:v1"},
+		// digests
+		{"ubuntu@" + digest, "mirror.local:5000/library/ubuntu@" + digest},
+		{"ubuntu:22.04@" + digest, "mirror.local:5000/library/ubuntu:22.04@" + digest},
+	}
+	for _, tc := range testCases {
+		mirrorImage, ok := mirrors.Rewrite(tc.image)
+		assert.True(t, ok, tc.image)
+		assert.Equal(t, tc.expected, mirrorImage, tc.image)
+	}
+}
+
+func TestRegistryMirrors_Rewrite_NoMirror(t *testing.T) {
+	mirrors := registryMirrors{"docker.io": "mirror.local:5000"}
+	for _, image := range []string{"ghcr.io/dstackai/dstack", "registry.local:5000/image", "Invalid:Image"} {
+		_, ok := mirrors.Rewrite(image)
+		assert.False(t, ok, image)
+	}
+	_, ok := registryMirrors(nil).Rewrite("ubuntu")
+	assert.False(t, ok)
+}
+
+func TestPullImage_Mirror(t *testing.T) {
+	client := newFakeDockerClient()
+	mirrors := registryMirrors{"docker.io": "mirror.local:5000"}
+	cfg := TaskConfig{ImageName: "ubuntu", RegistryUsername: "user", RegistryPassword: "password"}
+
+	require.NoError(t, pullImage(context.Background(), client, cfg, mirrors, false, nil))
+
+	assert.Equal(t, []string{"mirror.local:5000/library/ubuntu:latest"}, client.pulledRefs)
+	assert.Equal(t, map[string]string{"ubuntu:latest": "mirror.local:5000/library/ubuntu:latest"}, client.tags)
+}
+
+func TestPullImage_MirrorFailed(t *testing.T) {
+	mirrors := registryMirrors{"docker.io": "mirror.local:5000"}
+	cfg := TaskConfig{ImageName: "ubuntu:22.04"}
+
+	client := newFakeDockerClient()
+	client.injectErrors("ImagePull", errors.New("connection refused"))
+	err := pullImage(context.Background(), client, cfg, mirrors, false, nil)
+	assert.ErrorContains(t, err, "failed to pull image from mirror")
+	assert.Equal(t, []string{"mirror.local:5000/library/ubuntu:22.04"}, client.pulledRefs)
+
+	client = newFakeDockerClient()
+	client.injectErrors("ImagePull", errors.New("connection refused"))
+	err = pullImage(context.Background(), client, cfg, mirrors, true, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"mirror.local:5000/library/ubuntu:22.04", "ubuntu:22.04"}, client.pulledRefs)
+	assert.Equal(t, map[string]string{}, client.tags)
+}
@@ -0,0 +1,282 @@
+package shim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/dstackai/dstack/runner/internal/log"
+)
+
+const (
+	// The replacement container must become healthy within this period, otherwise it's rolled back
+	defaultReplaceHealthTimeout = 5 * time.Minute
+	// If the image has no HEALTHCHECK, the replacement container is considered healthy
+	// once it has been running for this long
+	defaultReplaceHealthyAfter = 10 * time.Second
+	// How often the replacement container state is checked
+	defaultReplaceCheckInterval = time.Second
+)
+
+// Replace starts a new container of the running task with the new config, waits until it's
+// healthy, then switches the task to the new container and stops the old one. If the new
+// container fails to start or doesn't become healthy, it's removed, and the task keeps running
+// the old container. Both containers run at the same time during the replacement:
+//   - The new container gets the same GPUs as the old one, GPU allocation cannot be changed.
+//   - With bridge network, both containers get their own ephemeral host ports, the task
+//     ports are updated once switched. With host network, both containers share the host ports,
+//     that is, the application must handle it, e.g., wait for the port to be released.
+//   - Volumes and instance mounts are prepared once for the task and cannot be changed.
+//
+// Replace holds the caller until the replacement is finished or rolled back
+func (d *DockerRunner) Replace(ctx context.Context, taskID string, cfg TaskConfig) (TaskInfo, error) {
+	task, ok := d.tasks.Get(taskID)
+	if !ok {
+		return TaskInfo{}, fmt.Errorf("task %s: %w", taskID, ErrNotFound)
+	}
+	// The task keeps its trace ID unless the new config sets one
+	if cfg.TraceID == "" {
+		cfg.TraceID = task.config.TraceID
+	}
+	ctx = withTraceID(ctx, cfg.TraceID)
+	if task.Status != TaskStatusRunning || !task.containerStarted {
+		return TaskInfo{}, fmt.Errorf("%w: cannot replace task %s with %s status", ErrRequest, task.ID, task.Status)
+	}
+	if err := d.validateReplaceConfig(task, cfg); err != nil {
+		return TaskInfo{}, err
+	}
+	// Only one replacement per task at a time
+	if !d.replacing.Add(task.ID) {
+		return TaskInfo{}, fmt.Errorf("%w: task %s is already being replaced", ErrRequest, task.ID)
+	}
+	defer d.replacing.Delete(task.ID)
+
+	replacement := task
+	replacement.config = cfg
+	// Must differ from the current container name, which is derived from the same (name, id) pair
+	replacement.containerName = generateUniqueName(cfg.Name, fmt.Sprintf("%s/%d", cfg.ID, time.Now().UnixNano()), d.nameSuffixLen)
+	replacement.containerID = ""
+	replacement.runnerDir = ""
+	log.Debug(ctx, "replacing task container", "task", task.ID, "old", task.containerName, "new", replacement.containerName)
+
+	if err := d.startReplacement(ctx, &replacement); err != nil {
+		d.removeReplacement(ctx, &replacement)
+		return TaskInfo{}, err
+	}
+	if err := d.waitReplacementStarted(ctx, &replacement); err != nil {
+		log.Info(ctx, "rolling back task container replacement", "task", task.ID, "err", err)
+		d.removeReplacement(ctx, &replacement)
+		return TaskInfo{}, err
+	}
+	if err := d.waitReplacementHealthy(ctx, replacement.containerID); err != nil {
+		log.Info(ctx, "rolling back task container replacement", "task", task.ID, "err", err)
+		d.removeReplacement(ctx, &replacement)
+		return TaskInfo{}, err
+	}
+
+	if err := d.switchToReplacement(ctx, task, &replacement); err != nil {
+		d.removeReplacement(ctx, &replacement)
+		return TaskInfo{}, err
+	}
+	log.Info(ctx, "switched task to the new container", "task", task.ID, "container", replacement.containerName)
+	d.audit.Record(ctx, AuditRecord{
+		Action: AuditActionReplace, TaskID: task.ID, TraceID: cfg.TraceID, ContainerID: replacement.containerID,
+		Config: redactTaskConfig(cfg),
+	})
+	d.tasks.RecordEvent(task.ID, TaskHistoryEvent{Type: TaskHistoryEventContainerReplaced, ContainerID: replacement.containerID})
+
+	// Run() notices the switch once the old container exits and keeps waiting for the new one
+	d.removeReplaced(ctx, &task)
+	return d.TaskInfo(taskID), nil
+}
+
+// switchToReplacement switches the task to the replacement container. The switch is serialized
+// with Terminate(), the task could also be terminated or removed while the replacement was starting,
+// in which case the replacement must not be used
+func (d *DockerRunner) switchToReplacement(ctx context.Context, task Task, replacement *Task) error {
+	task.Lock(ctx)
+	defer func() { task.Release(ctx) }()
+	current, ok := d.tasks.Get(task.ID)
+	if !ok || current.mu != task.mu || current.Status != TaskStatusRunning || current.containerID != task.containerID {
+		return fmt.Errorf("%w: task %s has changed during replacement", ErrRequest, task.ID)
+	}
+	current.config = replacement.config
+	current.containerName = replacement.containerName
+	current.containerID = replacement.containerID
+	current.runnerDir = replacement.runnerDir
+	current.ports = replacement.ports
+	current.diagnostics = nil
+	if err := d.tasks.Update(current); err != nil {
+		return fmt.Errorf("%w: failed to update task %s: %w", ErrInternal, task.ID, err)
+	}
+	return nil
+}
+
+// validateReplaceConfig checks the new config and the parts that must not change
+func (d *DockerRunner) validateReplaceConfig(task Task, cfg TaskConfig) error {
+	if cfg.ID != task.ID {
+		return fmt.Errorf("%w: config id %s doesn't match task id %s", ErrInvalidConfig, cfg.ID, task.ID)
+	}
+	if cfg.GPUReservation != "" {
+		return fmt.Errorf("%w: gpu_reservation cannot be used on replacement", ErrInvalidConfig)
+	}
+	old := task.config
+	immutable := []struct {
+		name     string
+		old, new any
+	}{
+		{"gpu", old.GPU, cfg.GPU},
+		{"gpu_memory_fraction", old.GPUMemoryFraction, cfg.GPUMemoryFraction},
+		{"mps_thread_percentage", old.MPSThreadPercentage, cfg.MPSThreadPercentage},
+		{"mps_memory_limit_mb", old.MPSMemoryLimitMB, cfg.MPSMemoryLimitMB},
+		{"network_mode", old.NetworkMode, cfg.NetworkMode},
+		{"volumes", old.Volumes, cfg.Volumes},
+		{"volume_mounts", old.VolumeMounts, cfg.VolumeMounts},
+		{"instance_mounts", old.InstanceMounts, cfg.InstanceMounts},
+		{"docker_volumes", old.DockerVolumes, cfg.DockerVolumes},
+		{"depends_on", old.DependsOn, cfg.DependsOn},
+	}
+	for _, field := range immutable {
+		if !reflect.DeepEqual(field.old, field.new) {
+			return fmt.Errorf("%w: %s cannot be changed on replacement", ErrInvalidConfig, field.name)
+		}
+	}
+	return d.validateTaskConfig(cfg)
+}
+
+func (d *DockerRunner) startReplacement(ctx context.Context, replacement *Task) error {
+	pullCtx, cancelPull := context.WithTimeout(ctx, ImagePullTimeout)
+	defer cancelPull()
+	if err := d.puller.Pull(pullCtx, replacement.config); err != nil {
+		return fmt.Errorf("%w: failed to pull image: %w", ErrRequest, err)
+	}
+	if err := d.verifyImageSignature(ctx, replacement.config); err != nil {
+		return fmt.Errorf("%w: %w", ErrRequest, err)
+	}
+	if err := d.createContainer(ctx, replacement); err != nil {
+		return fmt.Errorf("%w: failed to create container: %w", ErrRequest, err)
+	}
+	if err := d.startContainer(ctx, replacement); err != nil {
+		return fmt.Errorf("%w: failed to start container: %w", ErrRequest, err)
+	}
+	return nil
+}
+
+// waitReplacementStarted runs the startup probe of the new config, if any, the health
+// is not checked until it passes
+func (d *DockerRunner) waitReplacementStarted(ctx context.Context, replacement *Task) error {
+	if replacement.config.StartupProbe == nil {
+		return nil
+	}
+	err := d.runStartupProbe(ctx, replacement)
+	if errors.Is(err, errStartupProbeContainerExited) {
+		return fmt.Errorf("%w: new container exited", ErrRequest)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: new container: %w", ErrRequest, err)
+	}
+	return nil
+}
+
+// waitReplacementHealthy waits until the container is healthy, see getReplacementHealth()
+func (d *DockerRunner) waitReplacementHealthy(ctx context.Context, containerID string) error {
+	ctx, cancel := context.WithTimeout(ctx, d.replaceHealthTimeout)
+	defer cancel()
+	ticker := time.NewTicker(d.replaceCheckInterval)
+	defer ticker.Stop()
+	for {
+		inspect, err := d.client.ContainerInspect(ctx, containerID)
+		if err != nil {
+			return fmt.Errorf("%w: failed to inspect container: %w", ErrInternal, err)
+		}
+		healthy, err := getReplacementHealth(inspect.State, time.Now(), d.replaceHealthyAfter)
+		if err != nil {
+			return err
+		}
+		if healthy {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("%w: new container is not healthy after %s", ErrRequest, d.replaceHealthTimeout)
+		}
+	}
+}
+
+// getReplacementHealth returns true if the container is healthy: according to its HEALTHCHECK,
+// if any, otherwise, if it has been running for healthyAfter. An error means it never will be
+func getReplacementHealth(state *types.ContainerState, now time.Time, healthyAfter time.Duration) (bool, error) {
+	if state == nil {
+		return false, nil
+	}
+	if !state.Running {
+		return false, fmt.Errorf("%w: new container exited with exit code %d", ErrRequest, state.ExitCode)
+	}
+	if state.Health != nil && state.Health.Status != types.NoHealthcheck {
+		switch state.Health.Status {
+		case types.Healthy:
+			return true, nil
+		case types.Unhealthy:
+			return false, fmt.Errorf("%w: new container is unhealthy", ErrRequest)
+		}
+		return false, nil
+	}
+	startedAt, err := time.Parse(time.RFC3339Nano, state.StartedAt)
+	if err != nil {
+		return false, nil
+	}
+	return now.Sub(startedAt) >= healthyAfter, nil
+}
+
+// removeReplacement removes the replacement container that is not used by the task
+func (d *DockerRunner) removeReplacement(ctx context.Context, replacement *Task) {
+	if replacement.containerID != "" {
+		removeOptions := container.RemoveOptions{Force: true}
+		if err := d.client.ContainerRemove(ctx, replacement.containerID, removeOptions); err != nil {
+			log.Error(ctx, "failed to remove replacement container", "task", replacement.ID, "err", err)
+		}
+	}
+	removeRunnerDir(ctx, replacement.runnerDir)
+}
+
+// removeReplaced stops and removes the container the task has been switched from
+func (d *DockerRunner) removeReplaced(ctx context.Context, old *Task) {
+	if err := d.stopContainer(ctx, old, nil); err != nil {
+		log.Error(ctx, "failed to stop replaced container", "task", old.ID, "err", err)
+	}
+	if err := d.client.ContainerRemove(ctx, old.containerID, container.RemoveOptions{Force: true}); err != nil {
+		log.Error(ctx, "failed to remove replaced container", "task", old.ID, "err", err)
+	}
+	removeRunnerDir(ctx, old.runnerDir)
+}
+
+// adoptReplacement updates the task if its container has been replaced, see Replace().
+// Returns false if the task still has the same container
+func (d *DockerRunner) adoptReplacement(task *Task) bool {
+	current, ok := d.tasks.Get(task.ID)
+	if !ok || current.Status != TaskStatusRunning || current.containerID == task.containerID {
+		return false
+	}
+	task.config = current.config
+	task.containerName = current.containerName
+	task.containerID = current.containerID
+	task.runnerDir = current.runnerDir
+	task.ports = current.ports
+	task.diagnostics = nil
+	return true
+}
+
+func removeRunnerDir(ctx context.Context, runnerDir string) {
+	if runnerDir == "" {
+		return
+	}
+	if err := os.RemoveAll(runnerDir); err != nil {
+		log.Error(ctx, "failed to remove runner directory", "dir", runnerDir, "err", err)
+	}
+}
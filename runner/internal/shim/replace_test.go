@@ -0,0 +1,176 @@
+package shim
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newReplaceTestRunner(t *testing.T, client *fakeDockerClient) *DockerRunner {
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	runner.replaceHealthTimeout = time.Second
+	runner.replaceHealthyAfter = 50 * time.Millisecond
+	runner.replaceCheckInterval = 10 * time.Millisecond
+	return runner
+}
+
+func runReplaceTestTask(t *testing.T, runner *DockerRunner, cfg TaskConfig) string {
+	containerID := runTask(t, runner, cfg)
+	require.Eventually(t, func() bool {
+		task, _ := runner.tasks.Get(cfg.ID)
+		return task.containerStarted
+	}, 5*time.Second, 10*time.Millisecond)
+	return containerID
+}
+
+func TestDockerRunner_Replace(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newReplaceTestRunner(t, client)
+	cfg := createTaskConfig(t)
+	oldContainerID := runReplaceTestTask(t, runner, cfg)
+
+	newCfg := cfg
+	newCfg.ImageName = "ubuntu:24.04"
+	info, err := runner.Replace(context.Background(), cfg.ID, newCfg)
+	require.NoError(t, err)
+	assert.Equal(t, TaskStatusRunning, info.Status)
+	assert.NotEqual(t, oldContainerID, info.ContainerID)
+
+	// the old container is stopped and removed
+	_, err = client.getContainer(oldContainerID)
+	assert.Error(t, err)
+	ctr, err := client.getContainer(info.ContainerID)
+	require.NoError(t, err)
+	assert.True(t, ctr.running)
+	assert.Equal(t, "ubuntu:24.04", ctr.config.Image)
+
+	// the task keeps running with the new container
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, TaskStatusRunning, runner.TaskInfo(cfg.ID).Status)
+	client.exitContainer(info.ContainerID, 0)
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusTerminated)
+	assert.Equal(t, "DONE_BY_RUNNER", runner.TaskInfo(cfg.ID).TerminationReason)
+}
+
+func TestDockerRunner_Replace_Rollback(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newReplaceTestRunner(t, client)
+	cfg := createTaskConfig(t)
+	oldContainerID := runReplaceTestTask(t, runner, cfg)
+	defer client.exitContainer(oldContainerID, 0)
+
+	client.crashOnStart = &fakeCrash{exitCode: 1}
+	_, err := runner.Replace(context.Background(), cfg.ID, cfg)
+	assert.ErrorIs(t, err, ErrRequest)
+	assert.ErrorContains(t, err, "exited with exit code 1")
+
+	info := runner.TaskInfo(cfg.ID)
+	assert.Equal(t, TaskStatusRunning, info.Status)
+	assert.Equal(t, oldContainerID, info.ContainerID)
+	ctr, err := client.getContainer(oldContainerID)
+	require.NoError(t, err)
+	assert.True(t, ctr.running)
+	// only the old container is left
+	assert.Len(t, client.containers, 1)
+}
+
+func TestDockerRunner_Replace_RacesTerminate(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newReplaceTestRunner(t, client)
+	cfg := createTaskConfig(t)
+	oldContainerID := runReplaceTestTask(t, runner, cfg)
+
+	// Terminate() holds the task lock while the old container is being stopped
+	client.stopGate = make(chan struct{})
+	terminateErr := make(chan error, 1)
+	go func() {
+		terminateErr <- runner.Terminate(context.Background(), cfg.ID, nil, "TERMINATED_BY_SERVER", "")
+	}()
+	require.Eventually(t, func() bool {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		return client.stopCount == 1
+	}, time.Second, time.Millisecond)
+	replaceErr := make(chan error, 1)
+	go func() {
+		_, err := runner.Replace(context.Background(), cfg.ID, cfg)
+		replaceErr <- err
+	}()
+	// the replacement becomes healthy and waits for the lock
+	require.Eventually(t, func() bool {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		return len(client.containers) == 2
+	}, time.Second, time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	close(client.stopGate)
+
+	require.NoError(t, <-terminateErr)
+	assert.ErrorIs(t, <-replaceErr, ErrRequest)
+	info := runner.TaskInfo(cfg.ID)
+	assert.Equal(t, TaskStatusTerminated, info.Status)
+	assert.Equal(t, oldContainerID, info.ContainerID)
+	// the replacement is removed, not orphaned
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	assert.Len(t, client.containers, 1)
+	assert.Contains(t, client.containers, oldContainerID)
+}
+
+func TestDockerRunner_Replace_Errors(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newReplaceTestRunner(t, client)
+	cfg := createTaskConfig(t)
+	containerID := runReplaceTestTask(t, runner, cfg)
+	defer client.exitContainer(containerID, 0)
+
+	_, err := runner.Replace(context.Background(), "unknown", cfg)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	testCases := []func(*TaskConfig){
+		func(c *TaskConfig) { c.ID = "other" },
+		func(c *TaskConfig) { c.GPU = 1 },
+		func(c *TaskConfig) { c.NetworkMode = NetworkModeBridge },
+		func(c *TaskConfig) { c.InstanceMounts = []InstanceMountPoint{{InstancePath: "/data", Path: "/data"}} },
+		func(c *TaskConfig) { c.GPUReservation = "reservation" },
+	}
+	for _, modify := range testCases {
+		newCfg := cfg
+		modify(&newCfg)
+		_, err := runner.Replace(context.Background(), cfg.ID, newCfg)
+		assert.ErrorIs(t, err, ErrInvalidConfig)
+	}
+	assert.Len(t, client.containers, 1)
+}
+
+func TestGetReplacementHealth(t *testing.T) {
+	now := time.Now()
+	startedAt := now.Add(-time.Minute).Format(time.RFC3339Nano)
+	testCases := []struct {
+		state   *types.ContainerState
+		healthy bool
+		err     bool
+	}{
+		{nil, false, false},
+		{&types.ContainerState{Running: false, ExitCode: 1}, false, true},
+		{&types.ContainerState{Running: true, StartedAt: startedAt}, true, false},
+		{&types.ContainerState{Running: true, StartedAt: now.Format(time.RFC3339Nano)}, false, false},
+		{&types.ContainerState{Running: true, StartedAt: startedAt, Health: &types.Health{Status: types.Starting}}, false, false},
+		{&types.ContainerState{Running: true, StartedAt: startedAt, Health: &types.Health{Status: types.Healthy}}, true, false},
+		{&types.ContainerState{Running: true, StartedAt: startedAt, Health: &types.Health{Status: types.Unhealthy}}, false, true},
+		{&types.ContainerState{Running: true, StartedAt: startedAt, Health: &types.Health{Status: types.NoHealthcheck}}, true, false},
+	}
+	for i, tc := range testCases {
+		healthy, err := getReplacementHealth(tc.state, now, 10*time.Second)
+		assert.Equal(t, tc.healthy, healthy, i)
+		if tc.err {
+			assert.ErrorIs(t, err, ErrRequest, i)
+		} else {
+			assert.NoError(t, err, i)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package shim
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/dstackai/dstack/runner/internal/log"
+)
+
+// How often expired GPU reservations are released, that is, the maximum delay between
+// the reservation expiration and GPUs becoming available to other tasks
+const reservationCheckInterval = time.Second
+
+// The maximum reservation TTL, reservations are meant to cover the gap between
+// the placement decision and the task submission
+const maxReservationTTL = 10 * time.Minute
+
+type gpuReservation struct {
+	ids       []GPUID
+	expiresAt time.Time
+}
+
+// Reserve locks the given GPUs until the reservation is consumed by a task (see Consume())
+// or released after it expires (see ReleaseExpired()). All GPUs must be idle, i.e., neither
+// allocated, shared, nor reserved, otherwise none is reserved and ErrNoCapacity is returned
+func (ga *GPUAllocator) Reserve(ctx context.Context, ids []GPUID, expiresAt time.Time) (string, error) {
+	if len(ids) == 0 {
+		return "", fmt.Errorf("%w: no GPUs to reserve", ErrInvalidConfig)
+	}
+	ga.mu.Lock()
+	defer ga.mu.Unlock()
+	for i, id := range ids {
+		dev, ok := ga.devices[id]
+		if !ok {
+			return "", fmt.Errorf("%w: unknown GPU %s", ErrInvalidConfig, id)
+		}
+		if slices.Contains(ids[:i], id) {
+			return "", fmt.Errorf("%w: duplicate GPU %s", ErrInvalidConfig, id)
+		}
+		if !dev.isIdle() {
+			return "", fmt.Errorf("%w: GPU %s is busy or reserved", ErrNoCapacity, id)
+		}
+	}
+	reservationID := generateReservationID()
+	for _, id := range ids {
+		ga.devices[id].reservationID = reservationID
+	}
+	ga.reservations[reservationID] = gpuReservation{ids: slices.Clone(ids), expiresAt: expiresAt}
+	return reservationID, nil
+}
+
+// Consume removes the reservation and allocates the reserved GPUs to the task exclusively,
+// the GPUs must be released with Release() by the task
+func (ga *GPUAllocator) Consume(reservationID string, taskID string, now time.Time) ([]GPUID, error) {
+	ga.mu.Lock()
+	defer ga.mu.Unlock()
+	reservation, ok := ga.reservations[reservationID]
+	if !ok || !now.Before(reservation.expiresAt) {
+		return nil, fmt.Errorf("%w: GPU reservation %s not found or expired", ErrInvalidConfig, reservationID)
+	}
+	if _, ok := ga.tasks[taskID]; ok {
+		return nil, fmt.Errorf("%w: task %s already has GPUs allocated", ErrInvalidConfig, taskID)
+	}
+	delete(ga.reservations, reservationID)
+	for _, id := range reservation.ids {
+		ga.devices[id].reservationID = ""
+	}
+	ga.assign(taskID, reservation.ids, 0, MPSLimits{})
+	return slices.Clone(reservation.ids), nil
+}
+
+// Peek returns the reserved GPU IDs without consuming the reservation
+func (ga *GPUAllocator) Peek(reservationID string, now time.Time) ([]GPUID, error) {
+	ga.mu.Lock()
+	defer ga.mu.Unlock()
+	reservation, ok := ga.reservations[reservationID]
+	if !ok || !now.Before(reservation.expiresAt) {
+		return nil, fmt.Errorf("%w: GPU reservation %s not found or expired", ErrInvalidConfig, reservationID)
+	}
+	return slices.Clone(reservation.ids), nil
+}
+
+// ReleaseExpired releases GPUs of reservations expired by now and returns IDs of these reservations
+func (ga *GPUAllocator) ReleaseExpired(ctx context.Context, now time.Time) []string {
+	ga.mu.Lock()
+	defer ga.mu.Unlock()
+	expired := []string{}
+	for reservationID, reservation := range ga.reservations {
+		if now.Before(reservation.expiresAt) {
+			continue
+		}
+		for _, id := range reservation.ids {
+			ga.devices[id].reservationID = ""
+		}
+		delete(ga.reservations, reservationID)
+		expired = append(expired, reservationID)
+		log.Debug(ctx, "GPU reservation expired", "reservation", reservationID, "gpus", reservation.ids)
+	}
+	return expired
+}
+
+// Reserved returns GPU ID: reservation ID mapping of not consumed reservations
+func (ga *GPUAllocator) Reserved() map[GPUID]string {
+	ga.mu.Lock()
+	defer ga.mu.Unlock()
+	reserved := map[GPUID]string{}
+	for reservationID, reservation := range ga.reservations {
+		for _, id := range reservation.ids {
+			reserved[id] = reservationID
+		}
+	}
+	return reserved
+}
+
+func generateReservationID() string {
+	b := make([]byte, 16)
+	// never returns an error, see crypto/rand.Read docs
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ReserveGpus reserves the GPUs for a task to be submitted within the TTL with
+// TaskConfig.GPUReservation set to the returned reservation ID
+func (d *DockerRunner) ReserveGpus(ctx context.Context, gpuIDs []string, ttl time.Duration) (string, time.Time, error) {
+	if ttl <= 0 || ttl > maxReservationTTL {
+		return "", time.Time{}, fmt.Errorf("%w: ttl must be in (0, %s] range, got %s", ErrInvalidConfig, maxReservationTTL, ttl)
+	}
+	expiresAt := d.clock.Now().Add(ttl)
+	reservationID, err := d.gpuAllocator.Reserve(ctx, gpuIDs, expiresAt)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	log.Debug(ctx, "reserved GPU(s)", "reservation", reservationID, "gpus", gpuIDs, "expires", expiresAt)
+	return reservationID, expiresAt, nil
+}
+
+// validateGpuReservation checks that the reservation exists and matches the requested GPUs
+func (d *DockerRunner) validateGpuReservation(cfg TaskConfig) error {
+	if cfg.GPUReservation == "" {
+		return nil
+	}
+	ids, err := d.gpuAllocator.Peek(cfg.GPUReservation, d.clock.Now())
+	if err != nil {
+		return err
+	}
+	if cfg.GPU != len(ids) {
+		return fmt.Errorf("%w: %d GPUs requested, but %d GPUs reserved", ErrInvalidConfig, cfg.GPU, len(ids))
+	}
+	if cfg.GPUMemoryFraction > 0 {
+		return fmt.Errorf("%w: reserved GPUs cannot be shared, gpu_memory_fraction must not be set", ErrInvalidConfig)
+	}
+	return nil
+}
+
+// watchReservations periodically releases expired GPU reservations until ctx is done
+func (d *DockerRunner) watchReservations(ctx context.Context) {
+	ticker := time.NewTicker(reservationCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if expired := d.gpuAllocator.ReleaseExpired(ctx, d.clock.Now()); len(expired) > 0 {
+				log.Info(ctx, "released expired GPU reservations", "reservations", expired)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package shim
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dstackai/dstack/runner/internal/shim/host"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newReservationTestGPUAllocator(t *testing.T) *GPUAllocator {
+	return newTestGPUAllocator(t, "GPU-beef", "GPU-f00d", "GPU-c0de")
+}
+
+func TestGPUAllocator_Reserve_Consume(t *testing.T) {
+	gl := newReservationTestGPUAllocator(t)
+	now := time.Now()
+	reservationID, err := gl.Reserve(context.Background(), []string{"GPU-beef", "GPU-c0de"}, now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.NotEmpty(t, reservationID)
+	assert.Equal(t, map[string]string{"GPU-beef": reservationID, "GPU-c0de": reservationID}, gl.Reserved())
+
+	// reserved GPUs are skipped
+	_, err = gl.Allocate(context.Background(), GPURequest{TaskID: "other", Count: 2})
+	assert.ErrorIs(t, err, ErrNoCapacity)
+	_, err = gl.Allocate(context.Background(), GPURequest{TaskID: "other", Count: 2, MemoryFraction: 0.5})
+	assert.ErrorIs(t, err, ErrNoCapacity)
+
+	gpuIDs, err := gl.Consume(reservationID, "task", now.Add(30*time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"GPU-beef", "GPU-c0de"}, gpuIDs)
+	assert.Empty(t, gl.Reserved())
+	// allocated to the consumer
+	assert.Equal(t, "task", gl.devices["GPU-beef"].taskID)
+	assert.Equal(t, "task", gl.devices["GPU-c0de"].taskID)
+	assert.Equal(t, map[GPUID]float64{"GPU-beef": 0, "GPU-f00d": 1, "GPU-c0de": 0}, gl.Available())
+
+	// consumed only once
+	_, err = gl.Consume(reservationID, "task", now.Add(30*time.Second))
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+
+	assert.Equal(t, []string{"GPU-beef", "GPU-c0de"}, gl.Release(context.Background(), "task"))
+	assert.Equal(t, map[GPUID]float64{"GPU-beef": 1, "GPU-f00d": 1, "GPU-c0de": 1}, gl.Available())
+}
+
+func TestGPUAllocator_Reserve_Consume_ErrorAlreadyAllocated(t *testing.T) {
+	gl := newReservationTestGPUAllocator(t)
+	now := time.Now()
+	allocate(t, gl, "task", 1)
+	reservationID, err := gl.Reserve(context.Background(), []string{"GPU-c0de"}, now.Add(time.Minute))
+	require.NoError(t, err)
+
+	_, err = gl.Consume(reservationID, "task", now)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	// not consumed
+	assert.Equal(t, map[GPUID]string{"GPU-c0de": reservationID}, gl.Reserved())
+}
+
+func TestGPUAllocator_Reserve_Expire(t *testing.T) {
+	gl := newReservationTestGPUAllocator(t)
+	now := time.Now()
+	reservationID, err := gl.Reserve(context.Background(), []string{"GPU-beef"}, now.Add(time.Minute))
+	require.NoError(t, err)
+
+	assert.Empty(t, gl.ReleaseExpired(context.Background(), now.Add(59*time.Second)))
+	assert.Equal(t, 0.0, gl.Available()["GPU-beef"])
+
+	_, err = gl.Consume(reservationID, "task", now.Add(time.Minute))
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+
+	assert.Equal(t, []string{reservationID}, gl.ReleaseExpired(context.Background(), now.Add(time.Minute)))
+	assert.Equal(t, 1.0, gl.Available()["GPU-beef"])
+	assert.Empty(t, gl.Reserved())
+	_, err = gl.Consume(reservationID, "task", now)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+}
+
+func TestGPUAllocator_Reserve_Conflict(t *testing.T) {
+	gl := newReservationTestGPUAllocator(t)
+	expiresAt := time.Now().Add(time.Minute)
+	_, err := gl.Reserve(context.Background(), []string{"GPU-beef", "GPU-f00d"}, expiresAt)
+	require.NoError(t, err)
+	_, err = gl.Allocate(context.Background(), GPURequest{TaskID: "shared", Count: 1, MemoryFraction: 0.5})
+	require.NoError(t, err)
+
+	// reserved
+	_, err = gl.Reserve(context.Background(), []string{"GPU-f00d"}, expiresAt)
+	assert.ErrorIs(t, err, ErrNoCapacity)
+	// shared
+	_, err = gl.Reserve(context.Background(), []string{"GPU-c0de"}, expiresAt)
+	assert.ErrorIs(t, err, ErrNoCapacity)
+	// nothing is reserved on failure
+	assert.Len(t, gl.Reserved(), 2)
+}
+
+func TestGPUAllocator_Reserve_Errors(t *testing.T) {
+	gl := newReservationTestGPUAllocator(t)
+	expiresAt := time.Now().Add(time.Minute)
+	testCases := [][]string{
+		nil,
+		{"GPU-dead"},
+		{"GPU-beef", "GPU-beef"},
+	}
+	for _, ids := range testCases {
+		_, err := gl.Reserve(context.Background(), ids, expiresAt)
+		assert.ErrorIs(t, err, ErrInvalidConfig, ids)
+	}
+	assert.Empty(t, gl.Reserved())
+}
+
+func newReservationTestRunner(t *testing.T, client *fakeDockerClient) (*DockerRunner, *fakeClock) {
+	gpus := []host.GpuInfo{
+		{Vendor: host.GpuVendorNvidia, ID: "GPU-beef"},
+		{Vendor: host.GpuVendorNvidia, ID: "GPU-f00d"},
+	}
+	runner, err := newDockerRunner(context.Background(), client, &dockerParametersMock{}, gpus)
+	require.NoError(t, err)
+	clock := newFakeClock()
+	runner.clock = clock
+	return runner, clock
+}
+
+func TestDockerRunner_ReserveGpus_Consume(t *testing.T) {
+	client := newFakeDockerClient()
+	runner, _ := newReservationTestRunner(t, client)
+	reservationID, _, err := runner.ReserveGpus(context.Background(), []string{"GPU-f00d"}, time.Minute)
+	require.NoError(t, err)
+	allocations := runner.Allocations(context.Background())
+	assert.Equal(t, 1, allocations.FreeGpus)
+	assert.Equal(t, reservationID, allocations.Gpus[1].Reservation)
+
+	cfg := createTaskConfig(t)
+	cfg.GPU = 1
+	cfg.GPUReservation = reservationID
+	containerID := runTask(t, runner, cfg)
+
+	ctr, err := client.getContainer(containerID)
+	require.NoError(t, err)
+	require.Len(t, ctr.hostConfig.DeviceRequests, 1)
+	assert.Equal(t, []string{"GPU-f00d"}, ctr.hostConfig.DeviceRequests[0].DeviceIDs)
+	assert.Empty(t, runner.gpuAllocator.Reserved())
+
+	client.exitContainer(containerID, 0)
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusTerminated)
+	assert.Equal(t, 1.0, runner.gpuAllocator.Available()["GPU-f00d"])
+}
+
+func TestDockerRunner_ReserveGpus_Expired(t *testing.T) {
+	runner, clock := newReservationTestRunner(t, newFakeDockerClient())
+	reservationID, expiresAt, err := runner.ReserveGpus(context.Background(), []string{"GPU-f00d"}, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, clock.Now().Add(time.Minute), expiresAt)
+
+	clock.Advance(time.Minute)
+	cfg := createTaskConfig(t)
+	cfg.GPU = 1
+	cfg.GPUReservation = reservationID
+	assert.ErrorIs(t, runner.Submit(context.Background(), cfg), ErrInvalidConfig)
+	assert.Equal(t, "", runner.TaskInfo(cfg.ID).ID)
+
+	runner.gpuAllocator.ReleaseExpired(context.Background(), clock.Now())
+	assert.Equal(t, 2, runner.Allocations(context.Background()).FreeGpus)
+}
+
+func TestDockerRunner_ReserveGpus_SubmitRejected(t *testing.T) {
+	runner, _ := newReservationTestRunner(t, newFakeDockerClient())
+	reservationID, _, err := runner.ReserveGpus(context.Background(), []string{"GPU-beef", "GPU-f00d"}, time.Minute)
+	require.NoError(t, err)
+
+	for _, gpu := range []int{0, 1, -1} {
+		cfg := createTaskConfig(t)
+		cfg.GPU = gpu
+		cfg.GPUReservation = reservationID
+		assert.ErrorIs(t, runner.Submit(context.Background(), cfg), ErrInvalidConfig, gpu)
+	}
+	// not consumed
+	assert.Len(t, runner.gpuAllocator.Reserved(), 2)
+
+	_, _, err = runner.ReserveGpus(context.Background(), []string{"GPU-beef"}, 0)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+}
@@ -0,0 +1,311 @@
+package shim
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
+
+	"github.com/dstackai/dstack/runner/internal/log"
+)
+
+// Set on containers of tasks with TaskConfig.RunID, so that the run is restored on shim restart
+const LabelKeyRunID = LabelKeyPrefix + "run-id"
+
+// In follow mode, a line is held back until every open source has a newer line or this
+// long has passed since the line was captured, so that lines of a quiet container don't
+// hold back other containers forever. Lines captured later than this may be out of order
+const runLogMergeDelay = time.Second
+
+// In follow mode, how often run tasks are checked for new containers and held back lines
+// for the merge delay. Overridden in tests
+var runLogPollInterval = 250 * time.Millisecond
+
+// RunLogLine is a line of the merged run logs, see RunLogs()
+type RunLogLine struct {
+	// The time Docker captured the line
+	Time        time.Time `json:"time"`
+	TaskID      string    `json:"task_id"`
+	ContainerID string    `json:"container_id"`
+	Text        string    `json:"text"`
+}
+
+// RunLogStream merges logs (stdout and stderr) of all containers of the run into a single
+// stream ordered by the capture time, see RunLogs(). Each container is read in its own
+// goroutine, lines are merged by Next(). The caller is responsible for closing the stream
+type RunLogStream struct {
+	runner *DockerRunner
+	runID  string
+	follow bool
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	// Fan-in channel of all sources
+	events chan runLogEvent
+	// In the order of opening, which breaks ties
+	sources []*runLogSource
+	// Container IDs of opened sources, a replaced task gets a new source for the new container
+	opened map[string]bool
+	// Nil unless in follow mode
+	ticker *time.Ticker
+	clock  clock
+}
+
+type runLogSource struct {
+	taskID      string
+	containerID string
+	// Lines received, but not returned yet, the oldest first
+	pending []RunLogLine
+	done    bool
+}
+
+type runLogEvent struct {
+	source *runLogSource
+	line   RunLogLine
+	// Set with the last event of the source
+	done bool
+	err  error
+}
+
+// RunLogs opens logs of containers of all tasks with the given TaskConfig.RunID. Unless follow
+// is set, the stream ends once the logs available at the moment of the call are read.
+// In follow mode, the stream picks up containers started after the call (including
+// replacements) and ends once all tasks of the run are finished and their logs are read
+func (d *DockerRunner) RunLogs(ctx context.Context, runID string, follow bool) (*RunLogStream, error) {
+	if runID == "" {
+		return nil, fmt.Errorf("%w: empty run ID", ErrInvalidConfig)
+	}
+	if len(d.runTasks(runID)) == 0 {
+		return nil, fmt.Errorf("run %s: %w", runID, ErrNotFound)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	stream := &RunLogStream{
+		runner: d,
+		runID:  runID,
+		follow: follow,
+		ctx:    ctx,
+		cancel: cancel,
+		events: make(chan runLogEvent),
+		opened: make(map[string]bool),
+		clock:  d.clock,
+	}
+	if follow {
+		stream.ticker = time.NewTicker(runLogPollInterval)
+	}
+	stream.openNewSources()
+	return stream, nil
+}
+
+// runTasks returns copies of tasks of the run in ascending order of IDs
+func (d *DockerRunner) runTasks(runID string) []Task {
+	var tasks []Task
+	for _, id := range d.tasks.IDs() {
+		if task, ok := d.tasks.Get(id); ok && task.config.RunID == runID {
+			tasks = append(tasks, task)
+		}
+	}
+	return tasks
+}
+
+// Next returns the next line in the order of the capture time, io.EOF at the end of the stream
+func (s *RunLogStream) Next() (RunLogLine, error) {
+	var ticks <-chan time.Time
+	if s.ticker != nil {
+		ticks = s.ticker.C
+	}
+	for {
+		if line, ok := s.pop(); ok {
+			return line, nil
+		}
+		if s.allDone() {
+			if !s.follow {
+				return RunLogLine{}, io.EOF
+			}
+			// Containers may have been started since the last tick
+			if !s.openNewSources() && s.runFinished() {
+				return RunLogLine{}, io.EOF
+			}
+		}
+		select {
+		case event := <-s.events:
+			s.handle(event)
+		case <-ticks:
+			s.openNewSources()
+		case <-s.ctx.Done():
+			return RunLogLine{}, s.ctx.Err()
+		}
+	}
+}
+
+// Close stops reading logs and waits for source goroutines to exit
+func (s *RunLogStream) Close() error {
+	s.cancel()
+	if s.ticker != nil {
+		s.ticker.Stop()
+	}
+	s.wg.Wait()
+	return nil
+}
+
+// pop returns the oldest pending line if no open source may still return an older one
+func (s *RunLogStream) pop() (RunLogLine, bool) {
+	var oldest *runLogSource
+	complete := true
+	for _, source := range s.sources {
+		if len(source.pending) == 0 {
+			if !source.done {
+				complete = false
+			}
+			continue
+		}
+		// Sources are ordered by opening, the first one wins ties
+		if oldest == nil || source.pending[0].Time.Before(oldest.pending[0].Time) {
+			oldest = source
+		}
+	}
+	if oldest == nil {
+		return RunLogLine{}, false
+	}
+	line := oldest.pending[0]
+	if !complete && !(s.follow && s.clock.Now().Sub(line.Time) >= runLogMergeDelay) {
+		return RunLogLine{}, false
+	}
+	oldest.pending = oldest.pending[1:]
+	return line, true
+}
+
+func (s *RunLogStream) handle(event runLogEvent) {
+	if !event.done {
+		event.source.pending = append(event.source.pending, event.line)
+		return
+	}
+	event.source.done = true
+	if event.err != nil && !errors.Is(event.err, context.Canceled) {
+		log.Error(s.ctx, "failed to read container logs", "run", s.runID, "task", event.source.taskID, "err", event.err)
+	}
+}
+
+func (s *RunLogStream) allDone() bool {
+	for _, source := range s.sources {
+		if !source.done || len(source.pending) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// runFinished returns true if all tasks of the run are finished or removed
+func (s *RunLogStream) runFinished() bool {
+	for _, task := range s.runner.runTasks(s.runID) {
+		if !task.Status.IsFinished() {
+			return false
+		}
+	}
+	return true
+}
+
+// openNewSources opens logs of run containers that are not opened yet. Returns true if any
+func (s *RunLogStream) openNewSources() bool {
+	opened := false
+	for _, task := range s.runner.runTasks(s.runID) {
+		if task.containerID == "" || s.opened[task.containerID] {
+			continue
+		}
+		s.opened[task.containerID] = true
+		reader, err := s.runner.openContainerLogs(s.ctx, task, s.follow)
+		if err != nil {
+			log.Error(s.ctx, "skip run container logs", "run", s.runID, "task", task.ID, "err", err)
+			continue
+		}
+		source := &runLogSource{taskID: task.ID, containerID: task.containerID}
+		s.sources = append(s.sources, source)
+		opened = true
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer reader.Close()
+			s.read(source, reader)
+		}()
+	}
+	return opened
+}
+
+// read sends lines of the source until the end of the logs or the stream is closed
+func (s *RunLogStream) read(source *runLogSource, reader io.Reader) {
+	send := func(event runLogEvent) bool {
+		event.source = source
+		select {
+		case s.events <- event:
+			return true
+		case <-s.ctx.Done():
+			return false
+		}
+	}
+	bufReader := bufio.NewReader(reader)
+	var lastTime time.Time
+	for {
+		line, err := readLogLine(bufReader)
+		if errors.Is(err, io.EOF) {
+			send(runLogEvent{done: true})
+			return
+		}
+		if err != nil {
+			send(runLogEvent{done: true, err: err})
+			return
+		}
+		// Docker prepends the capture time to each line, a line without a valid one keeps
+		// the time of the previous line, so that the order within the source is preserved
+		timestamp, text, _ := strings.Cut(line, " ")
+		if lineTime, err := time.Parse(time.RFC3339Nano, timestamp); err == nil {
+			lastTime = lineTime
+		} else {
+			text = line
+		}
+		if !send(runLogEvent{line: RunLogLine{Time: lastTime, TaskID: source.taskID, ContainerID: source.containerID, Text: text}}) {
+			return
+		}
+	}
+}
+
+// openContainerLogs returns demuxed logs of the task container, each line is prefixed
+// with the capture time
+func (d *DockerRunner) openContainerLogs(ctx context.Context, task Task, follow bool) (io.ReadCloser, error) {
+	if logDriver := d.getEffectiveLogDriver(task.config); !isLogDriverReadable(logDriver) {
+		return nil, fmt.Errorf("%w: task %s logs cannot be read back with %s log driver", ErrRequest, task.ID, logDriver)
+	}
+	// Restored tasks have no config, the container is the source of truth
+	containerFull, err := d.client.ContainerInspect(ctx, task.containerID)
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return nil, fmt.Errorf("%w: task %s container is gone", ErrRequest, task.ID)
+		}
+		return nil, fmt.Errorf("%w: failed to inspect container: %w", ErrInternal, err)
+	}
+	muxedReader, err := d.client.ContainerLogs(ctx, task.containerID, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Timestamps: true,
+		Follow:     follow,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to get container logs: %w", ErrInternal, err)
+	}
+	if containerFull.Config != nil && containerFull.Config.Tty {
+		return muxedReader, nil
+	}
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		_, err := stdcopy.StdCopy(pipeWriter, pipeWriter, muxedReader)
+		_ = muxedReader.Close()
+		pipeWriter.CloseWithError(err)
+	}()
+	return pipeReader, nil
+}
@@ -0,0 +1,149 @@
+package shim
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setContainerLogs(client *fakeDockerClient, containerID string, lines []string, times []time.Time) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.containers[containerID].logs = lines
+	client.containers[containerID].logTimes = times
+}
+
+// readRunLogs reads the stream until the end
+func readRunLogs(t *testing.T, stream *RunLogStream) []RunLogLine {
+	t.Helper()
+	var lines []RunLogLine
+	for {
+		line, err := stream.Next()
+		if errors.Is(err, io.EOF) {
+			return lines
+		}
+		require.NoError(t, err)
+		lines = append(lines, line)
+	}
+}
+
+func TestDockerRunner_RunLogs_Merge(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfgA := createTaskConfig(t)
+	cfgA.RunID = "run"
+	cfgB := createTaskConfig(t)
+	cfgB.RunID = "run"
+	cfgB.TTY = true
+	cfgOther := createTaskConfig(t)
+	cfgOther.RunID = "other"
+	containerA := runTask(t, runner, cfgA)
+	containerB := runTask(t, runner, cfgB)
+	containerOther := runTask(t, runner, cfgOther)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(ms int) time.Time { return base.Add(time.Duration(ms) * time.Millisecond) }
+	setContainerLogs(client, containerA, []string{"a1", "a2 with spaces", "a3"}, []time.Time{at(1), at(4), at(5)})
+	setContainerLogs(client, containerB, []string{"b1", "b2", "b3"}, []time.Time{at(2), at(3), at(6)})
+	setContainerLogs(client, containerOther, []string{"other"}, []time.Time{at(0)})
+
+	stream, err := runner.RunLogs(context.Background(), "run", false)
+	require.NoError(t, err)
+	defer stream.Close()
+	lines := readRunLogs(t, stream)
+
+	assert.Equal(t, []RunLogLine{
+		{Time: at(1), TaskID: cfgA.ID, ContainerID: containerA, Text: "a1"},
+		{Time: at(2), TaskID: cfgB.ID, ContainerID: containerB, Text: "b1"},
+		{Time: at(3), TaskID: cfgB.ID, ContainerID: containerB, Text: "b2"},
+		{Time: at(4), TaskID: cfgA.ID, ContainerID: containerA, Text: "a2 with spaces"},
+		{Time: at(5), TaskID: cfgA.ID, ContainerID: containerA, Text: "a3"},
+		{Time: at(6), TaskID: cfgB.ID, ContainerID: containerB, Text: "b3"},
+	}, lines)
+}
+
+func TestDockerRunner_RunLogs_Errors(t *testing.T) {
+	runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.RunID = "run"
+	require.NoError(t, runner.Submit(context.Background(), cfg))
+
+	_, err := runner.RunLogs(context.Background(), "", false)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	_, err = runner.RunLogs(context.Background(), "unknown", false)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// the task has no container yet
+	stream, err := runner.RunLogs(context.Background(), "run", false)
+	require.NoError(t, err)
+	defer stream.Close()
+	assert.Empty(t, readRunLogs(t, stream))
+}
+
+func TestDockerRunner_RunLogs_Follow(t *testing.T) {
+	interval := runLogPollInterval
+	runLogPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { runLogPollInterval = interval })
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfgA := createTaskConfig(t)
+	cfgA.RunID = "run"
+	containerA := runTask(t, runner, cfgA)
+	setContainerLogs(client, containerA, []string{"a1"}, nil)
+
+	stream, err := runner.RunLogs(context.Background(), "run", true)
+	require.NoError(t, err)
+	defer stream.Close()
+	lines := make(chan RunLogLine)
+	done := make(chan error, 1)
+	go func() {
+		for {
+			line, err := stream.Next()
+			if err != nil {
+				done <- err
+				return
+			}
+			lines <- line
+		}
+	}()
+	receive := func() RunLogLine {
+		t.Helper()
+		select {
+		case line := <-lines:
+			return line
+		case err := <-done:
+			require.FailNow(t, "unexpected end of stream", err)
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timeout")
+		}
+		return RunLogLine{}
+	}
+
+	assert.Equal(t, "a1", receive().Text)
+
+	// the container started mid-stream is picked up
+	client.crashOnStart = &fakeCrash{exitCode: 0, logs: []string{"b1"}}
+	cfgB := createTaskConfig(t)
+	cfgB.RunID = "run"
+	require.NoError(t, runner.Submit(context.Background(), cfgB))
+	go func() { _ = runner.Run(context.Background(), cfgB.ID) }()
+	line := receive()
+	assert.Equal(t, "b1", line.Text)
+	assert.Equal(t, cfgB.ID, line.TaskID)
+	assert.Equal(t, runner.TaskInfo(cfgB.ID).ContainerID, line.ContainerID)
+
+	// the stream ends once all tasks are finished
+	client.exitContainer(containerA, 0)
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, io.EOF)
+	case line := <-lines:
+		assert.Fail(t, "unexpected line", line)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "timeout")
+	}
+}
@@ -0,0 +1,125 @@
+package shim
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	SecurityProfileUnconfined = "unconfined"
+
+	SecurityProfileTypeSeccomp  = "seccomp"
+	SecurityProfileTypeAppArmor = "apparmor"
+)
+
+// A list of AppArmor profiles loaded into the kernel, one per line: `<name> (<mode>)`
+// Overridden in tests
+var appArmorProfilesPath = "/sys/kernel/security/apparmor/profiles"
+
+// getSecurityOpts validates the task's seccomp and AppArmor profiles and returns
+// corresponding HostConfig.SecurityOpt entries.
+// allowUnconfined is an operator-provided list of profile types (SecurityProfileType*)
+// for which the "unconfined" value is allowed
+func getSecurityOpts(cfg TaskConfig, allowUnconfined []string) ([]string, error) {
+	securityOpts := []string{}
+	if cfg.SeccompProfile != "" {
+		opt, err := getSeccompSecurityOpt(cfg.SeccompProfile, allowUnconfined)
+		if err != nil {
+			return nil, err
+		}
+		securityOpts = append(securityOpts, opt)
+	}
+	if cfg.AppArmorProfile != "" {
+		opt, err := getAppArmorSecurityOpt(cfg.AppArmorProfile, allowUnconfined)
+		if err != nil {
+			return nil, err
+		}
+		securityOpts = append(securityOpts, opt)
+	}
+	return securityOpts, nil
+}
+
+// getSeccompSecurityOpt accepts either "unconfined" or an absolute path to a JSON profile
+// on the host. Unlike the docker CLI, the API expects the profile content, not the path
+func getSeccompSecurityOpt(profile string, allowUnconfined []string) (string, error) {
+	if profile == SecurityProfileUnconfined {
+		if !slices.Contains(allowUnconfined, SecurityProfileTypeSeccomp) {
+			return "", fmt.Errorf("%w: unconfined seccomp profile is not allowed on this host", ErrInvalidConfig)
+		}
+		return "seccomp=unconfined", nil
+	}
+	if !filepath.IsAbs(profile) {
+		return "", fmt.Errorf("%w: seccomp profile must be either %q or an absolute path, got %q", ErrInvalidConfig, SecurityProfileUnconfined, profile)
+	}
+	content, err := os.ReadFile(profile)
+	if err != nil {
+		return "", fmt.Errorf("%w: failed to read seccomp profile: %w", ErrInvalidConfig, err)
+	}
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, content); err != nil {
+		return "", fmt.Errorf("%w: failed to load seccomp profile %s: %w", ErrInvalidConfig, profile, err)
+	}
+	return fmt.Sprintf("seccomp=%s", compacted.String()), nil
+}
+
+// getAppArmorSecurityOpt accepts either "unconfined" or a name of a profile loaded into the kernel
+func getAppArmorSecurityOpt(profile string, allowUnconfined []string) (string, error) {
+	if profile == SecurityProfileUnconfined {
+		if !slices.Contains(allowUnconfined, SecurityProfileTypeAppArmor) {
+			return "", fmt.Errorf("%w: unconfined AppArmor profile is not allowed on this host", ErrInvalidConfig)
+		}
+		return "apparmor=unconfined", nil
+	}
+	loaded, err := isAppArmorProfileLoaded(profile)
+	if err != nil {
+		return "", fmt.Errorf("%w: failed to check AppArmor profile: %w", ErrInvalidConfig, err)
+	}
+	if !loaded {
+		return "", fmt.Errorf("%w: AppArmor profile %q is not loaded", ErrInvalidConfig, profile)
+	}
+	return fmt.Sprintf("apparmor=%s", profile), nil
+}
+
+func isAppArmorProfileLoaded(profile string) (bool, error) {
+	file, err := os.Open(appArmorProfilesPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, errors.New("AppArmor is not enabled on this host")
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// docker-default (enforce)
+		name, _, _ := strings.Cut(scanner.Text(), " (")
+		if name == profile {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// mergeSecurityOpts appends task's security options to the existing ones. If the task
+// specifies a profile of some type, it replaces the existing profile of the same type,
+// e.g., seccomp=unconfined set by default for AMD GPUs
+func mergeSecurityOpts(existing []string, taskOpts []string) []string {
+	merged := make([]string, 0, len(existing)+len(taskOpts))
+	for _, opt := range existing {
+		optType, _, _ := strings.Cut(opt, "=")
+		overridden := slices.ContainsFunc(taskOpts, func(taskOpt string) bool {
+			return strings.HasPrefix(taskOpt, optType+"=")
+		})
+		if !overridden {
+			merged = append(merged, opt)
+		}
+	}
+	return append(merged, taskOpts...)
+}
@@ -0,0 +1,125 @@
+package shim
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetSecurityOpts_Empty(t *testing.T) {
+	opts, err := getSecurityOpts(TaskConfig{}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, opts)
+}
+
+func TestGetSecurityOpts_SeccompProfilePath(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profile.json")
+	require.NoError(t, os.WriteFile(path, []byte("{\n  \"defaultAction\": \"SCMP_ACT_ALLOW\"\n}\n"), 0o644))
+
+	opts, err := getSecurityOpts(TaskConfig{SeccompProfile: path}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`seccomp={"defaultAction":"SCMP_ACT_ALLOW"}`}, opts)
+}
+
+func TestGetSecurityOpts_SeccompProfileErrors(t *testing.T) {
+	invalidPath := filepath.Join(t.TempDir(), "invalid.json")
+	require.NoError(t, os.WriteFile(invalidPath, []byte("not a json"), 0o644))
+	testCases := []struct {
+		profile, err string
+	}{
+		{"unconfined", "unconfined seccomp profile is not allowed"},
+		{"default", "must be either \"unconfined\" or an absolute path"},
+		{"profile.json", "must be either \"unconfined\" or an absolute path"},
+		{filepath.Join(t.TempDir(), "missing.json"), "failed to read seccomp profile"},
+		{invalidPath, "failed to load seccomp profile"},
+	}
+	for _, tc := range testCases {
+		_, err := getSecurityOpts(TaskConfig{SeccompProfile: tc.profile}, nil)
+		assert.ErrorIs(t, err, ErrInvalidConfig, tc.profile)
+		assert.ErrorContains(t, err, tc.err, tc.profile)
+	}
+}
+
+func TestGetSecurityOpts_UnconfinedAllowlist(t *testing.T) {
+	cfg := TaskConfig{SeccompProfile: "unconfined", AppArmorProfile: "unconfined"}
+
+	_, err := getSecurityOpts(cfg, []string{SecurityProfileTypeSeccomp})
+	assert.ErrorContains(t, err, "unconfined AppArmor profile is not allowed")
+
+	opts, err := getSecurityOpts(cfg, []string{SecurityProfileTypeSeccomp, SecurityProfileTypeAppArmor})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"seccomp=unconfined", "apparmor=unconfined"}, opts)
+}
+
+func TestGetSecurityOpts_AppArmorProfile(t *testing.T) {
+	setAppArmorProfiles(t, "docker-default (enforce)\ndstack-task (complain)\n")
+
+	opts, err := getSecurityOpts(TaskConfig{AppArmorProfile: "dstack-task"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"apparmor=dstack-task"}, opts)
+
+	_, err = getSecurityOpts(TaskConfig{AppArmorProfile: "dstack"}, nil)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.ErrorContains(t, err, "AppArmor profile \"dstack\" is not loaded")
+}
+
+func TestGetSecurityOpts_AppArmorNotEnabled(t *testing.T) {
+	appArmorProfilesPathOrig := appArmorProfilesPath
+	appArmorProfilesPath = filepath.Join(t.TempDir(), "profiles")
+	t.Cleanup(func() { appArmorProfilesPath = appArmorProfilesPathOrig })
+
+	_, err := getSecurityOpts(TaskConfig{AppArmorProfile: "docker-default"}, nil)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.ErrorContains(t, err, "AppArmor is not enabled")
+}
+
+func TestMergeSecurityOpts(t *testing.T) {
+	existing := []string{"seccomp=unconfined", "no-new-privileges"}
+
+	merged := mergeSecurityOpts(existing, []string{"apparmor=dstack-task"})
+	assert.Equal(t, []string{"seccomp=unconfined", "no-new-privileges", "apparmor=dstack-task"}, merged)
+
+	merged = mergeSecurityOpts(existing, []string{`seccomp={"defaultAction":"SCMP_ACT_ALLOW"}`})
+	assert.Equal(t, []string{"no-new-privileges", `seccomp={"defaultAction":"SCMP_ACT_ALLOW"}`}, merged)
+}
+
+func TestDockerRunner_SecurityOpts(t *testing.T) {
+	setAppArmorProfiles(t, "dstack-task (enforce)\n")
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{allowUnconfined: []string{"seccomp"}})
+	cfg := createTaskConfig(t)
+	cfg.SeccompProfile = "unconfined"
+	cfg.AppArmorProfile = "dstack-task"
+
+	require.NoError(t, runner.Submit(context.Background(), cfg))
+	go func() { _ = runner.Run(context.Background(), cfg.ID) }()
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusRunning)
+
+	containerID := runner.TaskInfo(cfg.ID).ContainerID
+	defer client.exitContainer(containerID, 0)
+	ctr, err := client.getContainer(containerID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"seccomp=unconfined", "apparmor=dstack-task"}, ctr.hostConfig.SecurityOpt)
+}
+
+func TestDockerRunner_SecurityOpts_SubmitRejected(t *testing.T) {
+	runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.SeccompProfile = "unconfined"
+
+	err := runner.Submit(context.Background(), cfg)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.Equal(t, "", runner.TaskInfo(cfg.ID).ID)
+}
+
+func setAppArmorProfiles(t *testing.T, profiles string) {
+	path := filepath.Join(t.TempDir(), "profiles")
+	require.NoError(t, os.WriteFile(path, []byte(profiles), 0o644))
+	appArmorProfilesPathOrig := appArmorProfilesPath
+	appArmorProfilesPath = path
+	t.Cleanup(func() { appArmorProfilesPath = appArmorProfilesPathOrig })
+}
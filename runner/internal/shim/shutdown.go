@@ -0,0 +1,94 @@
+package shim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dstackai/dstack/runner/internal/log"
+)
+
+// ShutdownBehavior defines what happens to the task when the shim is shutting down, see Shutdown()
+type ShutdownBehavior string
+
+const (
+	// Stop the container with the task stop timeout, capped by the shutdown deadline
+	ShutdownBehaviorGraceful ShutdownBehavior = "graceful"
+	// Kill the container immediately
+	ShutdownBehaviorFast ShutdownBehavior = "fast"
+	// Leave the container running, the next shim restores the task from the container
+	ShutdownBehaviorKeep ShutdownBehavior = "keep"
+)
+
+// The Docker default, used if the task has no StopTimeout
+const defaultStopTimeout = 10 * time.Second
+
+// Extra time for Docker to kill containers after the stop timeout expires, not included
+// in the shutdown deadline so that graceful stops are not cut short by the request timeout
+const shutdownKillGracePeriod = 5 * time.Second
+
+const shutdownReason = "HOST_SHUTDOWN"
+
+func validateShutdownBehavior(behavior ShutdownBehavior) error {
+	switch behavior {
+	case "", ShutdownBehaviorGraceful, ShutdownBehaviorFast, ShutdownBehaviorKeep:
+		return nil
+	}
+	return fmt.Errorf("%w: shutdown_behavior must be one of graceful, fast, keep, got %q", ErrInvalidConfig, behavior)
+}
+
+// Shutdown terminates tasks according to their ShutdownBehavior, all tasks in parallel,
+// and returns after all of them are stopped, in no more than timeout plus the kill grace period.
+// Graceful stop timeouts are capped by the remaining time, so that the deadline is respected.
+// Tasks without a container yet (pending, pulling, etc.) cannot be kept and are terminated
+// regardless of the behavior. Must be called after the API server is stopped
+func (d *DockerRunner) Shutdown(ctx context.Context, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	ctx, cancel := context.WithDeadline(ctx, deadline.Add(shutdownKillGracePeriod))
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+	for _, taskID := range d.tasks.IDs() {
+		task, ok := d.tasks.Get(taskID)
+		if !ok || task.Status.IsFinished() {
+			continue
+		}
+		behavior := task.config.ShutdownBehavior
+		if behavior == "" {
+			behavior = ShutdownBehaviorKeep
+		}
+		var stopTimeout uint
+		switch {
+		case task.Status != TaskStatusRunning:
+			// no container to keep or stop gracefully
+		case behavior == ShutdownBehaviorKeep:
+			log.Info(ctx, "keeping task on shutdown", "task", task.ID)
+			continue
+		case behavior == ShutdownBehaviorGraceful:
+			taskStopTimeout := defaultStopTimeout
+			if len(task.config.StopSignals) > 0 {
+				taskStopTimeout = getStopSignalsTimeout(task.config.StopSignals)
+			} else if task.config.StopTimeout > 0 {
+				taskStopTimeout = time.Duration(task.config.StopTimeout) * time.Second
+			}
+			stopTimeout = uint(max(min(taskStopTimeout, time.Until(deadline)), 0) / time.Second)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			log.Info(ctx, "terminating task on shutdown", "task", taskID, "behavior", behavior, "timeout", stopTimeout)
+			message := fmt.Sprintf("shim is shutting down, %s shutdown", behavior)
+			if err := d.Terminate(ctx, taskID, &stopTimeout, shutdownReason, message); err != nil {
+				mu.Lock()
+				defer mu.Unlock()
+				errs = append(errs, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,78 @@
+package shim
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDockerRunner_Shutdown(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+
+	graceful := createTaskConfig(t)
+	graceful.ShutdownBehavior = ShutdownBehaviorGraceful
+	graceful.StopTimeout = 30
+	gracefulCapped := createTaskConfig(t)
+	gracefulCapped.ShutdownBehavior = ShutdownBehaviorGraceful
+	gracefulCapped.StopTimeout = 600
+	fast := createTaskConfig(t)
+	fast.ShutdownBehavior = ShutdownBehaviorFast
+	fast.StopTimeout = 30
+	keep := createTaskConfig(t)
+	keep.ShutdownBehavior = ShutdownBehaviorKeep
+	keepByDefault := createTaskConfig(t)
+	cfgs := []TaskConfig{graceful, gracefulCapped, fast, keep, keepByDefault}
+	containerIDs := map[string]string{}
+	for _, cfg := range cfgs {
+		containerIDs[cfg.ID] = runTask(t, runner, cfg)
+	}
+	defer client.exitContainer(containerIDs[keep.ID], 0)
+	defer client.exitContainer(containerIDs[keepByDefault.ID], 0)
+	// not started yet, cannot be kept
+	pending := createTaskConfig(t)
+	pending.ShutdownBehavior = ShutdownBehaviorKeep
+	require.NoError(t, runner.Submit(context.Background(), pending))
+
+	require.NoError(t, runner.Shutdown(context.Background(), time.Minute))
+
+	stopTimeouts := map[string]*int{}
+	for _, cfg := range cfgs {
+		ctr, err := client.getContainer(containerIDs[cfg.ID])
+		require.NoError(t, err)
+		if ctr.stopOptions != nil {
+			stopTimeouts[cfg.ID] = ctr.stopOptions.Timeout
+		}
+	}
+	require.Contains(t, stopTimeouts, graceful.ID)
+	assert.Equal(t, 30, *stopTimeouts[graceful.ID])
+	require.Contains(t, stopTimeouts, gracefulCapped.ID)
+	assert.InDelta(t, 60, *stopTimeouts[gracefulCapped.ID], 1)
+	require.Contains(t, stopTimeouts, fast.ID)
+	assert.Equal(t, 0, *stopTimeouts[fast.ID])
+	assert.NotContains(t, stopTimeouts, keep.ID)
+	assert.NotContains(t, stopTimeouts, keepByDefault.ID)
+
+	for _, cfg := range []TaskConfig{graceful, gracefulCapped, fast, pending} {
+		taskInfo := runner.TaskInfo(cfg.ID)
+		assert.Equal(t, TaskStatusTerminated, taskInfo.Status, cfg.ID)
+		assert.Equal(t, "HOST_SHUTDOWN", taskInfo.TerminationReason, cfg.ID)
+	}
+	for _, cfg := range []TaskConfig{keep, keepByDefault} {
+		assert.Equal(t, TaskStatusRunning, runner.TaskInfo(cfg.ID).Status, cfg.ID)
+	}
+}
+
+func TestDockerRunner_ShutdownBehavior_SubmitRejected(t *testing.T) {
+	runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.ShutdownBehavior = "drain"
+
+	err := runner.Submit(context.Background(), cfg)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.ErrorContains(t, err, "shutdown_behavior must be one of graceful, fast, keep")
+	assert.Equal(t, "", runner.TaskInfo(cfg.ID).ID)
+}
@@ -0,0 +1,190 @@
+package shim
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/distribution/reference"
+	docker "github.com/docker/docker/client"
+
+	"github.com/dstackai/dstack/runner/internal/log"
+)
+
+// The registry key of the requirement applied to images of registries not listed explicitly
+const signaturePolicyAnyRegistry = "*"
+
+var ErrSignatureVerification = errors.New("signature verification failed")
+
+// SignaturePolicy lists cosign signature requirements per registry domain, e.g., ghcr.io,
+// or "*" for all other registries. Images of registries without requirements are not verified.
+// Loaded from a JSON file, see loadSignaturePolicy():
+//
+//	{"registries": {"ghcr.io": {"keys": ["/etc/dstack/cosign.pub"]}}}
+type SignaturePolicy struct {
+	Registries map[string]SignatureRequirement `json:"registries"`
+}
+
+// SignatureRequirement is satisfied if the image is signed with any of the keys
+// or by any of the keyless identities
+type SignatureRequirement struct {
+	// Paths to cosign public keys, or KMS URIs, as accepted by `cosign verify --key`
+	Keys       []string            `json:"keys"`
+	Identities []SignatureIdentity `json:"identities"`
+}
+
+// SignatureIdentity is a keyless (Fulcio) signing identity
+type SignatureIdentity struct {
+	// OIDC issuer, e.g., https://token.actions.githubusercontent.com
+	Issuer string `json:"issuer"`
+	// Certificate subject, e.g., https://github.com/org/repo/.github/workflows/build.yml@refs/heads/main
+	Subject string `json:"subject"`
+}
+
+// loadSignaturePolicy reads the policy file, empty path means "no policy", nil is returned
+func loadSignaturePolicy(path string) (*SignaturePolicy, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read signature policy: %w", err)
+	}
+	var policy SignaturePolicy
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&policy); err != nil {
+		return nil, fmt.Errorf("parse signature policy %s: %w", path, err)
+	}
+	normalized := make(map[string]SignatureRequirement, len(policy.Registries))
+	for registry, requirement := range policy.Registries {
+		if len(requirement.Keys) == 0 && len(requirement.Identities) == 0 {
+			return nil, fmt.Errorf("signature policy %s: registry %s: either keys or identities must be set", path, registry)
+		}
+		for _, identity := range requirement.Identities {
+			if identity.Issuer == "" || identity.Subject == "" {
+				return nil, fmt.Errorf("signature policy %s: registry %s: identity issuer and subject must be set", path, registry)
+			}
+		}
+		registry = normalizeRegistryDomain(strings.TrimSpace(registry))
+		if _, ok := normalized[registry]; ok {
+			return nil, fmt.Errorf("signature policy %s: duplicate registry %s", path, registry)
+		}
+		normalized[registry] = requirement
+	}
+	policy.Registries = normalized
+	return &policy, nil
+}
+
+// Requirement returns the requirement for the image, ok is false if the image is not verified
+func (p *SignaturePolicy) Requirement(named reference.Named) (requirement SignatureRequirement, ok bool) {
+	if p == nil {
+		return SignatureRequirement{}, false
+	}
+	if requirement, ok = p.Registries[reference.Domain(named)]; ok {
+		return requirement, true
+	}
+	requirement, ok = p.Registries[signaturePolicyAnyRegistry]
+	return requirement, ok
+}
+
+// imageVerifier verifies signatures of the image, pinned to the digest, against the requirement.
+// A nil error means the image is signed as required
+type imageVerifier interface {
+	Verify(ctx context.Context, image string, requirement SignatureRequirement, auth registryCredentials) error
+}
+
+type registryCredentials struct {
+	Username string
+	Password string
+}
+
+// cosignVerifier runs the cosign CLI, which must be installed on the host
+type cosignVerifier struct {
+	path string
+}
+
+func (v cosignVerifier) Verify(ctx context.Context, image string, requirement SignatureRequirement, auth registryCredentials) error {
+	path, err := exec.LookPath(v.path)
+	if err != nil {
+		return fmt.Errorf("cosign not found: %w", err)
+	}
+	var authArgs []string
+	if auth.Username != "" {
+		authArgs = []string{"--registry-username", auth.Username, "--registry-password", auth.Password}
+	}
+	var argsList [][]string
+	for _, key := range requirement.Keys {
+		argsList = append(argsList, []string{"--key", key})
+	}
+	for _, identity := range requirement.Identities {
+		argsList = append(argsList, []string{
+			"--certificate-oidc-issuer", identity.Issuer, "--certificate-identity", identity.Subject,
+		})
+	}
+	var errs []error
+	for _, args := range argsList {
+		args = append(append(append([]string{"verify"}, args...), authArgs...), image)
+		cmd := exec.CommandContext(ctx, path, args...)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w: %s", strings.Join(args[1:3], " "), err, strings.TrimSpace(stderr.String())))
+			continue
+		}
+		return nil
+	}
+	return errors.Join(errs...)
+}
+
+// verifyImageSignature verifies the pulled image of the task according to the signature policy.
+// The image is verified by its digest, so that the verified image is the one the container
+// is created from, even if the tag has been moved since the pull
+func (d *DockerRunner) verifyImageSignature(ctx context.Context, cfg TaskConfig) error {
+	if d.signaturePolicy == nil {
+		return nil
+	}
+	named, err := reference.ParseNormalizedNamed(cfg.ImageName)
+	if err != nil {
+		return fmt.Errorf("%w: invalid image name %s: %w", ErrSignatureVerification, cfg.ImageName, err)
+	}
+	requirement, ok := d.signaturePolicy.Requirement(named)
+	if !ok {
+		log.Debug(ctx, "image signature is not required", "image", cfg.ImageName)
+		return nil
+	}
+	image, err := getPinnedImageRef(ctx, d.client, named, cfg.ImageName)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrSignatureVerification, err)
+	}
+	auth := registryCredentials{Username: cfg.RegistryUsername, Password: cfg.RegistryPassword}
+	if err := d.imageVerifier.Verify(ctx, image, requirement, auth); err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrSignatureVerification, image, err)
+	}
+	log.Debug(ctx, "image signature verified", "image", image)
+	return nil
+}
+
+// getPinnedImageRef returns the image reference pinned to the digest of the local image,
+// e.g., docker.io/library/ubuntu@sha256:...
+// Digests are the same across registries, the image may be pulled from a mirror
+func getPinnedImageRef(ctx context.Context, client docker.APIClient, named reference.Named, imageName string) (string, error) {
+	if digested, ok := named.(reference.Digested); ok {
+		return named.Name() + "@" + digested.Digest().String(), nil
+	}
+	inspect, _, err := client.ImageInspectWithRaw(ctx, imageName)
+	if err != nil {
+		return "", fmt.Errorf("inspect image %s: %w", imageName, err)
+	}
+	for _, repoDigest := range inspect.RepoDigests {
+		if _, digest, ok := strings.Cut(repoDigest, "@"); ok {
+			return named.Name() + "@" + digest, nil
+		}
+	}
+	return "", fmt.Errorf("image %s has no registry digest", imageName)
+}
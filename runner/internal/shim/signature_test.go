@@ -0,0 +1,178 @@
+package shim
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/distribution/reference"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeImageVerifier records verified images and fails if err is set
+type fakeImageVerifier struct {
+	err    error
+	mu     sync.Mutex
+	images []string
+	auths  []registryCredentials
+}
+
+func (v *fakeImageVerifier) Verify(ctx context.Context, image string, requirement SignatureRequirement, auth registryCredentials) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.images = append(v.images, image)
+	v.auths = append(v.auths, auth)
+	return v.err
+}
+
+func (v *fakeImageVerifier) verified() []string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.images
+}
+
+func writeSignaturePolicy(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadSignaturePolicy(t *testing.T) {
+	policy, err := loadSignaturePolicy("")
+	require.NoError(t, err)
+	assert.Nil(t, policy)
+
+	policy, err = loadSignaturePolicy(writeSignaturePolicy(t, `{"registries": {
+		"index.docker.io": {"keys": ["/etc/cosign.pub"]},
+		"*": {"identities": [{"issuer": "https://issuer", "subject": "ci@example.com"}]}
+	}}`))
+	require.NoError(t, err)
+	assert.Equal(t, &SignaturePolicy{Registries: map[string]SignatureRequirement{
+		"docker.io": {Keys: []string{"/etc/cosign.pub"}},
+		"*":         {Identities: []SignatureIdentity{{Issuer: "https://issuer", Subject: "ci@example.com"}}},
+	}}, policy)
+}
+
+func TestLoadSignaturePolicy_Invalid(t *testing.T) {
+	testCases := map[string]string{
+		"malformed":          `{"registries": `,
+		"unknown field":      `{"registries": {}, "mode": "strict"}`,
+		"no requirements":    `{"registries": {"ghcr.io": {}}}`,
+		"no subject":         `{"registries": {"ghcr.io": {"identities": [{"issuer": "https://issuer"}]}}}`,
+		"duplicate registry": `{"registries": {"docker.io": {"keys": ["a"]}, "index.docker.io": {"keys": ["b"]}}}`,
+	}
+	for name, content := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := loadSignaturePolicy(writeSignaturePolicy(t, content))
+			assert.Error(t, err)
+		})
+	}
+	_, err := loadSignaturePolicy(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestSignaturePolicy_Requirement(t *testing.T) {
+	ghcr := SignatureRequirement{Keys: []string{"ghcr.pub"}}
+	anyRegistry := SignatureRequirement{Keys: []string{"any.pub"}}
+	testCases := []struct {
+		policy   *SignaturePolicy
+		image    string
+		expected SignatureRequirement
+		ok       bool
+	}{
+		{nil, "ubuntu", SignatureRequirement{}, false},
+		{&SignaturePolicy{Registries: map[string]SignatureRequirement{"ghcr.io": ghcr}}, "ghcr.io/org/app:1", ghcr, true},
+		{&SignaturePolicy{Registries: map[string]SignatureRequirement{"ghcr.io": ghcr}}, "ubuntu", SignatureRequirement{}, false},
+		{&SignaturePolicy{Registries: map[string]SignatureRequirement{"ghcr.io": ghcr, "*": anyRegistry}}, "ghcr.io/org/app", ghcr, true},
+		{&SignaturePolicy{Registries: map[string]SignatureRequirement{"ghcr.io": ghcr, "*": anyRegistry}}, "ubuntu", anyRegistry, true},
+	}
+	for _, tc := range testCases {
+		named, err := reference.ParseNormalizedNamed(tc.image)
+		require.NoError(t, err)
+		requirement, ok := tc.policy.Requirement(named)
+		assert.Equal(t, tc.ok, ok, tc.image)
+		assert.Equal(t, tc.expected, requirement, tc.image)
+	}
+}
+
+func newSignatureTestRunner(t *testing.T, client *fakeDockerClient, verifier *fakeImageVerifier) *DockerRunner {
+	path := writeSignaturePolicy(t, `{"registries": {"docker.io": {"keys": ["/etc/cosign.pub"]}}}`)
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{imageSignaturePolicy: path})
+	runner.imageVerifier = verifier
+	return runner
+}
+
+func TestDockerRunner_Run_SignatureVerified(t *testing.T) {
+	verifier := &fakeImageVerifier{}
+	client := newFakeDockerClient()
+	runner := newSignatureTestRunner(t, client, verifier)
+	cfg := createTaskConfig(t)
+	cfg.ImageName = "ubuntu:24.04"
+	cfg.RegistryUsername = "user"
+	cfg.RegistryPassword = "password"
+
+	runTask(t, runner, cfg)
+
+	assert.Equal(t, []string{"docker.io/library/ubuntu@" + fakeImageDigest}, verifier.verified())
+	assert.Equal(t, []registryCredentials{{Username: "user", Password: "password"}}, verifier.auths)
+}
+
+func TestDockerRunner_Run_SignatureVerificationFailed(t *testing.T) {
+	verifier := &fakeImageVerifier{err: errors.New("no matching signatures")}
+	client := newFakeDockerClient()
+	runner := newSignatureTestRunner(t, client, verifier)
+	cfg := createTaskConfig(t)
+
+	err := runner.Submit(context.Background(), cfg)
+	require.NoError(t, err)
+	err = runner.Run(context.Background(), cfg.ID)
+
+	assert.ErrorIs(t, err, ErrSignatureVerification)
+	info := runner.TaskInfo(cfg.ID)
+	assert.Equal(t, TaskStatusFailed, info.Status)
+	assert.Equal(t, "IMAGE_SIGNATURE_VERIFICATION_FAILED", info.TerminationReason)
+	assert.Contains(t, info.TerminationMessage, "signature verification failed")
+	assert.Contains(t, info.TerminationMessage, "no matching signatures")
+	assert.Empty(t, info.ContainerID)
+	assert.Empty(t, client.containers, "container must not be created")
+}
+
+func TestDockerRunner_Run_SignatureNoDigest(t *testing.T) {
+	verifier := &fakeImageVerifier{}
+	client := newFakeDockerClient()
+	client.injectErrors("ImageInspectWithRaw", errors.New("no such image"))
+	runner := newSignatureTestRunner(t, client, verifier)
+	cfg := createTaskConfig(t)
+
+	require.NoError(t, runner.Submit(context.Background(), cfg))
+	err := runner.Run(context.Background(), cfg.ID)
+
+	assert.ErrorIs(t, err, ErrSignatureVerification)
+	assert.Equal(t, TaskStatusFailed, runner.TaskInfo(cfg.ID).Status)
+	assert.Empty(t, verifier.verified())
+}
+
+func TestDockerRunner_Run_SignatureNotRequired(t *testing.T) {
+	verifier := &fakeImageVerifier{err: errors.New("must not be called")}
+	runner := newSignatureTestRunner(t, newFakeDockerClient(), verifier)
+	cfg := createTaskConfig(t)
+	cfg.ImageName = "ghcr.io/org/app:latest"
+
+	runTask(t, runner, cfg)
+
+	assert.Empty(t, verifier.verified())
+}
+
+func TestDockerRunner_Run_NoSignaturePolicy(t *testing.T) {
+	verifier := &fakeImageVerifier{err: errors.New("must not be called")}
+	runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{})
+	runner.imageVerifier = verifier
+
+	runTask(t, runner, createTaskConfig(t))
+
+	assert.Empty(t, verifier.verified())
+}
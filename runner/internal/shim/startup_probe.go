@@ -0,0 +1,254 @@
+package shim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+
+	"github.com/dstackai/dstack/runner/internal/log"
+)
+
+const (
+	defaultStartupProbeInterval         = 10 // seconds
+	defaultStartupProbeTimeout          = 5  // seconds
+	defaultStartupProbeFailureThreshold = 3
+)
+
+// StartupProbe fields are in seconds, overridden in tests
+var startupProbeTimeUnit = time.Second
+
+// How often an exec probe is checked for completion
+const startupProbeExecPollInterval = 100 * time.Millisecond
+
+var errStartupProbeFailed = errors.New("startup probe failed")
+
+// errStartupProbeContainerExited is returned by runStartupProbe if the container is not running,
+// the exit is handled as usual
+var errStartupProbeContainerExited = errors.New("container is not running")
+
+// StartupProbe checks that the application in the container has started, e.g., a model server
+// has loaded the model, see TaskConfig.StartupProbe. Exactly one of Exec and HTTP must be set
+type StartupProbe struct {
+	// A command executed in the container, succeeds if it exits with 0
+	Exec []string `json:"exec"`
+	// A GET request to the container port, succeeds if the status is 2xx or 3xx
+	HTTP *HTTPProbe `json:"http"`
+	// Seconds to wait after the container has started before the first attempt
+	InitialDelay uint `json:"initial_delay"`
+	// Seconds between attempts, 0 = 10
+	Interval uint `json:"interval"`
+	// Seconds an attempt may take, 0 = 5
+	Timeout uint `json:"timeout"`
+	// Consecutive failed attempts after which the task fails, 0 = 3
+	FailureThreshold uint `json:"failure_threshold"`
+}
+
+type HTTPProbe struct {
+	// The container port, with bridge network it must be published
+	Port int `json:"port"`
+	// "/" if not set
+	Path string `json:"path"`
+}
+
+func (p StartupProbe) getInterval() time.Duration {
+	if p.Interval == 0 {
+		return defaultStartupProbeInterval * startupProbeTimeUnit
+	}
+	return time.Duration(p.Interval) * startupProbeTimeUnit
+}
+
+func (p StartupProbe) getTimeout() time.Duration {
+	if p.Timeout == 0 {
+		return defaultStartupProbeTimeout * startupProbeTimeUnit
+	}
+	return time.Duration(p.Timeout) * startupProbeTimeUnit
+}
+
+func (p StartupProbe) getFailureThreshold() uint {
+	if p.FailureThreshold == 0 {
+		return defaultStartupProbeFailureThreshold
+	}
+	return p.FailureThreshold
+}
+
+// getBudget returns the maximum time the probe may take to pass, an upper bound,
+// as attempts interleave with intervals
+func (p StartupProbe) getBudget() time.Duration {
+	threshold := time.Duration(p.getFailureThreshold())
+	return time.Duration(p.InitialDelay)*startupProbeTimeUnit + threshold*(p.getTimeout()+p.getInterval())
+}
+
+func validateStartupProbe(probe *StartupProbe) error {
+	if probe == nil {
+		return nil
+	}
+	if (len(probe.Exec) > 0) == (probe.HTTP != nil) {
+		return fmt.Errorf("%w: startup_probe: exactly one of exec and http must be set", ErrInvalidConfig)
+	}
+	if probe.HTTP != nil {
+		if probe.HTTP.Port <= 0 || probe.HTTP.Port > 65535 {
+			return fmt.Errorf("%w: startup_probe: invalid http port %d", ErrInvalidConfig, probe.HTTP.Port)
+		}
+		if probe.HTTP.Path != "" && !strings.HasPrefix(probe.HTTP.Path, "/") {
+			return fmt.Errorf("%w: startup_probe: http path must start with /: %s", ErrInvalidConfig, probe.HTTP.Path)
+		}
+	}
+	return nil
+}
+
+// watchStartupProbe runs the startup probe of the just started container, the task is
+// in the starting phase until the probe passes. If it never passes, the container is stopped,
+// and the returned function reports errStartupProbeFailed, nil otherwise. The returned function
+// must be called once the container exits, it may be called more than once
+func (d *DockerRunner) watchStartupProbe(ctx context.Context, task *Task) func() error {
+	if task.config.StartupProbe == nil {
+		return func() error { return nil }
+	}
+	// Run() keeps updating the task
+	taskCopy := *task
+	task = &taskCopy
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	var probeErr error
+	d.startingUp.Add(task.ID)
+	go func() {
+		defer close(done)
+		defer d.startingUp.Delete(task.ID)
+		err := d.runStartupProbe(ctx, task)
+		if err == nil {
+			log.Info(ctx, "startup probe passed", "task", task.ID)
+			return
+		}
+		if errors.Is(err, errStartupProbeContainerExited) || ctx.Err() != nil {
+			return
+		}
+		log.Error(ctx, "startup probe failed, stopping container", "task", task.ID, "err", err)
+		probeErr = err
+		if err := d.stopContainer(ctx, task, nil); err != nil {
+			log.Error(ctx, "failed to stop container", "task", task.ID, "err", err)
+		}
+	}()
+	return func() error {
+		cancel()
+		<-done
+		return probeErr
+	}
+}
+
+// runStartupProbe returns nil once the probe passes, errStartupProbeContainerExited if
+// the container exits before that, or errStartupProbeFailed with the last attempt error
+// after FailureThreshold consecutive failed attempts
+func (d *DockerRunner) runStartupProbe(ctx context.Context, task *Task) error {
+	probe := *task.config.StartupProbe
+	if err := sleepCtx(ctx, time.Duration(probe.InitialDelay)*startupProbeTimeUnit); err != nil {
+		return err
+	}
+	var failures uint
+	for {
+		attemptCtx, cancel := context.WithTimeout(ctx, probe.getTimeout())
+		err := d.probeOnce(attemptCtx, task, probe)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if inspect, inspectErr := d.client.ContainerInspect(ctx, task.containerID); inspectErr == nil && (inspect.State == nil || !inspect.State.Running) {
+			return errStartupProbeContainerExited
+		}
+		failures++
+		log.Debug(ctx, "startup probe attempt failed", "task", task.ID, "failures", failures, "err", err)
+		if failures >= probe.getFailureThreshold() {
+			return fmt.Errorf("%w after %d attempts: %w", errStartupProbeFailed, failures, err)
+		}
+		if err := sleepCtx(ctx, probe.getInterval()); err != nil {
+			return err
+		}
+	}
+}
+
+func (d *DockerRunner) probeOnce(ctx context.Context, task *Task, probe StartupProbe) error {
+	if probe.HTTP != nil {
+		return d.probeHTTP(ctx, task, *probe.HTTP)
+	}
+	return d.probeExec(ctx, task, probe.Exec)
+}
+
+func (d *DockerRunner) probeExec(ctx context.Context, task *Task, cmd []string) error {
+	// A process left after the timeout keeps running, as with Docker HEALTHCHECK
+	exec, err := d.client.ContainerExecCreate(ctx, task.containerID, types.ExecConfig{Cmd: cmd})
+	if err != nil {
+		return fmt.Errorf("create exec: %w", err)
+	}
+	if err := d.client.ContainerExecStart(ctx, exec.ID, types.ExecStartCheck{Detach: true}); err != nil {
+		return fmt.Errorf("start exec: %w", err)
+	}
+	for {
+		inspect, err := d.client.ContainerExecInspect(ctx, exec.ID)
+		if err != nil {
+			return fmt.Errorf("inspect exec: %w", err)
+		}
+		if !inspect.Running {
+			if inspect.ExitCode != 0 {
+				return fmt.Errorf("command exited with exit code %d", inspect.ExitCode)
+			}
+			return nil
+		}
+		if err := sleepCtx(ctx, startupProbeExecPollInterval); err != nil {
+			return fmt.Errorf("command timed out: %w", err)
+		}
+	}
+}
+
+func (d *DockerRunner) probeHTTP(ctx context.Context, task *Task, probe HTTPProbe) error {
+	port := probe.Port
+	if !getNetworkMode(task.config.NetworkMode).IsHost() {
+		port = 0
+		for _, mapping := range task.ports {
+			if mapping.Container == probe.Port {
+				port = mapping.Host
+				break
+			}
+		}
+		if port == 0 {
+			return fmt.Errorf("port %d is not published", probe.Port)
+		}
+	}
+	path := probe.Path
+	if path == "" {
+		path = "/"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://127.0.0.1:%d%s", port, path), nil)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,193 @@
+package shim
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setStartupProbeTimeUnit(t *testing.T, unit time.Duration) {
+	t.Helper()
+	prev := startupProbeTimeUnit
+	startupProbeTimeUnit = unit
+	t.Cleanup(func() { startupProbeTimeUnit = prev })
+}
+
+func setExecExitCodes(client *fakeDockerClient, codes ...int) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.execExitCodes = codes
+}
+
+func getExecCmds(client *fakeDockerClient, containerID string) [][]string {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.containers[containerID].execCmds
+}
+
+func TestDockerRunner_StartupProbe_StartingThenRunning(t *testing.T) {
+	setStartupProbeTimeUnit(t, time.Millisecond)
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	setExecExitCodes(client, 1)
+	cfg := createTaskConfig(t)
+	cfg.StartupProbe = &StartupProbe{Exec: []string{"test", "-f", "/ready"}, Interval: 5, FailureThreshold: 1000}
+	containerID := runTask(t, runner, cfg)
+
+	require.Eventually(t, func() bool { return len(getExecCmds(client, containerID)) >= 2 }, 5*time.Second, time.Millisecond)
+	info := runner.TaskInfo(cfg.ID)
+	assert.Equal(t, TaskStatusRunning, info.Status)
+	assert.Equal(t, TaskProgress{Phase: TaskPhaseStarting, Percent: 90}, info.Progress)
+
+	setExecExitCodes(client, 0)
+	require.Eventually(t, func() bool {
+		return runner.TaskInfo(cfg.ID).Progress.Phase == TaskPhaseRunning
+	}, 5*time.Second, time.Millisecond)
+	execs := len(getExecCmds(client, containerID))
+	assert.Equal(t, []string{"test", "-f", "/ready"}, getExecCmds(client, containerID)[0])
+	// not probed after passing
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, getExecCmds(client, containerID), execs)
+	assert.Equal(t, TaskStatusRunning, runner.TaskInfo(cfg.ID).Status)
+
+	client.exitContainer(containerID, 0)
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusTerminated)
+	assert.Equal(t, "DONE_BY_RUNNER", runner.TaskInfo(cfg.ID).TerminationReason)
+}
+
+func TestDockerRunner_StartupProbe_Failed(t *testing.T) {
+	setStartupProbeTimeUnit(t, time.Millisecond)
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	setExecExitCodes(client, 1)
+	cfg := createTaskConfig(t)
+	cfg.StartupProbe = &StartupProbe{Exec: []string{"false"}, Interval: 1, FailureThreshold: 3}
+	require.NoError(t, runner.Submit(context.Background(), cfg))
+	// fails too fast for runTask() to observe the running status
+	go func() { _ = runner.Run(context.Background(), cfg.ID) }()
+
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusFailed)
+	info := runner.TaskInfo(cfg.ID)
+	containerID := info.ContainerID
+	assert.Equal(t, "STARTUP_PROBE_FAILED", info.TerminationReason)
+	assert.Equal(t, "startup probe failed after 3 attempts: command exited with exit code 1", info.TerminationMessage)
+	assert.Len(t, getExecCmds(client, containerID), 3)
+	_, stopped := getContainerSignals(client, containerID)
+	assert.True(t, stopped)
+}
+
+func TestDockerRunner_StartupProbe_ContainerExited(t *testing.T) {
+	setStartupProbeTimeUnit(t, time.Millisecond)
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	setExecExitCodes(client, 1)
+	cfg := createTaskConfig(t)
+	cfg.StartupProbe = &StartupProbe{Exec: []string{"false"}, Interval: 1, FailureThreshold: 1000}
+	containerID := runTask(t, runner, cfg)
+
+	require.Eventually(t, func() bool { return len(getExecCmds(client, containerID)) > 0 }, 5*time.Second, time.Millisecond)
+	client.exitContainer(containerID, 0)
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusTerminated)
+	assert.Equal(t, "DONE_BY_RUNNER", runner.TaskInfo(cfg.ID).TerminationReason)
+}
+
+func TestDockerRunner_StartupProbe_HTTP(t *testing.T) {
+	setStartupProbeTimeUnit(t, time.Millisecond)
+	ready := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/health", r.URL.Path)
+		select {
+		case <-ready:
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	_, portStr, err := net.SplitHostPort(server.Listener.Addr().String())
+	require.NoError(t, err)
+	port, err := strconv.Atoi(portStr)
+	require.NoError(t, err)
+
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.NetworkMode = NetworkModeHost
+	cfg.StartupProbe = &StartupProbe{HTTP: &HTTPProbe{Port: port, Path: "/health"}, Interval: 5, FailureThreshold: 1000}
+	containerID := runTask(t, runner, cfg)
+
+	assert.Equal(t, TaskPhaseStarting, runner.TaskInfo(cfg.ID).Progress.Phase)
+	close(ready)
+	require.Eventually(t, func() bool {
+		return runner.TaskInfo(cfg.ID).Progress.Phase == TaskPhaseRunning
+	}, 5*time.Second, time.Millisecond)
+	client.exitContainer(containerID, 0)
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusTerminated)
+}
+
+func TestDockerRunner_StartupProbe_HealthcheckStartPeriod(t *testing.T) {
+	setStartupProbeTimeUnit(t, time.Millisecond)
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.StartupProbe = &StartupProbe{Exec: []string{"true"}, InitialDelay: 30, Interval: 10, Timeout: 2, FailureThreshold: 5}
+	containerID := runTask(t, runner, cfg)
+
+	ctr, err := client.getContainer(containerID)
+	require.NoError(t, err)
+	require.NotNil(t, ctr.config.Healthcheck)
+	assert.Empty(t, ctr.config.Healthcheck.Test)
+	assert.Equal(t, 90*time.Millisecond, ctr.config.Healthcheck.StartPeriod)
+	client.exitContainer(containerID, 0)
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusTerminated)
+}
+
+func TestDockerRunner_Replace_StartupProbeFailed(t *testing.T) {
+	setStartupProbeTimeUnit(t, time.Millisecond)
+	client := newFakeDockerClient()
+	runner := newReplaceTestRunner(t, client)
+	cfg := createTaskConfig(t)
+	oldContainerID := runReplaceTestTask(t, runner, cfg)
+	defer client.exitContainer(oldContainerID, 0)
+
+	setExecExitCodes(client, 1)
+	newCfg := cfg
+	newCfg.StartupProbe = &StartupProbe{Exec: []string{"false"}, Interval: 1, FailureThreshold: 2}
+	_, err := runner.Replace(context.Background(), cfg.ID, newCfg)
+	assert.ErrorIs(t, err, ErrRequest)
+	assert.ErrorContains(t, err, "startup probe failed after 2 attempts")
+
+	info := runner.TaskInfo(cfg.ID)
+	assert.Equal(t, TaskStatusRunning, info.Status)
+	assert.Equal(t, oldContainerID, info.ContainerID)
+	assert.Len(t, client.containers, 1)
+}
+
+func TestValidateStartupProbe(t *testing.T) {
+	valid := []*StartupProbe{
+		nil,
+		{Exec: []string{"true"}},
+		{HTTP: &HTTPProbe{Port: 8000}},
+		{HTTP: &HTTPProbe{Port: 8000, Path: "/health"}},
+	}
+	for _, probe := range valid {
+		assert.NoError(t, validateStartupProbe(probe))
+	}
+	invalid := []*StartupProbe{
+		{},
+		{Exec: []string{"true"}, HTTP: &HTTPProbe{Port: 8000}},
+		{HTTP: &HTTPProbe{}},
+		{HTTP: &HTTPProbe{Port: 70000}},
+		{HTTP: &HTTPProbe{Port: 8000, Path: "health"}},
+	}
+	for _, probe := range invalid {
+		assert.ErrorIs(t, validateStartupProbe(probe), ErrInvalidConfig, "%+v", probe)
+	}
+}
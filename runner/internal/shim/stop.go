@@ -0,0 +1,169 @@
+package shim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+	"golang.org/x/sys/unix"
+
+	"github.com/dstackai/dstack/runner/internal/log"
+)
+
+// Set on containers of tasks with TaskConfig.StopSignals, so that the ladder is used
+// after the shim restart, the value is formatStopSignals() output
+const LabelKeyStopSignals = LabelKeyPrefix + "stop-signals"
+
+const maxStopSignals = 8
+
+// The highest real-time signal on Linux
+const maxSignalNumber = 64
+
+// StopSignal is a step of the stop ladder, see TaskConfig.StopSignals
+type StopSignal struct {
+	// A signal name, with or without the SIG prefix, e.g., SIGTERM or INT, or a number
+	Signal string `json:"signal"`
+	// Seconds to wait for the container to exit before the next step
+	Timeout uint `json:"timeout"`
+}
+
+func validateStopSignals(steps []StopSignal) error {
+	if len(steps) > maxStopSignals {
+		return fmt.Errorf("%w: stop_signals: at most %d steps allowed, got %d", ErrInvalidConfig, maxStopSignals, len(steps))
+	}
+	for _, step := range steps {
+		if _, err := parseSignal(step.Signal); err != nil {
+			return fmt.Errorf("%w: stop_signals: %w", ErrInvalidConfig, err)
+		}
+	}
+	return nil
+}
+
+func parseSignal(signal string) (unix.Signal, error) {
+	if num, err := strconv.Atoi(signal); err == nil {
+		if num <= 0 || num > maxSignalNumber {
+			return 0, fmt.Errorf("invalid signal number %d", num)
+		}
+		return unix.Signal(num), nil
+	}
+	name := strings.ToUpper(signal)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	if num := unix.SignalNum(name); num != 0 {
+		return num, nil
+	}
+	return 0, fmt.Errorf("unknown signal %q", signal)
+}
+
+// getStopSignalsTimeout returns the total wait time of the ladder
+func getStopSignalsTimeout(steps []StopSignal) time.Duration {
+	var timeout time.Duration
+	for _, step := range steps {
+		timeout += time.Duration(step.Timeout) * time.Second
+	}
+	return timeout
+}
+
+// formatStopSignals encodes steps as comma-separated signal:timeout pairs, e.g., SIGTERM:10,SIGINT:5
+func formatStopSignals(steps []StopSignal) string {
+	pairs := make([]string, 0, len(steps))
+	for _, step := range steps {
+		pairs = append(pairs, fmt.Sprintf("%s:%d", step.Signal, step.Timeout))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func parseStopSignals(value string) ([]StopSignal, error) {
+	var steps []StopSignal
+	for _, pair := range strings.Split(value, ",") {
+		signal, timeout, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid step %q", pair)
+		}
+		seconds, err := strconv.ParseUint(timeout, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid step %q: %w", pair, err)
+		}
+		steps = append(steps, StopSignal{Signal: signal, Timeout: uint(seconds)})
+	}
+	if err := validateStopSignals(steps); err != nil {
+		return nil, err
+	}
+	return steps, nil
+}
+
+// stopContainer stops the task container and returns once it is not running.
+// Without TaskConfig.StopSignals, the container is stopped as `docker stop` does, that is,
+// with the stop signal, then SIGKILL after the stop timeout. Otherwise, each step sends
+// its signal and waits for the container to exit, the container is killed after the last step.
+// timeout, if not nil, overrides the stop timeout or caps the total time of the ladder,
+// zero means "kill immediately"
+func (d *DockerRunner) stopContainer(ctx context.Context, task *Task, timeout *uint) error {
+	if len(task.config.StopSignals) == 0 {
+		stopOptions := container.StopOptions{}
+		// If not set, the container's StopTimeout is used, see createContainer()
+		if timeout != nil {
+			timeout := int(*timeout)
+			stopOptions.Timeout = &timeout
+		}
+		return d.client.ContainerStop(ctx, task.containerID, stopOptions)
+	}
+	var deadline time.Time
+	if timeout != nil {
+		deadline = time.Now().Add(time.Duration(*timeout) * time.Second)
+	}
+	for _, step := range task.config.StopSignals {
+		wait := time.Duration(step.Timeout) * time.Second
+		if !deadline.IsZero() {
+			wait = min(wait, time.Until(deadline))
+			if wait <= 0 {
+				break
+			}
+		}
+		log.Debug(ctx, "sending stop signal", "task", task.ID, "signal", step.Signal, "timeout", wait)
+		if exited, err := d.killContainer(ctx, task.containerID, step.Signal); err != nil || exited {
+			return err
+		}
+		if wait == 0 {
+			continue
+		}
+		waitCtx, cancel := context.WithTimeout(ctx, wait)
+		err := d.waitContainerExit(waitCtx, task.containerID)
+		cancel()
+		if err == nil || ctx.Err() != nil || !errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+	}
+	log.Debug(ctx, "killing container", "task", task.ID)
+	if exited, err := d.killContainer(ctx, task.containerID, "SIGKILL"); err != nil || exited {
+		return err
+	}
+	// The kill is asynchronous
+	return d.waitContainerExit(ctx, task.containerID)
+}
+
+// killContainer sends the signal to the container, returns true if the container is not running
+func (d *DockerRunner) killContainer(ctx context.Context, containerID string, signal string) (bool, error) {
+	err := d.client.ContainerKill(ctx, containerID, signal)
+	if errdefs.IsConflict(err) {
+		return true, nil
+	}
+	return false, err
+}
+
+// waitContainerExit waits until the container is not running or the context is done
+func (d *DockerRunner) waitContainerExit(ctx context.Context, containerID string) error {
+	waitCh, errCh := d.client.ContainerWait(ctx, containerID, container.WaitConditionNotRunning)
+	select {
+	case <-waitCh:
+		return nil
+	case err := <-errCh:
+		return err
+	}
+}
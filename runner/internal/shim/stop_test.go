@@ -0,0 +1,158 @@
+package shim
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// getContainerSignals returns signals sent to the container and whether ContainerStop was called
+func getContainerSignals(client *fakeDockerClient, containerID string) ([]string, bool) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	ctr := client.containers[containerID]
+	return ctr.signals, ctr.stopOptions != nil
+}
+
+func setIgnoredSignals(client *fakeDockerClient, containerID string, signals ...string) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.containers[containerID].ignoredSignals = signals
+}
+
+func TestDockerRunner_Terminate_StopSignals(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.StopSignals = []StopSignal{{Signal: "SIGTERM", Timeout: 1}, {Signal: "SIGINT", Timeout: 5}}
+	containerID := runTask(t, runner, cfg)
+	// the container only exits on the second signal
+	setIgnoredSignals(client, containerID, "SIGTERM")
+
+	start := time.Now()
+	require.NoError(t, runner.Terminate(context.Background(), cfg.ID, nil, "TERMINATED_BY_USER", ""))
+	elapsed := time.Since(start)
+
+	signals, stopped := getContainerSignals(client, containerID)
+	assert.Equal(t, []string{"SIGTERM", "SIGINT"}, signals)
+	assert.False(t, stopped)
+	assert.GreaterOrEqual(t, elapsed, time.Second)
+	assert.Less(t, elapsed, 5*time.Second)
+	inspect, err := client.ContainerInspect(context.Background(), containerID)
+	require.NoError(t, err)
+	// 128 + SIGINT
+	assert.Equal(t, 130, inspect.State.ExitCode)
+	assert.Equal(t, TaskStatusTerminated, runner.TaskInfo(cfg.ID).Status)
+}
+
+func TestDockerRunner_Terminate_StopSignalsExhausted(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.StopSignals = []StopSignal{{Signal: "TERM"}, {Signal: "2"}}
+	containerID := runTask(t, runner, cfg)
+	setIgnoredSignals(client, containerID, "TERM", "2")
+
+	require.NoError(t, runner.Terminate(context.Background(), cfg.ID, nil, "TERMINATED_BY_USER", ""))
+
+	// the last step always kills
+	signals, _ := getContainerSignals(client, containerID)
+	assert.Equal(t, []string{"TERM", "2", "SIGKILL"}, signals)
+	assert.Equal(t, TaskStatusTerminated, runner.TaskInfo(cfg.ID).Status)
+}
+
+func TestDockerRunner_Terminate_StopSignalsTimeoutOverride(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.StopSignals = []StopSignal{{Signal: "SIGTERM", Timeout: 60}}
+	containerID := runTask(t, runner, cfg)
+	setIgnoredSignals(client, containerID, "SIGTERM")
+
+	// the ladder is capped by the timeout
+	timeout := uint(1)
+	start := time.Now()
+	require.NoError(t, runner.Terminate(context.Background(), cfg.ID, &timeout, "TERMINATED_BY_USER", ""))
+	assert.Less(t, time.Since(start), 5*time.Second)
+	signals, _ := getContainerSignals(client, containerID)
+	assert.Equal(t, []string{"SIGTERM", "SIGKILL"}, signals)
+
+	// zero timeout kills immediately
+	cfg = createTaskConfig(t)
+	cfg.StopSignals = []StopSignal{{Signal: "SIGTERM", Timeout: 60}}
+	containerID = runTask(t, runner, cfg)
+	timeout = 0
+	require.NoError(t, runner.Terminate(context.Background(), cfg.ID, &timeout, "TERMINATED_BY_USER", ""))
+	signals, _ = getContainerSignals(client, containerID)
+	assert.Equal(t, []string{"SIGKILL"}, signals)
+}
+
+func TestDockerRunner_Terminate_DefaultStop(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	containerID := runTask(t, runner, cfg)
+
+	require.NoError(t, runner.Terminate(context.Background(), cfg.ID, nil, "TERMINATED_BY_USER", ""))
+
+	signals, stopped := getContainerSignals(client, containerID)
+	assert.Empty(t, signals)
+	assert.True(t, stopped)
+}
+
+func TestDockerRunner_StopSignals_Restore(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.StopSignals = []StopSignal{{Signal: "SIGINT", Timeout: 3}, {Signal: "SIGQUIT", Timeout: 1}}
+	containerID := runTask(t, runner, cfg)
+	client.mu.Lock()
+	labels := client.containers[containerID].config.Labels
+	client.mu.Unlock()
+	assert.Equal(t, "SIGINT:3,SIGQUIT:1", labels[LabelKeyStopSignals])
+
+	restoredClient := newFakeDockerClient()
+	restoredClient.addContainer(&fakeContainer{
+		id:      "restored",
+		name:    "restored",
+		config:  &container.Config{Labels: labels},
+		running: true,
+	})
+	restored := newFakeDockerRunner(t, restoredClient, &dockerParametersMock{})
+
+	require.NoError(t, restored.Terminate(context.Background(), cfg.ID, nil, "TERMINATED_BY_USER", ""))
+	signals, _ := getContainerSignals(restoredClient, "restored")
+	assert.Equal(t, []string{"SIGINT"}, signals)
+}
+
+func TestValidateStopSignals(t *testing.T) {
+	valid := [][]StopSignal{
+		nil,
+		{{Signal: "SIGTERM", Timeout: 10}, {Signal: "int", Timeout: 5}, {Signal: "9"}},
+		{{Signal: "SIGUSR1"}},
+	}
+	for _, steps := range valid {
+		assert.NoError(t, validateStopSignals(steps), steps)
+	}
+	invalid := [][]StopSignal{
+		{{Signal: ""}},
+		{{Signal: "SIGFOO"}},
+		{{Signal: "0"}},
+		{{Signal: "65"}},
+		make([]StopSignal, maxStopSignals+1),
+	}
+	for _, steps := range invalid {
+		assert.ErrorIs(t, validateStopSignals(steps), ErrInvalidConfig, steps)
+	}
+
+	steps, err := parseStopSignals(formatStopSignals(valid[1]))
+	require.NoError(t, err)
+	assert.Equal(t, valid[1], steps)
+	_, err = parseStopSignals("SIGTERM")
+	assert.Error(t, err)
+	assert.Equal(t, 15*time.Second, getStopSignalsTimeout(valid[1]))
+}
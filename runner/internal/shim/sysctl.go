@@ -0,0 +1,46 @@
+package shim
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+)
+
+// Namespaced sysctls, the same allowlist as Docker CLI uses for --sysctl validation: the listed
+// IPC ones, fs.mqueue.* (IPC namespace), and net.* (network namespace). Other sysctls are
+// host-global, runc refuses to set them in the container
+var ipcNamespacedSysctls = map[string]bool{
+	"kernel.msgmax":          true,
+	"kernel.msgmnb":          true,
+	"kernel.msgmni":          true,
+	"kernel.sem":             true,
+	"kernel.shmall":          true,
+	"kernel.shmmax":          true,
+	"kernel.shmmni":          true,
+	"kernel.shm_rmid_forced": true,
+}
+
+// getSysctls validates the requested sysctls and returns them as is. IPC and network sysctls
+// cannot be set if the container shares the host IPC or network namespace respectively
+func getSysctls(cfg TaskConfig, ipcHost bool) (map[string]string, error) {
+	if len(cfg.Sysctls) == 0 {
+		return nil, nil
+	}
+	for key := range cfg.Sysctls {
+		// runc accepts both net.ipv4.ip_forward and net/ipv4/ip_forward forms
+		name := strings.ReplaceAll(key, "/", ".")
+		switch {
+		case strings.HasPrefix(name, "net."):
+			if cfg.NetworkMode == NetworkModeHost {
+				return nil, fmt.Errorf("%w: sysctl %s cannot be set with host network", ErrInvalidConfig, key)
+			}
+		case ipcNamespacedSysctls[name] || strings.HasPrefix(name, "fs.mqueue."):
+			if ipcHost {
+				return nil, fmt.Errorf("%w: sysctl %s cannot be set with host IPC namespace", ErrInvalidConfig, key)
+			}
+		default:
+			return nil, fmt.Errorf("%w: sysctl %s is not namespaced", ErrInvalidConfig, key)
+		}
+	}
+	return maps.Clone(cfg.Sysctls), nil
+}
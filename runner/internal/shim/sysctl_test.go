@@ -0,0 +1,71 @@
+package shim
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetSysctls(t *testing.T) {
+	sysctls, err := getSysctls(TaskConfig{}, false)
+	assert.NoError(t, err)
+	assert.Nil(t, sysctls)
+
+	requested := map[string]string{
+		"net.core.somaxconn":  "4096",
+		"net/ipv4/ip_forward": "1",
+		"kernel.shmmax":       "68719476736",
+		"fs.mqueue.msg_max":   "64",
+	}
+	sysctls, err = getSysctls(TaskConfig{Sysctls: requested}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, requested, sysctls)
+}
+
+func TestGetSysctls_Errors(t *testing.T) {
+	testCases := []struct {
+		key         string
+		networkMode NetworkMode
+		ipcHost     bool
+		err         string
+	}{
+		{"vm.overcommit_memory", NetworkModeBridge, false, "sysctl vm.overcommit_memory is not namespaced"},
+		{"kernel.pid_max", NetworkModeBridge, false, "sysctl kernel.pid_max is not namespaced"},
+		{"kernel.shm", NetworkModeBridge, false, "sysctl kernel.shm is not namespaced"},
+		{"net.core.somaxconn", NetworkModeHost, false, "sysctl net.core.somaxconn cannot be set with host network"},
+		{"kernel.shmmax", NetworkModeBridge, true, "sysctl kernel.shmmax cannot be set with host IPC namespace"},
+		{"fs.mqueue.msg_max", NetworkModeBridge, true, "sysctl fs.mqueue.msg_max cannot be set with host IPC namespace"},
+	}
+	for _, tc := range testCases {
+		cfg := TaskConfig{NetworkMode: tc.networkMode, Sysctls: map[string]string{tc.key: "1"}}
+		_, err := getSysctls(cfg, tc.ipcHost)
+		assert.ErrorIs(t, err, ErrInvalidConfig, tc.key)
+		assert.ErrorContains(t, err, tc.err, tc.key)
+	}
+}
+
+func TestDockerRunner_Sysctls(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.Sysctls = map[string]string{"net.core.somaxconn": "4096", "kernel.shmmax": "68719476736"}
+	containerID := runTask(t, runner, cfg)
+	defer client.exitContainer(containerID, 0)
+
+	ctr, err := client.getContainer(containerID)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"net.core.somaxconn": "4096", "kernel.shmmax": "68719476736"}, ctr.hostConfig.Sysctls)
+}
+
+func TestDockerRunner_Sysctls_SubmitRejected(t *testing.T) {
+	runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.Sysctls = map[string]string{"kernel.pid_max": "4194304"}
+
+	err := runner.Submit(context.Background(), cfg)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.ErrorContains(t, err, "not namespaced")
+	assert.Equal(t, "", runner.TaskInfo(cfg.ID).ID)
+}
@@ -4,7 +4,11 @@ import (
 	"context"
 	"crypto/sha256"
 	"fmt"
+	"regexp"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/dstackai/dstack/runner/internal/log"
 )
@@ -12,18 +16,30 @@ import (
 type TaskStatus string
 
 const (
-	// pending -> preparing -> pulling -> creating -> running -> terminated
+	// pending -> preparing -> pulling -> creating -> running -> terminated | failed
 	//    |         |           |            |
 	//    v         v           v            v
-	// terminated terminated terminated terminated
+	// terminated terminated   terminated   terminated
+	//            | failed     | failed     | failed
+	//
+	// terminated: the container exited with 0 or the task was stopped intentionally
+	// (by the server, on lease expiration, etc.)
+	// failed: the task could not be started (GPUs, image, container errors) or
+	// the container exited with non-zero code, including OOM kills
 	TaskStatusPending    TaskStatus = "pending"
 	TaskStatusPreparing  TaskStatus = "preparing"
 	TaskStatusPulling    TaskStatus = "pulling"
 	TaskStatusCreating   TaskStatus = "creating"
 	TaskStatusRunning    TaskStatus = "running"
 	TaskStatusTerminated TaskStatus = "terminated"
+	TaskStatusFailed     TaskStatus = "failed"
 )
 
+// IsFinished returns true for the final statuses, terminated and failed
+func (s TaskStatus) IsFinished() bool {
+	return s == TaskStatusTerminated || s == TaskStatusFailed
+}
+
 // Task represents shim-specific part of dstack server's Job entity,
 // both configuration submitted by the server (container image,
 // container user, etc.) and state managed by the shim (container ID,
@@ -39,18 +55,30 @@ type Task struct {
 	containerID   string
 	cancelPull    context.CancelFunc
 	gpuIDs        []string
-	ports         []PortMapping
-	runnerDir     string // path on host mapped to consts.RunnerDir in container
+	// 0.0 if gpuIDs are locked exclusively, otherwise a share of each GPU reserved for the task
+	gpuMemoryFraction float64
+	ports             []PortMapping
+	runnerDir         string // path on host mapped to consts.RunnerDir in container
+	submittedAt       time.Time
+	startedAt         time.Time // the time the task has left the queue, zero if still queued
+	finishedAt        time.Time // the time the task was terminated by Terminate() or failed while pending, zero otherwise
+	// set if the container failed to start or exited right after start
+	diagnostics *ContainerDiagnostics
+	// resource usage over the container lifetime, set when the container exits
+	resourceSummary *ResourceSummary
+	// set when the container exits, see TaskConfig.CoreDumps
+	coreDumps []CoreDump
+	// set once the container has been started, distinguishes the starting phase from running
+	containerStarted bool
 
 	mu *sync.Mutex
 }
 
 // Lock is used for exclusive operations, e.g, stopping a container,
-// removing task data, etc.
+// removing task data, etc. It blocks until the concurrent operation, if any, is done,
+// the task copy must be re-read from TaskStorage after that, as it may be outdated
 func (t *Task) Lock(ctx context.Context) {
-	if !t.mu.TryLock() {
-		log.Fatal(ctx, "already locked!", "task", t.ID)
-	}
+	t.mu.Lock()
 	log.Debug(ctx, "locked", "task", t.ID)
 }
 
@@ -62,6 +90,22 @@ func (t *Task) Release(ctx context.Context) {
 	log.Debug(ctx, "unlocked", "task", t.ID)
 }
 
+// QueuedDuration returns the time the task spent in the queue, from submit to start.
+// If the task is still queued, it returns the time elapsed since submit until now, if it was
+// terminated while queued, until termination.
+// Restored tasks have no submit time, the returned value is zero in that case
+func (t *Task) QueuedDuration(now time.Time) time.Duration {
+	switch {
+	case t.submittedAt.IsZero():
+		return 0
+	case !t.startedAt.IsZero():
+		return t.startedAt.Sub(t.submittedAt)
+	case !t.finishedAt.IsZero():
+		return t.finishedAt.Sub(t.submittedAt)
+	}
+	return now.Sub(t.submittedAt)
+}
+
 func (t *Task) IsTransitionAllowed(toStatus TaskStatus) bool {
 	// same-state transitions are not allowed unless stated otherwise, meaning that
 	// task.Update(); task.Update() is not allowed is most cases.
@@ -81,7 +125,7 @@ func (t *Task) IsTransitionAllowed(toStatus TaskStatus) bool {
 	case TaskStatusRunning:
 		// allow running->running transition to update internal state, e.g., ports
 		return t.Status == TaskStatusCreating || t.Status == TaskStatusRunning
-	case TaskStatusTerminated:
+	case TaskStatusTerminated, TaskStatusFailed:
 		// terminated -> terminated is also allowed since server _always_ tries to
 		// terminate the task, even if it is already terminated, but this is a special case,
 		// see TaskStorage.Update() for details
@@ -119,6 +163,13 @@ func (t *Task) SetStatusTerminated(reason string, message string) {
 	t.cancelPull = nil
 }
 
+func (t *Task) SetStatusFailed(reason string, message string) {
+	t.Status = TaskStatusFailed
+	t.TerminationReason = reason
+	t.TerminationMessage = message
+	t.cancelPull = nil
+}
+
 func NewTask(id string, status TaskStatus, containerName string, containerID string, gpuIDs []string, ports []PortMapping, runnerDir string) Task {
 	return Task{
 		ID:            id,
@@ -134,20 +185,28 @@ func NewTask(id string, status TaskStatus, containerName string, containerID str
 
 func NewTaskFromConfig(cfg TaskConfig) Task {
 	return Task{
-		ID:            cfg.ID,
-		Status:        TaskStatusPending,
-		config:        cfg,
-		containerName: generateUniqueName(cfg.Name, cfg.ID),
-		mu:            &sync.Mutex{},
+		ID:                cfg.ID,
+		Status:            TaskStatusPending,
+		config:            cfg,
+		containerName:     generateUniqueName(cfg.Name, cfg.ID, defaultNameSuffixLen),
+		gpuMemoryFraction: cfg.GPUMemoryFraction,
+		submittedAt:       time.Now(),
+		mu:                &sync.Mutex{},
 	}
 }
 
 type TaskStorage struct {
 	// Task.ID: Task mapping
 	tasks map[string]Task
-	mu    sync.RWMutex
+	// Task.ID: channels notified on each task update or deletion, see Subscribe()
+	subscribers map[string][]chan struct{}
+	// Task.ID: lifecycle events, kept here rather than in Task, as a Task copy committed
+	// by a concurrent operation would overwrite events recorded since the copy was made
+	history map[string]*taskHistory
+	mu      sync.RWMutex
 }
 
+// IDs returns task IDs in ascending order
 func (ts *TaskStorage) IDs() []string {
 	ts.mu.RLock()
 	defer ts.mu.RUnlock()
@@ -155,6 +214,7 @@ func (ts *TaskStorage) IDs() []string {
 	for id := range ts.tasks {
 		ids = append(ids, id)
 	}
+	slices.Sort(ids)
 	return ids
 }
 
@@ -166,15 +226,21 @@ func (ts *TaskStorage) Get(id string) (Task, bool) {
 	return task, ok
 }
 
-// Add a _new_ task. If the task is already in the storage, do nothing and return false
-func (ts *TaskStorage) Add(task Task) bool {
+// Add a _new_ task. If the task ID is not valid, return InvalidTaskIDError
+// If the task is already in the storage, do nothing and return ErrRequest
+func (ts *TaskStorage) Add(task Task) error {
+	if err := ValidateTaskID(task.ID); err != nil {
+		return err
+	}
 	ts.mu.Lock()
 	defer ts.mu.Unlock()
 	if _, ok := ts.tasks[task.ID]; ok {
-		return false
+		return fmt.Errorf("%w: task %s already exists", ErrRequest, task.ID)
 	}
 	ts.tasks[task.ID] = task
-	return true
+	ts.history[task.ID] = &taskHistory{}
+	ts.recordStatus(task)
+	return nil
 }
 
 // Update the _existing_ task. If the task is not in the storage, do nothing and return false
@@ -189,16 +255,31 @@ func (ts *TaskStorage) Update(task Task) error {
 	if !currentTask.IsTransitionAllowed(task.Status) {
 		return fmt.Errorf("%w: %s -> %s transition not allowed", ErrRequest, currentTask.Status, task.Status)
 	}
-	if currentTask.Status == TaskStatusTerminated {
-		// We ignore reason/message fields if they are already set to avoid
+	if currentTask.Status.IsFinished() {
+		// We ignore status/reason/message fields if they are already set to avoid
 		// overriding these fields by the server, which _always_ tries to terminate the task,
-		// even if it is not running
+		// even if it is not running, that is, the first final status wins
+		task.Status = currentTask.Status
 		if currentTask.TerminationReason != "" {
 			task.TerminationReason = currentTask.TerminationReason
 			task.TerminationMessage = currentTask.TerminationMessage
 		}
+	} else if task.Status.IsFinished() {
+		tasksFinished.WithLabelValues(string(task.Status)).Inc()
+	}
+	// The summary is set once, but the task may be concurrently updated by a copy
+	// made before that, e.g., by Terminate() racing with Run()
+	if task.resourceSummary == nil {
+		task.resourceSummary = currentTask.resourceSummary
+	}
+	if task.coreDumps == nil {
+		task.coreDumps = currentTask.coreDumps
 	}
 	ts.tasks[task.ID] = task
+	if task.Status != currentTask.Status {
+		ts.recordStatus(task)
+	}
+	ts.notify(task.ID)
 	return nil
 }
 
@@ -206,29 +287,240 @@ func (ts *TaskStorage) Delete(id string) {
 	ts.mu.Lock()
 	defer ts.mu.Unlock()
 	delete(ts.tasks, id)
+	delete(ts.history, id)
+	ts.notify(id)
+}
+
+// RecordEvent adds the event to the task history, Time is set to the current time if not set.
+// If the task is not in the storage, do nothing
+func (ts *TaskStorage) RecordEvent(id string, event TaskHistoryEvent) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.recordEvent(id, event)
+}
+
+// Events returns a copy of the task history, the oldest event first, nil if the task
+// is not in the storage
+func (ts *TaskStorage) Events(id string) []TaskHistoryEvent {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	history, ok := ts.history[id]
+	if !ok {
+		return nil
+	}
+	return history.list()
+}
+
+// EventsSince is the same as Events, but only returns events recorded after the first n ones,
+// and the number of events ever recorded, to be passed as n to the next call
+func (ts *TaskStorage) EventsSince(id string, n int) ([]TaskHistoryEvent, int) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	history, ok := ts.history[id]
+	if !ok {
+		return nil, n
+	}
+	return history.since(n)
+}
+
+// recordEvent must be called with lock held
+func (ts *TaskStorage) recordEvent(id string, event TaskHistoryEvent) {
+	history, ok := ts.history[id]
+	if !ok {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	history.add(event)
+}
+
+// recordStatus must be called with lock held
+func (ts *TaskStorage) recordStatus(task Task) {
+	event := TaskHistoryEvent{Type: TaskHistoryEventStatus, Status: task.Status}
+	if task.Status.IsFinished() {
+		event.TerminationReason = task.TerminationReason
+		event.Message = task.TerminationMessage
+	}
+	ts.recordEvent(task.ID, event)
+}
+
+// Subscribe returns a channel that receives a value after any of the tasks is updated or deleted,
+// and a function to unsubscribe, which must be called when the channel is no longer used.
+// Notifications are coalesced: the channel is buffered, and if the subscriber hasn't
+// received the previous value yet, the new one is dropped, so the subscriber must Get()
+// the tasks to check their current state. The tasks don't have to exist to subscribe
+func (ts *TaskStorage) Subscribe(ids ...string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	for _, id := range ids {
+		ts.subscribers[id] = append(ts.subscribers[id], ch)
+	}
+	unsubscribe := func() {
+		ts.mu.Lock()
+		defer ts.mu.Unlock()
+		for _, id := range ids {
+			subscribers := slices.DeleteFunc(ts.subscribers[id], func(c chan struct{}) bool { return c == ch })
+			if len(subscribers) == 0 {
+				delete(ts.subscribers, id)
+			} else {
+				ts.subscribers[id] = subscribers
+			}
+		}
+	}
+	return ch, unsubscribe
+}
+
+// notify must be called with lock held
+func (ts *TaskStorage) notify(id string) {
+	for _, ch := range ts.subscribers[id] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
 }
 
 func NewTaskStorage() TaskStorage {
 	return TaskStorage{
-		tasks: make(map[string]Task),
+		tasks:       make(map[string]Task),
+		subscribers: make(map[string][]chan struct{}),
+		history:     make(map[string]*taskHistory),
+	}
+}
+
+// Task IDs are assigned by the server, normally these are UUIDs, but any string of
+// ASCII letters, digits, underscores, and hyphens, starting with a letter or a digit,
+// up to maxTaskIDLen characters long is accepted
+const maxTaskIDLen = 64
+
+var taskIDRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+
+// InvalidTaskIDError is returned when the task ID is empty or malformed
+type InvalidTaskIDError struct {
+	ID     string
+	Reason string
+}
+
+func (e *InvalidTaskIDError) Error() string {
+	return fmt.Sprintf("invalid task ID %q: %s", e.ID, e.Reason)
+}
+
+func (e *InvalidTaskIDError) Unwrap() error {
+	return ErrInvalidConfig
+}
+
+func ValidateTaskID(id string) error {
+	if id == "" {
+		return &InvalidTaskIDError{ID: id, Reason: "empty"}
 	}
+	if len(id) > maxTaskIDLen {
+		return &InvalidTaskIDError{ID: id, Reason: fmt.Sprintf("longer than %d characters", maxTaskIDLen)}
+	}
+	if !taskIDRegexp.MatchString(id) {
+		return &InvalidTaskIDError{ID: id, Reason: "unexpected characters"}
+	}
+	return nil
 }
 
+const (
+	// Docker itself does not limit container name length, but we keep names
+	// DNS label-sized, as the name is also used as a runner dir name, etc.
+	maxContainerNameLen  = 63
+	defaultContainerName = "task"
+	// The length of the unique suffix, hex characters, see generateNameSuffix().
+	// The suffix only has to be unique among containers of the same Docker daemon
+	// with the same (sanitized and truncated) name part, a collision fails the task
+	// on container creation. With k hex characters (4k bits), the collision probability
+	// among n such containers is about n^2 / 2^(4k+1), that is, for the default 8 characters,
+	// ~1e-6 for 100 containers, ~1e-4 for 1000 containers, and ~1e-2 for 10000 containers;
+	// for 16 characters, below 1e-10 even for 10000 containers
+	defaultNameSuffixLen = 8
+	minNameSuffixLen     = 8
+	// Leaves at least 30 characters for the human-readable part
+	maxNameSuffixLen = 32
+)
+
+// Docker allows [a-zA-Z0-9][a-zA-Z0-9_.-]+
+var (
+	containerNameInvalidCharsRegexp  = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+	containerNameInvalidPrefixRegexp = regexp.MustCompile(`^[_.-]+`)
+)
+
 // generateUniqueName returns a unique name in the form of <name>-<suffix>,
 // where <name> is non-unique human-readable name provided by the server, and
 // <suffix> is a relatively short unique hex string generated from (name, id) pair
-func generateUniqueName(name string, id string) string {
-	suffix := generateNameSuffix(name, id)
-	return fmt.Sprintf("%s-%s", name, suffix)
+// <name> is sanitized (invalid characters are replaced with hyphens) and truncated
+// so that the whole name is not longer than maxContainerNameLen
+// The suffix is always generated from the original (name, id) pair and is never truncated
+func generateUniqueName(name string, id string, suffixLen int) string {
+	suffix := generateNameSuffix(name, id, suffixLen)
+	return fmt.Sprintf("%s-%s", sanitizeContainerName(name, maxContainerNameLen-len(suffix)-1), suffix)
+}
+
+// validateNameSuffixLen returns the suffix length to use, 0 = defaultNameSuffixLen
+func validateNameSuffixLen(suffixLen int) (int, error) {
+	if suffixLen == 0 {
+		return defaultNameSuffixLen, nil
+	}
+	if suffixLen < minNameSuffixLen || suffixLen > maxNameSuffixLen {
+		return 0, fmt.Errorf("container name hash length must be in %d..%d range, got %d", minNameSuffixLen, maxNameSuffixLen, suffixLen)
+	}
+	return suffixLen, nil
+}
+
+func sanitizeContainerName(name string, maxLen int) string {
+	name = containerNameInvalidCharsRegexp.ReplaceAllString(name, "-")
+	name = containerNameInvalidPrefixRegexp.ReplaceAllString(name, "")
+	if len(name) > maxLen {
+		name = strings.TrimRight(name[:maxLen], "_.-")
+	}
+	if name == "" {
+		return defaultContainerName
+	}
+	return name
 }
 
 // generateNameSuffix returns a (semi-)unique hex string based on (name, id) pair
 // Used to avoid possible name clashes
 // The generated string is unique as long as
 // - (name, id) pair is unique
-// - there is no collision within first nameSuffixLen / 2 bytes of hash
-func generateNameSuffix(name string, id string) string {
-	const nameSuffixLen = 8
+// - there is no collision within first suffixLen / 2 bytes of hash
+func generateNameSuffix(name string, id string, suffixLen int) string {
 	b := []byte(fmt.Sprintf("%s/%s", name, id))
-	return fmt.Sprintf("%x", sha256.Sum256(b))[:nameSuffixLen]
+	return fmt.Sprintf("%x", sha256.Sum256(b))[:suffixLen]
+}
+
+// taskSet is a set of task IDs with some operation in progress, e.g., being replaced
+type taskSet struct {
+	taskIDs map[string]bool
+	mu      sync.Mutex
+}
+
+func newTaskSet() *taskSet {
+	return &taskSet{taskIDs: make(map[string]bool)}
+}
+
+// Add returns false if the task is already in the set
+func (s *taskSet) Add(taskID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.taskIDs[taskID] {
+		return false
+	}
+	s.taskIDs[taskID] = true
+	return true
+}
+
+func (s *taskSet) Delete(taskID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.taskIDs, taskID)
+}
+
+func (s *taskSet) Has(taskID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.taskIDs[taskID]
 }
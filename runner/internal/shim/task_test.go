@@ -1,10 +1,15 @@
 package shim
 
 import (
+	"context"
 	"fmt"
+	"math/rand"
+	"strings"
 	"testing"
 
+	"github.com/dstackai/dstack/runner/internal/shim/host"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestTaskStorage_Get(t *testing.T) {
@@ -21,14 +26,23 @@ func TestTaskStorage_Get(t *testing.T) {
 	assert.NotEqual(t, storedTask, task)
 }
 
+func TestTaskStorage_IDs_Sorted(t *testing.T) {
+	storage := NewTaskStorage()
+	for _, id := range []string{"b", "c", "a"} {
+		storage.tasks[id] = Task{ID: id, Status: TaskStatusRunning}
+	}
+
+	assert.Equal(t, []string{"a", "b", "c"}, storage.IDs())
+}
+
 func TestTaskStorage_Add_OK(t *testing.T) {
 	storage := NewTaskStorage()
 	storedTask := Task{ID: "1", Status: TaskStatusRunning}
 	storage.tasks["1"] = storedTask
 	addedTask := Task{ID: "2", Status: TaskStatusPending}
 
-	ok := storage.Add(addedTask)
-	assert.True(t, ok)
+	err := storage.Add(addedTask)
+	assert.NoError(t, err)
 	assert.Equal(t, storedTask, storage.tasks["1"])
 	assert.Equal(t, addedTask, storage.tasks["2"])
 }
@@ -38,11 +52,29 @@ func TestTaskStorage_Add_AlreadyExists(t *testing.T) {
 	storedTask := Task{ID: "1", Status: TaskStatusRunning}
 	storage.tasks["1"] = storedTask
 
-	ok := storage.Add(Task{ID: "1", Status: TaskStatusPending})
-	assert.False(t, ok)
+	err := storage.Add(Task{ID: "1", Status: TaskStatusPending})
+	assert.ErrorIs(t, err, ErrRequest)
 	assert.Equal(t, storedTask, storage.tasks["1"])
 }
 
+func TestTaskStorage_Add_InvalidID(t *testing.T) {
+	storage := NewTaskStorage()
+
+	for _, id := range []string{"", "-1", "../1", "1 2", strings.Repeat("1", 65)} {
+		err := storage.Add(Task{ID: id, Status: TaskStatusPending})
+		var invalidIDErr *InvalidTaskIDError
+		assert.ErrorAs(t, err, &invalidIDErr, id)
+		assert.ErrorIs(t, err, ErrInvalidConfig, id)
+	}
+	assert.Equal(t, 0, len(storage.tasks))
+}
+
+func TestValidateTaskID_OK(t *testing.T) {
+	for _, id := range []string{"66a886db-86db-4cf9-8c06-8984ad15dde2", "dummy-id", "1", "a_1", strings.Repeat("1", 64)} {
+		assert.NoError(t, ValidateTaskID(id), id)
+	}
+}
+
 func TestTaskStorage_Update_OK(t *testing.T) {
 	storage := NewTaskStorage()
 	storedTask := Task{ID: "1", Status: TaskStatusRunning}
@@ -74,6 +106,32 @@ func TestTaskStorage_Update_TransitionNotAllowed(t *testing.T) {
 	assert.Equal(t, storedTask, storage.tasks["1"])
 }
 
+func TestTaskStorage_Update_KeepsFirstFinalStatus(t *testing.T) {
+	storage := NewTaskStorage()
+	storage.tasks["1"] = Task{ID: "1", Status: TaskStatusFailed, TerminationReason: "CONTAINER_EXITED_WITH_ERROR"}
+	err := storage.Update(Task{ID: "1", Status: TaskStatusTerminated, TerminationReason: "TERMINATED_BY_SERVER"})
+	assert.Nil(t, err)
+	assert.Equal(t, TaskStatusFailed, storage.tasks["1"].Status)
+	assert.Equal(t, "CONTAINER_EXITED_WITH_ERROR", storage.tasks["1"].TerminationReason)
+
+	storage.tasks["2"] = Task{ID: "2", Status: TaskStatusTerminated, TerminationReason: "TERMINATED_BY_SERVER"}
+	err = storage.Update(Task{ID: "2", Status: TaskStatusFailed, TerminationReason: "CONTAINER_EXITED_WITH_ERROR"})
+	assert.Nil(t, err)
+	assert.Equal(t, TaskStatusTerminated, storage.tasks["2"].Status)
+	assert.Equal(t, "TERMINATED_BY_SERVER", storage.tasks["2"].TerminationReason)
+}
+
+func TestTaskStorage_Update_KeepsResourceSummary(t *testing.T) {
+	storage := NewTaskStorage()
+	summary := &ResourceSummary{CPUSeconds: 1}
+	storage.tasks["1"] = Task{ID: "1", Status: TaskStatusTerminated, resourceSummary: summary}
+	updatedTask := Task{ID: "1", Status: TaskStatusTerminated}
+
+	err := storage.Update(updatedTask)
+	assert.Nil(t, err)
+	assert.Equal(t, summary, storage.tasks["1"].resourceSummary)
+}
+
 func TestTaskStorage_Delete(t *testing.T) {
 	storage := NewTaskStorage()
 	storage.tasks["1"] = Task{ID: "1", Status: TaskStatusRunning}
@@ -85,6 +143,44 @@ func TestTaskStorage_Delete(t *testing.T) {
 	assert.Equal(t, 0, len(storage.tasks))
 }
 
+func TestTaskStorage_Subscribe(t *testing.T) {
+	storage := NewTaskStorage()
+	storage.tasks["1"] = Task{ID: "1", Status: TaskStatusPending}
+	updates, unsubscribe := storage.Subscribe("1")
+	other, unsubscribeOther := storage.Subscribe("2")
+	defer unsubscribeOther()
+
+	// notifications are coalesced
+	assert.Nil(t, storage.Update(Task{ID: "1", Status: TaskStatusPreparing}))
+	assert.Nil(t, storage.Update(Task{ID: "1", Status: TaskStatusPulling}))
+	assert.Len(t, updates, 1)
+	<-updates
+	assert.Len(t, other, 0)
+
+	storage.Delete("1")
+	assert.Len(t, updates, 1)
+
+	unsubscribe()
+	assert.NotContains(t, storage.subscribers, "1")
+	assert.Len(t, storage.subscribers["2"], 1)
+}
+
+func TestTaskStorage_Subscribe_Many(t *testing.T) {
+	storage := NewTaskStorage()
+	storage.tasks["1"] = Task{ID: "1", Status: TaskStatusPending}
+	storage.tasks["2"] = Task{ID: "2", Status: TaskStatusPending}
+	updates, unsubscribe := storage.Subscribe("1", "2")
+
+	assert.Nil(t, storage.Update(Task{ID: "2", Status: TaskStatusPreparing}))
+	assert.Len(t, updates, 1)
+	<-updates
+	storage.Delete("1")
+	assert.Len(t, updates, 1)
+
+	unsubscribe()
+	assert.Empty(t, storage.subscribers)
+}
+
 func TestTask_IsTransitionAllowed_true(t *testing.T) {
 	testCases := []struct {
 		oldStatus, newStatus TaskStatus
@@ -100,6 +196,11 @@ func TestTask_IsTransitionAllowed_true(t *testing.T) {
 		{TaskStatusRunning, TaskStatusRunning},
 		{TaskStatusRunning, TaskStatusTerminated},
 		{TaskStatusTerminated, TaskStatusTerminated},
+		{TaskStatusPending, TaskStatusFailed},
+		{TaskStatusPreparing, TaskStatusFailed},
+		{TaskStatusRunning, TaskStatusFailed},
+		{TaskStatusFailed, TaskStatusFailed},
+		{TaskStatusFailed, TaskStatusTerminated},
 	}
 	for _, tc := range testCases {
 		task := Task{ID: "1", Status: tc.oldStatus}
@@ -115,6 +216,8 @@ func TestTask_IsTransitionAllowed_false(t *testing.T) {
 		{TaskStatusPending, TaskStatusPending},
 		{TaskStatusPending, TaskStatusRunning},
 		{TaskStatusPulling, TaskStatusPending},
+		{TaskStatusFailed, TaskStatusRunning},
+		{TaskStatusTerminated, TaskStatusPending},
 	}
 	for _, tc := range testCases {
 		task := Task{ID: "1", Status: tc.oldStatus}
@@ -144,7 +247,92 @@ func TestGenerateUniqueName(t *testing.T) {
 		{"llamacpp-0-0", "66a886db-86db-4cf9-8c06-8984ad15dde2", "llamacpp-0-0-58d1283d"},
 	}
 	for _, tc := range testCases {
-		generated := generateUniqueName(tc.name, tc.id)
+		generated := generateUniqueName(tc.name, tc.id, defaultNameSuffixLen)
 		assert.Equal(t, tc.expected, generated)
 	}
 }
+
+func TestGenerateUniqueName_EdgeCases(t *testing.T) {
+	const id = "66a886db-86db-4cf9-8c06-8984ad15dde2"
+	testCases := []struct {
+		name, expectedPrefix string
+	}{
+		{"", "task-"},
+		{"---", "task-"},
+		{"my run/0:0", "my-run-0-0-"},
+		{"_.vllm-0-0", "vllm-0-0-"},
+		{"привет-0-0", "0-0-"},
+		{strings.Repeat("a", 100), strings.Repeat("a", 54) + "-"},
+		{strings.Repeat("a", 53) + "-" + strings.Repeat("b", 10), strings.Repeat("a", 53) + "-"},
+	}
+	for _, tc := range testCases {
+		generated := generateUniqueName(tc.name, id, defaultNameSuffixLen)
+		assert.True(t, strings.HasPrefix(generated, tc.expectedPrefix), "%q: %s", tc.name, generated)
+		assert.Len(t, generated, len(tc.expectedPrefix)+8, tc.name)
+		assert.LessOrEqual(t, len(generated), maxContainerNameLen, tc.name)
+		assert.Regexp(t, `^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`, generated, tc.name)
+	}
+	// names that differ only in invalid characters still produce different suffixes
+	assert.NotEqual(t, generateUniqueName("a/b", id, defaultNameSuffixLen), generateUniqueName("a:b", id, defaultNameSuffixLen))
+}
+
+func TestGenerateUniqueName_Properties(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	const alphabet = "abcXYZ019_.-/: ж"
+	randomString := func(maxLen int) string {
+		runes := []rune(alphabet)
+		b := make([]rune, r.Intn(maxLen+1))
+		for i := range b {
+			b[i] = runes[r.Intn(len(runes))]
+		}
+		return string(b)
+	}
+	for _, suffixLen := range []int{minNameSuffixLen, 12, 16, maxNameSuffixLen} {
+		seen := map[string]string{}
+		for i := 0; i < 10000; i++ {
+			// many pairs share the name or the human-readable part after truncation
+			name := randomString(80)
+			if i%2 == 0 {
+				name = strings.Repeat("a", 70)
+			}
+			id := fmt.Sprintf("%s-%d", randomString(8), i)
+			generated := generateUniqueName(name, id, suffixLen)
+			assert.LessOrEqual(t, len(generated), maxContainerNameLen)
+			assert.Regexp(t, `^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`, generated)
+			assert.Equal(t, "-"+generateNameSuffix(name, id, suffixLen), generated[len(generated)-suffixLen-1:])
+			// deterministic
+			assert.Equal(t, generated, generateUniqueName(name, id, suffixLen))
+			pair := name + "/" + id
+			previous, ok := seen[generated]
+			require.False(t, ok, "%s: %q and %q collide", generated, previous, pair)
+			seen[generated] = pair
+		}
+	}
+}
+
+func TestValidateNameSuffixLen(t *testing.T) {
+	suffixLen, err := validateNameSuffixLen(0)
+	assert.NoError(t, err)
+	assert.Equal(t, defaultNameSuffixLen, suffixLen)
+
+	suffixLen, err = validateNameSuffixLen(16)
+	assert.NoError(t, err)
+	assert.Equal(t, 16, suffixLen)
+
+	for _, suffixLen := range []int{-1, 7, 33, 64} {
+		_, err := validateNameSuffixLen(suffixLen)
+		assert.Error(t, err, suffixLen)
+	}
+}
+
+func TestDockerRunner_ContainerNameHashLength(t *testing.T) {
+	runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{containerNameHashLength: 16})
+	cfg := createTaskConfig(t)
+	cfg.Name = "vllm-0-0"
+	cfg.ID = "66a886db-86db-4cf9-8c06-8984ad15dde2"
+	require.NoError(t, runner.Submit(context.Background(), cfg))
+	assert.Equal(t, "vllm-0-0-cff1b8da"+generateNameSuffix(cfg.Name, cfg.ID, 16)[8:], runner.TaskInfo(cfg.ID).ContainerName)
+
+	_, err := newDockerRunner(context.Background(), newFakeDockerClient(), &dockerParametersMock{containerNameHashLength: 4}, []host.GpuInfo{})
+	assert.ErrorContains(t, err, "container name hash length must be in 8..32 range")
+}
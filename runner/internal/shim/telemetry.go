@@ -0,0 +1,230 @@
+package shim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/dstackai/dstack/runner/internal/log"
+)
+
+const telemetryServiceName = "dstack-shim"
+
+const telemetryInstrumentationName = "github.com/dstackai/dstack/runner/internal/shim"
+
+// Used if TelemetryConfig.MetricInterval is not set
+const defaultTelemetryMetricInterval = time.Minute
+
+// TelemetryConfig configures OpenTelemetry export, alongside Prometheus /metrics.
+// Empty OTLPEndpoint disables it
+type TelemetryConfig struct {
+	// An OTLP/HTTP collector URL, e.g., http://localhost:4318, traces and metrics are sent
+	// to the standard /v1/traces and /v1/metrics paths under it
+	OTLPEndpoint   string
+	MetricInterval time.Duration
+}
+
+// telemetry emits a span per task, from submit to the final status, with an event for each
+// status change and container event, see traceTask(), and task metrics
+type telemetry struct {
+	tracer        trace.Tracer
+	tasksFinished metric.Int64Counter
+	taskDuration  metric.Float64Histogram
+	queueDuration metric.Float64Histogram
+	// flushes and stops exporters, nil if providers are managed by the caller
+	shutdown func(context.Context) error
+}
+
+// newTelemetry returns nil if telemetry is disabled
+func newTelemetry(ctx context.Context, config TelemetryConfig) (*telemetry, error) {
+	if config.OTLPEndpoint == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(config.OTLPEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OTLP endpoint: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %s: scheme must be http or https", config.OTLPEndpoint)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL, semconv.ServiceName(telemetryServiceName),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("telemetry resource: %w", err)
+	}
+	// The exporters connect lazily, an unavailable collector doesn't prevent the shim from starting
+	traceExporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(u.JoinPath("v1", "traces").String()))
+	if err != nil {
+		return nil, fmt.Errorf("OTLP trace exporter: %w", err)
+	}
+	metricExporter, err := otlpmetrichttp.New(ctx, otlpmetrichttp.WithEndpointURL(u.JoinPath("v1", "metrics").String()))
+	if err != nil {
+		return nil, fmt.Errorf("OTLP metric exporter: %w", err)
+	}
+	interval := config.MetricInterval
+	if interval <= 0 {
+		interval = defaultTelemetryMetricInterval
+	}
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(traceExporter), sdktrace.WithResource(res))
+	meterProvider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter, sdkmetric.WithInterval(interval))),
+		sdkmetric.WithResource(res),
+	)
+	t, err := newTelemetryFromProviders(tracerProvider, meterProvider)
+	if err != nil {
+		return nil, err
+	}
+	t.shutdown = func(ctx context.Context) error {
+		return errors.Join(tracerProvider.Shutdown(ctx), meterProvider.Shutdown(ctx))
+	}
+	log.Info(ctx, "OpenTelemetry export enabled", "endpoint", config.OTLPEndpoint)
+	return t, nil
+}
+
+func newTelemetryFromProviders(tracerProvider trace.TracerProvider, meterProvider metric.MeterProvider) (*telemetry, error) {
+	meter := meterProvider.Meter(telemetryInstrumentationName)
+	tasksFinished, err := meter.Int64Counter(
+		"shim.tasks.finished", metric.WithDescription("Number of tasks that reached a final status, terminated or failed"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("telemetry metric: %w", err)
+	}
+	taskDuration, err := meter.Float64Histogram(
+		"shim.task.duration", metric.WithUnit("s"), metric.WithDescription("Time from submit to the final status"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("telemetry metric: %w", err)
+	}
+	queueDuration, err := meter.Float64Histogram(
+		"shim.task.queue.duration", metric.WithUnit("s"), metric.WithDescription("Time tasks spent in the queue waiting for a free slot"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("telemetry metric: %w", err)
+	}
+	return &telemetry{
+		tracer:        tracerProvider.Tracer(telemetryInstrumentationName),
+		tasksFinished: tasksFinished,
+		taskDuration:  taskDuration,
+		queueDuration: queueDuration,
+	}, nil
+}
+
+// CloseTelemetry flushes pending spans and metrics, it's a no-op if telemetry is disabled
+func (d *DockerRunner) CloseTelemetry(ctx context.Context) error {
+	if d.telemetry == nil || d.telemetry.shutdown == nil {
+		return nil
+	}
+	return d.telemetry.shutdown(ctx)
+}
+
+// traceTask follows the submitted task until it reaches a final status or is removed, and
+// emits its span. Span events are taken from the task history, so that their times are exact
+// even though storage notifications are coalesced. The state is not persisted, tasks restored
+// on shim restart are not traced
+func (d *DockerRunner) traceTask(task Task) {
+	updates, unsubscribe := d.tasks.Subscribe(task.ID)
+	defer unsubscribe()
+	_, span := d.telemetry.tracer.Start(
+		context.Background(), "task",
+		trace.WithTimestamp(task.submittedAt),
+		trace.WithAttributes(
+			attribute.String("dstack.task.id", task.ID),
+			attribute.String("dstack.task.name", task.config.Name),
+			attribute.String("dstack.task.image", task.config.ImageName),
+			attribute.String("dstack.trace_id", task.config.TraceID),
+		),
+	)
+	if task.config.RunID != "" {
+		span.SetAttributes(attribute.String("dstack.run.id", task.config.RunID))
+	}
+	// Events may share the same time, so they are tracked by count
+	var sentEvents int
+	var exitCode *int
+	for {
+		current, ok := d.tasks.Get(task.ID)
+		var events []TaskHistoryEvent
+		events, sentEvents = d.tasks.EventsSince(task.ID, sentEvents)
+		for _, event := range events {
+			if event.ExitCode != nil {
+				exitCode = event.ExitCode
+			}
+			span.AddEvent(getTaskSpanEventName(event), trace.WithTimestamp(event.Time), trace.WithAttributes(getTaskSpanEventAttributes(event)...))
+		}
+		if !ok {
+			span.SetAttributes(attribute.Bool("dstack.task.removed", true))
+			span.End()
+			return
+		}
+		if current.Status.IsFinished() {
+			d.endTaskSpan(span, current, exitCode)
+			return
+		}
+		<-updates
+	}
+}
+
+func (d *DockerRunner) endTaskSpan(span trace.Span, task Task, exitCode *int) {
+	span.SetAttributes(
+		attribute.String("dstack.task.status", string(task.Status)),
+		attribute.String("dstack.task.termination_reason", task.TerminationReason),
+		attribute.StringSlice("dstack.task.gpus", task.gpuIDs),
+	)
+	if exitCode != nil {
+		span.SetAttributes(attribute.Int("dstack.task.exit_code", *exitCode))
+	}
+	if task.Status == TaskStatusFailed {
+		span.SetStatus(codes.Error, task.TerminationMessage)
+	}
+	span.End()
+
+	ctx := context.Background()
+	status := metric.WithAttributes(attribute.String("status", string(task.Status)))
+	d.telemetry.tasksFinished.Add(ctx, 1, status)
+	now := d.clock.Now()
+	d.telemetry.taskDuration.Record(ctx, now.Sub(task.submittedAt).Seconds(), status)
+	if !task.startedAt.IsZero() {
+		d.telemetry.queueDuration.Record(ctx, task.QueuedDuration(now).Seconds())
+	}
+}
+
+// getTaskSpanEventName returns the status for status events, e.g., pulling, the type otherwise
+func getTaskSpanEventName(event TaskHistoryEvent) string {
+	if event.Type == TaskHistoryEventStatus {
+		return string(event.Status)
+	}
+	return string(event.Type)
+}
+
+func getTaskSpanEventAttributes(event TaskHistoryEvent) []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	if event.TerminationReason != "" {
+		attrs = append(attrs, attribute.String("dstack.task.termination_reason", event.TerminationReason))
+	}
+	if event.ContainerID != "" {
+		attrs = append(attrs, attribute.String("container.id", event.ContainerID))
+	}
+	if event.ExitCode != nil {
+		attrs = append(attrs, attribute.Int("dstack.container.exit_code", *event.ExitCode))
+	}
+	if event.OOMKilled {
+		attrs = append(attrs, attribute.Bool("dstack.container.oom_killed", true))
+	}
+	if event.Message != "" {
+		attrs = append(attrs, attribute.String("message", event.Message))
+	}
+	return attrs
+}
@@ -0,0 +1,166 @@
+package shim
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/dstackai/dstack/runner/internal/shim/host"
+)
+
+func newTelemetryTestRunner(t *testing.T, client *fakeDockerClient) (*DockerRunner, *tracetest.InMemoryExporter, *sdkmetric.ManualReader) {
+	t.Helper()
+	gpus := []host.GpuInfo{{Vendor: host.GpuVendorNvidia, ID: "GPU-beef"}}
+	runner, err := newDockerRunner(context.Background(), client, &dockerParametersMock{}, gpus)
+	require.NoError(t, err)
+	exporter := tracetest.NewInMemoryExporter()
+	reader := sdkmetric.NewManualReader()
+	runner.telemetry, err = newTelemetryFromProviders(
+		sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)),
+		sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)),
+	)
+	require.NoError(t, err)
+	return runner, exporter, reader
+}
+
+func waitTaskSpan(t *testing.T, exporter *tracetest.InMemoryExporter) tracetest.SpanStub {
+	t.Helper()
+	require.Eventually(t, func() bool { return len(exporter.GetSpans()) > 0 }, 5*time.Second, time.Millisecond)
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 1)
+	return spans[0]
+}
+
+func getSpanAttributes(span tracetest.SpanStub) map[attribute.Key]attribute.Value {
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, attr := range span.Attributes {
+		attrs[attr.Key] = attr.Value
+	}
+	return attrs
+}
+
+func getSpanEventNames(span tracetest.SpanStub) []string {
+	var names []string
+	for _, event := range span.Events {
+		names = append(names, event.Name)
+	}
+	return names
+}
+
+func TestDockerRunner_Telemetry_TaskSpan(t *testing.T) {
+	client := newFakeDockerClient()
+	runner, exporter, reader := newTelemetryTestRunner(t, client)
+	cfg := createTaskConfig(t)
+	cfg.GPU = 1
+	cfg.RunID = "run-1"
+	containerID := runTask(t, runner, cfg)
+	// the span is emitted once the task is finished
+	assert.Empty(t, exporter.GetSpans())
+
+	client.exitContainer(containerID, 3)
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusFailed)
+	span := waitTaskSpan(t, exporter)
+
+	info := runner.TaskInfo(cfg.ID)
+	assert.Equal(t, "task", span.Name)
+	assert.Equal(t, codes.Error, span.Status.Code)
+	attrs := getSpanAttributes(span)
+	assert.Equal(t, cfg.ID, attrs["dstack.task.id"].AsString())
+	assert.Equal(t, "ubuntu", attrs["dstack.task.image"].AsString())
+	assert.Equal(t, "run-1", attrs["dstack.run.id"].AsString())
+	assert.Equal(t, info.TraceID, attrs["dstack.trace_id"].AsString())
+	assert.Equal(t, []string{"GPU-beef"}, attrs["dstack.task.gpus"].AsStringSlice())
+	assert.Equal(t, int64(3), attrs["dstack.task.exit_code"].AsInt64())
+	assert.Equal(t, "failed", attrs["dstack.task.status"].AsString())
+	assert.Equal(t, "CONTAINER_EXITED_WITH_ERROR", attrs["dstack.task.termination_reason"].AsString())
+
+	assert.Equal(t, []string{
+		"pending", "preparing", "pulling", "creating", "running", "container_started", "container_exited", "failed",
+	}, getSpanEventNames(span))
+	for i, event := range span.Events {
+		assert.Equal(t, info.Events[i].Time, event.Time)
+	}
+	// from submit
+	assert.False(t, span.StartTime.After(info.Events[0].Time))
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	require.Len(t, rm.ScopeMetrics, 1)
+	metrics := map[string]metricdata.Aggregation{}
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		metrics[m.Name] = m.Data
+	}
+	finished, ok := metrics["shim.tasks.finished"].(metricdata.Sum[int64])
+	require.True(t, ok)
+	require.Len(t, finished.DataPoints, 1)
+	assert.Equal(t, int64(1), finished.DataPoints[0].Value)
+	status, _ := finished.DataPoints[0].Attributes.Value("status")
+	assert.Equal(t, "failed", status.AsString())
+	duration, ok := metrics["shim.task.duration"].(metricdata.Histogram[float64])
+	require.True(t, ok)
+	assert.Equal(t, uint64(1), duration.DataPoints[0].Count)
+	queue, ok := metrics["shim.task.queue.duration"].(metricdata.Histogram[float64])
+	require.True(t, ok)
+	assert.Equal(t, uint64(1), queue.DataPoints[0].Count)
+}
+
+func TestDockerRunner_Telemetry_Terminated(t *testing.T) {
+	client := newFakeDockerClient()
+	runner, exporter, _ := newTelemetryTestRunner(t, client)
+	cfg := createTaskConfig(t)
+	runTask(t, runner, cfg)
+
+	require.NoError(t, runner.Terminate(context.Background(), cfg.ID, nil, "TERMINATED_BY_USER", ""))
+	span := waitTaskSpan(t, exporter)
+
+	assert.Equal(t, codes.Unset, span.Status.Code)
+	attrs := getSpanAttributes(span)
+	assert.Equal(t, "terminated", attrs["dstack.task.status"].AsString())
+	assert.Equal(t, "TERMINATED_BY_USER", attrs["dstack.task.termination_reason"].AsString())
+	// container_exited is recorded by Run() concurrently, before or after the span has ended
+	assert.Contains(t, getSpanEventNames(span), "terminated")
+}
+
+func TestDockerRunner_Telemetry_Removed(t *testing.T) {
+	client := newFakeDockerClient()
+	runner, exporter, _ := newTelemetryTestRunner(t, client)
+	cfg := createTaskConfig(t)
+	// not run, the task stays pending
+	require.NoError(t, runner.Submit(context.Background(), cfg))
+	runner.tasks.Delete(cfg.ID)
+
+	span := waitTaskSpan(t, exporter)
+	attrs := getSpanAttributes(span)
+	assert.True(t, attrs["dstack.task.removed"].AsBool())
+}
+
+func TestDockerRunner_Telemetry_Disabled(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	assert.Nil(t, runner.telemetry)
+	assert.NoError(t, runner.CloseTelemetry(context.Background()))
+}
+
+func TestNewTelemetry(t *testing.T) {
+	tel, err := newTelemetry(context.Background(), TelemetryConfig{})
+	assert.NoError(t, err)
+	assert.Nil(t, tel)
+
+	_, err = newTelemetry(context.Background(), TelemetryConfig{OTLPEndpoint: "localhost:4318"})
+	assert.Error(t, err)
+
+	// the collector is not contacted until there is something to export
+	tel, err = newTelemetry(context.Background(), TelemetryConfig{OTLPEndpoint: "http://localhost:4318"})
+	require.NoError(t, err)
+	require.NotNil(t, tel)
+	assert.NotNil(t, tel.shutdown)
+}
@@ -0,0 +1,90 @@
+package shim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/dstackai/dstack/runner/internal/log"
+)
+
+// The maximum number of tasks terminated concurrently by TerminateBatch()
+const terminateBatchParallelism = 8
+
+type TerminateResultStatus string
+
+const (
+	// The task was not finished and has been terminated
+	TerminateResultStopped TerminateResultStatus = "stopped"
+	// The task had already been terminated or failed, nothing was done
+	TerminateResultAlreadyTerminated TerminateResultStatus = "already_terminated"
+	TerminateResultNotFound          TerminateResultStatus = "not_found"
+	TerminateResultError             TerminateResultStatus = "error"
+)
+
+type TerminateResult struct {
+	TaskID string                `json:"id"`
+	Status TerminateResultStatus `json:"status"`
+	// Set if Status is error or not_found
+	Error string `json:"error,omitempty"`
+}
+
+// TerminateBatch terminates the tasks as Terminate() does, up to terminateBatchParallelism
+// tasks at a time, and returns a result for each unique task ID, in the order of taskIDs.
+// A failure to terminate one task does not affect the others.
+// If all is true, taskIDs must be empty, and all tasks known to the shim are terminated
+func (d *DockerRunner) TerminateBatch(ctx context.Context, taskIDs []string, all bool, timeout *uint, reason string, message string) ([]TerminateResult, error) {
+	if all {
+		if len(taskIDs) > 0 {
+			return nil, fmt.Errorf("%w: either task IDs or all must be set, not both", ErrRequest)
+		}
+		taskIDs = d.tasks.IDs()
+	} else if len(taskIDs) == 0 {
+		return nil, fmt.Errorf("%w: either task IDs or all must be set", ErrRequest)
+	}
+	// Terminations of the same task are serialized, see Task.Lock(), a duplicate would only
+	// wait for the first one to report the task as already finished
+	seen := make(map[string]bool, len(taskIDs))
+	uniqueIDs := make([]string, 0, len(taskIDs))
+	for _, taskID := range taskIDs {
+		if !seen[taskID] {
+			seen[taskID] = true
+			uniqueIDs = append(uniqueIDs, taskID)
+		}
+	}
+
+	results := make([]TerminateResult, len(uniqueIDs))
+	sem := make(chan struct{}, terminateBatchParallelism)
+	var wg sync.WaitGroup
+	for i, taskID := range uniqueIDs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = d.terminateBatchItem(ctx, taskID, timeout, reason, message)
+		}()
+	}
+	wg.Wait()
+	return results, nil
+}
+
+func (d *DockerRunner) terminateBatchItem(ctx context.Context, taskID string, timeout *uint, reason string, message string) TerminateResult {
+	result := TerminateResult{TaskID: taskID}
+	wasTerminated, err := d.terminateTask(ctx, taskID, timeout, reason, message)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		result.Status = TerminateResultNotFound
+		result.Error = err.Error()
+	case err != nil:
+		log.Error(ctx, "failed to terminate task in batch", "task", taskID, "err", err)
+		result.Status = TerminateResultError
+		result.Error = err.Error()
+	case wasTerminated:
+		result.Status = TerminateResultAlreadyTerminated
+	default:
+		result.Status = TerminateResultStopped
+	}
+	return result
+}
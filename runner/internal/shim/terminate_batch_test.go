@@ -0,0 +1,85 @@
+package shim
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDockerRunner_TerminateBatch(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	running := createTaskConfig(t)
+	runTask(t, runner, running)
+	pending := createTaskConfig(t)
+	require.NoError(t, runner.Submit(context.Background(), pending))
+	finished := createTaskConfig(t)
+	client.exitContainer(runTask(t, runner, finished), 0)
+	waitTaskStatus(t, runner, finished.ID, TaskStatusTerminated)
+
+	// one failing stop doesn't abort the others
+	client.injectErrors("ContainerStop", errors.New("daemon is busy"))
+	results, err := runner.TerminateBatch(
+		context.Background(), []string{running.ID, pending.ID, finished.ID, "unknown", running.ID}, false, nil, "HOST_DRAINING", "",
+	)
+	require.NoError(t, err)
+	require.Len(t, results, 4)
+	assert.Equal(t, TerminateResult{TaskID: running.ID, Status: TerminateResultError, Error: "internal error: failed to stop container: daemon is busy"}, results[0])
+	assert.Equal(t, TerminateResult{TaskID: pending.ID, Status: TerminateResultStopped}, results[1])
+	assert.Equal(t, TerminateResult{TaskID: finished.ID, Status: TerminateResultAlreadyTerminated}, results[2])
+	assert.Equal(t, TerminateResultNotFound, results[3].Status)
+	assert.Equal(t, "unknown", results[3].TaskID)
+	assert.Equal(t, TaskStatusRunning, runner.TaskInfo(running.ID).Status)
+	assert.Equal(t, "HOST_DRAINING", runner.TaskInfo(pending.ID).TerminationReason)
+	// not overwritten
+	assert.Equal(t, "DONE_BY_RUNNER", runner.TaskInfo(finished.ID).TerminationReason)
+
+	// all
+	results, err = runner.TerminateBatch(context.Background(), nil, true, nil, "HOST_DRAINING", "")
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	for _, result := range results {
+		if result.TaskID == running.ID {
+			assert.Equal(t, TerminateResultStopped, result.Status)
+		} else {
+			assert.Equal(t, TerminateResultAlreadyTerminated, result.Status, result.TaskID)
+		}
+	}
+	waitTaskStatus(t, runner, running.ID, TaskStatusTerminated)
+	assert.Equal(t, "HOST_DRAINING", runner.TaskInfo(running.ID).TerminationReason)
+}
+
+func TestDockerRunner_TerminateBatch_Parallel(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	var taskIDs []string
+	for range terminateBatchParallelism * 2 {
+		cfg := createTaskConfig(t)
+		runTask(t, runner, cfg)
+		taskIDs = append(taskIDs, cfg.ID)
+	}
+
+	results, err := runner.TerminateBatch(context.Background(), taskIDs, false, nil, "HOST_DRAINING", "")
+	require.NoError(t, err)
+	require.Len(t, results, len(taskIDs))
+	for i, result := range results {
+		assert.Equal(t, TerminateResult{TaskID: taskIDs[i], Status: TerminateResultStopped}, result)
+		waitTaskStatus(t, runner, taskIDs[i], TaskStatusTerminated)
+	}
+}
+
+func TestDockerRunner_TerminateBatch_BadRequest(t *testing.T) {
+	runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{})
+	_, err := runner.TerminateBatch(context.Background(), nil, false, nil, "", "")
+	assert.ErrorIs(t, err, ErrRequest)
+	_, err = runner.TerminateBatch(context.Background(), []string{"task"}, true, nil, "", "")
+	assert.ErrorIs(t, err, ErrRequest)
+
+	// no tasks
+	results, err := runner.TerminateBatch(context.Background(), nil, true, nil, "", "")
+	require.NoError(t, err)
+	assert.Empty(t, results)
+}
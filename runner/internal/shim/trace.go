@@ -0,0 +1,51 @@
+package shim
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+
+	"github.com/dstackai/dstack/runner/internal/log"
+)
+
+// Set on containers to TaskConfig.TraceID, so that the trace ID is restored on shim restart
+const LabelKeyTraceID = LabelKeyPrefix + "trace-id"
+
+// The container env var with TaskConfig.TraceID, for applications to include it in their logs
+const TraceIDEnvVar = "DSTACK_TRACE_ID"
+
+const maxTraceIDLength = 128
+
+// Safe for log lines, labels, and env values without quoting
+var traceIDRegex = regexp.MustCompile(`^[A-Za-z0-9._:-]+$`)
+
+func validateTraceID(traceID string) error {
+	if traceID == "" {
+		return nil
+	}
+	if len(traceID) > maxTraceIDLength {
+		return fmt.Errorf("%w: trace_id is too long: %d > %d", ErrInvalidConfig, len(traceID), maxTraceIDLength)
+	}
+	if !traceIDRegex.MatchString(traceID) {
+		return fmt.Errorf("%w: trace_id %q must only contain letters, digits, and . _ : -", ErrInvalidConfig, traceID)
+	}
+	return nil
+}
+
+// generateTraceID returns 32 random hex characters, the W3C Trace Context trace-id format
+func generateTraceID() string {
+	b := make([]byte, 16)
+	// never returns an error on Linux
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// withTraceID adds the trace ID to all log lines written with the returned context
+func withTraceID(ctx context.Context, traceID string) context.Context {
+	if traceID == "" {
+		return ctx
+	}
+	return log.AppendArgsCtx(ctx, "trace_id", traceID)
+}
@@ -0,0 +1,89 @@
+package shim
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dstackai/dstack/runner/internal/log"
+)
+
+func TestDockerRunner_TraceID(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.TraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	cfg.Env = map[string]string{"FOO": "bar"}
+	containerID := runTask(t, runner, cfg)
+	defer client.exitContainer(containerID, 0)
+
+	ctr, err := client.getContainer(containerID)
+	require.NoError(t, err)
+	assert.Contains(t, ctr.config.Env, "DSTACK_TRACE_ID=4bf92f3577b34da6a3ce929d0e0e4736")
+	assert.Equal(t, cfg.TraceID, ctr.config.Labels[LabelKeyTraceID])
+	assert.Equal(t, cfg.TraceID, runner.TaskInfo(cfg.ID).TraceID)
+}
+
+func TestDockerRunner_TraceID_Generated(t *testing.T) {
+	runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{})
+	cfgA := createTaskConfig(t)
+	cfgB := createTaskConfig(t)
+
+	require.NoError(t, runner.Submit(context.Background(), cfgA))
+	require.NoError(t, runner.Submit(context.Background(), cfgB))
+
+	traceIDA := runner.TaskInfo(cfgA.ID).TraceID
+	assert.Regexp(t, "^[0-9a-f]{32}$", traceIDA)
+	assert.NotEqual(t, traceIDA, runner.TaskInfo(cfgB.ID).TraceID)
+}
+
+func TestDockerRunner_TraceID_Restore(t *testing.T) {
+	client := newFakeDockerClient()
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.TraceID = "trace"
+	containerID := runTask(t, runner, cfg)
+	defer client.exitContainer(containerID, 0)
+
+	restored := newFakeDockerRunner(t, client, &dockerParametersMock{})
+
+	assert.Equal(t, "trace", restored.TaskInfo(cfg.ID).TraceID)
+}
+
+func TestDockerRunner_TraceID_LogsAndAudit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	runner := newFakeDockerRunner(t, newFakeDockerClient(), &dockerParametersMock{auditLog: AuditLogConfig{Path: path}})
+	cfg := createTaskConfig(t)
+	cfg.TraceID = "trace-42"
+	var buf bytes.Buffer
+	ctx := log.WithLogger(context.Background(), log.NewEntry(&buf, int(logrus.DebugLevel)))
+
+	require.NoError(t, runner.Submit(ctx, cfg))
+	require.NoError(t, runner.Terminate(ctx, cfg.ID, nil, "TERMINATED_BY_SERVER", ""))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.NotEmpty(t, lines)
+	for _, line := range lines {
+		assert.Contains(t, line, "trace_id=trace-42")
+	}
+	require.Eventually(t, func() bool { return len(readAuditRecords(t, path)) == 2 }, 5*time.Second, 10*time.Millisecond)
+	for _, record := range readAuditRecords(t, path) {
+		assert.Equal(t, "trace-42", record.TraceID)
+	}
+}
+
+func TestValidateTraceID(t *testing.T) {
+	for _, traceID := range []string{"", "4bf92f3577b34da6a3ce929d0e0e4736", "run-1.job_2:3"} {
+		assert.NoError(t, validateTraceID(traceID), traceID)
+	}
+	for _, traceID := range []string{"with space", "a\nb", "a=b", strings.Repeat("a", maxTraceIDLength+1)} {
+		assert.ErrorIs(t, validateTraceID(traceID), ErrInvalidConfig, traceID)
+	}
+}
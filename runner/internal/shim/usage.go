@@ -0,0 +1,168 @@
+package shim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/dstackai/dstack/runner/internal/log"
+)
+
+// How often container resource usage is sampled while the task is running, by default
+const defaultUsageSampleInterval = 10 * time.Second
+
+// ResourceSummary is the resource usage of the task over its lifetime, computed at termination.
+// CPU time and peak values are as of the last sample, that is, usage after the last sample
+// is not accounted (a stopped container has no stats)
+type ResourceSummary struct {
+	PeakMemory uint64  `json:"peak_memory"` // bytes
+	CPUSeconds float64 `json:"cpu_seconds"`
+	// bytes, the sum for all task GPUs; only sampled if GPUs are used exclusively by the task
+	PeakGPUMemory uint64 `json:"peak_gpu_memory"`
+	// the number of GPUs (multiplied by gpu_memory_fraction if shared) times the running time
+	GPUHours float64 `json:"gpu_hours"`
+	Samples  int     `json:"samples"`
+}
+
+type usageSample struct {
+	MemoryUsage    uint64 // bytes
+	MemoryMaxUsage uint64 // bytes, the cgroup-recorded peak, cgroup v1 only
+	CPUUsage       uint64 // nanoseconds, cumulative since the container start
+	GPUMemoryUsage uint64 // bytes
+}
+
+type usageAccumulator struct {
+	samples       int
+	peakMemory    uint64
+	cpuUsage      uint64
+	peakGPUMemory uint64
+}
+
+func (a *usageAccumulator) Add(sample usageSample) {
+	a.samples++
+	a.peakMemory = max(a.peakMemory, sample.MemoryUsage, sample.MemoryMaxUsage)
+	// the counter is cumulative, but don't trust that it never goes backwards
+	a.cpuUsage = max(a.cpuUsage, sample.CPUUsage)
+	a.peakGPUMemory = max(a.peakGPUMemory, sample.GPUMemoryUsage)
+}
+
+// Summary returns the summary for the task that ran for the duration with gpuCount GPUs,
+// gpuCount is fractional for shared GPUs
+func (a *usageAccumulator) Summary(gpuCount float64, duration time.Duration) ResourceSummary {
+	return ResourceSummary{
+		PeakMemory:    a.peakMemory,
+		CPUSeconds:    time.Duration(a.cpuUsage).Seconds(),
+		PeakGPUMemory: a.peakGPUMemory,
+		GPUHours:      gpuCount * duration.Hours(),
+		Samples:       a.samples,
+	}
+}
+
+// usageSampler periodically samples resource usage of the running task container
+type usageSampler struct {
+	d           *DockerRunner
+	taskID      string
+	containerID string
+	gpuIDs      []string
+	// 0.0 if GPUs are used exclusively, see Task.gpuMemoryFraction
+	gpuMemoryFraction float64
+	startedAt         time.Time
+	acc               usageAccumulator
+	cancel            context.CancelFunc
+	done              chan struct{}
+	mu                sync.Mutex
+}
+
+// startUsageSampler starts sampling, the task must be running. Stop() must be called
+// when the container exits
+func (d *DockerRunner) startUsageSampler(ctx context.Context, task *Task) *usageSampler {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &usageSampler{
+		d:                 d,
+		taskID:            task.ID,
+		containerID:       task.containerID,
+		gpuIDs:            task.gpuIDs,
+		gpuMemoryFraction: task.gpuMemoryFraction,
+		startedAt:         time.Now(),
+		cancel:            cancel,
+		done:              make(chan struct{}),
+	}
+	go s.run(ctx)
+	return s
+}
+
+func (s *usageSampler) run(ctx context.Context) {
+	defer close(s.done)
+	ticker := time.NewTicker(s.d.usageSampleInterval)
+	defer ticker.Stop()
+	for {
+		s.sample(ctx)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *usageSampler) sample(ctx context.Context) {
+	sample, err := s.getUsageSample(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Warning(ctx, "failed to sample resource usage", "task", s.taskID, "err", err)
+		}
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.acc.Add(sample)
+}
+
+// Stop stops sampling and returns the summary
+func (s *usageSampler) Stop() ResourceSummary {
+	duration := time.Since(s.startedAt)
+	s.cancel()
+	<-s.done
+	gpuCount := float64(len(s.gpuIDs))
+	if s.gpuMemoryFraction > 0 {
+		gpuCount *= s.gpuMemoryFraction
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.acc.Summary(gpuCount, duration)
+}
+
+func (s *usageSampler) getUsageSample(ctx context.Context) (usageSample, error) {
+	resp, err := s.d.client.ContainerStats(ctx, s.containerID, false)
+	if err != nil {
+		return usageSample{}, fmt.Errorf("failed to get container stats: %w", err)
+	}
+	defer resp.Body.Close()
+	var stats types.StatsJSON
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return usageSample{}, fmt.Errorf("failed to decode container stats: %w", err)
+	}
+	sample := usageSample{
+		MemoryUsage:    stats.MemoryStats.Usage,
+		MemoryMaxUsage: stats.MemoryStats.MaxUsage,
+		CPUUsage:       stats.CPUStats.CPUUsage.TotalUsage,
+	}
+	// the memory of shared GPUs cannot be attributed to the task
+	if s.d.gpuMemoryUsage != nil && len(s.gpuIDs) > 0 && s.gpuMemoryFraction == 0 {
+		// e.g., the driver is broken after the shim start, CPU and memory usage is still sampled
+		if usage, err := s.d.gpuMemoryUsage(ctx); err != nil {
+			if ctx.Err() == nil {
+				log.Warning(ctx, "failed to get GPU memory usage", "task", s.taskID, "err", err)
+			}
+		} else {
+			for _, gpuID := range s.gpuIDs {
+				// MiB
+				sample.GPUMemoryUsage += uint64(usage[gpuID]) * 1024 * 1024
+			}
+		}
+	}
+	return sample, nil
+}
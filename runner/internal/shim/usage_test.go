@@ -0,0 +1,143 @@
+package shim
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/dstackai/dstack/runner/internal/shim/host"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsageAccumulator(t *testing.T) {
+	var acc usageAccumulator
+	acc.Add(usageSample{MemoryUsage: 100, CPUUsage: uint64(time.Second), GPUMemoryUsage: 2048})
+	acc.Add(usageSample{MemoryUsage: 300, CPUUsage: uint64(3 * time.Second), GPUMemoryUsage: 4096})
+	acc.Add(usageSample{MemoryUsage: 200, MemoryMaxUsage: 250, CPUUsage: uint64(5 * time.Second), GPUMemoryUsage: 1024})
+
+	assert.Equal(t, ResourceSummary{
+		PeakMemory:    300,
+		CPUSeconds:    5,
+		PeakGPUMemory: 4096,
+		GPUHours:      3,
+		Samples:       3,
+	}, acc.Summary(2, 90*time.Minute))
+}
+
+func TestUsageAccumulator_MaxUsage(t *testing.T) {
+	var acc usageAccumulator
+	acc.Add(usageSample{MemoryUsage: 100, MemoryMaxUsage: 500, CPUUsage: uint64(2 * time.Second)})
+	// the counter went backwards, e.g., due to a bogus sample
+	acc.Add(usageSample{MemoryUsage: 200, CPUUsage: 0})
+
+	summary := acc.Summary(0.5, time.Hour)
+	assert.Equal(t, uint64(500), summary.PeakMemory)
+	assert.Equal(t, 2.0, summary.CPUSeconds)
+	assert.Equal(t, 0.5, summary.GPUHours)
+}
+
+func TestUsageAccumulator_NoSamples(t *testing.T) {
+	var acc usageAccumulator
+	assert.Equal(t, ResourceSummary{GPUHours: 1}, acc.Summary(1, time.Hour))
+}
+
+func TestDockerRunner_ResourceSummary(t *testing.T) {
+	client := newFakeDockerClient()
+	client.stats = []types.StatsJSON{
+		newFakeStats(1<<30, 10*time.Second),
+		newFakeStats(3<<30, 20*time.Second),
+		newFakeStats(2<<30, 30*time.Second),
+	}
+	gpus := []host.GpuInfo{{Vendor: host.GpuVendorNvidia, ID: "GPU-beef", Vram: 81920}}
+	runner, err := newDockerRunner(context.Background(), client, &dockerParametersMock{}, gpus)
+	require.NoError(t, err)
+	runner.usageSampleInterval = time.Millisecond
+	runner.gpuMemoryUsage = func(context.Context) (map[string]int, error) {
+		return map[string]int{"GPU-beef": 1024, "GPU-dead": 2048}, nil
+	}
+	cfg := createTaskConfig(t)
+	cfg.GPU = 1
+	containerID := runTask(t, runner, cfg)
+	require.Nil(t, runner.TaskInfo(cfg.ID).ResourceSummary)
+	require.Eventually(t, func() bool {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		return client.statsCount >= len(client.stats)
+	}, 5*time.Second, time.Millisecond)
+	client.exitContainer(containerID, 0)
+
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusTerminated)
+	summary := runner.TaskInfo(cfg.ID).ResourceSummary
+	require.NotNil(t, summary)
+	assert.Equal(t, uint64(3<<30), summary.PeakMemory)
+	assert.Equal(t, 30.0, summary.CPUSeconds)
+	assert.Equal(t, uint64(1<<30), summary.PeakGPUMemory)
+	assert.Greater(t, summary.GPUHours, 0.0)
+	assert.GreaterOrEqual(t, summary.Samples, len(client.stats))
+}
+
+func TestDockerRunner_ResourceSummary_GPUMemoryUsageError(t *testing.T) {
+	client := newFakeDockerClient()
+	client.stats = []types.StatsJSON{newFakeStats(1<<30, 10*time.Second)}
+	gpus := []host.GpuInfo{{Vendor: host.GpuVendorNvidia, ID: "GPU-beef", Vram: 81920}}
+	runner, err := newDockerRunner(context.Background(), client, &dockerParametersMock{}, gpus)
+	require.NoError(t, err)
+	runner.usageSampleInterval = time.Millisecond
+	runner.gpuMemoryUsage = func(context.Context) (map[string]int, error) {
+		return nil, errors.New("failed to execute nvidia-smi: exec: \"nvidia-smi\": executable file not found in $PATH")
+	}
+	cfg := createTaskConfig(t)
+	cfg.GPU = 1
+	containerID := runTask(t, runner, cfg)
+	require.Eventually(t, func() bool {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		return client.statsCount >= len(client.stats)
+	}, 5*time.Second, time.Millisecond)
+	client.exitContainer(containerID, 0)
+
+	waitTaskStatus(t, runner, cfg.ID, TaskStatusTerminated)
+	summary := runner.TaskInfo(cfg.ID).ResourceSummary
+	require.NotNil(t, summary)
+	assert.Equal(t, uint64(1<<30), summary.PeakMemory)
+	assert.Equal(t, 10.0, summary.CPUSeconds)
+	assert.Equal(t, uint64(0), summary.PeakGPUMemory)
+	assert.Greater(t, summary.Samples, 0)
+}
+
+func TestDockerRunner_ResourceSummary_Terminated(t *testing.T) {
+	client := newFakeDockerClient()
+	client.stats = []types.StatsJSON{newFakeStats(1<<30, 10*time.Second)}
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	runner.usageSampleInterval = time.Millisecond
+	cfg := createTaskConfig(t)
+	runTask(t, runner, cfg)
+	require.Eventually(t, func() bool {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		return client.statsCount > 0
+	}, 5*time.Second, time.Millisecond)
+
+	timeout := uint(0)
+	require.NoError(t, runner.Terminate(context.Background(), cfg.ID, &timeout, "TERMINATED_BY_USER", ""))
+
+	// the summary is produced by Run() after the container is killed
+	require.Eventually(t, func() bool {
+		return runner.TaskInfo(cfg.ID).ResourceSummary != nil
+	}, 5*time.Second, time.Millisecond)
+	taskInfo := runner.TaskInfo(cfg.ID)
+	assert.Equal(t, "TERMINATED_BY_USER", taskInfo.TerminationReason)
+	assert.Equal(t, uint64(1<<30), taskInfo.ResourceSummary.PeakMemory)
+	assert.Equal(t, 10.0, taskInfo.ResourceSummary.CPUSeconds)
+	assert.Equal(t, 0.0, taskInfo.ResourceSummary.GPUHours)
+}
+
+func newFakeStats(memoryUsage uint64, cpuUsage time.Duration) types.StatsJSON {
+	var stats types.StatsJSON
+	stats.MemoryStats.Usage = memoryUsage
+	stats.CPUStats.CPUUsage.TotalUsage = uint64(cpuUsage)
+	return stats
+}
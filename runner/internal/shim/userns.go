@@ -0,0 +1,56 @@
+package shim
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/docker/docker/api/types/container"
+	dockersystem "github.com/docker/docker/api/types/system"
+)
+
+const (
+	// Run in the host user namespace even if the daemon remaps user namespaces
+	UsernsModeHost = "host"
+	// Require the daemon to remap the container user namespace, see userns-remap daemon option.
+	// The remapping itself (the subordinate UID/GID range) is configured daemon-wide
+	UsernsModeRemap = "remap"
+)
+
+// isUsernsRemapEnabled reports whether the daemon runs with userns-remap option
+func isUsernsRemapEnabled(info dockersystem.Info) bool {
+	return slices.Contains(info.SecurityOptions, "name=userns")
+}
+
+// getUsernsMode validates the task's user namespace mode and returns HostConfig.UsernsMode.
+// With user namespace remapping, container root is an unprivileged user on the host,
+// which is incompatible with privileged mode and sharing host namespaces or the Docker socket, see
+// https://docs.docker.com/engine/security/userns-remap/#user-namespace-known-limitations
+func getUsernsMode(cfg TaskConfig, privileged bool, remapEnabled bool) (container.UsernsMode, error) {
+	switch cfg.UsernsMode {
+	case "":
+		// the daemon default
+		return "", nil
+	case UsernsModeHost:
+		return container.UsernsMode(UsernsModeHost), nil
+	case UsernsModeRemap:
+		if !remapEnabled {
+			return "", fmt.Errorf("%w: userns_mode is %q, but user namespace remapping is not enabled on this host", ErrInvalidConfig, cfg.UsernsMode)
+		}
+		if privileged {
+			return "", fmt.Errorf("%w: privileged mode is not allowed with userns_mode %q", ErrInvalidConfig, cfg.UsernsMode)
+		}
+		if cfg.NetworkMode == NetworkModeHost {
+			return "", fmt.Errorf("%w: host network mode is not allowed with userns_mode %q", ErrInvalidConfig, cfg.UsernsMode)
+		}
+		if cfg.DockerSocket != "" {
+			return "", fmt.Errorf("%w: docker_socket is not allowed with userns_mode %q", ErrInvalidConfig, cfg.UsernsMode)
+		}
+		// Remapped by the daemon, there is no per-container remapping setting
+		return "", nil
+	default:
+		return "", fmt.Errorf(
+			"%w: userns_mode must be either %q or %q, got %q",
+			ErrInvalidConfig, UsernsModeHost, UsernsModeRemap, cfg.UsernsMode,
+		)
+	}
+}
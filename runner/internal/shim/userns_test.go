@@ -0,0 +1,72 @@
+package shim
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetUsernsMode(t *testing.T) {
+	mode, err := getUsernsMode(TaskConfig{}, true, true)
+	assert.NoError(t, err)
+	assert.Equal(t, container.UsernsMode(""), mode)
+
+	// host is always allowed, this is how privileged tasks run on a remapping daemon
+	mode, err = getUsernsMode(TaskConfig{UsernsMode: "host", Privileged: true}, true, true)
+	assert.NoError(t, err)
+	assert.Equal(t, container.UsernsMode("host"), mode)
+
+	mode, err = getUsernsMode(TaskConfig{UsernsMode: "remap"}, false, true)
+	assert.NoError(t, err)
+	assert.Equal(t, container.UsernsMode(""), mode)
+}
+
+func TestGetUsernsMode_Errors(t *testing.T) {
+	testCases := []struct {
+		cfg          TaskConfig
+		privileged   bool
+		remapEnabled bool
+		err          string
+	}{
+		{TaskConfig{UsernsMode: "remap"}, false, false, "user namespace remapping is not enabled"},
+		{TaskConfig{UsernsMode: "remap"}, true, true, "privileged mode is not allowed"},
+		{TaskConfig{UsernsMode: "remap", NetworkMode: NetworkModeHost}, false, true, "host network mode is not allowed"},
+		{TaskConfig{UsernsMode: "remap", DockerSocket: "ro"}, false, true, "docker_socket is not allowed"},
+		{TaskConfig{UsernsMode: "private"}, false, true, "userns_mode must be either"},
+	}
+	for _, tc := range testCases {
+		_, err := getUsernsMode(tc.cfg, tc.privileged, tc.remapEnabled)
+		assert.ErrorIs(t, err, ErrInvalidConfig, tc.err)
+		assert.ErrorContains(t, err, tc.err)
+	}
+}
+
+func TestDockerRunner_UsernsMode(t *testing.T) {
+	client := newFakeDockerClient()
+	client.info.SecurityOptions = []string{"name=seccomp,profile=builtin", "name=userns"}
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{})
+	cfg := createTaskConfig(t)
+	cfg.UsernsMode = "host"
+	cfg.Privileged = true
+	containerID := runTask(t, runner, cfg)
+	defer client.exitContainer(containerID, 0)
+
+	ctr, err := client.getContainer(containerID)
+	require.NoError(t, err)
+	assert.Equal(t, container.UsernsMode("host"), ctr.hostConfig.UsernsMode)
+}
+
+func TestDockerRunner_UsernsMode_SubmitRejected(t *testing.T) {
+	client := newFakeDockerClient()
+	client.info.SecurityOptions = []string{"name=userns"}
+	// privileged is forced by the operator
+	runner := newFakeDockerRunner(t, client, &dockerParametersMock{privileged: true})
+	cfg := createTaskConfig(t)
+	cfg.UsernsMode = "remap"
+
+	assert.ErrorIs(t, runner.Submit(context.Background(), cfg), ErrInvalidConfig)
+	assert.Equal(t, "", runner.TaskInfo(cfg.ID).ID)
+}
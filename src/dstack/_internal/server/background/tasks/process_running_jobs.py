@@ -505,8 +505,9 @@ def _process_pulling_with_shim(
     if shim_client.is_api_v2_supported():  # raises error if shim is down, causes retry
         task = shim_client.get_task(job_model.id)
 
-        # If task goes to terminated before the job is submitted to runner, then an error occured
-        if task.status == TaskStatus.TERMINATED:
+        # If task goes to terminated or failed before the job is submitted to runner,
+        # then an error occured
+        if task.status in (TaskStatus.TERMINATED, TaskStatus.FAILED):
             logger.warning(
                 "shim failed to execute job %s: %s (%s)",
                 job_model.job_name,
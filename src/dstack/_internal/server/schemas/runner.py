@@ -107,6 +107,7 @@ class TaskStatus(str, Enum):
     CREATING = "creating"
     RUNNING = "running"
     TERMINATED = "terminated"
+    FAILED = "failed"
 
 
 class TaskInfoResponse(CoreModel):